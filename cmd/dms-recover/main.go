@@ -0,0 +1,181 @@
+// Command dms-recover opens a printed Dead Man's Switch recovery kit offline.
+//
+// Scan the QR codes of the kit into a text file, or type its text parts, and
+// run "dms-recover kit.txt". It needs no server and no network.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/recoverykit"
+)
+
+const usage = `Usage: dms-recover [flags] [kit.txt ...]
+
+Reads the passphrase of the kit from the first line of stdin and the parts
+of the kit from the files, or from the rest of stdin without files. The
+parts can be in any order, whitespace doesn't matter.
+
+With -zk the second line of stdin is the passphrase of the owner, for the
+secrets the owner encrypted in the browser.
+
+Flags:
+`
+
+func main() {
+	stat, err := os.Stdin.Stat()
+	interactive := err == nil && stat.Mode()&os.ModeCharDevice != 0
+
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, interactive))
+}
+
+// run executes a dms-recover command line and returns the exit code. The
+// passphrases are asked for on stderr if stdin is a terminal.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer, interactive bool) int {
+	flags := flag.NewFlagSet("dms-recover", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	output := flags.String("o", "text", "output format: text or json")
+	zeroKnowledge := flags.Bool("zk", false, "also decrypt zero-knowledge secrets, reads the owner's passphrase from the second line of stdin")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "dms-recover: unknown output format %q\n", *output)
+		return 2
+	}
+
+	// The passphrases are read from stdin so they never show up in the process list or shell history
+	reader := bufio.NewReader(stdin)
+	readLine := func(prompt string) (string, error) {
+		if interactive {
+			fmt.Fprint(stderr, prompt)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	passphrase, err := readLine("Passphrase of the kit: ")
+	if err != nil {
+		fmt.Fprintf(stderr, "dms-recover: failed to read the passphrase: %v\n", err)
+		return 1
+	}
+	if passphrase == "" {
+		fmt.Fprintln(stderr, "dms-recover: no passphrase on stdin")
+		return 1
+	}
+
+	var ownerPassphrase string
+	if *zeroKnowledge {
+		if ownerPassphrase, err = readLine("Passphrase of the owner: "); err != nil {
+			fmt.Fprintf(stderr, "dms-recover: failed to read the passphrase: %v\n", err)
+			return 1
+		}
+	}
+
+	text, err := readKit(flags.Args(), reader, interactive, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "dms-recover: %v\n", err)
+		return 1
+	}
+
+	kit, err := recoverykit.Open(text, passphrase)
+	if err != nil {
+		fmt.Fprintf(stderr, "dms-recover: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	if *zeroKnowledge {
+		for i, secret := range kit.Secrets {
+			if !secret.ZeroKnowledge {
+				continue
+			}
+			plaintext, err := crypto.DecryptClientEnvelope(secret.Content, ownerPassphrase)
+			if err != nil {
+				fmt.Fprintf(stderr, "dms-recover: %q can't be decrypted with the passphrase of the owner\n", secret.Name)
+				failed++
+				continue
+			}
+			kit.Secrets[i].Content = string(plaintext)
+			kit.Secrets[i].ZeroKnowledge = false
+		}
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(kit)
+	} else {
+		err = printKit(stdout, kit)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "dms-recover: %v\n", err)
+		return 1
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readKit returns the text of the kit from the files, or from stdin without files
+func readKit(files []string, stdin io.Reader, interactive bool, stderr io.Writer) (string, error) {
+	if len(files) == 0 {
+		if interactive {
+			fmt.Fprintln(stderr, "Paste the parts of the kit and end with Ctrl-D:")
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the kit: %w", err)
+		}
+		return string(data), nil
+	}
+
+	var b strings.Builder
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// printKit writes the secrets of a kit as text
+func printKit(w io.Writer, kit *recoverykit.Kit) error {
+	fmt.Fprintf(w, "Recovery kit for %s <%s> from %s\n", kit.Recipient, kit.RecipientEmail, kit.Owner)
+	fmt.Fprintf(w, "Made on %s, server %s\n", kit.CreatedAt.Format("2 January 2006"), kit.ServerURL)
+
+	for _, secret := range kit.Secrets {
+		fmt.Fprintf(w, "\n== %s (%s) ==\n", secret.Name, secret.Type)
+		fmt.Fprintln(w, strings.TrimRight(secret.Content, "\n"))
+		if secret.ZeroKnowledge {
+			fmt.Fprintln(w, "(Encrypted in the owner's browser, run dms-recover with -zk and the passphrase of the owner to decrypt it.)")
+		}
+	}
+
+	if len(kit.Secrets) == 0 {
+		_, err := fmt.Fprintln(w, "\nThe kit holds no secrets.")
+		return err
+	}
+	return nil
+}
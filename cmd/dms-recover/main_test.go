@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/recoverykit"
+)
+
+func TestRun(t *testing.T) {
+	envelope, err := crypto.EncryptClientEnvelope([]byte("dear diary"), "owner passphrase")
+	if err != nil {
+		t.Fatalf("Failed to encrypt envelope: %v", err)
+	}
+
+	kit := &recoverykit.Kit{
+		Version:        recoverykit.Version,
+		CreatedAt:      time.Now().UTC(),
+		Owner:          "owner@example.com",
+		Recipient:      "Alice",
+		RecipientEmail: "alice@example.com",
+		ServerURL:      "https://dms.example.com",
+		Secrets: []recoverykit.Secret{
+			{Name: "Bank", Type: "Note", Content: "PIN 1234"},
+			{Name: "Diary", Type: "Zero-knowledge", Content: envelope, ZeroKnowledge: true},
+		},
+	}
+	parts, err := recoverykit.Seal(kit, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Failed to seal kit: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "kit.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(parts, "\n")), 0o600); err != nil {
+		t.Fatalf("Failed to write kit: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		wantCode int
+		wantOut  []string
+		wantErr  string
+	}{
+		{
+			name:     "file",
+			args:     []string{path},
+			stdin:    "correct horse battery staple\n",
+			wantCode: 0,
+			wantOut:  []string{"Recovery kit for Alice", "== Bank (Note) ==", "PIN 1234", "run dms-recover with -zk"},
+		},
+		{
+			name:     "stdin",
+			stdin:    "correct horse battery staple\n" + strings.Join(parts, "\n"),
+			wantCode: 0,
+			wantOut:  []string{"PIN 1234"},
+		},
+		{
+			name:     "zero-knowledge",
+			args:     []string{"-zk", path},
+			stdin:    "correct horse battery staple\nowner passphrase\n",
+			wantCode: 0,
+			wantOut:  []string{"PIN 1234", "dear diary"},
+		},
+		{
+			name:     "wrong owner passphrase",
+			args:     []string{"-zk", path},
+			stdin:    "correct horse battery staple\nwrong\n",
+			wantCode: 1,
+			wantOut:  []string{"PIN 1234"},
+			wantErr:  `"Diary" can't be decrypted`,
+		},
+		{
+			name:     "wrong passphrase",
+			args:     []string{path},
+			stdin:    "wrong horse battery staple\n",
+			wantCode: 1,
+			wantErr:  "wrong passphrase",
+		},
+		{
+			name:     "no passphrase",
+			args:     []string{path},
+			wantCode: 1,
+			wantErr:  "no passphrase",
+		},
+		{
+			name:     "unknown output",
+			args:     []string{"-o", "yaml", path},
+			wantCode: 2,
+			wantErr:  "unknown output format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr, false)
+			if code != tt.wantCode {
+				t.Fatalf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr.String())
+			}
+			for _, want := range tt.wantOut {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("Expected %q in the output, got %s", want, stdout.String())
+				}
+			}
+			if tt.wantErr != "" && !strings.Contains(stderr.String(), tt.wantErr) {
+				t.Errorf("Expected %q on stderr, got %s", tt.wantErr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	kit := &recoverykit.Kit{Version: recoverykit.Version, Secrets: []recoverykit.Secret{{Name: "Bank", Type: "Note", Content: "PIN 1234"}}}
+	parts, err := recoverykit.Seal(kit, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Failed to seal kit: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	stdin := "correct horse battery staple\n" + strings.Join(parts, "\n")
+	if code := run([]string{"-o", "json"}, strings.NewReader(stdin), &stdout, &stderr, false); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+
+	var opened recoverykit.Kit
+	if err := json.Unmarshal(stdout.Bytes(), &opened); err != nil {
+		t.Fatalf("Expected JSON output, got %s", stdout.String())
+	}
+	if len(opened.Secrets) != 1 || opened.Secrets[0].Content != "PIN 1234" {
+		t.Errorf("Unexpected kit %+v", opened)
+	}
+}
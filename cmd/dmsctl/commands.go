@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// timeFormat is how times are shown in tables
+const timeFormat = "2006-01-02 15:04"
+
+func (c *cli) checkIn(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	status, err := c.client.CheckIn(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return c.printJSON(status)
+	}
+
+	fmt.Fprintf(c.stdout, "Checked in. Deadline in %s (%s).\n",
+		formatDuration(status.UntilDeadline()), status.Deadline.Local().Format(timeFormat))
+	return nil
+}
+
+func (c *cli) status(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	status, err := c.client.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return c.printJSON(status)
+	}
+
+	pinging := "enabled"
+	if !status.PingingEnabled {
+		pinging = "disabled"
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Last activity:\t%s\n", status.LastActivity.Local().Format(timeFormat))
+	fmt.Fprintf(w, "Next ping:\t%s\n", status.NextPing.Local().Format(timeFormat))
+	fmt.Fprintf(w, "Deadline:\t%s (in %s)\n", status.Deadline.Local().Format(timeFormat), formatDuration(status.UntilDeadline()))
+	fmt.Fprintf(w, "Pinging:\t%s\n", pinging)
+	fmt.Fprintf(w, "Secrets:\t%d\n", status.Secrets)
+	fmt.Fprintf(w, "Recipients:\t%d\n", status.Recipients)
+	return w.Flush()
+}
+
+func (c *cli) listSecrets(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	secrets, err := c.client.ListSecrets(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return c.printJSON(secrets)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tRECIPIENTS\tQUORUM\tUPDATED")
+	for _, secret := range secrets {
+		quorum := "-"
+		if secret.QuorumThreshold > 0 {
+			quorum = fmt.Sprintf("%d of %d", secret.QuorumThreshold, len(secret.RecipientIDs))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+			secret.ID, secret.Name, len(secret.RecipientIDs), quorum, secret.UpdatedAt.Local().Format(timeFormat))
+	}
+	return w.Flush()
+}
+
+func (c *cli) listRecipients(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	recipients, err := c.client.ListRecipients(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return c.printJSON(recipients)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tEMAIL\tCONFIRMED\tRELEASE DELAY")
+	for _, recipient := range recipients {
+		confirmed := "no"
+		if recipient.IsConfirmed {
+			confirmed = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%dd\n", recipient.ID, recipient.Name, recipient.Email, confirmed, recipient.ReleaseDelayDays)
+	}
+	return w.Flush()
+}
+
+func (c *cli) listAssignments(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	assignments, err := c.client.ListAssignments(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return c.printJSON(assignments)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSECRET\tRECIPIENT\tCREATED")
+	for _, assignment := range assignments {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			assignment.ID, assignment.SecretID, assignment.RecipientID, assignment.CreatedAt.Local().Format(timeFormat))
+	}
+	return w.Flush()
+}
+
+func (c *cli) assign(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	assignment, err := c.client.Assign(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return c.printJSON(assignment)
+	}
+
+	fmt.Fprintf(c.stdout, "Assigned secret %s to recipient %s (assignment %s).\n",
+		assignment.SecretID, assignment.RecipientID, assignment.ID)
+	return nil
+}
+
+func (c *cli) unassign(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	if err := c.client.Unassign(ctx, args[0]); err != nil {
+		return err
+	}
+
+	if c.output == "table" {
+		fmt.Fprintf(c.stdout, "Removed assignment %s.\n", args[0])
+	}
+	return nil
+}
+
+func (c *cli) testContact(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	recipient, err := c.client.TestRecipient(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return c.printJSON(recipient)
+	}
+
+	fmt.Fprintf(c.stdout, "Sent a test contact email to %s <%s>.\n", recipient.Name, recipient.Email)
+	return nil
+}
+
+func (c *cli) unlock(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	// The password is read from stdin so it never shows up in the process list or shell history
+	password, err := bufio.NewReader(c.stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return fmt.Errorf("no password on stdin")
+	}
+
+	expiresAt, err := c.client.UnlockVault(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return c.printJSON(map[string]interface{}{"unlocked": true, "expires_at": expiresAt})
+	}
+
+	fmt.Fprintf(c.stdout, "Vault unlocked until %s.\n", expiresAt.Local().Format(timeFormat))
+	return nil
+}
+
+func (c *cli) lock(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	if err := c.client.LockVault(ctx); err != nil {
+		return err
+	}
+
+	if c.output == "table" {
+		fmt.Fprintln(c.stdout, "Vault locked.")
+	}
+	return nil
+}
+
+func (c *cli) audit(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	since := flags.Duration("since", 0, "only show entries from this long ago")
+	limit := flags.Int("n", 20, "number of entries to show")
+	follow := flags.Bool("f", false, "keep showing new entries")
+	interval := flags.Duration("interval", 30*time.Second, "how often to poll with -f")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	var from time.Time
+	if *since > 0 {
+		from = time.Now().Add(-*since)
+	}
+
+	logs, err := c.client.AuditLogs(ctx, from, *limit)
+	if err != nil {
+		return err
+	}
+
+	// The API returns the newest entries first, print them in the order they happened
+	reverseAuditLogs(logs)
+
+	var w *tabwriter.Writer
+	if c.output == "table" {
+		w = tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tACTION\tDETAILS")
+	}
+	if err := c.printAuditLogs(w, logs); err != nil {
+		return err
+	}
+
+	if !*follow {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	last := time.Now()
+	for _, entry := range logs {
+		seen[entry.ID] = true
+		if entry.Timestamp.After(last) {
+			last = entry.Timestamp
+		}
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Overlap the polls a little, entries seen before are skipped
+		logs, err := c.client.AuditLogs(ctx, last.Add(-time.Minute), 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		reverseAuditLogs(logs)
+
+		var fresh []models.AuditLog
+		for _, entry := range logs {
+			if seen[entry.ID] {
+				continue
+			}
+			seen[entry.ID] = true
+			fresh = append(fresh, entry)
+			if entry.Timestamp.After(last) {
+				last = entry.Timestamp
+			}
+		}
+
+		if err := c.printAuditLogs(w, fresh); err != nil {
+			return err
+		}
+	}
+}
+
+// printAuditLogs prints audit log entries as table rows or as one JSON object per line
+func (c *cli) printAuditLogs(w *tabwriter.Writer, logs []models.AuditLog) error {
+	if w == nil {
+		for _, entry := range logs {
+			if err := c.printJSONLine(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, entry := range logs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Timestamp.Local().Format(timeFormat), entry.Action, entry.Details)
+	}
+	return w.Flush()
+}
+
+// printJSONLine writes v as a single line of JSON, so a followed log can be piped into jq
+func (c *cli) printJSONLine(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.stdout, string(data))
+	return err
+}
+
+// reverseAuditLogs reverses the order of the entries in place
+func reverseAuditLogs(logs []models.AuditLog) {
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+}
+
+// formatDuration formats a duration in days, hours and minutes
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return "0m"
+	}
+
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
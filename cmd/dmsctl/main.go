@@ -0,0 +1,165 @@
+// Command dmsctl is a command-line client for the Dead Man's Switch API.
+//
+// Put "dmsctl checkin" in a shell profile or a systemd timer to reset the
+// switch whenever you use one of your machines.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/client"
+)
+
+const usage = `Usage: dmsctl [flags] <command> [arguments]
+
+Commands:
+  checkin                              Check in and reset the switch
+  status                               Show the time until the deadline and the next ping
+  secrets                              List secrets
+  recipients                           List recipients
+  assignments                          List which secrets are assigned to which recipients
+  assign <secret-id> <recipient-id>    Assign a secret to a recipient
+  unassign <assignment-id>             Remove an assignment
+  test-contact <recipient-id>          Send a test contact email to a recipient
+  unlock                               Unlock the vault, reads the password from stdin
+  lock                                 Lock the vault again
+  audit [-since 24h] [-n 20] [-f]      Show the audit log, -f keeps following it
+
+Flags:
+`
+
+// cli holds the global options of a dmsctl invocation
+type cli struct {
+	client *client.Client
+	output string
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes a dmsctl command line and returns the exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dmsctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	server := flags.String("server", envOr("DMS_SERVER", "http://localhost:8080"), "server URL, or set DMS_SERVER")
+	token := flags.String("token", os.Getenv("DMS_TOKEN"), "personal API token, or set DMS_TOKEN")
+	output := flags.String("o", "table", "output format: table or json")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of each request")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "dmsctl: unknown output format %q\n", *output)
+		return 2
+	}
+	if *token == "" {
+		fmt.Fprintln(stderr, "dmsctl: no API token, use -token or set DMS_TOKEN")
+		return 2
+	}
+
+	c := &cli{
+		client: client.New(*server, *token, nil),
+		output: *output,
+		stdin:  stdin,
+		stdout: stdout,
+	}
+
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+
+	// Following the audit log runs until interrupted, everything else is a single request
+	if command != "audit" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	err := c.dispatch(ctx, command, commandArgs)
+	if errors.Is(err, errUsage) {
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		if client.IsVaultLocked(err) {
+			fmt.Fprintln(stderr, "dmsctl: the vault is locked, run \"dmsctl unlock\" first")
+			return 1
+		}
+		fmt.Fprintf(stderr, "dmsctl: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// errUsage is returned for an unknown command or wrong arguments
+var errUsage = errors.New("usage")
+
+// dispatch runs a command
+func (c *cli) dispatch(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "checkin", "check-in":
+		return c.checkIn(ctx, args)
+	case "status":
+		return c.status(ctx, args)
+	case "secrets":
+		return c.listSecrets(ctx, args)
+	case "recipients":
+		return c.listRecipients(ctx, args)
+	case "assignments":
+		return c.listAssignments(ctx, args)
+	case "assign":
+		return c.assign(ctx, args)
+	case "unassign":
+		return c.unassign(ctx, args)
+	case "test-contact":
+		return c.testContact(ctx, args)
+	case "unlock":
+		return c.unlock(ctx, args)
+	case "lock":
+		return c.lock(ctx, args)
+	case "audit":
+		return c.audit(ctx, args)
+	default:
+		return errUsage
+	}
+}
+
+// printJSON writes v as indented JSON
+func (c *cli) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// envOr returns the environment variable or a default
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeServer answers the API endpoints dmsctl calls with canned responses
+func newFakeServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	var requests []string
+	mux := http.NewServeMux()
+
+	status := map[string]interface{}{
+		"pinging_enabled":        true,
+		"last_activity":          time.Now().UTC(),
+		"next_ping":              time.Now().UTC().Add(7 * 24 * time.Hour),
+		"deadline":               time.Now().UTC().Add(14 * 24 * time.Hour),
+		"seconds_until_deadline": 14 * 24 * 60 * 60,
+		"secrets":                2,
+		"recipients":             1,
+	}
+	writeJSON := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+
+		if r.Header.Get("Authorization") != "Bearer dms_test" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid API token"})
+			return
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v1/status", "POST /api/v1/check-in":
+			writeJSON(w, http.StatusOK, status)
+		case "GET /api/v1/recipients":
+			writeJSON(w, http.StatusOK, []map[string]interface{}{
+				{"id": "r1", "name": "Alice", "email": "alice@example.com", "is_confirmed": true},
+			})
+		case "POST /api/v1/assignments":
+			writeJSON(w, http.StatusLocked, map[string]string{"error": "vault is locked"})
+		case "POST /api/v1/vault/unlock":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["password"] != "hunter2" {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "invalid password"})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"unlocked": true, "expires_at": time.Now().Add(15 * time.Minute)})
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func runDmsctl(server *httptest.Server, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-server", server.URL, "-token", "dms_test"}, args...)
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	server, requests := newFakeServer(t)
+
+	tests := []struct {
+		name       string
+		stdin      string
+		args       []string
+		wantCode   int
+		wantOutput string
+		wantErr    string
+		wantCall   string
+	}{
+		{"check in", "", []string{"checkin"}, 0, "Deadline in 14d 0h", "", "POST /api/v1/check-in"},
+		{"status table", "", []string{"status"}, 0, "Secrets:        2", "", "GET /api/v1/status"},
+		{"status json", "", []string{"-o", "json", "status"}, 0, `"seconds_until_deadline": 1209600`, "", "GET /api/v1/status"},
+		{"recipients", "", []string{"recipients"}, 0, "alice@example.com", "", "GET /api/v1/recipients"},
+		{"locked vault", "", []string{"assign", "s1", "r1"}, 1, "", "dmsctl unlock", "POST /api/v1/assignments"},
+		{"unlock", "hunter2\n", []string{"unlock"}, 0, "Vault unlocked", "", "POST /api/v1/vault/unlock"},
+		{"wrong password", "nope\n", []string{"unlock"}, 1, "", "invalid password", "POST /api/v1/vault/unlock"},
+		{"unknown command", "", []string{"explode"}, 2, "", "Usage:", ""},
+		{"missing argument", "", []string{"assign", "s1"}, 2, "", "Usage:", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*requests = nil
+
+			code, stdout, stderr := runDmsctl(server, tt.stdin, tt.args...)
+			if code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d (stderr: %s)", tt.wantCode, code, stderr)
+			}
+			if !strings.Contains(stdout, tt.wantOutput) {
+				t.Errorf("Expected output to contain %q, got %q", tt.wantOutput, stdout)
+			}
+			if !strings.Contains(stderr, tt.wantErr) {
+				t.Errorf("Expected errors to contain %q, got %q", tt.wantErr, stderr)
+			}
+
+			if tt.wantCall == "" {
+				if len(*requests) != 0 {
+					t.Errorf("Expected no requests, got %v", *requests)
+				}
+			} else if len(*requests) != 1 || (*requests)[0] != tt.wantCall {
+				t.Errorf("Expected request %q, got %v", tt.wantCall, *requests)
+			}
+		})
+	}
+}
+
+func TestRunWithoutToken(t *testing.T) {
+	t.Setenv("DMS_TOKEN", "")
+
+	var stderr bytes.Buffer
+	code := run(context.Background(), []string{"status"}, strings.NewReader(""), io.Discard, &stderr)
+	if code != 2 || !strings.Contains(stderr.String(), "DMS_TOKEN") {
+		t.Errorf("Expected exit code 2 and a hint about DMS_TOKEN, got %d: %s", code, stderr.String())
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{-time.Hour, "0m"},
+		{45 * time.Minute, "45m"},
+		{5*time.Hour + 30*time.Minute, "5h 30m"},
+		{3*24*time.Hour + 2*time.Hour, "3d 2h"},
+	}
+
+	for _, tt := range tests {
+		if got := formatDuration(tt.in); got != tt.want {
+			t.Errorf("formatDuration(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
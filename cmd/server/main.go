@@ -51,6 +51,19 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Maintenance commands run instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rotate-keys":
+			if err := rotateKeys(ctx, cfg, repo); err != nil {
+				log.Fatalf("Key rotation failed: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown command %q, available commands: rotate-keys", os.Args[1])
+		}
+		return
+	}
+
 	// Initialize email client if SMTP is configured
 	var emailClient *email.Client
 	if cfg.SMTPHost != "" {
@@ -63,6 +76,10 @@ func main() {
 		log.Printf("Warning: SMTP not configured, email notifications will be disabled")
 	}
 
+	if len(cfg.MasterKey) == 0 {
+		log.Printf("Warning: MASTER_KEY not configured, quorum protected secrets will be disabled")
+	}
+
 	// Initialize Telegram bot
 	log.Printf("Initializing Telegram bot")
 	telegramBot, err := telegram.NewBot(cfg, repo)
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/korjavin/deadmanswitch/internal/config"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+// rotateKeysProgressEvery is how many secrets are rotated between progress messages
+const rotateKeysProgressEvery = 100
+
+// rotateKeys moves all sealed delivery material to MASTER_KEY. Material sealed
+// with a key from MASTER_KEY_PREVIOUS gets its DEK wrapped with the new key.
+// The command can run while the server is up, and can be run again if it was
+// interrupted or some secrets failed.
+func rotateKeys(ctx context.Context, cfg *config.Config, repo storage.Repository) error {
+	if len(cfg.MasterKey) == 0 {
+		return fmt.Errorf("MASTER_KEY is not configured")
+	}
+
+	sealer := delivery.NewSealer(repo, cfg.MasterKey, cfg.Timelock)
+	sealer.SetPreviousMasterKeys(cfg.PreviousMasterKeys)
+
+	log.Printf("Rotating sealed delivery material to the current master key, %d previous keys configured", len(cfg.PreviousMasterKeys))
+
+	report, err := sealer.RotateKeys(ctx, func(report *delivery.KeyRotationReport) {
+		if report.Secrets%rotateKeysProgressEvery == 0 || report.Secrets == report.TotalSecrets {
+			log.Printf("Rotated %d of %d secrets", report.Secrets, report.TotalSecrets)
+		}
+	})
+	if report != nil {
+		log.Printf("Users: %d, secrets: %d, rewrapped: %d, re-encrypted: %d, already current: %d, verified: %d",
+			report.Users, report.TotalSecrets, report.Rewrapped, report.Reencrypted, report.Current, report.Verified)
+		for _, secretID := range report.Failed {
+			log.Printf("Failed: secret %s", secretID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("All sealed material uses the current master key, MASTER_KEY_PREVIOUS can be removed")
+	return nil
+}
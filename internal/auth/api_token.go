@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// APITokenPrefix marks personal API tokens so they are easy to recognise in scripts and secret scanners
+const APITokenPrefix = "dms_"
+
+// GenerateAPIToken generates a new personal API token and returns it together with its hash.
+// Only the hash is stored, the token itself is shown to the user once.
+func GenerateAPIToken() (string, string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(tokenBytes)
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the hash an API token is stored and looked up by.
+// Tokens carry 256 bits of randomness, so a plain SHA-256 is enough here.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIToken reports whether a bearer credential looks like a personal API token
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestGenerateAPIToken(t *testing.T) {
+	token, hash, err := GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("GenerateAPIToken failed: %v", err)
+	}
+
+	if !IsAPIToken(token) {
+		t.Errorf("Expected token to start with %q, got %q", APITokenPrefix, token)
+	}
+	if hash != HashAPIToken(token) {
+		t.Error("Expected returned hash to match HashAPIToken")
+	}
+	if hash == token {
+		t.Error("Expected hash to differ from the token")
+	}
+
+	other, otherHash, err := GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("GenerateAPIToken failed: %v", err)
+	}
+	if other == token || otherHash == hash {
+		t.Error("Expected two generated tokens to differ")
+	}
+}
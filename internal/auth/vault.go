@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+// legacyMasterKey is the hardcoded key secrets were encrypted with before
+// per-user vault keys existed. It is only used to migrate those secrets.
+var legacyMasterKey = []byte("this-is-a-dummy-master-key-for-demo-only")
+
+// ErrVaultLocked is returned when the vault key for a session is not in memory
+var ErrVaultLocked = errors.New("vault is locked")
+
+// ErrTooManyUnlockAttempts is returned while unlocking is blocked after too many wrong passwords
+var ErrTooManyUnlockAttempts = errors.New("too many failed unlock attempts")
+
+const (
+	// MaxUnlockAttempts is how many wrong passwords in a row block unlocking the vault
+	MaxUnlockAttempts = 5
+	// UnlockBlockDuration is how long unlocking stays blocked after that
+	UnlockBlockDuration = 15 * time.Minute
+)
+
+// vaultKeyEntry is an unlocked vault key held for a session
+type vaultKeyEntry struct {
+	key       []byte
+	expiresAt time.Time
+}
+
+// unlockFailures counts the wrong passwords a user entered to unlock the vault
+type unlockFailures struct {
+	count        int
+	blockedUntil time.Time
+}
+
+// VaultService manages per-user vault keys.
+//
+// Each user has a random vault key that encrypts their secrets. The vault key is
+// stored in the users table wrapped with a key-encryption key derived from the
+// login password, so it can only be unwrapped while the password is at hand.
+// Unwrapped keys are kept in memory per session and never written to disk.
+type VaultService struct {
+	repo     storage.Repository
+	keys     map[string]*vaultKeyEntry  // In-memory key store, keyed by session ID
+	failures map[string]*unlockFailures // Wrong unlock passwords, keyed by user ID
+	mutex    sync.Mutex                 // Mutex to protect the keys and failures maps
+}
+
+// NewVaultService creates a new VaultService
+func NewVaultService(repo storage.Repository) *VaultService {
+	return &VaultService{
+		repo:     repo,
+		keys:     make(map[string]*vaultKeyEntry),
+		failures: make(map[string]*unlockFailures),
+	}
+}
+
+// Unlock unwraps the user's vault key with the password and keeps it in memory
+// for the session. A vault key is created on first use, and any secrets still
+// encrypted with the legacy key are migrated to it.
+func (s *VaultService) Unlock(ctx context.Context, user *models.User, password string, session *models.Session) error {
+	var vaultKey []byte
+	var err error
+
+	if user.EncryptedVaultKey == "" {
+		vaultKey, err = s.createVaultKey(ctx, user, password)
+	} else {
+		vaultKey, err = s.unwrapVaultKey(user, password)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Re-encrypt any secrets left over from before vault keys existed
+	if err := s.migrateLegacySecrets(ctx, user.ID, vaultKey); err != nil {
+		log.Printf("Error migrating legacy secrets for user %s: %v", user.ID, err)
+		// Continue anyway, the migration is retried on the next unlock
+	}
+
+	s.mutex.Lock()
+	s.keys[session.ID] = &vaultKeyEntry{
+		key:       vaultKey,
+		expiresAt: session.ExpiresAt,
+	}
+	delete(s.failures, user.ID)
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// CheckUnlockAttempts returns ErrTooManyUnlockAttempts while unlocking the
+// vault of a user is blocked after too many wrong passwords
+func (s *VaultService) CheckUnlockAttempts(userID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	failures, ok := s.failures[userID]
+	if ok && time.Now().Before(failures.blockedUntil) {
+		return ErrTooManyUnlockAttempts
+	}
+	return nil
+}
+
+// FailedUnlock records a wrong password for unlocking the vault of a user and
+// returns how many attempts are left before unlocking is blocked. The count
+// starts over once the block ends or the vault is unlocked.
+func (s *VaultService) FailedUnlock(userID string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	failures, ok := s.failures[userID]
+	if !ok || (!failures.blockedUntil.IsZero() && now.After(failures.blockedUntil)) {
+		failures = &unlockFailures{}
+		s.failures[userID] = failures
+	}
+
+	failures.count++
+	if failures.count >= MaxUnlockAttempts {
+		failures.blockedUntil = now.Add(UnlockBlockDuration)
+		return 0
+	}
+	return MaxUnlockAttempts - failures.count
+}
+
+// Key returns the unlocked vault key for a session
+func (s *VaultService) Key(sessionID string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.keys[sessionID]
+	if !ok {
+		return nil, ErrVaultLocked
+	}
+
+	// Drop keys that outlived their session
+	if time.Now().After(entry.expiresAt) {
+		s.deleteLocked(sessionID)
+		return nil, ErrVaultLocked
+	}
+
+	return entry.key, nil
+}
+
+// Lock forgets the vault key for a session
+func (s *VaultService) Lock(sessionID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.deleteLocked(sessionID)
+}
+
+// deleteLocked wipes and removes a key entry; the caller must hold the mutex
+func (s *VaultService) deleteLocked(sessionID string) {
+	entry, ok := s.keys[sessionID]
+	if !ok {
+		return
+	}
+
+	for i := range entry.key {
+		entry.key[i] = 0
+	}
+	delete(s.keys, sessionID)
+}
+
+// createVaultKey generates a new vault key for the user and stores it wrapped with the password
+func (s *VaultService) createVaultKey(ctx context.Context, user *models.User, password string) ([]byte, error) {
+	vaultKey, err := crypto.GenerateDataEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, salt, err := crypto.WrapKey(vaultKey, []byte(password))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap vault key: %w", err)
+	}
+
+	user.VaultKeySalt = base64.StdEncoding.EncodeToString(salt)
+	user.EncryptedVaultKey = base64.StdEncoding.EncodeToString(wrapped)
+
+	if err := s.repo.UpdateUserVaultKey(ctx, user.ID, user.VaultKeySalt, user.EncryptedVaultKey); err != nil {
+		return nil, fmt.Errorf("failed to store vault key: %w", err)
+	}
+
+	log.Printf("Created vault key for user %s", user.ID)
+	return vaultKey, nil
+}
+
+// unwrapVaultKey decrypts the user's stored vault key with the password
+func (s *VaultService) unwrapVaultKey(user *models.User, password string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(user.VaultKeySalt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode vault key salt: %w", err)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(user.EncryptedVaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode vault key: %w", err)
+	}
+
+	vaultKey, err := crypto.UnwrapKey(wrapped, []byte(password), salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap vault key: %w", err)
+	}
+
+	return vaultKey, nil
+}
+
+// UpgradeSecret encrypts a secret again with the vault key if it is stored in
+// an older envelope format, and saves it. It is called wherever the plaintext
+// is at hand anyway, so old secrets are upgraded as they are used.
+func (s *VaultService) UpgradeSecret(ctx context.Context, secret *models.Secret, plaintext []byte, vaultKey []byte) error {
+	if secret.IsClientEncrypted() || !crypto.NeedsUpgrade(secret.EncryptedData) {
+		return nil
+	}
+
+	encryptedData, err := crypto.EncryptSecret(plaintext, vaultKey)
+	if err != nil {
+		return fmt.Errorf("failed to re-encrypt secret %s: %w", secret.ID, err)
+	}
+
+	secret.EncryptedData = encryptedData
+	secret.UpdatedAt = time.Now().UTC()
+	if err := s.repo.UpdateSecret(ctx, secret); err != nil {
+		return fmt.Errorf("failed to update secret %s: %w", secret.ID, err)
+	}
+
+	log.Printf("Upgraded secret %s to envelope version %d", secret.ID, crypto.EnvelopeVersion)
+	return nil
+}
+
+// migrateLegacySecrets re-encrypts secrets that still use the legacy key with the vault key
+func (s *VaultService) migrateLegacySecrets(ctx context.Context, userID string, vaultKey []byte) error {
+	secrets, err := s.repo.ListSecretsByUserID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	migrated := 0
+	for _, secret := range secrets {
+		if secret.EncryptionType != models.EncryptionTypeLegacy {
+			continue
+		}
+
+		plaintext, err := crypto.DecryptSecret(secret.EncryptedData, legacyMasterKey)
+		if err != nil {
+			log.Printf("Error decrypting legacy secret %s: %v", secret.ID, err)
+			continue
+		}
+
+		encryptedData, err := crypto.EncryptSecret(plaintext, vaultKey)
+		if err != nil {
+			return fmt.Errorf("failed to re-encrypt secret %s: %w", secret.ID, err)
+		}
+
+		secret.EncryptedData = encryptedData
+		secret.EncryptionType = models.EncryptionTypeVault
+		if err := s.repo.UpdateSecret(ctx, secret); err != nil {
+			return fmt.Errorf("failed to update secret %s: %w", secret.ID, err)
+		}
+		migrated++
+	}
+
+	if migrated == 0 {
+		return nil
+	}
+
+	log.Printf("Migrated %d legacy secrets for user %s to the vault key", migrated, userID)
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		Action:    "migrate_secrets",
+		Timestamp: time.Now(),
+		Details:   fmt.Sprintf("Re-encrypted %d secrets with the vault key", migrated),
+	}
+	if err := s.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+	}
+
+	return nil
+}
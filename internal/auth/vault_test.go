@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+// TestVaultUnlockCreatesKey tests that the first unlock creates and stores a wrapped vault key
+func TestVaultUnlockCreatesKey(t *testing.T) {
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user1", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+
+	vault := NewVaultService(repo)
+	session := &models.Session{ID: "session1", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+
+	if _, err := vault.Key(session.ID); err != ErrVaultLocked {
+		t.Fatalf("Expected ErrVaultLocked before unlock, got %v", err)
+	}
+
+	if err := vault.Unlock(context.Background(), user, "password", session); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+
+	if user.VaultKeySalt == "" || user.EncryptedVaultKey == "" {
+		t.Fatal("Expected wrapped vault key to be stored on the user")
+	}
+
+	key, err := vault.Key(session.ID)
+	if err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+
+	// Unlocking again in a new session must yield the same key
+	other := &models.Session{ID: "session2", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := vault.Unlock(context.Background(), user, "password", other); err != nil {
+		t.Fatalf("Second unlock failed: %v", err)
+	}
+	otherKey, _ := vault.Key(other.ID)
+	if !bytes.Equal(key, otherKey) {
+		t.Error("Expected the same vault key for both sessions")
+	}
+
+	// A wrong password must not unlock the vault
+	third := &models.Session{ID: "session3", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := vault.Unlock(context.Background(), user, "wrong", third); err == nil {
+		t.Error("Expected unlock with wrong password to fail")
+	}
+
+	// Locking forgets the key
+	vault.Lock(session.ID)
+	if _, err := vault.Key(session.ID); err != ErrVaultLocked {
+		t.Errorf("Expected ErrVaultLocked after lock, got %v", err)
+	}
+}
+
+// TestVaultKeyExpires tests that keys are dropped once their session expires
+func TestVaultKeyExpires(t *testing.T) {
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user1", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+
+	vault := NewVaultService(repo)
+	session := &models.Session{ID: "session1", UserID: user.ID, ExpiresAt: time.Now().Add(-time.Minute)}
+
+	if err := vault.Unlock(context.Background(), user, "password", session); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+
+	if _, err := vault.Key(session.ID); err != ErrVaultLocked {
+		t.Errorf("Expected ErrVaultLocked for expired session, got %v", err)
+	}
+}
+
+// TestVaultUnlockAttempts tests that too many wrong passwords block unlocking for a while
+func TestVaultUnlockAttempts(t *testing.T) {
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user1", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+
+	vault := NewVaultService(repo)
+
+	for i := 1; i < MaxUnlockAttempts; i++ {
+		if remaining := vault.FailedUnlock(user.ID); remaining != MaxUnlockAttempts-i {
+			t.Errorf("Expected %d attempts left, got %d", MaxUnlockAttempts-i, remaining)
+		}
+	}
+	if err := vault.CheckUnlockAttempts(user.ID); err != nil {
+		t.Errorf("Expected unlocking to be allowed before the last attempt, got %v", err)
+	}
+
+	// A successful unlock starts the count over
+	session := &models.Session{ID: "session1", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := vault.Unlock(context.Background(), user, "password", session); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if remaining := vault.FailedUnlock(user.ID); remaining != MaxUnlockAttempts-1 {
+		t.Errorf("Expected the count to start over after an unlock, got %d attempts left", remaining)
+	}
+
+	for i := 1; i < MaxUnlockAttempts; i++ {
+		vault.FailedUnlock(user.ID)
+	}
+	if err := vault.CheckUnlockAttempts(user.ID); err != ErrTooManyUnlockAttempts {
+		t.Errorf("Expected ErrTooManyUnlockAttempts, got %v", err)
+	}
+	if err := vault.CheckUnlockAttempts("user2"); err != nil {
+		t.Errorf("Expected other users to be unaffected, got %v", err)
+	}
+
+	// The block ends after a while
+	vault.failures[user.ID].blockedUntil = time.Now().Add(-time.Second)
+	if err := vault.CheckUnlockAttempts(user.ID); err != nil {
+		t.Errorf("Expected unlocking to be allowed after the block, got %v", err)
+	}
+	if remaining := vault.FailedUnlock(user.ID); remaining != MaxUnlockAttempts-1 {
+		t.Errorf("Expected the count to start over after the block, got %d attempts left", remaining)
+	}
+}
+
+// TestVaultMigratesLegacySecrets tests that legacy secrets are re-encrypted with the vault key
+func TestVaultMigratesLegacySecrets(t *testing.T) {
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user1", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+
+	encryptedData, err := crypto.EncryptSecret([]byte("legacy content"), legacyMasterKey)
+	if err != nil {
+		t.Fatalf("EncryptSecret failed: %v", err)
+	}
+	repo.Secrets = append(repo.Secrets, &models.Secret{
+		ID:             "secret1",
+		UserID:         user.ID,
+		Name:           "Legacy",
+		EncryptedData:  encryptedData,
+		EncryptionType: models.EncryptionTypeLegacy,
+	})
+
+	vault := NewVaultService(repo)
+	session := &models.Session{ID: "session1", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := vault.Unlock(context.Background(), user, "password", session); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+
+	secret := repo.Secrets[0]
+	if secret.EncryptionType != models.EncryptionTypeVault {
+		t.Errorf("Expected encryption type %q, got %q", models.EncryptionTypeVault, secret.EncryptionType)
+	}
+
+	key, _ := vault.Key(session.ID)
+	plaintext, err := crypto.DecryptSecret(secret.EncryptedData, key)
+	if err != nil {
+		t.Fatalf("Failed to decrypt migrated secret: %v", err)
+	}
+	if string(plaintext) != "legacy content" {
+		t.Errorf("Expected 'legacy content', got %q", plaintext)
+	}
+
+	if _, err := crypto.DecryptSecret(secret.EncryptedData, legacyMasterKey); err == nil {
+		t.Error("Migrated secret should no longer decrypt with the legacy key")
+	}
+
+	if len(repo.AuditLogs) != 1 || repo.AuditLogs[0].Action != "migrate_secrets" {
+		t.Errorf("Expected a migrate_secrets audit log entry, got %v", repo.AuditLogs)
+	}
+}
+
+// TestVaultUpgradeSecret tests that secrets without an envelope header are encrypted again
+func TestVaultUpgradeSecret(t *testing.T) {
+	repo := storage.NewMockRepository()
+	vaultKey, _ := crypto.GenerateDataEncryptionKey()
+
+	// Written the way secrets were stored before the envelope format
+	salt, _ := crypto.GenerateSalt()
+	derivedKey, _ := crypto.DeriveKey(vaultKey, salt)
+	dek, _ := crypto.GenerateDataEncryptionKey()
+	encryptedDEK, _ := crypto.Encrypt(dek, derivedKey)
+	encryptedSecret, _ := crypto.Encrypt([]byte("old content"), dek)
+	legacy := append(append(salt, 0, 0, 0, byte(len(encryptedDEK))), encryptedDEK...)
+	legacy = append(legacy, encryptedSecret...)
+
+	secret := &models.Secret{
+		ID:             "secret1",
+		UserID:         "user1",
+		EncryptedData:  base64.StdEncoding.EncodeToString(legacy),
+		EncryptionType: models.EncryptionTypeVault,
+	}
+	repo.Secrets = append(repo.Secrets, secret)
+
+	vault := NewVaultService(repo)
+	plaintext, err := crypto.DecryptSecret(secret.EncryptedData, vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to decrypt legacy secret: %v", err)
+	}
+	if err := vault.UpgradeSecret(context.Background(), secret, plaintext, vaultKey); err != nil {
+		t.Fatalf("UpgradeSecret failed: %v", err)
+	}
+
+	header, err := crypto.ParseEnvelopeHeader(repo.Secrets[0].EncryptedData)
+	if err != nil {
+		t.Fatalf("Expected an envelope after the upgrade: %v", err)
+	}
+	if header.KeyID != crypto.KeyID(vaultKey) {
+		t.Errorf("Expected key ID %s, got %s", crypto.KeyID(vaultKey), header.KeyID)
+	}
+	if decrypted, err := crypto.DecryptSecret(repo.Secrets[0].EncryptedData, vaultKey); err != nil || string(decrypted) != "old content" {
+		t.Errorf("Expected the upgraded secret to decrypt to 'old content', got %q, %v", decrypted, err)
+	}
+
+	// A current envelope is left alone
+	upgraded := repo.Secrets[0].EncryptedData
+	if err := vault.UpgradeSecret(context.Background(), secret, plaintext, vaultKey); err != nil {
+		t.Fatalf("UpgradeSecret failed: %v", err)
+	}
+	if repo.Secrets[0].EncryptedData != upgraded {
+		t.Error("Expected a current envelope not to be encrypted again")
+	}
+}
@@ -0,0 +1,248 @@
+// Package backup exports everything an owner keeps on the server into one
+// portable file and restores it, on the same or another instance. Secrets are
+// decrypted with the owner's vault key and the whole bundle is encrypted
+// again with a passphrase of its own, so the file doesn't depend on the
+// server's keys and can be kept offline.
+package backup
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// FormatName identifies backup files
+	FormatName = "deadmanswitch-backup"
+
+	// Version is the version of the bundle written by Export. Restore reads
+	// this and all earlier versions.
+	Version = 1
+
+	// MinPassphraseLength is the shortest passphrase a backup can be encrypted with
+	MinPassphraseLength = 12
+
+	// KDFArgon2id derives the key of a backup from its passphrase
+	KDFArgon2id = "argon2id"
+
+	// Argon2id parameters of new backups
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024
+	kdfThreads = 4
+	keySize    = 32
+	saltSize   = 16
+
+	// Limits for reading, so an uploaded file can't make the derivation take
+	// more time or memory than for a backup written by Seal
+	maxKDFTime    = kdfTime
+	maxKDFMemory  = kdfMemory
+	maxKDFThreads = kdfThreads
+)
+
+var (
+	// ErrWeakPassphrase is returned for a passphrase shorter than MinPassphraseLength
+	ErrWeakPassphrase = fmt.Errorf("the passphrase must be at least %d characters", MinPassphraseLength)
+
+	// ErrWrongPassphrase is returned when a backup can't be decrypted with the passphrase
+	ErrWrongPassphrase = errors.New("wrong passphrase or damaged backup")
+
+	// ErrInvalidBackup is returned for a file that is not a backup
+	ErrInvalidBackup = errors.New("not a Dead Man's Switch backup")
+
+	// ErrUnsupportedVersion is returned for a backup written by a newer version
+	ErrUnsupportedVersion = errors.New("the backup was written by a newer version, update this server first")
+)
+
+// File is a backup as it is written to disk. Only the format, the version,
+// the time and the key derivation are readable without the passphrase.
+type File struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	KDF       KDF       `json:"kdf"`
+	Data      string    `json:"data"` // The bundle, encrypted with the derived key in the envelope of crypto.EncryptWithKey
+}
+
+// KDF describes how the key of a backup is derived from its passphrase
+type KDF struct {
+	Name    string `json:"name"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+	Salt    []byte `json:"salt"`
+}
+
+// Bundle is the content of a backup
+type Bundle struct {
+	Version     int          `json:"version"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Email       string       `json:"email"` // The owner the backup was made for
+	Settings    Settings     `json:"settings"`
+	Recipients  []Recipient  `json:"recipients"`
+	Secrets     []Secret     `json:"secrets"`
+	Assignments []Assignment `json:"assignments"`
+}
+
+// Settings are the switch settings of the owner
+type Settings struct {
+	PingFrequency       int    `json:"ping_frequency"`
+	PingDeadline        int    `json:"ping_deadline"`
+	PingingEnabled      bool   `json:"pinging_enabled"`
+	PingMethod          string `json:"ping_method"`
+	EmergencyAccessDays int    `json:"emergency_access_days"`
+	GitHubUsername      string `json:"github_username,omitempty"`
+}
+
+// Recipient is a recipient of the owner. The ID is only used to link the
+// recipient to its assignments, a restored recipient gets a new one.
+type Recipient struct {
+	ID               string     `json:"id"`
+	Email            string     `json:"email"`
+	Name             string     `json:"name"`
+	Message          string     `json:"message,omitempty"`
+	PhoneNumber      string     `json:"phone_number,omitempty"`
+	IsConfirmed      bool       `json:"is_confirmed"`
+	ReleaseDelayDays int        `json:"release_delay_days"`
+	PublicKey        string     `json:"public_key,omitempty"`
+	Questions        *Questions `json:"questions,omitempty"`
+}
+
+// Questions are the personal questions of a recipient with the shares of the
+// question key locked with their answers. The answers themselves are never
+// stored, so the recipient can still open their copies with the same answers.
+type Questions struct {
+	Threshold    int      `json:"threshold"`
+	Key          string   `json:"key"` // age recipient the copies are encrypted to
+	Questions    []string `json:"questions"`
+	LockedShares []string `json:"locked_shares"`
+}
+
+// Secret is a secret of the owner with its decrypted content. Secrets
+// encrypted in the owner's browser keep their envelope as content. The ID is
+// only used to link the secret to its assignments.
+type Secret struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	Type            string    `json:"type,omitempty"`
+	EncryptionType  string    `json:"encryption_type"`
+	QuorumThreshold int       `json:"quorum_threshold,omitempty"`
+	Content         string    `json:"content,omitempty"`
+	File            *FileData `json:"file,omitempty"` // The uploaded file of a file secret
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// FileData is the uploaded file of a file secret
+type FileData struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+// Assignment assigns a secret of the bundle to a recipient of the bundle
+type Assignment struct {
+	SecretID    string `json:"secret_id"`
+	RecipientID string `json:"recipient_id"`
+}
+
+// CheckPassphrase returns ErrWeakPassphrase if a passphrase is too short to protect a backup
+func CheckPassphrase(passphrase string) error {
+	if utf8.RuneCountInString(passphrase) < MinPassphraseLength {
+		return ErrWeakPassphrase
+	}
+	return nil
+}
+
+// Seal encrypts a bundle with a passphrase and returns the backup file
+func Seal(bundle *Bundle, passphrase string) (*File, error) {
+	if err := CheckPassphrase(passphrase); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	kdf := KDF{Name: KDFArgon2id, Time: kdfTime, Memory: kdfMemory, Threads: kdfThreads, Salt: salt}
+	key, err := kdf.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode backup: %w", err)
+	}
+
+	encrypted, err := crypto.EncryptWithKey(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt backup: %w", err)
+	}
+
+	return &File{
+		Format:    FormatName,
+		Version:   bundle.Version,
+		CreatedAt: bundle.CreatedAt,
+		KDF:       kdf,
+		Data:      encrypted,
+	}, nil
+}
+
+// Parse reads a backup file
+func Parse(data []byte) (*File, error) {
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil || file.Format != FormatName {
+		return nil, ErrInvalidBackup
+	}
+	return &file, nil
+}
+
+// Open decrypts a backup file with its passphrase
+func Open(file *File, passphrase string) (*Bundle, error) {
+	if file.Format != FormatName {
+		return nil, ErrInvalidBackup
+	}
+	if file.Version < 1 || file.Version > Version {
+		return nil, ErrUnsupportedVersion
+	}
+
+	key, err := file.KDF.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := crypto.DecryptSecret(file.Data, key)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	// The version inside is authenticated, the one outside is not
+	if bundle.Version != file.Version {
+		return nil, fmt.Errorf("%w: version mismatch", ErrInvalidBackup)
+	}
+
+	return &bundle, nil
+}
+
+// deriveKey derives the key of a backup from its passphrase
+func (k *KDF) deriveKey(passphrase string) ([]byte, error) {
+	if k.Name != KDFArgon2id {
+		return nil, fmt.Errorf("%w: unsupported key derivation %q", ErrInvalidBackup, k.Name)
+	}
+	if k.Time < 1 || k.Time > maxKDFTime || k.Memory < 8*uint32(k.Threads) || k.Memory > maxKDFMemory || k.Threads < 1 || k.Threads > maxKDFThreads || len(k.Salt) < saltSize {
+		return nil, fmt.Errorf("%w: invalid key derivation parameters", ErrInvalidBackup)
+	}
+
+	return argon2.IDKey([]byte(passphrase), k.Salt, k.Time, k.Memory, k.Threads, keySize), nil
+}
@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+var testMasterKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSealOpen(t *testing.T) {
+	bundle := &Bundle{
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+		Email:     "owner@example.com",
+		Secrets:   []Secret{{ID: "secret1", Name: "Bank", EncryptionType: models.EncryptionTypeVault, Content: "PIN 1234"}},
+	}
+
+	if _, err := Seal(bundle, "too short"); !errors.Is(err, ErrWeakPassphrase) {
+		t.Errorf("Expected ErrWeakPassphrase, got %v", err)
+	}
+
+	file, err := Seal(bundle, testPassphrase)
+	if err != nil {
+		t.Fatalf("Failed to seal backup: %v", err)
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatalf("Failed to encode backup: %v", err)
+	}
+	if strings.Contains(string(data), "PIN 1234") || strings.Contains(string(data), "owner@example.com") {
+		t.Errorf("Expected the content to be encrypted, got %s", data)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse backup: %v", err)
+	}
+
+	if _, err := Open(parsed, "wrong horse battery staple"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+
+	opened, err := Open(parsed, testPassphrase)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	if opened.Email != bundle.Email || len(opened.Secrets) != 1 || opened.Secrets[0].Content != "PIN 1234" {
+		t.Errorf("Unexpected bundle %+v", opened)
+	}
+
+	if _, err := Parse([]byte(`{"format": "something else"}`)); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("Expected ErrInvalidBackup for another format, got %v", err)
+	}
+
+	newer := *parsed
+	newer.Version = Version + 1
+	if _, err := Open(&newer, testPassphrase); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	// Key derivation parameters more expensive than Seal's are refused before deriving anything
+	expensive := *parsed
+	expensive.KDF.Memory = maxKDFMemory + 1
+	if _, err := Open(&expensive, testPassphrase); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("Expected ErrInvalidBackup for too much memory, got %v", err)
+	}
+	expensive = *parsed
+	expensive.KDF.Time = maxKDFTime + 1
+	if _, err := Open(&expensive, testPassphrase); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("Expected ErrInvalidBackup for too many passes, got %v", err)
+	}
+	expensive = *parsed
+	expensive.KDF.Threads = maxKDFThreads + 1
+	if _, err := Open(&expensive, testPassphrase); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("Expected ErrInvalidBackup for too many threads, got %v", err)
+	}
+}
+
+func TestExportRestore(t *testing.T) {
+	ctx := context.Background()
+
+	// The account the backup is made of
+	repo := storage.NewMockRepository()
+	sealer := delivery.NewSealer(repo, testMasterKey, nil)
+	service := NewService(repo, sealer)
+	vaultKey := []byte("abcdef0123456789abcdef0123456789")
+
+	user := &models.User{ID: "user123", Email: "owner@example.com", PingFrequency: 5, PingDeadline: 10, PingingEnabled: true, PingMethod: "both", EmergencyAccessDays: 3}
+	repo.Users = append(repo.Users, user)
+
+	alice := &models.Recipient{ID: "alice", UserID: user.ID, Email: "alice@example.com", Name: "Alice", IsConfirmed: true}
+	bob := &models.Recipient{ID: "bob", UserID: user.ID, Email: "bob@example.com", Name: "Bob", ReleaseDelayDays: 7}
+	repo.Recipients = append(repo.Recipients, alice, bob)
+
+	createSecret := func(name, content string, threshold int, recipientIDs ...string) {
+		t.Helper()
+		encrypted, err := crypto.EncryptSecret([]byte(content), vaultKey)
+		if err != nil {
+			t.Fatalf("Failed to encrypt secret: %v", err)
+		}
+		secret := &models.Secret{UserID: user.ID, Name: name, EncryptedData: encrypted, EncryptionType: models.EncryptionTypeVault, QuorumThreshold: threshold}
+		if err := repo.CreateSecret(ctx, secret); err != nil {
+			t.Fatalf("Failed to create secret: %v", err)
+		}
+		for _, recipientID := range recipientIDs {
+			if err := repo.CreateSecretAssignment(ctx, &models.SecretAssignment{SecretID: secret.ID, RecipientID: recipientID, UserID: user.ID}); err != nil {
+				t.Fatalf("Failed to create assignment: %v", err)
+			}
+		}
+		if err := sealer.ResealSecret(ctx, secret, vaultKey); err != nil {
+			t.Fatalf("Failed to seal secret: %v", err)
+		}
+	}
+	createSecret("Bank", "PIN 1234", 0, "alice")
+	createSecret("Seed", "abandon ability able", 2, "alice", "bob")
+	repo.Secrets = append(repo.Secrets, &models.Secret{ID: "legacy", UserID: user.ID, Name: "Old note", EncryptionType: models.EncryptionTypeLegacy})
+
+	if err := sealer.SetQuestions(ctx, alice, []string{"Where did we meet?", "First dog?"}, []string{"Paris", "Rex"}, 2, vaultKey); err != nil {
+		t.Fatalf("Failed to set questions: %v", err)
+	}
+
+	bundle, warnings, err := service.Export(ctx, user, vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if len(bundle.Recipients) != 2 || len(bundle.Secrets) != 2 || len(bundle.Assignments) != 3 {
+		t.Fatalf("Expected 2 recipients, 2 secrets and 3 assignments, got %d, %d and %d", len(bundle.Recipients), len(bundle.Secrets), len(bundle.Assignments))
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "Old note") {
+		t.Errorf("Expected a warning for the legacy secret, got %v", warnings)
+	}
+	if bundle.Secrets[0].Content != "PIN 1234" {
+		t.Errorf("Expected the decrypted content, got %q", bundle.Secrets[0].Content)
+	}
+
+	// Restore into a new account on another server with an existing recipient
+	newRepo := storage.NewMockRepository()
+	newSealer := delivery.NewSealer(newRepo, []byte("fedcba9876543210fedcba9876543210"), nil)
+	newVaultKey := []byte("9876543210abcdef9876543210abcdef")
+	newUser := &models.User{ID: "user456", Email: "owner@example.org", PingFrequency: 7, PingDeadline: 14, PingMethod: "email"}
+	newRepo.Users = append(newRepo.Users, newUser)
+	newRepo.Recipients = append(newRepo.Recipients, &models.Recipient{ID: "bob2", UserID: newUser.ID, Email: "BOB@example.com", Name: "Bob"})
+
+	report, err := NewService(newRepo, newSealer).Restore(ctx, newUser, newVaultKey, bundle)
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if !report.SettingsRestored || report.RecipientsCreated != 1 || report.RecipientsMatched != 1 || report.SecretsCreated != 2 || report.Assignments != 3 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if newUser.PingFrequency != 5 || newUser.PingDeadline != 10 || newUser.PingMethod != "both" || newUser.EmergencyAccessDays != 3 {
+		t.Errorf("Expected the settings to be restored, got %+v", newUser)
+	}
+	if len(newRepo.Recipients) != 2 {
+		t.Fatalf("Expected Bob to be reused, got %d recipients", len(newRepo.Recipients))
+	}
+
+	restoredAlice := newRepo.Recipients[1]
+	if restoredAlice.Email != "alice@example.com" || restoredAlice.IsConfirmed || !restoredAlice.HasQuestions() {
+		t.Fatalf("Unexpected recipient %+v", restoredAlice)
+	}
+	if len(report.Reconfirm) != 1 || report.Reconfirm[0] != restoredAlice.ID {
+		t.Errorf("Expected Alice to confirm again, got %v", report.Reconfirm)
+	}
+
+	for _, secret := range newRepo.Secrets {
+		plaintext, err := crypto.DecryptSecret(secret.EncryptedData, newVaultKey)
+		if err != nil {
+			t.Fatalf("Failed to decrypt %s with the new vault key: %v", secret.Name, err)
+		}
+		if secret.Name == "Seed" && (string(plaintext) != "abandon ability able" || secret.QuorumData == "") {
+			t.Errorf("Expected the seed to be restored with its quorum, got %q", plaintext)
+		}
+		if secret.Name != "Bank" {
+			continue
+		}
+
+		// Alice opens her copy with the answers she gave on the old server
+		assignments, _ := newRepo.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+		if len(assignments) != 1 || assignments[0].RecipientID != restoredAlice.ID {
+			t.Fatalf("Expected the bank secret to be assigned to Alice, got %+v", assignments)
+		}
+		identity, err := newSealer.OpenWithAnswers(ctx, restoredAlice, []string{"paris", "rex"})
+		if err != nil {
+			t.Fatalf("Failed to open with answers: %v", err)
+		}
+		plaintext, err = newSealer.OpenFor(restoredAlice, assignments[0].DeliveryData, identity)
+		if err != nil || string(plaintext) != "PIN 1234" {
+			t.Errorf("Expected %q, got %q (%v)", "PIN 1234", plaintext, err)
+		}
+	}
+
+	// Without the master key the quorum can't be kept
+	disabledRepo := storage.NewMockRepository()
+	otherUser := &models.User{ID: "user789"}
+	bundle.Settings.PingMethod = "pigeon"
+	report, err = NewService(disabledRepo, delivery.NewSealer(disabledRepo, nil, nil)).Restore(ctx, otherUser, newVaultKey, bundle)
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if report.SettingsRestored || report.SecretsCreated != 2 || len(report.Warnings) != 2 {
+		t.Errorf("Expected the settings to be skipped and a warning for the quorum, got %+v", report)
+	}
+	for _, secret := range disabledRepo.Secrets {
+		if secret.IsQuorumProtected() {
+			t.Errorf("Expected %s to lose its quorum", secret.Name)
+		}
+	}
+}
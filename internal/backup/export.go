@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/files"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+// Service exports and restores the data of owners
+type Service struct {
+	repo   storage.Repository
+	sealer *delivery.Sealer
+
+	// File secrets are only exported and restored with a file store
+	files       *files.Store
+	maxFileSize int64
+	fileQuota   int64
+}
+
+// NewService creates a new Service
+func NewService(repo storage.Repository, sealer *delivery.Sealer) *Service {
+	return &Service{
+		repo:   repo,
+		sealer: sealer,
+	}
+}
+
+// SetFileStore includes file secrets in backups. Restored files are limited
+// to maxFileSize bytes and the files of an owner to fileQuota bytes together.
+func (s *Service) SetFileStore(store *files.Store, maxFileSize, fileQuota int64) {
+	s.files = store
+	s.maxFileSize = maxFileSize
+	s.fileQuota = fileQuota
+}
+
+// Export collects the settings, recipients, secrets and assignments of a
+// user into a bundle. The content of the secrets is decrypted with the vault
+// key. What can't be exported is left out and explained in the warnings.
+func (s *Service) Export(ctx context.Context, user *models.User, vaultKey []byte) (*Bundle, []string, error) {
+	bundle := &Bundle{
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+		Email:     user.Email,
+		Settings: Settings{
+			PingFrequency:       user.PingFrequency,
+			PingDeadline:        user.PingDeadline,
+			PingingEnabled:      user.PingingEnabled,
+			PingMethod:          user.PingMethod,
+			EmergencyAccessDays: user.EmergencyAccessDays,
+			GitHubUsername:      user.GitHubUsername,
+		},
+		Recipients:  []Recipient{},
+		Secrets:     []Secret{},
+		Assignments: []Assignment{},
+	}
+	var warnings []string
+
+	recipients, err := s.repo.ListRecipientsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list recipients: %w", err)
+	}
+
+	exported := make(map[string]bool)
+	for _, recipient := range recipients {
+		entry := Recipient{
+			ID:               recipient.ID,
+			Email:            recipient.Email,
+			Name:             recipient.Name,
+			Message:          recipient.Message,
+			PhoneNumber:      recipient.PhoneNumber,
+			IsConfirmed:      recipient.IsConfirmed,
+			ReleaseDelayDays: recipient.ReleaseDelayDays,
+			PublicKey:        recipient.PublicKey,
+		}
+
+		if recipient.HasQuestions() {
+			questions, err := s.sealer.LockedQuestions(ctx, recipient, vaultKey)
+			switch {
+			case errors.Is(err, delivery.ErrQuestionsLocked):
+				warnings = append(warnings, fmt.Sprintf("The questions of %s are only kept behind the timelock and were left out, set them again after restoring", recipient.Email))
+			case err != nil:
+				return nil, nil, fmt.Errorf("failed to export the questions of recipient %s: %w", recipient.ID, err)
+			default:
+				entry.Questions = &Questions{Threshold: recipient.QuestionThreshold, Key: recipient.QuestionKey}
+				for _, question := range questions {
+					entry.Questions.Questions = append(entry.Questions.Questions, question.Question)
+					entry.Questions.LockedShares = append(entry.Questions.LockedShares, question.LockedShare)
+				}
+			}
+		}
+
+		bundle.Recipients = append(bundle.Recipients, entry)
+		exported[recipient.ID] = true
+	}
+
+	secrets, err := s.repo.ListSecretsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	for _, secret := range secrets {
+		entry, warning, err := s.exportSecret(secret, vaultKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to export secret %s: %w", secret.ID, err)
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+			continue
+		}
+
+		bundle.Secrets = append(bundle.Secrets, *entry)
+		exported[secret.ID] = true
+	}
+
+	assignments, err := s.repo.ListSecretAssignmentsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list secret assignments: %w", err)
+	}
+
+	for _, assignment := range assignments {
+		if exported[assignment.SecretID] && exported[assignment.RecipientID] {
+			bundle.Assignments = append(bundle.Assignments, Assignment{
+				SecretID:    assignment.SecretID,
+				RecipientID: assignment.RecipientID,
+			})
+		}
+	}
+
+	return bundle, warnings, nil
+}
+
+// exportSecret returns a secret with its decrypted content, or a warning if it can't be exported
+func (s *Service) exportSecret(secret *models.Secret, vaultKey []byte) (*Secret, string, error) {
+	entry := &Secret{
+		ID:              secret.ID,
+		Name:            secret.Name,
+		Type:            secret.Type,
+		EncryptionType:  secret.EncryptionType,
+		QuorumThreshold: secret.QuorumThreshold,
+		CreatedAt:       secret.CreatedAt,
+	}
+
+	switch secret.EncryptionType {
+	case models.EncryptionTypeClient:
+		// The envelope is already encrypted with the owner's own passphrase
+		entry.Content = secret.EncryptedData
+		return entry, "", nil
+	case models.EncryptionTypeVault:
+	default:
+		return nil, fmt.Sprintf("%q is still encrypted with the old demo key and was left out, unlock your vault once to upgrade it", secret.Name), nil
+	}
+
+	content, err := crypto.DecryptSecret(secret.EncryptedData, vaultKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+
+	if !secret.IsFile() {
+		entry.Content = string(content)
+		return entry, "", nil
+	}
+
+	if s.files == nil {
+		return nil, fmt.Sprintf("The file of %q was left out, file secrets are not available on this server", secret.Name), nil
+	}
+
+	file, err := files.Parse(content)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r, err := s.files.Decrypt(secret.FileRef, file)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt file: %w", err)
+	}
+
+	entry.File = &FileData{Name: file.Name, Type: file.Type, Data: data}
+	return entry, "", nil
+}
@@ -0,0 +1,351 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/files"
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// Report describes what a restore did
+type Report struct {
+	SettingsRestored  bool     `json:"settings_restored"`
+	RecipientsCreated int      `json:"recipients_created"`
+	RecipientsMatched int      `json:"recipients_matched"` // Recipients that already existed with the same email
+	SecretsCreated    int      `json:"secrets_created"`
+	Assignments       int      `json:"assignments"`
+	Warnings          []string `json:"warnings,omitempty"`
+
+	// IDs of the created recipients that were confirmed in the backup. They
+	// are restored unconfirmed and have to confirm their contact again.
+	Reconfirm []string `json:"-"`
+}
+
+// Restore adds the content of a bundle to the account of a user. Recipients
+// that already exist with the same email are reused as they are, everything
+// else is created new, so restoring twice duplicates the secrets. Created
+// recipients are unconfirmed, a confirmation in the backup says nothing about
+// whether the contact details still reach them on this server. Secrets
+// are encrypted with the user's vault key and sealed for their recipients.
+// What can't be restored is skipped and explained in the warnings of the
+// report. If restoring fails halfway, the report says what was restored.
+func (s *Service) Restore(ctx context.Context, user *models.User, vaultKey []byte, bundle *Bundle) (*Report, error) {
+	report := &Report{}
+
+	if err := bundle.Settings.validate(); err != nil {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("The settings were not restored: %v", err))
+	} else {
+		user.PingFrequency = bundle.Settings.PingFrequency
+		user.PingDeadline = bundle.Settings.PingDeadline
+		user.PingingEnabled = bundle.Settings.PingingEnabled
+		user.PingMethod = bundle.Settings.PingMethod
+		user.EmergencyAccessDays = bundle.Settings.EmergencyAccessDays
+		if bundle.Settings.GitHubUsername != "" {
+			user.GitHubUsername = bundle.Settings.GitHubUsername
+		}
+		if err := s.repo.UpdateUser(ctx, user); err != nil {
+			return report, fmt.Errorf("failed to update settings: %w", err)
+		}
+		report.SettingsRestored = true
+	}
+
+	recipientIDs, err := s.restoreRecipients(ctx, user, vaultKey, bundle.Recipients, report)
+	if err != nil {
+		return report, err
+	}
+
+	// Count the recipients of every secret first, a quorum needs enough of them
+	assigned := make(map[string][]string)
+	for _, assignment := range bundle.Assignments {
+		recipientID, ok := recipientIDs[assignment.RecipientID]
+		if ok && !contains(assigned[assignment.SecretID], recipientID) {
+			assigned[assignment.SecretID] = append(assigned[assignment.SecretID], recipientID)
+		}
+	}
+
+	fileLimit, err := s.fileLimit(ctx, user.ID)
+	if err != nil {
+		return report, fmt.Errorf("failed to check file quota: %w", err)
+	}
+
+	for _, entry := range bundle.Secrets {
+		secret, warning, err := s.restoreSecret(ctx, user, vaultKey, entry, len(assigned[entry.ID]), &fileLimit)
+		if err != nil {
+			return report, fmt.Errorf("failed to restore secret %q: %w", entry.Name, err)
+		}
+		if warning != "" {
+			report.Warnings = append(report.Warnings, warning)
+		}
+		if secret == nil {
+			continue
+		}
+		report.SecretsCreated++
+
+		for _, recipientID := range assigned[entry.ID] {
+			assignment := &models.SecretAssignment{
+				SecretID:    secret.ID,
+				RecipientID: recipientID,
+				UserID:      user.ID,
+			}
+			if err := s.repo.CreateSecretAssignment(ctx, assignment); err != nil {
+				return report, fmt.Errorf("failed to create secret assignment: %w", err)
+			}
+			report.Assignments++
+		}
+
+		// Seal the recipient copies now that the recipients are assigned
+		if s.sealer.Enabled() {
+			if err := s.sealer.ResealSecret(ctx, secret, vaultKey); err != nil {
+				return report, fmt.Errorf("failed to seal secret %q: %w", entry.Name, err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// restoreRecipients creates the recipients of a bundle that don't exist yet
+// and returns the IDs they have now by their IDs in the bundle
+func (s *Service) restoreRecipients(ctx context.Context, user *models.User, vaultKey []byte, entries []Recipient, report *Report) (map[string]string, error) {
+	existing, err := s.repo.ListRecipientsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipients: %w", err)
+	}
+
+	byEmail := make(map[string]*models.Recipient)
+	for _, recipient := range existing {
+		byEmail[strings.ToLower(recipient.Email)] = recipient
+	}
+
+	ids := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Email == "" {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("The recipient %q has no email and was not restored", entry.Name))
+			continue
+		}
+
+		if recipient, ok := byEmail[strings.ToLower(entry.Email)]; ok {
+			ids[entry.ID] = recipient.ID
+			report.RecipientsMatched++
+			continue
+		}
+
+		recipient := &models.Recipient{
+			UserID:           user.ID,
+			Email:            entry.Email,
+			Name:             entry.Name,
+			Message:          entry.Message,
+			PhoneNumber:      entry.PhoneNumber,
+			ReleaseDelayDays: entry.ReleaseDelayDays,
+		}
+		if recipient.ReleaseDelayDays < 0 || recipient.ReleaseDelayDays > models.MaxReleaseDelayDays {
+			recipient.ReleaseDelayDays = 0
+			report.Warnings = append(report.Warnings, fmt.Sprintf("The release delay of %s was out of range and was reset", entry.Email))
+		}
+		if entry.PublicKey != "" {
+			if _, err := crypto.ParsePublicKey(entry.PublicKey); err != nil {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("The public key of %s is not valid and was left out: %v", entry.Email, err))
+			} else {
+				recipient.PublicKey = entry.PublicKey
+			}
+		}
+
+		questions := entry.Questions.recipientQuestions(user.ID)
+		if questions != nil {
+			recipient.QuestionThreshold = entry.Questions.Threshold
+			recipient.QuestionKey = entry.Questions.Key
+		} else if entry.Questions != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("The questions of %s are not valid and were left out, set them again", entry.Email))
+		}
+
+		if err := s.repo.CreateRecipient(ctx, recipient); err != nil {
+			return nil, fmt.Errorf("failed to create recipient: %w", err)
+		}
+		ids[entry.ID] = recipient.ID
+		byEmail[strings.ToLower(recipient.Email)] = recipient
+		report.RecipientsCreated++
+		if entry.IsConfirmed {
+			report.Reconfirm = append(report.Reconfirm, recipient.ID)
+		}
+
+		if questions != nil {
+			for _, question := range questions {
+				question.RecipientID = recipient.ID
+			}
+			if err := s.sealer.RestoreQuestions(ctx, recipient, questions, vaultKey); err != nil {
+				return nil, fmt.Errorf("failed to restore the questions of %s: %w", entry.Email, err)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// restoreSecret creates a secret of a bundle with the given number of
+// recipients. It returns nil and a warning if the secret can't be restored.
+// fileLimit is the space left for files and shrinks with every restored file.
+func (s *Service) restoreSecret(ctx context.Context, user *models.User, vaultKey []byte, entry Secret, recipients int, fileLimit *int64) (*models.Secret, string, error) {
+	secret := &models.Secret{
+		UserID:          user.ID,
+		Name:            entry.Name,
+		Type:            entry.Type,
+		EncryptionType:  entry.EncryptionType,
+		QuorumThreshold: entry.QuorumThreshold,
+	}
+
+	var warning string
+	if secret.IsQuorumProtected() {
+		if !s.sealer.Enabled() {
+			warning = fmt.Sprintf("%q was restored without quorum protection, this server has no master key", entry.Name)
+			secret.QuorumThreshold = 0
+		} else if err := delivery.ValidateQuorum(secret.QuorumThreshold, recipients); err != nil {
+			warning = fmt.Sprintf("%q was restored without quorum protection, not all of its recipients were restored", entry.Name)
+			secret.QuorumThreshold = 0
+		}
+	}
+
+	switch {
+	case entry.EncryptionType == models.EncryptionTypeClient:
+		if _, err := crypto.ParseClientEnvelope(entry.Content); err != nil {
+			return nil, fmt.Sprintf("%q was not restored, its encrypted content is damaged", entry.Name), nil
+		}
+		secret.EncryptedData = entry.Content
+
+	case entry.EncryptionType != models.EncryptionTypeVault:
+		return nil, fmt.Sprintf("%q was not restored, its encryption %q is unknown", entry.Name, entry.EncryptionType), nil
+
+	case entry.File != nil:
+		if s.files == nil {
+			return nil, fmt.Sprintf("The file %q was not restored, file secrets are not available on this server", entry.Name), nil
+		}
+
+		limit := *fileLimit
+		if limit > s.maxFileSize {
+			limit = s.maxFileSize
+		}
+		ref, file, err := s.files.Encrypt(bytes.NewReader(entry.File.Data), entry.File.Name, entry.File.Type, limit)
+		if errors.Is(err, files.ErrTooLarge) {
+			return nil, fmt.Sprintf("The file %q was not restored, it is larger than the file size limit or your remaining file quota", entry.Name), nil
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to store file: %w", err)
+		}
+		*fileLimit -= file.Size
+		secret.FileRef = ref
+		secret.FileSize = file.Size
+
+		content, err := files.Marshal(file)
+		if err != nil {
+			return nil, "", err
+		}
+		if secret.EncryptedData, err = crypto.EncryptSecret(content, vaultKey); err != nil {
+			return nil, "", fmt.Errorf("failed to encrypt secret: %w", err)
+		}
+
+	default:
+		encryptedData, err := crypto.EncryptSecret([]byte(entry.Content), vaultKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encrypt secret: %w", err)
+		}
+		secret.EncryptedData = encryptedData
+	}
+
+	if err := s.repo.CreateSecret(ctx, secret); err != nil {
+		if secret.IsFile() {
+			s.files.Delete(secret.FileRef)
+		}
+		return nil, "", fmt.Errorf("failed to create secret: %w", err)
+	}
+
+	return secret, warning, nil
+}
+
+// fileLimit returns how many bytes of files a user can still store
+func (s *Service) fileLimit(ctx context.Context, userID string) (int64, error) {
+	if s.files == nil {
+		return 0, nil
+	}
+
+	secrets, err := s.repo.ListSecretsByUserID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	versions, err := s.repo.ListSecretVersionsByUserID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Revisions of a renamed file secret share its file, every file counts once
+	used := make(map[string]int64)
+	for _, secret := range secrets {
+		if secret.IsFile() {
+			used[secret.FileRef] = secret.FileSize
+		}
+	}
+	for _, version := range versions {
+		if version.IsFile() {
+			used[version.FileRef] = version.FileSize
+		}
+	}
+
+	remaining := s.fileQuota
+	for _, size := range used {
+		remaining -= size
+	}
+	if remaining < 0 {
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// validate checks settings like the settings page does
+func (s Settings) validate() error {
+	if s.PingFrequency < 1 || s.PingFrequency > 30 {
+		return errors.New("the ping frequency must be between 1 and 30 days")
+	}
+	if s.PingDeadline < 3 || s.PingDeadline > 30 {
+		return errors.New("the ping deadline must be between 3 and 30 days")
+	}
+	if s.PingMethod != "email" && s.PingMethod != "telegram" && s.PingMethod != "both" {
+		return errors.New("the ping method must be email, telegram or both")
+	}
+	if s.EmergencyAccessDays < 0 || (s.EmergencyAccessDays > 0 && s.EmergencyAccessDays >= s.PingDeadline) {
+		return errors.New("the emergency access delay must be 0 or shorter than the ping deadline")
+	}
+	return nil
+}
+
+// recipientQuestions returns the questions to store for a recipient, or nil
+// if there are none or they are not complete
+func (q *Questions) recipientQuestions(userID string) []*models.RecipientQuestion {
+	if q == nil || q.Key == "" || len(q.Questions) < 2 || len(q.Questions) > models.MaxRecipientQuestions ||
+		len(q.Questions) != len(q.LockedShares) || q.Threshold < 2 || q.Threshold > len(q.Questions) {
+		return nil
+	}
+
+	questions := make([]*models.RecipientQuestion, len(q.Questions))
+	for i := range q.Questions {
+		questions[i] = &models.RecipientQuestion{
+			UserID:      userID,
+			Position:    i + 1,
+			Question:    q.Questions[i],
+			LockedShare: q.LockedShares[i],
+		}
+	}
+	return questions
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+// Package client is a Go client for the server's versioned JSON API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// apiPrefix is the path prefix of the versioned API
+const apiPrefix = "/api/v1"
+
+// Status is the state of the switch
+type Status struct {
+	PingingEnabled       bool      `json:"pinging_enabled"`
+	LastActivity         time.Time `json:"last_activity"`
+	NextPing             time.Time `json:"next_ping"`
+	Deadline             time.Time `json:"deadline"`
+	SecondsUntilDeadline int64     `json:"seconds_until_deadline"`
+	Secrets              int       `json:"secrets"`
+	Recipients           int       `json:"recipients"`
+}
+
+// UntilDeadline returns the time left until the switch triggers
+func (s *Status) UntilDeadline() time.Duration {
+	return time.Duration(s.SecondsUntilDeadline) * time.Second
+}
+
+// Secret is a secret without its content
+type Secret struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	EncryptionType  string    `json:"encryption_type"`
+	QuorumThreshold int       `json:"quorum_threshold"`
+	RecipientIDs    []string  `json:"recipient_ids"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Recipient is a person secrets are delivered to
+type Recipient struct {
+	ID                 string     `json:"id"`
+	Name               string     `json:"name"`
+	Email              string     `json:"email"`
+	Message            string     `json:"message"`
+	PhoneNumber        string     `json:"phone_number,omitempty"`
+	IsConfirmed        bool       `json:"is_confirmed"`
+	ConfirmedAt        *time.Time `json:"confirmed_at,omitempty"`
+	ConfirmationSentAt *time.Time `json:"confirmation_sent_at,omitempty"`
+	ReleaseDelayDays   int        `json:"release_delay_days"`
+	PublicKey          string     `json:"public_key,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// IsVaultLocked reports whether the request failed because the vault is locked
+func IsVaultLocked(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusLocked
+}
+
+// Client calls the API with a personal API token
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// New creates a new Client for the server at baseURL
+func New(baseURL, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// Status returns the state of the switch
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.do(ctx, "GET", "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// CheckIn checks in and returns the new state of the switch
+func (c *Client) CheckIn(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.do(ctx, "POST", "/check-in", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// UnlockVault unlocks the vault for the token
+func (c *Client) UnlockVault(ctx context.Context, password string) (time.Time, error) {
+	var result struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := c.do(ctx, "POST", "/vault/unlock", map[string]string{"password": password}, &result); err != nil {
+		return time.Time{}, err
+	}
+	return result.ExpiresAt, nil
+}
+
+// LockVault locks the vault for the token
+func (c *Client) LockVault(ctx context.Context) error {
+	return c.do(ctx, "POST", "/vault/lock", nil, nil)
+}
+
+// ListSecrets lists the secrets
+func (c *Client) ListSecrets(ctx context.Context) ([]Secret, error) {
+	var secrets []Secret
+	if err := c.do(ctx, "GET", "/secrets", nil, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// ListRecipients lists the recipients
+func (c *Client) ListRecipients(ctx context.Context) ([]Recipient, error) {
+	var recipients []Recipient
+	if err := c.do(ctx, "GET", "/recipients", nil, &recipients); err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
+
+// TestRecipient sends a test contact email to a recipient
+func (c *Client) TestRecipient(ctx context.Context, recipientID string) (*Recipient, error) {
+	var recipient Recipient
+	if err := c.do(ctx, "POST", "/recipients/"+url.PathEscape(recipientID)+"/test", nil, &recipient); err != nil {
+		return nil, err
+	}
+	return &recipient, nil
+}
+
+// ListAssignments lists which secrets are assigned to which recipients
+func (c *Client) ListAssignments(ctx context.Context) ([]models.SecretAssignment, error) {
+	var assignments []models.SecretAssignment
+	if err := c.do(ctx, "GET", "/assignments", nil, &assignments); err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// Assign assigns a secret to a recipient
+func (c *Client) Assign(ctx context.Context, secretID, recipientID string) (*models.SecretAssignment, error) {
+	body := map[string]string{"secret_id": secretID, "recipient_id": recipientID}
+
+	var assignment models.SecretAssignment
+	if err := c.do(ctx, "POST", "/assignments", body, &assignment); err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+// Unassign removes an assignment
+func (c *Client) Unassign(ctx context.Context, assignmentID string) error {
+	return c.do(ctx, "DELETE", "/assignments/"+url.PathEscape(assignmentID), nil, nil)
+}
+
+// AuditLogs lists audit log entries after since, newest first. A zero since or limit is ignored.
+func (c *Client) AuditLogs(ctx context.Context, since time.Time, limit int) ([]models.AuditLog, error) {
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set("since", since.UTC().Format(time.RFC3339Nano))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	path := "/audit-logs"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var logs []models.AuditLog
+	if err := c.do(ctx, "GET", path, nil, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// do sends a request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+apiPrefix+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+
+		var errBody struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errBody); err == nil && errBody.Error != "" {
+			apiErr.Message = errBody.Error
+		}
+		return apiErr
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
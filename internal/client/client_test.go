@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/handlers"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+)
+
+// newTestServer serves the real API handlers on top of a mock repository
+func newTestServer(t *testing.T, scopes []string) (*storage.MockRepository, *Client) {
+	t.Helper()
+
+	repo := storage.NewMockRepository()
+	repo.Users = append(repo.Users, &models.User{
+		ID:            "user123",
+		Email:         "test@example.com",
+		LastActivity:  time.Now().UTC().Add(-48 * time.Hour),
+		PingFrequency: 7,
+		PingDeadline:  14,
+	})
+	repo.Secrets = append(repo.Secrets, &models.Secret{ID: "secret1", UserID: "user123", Name: "Bank"})
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient1", UserID: "user123", Name: "Alice", Email: "alice@example.com"})
+
+	token, tokenHash, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	repo.APITokens = append(repo.APITokens, &models.APIToken{ID: "token1", UserID: "user123", Name: "cli", TokenHash: tokenHash, Scopes: scopes})
+
+	api := handlers.NewAPIV1Handler(repo, nil, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil), "")
+	mux := http.NewServeMux()
+	for _, route := range api.Routes() {
+		mux.HandleFunc(route.Method+" "+handlers.APIV1Prefix+route.Path, middleware.APIAuth(repo, delivery.NewSealer(repo, nil, nil), route.Scope)(route.HandlerFunc))
+	}
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return repo, New(server.URL+"/", token, server.Client())
+}
+
+func TestClient(t *testing.T) {
+	repo, c := newTestServer(t, models.APITokenScopes)
+	ctx := context.Background()
+
+	status, err := c.CheckIn(ctx)
+	if err != nil {
+		t.Fatalf("CheckIn failed: %v", err)
+	}
+	if until := status.UntilDeadline(); until < 13*24*time.Hour || until > 14*24*time.Hour {
+		t.Errorf("Expected about 14 days until the deadline after checking in, got %v", until)
+	}
+	if status.Secrets != 1 || status.Recipients != 1 {
+		t.Errorf("Expected 1 secret and 1 recipient, got %d and %d", status.Secrets, status.Recipients)
+	}
+
+	secrets, err := c.ListSecrets(ctx)
+	if err != nil || len(secrets) != 1 || secrets[0].Name != "Bank" {
+		t.Fatalf("Unexpected secrets %v: %v", secrets, err)
+	}
+
+	recipients, err := c.ListRecipients(ctx)
+	if err != nil || len(recipients) != 1 || recipients[0].Email != "alice@example.com" {
+		t.Fatalf("Unexpected recipients %v: %v", recipients, err)
+	}
+
+	assignment, err := c.Assign(ctx, "secret1", "recipient1")
+	if err != nil {
+		t.Fatalf("Assign failed: %v", err)
+	}
+
+	assignments, err := c.ListAssignments(ctx)
+	if err != nil || len(assignments) != 1 || assignments[0].ID != assignment.ID {
+		t.Fatalf("Unexpected assignments %v: %v", assignments, err)
+	}
+
+	if err := c.Unassign(ctx, assignment.ID); err != nil {
+		t.Fatalf("Unassign failed: %v", err)
+	}
+	if len(repo.SecretAssignments) != 0 {
+		t.Errorf("Expected the assignment to be removed, got %d", len(repo.SecretAssignments))
+	}
+
+	logs, err := c.AuditLogs(ctx, time.Now().Add(-time.Hour), 2)
+	if err != nil {
+		t.Fatalf("AuditLogs failed: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Errorf("Expected 2 audit log entries, got %d", len(logs))
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	_, c := newTestServer(t, []string{models.APITokenScopeCheckIn})
+	ctx := context.Background()
+
+	// The check-in token can't read secrets
+	_, err := c.ListSecrets(ctx)
+	apiErr, ok := err.(*Error)
+	if !ok || apiErr.StatusCode != http.StatusForbidden || apiErr.Message == "" {
+		t.Errorf("Expected a 403 API error with a message, got %v", err)
+	}
+
+	if _, err := c.CheckIn(ctx); err != nil {
+		t.Errorf("Expected the check-in token to check in, got %v", err)
+	}
+
+	if err := (&Error{StatusCode: http.StatusLocked}); !IsVaultLocked(err) {
+		t.Error("Expected a 423 error to report a locked vault")
+	}
+}
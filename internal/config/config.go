@@ -1,10 +1,23 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+)
+
+const (
+	// DefaultDrandURL is the drand relay used when DRAND_URL is not set
+	DefaultDrandURL = "https://api.drand.sh"
+
+	// DefaultDrandChainHash is the hash of the League of Entropy quicknet chain
+	DefaultDrandChainHash = "52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971"
 )
 
 // Config holds the application configuration
@@ -28,6 +41,10 @@ type Config struct {
 	// Admin email for notifications
 	AdminEmail string
 
+	// Token the admin API endpoints require in the X-Admin-Token header,
+	// empty disables them
+	AdminToken string
+
 	// Email templates path
 	EmailTemplatesPath string
 
@@ -39,9 +56,42 @@ type Config struct {
 	AccessCodeExpirationDays int
 	AccessCodeMaxAttempts    int
 
+	// Grace period between the deadline passing and secrets being released
+	TriggerGracePeriod time.Duration
+
+	// Delivery retry settings
+	DeliveryRetryBaseDelay time.Duration
+	DeliveryRetryMaxDelay  time.Duration
+	DeliveryRetryHorizon   time.Duration
+
 	// Database settings
 	DBPath string
 
+	// File secret settings: where the encrypted files are kept, the largest
+	// file that can be uploaded and the total size of a user's files in bytes
+	FilesDir    string
+	MaxFileSize int64
+	FileQuota   int64
+
+	// Number of earlier revisions kept per secret, 0 keeps none
+	SecretVersions int
+
+	// Server master key used to seal delivery material for recipients
+	MasterKey []byte
+
+	// Master keys used before the last rotation, material sealed with them can
+	// still be opened until rotate-keys has moved it to MasterKey
+	PreviousMasterKeys [][]byte
+
+	// Timelock beacon for personal questions: "drand", "local" (testing only)
+	// or empty to disable it
+	TimelockBeacon string
+	DrandURL       string
+	DrandChainHash string
+
+	// Timelock built from the settings above, nil if disabled
+	Timelock crypto.Timelock
+
 	// Debug mode
 	Debug bool
 
@@ -153,16 +203,170 @@ func LoadFromEnv() (*Config, error) {
 		config.AccessCodeMaxAttempts = attempts
 	}
 
+	// Trigger grace period
+	triggerGraceHoursStr := os.Getenv("TRIGGER_GRACE_HOURS")
+	if triggerGraceHoursStr == "" {
+		config.TriggerGracePeriod = 24 * time.Hour // 24 hours default
+	} else {
+		hours, err := strconv.Atoi(triggerGraceHoursStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRIGGER_GRACE_HOURS: %w", err)
+		}
+		if hours < 0 || hours > 168 {
+			return nil, fmt.Errorf("TRIGGER_GRACE_HOURS must be between 0 and 168 hours")
+		}
+		config.TriggerGracePeriod = time.Duration(hours) * time.Hour
+	}
+
+	// Delivery retry settings
+	retryDurations := []struct {
+		env          string
+		target       *time.Duration
+		defaultValue time.Duration
+	}{
+		{"DELIVERY_RETRY_BASE_DELAY", &config.DeliveryRetryBaseDelay, 5 * time.Minute},
+		{"DELIVERY_RETRY_MAX_DELAY", &config.DeliveryRetryMaxDelay, 6 * time.Hour},
+		{"DELIVERY_RETRY_HORIZON", &config.DeliveryRetryHorizon, 72 * time.Hour},
+	}
+	for _, setting := range retryDurations {
+		value := os.Getenv(setting.env)
+		if value == "" {
+			*setting.target = setting.defaultValue
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", setting.env, err)
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("%s must be positive", setting.env)
+		}
+		*setting.target = duration
+	}
+	if config.DeliveryRetryMaxDelay < config.DeliveryRetryBaseDelay {
+		return nil, fmt.Errorf("DELIVERY_RETRY_MAX_DELAY must not be shorter than DELIVERY_RETRY_BASE_DELAY")
+	}
+
 	// Database settings
 	config.DBPath = os.Getenv("DB_PATH")
 	if config.DBPath == "" {
 		config.DBPath = "/app/data/db.sqlite"
 	}
 
+	// File secret settings, sizes in megabytes
+	config.FilesDir = os.Getenv("FILES_DIR")
+	if config.FilesDir == "" {
+		config.FilesDir = filepath.Join(filepath.Dir(config.DBPath), "files")
+	}
+	for _, setting := range []struct {
+		env          string
+		target       *int64
+		defaultValue int64
+	}{
+		{"MAX_FILE_SIZE", &config.MaxFileSize, 25},
+		{"FILE_QUOTA", &config.FileQuota, 100},
+	} {
+		megabytes := setting.defaultValue
+		if value := os.Getenv(setting.env); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", setting.env, err)
+			}
+			if parsed <= 0 {
+				return nil, fmt.Errorf("%s must be positive", setting.env)
+			}
+			megabytes = parsed
+		}
+		*setting.target = megabytes * 1024 * 1024
+	}
+
+	// Secret version history
+	secretVersionsStr := os.Getenv("SECRET_VERSIONS")
+	if secretVersionsStr == "" {
+		config.SecretVersions = 10 // 10 revisions default
+	} else {
+		versions, err := strconv.Atoi(secretVersionsStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SECRET_VERSIONS: %w", err)
+		}
+		if versions < 0 || versions > 100 {
+			return nil, fmt.Errorf("SECRET_VERSIONS must be between 0 and 100")
+		}
+		config.SecretVersions = versions
+	}
+
+	// Server master key
+	masterKeyStr := os.Getenv("MASTER_KEY")
+	if masterKeyStr != "" {
+		masterKey, err := parseMasterKey("MASTER_KEY", masterKeyStr)
+		if err != nil {
+			return nil, err
+		}
+		config.MasterKey = masterKey
+	}
+
+	// Previous master keys, comma separated
+	for _, previousKeyStr := range strings.Split(os.Getenv("MASTER_KEY_PREVIOUS"), ",") {
+		if previousKeyStr = strings.TrimSpace(previousKeyStr); previousKeyStr == "" {
+			continue
+		}
+		previousKey, err := parseMasterKey("MASTER_KEY_PREVIOUS", previousKeyStr)
+		if err != nil {
+			return nil, err
+		}
+		config.PreviousMasterKeys = append(config.PreviousMasterKeys, previousKey)
+	}
+	if len(config.PreviousMasterKeys) > 0 && len(config.MasterKey) == 0 {
+		return nil, fmt.Errorf("MASTER_KEY_PREVIOUS needs a MASTER_KEY to rotate to")
+	}
+
+	// Admin API token
+	config.AdminToken = os.Getenv("ADMIN_TOKEN")
+	if config.AdminToken != "" && len(config.AdminToken) < 32 {
+		return nil, fmt.Errorf("ADMIN_TOKEN must be at least 32 characters")
+	}
+
 	// Debug mode
 	debugStr := os.Getenv("DEBUG")
 	config.Debug = debugStr == "true" || debugStr == "1"
 
+	// Timelock beacon
+	config.TimelockBeacon = os.Getenv("TIMELOCK_BEACON")
+	config.DrandURL = os.Getenv("DRAND_URL")
+	if config.DrandURL == "" {
+		config.DrandURL = DefaultDrandURL
+	}
+	config.DrandChainHash = os.Getenv("DRAND_CHAIN_HASH")
+	if config.DrandChainHash == "" {
+		config.DrandChainHash = DefaultDrandChainHash
+	}
+
+	switch config.TimelockBeacon {
+	case "":
+	case "drand":
+		timelock, err := crypto.NewDrandTimelock(config.DrandURL, config.DrandChainHash, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TIMELOCK_BEACON: %w", err)
+		}
+		config.Timelock = timelock
+	case "local":
+		// Whoever holds the seed can open every round, so it is for testing only
+		if !config.Debug {
+			return nil, fmt.Errorf("TIMELOCK_BEACON=local is for testing only and needs DEBUG=true")
+		}
+		seed, err := base64.StdEncoding.DecodeString(os.Getenv("TIMELOCK_SEED"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TIMELOCK_SEED: must be base64 encoded: %w", err)
+		}
+		timelock, err := crypto.NewLocalTimelock(seed, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TIMELOCK_SEED: %w", err)
+		}
+		config.Timelock = timelock
+	default:
+		return nil, fmt.Errorf("TIMELOCK_BEACON must be drand, local or empty")
+	}
+
 	// Log level
 	config.LogLevel = os.Getenv("LOG_LEVEL")
 	if config.LogLevel == "" {
@@ -181,3 +385,15 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// parseMasterKey decodes a base64 master key from the environment variable name
+func parseMasterKey(name, value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: must be base64 encoded: %w", name, err)
+	}
+	if len(key) < 32 {
+		return nil, fmt.Errorf("%s must be at least 32 bytes", name)
+	}
+	return key, nil
+}
@@ -1,18 +1,27 @@
 package config
 
 import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/cloudflare/circl/ecc/bls12381"
 )
 
 func TestLoadFromEnv(t *testing.T) {
 	// Save original environment variables to restore later
 	originalEnv := make(map[string]string)
 	envVars := []string{
-		"BASE_DOMAIN", "TG_BOT_TOKEN", "ADMIN_EMAIL",
+		"BASE_DOMAIN", "TG_BOT_TOKEN", "ADMIN_EMAIL", "ADMIN_TOKEN",
 		"SMTP_HOST", "SMTP_PORT", "SMTP_USERNAME", "SMTP_PASSWORD", "SMTP_FROM",
 		"PING_FREQUENCY", "PING_DEADLINE", "DB_PATH", "DEBUG", "LOG_LEVEL",
+		"MASTER_KEY", "MASTER_KEY_PREVIOUS", "DELIVERY_RETRY_BASE_DELAY", "DELIVERY_RETRY_MAX_DELAY", "DELIVERY_RETRY_HORIZON",
+		"TRIGGER_GRACE_HOURS", "TIMELOCK_BEACON", "TIMELOCK_SEED", "DRAND_URL", "DRAND_CHAIN_HASH",
+		"FILES_DIR", "MAX_FILE_SIZE", "FILE_QUOTA", "SECRET_VERSIONS",
 	}
 
 	for _, env := range envVars {
@@ -30,6 +39,17 @@ func TestLoadFromEnv(t *testing.T) {
 		}
 	}()
 
+	// A drand relay that serves the info of the default chain
+	drand := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+DefaultDrandChainHash+"/info" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"public_key":%q,"period":3,"genesis_time":1692803367,"hash":%q,"schemeID":"bls-unchained-g1-rfc9380"}`,
+			hex.EncodeToString(bls12381.G2Generator().BytesCompressed()), DefaultDrandChainHash)
+	}))
+	defer drand.Close()
+
 	// Test cases
 	tests := []struct {
 		name        string
@@ -71,6 +91,19 @@ func TestLoadFromEnv(t *testing.T) {
 				if cfg.LogLevel != "info" {
 					t.Errorf("Expected default LogLevel to be 'info', got '%s'", cfg.LogLevel)
 				}
+				if cfg.TriggerGracePeriod != 24*time.Hour {
+					t.Errorf("Expected default TriggerGracePeriod to be 24 hours, got %v", cfg.TriggerGracePeriod)
+				}
+				if cfg.DeliveryRetryBaseDelay != 5*time.Minute || cfg.DeliveryRetryMaxDelay != 6*time.Hour || cfg.DeliveryRetryHorizon != 72*time.Hour {
+					t.Errorf("Unexpected default delivery retry settings: %v, %v, %v",
+						cfg.DeliveryRetryBaseDelay, cfg.DeliveryRetryMaxDelay, cfg.DeliveryRetryHorizon)
+				}
+				if cfg.FilesDir != "/app/data/files" || cfg.MaxFileSize != 25<<20 || cfg.FileQuota != 100<<20 {
+					t.Errorf("Unexpected default file settings: %s, %d, %d", cfg.FilesDir, cfg.MaxFileSize, cfg.FileQuota)
+				}
+				if cfg.SecretVersions != 10 {
+					t.Errorf("Expected default SecretVersions to be 10, got %d", cfg.SecretVersions)
+				}
 			},
 		},
 		{
@@ -167,6 +200,172 @@ func TestLoadFromEnv(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "Valid MASTER_KEY",
+			envVars: map[string]string{
+				"BASE_DOMAIN":  "example.com",
+				"TG_BOT_TOKEN": "test-token",
+				"ADMIN_EMAIL":  "admin@example.com",
+				"MASTER_KEY":   "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+			},
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if string(cfg.MasterKey) != "0123456789abcdef0123456789abcdef" {
+					t.Errorf("Expected MasterKey to be decoded from base64, got '%s'", cfg.MasterKey)
+				}
+			},
+		},
+		{
+			name: "Invalid MASTER_KEY encoding",
+			envVars: map[string]string{
+				"BASE_DOMAIN":  "example.com",
+				"TG_BOT_TOKEN": "test-token",
+				"ADMIN_EMAIL":  "admin@example.com",
+				"MASTER_KEY":   "not base64!",
+			},
+			expectError: true,
+		},
+		{
+			name: "MASTER_KEY too short",
+			envVars: map[string]string{
+				"BASE_DOMAIN":  "example.com",
+				"TG_BOT_TOKEN": "test-token",
+				"ADMIN_EMAIL":  "admin@example.com",
+				"MASTER_KEY":   "c2hvcnQta2V5",
+			},
+			expectError: true,
+		},
+		{
+			name: "Previous master keys",
+			envVars: map[string]string{
+				"BASE_DOMAIN":         "example.com",
+				"TG_BOT_TOKEN":        "test-token",
+				"ADMIN_EMAIL":         "admin@example.com",
+				"MASTER_KEY":          "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+				"MASTER_KEY_PREVIOUS": "YWJjZGVmMDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODk=, MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+			},
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if len(cfg.PreviousMasterKeys) != 2 || string(cfg.PreviousMasterKeys[0]) != "abcdef0123456789abcdef0123456789" {
+					t.Errorf("Expected 2 previous master keys, got %q", cfg.PreviousMasterKeys)
+				}
+			},
+		},
+		{
+			name: "Previous master key without MASTER_KEY",
+			envVars: map[string]string{
+				"BASE_DOMAIN":         "example.com",
+				"TG_BOT_TOKEN":        "test-token",
+				"ADMIN_EMAIL":         "admin@example.com",
+				"MASTER_KEY_PREVIOUS": "YWJjZGVmMDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODk=",
+			},
+			expectError: true,
+		},
+		{
+			name: "Admin token",
+			envVars: map[string]string{
+				"BASE_DOMAIN":  "example.com",
+				"TG_BOT_TOKEN": "test-token",
+				"ADMIN_EMAIL":  "admin@example.com",
+				"ADMIN_TOKEN":  "admin-token-for-testing-purposes",
+			},
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.AdminToken != "admin-token-for-testing-purposes" {
+					t.Errorf("Expected the admin token, got %q", cfg.AdminToken)
+				}
+			},
+		},
+		{
+			name: "Short admin token",
+			envVars: map[string]string{
+				"BASE_DOMAIN":  "example.com",
+				"TG_BOT_TOKEN": "test-token",
+				"ADMIN_EMAIL":  "admin@example.com",
+				"ADMIN_TOKEN":  "admin",
+			},
+			expectError: true,
+		},
+		{
+			name: "Drand timelock beacon",
+			envVars: map[string]string{
+				"BASE_DOMAIN":     "example.com",
+				"TG_BOT_TOKEN":    "test-token",
+				"ADMIN_EMAIL":     "admin@example.com",
+				"TIMELOCK_BEACON": "drand",
+				"DRAND_URL":       drand.URL,
+			},
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.Timelock == nil {
+					t.Errorf("Expected a timelock for TIMELOCK_BEACON=drand")
+				}
+				if cfg.DrandChainHash != DefaultDrandChainHash {
+					t.Errorf("Expected the quicknet chain by default, got %s", cfg.DrandChainHash)
+				}
+			},
+		},
+		{
+			name: "Unknown drand chain",
+			envVars: map[string]string{
+				"BASE_DOMAIN":      "example.com",
+				"TG_BOT_TOKEN":     "test-token",
+				"ADMIN_EMAIL":      "admin@example.com",
+				"TIMELOCK_BEACON":  "drand",
+				"DRAND_URL":        drand.URL,
+				"DRAND_CHAIN_HASH": "8990e7a9aaed2ffed73dbd7092123d6f289930540d7651336225dc172e51b2ce",
+			},
+			expectError: true,
+		},
+		{
+			name: "Local timelock beacon",
+			envVars: map[string]string{
+				"BASE_DOMAIN":     "example.com",
+				"TG_BOT_TOKEN":    "test-token",
+				"ADMIN_EMAIL":     "admin@example.com",
+				"DEBUG":           "true",
+				"TIMELOCK_BEACON": "local",
+				"TIMELOCK_SEED":   "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+			},
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.Timelock == nil {
+					t.Errorf("Expected a timelock for TIMELOCK_BEACON=local")
+				}
+			},
+		},
+		{
+			name: "Local timelock beacon in production",
+			envVars: map[string]string{
+				"BASE_DOMAIN":     "example.com",
+				"TG_BOT_TOKEN":    "test-token",
+				"ADMIN_EMAIL":     "admin@example.com",
+				"TIMELOCK_BEACON": "local",
+				"TIMELOCK_SEED":   "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+			},
+			expectError: true,
+		},
+		{
+			name: "Local timelock beacon without seed",
+			envVars: map[string]string{
+				"BASE_DOMAIN":     "example.com",
+				"TG_BOT_TOKEN":    "test-token",
+				"ADMIN_EMAIL":     "admin@example.com",
+				"DEBUG":           "true",
+				"TIMELOCK_BEACON": "local",
+			},
+			expectError: true,
+		},
+		{
+			name: "Unknown timelock beacon",
+			envVars: map[string]string{
+				"BASE_DOMAIN":     "example.com",
+				"TG_BOT_TOKEN":    "test-token",
+				"ADMIN_EMAIL":     "admin@example.com",
+				"TIMELOCK_BEACON": "sundial",
+			},
+			expectError: true,
+		},
 		{
 			name: "Full valid configuration",
 			envVars: map[string]string{
@@ -233,6 +432,122 @@ func TestLoadFromEnv(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Custom file settings",
+			envVars: map[string]string{
+				"BASE_DOMAIN":   "example.com",
+				"TG_BOT_TOKEN":  "test-token",
+				"ADMIN_EMAIL":   "admin@example.com",
+				"FILES_DIR":     "/data/files",
+				"MAX_FILE_SIZE": "5",
+				"FILE_QUOTA":    "50",
+			},
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.FilesDir != "/data/files" || cfg.MaxFileSize != 5<<20 || cfg.FileQuota != 50<<20 {
+					t.Errorf("Unexpected file settings: %s, %d, %d", cfg.FilesDir, cfg.MaxFileSize, cfg.FileQuota)
+				}
+			},
+		},
+		{
+			name: "Invalid MAX_FILE_SIZE",
+			envVars: map[string]string{
+				"BASE_DOMAIN":   "example.com",
+				"TG_BOT_TOKEN":  "test-token",
+				"ADMIN_EMAIL":   "admin@example.com",
+				"MAX_FILE_SIZE": "0",
+			},
+			expectError: true,
+		},
+		{
+			name: "Secret version history disabled",
+			envVars: map[string]string{
+				"BASE_DOMAIN":     "example.com",
+				"TG_BOT_TOKEN":    "test-token",
+				"ADMIN_EMAIL":     "admin@example.com",
+				"SECRET_VERSIONS": "0",
+			},
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.SecretVersions != 0 {
+					t.Errorf("Expected SecretVersions to be 0, got %d", cfg.SecretVersions)
+				}
+			},
+		},
+		{
+			name: "Invalid SECRET_VERSIONS",
+			envVars: map[string]string{
+				"BASE_DOMAIN":     "example.com",
+				"TG_BOT_TOKEN":    "test-token",
+				"ADMIN_EMAIL":     "admin@example.com",
+				"SECRET_VERSIONS": "101",
+			},
+			expectError: true,
+		},
+		{
+			name: "Custom delivery retry settings",
+			envVars: map[string]string{
+				"BASE_DOMAIN":               "example.com",
+				"TG_BOT_TOKEN":              "test-token",
+				"ADMIN_EMAIL":               "admin@example.com",
+				"DELIVERY_RETRY_BASE_DELAY": "1m",
+				"DELIVERY_RETRY_MAX_DELAY":  "1h",
+				"DELIVERY_RETRY_HORIZON":    "168h",
+			},
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.DeliveryRetryBaseDelay != time.Minute || cfg.DeliveryRetryMaxDelay != time.Hour || cfg.DeliveryRetryHorizon != 7*24*time.Hour {
+					t.Errorf("Unexpected delivery retry settings: %v, %v, %v",
+						cfg.DeliveryRetryBaseDelay, cfg.DeliveryRetryMaxDelay, cfg.DeliveryRetryHorizon)
+				}
+			},
+		},
+		{
+			name: "Trigger grace period disabled",
+			envVars: map[string]string{
+				"BASE_DOMAIN":         "example.com",
+				"TG_BOT_TOKEN":        "test-token",
+				"ADMIN_EMAIL":         "admin@example.com",
+				"TRIGGER_GRACE_HOURS": "0",
+			},
+			expectError: false,
+			validate: func(t *testing.T, cfg *Config) {
+				if cfg.TriggerGracePeriod != 0 {
+					t.Errorf("Expected TriggerGracePeriod to be 0, got %v", cfg.TriggerGracePeriod)
+				}
+			},
+		},
+		{
+			name: "TRIGGER_GRACE_HOURS out of range",
+			envVars: map[string]string{
+				"BASE_DOMAIN":         "example.com",
+				"TG_BOT_TOKEN":        "test-token",
+				"ADMIN_EMAIL":         "admin@example.com",
+				"TRIGGER_GRACE_HOURS": "200",
+			},
+			expectError: true,
+		},
+		{
+			name: "Invalid DELIVERY_RETRY_HORIZON",
+			envVars: map[string]string{
+				"BASE_DOMAIN":            "example.com",
+				"TG_BOT_TOKEN":           "test-token",
+				"ADMIN_EMAIL":            "admin@example.com",
+				"DELIVERY_RETRY_HORIZON": "3 days",
+			},
+			expectError: true,
+		},
+		{
+			name: "DELIVERY_RETRY_MAX_DELAY shorter than base delay",
+			envVars: map[string]string{
+				"BASE_DOMAIN":               "example.com",
+				"TG_BOT_TOKEN":              "test-token",
+				"ADMIN_EMAIL":               "admin@example.com",
+				"DELIVERY_RETRY_BASE_DELAY": "1h",
+				"DELIVERY_RETRY_MAX_DELAY":  "5m",
+			},
+			expectError: true,
+		},
 		{
 			name: "Debug mode with '1'",
 			envVars: map[string]string{
@@ -0,0 +1,96 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Recipient copies and files are age v1 files (https://age-encryption.org/v1)
+// encrypted to X25519 recipients with filippo.io/age. Copies are ASCII armored
+// so they can be pasted into a web page or an email, `age -d -i key.txt`
+// decrypts them.
+
+// GenerateAgeIdentity generates an X25519 key pair. It returns the secret
+// identity (AGE-SECRET-KEY-1...) and the public recipient (age1...).
+func GenerateAgeIdentity() (string, string, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return identity.String(), identity.Recipient().String(), nil
+}
+
+// ageEncrypt encrypts data to an X25519 recipient and returns an armored age file
+func ageEncrypt(data []byte, recipient *age.X25519Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	armored := armor.NewWriter(&buf)
+
+	w, err := age.Encrypt(armored, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := armored.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close armor: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ageDecrypt decrypts an age file, armored or not, with an X25519 identity
+func ageDecrypt(file []byte, identity *age.X25519Identity) ([]byte, error) {
+	var src io.Reader = bytes.NewReader(file)
+	if bytes.HasPrefix(bytes.TrimSpace(file), []byte(armor.Header)) {
+		src = armor.NewReader(src)
+	}
+
+	r, err := ageDecryptReader(src, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ageDecryptReader checks the header of an age file and returns a reader of
+// the payload. Errors of the file and of its payload are ErrDecryptionFailed.
+func ageDecryptReader(src io.Reader, identity *age.X25519Identity) (io.Reader, error) {
+	r, err := age.Decrypt(src, identity)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	return &ageErrorReader{r: r}, nil
+}
+
+// ageErrorReader reports errors of a payload, e.g. a tampered or truncated
+// chunk, as ErrDecryptionFailed
+type ageErrorReader struct {
+	r io.Reader
+}
+
+func (a *ageErrorReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	return n, err
+}
+
+// parseAgeIdentity parses an AGE-SECRET-KEY-1... identity
+func parseAgeIdentity(identity string) (*age.X25519Identity, error) {
+	return age.ParseX25519Identity(strings.TrimSpace(identity))
+}
@@ -0,0 +1,38 @@
+package crypto
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Streaming variants of ageEncrypt and ageDecrypt for files that shouldn't be
+// held in memory. They write and read binary age files, only one chunk of the
+// payload is buffered at a time.
+
+// NewAgeWriter returns a writer that encrypts everything written to it to an
+// age X25519 recipient (age1...) and writes a binary age file to w. Close must
+// be called to write the last chunk, it doesn't close w.
+func NewAgeWriter(w io.Writer, recipient string) (io.WriteCloser, error) {
+	publicKey, err := age.ParseX25519Recipient(strings.TrimSpace(recipient))
+	if err != nil {
+		return nil, fmt.Errorf("invalid age recipient: %w", err)
+	}
+
+	return age.Encrypt(w, publicKey)
+}
+
+// NewAgeReader checks the header of a binary age file read from r with an
+// X25519 identity (AGE-SECRET-KEY-1...) and returns a reader of the decrypted
+// payload. Each chunk is authenticated before it is returned, a truncated or
+// tampered file fails with ErrDecryptionFailed.
+func NewAgeReader(r io.Reader, identity string) (io.Reader, error) {
+	key, err := parseAgeIdentity(identity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity: %w", err)
+	}
+
+	return ageDecryptReader(r, key)
+}
@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Client side encryption. In zero-knowledge mode secrets are encrypted in the
+// owner's browser with WebCrypto before they are sent, so the server only ever
+// stores an envelope it can't open. The key is derived from a passphrase with
+// PBKDF2, which is what WebCrypto offers.
+
+const (
+	// ClientEnvelopeVersion is the version of the envelope format
+	ClientEnvelopeVersion = 1
+
+	// ClientEnvelopeKDF is the key derivation function used for envelopes
+	ClientEnvelopeKDF = "PBKDF2-SHA256"
+
+	// ClientEnvelopeIterations is the number of PBKDF2 iterations new envelopes use
+	ClientEnvelopeIterations = 600000
+
+	// Envelopes with fewer iterations are rejected, more than this would take too long to open
+	clientMinIterations = 100000
+	clientMaxIterations = 10000000
+)
+
+// ErrInvalidEnvelope is returned for data that is not a valid client side envelope
+var ErrInvalidEnvelope = errors.New("invalid client side envelope")
+
+// ClientEnvelope is a secret encrypted in the browser. It is stored as JSON.
+type ClientEnvelope struct {
+	Version    int    `json:"v"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iter"`
+	Salt       string `json:"salt"` // Base64, 16 bytes
+	IV         string `json:"iv"`   // Base64, 12 bytes
+	Ciphertext string `json:"ct"`   // Base64, AES-256-GCM ciphertext with the tag appended
+}
+
+// ParseClientEnvelope parses and validates an envelope. The server can't check
+// that the ciphertext is intact, only that it is well-formed.
+func ParseClientEnvelope(data string) (*ClientEnvelope, error) {
+	var envelope ClientEnvelope
+	if err := json.Unmarshal([]byte(data), &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+
+	if envelope.Version != ClientEnvelopeVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidEnvelope, envelope.Version)
+	}
+	if envelope.KDF != ClientEnvelopeKDF {
+		return nil, fmt.Errorf("%w: unsupported key derivation %q", ErrInvalidEnvelope, envelope.KDF)
+	}
+	if envelope.Iterations < clientMinIterations || envelope.Iterations > clientMaxIterations {
+		return nil, fmt.Errorf("%w: iterations must be between %d and %d", ErrInvalidEnvelope, clientMinIterations, clientMaxIterations)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(envelope.Salt)
+	if err != nil || len(salt) != saltSize {
+		return nil, fmt.Errorf("%w: salt must be %d bytes", ErrInvalidEnvelope, saltSize)
+	}
+	iv, err := base64.StdEncoding.DecodeString(envelope.IV)
+	if err != nil || len(iv) != nonceSize {
+		return nil, fmt.Errorf("%w: iv must be %d bytes", ErrInvalidEnvelope, nonceSize)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(envelope.Ciphertext)
+	if err != nil || len(ciphertext) < tagSize {
+		return nil, fmt.Errorf("%w: ciphertext is too short", ErrInvalidEnvelope)
+	}
+
+	return &envelope, nil
+}
+
+// EncryptClientEnvelope encrypts data the same way the browser does. It is
+// meant for tools and tests, the web interface encrypts in the browser.
+func EncryptClientEnvelope(data []byte, passphrase string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := pbkdf2.Key([]byte(passphrase), salt, ClientEnvelopeIterations, keySize, sha256.New)
+
+	// Encrypt prepends the nonce, the envelope keeps it separately
+	sealed, err := Encrypt(data, key)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := json.Marshal(ClientEnvelope{
+		Version:    ClientEnvelopeVersion,
+		KDF:        ClientEnvelopeKDF,
+		Iterations: ClientEnvelopeIterations,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		IV:         base64.StdEncoding.EncodeToString(sealed[:nonceSize]),
+		Ciphertext: base64.StdEncoding.EncodeToString(sealed[nonceSize:]),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode envelope: %w", err)
+	}
+
+	return string(encoded), nil
+}
+
+// DecryptClientEnvelope decrypts an envelope with the passphrase it was encrypted with
+func DecryptClientEnvelope(data string, passphrase string) ([]byte, error) {
+	envelope, err := ParseClientEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Already validated by ParseClientEnvelope
+	salt, _ := base64.StdEncoding.DecodeString(envelope.Salt)
+	iv, _ := base64.StdEncoding.DecodeString(envelope.IV)
+	ciphertext, _ := base64.StdEncoding.DecodeString(envelope.Ciphertext)
+
+	key := pbkdf2.Key([]byte(passphrase), salt, envelope.Iterations, keySize, sha256.New)
+
+	// A wrong passphrase fails the GCM authentication
+	return Decrypt(append(iv, ciphertext...), key)
+}
@@ -3,9 +3,11 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -51,6 +53,11 @@ func DeriveKey(password []byte, salt []byte) ([]byte, error) {
 
 // Encrypt encrypts data using AES-GCM with a random nonce
 func Encrypt(data []byte, key []byte) ([]byte, error) {
+	return encryptWithAAD(data, key, nil)
+}
+
+// encryptWithAAD encrypts data like Encrypt and authenticates additionalData with it
+func encryptWithAAD(data, key, additionalData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
@@ -67,12 +74,17 @@ func Encrypt(data []byte, key []byte) ([]byte, error) {
 	}
 
 	// Encrypt and seal
-	ciphertext := aesGCM.Seal(nonce, nonce, data, nil)
+	ciphertext := aesGCM.Seal(nonce, nonce, data, additionalData)
 	return ciphertext, nil
 }
 
 // Decrypt decrypts data that was encrypted with Encrypt
 func Decrypt(data []byte, key []byte) ([]byte, error) {
+	return decryptWithAAD(data, key, nil)
+}
+
+// decryptWithAAD decrypts data that was encrypted with encryptWithAAD and the same additionalData
+func decryptWithAAD(data, key, additionalData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
@@ -89,7 +101,7 @@ func Decrypt(data []byte, key []byte) ([]byte, error) {
 	}
 
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, ErrDecryptionFailed
 	}
@@ -115,8 +127,43 @@ func GenerateDataEncryptionKey() ([]byte, error) {
 	return dek, nil
 }
 
+// WrapKey encrypts a key with a key-encryption key derived from a password
+// Returns the wrapped key and the salt used for the derivation
+func WrapKey(key []byte, password []byte) ([]byte, []byte, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kek, err := DeriveKey(password, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := Encrypt(key, kek)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return wrapped, salt, nil
+}
+
+// UnwrapKey decrypts a key that was wrapped with WrapKey
+func UnwrapKey(wrappedKey []byte, password []byte, salt []byte) ([]byte, error) {
+	if len(salt) != saltSize {
+		return nil, ErrInvalidData
+	}
+
+	kek, err := DeriveKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decrypt(wrappedKey, kek)
+}
+
 // EncryptSecret encrypts a secret with a key and returns the complete encrypted package
-// Format: base64(salt + encrypted(DEK) + encrypted(secret))
+// Format: base64(envelope header + encrypted(DEK) + encrypted(secret)), see envelope.go
 func EncryptSecret(secret []byte, masterKey []byte) (string, error) {
 	// Generate a random salt for this operation
 	salt, err := GenerateSalt()
@@ -125,7 +172,8 @@ func EncryptSecret(secret []byte, masterKey []byte) (string, error) {
 	}
 
 	// Derive key from master key and salt
-	derivedKey, err := DeriveKey(masterKey, salt)
+	header := newEnvelopeHeader(salt, masterKey)
+	derivedKey, err := header.deriveKey(masterKey)
 	if err != nil {
 		return "", err
 	}
@@ -136,8 +184,8 @@ func EncryptSecret(secret []byte, masterKey []byte) (string, error) {
 		return "", err
 	}
 
-	// Encrypt the DEK with the derived key
-	encryptedDEK, err := Encrypt(dek, derivedKey)
+	// Encrypt the DEK with the derived key, bound to the header
+	encryptedDEK, err := encryptWithAAD(dek, derivedKey, header.additionalData())
 	if err != nil {
 		return "", err
 	}
@@ -148,31 +196,14 @@ func EncryptSecret(secret []byte, masterKey []byte) (string, error) {
 		return "", err
 	}
 
-	// Combine salt + encryptedDEK + encryptedSecret
-	dekSize := len(encryptedDEK)
-	result := make([]byte, saltSize+4+dekSize+len(encryptedSecret))
-
-	// Copy salt
-	copy(result[:saltSize], salt)
-
-	// Copy DEK size (as 4 bytes)
-	result[saltSize] = byte(dekSize >> 24)
-	result[saltSize+1] = byte(dekSize >> 16)
-	result[saltSize+2] = byte(dekSize >> 8)
-	result[saltSize+3] = byte(dekSize)
-
-	// Copy encrypted DEK
-	copy(result[saltSize+4:saltSize+4+dekSize], encryptedDEK)
-
-	// Copy encrypted secret
-	copy(result[saltSize+4+dekSize:], encryptedSecret)
-
 	// Base64 encode everything
-	encoded := base64.StdEncoding.EncodeToString(result)
+	encoded := base64.StdEncoding.EncodeToString(header.marshal(encryptedDEK, encryptedSecret))
 	return encoded, nil
 }
 
-// DecryptSecret decrypts a secret that was encrypted with EncryptSecret
+// DecryptSecret decrypts a secret that was encrypted with EncryptSecret. Data
+// from before the envelope format is read as well, NeedsUpgrade tells whether
+// it should be encrypted again.
 func DecryptSecret(encryptedSecret string, masterKey []byte) ([]byte, error) {
 	// Decode base64
 	decoded, err := base64.StdEncoding.DecodeString(encryptedSecret)
@@ -180,6 +211,26 @@ func DecryptSecret(encryptedSecret string, masterKey []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to decode base64: %w", err)
 	}
 
+	header, encryptedDEK, encryptedData, err := parseEnvelope(decoded)
+	if errors.Is(err, ErrLegacyEnvelope) {
+		return decryptLegacySecret(decoded, masterKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive key from master key and salt
+	derivedKey, err := header.deriveKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptWithDEK(encryptedDEK, encryptedData, derivedKey, header.additionalData())
+}
+
+// decryptLegacySecret decrypts data written before the envelope format
+// Format: salt + DEK size (4 bytes) + encrypted(DEK) + encrypted(secret)
+func decryptLegacySecret(decoded []byte, masterKey []byte) ([]byte, error) {
 	// Check minimum length
 	if len(decoded) < saltSize+4+nonceSize {
 		return nil, ErrInvalidData
@@ -203,8 +254,14 @@ func DecryptSecret(encryptedSecret string, masterKey []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	return decryptWithDEK(encryptedDEK, encryptedData, derivedKey, nil)
+}
+
+// decryptWithDEK decrypts the DEK with the derived key and the additional data
+// it is bound to, then the secret with the DEK
+func decryptWithDEK(encryptedDEK, encryptedData, derivedKey, additionalData []byte) ([]byte, error) {
 	// Decrypt the DEK
-	dek, err := Decrypt(encryptedDEK, derivedKey)
+	dek, err := decryptWithAAD(encryptedDEK, derivedKey, additionalData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt DEK: %w", err)
 	}
@@ -250,6 +307,21 @@ func VerifyPassword(password string, storedHash []byte) (bool, error) {
 	return hmacEqual(hash, newHash), nil
 }
 
+// AccessCodeHashPrefix marks the access code hashes written by HashAccessCode.
+// Codes stored before were hashed with HashPassword and have no prefix.
+const AccessCodeHashPrefix = "hmac-sha256:"
+
+// HashAccessCode returns the HMAC-SHA256 of an access code under key, which
+// the code is stored and looked up by. Access codes are random, so unlike a
+// password they don't need a slow hash, and the key keeps a copy of the
+// database from confirming a code without it.
+func HashAccessCode(code string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("deadmanswitch access code"))
+	mac.Write([]byte(code))
+	return AccessCodeHashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
 // hmacEqual is a constant-time comparison function
 func hmacEqual(a, b []byte) bool {
 	if len(a) != len(b) {
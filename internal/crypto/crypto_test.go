@@ -3,6 +3,8 @@ package crypto
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -138,6 +140,45 @@ func TestGenerateDataEncryptionKey(t *testing.T) {
 	}
 }
 
+func TestWrapUnwrapKey(t *testing.T) {
+	password := []byte("user-login-password")
+	key, err := GenerateDataEncryptionKey()
+	if err != nil {
+		t.Fatalf("GenerateDataEncryptionKey failed: %v", err)
+	}
+
+	// Wrap the key
+	wrapped, salt, err := WrapKey(key, password)
+	if err != nil {
+		t.Fatalf("WrapKey failed: %v", err)
+	}
+	if len(salt) != saltSize {
+		t.Errorf("Expected salt length %d, got %d", saltSize, len(salt))
+	}
+	if bytes.Contains(wrapped, key) {
+		t.Errorf("Wrapped key should not contain the plaintext key")
+	}
+
+	// Unwrap the key
+	unwrapped, err := UnwrapKey(wrapped, password, salt)
+	if err != nil {
+		t.Fatalf("UnwrapKey failed: %v", err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Errorf("Unwrapped key doesn't match original")
+	}
+
+	// Test unwrapping with wrong password
+	if _, err := UnwrapKey(wrapped, []byte("wrong-password"), salt); err == nil {
+		t.Errorf("Unwrapping with wrong password should fail")
+	}
+
+	// Test unwrapping with invalid salt
+	if _, err := UnwrapKey(wrapped, password, []byte("short")); err != ErrInvalidData {
+		t.Errorf("Expected ErrInvalidData for invalid salt, got %v", err)
+	}
+}
+
 func TestEncryptDecryptSecret(t *testing.T) {
 	masterKey := []byte("master-password-for-testing-purposes")
 	secret := []byte("This is a secret message that needs to be encrypted")
@@ -252,3 +293,65 @@ func TestHmacEqual(t *testing.T) {
 		t.Errorf("hmacEqual should return false for different content slices")
 	}
 }
+
+func TestHashAccessCode(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	hash := HashAccessCode("the-code", key)
+	if !strings.HasPrefix(hash, AccessCodeHashPrefix) {
+		t.Errorf("Expected the hash to start with %q, got %q", AccessCodeHashPrefix, hash)
+	}
+	if HashAccessCode("the-code", key) != hash {
+		t.Error("Expected the same code and key to give the same hash")
+	}
+	if HashAccessCode("other-code", key) == hash || HashAccessCode("the-code", []byte("another key")) == hash {
+		t.Error("Expected another code or key to give another hash")
+	}
+}
+
+func TestClientEnvelope(t *testing.T) {
+	envelope, err := EncryptClientEnvelope([]byte("bank login"), "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("EncryptClientEnvelope failed: %v", err)
+	}
+
+	if _, err := ParseClientEnvelope(envelope); err != nil {
+		t.Fatalf("ParseClientEnvelope failed: %v", err)
+	}
+
+	plaintext, err := DecryptClientEnvelope(envelope, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("DecryptClientEnvelope failed: %v", err)
+	}
+	if string(plaintext) != "bank login" {
+		t.Errorf("Expected %q, got %q", "bank login", plaintext)
+	}
+
+	if _, err := DecryptClientEnvelope(envelope, "wrong passphrase"); err != ErrDecryptionFailed {
+		t.Errorf("Expected ErrDecryptionFailed for a wrong passphrase, got %v", err)
+	}
+
+	// Produced by web/static/js/zero-knowledge.js
+	browser := `{"v":1,"kdf":"PBKDF2-SHA256","iter":600000,"salt":"tdmHVJcJVLkZhftqjvA6Kg==","iv":"Ge+2rPq/ddWfbDz7","ct":"BAOrVOkfPyGpSxi4spozSjkbVZLvQk3vZeTiDzw="}`
+	plaintext, err = DecryptClientEnvelope(browser, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Failed to decrypt the browser envelope: %v", err)
+	}
+	if string(plaintext) != "bank login é" {
+		t.Errorf("Expected %q, got %q", "bank login é", plaintext)
+	}
+
+	invalid := []string{
+		"bank login",
+		`{"v":2,"kdf":"PBKDF2-SHA256","iter":600000,"salt":"AAAAAAAAAAAAAAAAAAAAAA==","iv":"AAAAAAAAAAAAAAAA","ct":"AAAAAAAAAAAAAAAAAAAAAA=="}`,
+		`{"v":1,"kdf":"PBKDF2-SHA1","iter":600000,"salt":"AAAAAAAAAAAAAAAAAAAAAA==","iv":"AAAAAAAAAAAAAAAA","ct":"AAAAAAAAAAAAAAAAAAAAAA=="}`,
+		`{"v":1,"kdf":"PBKDF2-SHA256","iter":1000,"salt":"AAAAAAAAAAAAAAAAAAAAAA==","iv":"AAAAAAAAAAAAAAAA","ct":"AAAAAAAAAAAAAAAAAAAAAA=="}`,
+		`{"v":1,"kdf":"PBKDF2-SHA256","iter":600000,"salt":"AAAA","iv":"AAAAAAAAAAAAAAAA","ct":"AAAAAAAAAAAAAAAAAAAAAA=="}`,
+		`{"v":1,"kdf":"PBKDF2-SHA256","iter":600000,"salt":"AAAAAAAAAAAAAAAAAAAAAA==","iv":"AAAAAAAAAAAAAAAA","ct":"AAAA"}`,
+	}
+	for _, data := range invalid {
+		if _, err := ParseClientEnvelope(data); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Errorf("Expected ErrInvalidEnvelope for %s, got %v", data, err)
+		}
+	}
+}
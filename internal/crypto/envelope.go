@@ -0,0 +1,358 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Secrets are stored in a self-describing envelope, so the key derivation cost,
+// the cipher and the key hierarchy can change without breaking existing data.
+// All integers are big-endian:
+//
+//	magic "DMSE" | version | KDF id | KDF params | salt length | salt |
+//	cipher id | key ID length | key ID | DEK length | encrypted DEK | encrypted secret
+//
+// The key encryption key is derived from the caller's key and the salt with the
+// KDF, it encrypts a random data encryption key (DEK) that encrypts the secret.
+// Rotating the key only has to encrypt the DEK again, see RewrapSecret.
+// Since version 2 the header up to the key ID is the additional data of the
+// encrypted DEK, so changing any of its fields makes decryption fail. The
+// encrypted secret is bound through its DEK, which is random per envelope.
+// Data written before the envelope existed has no header and is read as
+// base64(salt | DEK length | encrypted DEK | encrypted secret).
+
+const (
+	// EnvelopeVersion is the version of the envelope format written by EncryptSecret
+	EnvelopeVersion = 2
+
+	// envelopeVersionUnbound is the first version, which didn't authenticate the header
+	envelopeVersionUnbound = 1
+
+	// KDFNone uses a random 256-bit key as the key encryption key as it is.
+	// It has no params and no salt.
+	KDFNone = 0
+
+	// KDFArgon2id derives the key encryption key with Argon2id, its params are
+	// the time cost (uint32), the memory cost in KiB (uint32) and the threads (uint8)
+	KDFArgon2id = 1
+
+	// CipherAES256GCM encrypts the DEK and the secret with AES-256-GCM, the
+	// nonce is prepended to each ciphertext
+	CipherAES256GCM = 1
+
+	// keyIDSize is the number of bytes of the key fingerprint stored as key ID
+	keyIDSize = 8
+
+	// Limits for parsing, so a damaged or crafted header can't make the
+	// derivation more expensive than for the data EncryptSecret writes
+	maxKDFTime    = argonTime
+	maxKDFMemory  = argonMemory
+	maxKDFThreads = argonThreads
+)
+
+var envelopeMagic = []byte("DMSE")
+
+// ErrLegacyEnvelope is returned for data that was encrypted before the envelope format existed
+var ErrLegacyEnvelope = errors.New("legacy encrypted data without an envelope header")
+
+// EnvelopeHeader describes how a secret was encrypted
+type EnvelopeHeader struct {
+	Version    int
+	KDF        int
+	KDFTime    uint32
+	KDFMemory  uint32
+	KDFThreads uint8
+	Salt       []byte
+	Cipher     int
+	KeyID      string
+}
+
+// KeyID returns a short fingerprint of a key. It identifies which key a
+// secret is encrypted with without revealing the key.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("deadmanswitch key id"), key...))
+	return hex.EncodeToString(sum[:keyIDSize])
+}
+
+// newEnvelopeHeader returns the header for data encrypted with the current parameters
+func newEnvelopeHeader(salt, key []byte) *EnvelopeHeader {
+	return &EnvelopeHeader{
+		Version:    EnvelopeVersion,
+		KDF:        KDFArgon2id,
+		KDFTime:    argonTime,
+		KDFMemory:  argonMemory,
+		KDFThreads: argonThreads,
+		Salt:       salt,
+		Cipher:     CipherAES256GCM,
+		KeyID:      KeyID(key),
+	}
+}
+
+// newKeyEnvelopeHeader returns the header for data encrypted directly with a random key
+func newKeyEnvelopeHeader(key []byte) *EnvelopeHeader {
+	return &EnvelopeHeader{
+		Version: EnvelopeVersion,
+		KDF:     KDFNone,
+		Cipher:  CipherAES256GCM,
+		KeyID:   KeyID(key),
+	}
+}
+
+// deriveKey derives the key encryption key as described by the header
+func (h *EnvelopeHeader) deriveKey(key []byte) ([]byte, error) {
+	switch h.KDF {
+	case KDFNone:
+		if len(key) != keySize {
+			return nil, fmt.Errorf("%w: key must be %d bytes", ErrInvalidData, keySize)
+		}
+		return key, nil
+	case KDFArgon2id:
+		return argon2.IDKey(key, h.Salt, h.KDFTime, h.KDFMemory, h.KDFThreads, argonKeyLen), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported key derivation %d", ErrInvalidData, h.KDF)
+	}
+}
+
+// isCurrent reports whether the header matches what EncryptSecret writes today
+func (h *EnvelopeHeader) isCurrent() bool {
+	if h.KDF == KDFNone {
+		return h.Version == EnvelopeVersion && h.Cipher == CipherAES256GCM
+	}
+	return h.Version == EnvelopeVersion &&
+		h.KDF == KDFArgon2id &&
+		h.KDFTime == argonTime &&
+		h.KDFMemory == argonMemory &&
+		h.KDFThreads == argonThreads &&
+		h.Cipher == CipherAES256GCM
+}
+
+// additionalData returns the serialized header the DEK is bound to, or nil
+// for a version 1 header
+func (h *EnvelopeHeader) additionalData() []byte {
+	if h.Version == envelopeVersionUnbound {
+		return nil
+	}
+	return h.serialize()
+}
+
+// serialize writes the header from the magic up to the key ID
+func (h *EnvelopeHeader) serialize() []byte {
+	var buf bytes.Buffer
+	buf.Write(envelopeMagic)
+	buf.WriteByte(byte(h.Version))
+	buf.WriteByte(byte(h.KDF))
+	binary.Write(&buf, binary.BigEndian, h.KDFTime)
+	binary.Write(&buf, binary.BigEndian, h.KDFMemory)
+	buf.WriteByte(h.KDFThreads)
+	buf.WriteByte(byte(len(h.Salt)))
+	buf.Write(h.Salt)
+	buf.WriteByte(byte(h.Cipher))
+	buf.WriteByte(byte(len(h.KeyID)))
+	buf.WriteString(h.KeyID)
+	return buf.Bytes()
+}
+
+// marshal writes the header followed by the encrypted DEK and the encrypted secret
+func (h *EnvelopeHeader) marshal(encryptedDEK, encryptedSecret []byte) []byte {
+	buf := bytes.NewBuffer(h.serialize())
+	binary.Write(buf, binary.BigEndian, uint32(len(encryptedDEK)))
+	buf.Write(encryptedDEK)
+	buf.Write(encryptedSecret)
+	return buf.Bytes()
+}
+
+// envelopeReader reads the fields of an envelope and remembers the first error
+type envelopeReader struct {
+	data []byte
+	err  error
+}
+
+func (r *envelopeReader) next(n int) []byte {
+	if r.err != nil || n > len(r.data) {
+		r.err = ErrInvalidData
+		return nil
+	}
+	field := r.data[:n]
+	r.data = r.data[n:]
+	return field
+}
+
+func (r *envelopeReader) readByte() byte {
+	if field := r.next(1); field != nil {
+		return field[0]
+	}
+	return 0
+}
+
+func (r *envelopeReader) readUint32() uint32 {
+	if field := r.next(4); field != nil {
+		return binary.BigEndian.Uint32(field)
+	}
+	return 0
+}
+
+// parseEnvelope splits decoded data into its header, the encrypted DEK and the
+// encrypted secret. It returns ErrLegacyEnvelope if the data has no header.
+func parseEnvelope(data []byte) (*EnvelopeHeader, []byte, []byte, error) {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		return nil, nil, nil, ErrLegacyEnvelope
+	}
+
+	r := &envelopeReader{data: data[len(envelopeMagic):]}
+	header := &EnvelopeHeader{Version: int(r.readByte())}
+	if r.err == nil && header.Version != EnvelopeVersion && header.Version != envelopeVersionUnbound {
+		return nil, nil, nil, fmt.Errorf("%w: unsupported envelope version %d", ErrInvalidData, header.Version)
+	}
+
+	header.KDF = int(r.readByte())
+	header.KDFTime = r.readUint32()
+	header.KDFMemory = r.readUint32()
+	header.KDFThreads = r.readByte()
+	header.Salt = r.next(int(r.readByte()))
+	header.Cipher = int(r.readByte())
+	header.KeyID = string(r.next(int(r.readByte())))
+	encryptedDEK := r.next(int(r.readUint32()))
+	if r.err != nil {
+		return nil, nil, nil, r.err
+	}
+
+	switch header.KDF {
+	case KDFNone:
+	case KDFArgon2id:
+		if header.KDFTime == 0 || header.KDFTime > maxKDFTime || header.KDFMemory == 0 || header.KDFMemory > maxKDFMemory || header.KDFThreads == 0 || header.KDFThreads > maxKDFThreads {
+			return nil, nil, nil, fmt.Errorf("%w: key derivation parameters out of range", ErrInvalidData)
+		}
+	default:
+		return nil, nil, nil, fmt.Errorf("%w: unsupported key derivation %d", ErrInvalidData, header.KDF)
+	}
+	if header.Cipher != CipherAES256GCM {
+		return nil, nil, nil, fmt.Errorf("%w: unsupported cipher %d", ErrInvalidData, header.Cipher)
+	}
+
+	return header, encryptedDEK, r.data, nil
+}
+
+// ParseEnvelopeHeader returns the header of an encrypted secret without
+// decrypting it. It returns ErrLegacyEnvelope if the secret has no header.
+func ParseEnvelopeHeader(encryptedSecret string) (*EnvelopeHeader, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encryptedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64: %w", err)
+	}
+
+	header, _, _, err := parseEnvelope(decoded)
+	return header, err
+}
+
+// NeedsUpgrade reports whether an encrypted secret uses the legacy format or
+// older parameters than EncryptSecret writes today, so it should be encrypted
+// again the next time its plaintext is at hand
+func NeedsUpgrade(encryptedSecret string) bool {
+	header, err := ParseEnvelopeHeader(encryptedSecret)
+	if err != nil {
+		return errors.Is(err, ErrLegacyEnvelope)
+	}
+	return !header.isCurrent()
+}
+
+// EncryptWithKey encrypts data with a random 256-bit key, such as the server
+// master key, in the same envelope as EncryptSecret but without deriving the
+// key encryption key first. DecryptSecret decrypts it.
+func EncryptWithKey(data []byte, key []byte) (string, error) {
+	return sealEnvelope(newKeyEnvelopeHeader(key), data, key)
+}
+
+// EncryptWithPassphrase encrypts data with a passphrase a person remembers in
+// the same envelope as EncryptSecret, but without a key ID. The fingerprint
+// of a passphrase could be tested offline much faster than Argon2id allows.
+// DecryptSecret decrypts it.
+func EncryptWithPassphrase(data []byte, passphrase []byte) (string, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return "", err
+	}
+
+	header := newEnvelopeHeader(salt, passphrase)
+	header.KeyID = ""
+	return sealEnvelope(header, data, passphrase)
+}
+
+// sealEnvelope encrypts data with a random DEK that is encrypted with the key
+// encryption key derived from key as described by the header
+func sealEnvelope(header *EnvelopeHeader, data, key []byte) (string, error) {
+	kek, err := header.deriveKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	dek, err := GenerateDataEncryptionKey()
+	if err != nil {
+		return "", err
+	}
+
+	encryptedDEK, err := encryptWithAAD(dek, kek, header.additionalData())
+	if err != nil {
+		return "", err
+	}
+
+	encryptedData, err := Encrypt(data, dek)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(header.marshal(encryptedDEK, encryptedData)), nil
+}
+
+// RewrapSecret encrypts the DEK of an envelope again under a new key. The
+// encrypted secret itself is kept as it is, so the plaintext is never touched.
+// Legacy data has no envelope and returns ErrLegacyEnvelope, it has to be
+// decrypted and encrypted again instead.
+func RewrapSecret(encryptedSecret string, oldKey, newKey []byte) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encryptedSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode base64: %w", err)
+	}
+
+	header, encryptedDEK, encryptedData, err := parseEnvelope(decoded)
+	if err != nil {
+		return "", err
+	}
+
+	oldKEK, err := header.deriveKey(oldKey)
+	if err != nil {
+		return "", err
+	}
+
+	dek, err := decryptWithAAD(encryptedDEK, oldKEK, header.additionalData())
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt DEK: %w", err)
+	}
+
+	// The new header keeps the kind of key but uses the current parameters
+	newHeader := newKeyEnvelopeHeader(newKey)
+	if header.KDF != KDFNone {
+		salt, err := GenerateSalt()
+		if err != nil {
+			return "", err
+		}
+		newHeader = newEnvelopeHeader(salt, newKey)
+	}
+
+	newKEK, err := newHeader.deriveKey(newKey)
+	if err != nil {
+		return "", err
+	}
+
+	if encryptedDEK, err = encryptWithAAD(dek, newKEK, newHeader.additionalData()); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(newHeader.marshal(encryptedDEK, encryptedData)), nil
+}
@@ -0,0 +1,285 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// encryptLegacySecret writes a secret the way EncryptSecret did before the envelope format
+func encryptLegacySecret(t *testing.T, secret, masterKey []byte) string {
+	t.Helper()
+
+	salt, _ := GenerateSalt()
+	derivedKey, _ := DeriveKey(masterKey, salt)
+	dek, _ := GenerateDataEncryptionKey()
+	encryptedDEK, err := Encrypt(dek, derivedKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt DEK: %v", err)
+	}
+	encryptedSecret, err := Encrypt(secret, dek)
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+
+	dekSize := len(encryptedDEK)
+	result := append([]byte{}, salt...)
+	result = append(result, byte(dekSize>>24), byte(dekSize>>16), byte(dekSize>>8), byte(dekSize))
+	result = append(result, encryptedDEK...)
+	result = append(result, encryptedSecret...)
+	return base64.StdEncoding.EncodeToString(result)
+}
+
+func TestEncryptSecretEnvelope(t *testing.T) {
+	key := []byte("vault-key-for-testing-purposes!!")
+
+	encrypted, err := EncryptSecret([]byte("secret"), key)
+	if err != nil {
+		t.Fatalf("EncryptSecret failed: %v", err)
+	}
+
+	header, err := ParseEnvelopeHeader(encrypted)
+	if err != nil {
+		t.Fatalf("Failed to parse envelope header: %v", err)
+	}
+	if header.Version != EnvelopeVersion || header.KDF != KDFArgon2id || header.Cipher != CipherAES256GCM {
+		t.Errorf("Unexpected header %+v", header)
+	}
+	if header.KDFTime != argonTime || header.KDFMemory != argonMemory || header.KDFThreads != argonThreads {
+		t.Errorf("Expected the current Argon2 parameters, got %+v", header)
+	}
+	if header.KeyID != KeyID(key) || header.KeyID == KeyID([]byte("another key")) {
+		t.Errorf("Expected key ID %s, got %s", KeyID(key), header.KeyID)
+	}
+	if NeedsUpgrade(encrypted) {
+		t.Error("A new envelope should not need an upgrade")
+	}
+
+	// A damaged header is rejected instead of being read as legacy data
+	decoded, _ := base64.StdEncoding.DecodeString(encrypted)
+	decoded[len(envelopeMagic)] = 99
+	if _, err := DecryptSecret(base64.StdEncoding.EncodeToString(decoded), key); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("Expected ErrInvalidData for an unknown version, got %v", err)
+	}
+	if _, err := DecryptSecret(base64.StdEncoding.EncodeToString(decoded[:20]), key); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("Expected ErrInvalidData for a truncated envelope, got %v", err)
+	}
+}
+
+func TestDecryptLegacySecret(t *testing.T) {
+	key := []byte("vault-key-for-testing-purposes!!")
+	legacy := encryptLegacySecret(t, []byte("old secret"), key)
+
+	if _, err := ParseEnvelopeHeader(legacy); !errors.Is(err, ErrLegacyEnvelope) {
+		t.Errorf("Expected ErrLegacyEnvelope, got %v", err)
+	}
+	if !NeedsUpgrade(legacy) {
+		t.Error("Legacy data should need an upgrade")
+	}
+
+	decrypted, err := DecryptSecret(legacy, key)
+	if err != nil {
+		t.Fatalf("Failed to decrypt legacy data: %v", err)
+	}
+	if !bytes.Equal(decrypted, []byte("old secret")) {
+		t.Errorf("Expected %q, got %q", "old secret", decrypted)
+	}
+}
+
+func TestNeedsUpgradeOldParameters(t *testing.T) {
+	key := []byte("vault-key-for-testing-purposes!!")
+	salt, _ := GenerateSalt()
+
+	// An envelope written with a lower Argon2 cost still decrypts, but needs an upgrade
+	header := newEnvelopeHeader(salt, key)
+	header.KDFTime = 1
+	derivedKey, _ := header.deriveKey(key)
+	dek, _ := GenerateDataEncryptionKey()
+	encryptedDEK, _ := encryptWithAAD(dek, derivedKey, header.additionalData())
+	encryptedSecret, _ := Encrypt([]byte("secret"), dek)
+	encrypted := base64.StdEncoding.EncodeToString(header.marshal(encryptedDEK, encryptedSecret))
+
+	if !NeedsUpgrade(encrypted) {
+		t.Error("An envelope with old parameters should need an upgrade")
+	}
+
+	decrypted, err := DecryptSecret(encrypted, key)
+	if err != nil {
+		t.Fatalf("Failed to decrypt envelope with old parameters: %v", err)
+	}
+	if string(decrypted) != "secret" {
+		t.Errorf("Expected %q, got %q", "secret", decrypted)
+	}
+
+	if NeedsUpgrade("not base64!") {
+		t.Error("Invalid data can't be upgraded")
+	}
+}
+
+func TestEnvelopeHeaderIsAuthenticated(t *testing.T) {
+	key, _ := GenerateDataEncryptionKey()
+
+	encrypted, err := EncryptWithKey([]byte("sealed"), key)
+	if err != nil {
+		t.Fatalf("EncryptWithKey failed: %v", err)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(encrypted)
+	header, encryptedDEK, encryptedData, err := parseEnvelope(decoded)
+	if err != nil {
+		t.Fatalf("Failed to parse envelope: %v", err)
+	}
+
+	// A header that still parses but was changed no longer opens the DEK
+	for _, change := range []func(*EnvelopeHeader){
+		func(h *EnvelopeHeader) { h.KeyID = KeyID([]byte("another key")) },
+		func(h *EnvelopeHeader) { h.KDFTime = 1 },
+		func(h *EnvelopeHeader) { h.Version = envelopeVersionUnbound },
+	} {
+		changed := *header
+		change(&changed)
+		tampered := base64.StdEncoding.EncodeToString(changed.marshal(encryptedDEK, encryptedData))
+
+		if _, err := DecryptSecret(tampered, key); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("Expected ErrDecryptionFailed for a changed header %+v, got %v", changed, err)
+		}
+	}
+
+	// Version 1 envelopes without an authenticated header still decrypt and get upgraded
+	unbound := newKeyEnvelopeHeader(key)
+	unbound.Version = envelopeVersionUnbound
+	dek, _ := GenerateDataEncryptionKey()
+	encryptedDEK, _ = Encrypt(dek, key)
+	encryptedData, _ = Encrypt([]byte("old"), dek)
+	old := base64.StdEncoding.EncodeToString(unbound.marshal(encryptedDEK, encryptedData))
+
+	if decrypted, err := DecryptSecret(old, key); err != nil || string(decrypted) != "old" {
+		t.Errorf("Expected %q from a version 1 envelope, got %q (%v)", "old", decrypted, err)
+	}
+	if !NeedsUpgrade(old) {
+		t.Error("A version 1 envelope should need an upgrade")
+	}
+	rewrapped, err := RewrapSecret(old, key, key)
+	if err != nil {
+		t.Fatalf("RewrapSecret failed for a version 1 envelope: %v", err)
+	}
+	if header, _ := ParseEnvelopeHeader(rewrapped); header.Version != EnvelopeVersion {
+		t.Errorf("Expected the rewrapped envelope to be version %d, got %d", EnvelopeVersion, header.Version)
+	}
+	if decrypted, err := DecryptSecret(rewrapped, key); err != nil || string(decrypted) != "old" {
+		t.Errorf("Expected %q from the rewrapped envelope, got %q (%v)", "old", decrypted, err)
+	}
+}
+
+func TestEnvelopeRejectsExpensiveParameters(t *testing.T) {
+	key := []byte("vault-key-for-testing-purposes!!")
+	salt, _ := GenerateSalt()
+
+	// A header asking for more than EncryptSecret uses is refused before deriving anything
+	for _, change := range []func(*EnvelopeHeader){
+		func(h *EnvelopeHeader) { h.KDFTime = argonTime + 1 },
+		func(h *EnvelopeHeader) { h.KDFMemory = argonMemory + 1 },
+		func(h *EnvelopeHeader) { h.KDFThreads = argonThreads + 1 },
+	} {
+		header := newEnvelopeHeader(salt, key)
+		change(header)
+		encrypted := base64.StdEncoding.EncodeToString(header.marshal([]byte("dek"), []byte("secret")))
+
+		if _, err := DecryptSecret(encrypted, key); !errors.Is(err, ErrInvalidData) {
+			t.Errorf("Expected ErrInvalidData for %+v, got %v", header, err)
+		}
+	}
+}
+
+func TestRewrapSecret(t *testing.T) {
+	oldKey, _ := GenerateDataEncryptionKey()
+	newKey, _ := GenerateDataEncryptionKey()
+
+	sealed, err := EncryptWithKey([]byte("sealed"), oldKey)
+	if err != nil {
+		t.Fatalf("EncryptWithKey failed: %v", err)
+	}
+	if _, err := EncryptWithKey([]byte("sealed"), []byte("short")); err == nil {
+		t.Error("Expected an error for a key that is not 256 bits")
+	}
+
+	passwordEncrypted, err := EncryptSecret([]byte("vault"), oldKey)
+	if err != nil {
+		t.Fatalf("EncryptSecret failed: %v", err)
+	}
+
+	for name, encrypted := range map[string]string{"key": sealed, "argon2id": passwordEncrypted} {
+		t.Run(name, func(t *testing.T) {
+			original, _ := ParseEnvelopeHeader(encrypted)
+
+			rewrapped, err := RewrapSecret(encrypted, oldKey, newKey)
+			if err != nil {
+				t.Fatalf("RewrapSecret failed: %v", err)
+			}
+
+			header, err := ParseEnvelopeHeader(rewrapped)
+			if err != nil {
+				t.Fatalf("Failed to parse rewrapped envelope: %v", err)
+			}
+			if header.KeyID != KeyID(newKey) || header.KDF != original.KDF {
+				t.Errorf("Expected key ID %s with KDF %d, got %+v", KeyID(newKey), original.KDF, header)
+			}
+
+			// The encrypted secret after the DEK is kept as it is
+			before, _ := base64.StdEncoding.DecodeString(encrypted)
+			after, _ := base64.StdEncoding.DecodeString(rewrapped)
+			_, _, beforeData, _ := parseEnvelope(before)
+			_, _, afterData, _ := parseEnvelope(after)
+			if !bytes.Equal(beforeData, afterData) {
+				t.Error("Expected the encrypted secret to stay the same")
+			}
+
+			plaintext, err := DecryptSecret(encrypted, oldKey)
+			if err != nil {
+				t.Fatalf("Failed to decrypt original: %v", err)
+			}
+			decrypted, err := DecryptSecret(rewrapped, newKey)
+			if err != nil {
+				t.Fatalf("Failed to decrypt rewrapped envelope: %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("Expected %q, got %q", plaintext, decrypted)
+			}
+			if _, err := DecryptSecret(rewrapped, oldKey); err == nil {
+				t.Error("Expected the old key to no longer decrypt the rewrapped envelope")
+			}
+		})
+	}
+
+	if _, err := RewrapSecret(sealed, newKey, oldKey); err == nil {
+		t.Error("Expected an error when rewrapping with the wrong old key")
+	}
+	if _, err := RewrapSecret(encryptLegacySecret(t, []byte("old"), oldKey), oldKey, newKey); !errors.Is(err, ErrLegacyEnvelope) {
+		t.Errorf("Expected ErrLegacyEnvelope for legacy data, got %v", err)
+	}
+}
+
+func TestEncryptWithPassphrase(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+
+	encrypted, err := EncryptWithPassphrase([]byte("paper"), passphrase)
+	if err != nil {
+		t.Fatalf("EncryptWithPassphrase failed: %v", err)
+	}
+
+	header, err := ParseEnvelopeHeader(encrypted)
+	if err != nil {
+		t.Fatalf("Failed to parse envelope: %v", err)
+	}
+	if header.KeyID != "" || header.KDF != KDFArgon2id {
+		t.Errorf("Expected an Argon2id envelope without key ID, got %+v", header)
+	}
+
+	decrypted, err := DecryptSecret(encrypted, passphrase)
+	if err != nil || string(decrypted) != "paper" {
+		t.Errorf("Expected %q, got %q (%v)", "paper", decrypted, err)
+	}
+	if _, err := DecryptSecret(encrypted, []byte("wrong horse battery staple")); err == nil {
+		t.Error("Expected an error for a wrong passphrase")
+	}
+}
@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+const (
+	// PublicKeyTypeAge is an age X25519 recipient (age1...)
+	PublicKeyTypeAge = "age"
+
+	// PublicKeyTypeOpenPGP is an ASCII armored OpenPGP public key
+	PublicKeyTypeOpenPGP = "openpgp"
+
+	openPGPPublicKeyHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+)
+
+// ErrInvalidPublicKey is returned when a public key can't be used to encrypt to
+var ErrInvalidPublicKey = errors.New("invalid public key")
+
+// PublicKey is a public key a recipient registered. Their copies of secrets are
+// encrypted to it, so that only the recipient can read them.
+type PublicKey struct {
+	Type        string // PublicKeyTypeAge or PublicKeyTypeOpenPGP
+	Fingerprint string // The age recipient itself, or the fingerprint of the OpenPGP key
+
+	age    *age.X25519Recipient
+	entity *openpgp.Entity
+}
+
+// ParsePublicKey parses an age recipient or an ASCII armored OpenPGP public key
+func ParsePublicKey(text string) (*PublicKey, error) {
+	text = strings.TrimSpace(text)
+
+	switch {
+	case strings.HasPrefix(text, "age1"):
+		key, err := age.ParseX25519Recipient(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		return &PublicKey{Type: PublicKeyTypeAge, Fingerprint: text, age: key}, nil
+
+	case strings.HasPrefix(text, openPGPPublicKeyHeader):
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(text))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		if len(entities) != 1 {
+			return nil, fmt.Errorf("%w: expected exactly one OpenPGP key, got %d", ErrInvalidPublicKey, len(entities))
+		}
+
+		key := &PublicKey{
+			Type:        PublicKeyTypeOpenPGP,
+			Fingerprint: fmt.Sprintf("%X", entities[0].PrimaryKey.Fingerprint),
+			entity:      entities[0],
+		}
+
+		// Keys without a valid encryption subkey can't be encrypted to, find out now rather than when a secret is saved
+		if _, err := key.Encrypt(nil); err != nil {
+			return nil, fmt.Errorf("%w: %v (the key needs an encryption subkey that hasn't expired)", ErrInvalidPublicKey, err)
+		}
+
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("%w: expected an age recipient (age1...) or an ASCII armored OpenPGP public key", ErrInvalidPublicKey)
+	}
+}
+
+// Encrypt encrypts data to the key. The result is ASCII armored and can be
+// decrypted with `age -d -i key.txt` or `gpg --decrypt` respectively.
+func (k *PublicKey) Encrypt(data []byte) ([]byte, error) {
+	if k.Type == PublicKeyTypeAge {
+		return ageEncrypt(data, k.age)
+	}
+
+	var buf bytes.Buffer
+	armored, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create armor: %w", err)
+	}
+
+	plaintext, err := openpgp.Encrypt(armored, []*openpgp.Entity{k.entity}, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+
+	if _, err := io.Copy(plaintext, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := plaintext.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := armored.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close armor: %w", err)
+	}
+
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// EncryptToPublicKey parses a public key and encrypts data to it
+func EncryptToPublicKey(data []byte, publicKey string) ([]byte, error) {
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return key.Encrypt(data)
+}
+
+// DecryptWithAgeIdentity decrypts an age file encrypted to an X25519 recipient
+// with the matching AGE-SECRET-KEY-1... identity
+func DecryptWithAgeIdentity(file []byte, identity string) ([]byte, error) {
+	key, err := parseAgeIdentity(identity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity: %w", err)
+	}
+	return ageDecrypt(file, key)
+}
@@ -0,0 +1,294 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	agetest "c2sp.org/CCTV/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// ageChunkSize is the payload chunk size of the age format
+const ageChunkSize = 64 * 1024
+
+func TestAgeEncryptDecrypt(t *testing.T) {
+	identity, recipient, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	if !strings.HasPrefix(identity, "AGE-SECRET-KEY-1") || !strings.HasPrefix(recipient, "age1") {
+		t.Fatalf("Unexpected key pair %s / %s", identity, recipient)
+	}
+
+	key, err := ParsePublicKey("  " + recipient + "\n")
+	if err != nil {
+		t.Fatalf("Failed to parse recipient: %v", err)
+	}
+	if key.Type != PublicKeyTypeAge || key.Fingerprint != recipient {
+		t.Errorf("Unexpected public key %s %s", key.Type, key.Fingerprint)
+	}
+
+	// Empty, single chunk, exactly one chunk and several chunks
+	for _, size := range []int{0, 11, ageChunkSize, 2*ageChunkSize + 1} {
+		data := bytes.Repeat([]byte{'x'}, size)
+
+		encrypted, err := key.Encrypt(data)
+		if err != nil {
+			t.Fatalf("Failed to encrypt %d bytes: %v", size, err)
+		}
+		if !bytes.HasPrefix(encrypted, []byte(agearmor.Header+"\n")) || !bytes.HasSuffix(encrypted, []byte(agearmor.Footer+"\n")) {
+			t.Fatalf("Expected armored output, got %q", encrypted[:40])
+		}
+
+		decrypted, err := DecryptWithAgeIdentity(encrypted, identity)
+		if err != nil {
+			t.Fatalf("Failed to decrypt %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("Decrypted data doesn't match for %d bytes", size)
+		}
+	}
+
+	encrypted, err := key.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	// Another identity can't decrypt
+	otherIdentity, _, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	if _, err := DecryptWithAgeIdentity(encrypted, otherIdentity); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for another identity, got %v", err)
+	}
+
+	// Tampering with the payload is detected
+	file, err := io.ReadAll(agearmor.NewReader(bytes.NewReader(encrypted)))
+	if err != nil {
+		t.Fatalf("Failed to dearmor: %v", err)
+	}
+	file[len(file)-1] ^= 1
+	if _, err := DecryptWithAgeIdentity(file, identity); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a tampered file, got %v", err)
+	}
+}
+
+// TestAgeVectors decrypts the age test vectors (https://c2sp.org/CCTV/age) that
+// use a single X25519 identity, armored or not
+func TestAgeVectors(t *testing.T) {
+	names, err := fs.ReadDir(agetest.Vectors, ".")
+	if err != nil {
+		t.Fatalf("Failed to list test vectors: %v", err)
+	}
+
+	tested := 0
+	for _, name := range names {
+		contents, err := fs.ReadFile(agetest.Vectors, name.Name())
+		if err != nil {
+			t.Fatalf("Failed to read test vector %s: %v", name.Name(), err)
+		}
+
+		header, file, ok := bytes.Cut(contents, []byte("\n\n"))
+		if !ok {
+			t.Fatalf("Test vector %s has no file", name.Name())
+		}
+
+		var expect, payload string
+		var identities []string
+		passphrase := false
+		for _, line := range strings.Split(string(header), "\n") {
+			key, value, _ := strings.Cut(line, ": ")
+			switch key {
+			case "expect":
+				expect = value
+			case "payload":
+				payload = value
+			case "identity":
+				identities = append(identities, value)
+			case "passphrase":
+				passphrase = true
+			}
+		}
+		if passphrase || len(identities) != 1 {
+			continue
+		}
+		tested++
+
+		decrypted, err := DecryptWithAgeIdentity(file, identities[0])
+		if expect != "success" {
+			if err == nil {
+				t.Errorf("Expected %s for %s, got no error", expect, name.Name())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to decrypt %s: %v", name.Name(), err)
+			continue
+		}
+		if hash := sha256.Sum256(decrypted); hex.EncodeToString(hash[:]) != payload {
+			t.Errorf("Decrypted payload of %s doesn't match", name.Name())
+		}
+	}
+
+	if tested == 0 {
+		t.Fatal("Expected X25519 test vectors")
+	}
+}
+
+func TestAgeStream(t *testing.T) {
+	identity, recipient, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+
+	// Empty, single chunk, exactly one chunk and several chunks written in odd pieces
+	for _, size := range []int{0, 11, ageChunkSize, 2*ageChunkSize + 1} {
+		data := bytes.Repeat([]byte{'x'}, size)
+
+		var file bytes.Buffer
+		w, err := NewAgeWriter(&file, recipient)
+		if err != nil {
+			t.Fatalf("Failed to create writer: %v", err)
+		}
+		for rest := data; len(rest) > 0; {
+			n := 1000
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatalf("Failed to write: %v", err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Failed to close writer: %v", err)
+		}
+
+		// The streamed file is a regular age file
+		decrypted, err := DecryptWithAgeIdentity(file.Bytes(), identity)
+		if err != nil {
+			t.Fatalf("Failed to decrypt %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("Decrypted data doesn't match for %d bytes", size)
+		}
+
+		r, err := NewAgeReader(bytes.NewReader(file.Bytes()), identity)
+		if err != nil {
+			t.Fatalf("Failed to create reader for %d bytes: %v", size, err)
+		}
+		streamed, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to read %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(streamed, data) {
+			t.Errorf("Streamed data doesn't match for %d bytes", size)
+		}
+	}
+
+	var file bytes.Buffer
+	w, _ := NewAgeWriter(&file, recipient)
+	w.Write(bytes.Repeat([]byte{'x'}, 2*ageChunkSize))
+	w.Close()
+
+	otherIdentity, _, _ := GenerateAgeIdentity()
+	if _, err := NewAgeReader(bytes.NewReader(file.Bytes()), otherIdentity); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for another identity, got %v", err)
+	}
+
+	// Dropping the last chunk is detected even though the rest authenticates
+	truncated := file.Bytes()[:file.Len()-16-ageChunkSize]
+	r, err := NewAgeReader(bytes.NewReader(truncated), identity)
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a truncated file, got %v", err)
+	}
+
+	if _, err := NewAgeWriter(&file, "not a recipient"); err == nil {
+		t.Error("Expected an error for an invalid recipient")
+	}
+}
+
+func TestOpenPGPEncrypt(t *testing.T) {
+	for name, config := range map[string]*packet.Config{
+		"rsa":     {Algorithm: packet.PubKeyAlgoRSA, RSABits: 2048},
+		"ed25519": {Algorithm: packet.PubKeyAlgoEdDSA},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testOpenPGPEncrypt(t, config)
+		})
+	}
+}
+
+func testOpenPGPEncrypt(t *testing.T, config *packet.Config) {
+	entity, err := openpgp.NewEntity("Recipient", "", "recipient@example.com", config)
+	if err != nil {
+		t.Fatalf("Failed to generate OpenPGP key: %v", err)
+	}
+
+	var publicKey bytes.Buffer
+	w, err := armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("Failed to create armor: %v", err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatalf("Failed to serialize public key: %v", err)
+	}
+	w.Close()
+
+	key, err := ParsePublicKey(publicKey.String())
+	if err != nil {
+		t.Fatalf("Failed to parse OpenPGP key: %v", err)
+	}
+	if key.Type != PublicKeyTypeOpenPGP || len(key.Fingerprint) != 40 {
+		t.Errorf("Unexpected public key %s %s", key.Type, key.Fingerprint)
+	}
+
+	encrypted, err := EncryptToPublicKey([]byte("secret"), publicKey.String())
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	block, err := armor.Decode(bytes.NewReader(encrypted))
+	if err != nil || block.Type != "PGP MESSAGE" {
+		t.Fatalf("Expected an armored PGP message, got %v", err)
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+
+	var decrypted bytes.Buffer
+	if _, err := decrypted.ReadFrom(md.UnverifiedBody); err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if decrypted.String() != "secret" {
+		t.Errorf("Expected %q, got %q", "secret", decrypted.String())
+	}
+}
+
+func TestParsePublicKeyInvalid(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl",
+		"age1qqqqqqqqqqqqqqqq",
+		"-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nnot a key\n-----END PGP PUBLIC KEY BLOCK-----",
+	} {
+		if _, err := ParsePublicKey(key); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("Expected ErrInvalidPublicKey for %q, got %v", key, err)
+		}
+	}
+}
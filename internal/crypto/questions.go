@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Answer locks protect an age identity with answers to personal questions.
+// The identity is split with Shamir's Secret Sharing into one share per
+// question, and every share is encrypted with a key derived from its answer
+// with Argon2id. Any threshold of correct answers rebuilds the identity.
+
+// ErrNotEnoughAnswers is returned when fewer answers than the threshold were correct
+var ErrNotEnoughAnswers = errors.New("not enough correct answers")
+
+// NormalizeAnswer makes answers independent of case and spacing, so that
+// "Paris" and " paris " unlock the same share
+func NormalizeAnswer(answer string) string {
+	return strings.ToLower(strings.Join(strings.Fields(answer), " "))
+}
+
+// NewAnswerLock generates an age identity and locks it with the answers.
+// It returns the age recipient to encrypt to and one locked share per answer.
+func NewAnswerLock(answers []string, threshold int) (string, []string, error) {
+	for _, answer := range answers {
+		if NormalizeAnswer(answer) == "" {
+			return "", nil, errors.New("answers must not be empty")
+		}
+	}
+
+	identity, recipient, err := GenerateAgeIdentity()
+	if err != nil {
+		return "", nil, err
+	}
+
+	shares, err := SplitKey([]byte(identity), len(answers), threshold)
+	if err != nil {
+		return "", nil, err
+	}
+
+	locked := make([]string, 0, len(shares))
+	for i, share := range shares {
+		salt, err := GenerateSalt()
+		if err != nil {
+			return "", nil, err
+		}
+
+		key, err := DeriveKey([]byte(NormalizeAnswer(answers[i])), salt)
+		if err != nil {
+			return "", nil, err
+		}
+
+		encrypted, err := Encrypt([]byte(share), key)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to lock share: %w", err)
+		}
+
+		locked = append(locked, base64.StdEncoding.EncodeToString(append(salt, encrypted...)))
+	}
+
+	return recipient, locked, nil
+}
+
+// OpenAnswerLock tries every answer on the share at the same position and
+// rebuilds the identity once threshold answers are correct. Empty answers are
+// skipped. It returns the identity and the number of correct answers.
+func OpenAnswerLock(lockedShares, answers []string, threshold int) (string, int, error) {
+	var shares []string
+	for i, locked := range lockedShares {
+		if i >= len(answers) || NormalizeAnswer(answers[i]) == "" {
+			continue
+		}
+
+		data, err := base64.StdEncoding.DecodeString(locked)
+		if err != nil || len(data) < saltSize {
+			return "", 0, ErrInvalidData
+		}
+
+		key, err := DeriveKey([]byte(NormalizeAnswer(answers[i])), data[:saltSize])
+		if err != nil {
+			return "", 0, err
+		}
+
+		// A wrong answer fails the GCM authentication
+		share, err := Decrypt(data[saltSize:], key)
+		if err != nil {
+			continue
+		}
+		shares = append(shares, string(share))
+	}
+
+	if len(shares) < threshold {
+		return "", len(shares), ErrNotEnoughAnswers
+	}
+
+	identity, err := CombineShares(shares, threshold)
+	if err != nil {
+		return "", len(shares), err
+	}
+	if _, err := parseAgeIdentity(string(identity)); err != nil {
+		return "", len(shares), fmt.Errorf("failed to rebuild identity: %w", err)
+	}
+
+	return string(identity), len(shares), nil
+}
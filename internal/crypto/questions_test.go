@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAnswerLock(t *testing.T) {
+	answers := []string{"Paris", "Rex", "blue"}
+
+	recipient, locked, err := NewAnswerLock(answers, 2)
+	if err != nil {
+		t.Fatalf("Failed to create answer lock: %v", err)
+	}
+	if len(locked) != len(answers) {
+		t.Fatalf("Expected %d locked shares, got %d", len(answers), len(locked))
+	}
+
+	encrypted, err := EncryptToPublicKey([]byte("secret"), recipient)
+	if err != nil {
+		t.Fatalf("Failed to encrypt to the lock: %v", err)
+	}
+
+	// Any two answers open the lock, regardless of case and spacing
+	identity, correct, err := OpenAnswerLock(locked, []string{"  paris ", "", "BLUE"}, 2)
+	if err != nil {
+		t.Fatalf("Failed to open answer lock: %v", err)
+	}
+	if correct != 2 {
+		t.Errorf("Expected 2 correct answers, got %d", correct)
+	}
+
+	decrypted, err := DecryptWithAgeIdentity(encrypted, identity)
+	if err != nil {
+		t.Fatalf("Failed to decrypt with the rebuilt identity: %v", err)
+	}
+	if string(decrypted) != "secret" {
+		t.Errorf("Expected %q, got %q", "secret", decrypted)
+	}
+
+	// One correct and one wrong answer are not enough
+	if _, correct, err := OpenAnswerLock(locked, []string{"Paris", "Max", ""}, 2); !errors.Is(err, ErrNotEnoughAnswers) || correct != 1 {
+		t.Errorf("Expected ErrNotEnoughAnswers with 1 correct answer, got %v with %d", err, correct)
+	}
+
+	// Answers at the wrong position don't count
+	if _, _, err := OpenAnswerLock(locked, []string{"Rex", "Paris", ""}, 2); !errors.Is(err, ErrNotEnoughAnswers) {
+		t.Errorf("Expected ErrNotEnoughAnswers for swapped answers, got %v", err)
+	}
+}
+
+func TestNewAnswerLockInvalid(t *testing.T) {
+	if _, _, err := NewAnswerLock([]string{"Paris", " "}, 2); err == nil {
+		t.Error("Expected an error for an empty answer")
+	}
+	if _, _, err := NewAnswerLock([]string{"Paris", "Rex"}, 3); err == nil {
+		t.Error("Expected an error for a threshold above the number of answers")
+	}
+}
+
+func TestNormalizeAnswer(t *testing.T) {
+	for input, expected := range map[string]string{
+		"Paris":            "paris",
+		"  New   York\t":   "new york",
+		"":                 "",
+		"Ünïcode Answer  ": "ünïcode answer",
+	} {
+		if got := NormalizeAnswer(input); got != expected {
+			t.Errorf("NormalizeAnswer(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/corvus-ch/shamir"
+)
+
+var (
+	// ErrInvalidShare is returned when a share cannot be parsed
+	ErrInvalidShare = errors.New("invalid share")
+
+	// ErrNotEnoughShares is returned when fewer shares than the threshold were provided
+	ErrNotEnoughShares = errors.New("not enough shares")
+)
+
+// SplitKey splits a key into n shares so that any k of them can rebuild it
+// Each share is encoded as "<index>-<base64url data>" so it can be copied by hand
+func SplitKey(key []byte, n, k int) ([]string, error) {
+	if k < 2 || n < k {
+		return nil, fmt.Errorf("invalid quorum: need 2 <= k <= n, got k=%d n=%d", k, n)
+	}
+
+	parts, err := shamir.Split(key, n, k)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split key: %w", err)
+	}
+
+	// Sort by index so shares are handed out in a stable order
+	indexes := make([]int, 0, len(parts))
+	for x := range parts {
+		indexes = append(indexes, int(x))
+	}
+	sort.Ints(indexes)
+
+	shares := make([]string, 0, len(parts))
+	for _, x := range indexes {
+		shares = append(shares, fmt.Sprintf("%d-%s", x, base64.RawURLEncoding.EncodeToString(parts[byte(x)])))
+	}
+
+	return shares, nil
+}
+
+// ParseShare decodes a share created by SplitKey into its index and data
+func ParseShare(share string) (byte, []byte, error) {
+	indexStr, data, ok := strings.Cut(strings.TrimSpace(share), "-")
+	if !ok {
+		return 0, nil, ErrInvalidShare
+	}
+
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index < 1 || index > 255 {
+		return 0, nil, ErrInvalidShare
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil || len(decoded) == 0 {
+		return 0, nil, ErrInvalidShare
+	}
+
+	return byte(index), decoded, nil
+}
+
+// CombineShares rebuilds a key from at least k shares created by SplitKey
+// Duplicate shares are ignored; fewer than k distinct shares yield a wrong key,
+// so callers must authenticate the result (e.g. by decrypting with it)
+func CombineShares(shares []string, k int) ([]byte, error) {
+	parts := make(map[byte][]byte, len(shares))
+	for _, share := range shares {
+		index, data, err := ParseShare(share)
+		if err != nil {
+			return nil, err
+		}
+		parts[index] = data
+	}
+
+	if len(parts) < k || len(parts) < 2 {
+		return nil, ErrNotEnoughShares
+	}
+
+	key, err := shamir.Combine(parts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to combine shares: %w", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,92 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombineKey(t *testing.T) {
+	key, err := GenerateDataEncryptionKey()
+	if err != nil {
+		t.Fatalf("GenerateDataEncryptionKey failed: %v", err)
+	}
+
+	shares, err := SplitKey(key, 3, 2)
+	if err != nil {
+		t.Fatalf("SplitKey failed: %v", err)
+	}
+	if len(shares) != 3 {
+		t.Fatalf("Expected 3 shares, got %d", len(shares))
+	}
+
+	// Any 2 of the 3 shares should rebuild the key
+	pairs := [][]string{
+		{shares[0], shares[1]},
+		{shares[0], shares[2]},
+		{shares[1], shares[2]},
+	}
+	for _, pair := range pairs {
+		combined, err := CombineShares(pair, 2)
+		if err != nil {
+			t.Fatalf("CombineShares failed: %v", err)
+		}
+		if !bytes.Equal(combined, key) {
+			t.Errorf("Combined key doesn't match original")
+		}
+	}
+
+	// A single share is not enough
+	if _, err := CombineShares(shares[:1], 2); err != ErrNotEnoughShares {
+		t.Errorf("Expected ErrNotEnoughShares, got %v", err)
+	}
+
+	// The same share submitted twice is still only one share
+	if _, err := CombineShares([]string{shares[0], shares[0]}, 2); err != ErrNotEnoughShares {
+		t.Errorf("Expected ErrNotEnoughShares for duplicate shares, got %v", err)
+	}
+}
+
+func TestSplitKeyInvalidQuorum(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	if _, err := SplitKey(key, 3, 1); err == nil {
+		t.Error("Expected error for threshold below 2")
+	}
+	if _, err := SplitKey(key, 2, 3); err == nil {
+		t.Error("Expected error for threshold above number of shares")
+	}
+}
+
+func TestParseShare(t *testing.T) {
+	tests := []struct {
+		name    string
+		share   string
+		wantErr bool
+	}{
+		{"Valid", "7-AQID", false},
+		{"Surrounding whitespace", "  7-AQID\n", false},
+		{"Missing separator", "7AQID", true},
+		{"Invalid index", "x-AQID", true},
+		{"Index out of range", "256-AQID", true},
+		{"Invalid data", "7-!!!", true},
+		{"Empty data", "7-", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			index, data, err := ParseShare(tc.share)
+			if tc.wantErr {
+				if err != ErrInvalidShare {
+					t.Errorf("Expected ErrInvalidShare, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseShare failed: %v", err)
+			}
+			if index != 7 || !bytes.Equal(data, []byte{1, 2, 3}) {
+				t.Errorf("Unexpected result: index %d data %v", index, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Timelock encryption makes data unreadable until a round of a randomness
+// beacon has been published. The key for a round only exists once the beacon
+// publishes it, so not even the server that stores the data can open it early.
+
+// ErrTooEarly is returned when timelocked data is opened before its round was published
+var ErrTooEarly = errors.New("too early to decrypt, the round has not been published yet")
+
+// Timelock encrypts data to a future round of a beacon
+type Timelock interface {
+	// RoundAt returns the first round that is published at or after t
+	RoundAt(t time.Time) uint64
+
+	// Encrypt encrypts data so it can only be decrypted once round is published
+	Encrypt(data []byte, round uint64) ([]byte, error)
+
+	// Decrypt decrypts timelocked data, it returns ErrTooEarly before its round
+	Decrypt(data []byte) ([]byte, error)
+}
+
+const (
+	// localTimelockPeriod is the time between two rounds of the local beacon
+	localTimelockPeriod = time.Minute
+
+	// localTimelockMinSeed is the shortest seed the local beacon accepts
+	localTimelockMinSeed = 32
+)
+
+// LocalTimelock is a deterministic beacon for tests and air-gapped
+// deployments. The key of a round is derived from a seed, so whoever holds the
+// seed can open every round at once. It only holds data back until its round
+// while the seed is kept away from the data, e.g. on a separate machine.
+type LocalTimelock struct {
+	seed []byte
+	now  func() time.Time
+}
+
+// NewLocalTimelock creates a local beacon from a seed of at least 32 bytes.
+// Rounds are a minute long and counted from the Unix epoch. now tells the
+// current time, nil uses the system clock.
+func NewLocalTimelock(seed []byte, now func() time.Time) (*LocalTimelock, error) {
+	if len(seed) < localTimelockMinSeed {
+		return nil, fmt.Errorf("timelock seed must be at least %d bytes", localTimelockMinSeed)
+	}
+	if now == nil {
+		now = time.Now
+	}
+
+	return &LocalTimelock{
+		seed: seed,
+		now:  now,
+	}, nil
+}
+
+// RoundAt returns the first round that is published at or after t
+func (l *LocalTimelock) RoundAt(t time.Time) uint64 {
+	elapsed := t.Sub(time.Unix(0, 0))
+	if elapsed <= 0 {
+		return 0
+	}
+
+	round := uint64(elapsed / localTimelockPeriod)
+	if elapsed%localTimelockPeriod != 0 {
+		round++
+	}
+	return round
+}
+
+// publishedAt returns when a round is published
+func (l *LocalTimelock) publishedAt(round uint64) time.Time {
+	return time.Unix(0, 0).Add(time.Duration(round) * localTimelockPeriod)
+}
+
+// roundKey derives the key of a round from the seed
+func (l *LocalTimelock) roundKey(round uint64) []byte {
+	mac := hmac.New(sha256.New, l.seed)
+	mac.Write([]byte("deadmanswitch timelock round"))
+	_ = binary.Write(mac, binary.BigEndian, round)
+	return mac.Sum(nil)
+}
+
+// Encrypt encrypts data with the key of a round. The round is stored in front
+// of the ciphertext.
+func (l *LocalTimelock) Encrypt(data []byte, round uint64) ([]byte, error) {
+	encrypted, err := Encrypt(data, l.roundKey(round))
+	if err != nil {
+		return nil, fmt.Errorf("failed to timelock data: %w", err)
+	}
+
+	return append(binary.BigEndian.AppendUint64(nil, round), encrypted...), nil
+}
+
+// Decrypt decrypts data once its round is published
+func (l *LocalTimelock) Decrypt(data []byte) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalidData
+	}
+
+	round := binary.BigEndian.Uint64(data[:8])
+	if l.now().Before(l.publishedAt(round)) {
+		return nil, ErrTooEarly
+	}
+
+	return Decrypt(data[8:], l.roundKey(round))
+}
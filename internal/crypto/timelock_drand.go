@@ -0,0 +1,300 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/circl/ecc/bls12381"
+)
+
+// The drand beacon uses the identity based encryption of tlock: the signature
+// a drand network publishes for a round is the private key of that round's
+// identity. Data encrypted to a round can be opened by anyone once the
+// network has published it and by nobody before, the server included. Only
+// unchained chains that sign on G1 are supported, like the quicknet chain of
+// the League of Entropy. The format of the ciphertext is this server's own,
+// it can't be opened with the tle command line tool.
+
+const (
+	// drandScheme is the signature scheme of the chains the beacon works with
+	drandScheme = "bls-unchained-g1-rfc9380"
+
+	// drandDST is the domain separation tag the scheme hashes rounds to G1 with
+	drandDST = "BLS_SIG_BLS12381G1_XMD:SHA-256_SSWU_RO_NUL_"
+
+	// drandKeySize is the size of the data key that is encrypted to a round
+	drandKeySize = 32
+
+	// drandHeaderSize is the size of the round and the encrypted data key
+	drandHeaderSize = 8 + bls12381.G2SizeCompressed + 2*drandKeySize
+)
+
+// drandInfo is the part of a chain's info the beacon needs
+type drandInfo struct {
+	PublicKey   string `json:"public_key"`
+	Period      int64  `json:"period"`
+	GenesisTime int64  `json:"genesis_time"`
+	Hash        string `json:"hash"`
+	SchemeID    string `json:"schemeID"`
+}
+
+// drandBeacon is a round published by a drand network
+type drandBeacon struct {
+	Round     uint64 `json:"round"`
+	Signature string `json:"signature"`
+}
+
+// DrandTimelock encrypts data to rounds of a drand network
+type DrandTimelock struct {
+	url       string
+	chainHash string
+	publicKey *bls12381.G2
+	genesis   time.Time
+	period    time.Duration
+	client    *http.Client
+	now       func() time.Time
+}
+
+// NewDrandTimelock connects to the chain with the given hash on the drand
+// HTTP endpoint at url and reads its public key and period. now tells the
+// current time, nil uses the system clock.
+func NewDrandTimelock(url, chainHash string, now func() time.Time) (*DrandTimelock, error) {
+	if now == nil {
+		now = time.Now
+	}
+
+	d := &DrandTimelock{
+		url:       strings.TrimRight(url, "/"),
+		chainHash: chainHash,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		now: now,
+	}
+
+	var info drandInfo
+	if err := d.fetch("info", &info); err != nil {
+		return nil, fmt.Errorf("failed to read drand chain %s: %w", chainHash, err)
+	}
+	if info.Hash != chainHash {
+		return nil, fmt.Errorf("drand returned chain %s instead of %s", info.Hash, chainHash)
+	}
+	if info.SchemeID != drandScheme {
+		return nil, fmt.Errorf("drand chain %s uses scheme %s, only %s is supported", chainHash, info.SchemeID, drandScheme)
+	}
+	if info.Period <= 0 {
+		return nil, fmt.Errorf("drand chain %s has an invalid period", chainHash)
+	}
+
+	publicKey, err := hex.DecodeString(info.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key of drand chain %s: %w", chainHash, err)
+	}
+	d.publicKey = new(bls12381.G2)
+	if err := d.publicKey.SetBytes(publicKey); err != nil || d.publicKey.IsIdentity() {
+		return nil, fmt.Errorf("invalid public key of drand chain %s", chainHash)
+	}
+
+	d.genesis = time.Unix(info.GenesisTime, 0)
+	d.period = time.Duration(info.Period) * time.Second
+	return d, nil
+}
+
+// fetch decodes the JSON document at path of the chain
+func (d *DrandTimelock) fetch(path string, v interface{}) error {
+	resp, err := d.client.Get(d.url + "/" + d.chainHash + "/" + path)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooEarly:
+		return ErrTooEarly
+	default:
+		return fmt.Errorf("drand returned status code %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// RoundAt returns the first round that is published at or after t
+func (d *DrandTimelock) RoundAt(t time.Time) uint64 {
+	elapsed := t.Sub(d.genesis)
+	if elapsed <= 0 {
+		return 1
+	}
+
+	round := uint64(elapsed/d.period) + 1
+	if elapsed%d.period != 0 {
+		round++
+	}
+	return round
+}
+
+// publishedAt returns when a round is published
+func (d *DrandTimelock) publishedAt(round uint64) time.Time {
+	if round == 0 {
+		return d.genesis
+	}
+	return d.genesis.Add(time.Duration(round-1) * d.period)
+}
+
+// drandIdentity returns the point of a round on G1, its signature is the
+// point multiplied with the private key of the network
+func drandIdentity(round uint64) *bls12381.G1 {
+	message := sha256.Sum256(binary.BigEndian.AppendUint64(nil, round))
+
+	identity := new(bls12381.G1)
+	identity.Hash(message[:], []byte(drandDST))
+	return identity
+}
+
+// drandMask hashes the input into a mask for a data key
+func drandMask(domain string, input []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(domain))
+	hash.Write(input)
+	return hash.Sum(nil)
+}
+
+// drandScalar derives the randomness of the encryption from sigma and the
+// data key, so decryption can check that the ciphertext wasn't altered
+func drandScalar(sigma, key []byte) *bls12381.Scalar {
+	hash := sha512.New()
+	hash.Write([]byte("IBE-H3"))
+	hash.Write(sigma)
+	hash.Write(key)
+
+	scalar := new(bls12381.Scalar)
+	scalar.SetBytes(hash.Sum(nil))
+	return scalar
+}
+
+// xorBytes returns a XOR b for slices of the same length
+func xorBytes(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// Encrypt encrypts data with a fresh key and encrypts the key to a round. The
+// round and the encrypted key are stored in front of the ciphertext.
+func (d *DrandTimelock) Encrypt(data []byte, round uint64) ([]byte, error) {
+	key := make([]byte, drandKeySize)
+	sigma := make([]byte, drandKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	if _, err := rand.Read(sigma); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	r := drandScalar(sigma, key)
+	u := new(bls12381.G2)
+	u.ScalarMult(r, bls12381.G2Generator())
+
+	roundKey := new(bls12381.Gt)
+	roundKey.Exp(bls12381.Pair(drandIdentity(round), d.publicKey), r)
+	roundKeyBytes, err := roundKey.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to timelock data: %w", err)
+	}
+
+	encrypted, err := Encrypt(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to timelock data: %w", err)
+	}
+
+	out := binary.BigEndian.AppendUint64(nil, round)
+	out = append(out, u.BytesCompressed()...)
+	out = append(out, xorBytes(sigma, drandMask("IBE-H2", roundKeyBytes))...)
+	out = append(out, xorBytes(key, drandMask("IBE-H4", sigma))...)
+	return append(out, encrypted...), nil
+}
+
+// Decrypt fetches the signature of the data's round from drand and decrypts
+// the data with it
+func (d *DrandTimelock) Decrypt(data []byte) ([]byte, error) {
+	if len(data) < drandHeaderSize {
+		return nil, ErrInvalidData
+	}
+
+	round := binary.BigEndian.Uint64(data[:8])
+	if d.now().Before(d.publishedAt(round)) {
+		return nil, ErrTooEarly
+	}
+
+	u := new(bls12381.G2)
+	if err := u.SetBytes(data[8 : 8+bls12381.G2SizeCompressed]); err != nil || u.IsIdentity() {
+		return nil, ErrInvalidData
+	}
+
+	signature, err := d.signature(round)
+	if err != nil {
+		return nil, err
+	}
+
+	roundKeyBytes, err := bls12381.Pair(signature, u).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt timelocked data: %w", err)
+	}
+
+	masked := data[8+bls12381.G2SizeCompressed : drandHeaderSize]
+	sigma := xorBytes(masked[:drandKeySize], drandMask("IBE-H2", roundKeyBytes))
+	key := xorBytes(masked[drandKeySize:], drandMask("IBE-H4", sigma))
+
+	// Only the signature of the right round recovers the randomness the key
+	// was encrypted with
+	expected := new(bls12381.G2)
+	expected.ScalarMult(drandScalar(sigma, key), bls12381.G2Generator())
+	if !expected.IsEqual(u) {
+		return nil, ErrDecryptionFailed
+	}
+
+	return Decrypt(data[drandHeaderSize:], key)
+}
+
+// signature fetches the signature of a round and checks it against the
+// public key of the chain
+func (d *DrandTimelock) signature(round uint64) (*bls12381.G1, error) {
+	var beacon drandBeacon
+	if err := d.fetch(fmt.Sprintf("public/%d", round), &beacon); err != nil {
+		if errors.Is(err, ErrTooEarly) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch drand round %d: %w", round, err)
+	}
+	if beacon.Round != round {
+		return nil, fmt.Errorf("drand returned round %d instead of %d", beacon.Round, round)
+	}
+
+	signatureBytes, err := hex.DecodeString(beacon.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature of drand round %d: %w", round, err)
+	}
+	signature := new(bls12381.G1)
+	if err := signature.SetBytes(signatureBytes); err != nil {
+		return nil, fmt.Errorf("invalid signature of drand round %d: %w", round, err)
+	}
+
+	if !bls12381.Pair(signature, bls12381.G2Generator()).IsEqual(bls12381.Pair(drandIdentity(round), d.publicKey)) {
+		return nil, fmt.Errorf("invalid signature of drand round %d", round)
+	}
+	return signature, nil
+}
@@ -0,0 +1,195 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/circl/ecc/bls12381"
+)
+
+func TestLocalTimelock(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, 32)
+	now := time.Date(2026, 3, 1, 12, 0, 30, 0, time.UTC)
+	timelock, err := NewLocalTimelock(seed, func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("Failed to create local timelock: %v", err)
+	}
+
+	// Rounds round up to the next full minute
+	round := timelock.RoundAt(now.Add(time.Hour))
+	if published := timelock.publishedAt(round); !published.Equal(now.Add(time.Hour + 30*time.Second)) {
+		t.Errorf("Expected round %d to be published at %v, got %v", round, now.Add(time.Hour+30*time.Second), published)
+	}
+
+	encrypted, err := timelock.Encrypt([]byte("questions"), round)
+	if err != nil {
+		t.Fatalf("Failed to timelock data: %v", err)
+	}
+
+	// Nothing can be read before the round
+	if _, err := timelock.Decrypt(encrypted); !errors.Is(err, ErrTooEarly) {
+		t.Errorf("Expected ErrTooEarly, got %v", err)
+	}
+
+	// The same seed opens the data once the round is published
+	now = now.Add(2 * time.Hour)
+	other, err := NewLocalTimelock(seed, func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("Failed to create local timelock: %v", err)
+	}
+	decrypted, err := other.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Failed to decrypt timelocked data: %v", err)
+	}
+	if string(decrypted) != "questions" {
+		t.Errorf("Expected %q, got %q", "questions", decrypted)
+	}
+
+	// A different seed can't
+	wrong, _ := NewLocalTimelock(bytes.Repeat([]byte{8}, 32), func() time.Time { return now })
+	if _, err := wrong.Decrypt(encrypted); err == nil {
+		t.Error("Expected an error for a different seed")
+	}
+
+	// A tampered round doesn't move the data to an earlier one
+	encrypted[7]--
+	if _, err := other.Decrypt(encrypted); err == nil {
+		t.Error("Expected an error for a tampered round")
+	}
+}
+
+func TestNewLocalTimelockShortSeed(t *testing.T) {
+	if _, err := NewLocalTimelock(make([]byte, 16), nil); err == nil {
+		t.Error("Expected an error for a seed shorter than 32 bytes")
+	}
+}
+
+// fakeDrand serves a drand chain with a period of 3 seconds that signs rounds
+// with a local key once now has passed them
+func fakeDrand(t *testing.T, scheme string, now *time.Time) (*httptest.Server, string) {
+	t.Helper()
+
+	secret := new(bls12381.Scalar)
+	if err := secret.Random(rand.Reader); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	publicKey := new(bls12381.G2)
+	publicKey.ScalarMult(secret, bls12381.G2Generator())
+
+	const chainHash = "52db9ba70e0cc0f6eaf7803dd07447a1f5477735fd3f661792ba94600c84e971"
+	genesis := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/"+chainHash+"/")
+		if path == "info" {
+			json.NewEncoder(w).Encode(drandInfo{
+				PublicKey:   hex.EncodeToString(publicKey.BytesCompressed()),
+				Period:      3,
+				GenesisTime: genesis.Unix(),
+				Hash:        chainHash,
+				SchemeID:    scheme,
+			})
+			return
+		}
+
+		round, err := strconv.ParseUint(strings.TrimPrefix(path, "public/"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if now.Before(genesis.Add(time.Duration(round-1) * 3 * time.Second)) {
+			w.WriteHeader(http.StatusTooEarly)
+			return
+		}
+
+		signature := new(bls12381.G1)
+		signature.ScalarMult(secret, drandIdentity(round))
+		json.NewEncoder(w).Encode(drandBeacon{
+			Round:     round,
+			Signature: hex.EncodeToString(signature.BytesCompressed()),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, chainHash
+}
+
+func TestDrandTimelock(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 1, 0, time.UTC)
+	server, chainHash := fakeDrand(t, drandScheme, &now)
+
+	timelock, err := NewDrandTimelock(server.URL, chainHash, func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("Failed to create drand timelock: %v", err)
+	}
+
+	// Rounds round up to the next published one
+	round := timelock.RoundAt(now.Add(time.Hour))
+	if published := timelock.publishedAt(round); !published.Equal(now.Add(time.Hour + 2*time.Second)) {
+		t.Errorf("Expected round %d to be published at %v, got %v", round, now.Add(time.Hour+2*time.Second), published)
+	}
+
+	encrypted, err := timelock.Encrypt([]byte("questions"), round)
+	if err != nil {
+		t.Fatalf("Failed to timelock data: %v", err)
+	}
+
+	// Nothing can be read before the round
+	if _, err := timelock.Decrypt(encrypted); !errors.Is(err, ErrTooEarly) {
+		t.Errorf("Expected ErrTooEarly, got %v", err)
+	}
+
+	// A clock ahead of the network doesn't open it either
+	ahead, _ := NewDrandTimelock(server.URL, chainHash, func() time.Time { return now.Add(2 * time.Hour) })
+	if _, err := ahead.Decrypt(encrypted); !errors.Is(err, ErrTooEarly) {
+		t.Errorf("Expected ErrTooEarly from the network, got %v", err)
+	}
+
+	// The signature of the round opens the data once it is published
+	now = now.Add(2 * time.Hour)
+	decrypted, err := timelock.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Failed to decrypt timelocked data: %v", err)
+	}
+	if string(decrypted) != "questions" {
+		t.Errorf("Expected %q, got %q", "questions", decrypted)
+	}
+
+	// A tampered round doesn't move the data to an earlier one
+	encrypted[7]--
+	if _, err := timelock.Decrypt(encrypted); err == nil {
+		t.Error("Expected an error for a tampered round")
+	}
+	encrypted[7]++
+
+	// Nor does data encrypted to another chain open with this one
+	otherServer, _ := fakeDrand(t, drandScheme, &now)
+	other, err := NewDrandTimelock(otherServer.URL, chainHash, func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("Failed to create drand timelock: %v", err)
+	}
+	if _, err := other.Decrypt(encrypted); err == nil {
+		t.Error("Expected an error for another chain")
+	}
+}
+
+func TestNewDrandTimelockChain(t *testing.T) {
+	now := time.Now()
+	server, chainHash := fakeDrand(t, "pedersen-bls-chained", &now)
+
+	if _, err := NewDrandTimelock(server.URL, chainHash, nil); err == nil {
+		t.Error("Expected an error for a chained scheme")
+	}
+	if _, err := NewDrandTimelock(server.URL, strings.Repeat("0", 64), nil); err == nil {
+		t.Error("Expected an error for an unknown chain")
+	}
+}
@@ -0,0 +1,263 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// KeyRotationReport is the progress and the result of RotateKeys. Counts of
+// sealed material cover recipient copies, key shares and submitted shares.
+type KeyRotationReport struct {
+	Users        int      `json:"users"`
+	TotalSecrets int      `json:"total_secrets"`
+	Secrets      int      `json:"secrets"`     // Secrets processed so far
+	Rewrapped    int      `json:"rewrapped"`   // Sealed with a previous key, only the DEK was encrypted again
+	Reencrypted  int      `json:"reencrypted"` // Sealed before the envelope format, decrypted and sealed again
+	Current      int      `json:"current"`     // Already sealed with the current key
+	Verified     int      `json:"verified"`    // Secrets whose sealed material all opens with the current key
+	Failed       []string `json:"failed,omitempty"`
+}
+
+// rotation is what rotateSealed did with a piece of sealed material
+type rotation int
+
+const (
+	rotationCurrent rotation = iota
+	rotationRewrapped
+	rotationReencrypted
+)
+
+// RotateKeys moves all sealed material of every user's secrets to the current
+// master key. Material sealed with a previous key only gets its DEK encrypted
+// again, material from before the envelope format is decrypted and sealed
+// again. Each secret is rotated in a transaction of its own and material that
+// already uses the current key is skipped, so an interrupted run can simply be
+// started again. Afterwards every secret is checked to open with the current
+// key. progress is called after each secret and may be nil.
+func (s *Sealer) RotateKeys(ctx context.Context, progress func(*KeyRotationReport)) (*KeyRotationReport, error) {
+	if !s.Enabled() {
+		return nil, ErrNoMasterKey
+	}
+
+	users, err := s.repo.ListUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	var secrets []*models.Secret
+	for _, user := range users {
+		userSecrets, err := s.repo.ListSecretsByUserID(ctx, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets of user %s: %w", user.ID, err)
+		}
+		secrets = append(secrets, userSecrets...)
+	}
+
+	report := &KeyRotationReport{Users: len(users), TotalSecrets: len(secrets)}
+	failed := make(map[string]bool)
+
+	for _, secret := range secrets {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		if err := s.rotateSecret(ctx, secret, report); err != nil {
+			log.Printf("Error rotating the master key of secret %s: %v", secret.ID, err)
+			failed[secret.ID] = true
+		}
+
+		report.Secrets++
+		if progress != nil {
+			progress(report)
+		}
+	}
+
+	for _, secret := range secrets {
+		if err := s.verifySecret(ctx, secret); err != nil {
+			log.Printf("Error verifying secret %s after key rotation: %v", secret.ID, err)
+			failed[secret.ID] = true
+			continue
+		}
+		report.Verified++
+	}
+
+	for _, secret := range secrets {
+		if failed[secret.ID] {
+			report.Failed = append(report.Failed, secret.ID)
+		}
+	}
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("%d of %d secrets could not be rotated or verified", len(report.Failed), len(secrets))
+	}
+
+	return report, nil
+}
+
+// rotateSecret rotates the recipient copies, key shares and submitted shares of
+// a secret. The material is rotated first and only written in a transaction,
+// which is kept short because it holds the only database connection. Material
+// that changed in between was sealed again by someone else and is left as it
+// is, the verification afterwards checks it. The report is only updated once
+// the transaction is committed.
+func (s *Sealer) rotateSecret(ctx context.Context, secret *models.Secret, report *KeyRotationReport) error {
+	counts := make(map[rotation]int)
+
+	// Rotated material by assignment or submission ID, with what it replaces
+	type update struct{ old, rotated string }
+	assignmentUpdates := make(map[string]update)
+	submissionUpdates := make(map[string]update)
+
+	assignments, err := s.repo.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list secret assignments: %w", err)
+	}
+	for _, assignment := range assignments {
+		if assignment.DeliveryData == "" {
+			continue
+		}
+
+		rotated, kind, err := s.rotateSealed(assignment.DeliveryData)
+		if err != nil {
+			return fmt.Errorf("assignment %s: %w", assignment.ID, err)
+		}
+		counts[kind]++
+		if kind != rotationCurrent {
+			assignmentUpdates[assignment.ID] = update{old: assignment.DeliveryData, rotated: rotated}
+		}
+	}
+
+	submissions, err := s.repo.ListShareSubmissionsBySecretID(ctx, secret.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list share submissions: %w", err)
+	}
+	for _, submission := range submissions {
+		rotated, kind, err := s.rotateSealed(submission.SealedShare)
+		if err != nil {
+			return fmt.Errorf("share submission %s: %w", submission.ID, err)
+		}
+		counts[kind]++
+		if kind != rotationCurrent {
+			submissionUpdates[submission.ID] = update{old: submission.SealedShare, rotated: rotated}
+		}
+	}
+
+	if len(assignmentUpdates) > 0 || len(submissionUpdates) > 0 {
+		tx, err := s.repo.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		assignments, err := tx.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list secret assignments: %w", err)
+		}
+		for _, assignment := range assignments {
+			u, ok := assignmentUpdates[assignment.ID]
+			if !ok || assignment.DeliveryData != u.old {
+				continue
+			}
+			assignment.DeliveryData = u.rotated
+			if err := tx.UpdateSecretAssignment(ctx, assignment); err != nil {
+				return fmt.Errorf("failed to update secret assignment: %w", err)
+			}
+		}
+
+		submissions, err := tx.ListShareSubmissionsBySecretID(ctx, secret.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list share submissions: %w", err)
+		}
+		for _, submission := range submissions {
+			u, ok := submissionUpdates[submission.ID]
+			if !ok || submission.SealedShare != u.old {
+				continue
+			}
+
+			// Saving a submission again replaces the one of the same recipient
+			submission.SealedShare = u.rotated
+			if err := tx.CreateShareSubmission(ctx, submission); err != nil {
+				return fmt.Errorf("failed to update share submission: %w", err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit key rotation: %w", err)
+		}
+	}
+
+	report.Current += counts[rotationCurrent]
+	report.Rewrapped += counts[rotationRewrapped]
+	report.Reencrypted += counts[rotationReencrypted]
+	return nil
+}
+
+// rotateSealed moves one piece of sealed material to the current master key
+func (s *Sealer) rotateSealed(sealed string) (string, rotation, error) {
+	header, err := crypto.ParseEnvelopeHeader(sealed)
+	if errors.Is(err, crypto.ErrLegacyEnvelope) {
+		plaintext, err := s.openLegacy(sealed)
+		if err != nil {
+			return "", rotationCurrent, err
+		}
+		resealed, err := s.Seal(plaintext)
+		return resealed, rotationReencrypted, err
+	}
+	if err != nil {
+		return "", rotationCurrent, err
+	}
+
+	if header.KeyID == crypto.KeyID(s.masterKey) {
+		return sealed, rotationCurrent, nil
+	}
+
+	oldKey := s.masterKeyByID(header.KeyID)
+	if oldKey == nil {
+		return "", rotationCurrent, ErrUnknownMasterKey
+	}
+
+	rewrapped, err := crypto.RewrapSecret(sealed, oldKey, s.masterKey)
+	return rewrapped, rotationRewrapped, err
+}
+
+// verifySecret checks that all sealed material of a secret opens with the current master key alone
+func (s *Sealer) verifySecret(ctx context.Context, secret *models.Secret) error {
+	var sealed []string
+
+	assignments, err := s.repo.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list secret assignments: %w", err)
+	}
+	for _, assignment := range assignments {
+		if assignment.DeliveryData != "" {
+			sealed = append(sealed, assignment.DeliveryData)
+		}
+	}
+
+	submissions, err := s.repo.ListShareSubmissionsBySecretID(ctx, secret.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list share submissions: %w", err)
+	}
+	for _, submission := range submissions {
+		sealed = append(sealed, submission.SealedShare)
+	}
+
+	for _, data := range sealed {
+		header, err := crypto.ParseEnvelopeHeader(data)
+		if err != nil {
+			return err
+		}
+		if header.KeyID != crypto.KeyID(s.masterKey) {
+			return fmt.Errorf("still sealed with key %s", header.KeyID)
+		}
+		if _, err := crypto.DecryptSecret(data, s.masterKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
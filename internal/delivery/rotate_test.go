@@ -0,0 +1,78 @@
+package delivery
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+func TestRotateKeys(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMockRepository()
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	oldSealer := NewSealer(repo, testMasterKey, nil)
+
+	repo.Users = append(repo.Users, &models.User{ID: "user1"})
+	repo.Secrets = append(repo.Secrets, &models.Secret{ID: "secret1", UserID: "user1"})
+
+	// One copy sealed with the old key, one before the envelope format, one already with the new key
+	rewrap, _ := oldSealer.Seal([]byte("rewrap"))
+	legacyData, _ := crypto.Encrypt([]byte("legacy"), testMasterKey)
+	current, _ := crypto.EncryptWithKey([]byte("current"), newKey)
+	repo.SecretAssignments = append(repo.SecretAssignments,
+		&models.SecretAssignment{ID: "a1", SecretID: "secret1", RecipientID: "alice", DeliveryData: rewrap},
+		&models.SecretAssignment{ID: "a2", SecretID: "secret1", RecipientID: "bob", DeliveryData: base64.StdEncoding.EncodeToString(legacyData)},
+		&models.SecretAssignment{ID: "a3", SecretID: "secret1", RecipientID: "carol", DeliveryData: current},
+	)
+	share, _ := oldSealer.Seal([]byte("share"))
+	repo.ShareSubmissions = append(repo.ShareSubmissions, &models.ShareSubmission{ID: "s1", SecretID: "secret1", RecipientID: "alice", SealedShare: share})
+
+	// Without the old key the old material can't be rotated
+	if _, err := NewSealer(repo, newKey, nil).RotateKeys(ctx, nil); err == nil {
+		t.Error("Expected rotation to fail without the previous master key")
+	}
+
+	sealer := NewSealer(repo, newKey, nil)
+	sealer.SetPreviousMasterKeys([][]byte{testMasterKey})
+
+	// Material sealed with the previous key can still be opened before the rotation
+	if plaintext, err := sealer.Open(rewrap); err != nil || string(plaintext) != "rewrap" {
+		t.Fatalf("Expected to open material sealed with the previous key, got %q, %v", plaintext, err)
+	}
+
+	progressCalls := 0
+	report, err := sealer.RotateKeys(ctx, func(*KeyRotationReport) { progressCalls++ })
+	if err != nil {
+		t.Fatalf("RotateKeys failed: %v", err)
+	}
+	if progressCalls != 1 || report.Users != 1 || report.TotalSecrets != 1 || report.Secrets != 1 {
+		t.Errorf("Unexpected progress: %d calls, report %+v", progressCalls, report)
+	}
+	if report.Rewrapped != 2 || report.Reencrypted != 1 || report.Current != 1 || report.Verified != 1 || len(report.Failed) != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	// Everything opens with the new key alone now
+	newOnly := NewSealer(repo, newKey, nil)
+	for _, assignment := range repo.SecretAssignments {
+		if _, err := newOnly.Open(assignment.DeliveryData); err != nil {
+			t.Errorf("Failed to open assignment %s with the new key: %v", assignment.ID, err)
+		}
+	}
+	if plaintext, err := newOnly.Open(repo.ShareSubmissions[0].SealedShare); err != nil || string(plaintext) != "share" {
+		t.Errorf("Expected the submitted share to open with the new key, got %q, %v", plaintext, err)
+	}
+
+	// A second run has nothing left to do
+	report, err = sealer.RotateKeys(ctx, nil)
+	if err != nil {
+		t.Fatalf("Second RotateKeys failed: %v", err)
+	}
+	if report.Rewrapped != 0 || report.Reencrypted != 0 || report.Current != 4 {
+		t.Errorf("Expected all material to be current on the second run, got %+v", report)
+	}
+}
@@ -0,0 +1,654 @@
+package delivery
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+var (
+	// ErrNoMasterKey is returned when sealing is requested but no server master key is configured
+	ErrNoMasterKey = errors.New("server master key is not configured")
+
+	// ErrUnknownMasterKey is returned for material sealed with a master key that is no longer configured
+	ErrUnknownMasterKey = errors.New("sealed with an unknown master key")
+
+	// ErrInvalidQuorum is returned when the threshold does not fit the number of recipients
+	ErrInvalidQuorum = errors.New("quorum threshold must be between 2 and the number of recipients")
+
+	// ErrNotAssigned is returned when a recipient submits a share for a secret they are not assigned to
+	ErrNotAssigned = errors.New("recipient is not assigned to this secret")
+
+	// ErrQuorumNotMet is returned when fewer than the required number of shares were submitted
+	ErrQuorumNotMet = errors.New("not enough shares submitted yet")
+
+	// ErrInvalidQuestions is returned when personal questions can't be used to protect a recipient's copies
+	ErrInvalidQuestions = fmt.Errorf("a recipient needs between 2 and %d questions with answers, and a threshold between 2 and the number of questions", models.MaxRecipientQuestions)
+)
+
+// Sealer protects material that has to be handed to recipients after the owner
+// is gone. The owner's vault key is not available at that point, so delivery
+// material is sealed with the server master key instead.
+type Sealer struct {
+	repo         storage.Repository
+	masterKey    []byte
+	previousKeys [][]byte
+	timelock     crypto.Timelock
+}
+
+// NewSealer creates a new Sealer. The timelock protects personal questions
+// until the owner's deadline, nil leaves them without one.
+func NewSealer(repo storage.Repository, masterKey []byte, timelock crypto.Timelock) *Sealer {
+	return &Sealer{
+		repo:      repo,
+		masterKey: masterKey,
+		timelock:  timelock,
+	}
+}
+
+// SetPreviousMasterKeys sets the master keys used before the current one.
+// Material sealed with them can still be opened until RotateKeys has moved it
+// to the current key.
+func (s *Sealer) SetPreviousMasterKeys(keys [][]byte) {
+	s.previousKeys = keys
+}
+
+// Enabled reports whether a master key is configured
+func (s *Sealer) Enabled() bool {
+	return len(s.masterKey) > 0
+}
+
+// Seal encrypts data with the server master key. The envelope names the key
+// by its key ID, so it can be rotated later without touching the data.
+func (s *Sealer) Seal(data []byte) (string, error) {
+	if !s.Enabled() {
+		return "", ErrNoMasterKey
+	}
+
+	sealed, err := crypto.EncryptWithKey(data, s.masterKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to seal data: %w", err)
+	}
+
+	return sealed, nil
+}
+
+// Open decrypts data sealed with Seal, with the current or a previous master
+// key. Data sealed before the envelope format is tried with all of them.
+func (s *Sealer) Open(sealed string) ([]byte, error) {
+	if !s.Enabled() {
+		return nil, ErrNoMasterKey
+	}
+
+	header, err := crypto.ParseEnvelopeHeader(sealed)
+	if errors.Is(err, crypto.ErrLegacyEnvelope) {
+		return s.openLegacy(sealed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sealed data: %w", err)
+	}
+
+	key := s.masterKeyByID(header.KeyID)
+	if key == nil {
+		return nil, ErrUnknownMasterKey
+	}
+
+	return crypto.DecryptSecret(sealed, key)
+}
+
+// openLegacy decrypts data that was sealed with plain AES-GCM before the
+// envelope format. It doesn't name its key, so every known key is tried.
+func (s *Sealer) openLegacy(sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sealed data: %w", err)
+	}
+
+	for _, key := range append([][]byte{s.masterKey}, s.previousKeys...) {
+		if plaintext, err := crypto.Decrypt(data, key); err == nil {
+			return plaintext, nil
+		}
+	}
+
+	return nil, crypto.ErrDecryptionFailed
+}
+
+// masterKeyByID returns the current or previous master key with the key ID, or nil
+func (s *Sealer) masterKeyByID(keyID string) []byte {
+	for _, key := range append([][]byte{s.masterKey}, s.previousKeys...) {
+		if crypto.KeyID(key) == keyID {
+			return key
+		}
+	}
+	return nil
+}
+
+// HashAccessCode returns the hash a new access code is stored by, keyed with
+// the current master key. Without a master key the hash is not keyed.
+func (s *Sealer) HashAccessCode(code string) string {
+	return crypto.HashAccessCode(code, s.masterKey)
+}
+
+// AccessCodeHashes returns the hashes an access code may be stored by, with the
+// current and the previous master keys, so links sent before a rotation keep
+// working while the old key is still configured
+func (s *Sealer) AccessCodeHashes(code string) []string {
+	hashes := []string{s.HashAccessCode(code)}
+	for _, key := range s.previousKeys {
+		hashes = append(hashes, crypto.HashAccessCode(code, key))
+	}
+	return hashes
+}
+
+// ValidateQuorum checks that a threshold can be used with the given number of recipients
+func ValidateQuorum(threshold, recipients int) error {
+	if threshold < 2 || threshold > recipients {
+		return ErrInvalidQuorum
+	}
+	return nil
+}
+
+// ResealSecret decrypts the owner's copy of a secret with their vault key and
+// reseals it for the current set of recipients. A copy in an older envelope
+// format is encrypted again with the current one. Secrets encrypted in the
+// browser can't be decrypted here, their recipients get the envelope as it is.
+func (s *Sealer) ResealSecret(ctx context.Context, secret *models.Secret, vaultKey []byte) error {
+	if secret.IsClientEncrypted() {
+		return s.Reseal(ctx, secret, []byte(secret.EncryptedData))
+	}
+
+	plaintext, err := crypto.DecryptSecret(secret.EncryptedData, vaultKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+
+	// Secrets in an older envelope format are upgraded on the way, Reseal saves them
+	if crypto.NeedsUpgrade(secret.EncryptedData) {
+		if secret.EncryptedData, err = crypto.EncryptSecret(plaintext, vaultKey); err != nil {
+			return fmt.Errorf("failed to re-encrypt secret: %w", err)
+		}
+	}
+
+	return s.Reseal(ctx, secret, plaintext)
+}
+
+// Reseal rebuilds the recipient copies of a secret. Every assigned recipient
+// gets the plaintext sealed with the master key, or nothing if no master key is
+// configured. Recipients with a public key get the plaintext encrypted to it
+// before it is sealed, unless the secret was encrypted in the browser and their
+// portal has to open the envelope. Recipients with personal questions get it
+// encrypted to their question key. For a quorum protected secret a fresh key is generated instead,
+// the plaintext is encrypted with it and the key is split so that every
+// assigned recipient holds one sealed share. Shares from an earlier split no
+// longer fit the new key, so pending submissions are dropped.
+// The updated secret is saved.
+func (s *Sealer) Reseal(ctx context.Context, secret *models.Secret, plaintext []byte) error {
+	assignments, err := s.repo.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list secret assignments: %w", err)
+	}
+
+	if err := s.repo.DeleteShareSubmissionsBySecretID(ctx, secret.ID); err != nil {
+		return fmt.Errorf("failed to clear share submissions: %w", err)
+	}
+
+	if !secret.IsQuorumProtected() {
+		for _, assignment := range assignments {
+			var sealed string
+			if s.Enabled() {
+				if sealed, err = s.sealFor(ctx, secret, assignment.RecipientID, plaintext); err != nil {
+					return err
+				}
+			}
+
+			if assignment.DeliveryData == sealed {
+				continue
+			}
+			assignment.DeliveryData = sealed
+			if err := s.repo.UpdateSecretAssignment(ctx, assignment); err != nil {
+				return fmt.Errorf("failed to update secret assignment: %w", err)
+			}
+		}
+
+		secret.QuorumData = ""
+		return s.repo.UpdateSecret(ctx, secret)
+	}
+
+	if !s.Enabled() {
+		return ErrNoMasterKey
+	}
+
+	if err := ValidateQuorum(secret.QuorumThreshold, len(assignments)); err != nil {
+		return err
+	}
+
+	key, err := crypto.GenerateDataEncryptionKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate quorum key: %w", err)
+	}
+
+	encrypted, err := crypto.Encrypt(plaintext, key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt quorum data: %w", err)
+	}
+
+	shares, err := crypto.SplitKey(key, len(assignments), secret.QuorumThreshold)
+	if err != nil {
+		return err
+	}
+
+	// The key is only kept as shares from here on
+	for i := range key {
+		key[i] = 0
+	}
+
+	for i, assignment := range assignments {
+		sealed, err := s.Seal([]byte(shares[i]))
+		if err != nil {
+			return err
+		}
+
+		assignment.DeliveryData = sealed
+		if err := s.repo.UpdateSecretAssignment(ctx, assignment); err != nil {
+			return fmt.Errorf("failed to update secret assignment: %w", err)
+		}
+	}
+
+	secret.QuorumData = base64.StdEncoding.EncodeToString(encrypted)
+	return s.repo.UpdateSecret(ctx, secret)
+}
+
+// sealFor seals data for a recipient. If the recipient registered a public key
+// the data is encrypted to it first, so the server can't read the copy anymore.
+// If the recipient has personal questions the result is encrypted to their
+// question key, which only the answers can rebuild.
+func (s *Sealer) sealFor(ctx context.Context, secret *models.Secret, recipientID string, data []byte) (string, error) {
+	recipient, err := s.repo.GetRecipientByID(ctx, recipientID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return "", fmt.Errorf("failed to get recipient: %w", err)
+	}
+
+	if recipient != nil && recipient.PublicKey != "" && !secret.IsClientEncrypted() {
+		if data, err = crypto.EncryptToPublicKey(data, recipient.PublicKey); err != nil {
+			return "", fmt.Errorf("failed to encrypt to the public key of recipient %s: %w", recipient.ID, err)
+		}
+	}
+
+	if recipient != nil && recipient.HasQuestions() {
+		if data, err = crypto.EncryptToPublicKey(data, recipient.QuestionKey); err != nil {
+			return "", fmt.Errorf("failed to encrypt to the question key of recipient %s: %w", recipient.ID, err)
+		}
+	}
+
+	return s.Seal(data)
+}
+
+// SetQuestions protects a recipient's copies with personal questions. The
+// answers lock a fresh question key, so any threshold of them opens the copies
+// on the access portal. Replacing the questions replaces the key, and no
+// questions at all remove the protection. The recipient's copies are rebuilt
+// with the owner's vault key. The answers are not stored. If a timelock beacon
+// is configured the locked shares are only kept behind the timelock.
+func (s *Sealer) SetQuestions(ctx context.Context, recipient *models.Recipient, questions, answers []string, threshold int, vaultKey []byte) error {
+	var stored []*models.RecipientQuestion
+	if len(questions) == 0 {
+		recipient.QuestionThreshold = 0
+		recipient.QuestionKey = ""
+		recipient.QuestionBundle = ""
+	} else {
+		if len(questions) != len(answers) || len(questions) < 2 || len(questions) > models.MaxRecipientQuestions ||
+			threshold < 2 || threshold > len(questions) {
+			return ErrInvalidQuestions
+		}
+		for i := range questions {
+			if strings.TrimSpace(questions[i]) == "" || crypto.NormalizeAnswer(answers[i]) == "" {
+				return ErrInvalidQuestions
+			}
+		}
+
+		key, lockedShares, err := crypto.NewAnswerLock(answers, threshold)
+		if err != nil {
+			return fmt.Errorf("failed to lock question key: %w", err)
+		}
+
+		for i, question := range questions {
+			stored = append(stored, &models.RecipientQuestion{
+				UserID:      recipient.UserID,
+				Question:    strings.TrimSpace(question),
+				LockedShare: lockedShares[i],
+			})
+		}
+
+		recipient.QuestionThreshold = threshold
+		recipient.QuestionKey = key
+	}
+
+	if err := s.repo.ReplaceRecipientQuestions(ctx, recipient.ID, stored); err != nil {
+		return fmt.Errorf("failed to store questions: %w", err)
+	}
+	if err := s.repo.ReplaceQuestionTimelocks(ctx, recipient.ID, nil); err != nil {
+		return fmt.Errorf("failed to delete question timelocks: %w", err)
+	}
+	if err := s.repo.UpdateRecipient(ctx, recipient); err != nil {
+		return fmt.Errorf("failed to update recipient: %w", err)
+	}
+
+	if s.timelock != nil && len(stored) > 0 {
+		if err := s.lockQuestions(ctx, recipient, stored, vaultKey); err != nil {
+			return err
+		}
+	}
+
+	return s.ResealRecipient(ctx, recipient.ID, vaultKey)
+}
+
+// OpenWithAnswers rebuilds a recipient's question key from their answers. The
+// answers are matched to the questions by position, empty answers are skipped.
+// It returns crypto.ErrNotEnoughAnswers if fewer than the threshold were correct
+// and ErrQuestionsLocked while the questions are timelocked.
+func (s *Sealer) OpenWithAnswers(ctx context.Context, recipient *models.Recipient, answers []string) (string, error) {
+	questions, err := s.OpenQuestions(ctx, recipient)
+	if err != nil {
+		return "", err
+	}
+
+	lockedShares := make([]string, len(questions))
+	for i, question := range questions {
+		lockedShares[i] = question.LockedShare
+	}
+
+	identity, _, err := crypto.OpenAnswerLock(lockedShares, answers, recipient.QuestionThreshold)
+	return identity, err
+}
+
+// OpenFor opens a recipient copy. Copies of recipients with personal questions
+// are decrypted with the question key rebuilt by OpenWithAnswers.
+func (s *Sealer) OpenFor(recipient *models.Recipient, sealed, questionIdentity string) ([]byte, error) {
+	data, err := s.Open(sealed)
+	if err != nil || !recipient.HasQuestions() {
+		return data, err
+	}
+
+	return crypto.DecryptWithAgeIdentity(data, questionIdentity)
+}
+
+// ResealRecipient rebuilds the copies of all secrets assigned to a recipient,
+// e.g. after their public key or questions changed. Quorum protected secrets are
+// skipped, their key shares are combined on the server and aren't encrypted to
+// public keys.
+func (s *Sealer) ResealRecipient(ctx context.Context, recipientID string, vaultKey []byte) error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	assignments, err := s.repo.ListSecretAssignmentsByRecipientID(ctx, recipientID)
+	if err != nil {
+		return fmt.Errorf("failed to list secret assignments: %w", err)
+	}
+
+	for _, assignment := range assignments {
+		secret, err := s.repo.GetSecretByID(ctx, assignment.SecretID)
+		if err != nil {
+			return fmt.Errorf("failed to get secret: %w", err)
+		}
+
+		if secret.IsQuorumProtected() {
+			continue
+		}
+
+		if err := s.ResealSecret(ctx, secret, vaultKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EncryptForRecipient encrypts the existing copies of a recipient's secrets to
+// the public key they just registered. This works without the owner's vault key,
+// the copies were only sealed with the master key so far. Quorum protected and
+// browser encrypted secrets are left alone, and so are the copies of recipients
+// with personal questions, the question key can't be removed without the
+// answers. It returns the number of copies that were encrypted.
+func (s *Sealer) EncryptForRecipient(ctx context.Context, recipient *models.Recipient) (int, error) {
+	if !s.Enabled() || recipient.HasQuestions() {
+		return 0, nil
+	}
+
+	key, err := crypto.ParsePublicKey(recipient.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	assignments, err := s.repo.ListSecretAssignmentsByRecipientID(ctx, recipient.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list secret assignments: %w", err)
+	}
+
+	encrypted := 0
+	for _, assignment := range assignments {
+		if assignment.DeliveryData == "" {
+			continue
+		}
+
+		secret, err := s.repo.GetSecretByID(ctx, assignment.SecretID)
+		if err != nil {
+			return encrypted, fmt.Errorf("failed to get secret: %w", err)
+		}
+
+		if secret.IsQuorumProtected() || secret.IsClientEncrypted() {
+			continue
+		}
+
+		plaintext, err := s.Open(assignment.DeliveryData)
+		if err != nil {
+			return encrypted, fmt.Errorf("failed to open recipient copy: %w", err)
+		}
+
+		ciphertext, err := key.Encrypt(plaintext)
+		if err != nil {
+			return encrypted, fmt.Errorf("failed to encrypt recipient copy: %w", err)
+		}
+
+		if assignment.DeliveryData, err = s.Seal(ciphertext); err != nil {
+			return encrypted, err
+		}
+		if err := s.repo.UpdateSecretAssignment(ctx, assignment); err != nil {
+			return encrypted, fmt.Errorf("failed to update secret assignment: %w", err)
+		}
+		encrypted++
+	}
+
+	return encrypted, nil
+}
+
+// SealMissing creates recipient copies for a user's secrets that don't have
+// one yet, e.g. secrets stored before a master key was configured. Quorum
+// protected secrets are skipped since their shares may already be handed out.
+// It returns the number of secrets that were sealed.
+func (s *Sealer) SealMissing(ctx context.Context, userID string, vaultKey []byte) (int, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+
+	secrets, err := s.repo.ListSecretsByUserID(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	sealed := 0
+	for _, secret := range secrets {
+		if secret.IsQuorumProtected() {
+			continue
+		}
+
+		assignments, err := s.repo.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+		if err != nil {
+			return sealed, fmt.Errorf("failed to list secret assignments: %w", err)
+		}
+
+		missing := false
+		for _, assignment := range assignments {
+			if assignment.DeliveryData == "" {
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			continue
+		}
+
+		if err := s.ResealSecret(ctx, secret, vaultKey); err != nil {
+			return sealed, err
+		}
+		sealed++
+	}
+
+	return sealed, nil
+}
+
+// ResealDeliveredQuorums splits the keys of a user's quorum protected secrets
+// again if shares of them were delivered. The server forgets a share once it
+// is emailed, so after a release is recovered the switch could not release
+// the secret again, and the shares the recipients were sent no longer fit the
+// new key. It returns how many secrets were resealed.
+func (s *Sealer) ResealDeliveredQuorums(ctx context.Context, userID string, vaultKey []byte) (int, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+
+	secrets, err := s.repo.ListSecretsByUserID(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	resealed := 0
+	for _, secret := range secrets {
+		if !secret.IsQuorumProtected() {
+			continue
+		}
+
+		assignments, err := s.repo.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+		if err != nil {
+			return resealed, fmt.Errorf("failed to list secret assignments: %w", err)
+		}
+
+		delivered := false
+		for _, assignment := range assignments {
+			if assignment.DeliveryData == "" {
+				delivered = true
+				break
+			}
+		}
+		if !delivered {
+			continue
+		}
+
+		if err := s.ResealSecret(ctx, secret, vaultKey); err != nil {
+			return resealed, fmt.Errorf("failed to reseal secret %s: %w", secret.ID, err)
+		}
+		resealed++
+	}
+
+	return resealed, nil
+}
+
+// SubmitShare records a share submitted by a recipient. Once enough distinct
+// shares are in, the secret is rebuilt and its plaintext returned. Until then
+// ErrQuorumNotMet is returned together with the number of shares still missing.
+func (s *Sealer) SubmitShare(ctx context.Context, secret *models.Secret, recipientID, share string) ([]byte, int, error) {
+	if !secret.IsQuorumProtected() {
+		return nil, 0, fmt.Errorf("secret %s is not quorum protected", secret.ID)
+	}
+
+	assignments, err := s.repo.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list secret assignments: %w", err)
+	}
+
+	assigned := false
+	for _, assignment := range assignments {
+		if assignment.RecipientID == recipientID {
+			assigned = true
+			break
+		}
+	}
+	if !assigned {
+		return nil, 0, ErrNotAssigned
+	}
+
+	index, _, err := crypto.ParseShare(share)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sealed, err := s.Seal([]byte(share))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	submission := &models.ShareSubmission{
+		SecretID:    secret.ID,
+		RecipientID: recipientID,
+		ShareIndex:  int(index),
+		SealedShare: sealed,
+	}
+	if err := s.repo.CreateShareSubmission(ctx, submission); err != nil {
+		return nil, 0, fmt.Errorf("failed to store share submission: %w", err)
+	}
+
+	return s.Combine(ctx, secret)
+}
+
+// Combine tries to rebuild a quorum protected secret from the submitted shares
+func (s *Sealer) Combine(ctx context.Context, secret *models.Secret) ([]byte, int, error) {
+	submissions, err := s.repo.ListShareSubmissionsBySecretID(ctx, secret.ID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list share submissions: %w", err)
+	}
+
+	// Count distinct shares, two recipients can't vote twice with the same share
+	shares := make([]string, 0, len(submissions))
+	seen := make(map[int]bool)
+	for _, submission := range submissions {
+		if seen[submission.ShareIndex] {
+			continue
+		}
+
+		share, err := s.Open(submission.SealedShare)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to open share submission: %w", err)
+		}
+
+		seen[submission.ShareIndex] = true
+		shares = append(shares, string(share))
+	}
+
+	if len(shares) < secret.QuorumThreshold {
+		return nil, secret.QuorumThreshold - len(shares), ErrQuorumNotMet
+	}
+
+	key, err := crypto.CombineShares(shares, secret.QuorumThreshold)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	encrypted, err := base64.StdEncoding.DecodeString(secret.QuorumData)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decode quorum data: %w", err)
+	}
+
+	// GCM authentication fails if any of the shares was wrong
+	plaintext, err := crypto.Decrypt(encrypted, key)
+	if err != nil {
+		return nil, 0, crypto.ErrInvalidShare
+	}
+
+	return plaintext, 0, nil
+}
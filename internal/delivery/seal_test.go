@@ -0,0 +1,420 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+var testMasterKey = []byte("0123456789abcdef0123456789abcdef")
+
+// setupQuorumSecret creates a 2-of-3 quorum protected secret and returns the shares handed to each recipient
+func setupQuorumSecret(t *testing.T, repo *storage.MockRepository, sealer *Sealer) (*models.Secret, map[string]string) {
+	t.Helper()
+
+	ctx := context.Background()
+	secret := &models.Secret{
+		UserID:          "user123",
+		Name:            "Seed phrase",
+		QuorumThreshold: 2,
+	}
+	if err := repo.CreateSecret(ctx, secret); err != nil {
+		t.Fatalf("Failed to create secret: %v", err)
+	}
+
+	for _, recipientID := range []string{"alice", "bob", "carol"} {
+		if err := repo.CreateSecretAssignment(ctx, &models.SecretAssignment{
+			SecretID:    secret.ID,
+			RecipientID: recipientID,
+			UserID:      secret.UserID,
+		}); err != nil {
+			t.Fatalf("Failed to create assignment: %v", err)
+		}
+	}
+
+	if err := sealer.Reseal(ctx, secret, []byte("correct horse battery staple")); err != nil {
+		t.Fatalf("Failed to seal secret: %v", err)
+	}
+
+	shares := make(map[string]string)
+	for _, assignment := range repo.SecretAssignments {
+		share, err := sealer.Open(assignment.DeliveryData)
+		if err != nil {
+			t.Fatalf("Failed to open share for %s: %v", assignment.RecipientID, err)
+		}
+		shares[assignment.RecipientID] = string(share)
+	}
+
+	return secret, shares
+}
+
+func TestSealOpen(t *testing.T) {
+	sealer := NewSealer(storage.NewMockRepository(), testMasterKey, nil)
+
+	sealed, err := sealer.Seal([]byte("share"))
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+
+	opened, err := sealer.Open(sealed)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	if string(opened) != "share" {
+		t.Errorf("Expected %q, got %q", "share", opened)
+	}
+
+	// Without a master key nothing can be sealed
+	disabled := NewSealer(storage.NewMockRepository(), nil, nil)
+	if _, err := disabled.Seal([]byte("share")); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("Expected ErrNoMasterKey, got %v", err)
+	}
+}
+
+func TestResealQuorum(t *testing.T) {
+	repo := storage.NewMockRepository()
+	sealer := NewSealer(repo, testMasterKey, nil)
+
+	secret, shares := setupQuorumSecret(t, repo, sealer)
+
+	if secret.QuorumData == "" {
+		t.Fatal("Expected quorum data to be set")
+	}
+	if len(shares) != 3 {
+		t.Fatalf("Expected 3 shares, got %d", len(shares))
+	}
+
+	// A single share must not reveal the key
+	for _, share := range shares {
+		if _, err := crypto.CombineShares([]string{share}, 2); err == nil {
+			t.Error("Expected a single share to be rejected")
+		}
+	}
+
+	// Too high a threshold is rejected
+	secret.QuorumThreshold = 4
+	if err := sealer.Reseal(context.Background(), secret, []byte("data")); !errors.Is(err, ErrInvalidQuorum) {
+		t.Errorf("Expected ErrInvalidQuorum, got %v", err)
+	}
+
+	// Turning quorum protection off hands every recipient a full copy
+	secret.QuorumThreshold = 0
+	if err := sealer.Reseal(context.Background(), secret, []byte("data")); err != nil {
+		t.Fatalf("Failed to reseal: %v", err)
+	}
+	if secret.QuorumData != "" {
+		t.Error("Expected quorum data to be cleared")
+	}
+	for _, assignment := range repo.SecretAssignments {
+		content, err := sealer.Open(assignment.DeliveryData)
+		if err != nil {
+			t.Fatalf("Failed to open copy for %s: %v", assignment.RecipientID, err)
+		}
+		if string(content) != "data" {
+			t.Errorf("Expected copy %q for %s, got %q", "data", assignment.RecipientID, content)
+		}
+	}
+}
+
+func TestSealMissing(t *testing.T) {
+	repo := storage.NewMockRepository()
+	sealer := NewSealer(repo, testMasterKey, nil)
+	ctx := context.Background()
+
+	vaultKey, err := crypto.GenerateDataEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate vault key: %v", err)
+	}
+	encrypted, err := crypto.EncryptSecret([]byte("bank login"), vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+
+	// A secret stored before a master key was configured has no recipient copy
+	secret := &models.Secret{UserID: "user123", Name: "Bank", EncryptedData: encrypted}
+	if err := repo.CreateSecret(ctx, secret); err != nil {
+		t.Fatalf("Failed to create secret: %v", err)
+	}
+	assignment := &models.SecretAssignment{SecretID: secret.ID, RecipientID: "alice", UserID: "user123"}
+	if err := repo.CreateSecretAssignment(ctx, assignment); err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+
+	sealed, err := sealer.SealMissing(ctx, "user123", vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to seal missing copies: %v", err)
+	}
+	if sealed != 1 {
+		t.Errorf("Expected 1 sealed secret, got %d", sealed)
+	}
+
+	content, err := sealer.Open(assignment.DeliveryData)
+	if err != nil {
+		t.Fatalf("Failed to open recipient copy: %v", err)
+	}
+	if string(content) != "bank login" {
+		t.Errorf("Expected %q, got %q", "bank login", content)
+	}
+
+	// Nothing left to do on the next run
+	sealed, err = sealer.SealMissing(ctx, "user123", vaultKey)
+	if err != nil || sealed != 0 {
+		t.Errorf("Expected nothing to seal, got %d (%v)", sealed, err)
+	}
+}
+
+func TestSubmitShare(t *testing.T) {
+	repo := storage.NewMockRepository()
+	sealer := NewSealer(repo, testMasterKey, nil)
+	ctx := context.Background()
+
+	secret, shares := setupQuorumSecret(t, repo, sealer)
+
+	// The first share is not enough
+	_, remaining, err := sealer.SubmitShare(ctx, secret, "alice", shares["alice"])
+	if !errors.Is(err, ErrQuorumNotMet) {
+		t.Fatalf("Expected ErrQuorumNotMet, got %v", err)
+	}
+	if remaining != 1 {
+		t.Errorf("Expected 1 remaining share, got %d", remaining)
+	}
+
+	// Submitting the same share again does not count twice
+	_, _, err = sealer.SubmitShare(ctx, secret, "bob", shares["alice"])
+	if !errors.Is(err, ErrQuorumNotMet) {
+		t.Fatalf("Expected ErrQuorumNotMet for duplicate share, got %v", err)
+	}
+
+	// Unassigned recipients can't submit shares
+	if _, _, err := sealer.SubmitShare(ctx, secret, "mallory", shares["carol"]); !errors.Is(err, ErrNotAssigned) {
+		t.Errorf("Expected ErrNotAssigned, got %v", err)
+	}
+
+	// The second distinct share unlocks the secret
+	plaintext, _, err := sealer.SubmitShare(ctx, secret, "carol", shares["carol"])
+	if err != nil {
+		t.Fatalf("Failed to combine shares: %v", err)
+	}
+	if string(plaintext) != "correct horse battery staple" {
+		t.Errorf("Expected original plaintext, got %q", plaintext)
+	}
+}
+
+func TestSubmitShareWrongShare(t *testing.T) {
+	repo := storage.NewMockRepository()
+	sealer := NewSealer(repo, testMasterKey, nil)
+	ctx := context.Background()
+
+	secret, shares := setupQuorumSecret(t, repo, sealer)
+
+	// Shares from an earlier split no longer fit after a reseal
+	stale := shares["alice"]
+	if err := sealer.Reseal(ctx, secret, []byte("correct horse battery staple")); err != nil {
+		t.Fatalf("Failed to reseal: %v", err)
+	}
+	fresh, err := sealer.Open(repo.SecretAssignments[1].DeliveryData)
+	if err != nil {
+		t.Fatalf("Failed to open share: %v", err)
+	}
+
+	if _, _, err := sealer.SubmitShare(ctx, secret, "alice", stale); !errors.Is(err, ErrQuorumNotMet) {
+		t.Fatalf("Expected ErrQuorumNotMet, got %v", err)
+	}
+
+	_, _, err = sealer.SubmitShare(ctx, secret, repo.SecretAssignments[1].RecipientID, string(fresh))
+	if !errors.Is(err, crypto.ErrInvalidShare) {
+		t.Errorf("Expected ErrInvalidShare, got %v", err)
+	}
+}
+
+func TestResealPublicKey(t *testing.T) {
+	repo := storage.NewMockRepository()
+	sealer := NewSealer(repo, testMasterKey, nil)
+	ctx := context.Background()
+
+	identity, ageRecipient, err := crypto.GenerateAgeIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+
+	repo.Recipients = append(repo.Recipients,
+		&models.Recipient{ID: "alice", UserID: "user123", PublicKey: ageRecipient},
+		&models.Recipient{ID: "bob", UserID: "user123"},
+	)
+
+	secret := &models.Secret{UserID: "user123", Name: "Bank"}
+	if err := repo.CreateSecret(ctx, secret); err != nil {
+		t.Fatalf("Failed to create secret: %v", err)
+	}
+	for _, recipientID := range []string{"alice", "bob"} {
+		if err := repo.CreateSecretAssignment(ctx, &models.SecretAssignment{SecretID: secret.ID, RecipientID: recipientID, UserID: "user123"}); err != nil {
+			t.Fatalf("Failed to create assignment: %v", err)
+		}
+	}
+
+	if err := sealer.Reseal(ctx, secret, []byte("bank login")); err != nil {
+		t.Fatalf("Failed to seal secret: %v", err)
+	}
+
+	// Alice's copy can only be read with her identity
+	copyForAlice, err := sealer.Open(repo.SecretAssignments[0].DeliveryData)
+	if err != nil {
+		t.Fatalf("Failed to open Alice's copy: %v", err)
+	}
+	plaintext, err := crypto.DecryptWithAgeIdentity(copyForAlice, identity)
+	if err != nil {
+		t.Fatalf("Failed to decrypt Alice's copy: %v", err)
+	}
+	if string(plaintext) != "bank login" {
+		t.Errorf("Expected %q, got %q", "bank login", plaintext)
+	}
+
+	// Bob has no key and gets the plaintext
+	copyForBob, err := sealer.Open(repo.SecretAssignments[1].DeliveryData)
+	if err != nil || string(copyForBob) != "bank login" {
+		t.Fatalf("Expected Bob's copy to be the plaintext, got %q (%v)", copyForBob, err)
+	}
+
+	// Bob registers a key later, his existing copy is encrypted to it
+	bobIdentity, bobRecipient, err := crypto.GenerateAgeIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+	repo.Recipients[1].PublicKey = bobRecipient
+
+	encrypted, err := sealer.EncryptForRecipient(ctx, repo.Recipients[1])
+	if err != nil {
+		t.Fatalf("Failed to encrypt Bob's copies: %v", err)
+	}
+	if encrypted != 1 {
+		t.Errorf("Expected 1 encrypted copy, got %d", encrypted)
+	}
+
+	copyForBob, err = sealer.Open(repo.SecretAssignments[1].DeliveryData)
+	if err != nil {
+		t.Fatalf("Failed to open Bob's copy: %v", err)
+	}
+	if plaintext, err := crypto.DecryptWithAgeIdentity(copyForBob, bobIdentity); err != nil || string(plaintext) != "bank login" {
+		t.Errorf("Expected Bob's copy to decrypt with his identity, got %q (%v)", plaintext, err)
+	}
+}
+
+func TestResealClientEncrypted(t *testing.T) {
+	repo := storage.NewMockRepository()
+	sealer := NewSealer(repo, testMasterKey, nil)
+	ctx := context.Background()
+
+	_, ageRecipient, err := crypto.GenerateAgeIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "alice", UserID: "user123", PublicKey: ageRecipient})
+
+	envelope, err := crypto.EncryptClientEnvelope([]byte("bank login"), "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Failed to encrypt envelope: %v", err)
+	}
+
+	secret := &models.Secret{UserID: "user123", Name: "Bank", EncryptedData: envelope, EncryptionType: models.EncryptionTypeClient}
+	if err := repo.CreateSecret(ctx, secret); err != nil {
+		t.Fatalf("Failed to create secret: %v", err)
+	}
+	if err := repo.CreateSecretAssignment(ctx, &models.SecretAssignment{SecretID: secret.ID, RecipientID: "alice", UserID: "user123"}); err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+
+	// No vault key is needed, the envelope is passed on as it is
+	if err := sealer.ResealSecret(ctx, secret, nil); err != nil {
+		t.Fatalf("Failed to seal secret: %v", err)
+	}
+
+	copyForAlice, err := sealer.Open(repo.SecretAssignments[0].DeliveryData)
+	if err != nil {
+		t.Fatalf("Failed to open Alice's copy: %v", err)
+	}
+	if string(copyForAlice) != envelope {
+		t.Errorf("Expected Alice's copy to be the envelope, got %q", copyForAlice)
+	}
+
+	// Registering a key doesn't touch the envelope either
+	if encrypted, err := sealer.EncryptForRecipient(ctx, repo.Recipients[0]); err != nil || encrypted != 0 {
+		t.Errorf("Expected no copies to be encrypted, got %d (%v)", encrypted, err)
+	}
+}
+
+func TestSetQuestions(t *testing.T) {
+	repo := storage.NewMockRepository()
+	sealer := NewSealer(repo, testMasterKey, nil)
+	ctx := context.Background()
+
+	vaultKey := []byte("abcdef0123456789abcdef0123456789")
+	recipient := &models.Recipient{ID: "alice", UserID: "user123"}
+	repo.Recipients = append(repo.Recipients, recipient)
+
+	encrypted, err := crypto.EncryptSecret([]byte("bank login"), vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+	secret := &models.Secret{UserID: "user123", Name: "Bank", EncryptedData: encrypted, EncryptionType: models.EncryptionTypeVault}
+	if err := repo.CreateSecret(ctx, secret); err != nil {
+		t.Fatalf("Failed to create secret: %v", err)
+	}
+	if err := repo.CreateSecretAssignment(ctx, &models.SecretAssignment{SecretID: secret.ID, RecipientID: "alice", UserID: "user123"}); err != nil {
+		t.Fatalf("Failed to create assignment: %v", err)
+	}
+
+	// A single question or a threshold of one is not enough
+	if err := sealer.SetQuestions(ctx, recipient, []string{"Where did we meet?"}, []string{"Paris"}, 1, vaultKey); !errors.Is(err, ErrInvalidQuestions) {
+		t.Errorf("Expected ErrInvalidQuestions for a single question, got %v", err)
+	}
+	if err := sealer.SetQuestions(ctx, recipient, []string{"Where did we meet?", "First dog?"}, []string{"Paris", ""}, 2, vaultKey); !errors.Is(err, ErrInvalidQuestions) {
+		t.Errorf("Expected ErrInvalidQuestions for an empty answer, got %v", err)
+	}
+
+	questions := []string{"Where did we meet?", "First dog?", "Favourite colour?"}
+	if err := sealer.SetQuestions(ctx, recipient, questions, []string{"Paris", "Rex", "Blue"}, 2, vaultKey); err != nil {
+		t.Fatalf("Failed to set questions: %v", err)
+	}
+	if !recipient.HasQuestions() || len(repo.RecipientQuestions) != 3 {
+		t.Fatalf("Expected 3 questions with a threshold, got %d (threshold %d)", len(repo.RecipientQuestions), recipient.QuestionThreshold)
+	}
+
+	// The copy can't be read without the answers
+	deliveryData := repo.SecretAssignments[0].DeliveryData
+	if copyForAlice, err := sealer.Open(deliveryData); err != nil || string(copyForAlice) == "bank login" {
+		t.Fatalf("Expected Alice's copy to be encrypted to her question key, got %q (%v)", copyForAlice, err)
+	}
+
+	if _, err := sealer.OpenWithAnswers(ctx, recipient, []string{"paris", "Max", ""}); !errors.Is(err, crypto.ErrNotEnoughAnswers) {
+		t.Errorf("Expected ErrNotEnoughAnswers, got %v", err)
+	}
+
+	identity, err := sealer.OpenWithAnswers(ctx, recipient, []string{"", "rex", "BLUE"})
+	if err != nil {
+		t.Fatalf("Failed to open with answers: %v", err)
+	}
+	plaintext, err := sealer.OpenFor(recipient, deliveryData, identity)
+	if err != nil || string(plaintext) != "bank login" {
+		t.Errorf("Expected %q, got %q (%v)", "bank login", plaintext, err)
+	}
+
+	// Registering a public key leaves the copies alone
+	if encrypted, err := sealer.EncryptForRecipient(ctx, recipient); err != nil || encrypted != 0 {
+		t.Errorf("Expected no copies to be encrypted, got %d (%v)", encrypted, err)
+	}
+
+	// Removing the questions gives the plaintext copy back
+	if err := sealer.SetQuestions(ctx, recipient, nil, nil, 0, vaultKey); err != nil {
+		t.Fatalf("Failed to remove questions: %v", err)
+	}
+	if recipient.HasQuestions() || len(repo.RecipientQuestions) != 0 {
+		t.Errorf("Expected the questions to be removed")
+	}
+	if plaintext, err := sealer.OpenFor(recipient, repo.SecretAssignments[0].DeliveryData, ""); err != nil || string(plaintext) != "bank login" {
+		t.Errorf("Expected %q, got %q (%v)", "bank login", plaintext, err)
+	}
+}
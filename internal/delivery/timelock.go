@@ -0,0 +1,404 @@
+package delivery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// Personal questions can be timelocked with a beacon, so the locked shares
+// can't be attacked offline before the switch fires. A check-in has to move the
+// timelock to the new deadline, but most check-ins happen without the owner's
+// vault key, and keeping the questions where the server can read them would
+// defeat the timelock. So a recipient gets a ladder of copies, one per day
+// after the deadline. A check-in drops the copies that would open before the
+// new deadline, and once the owner signs in with their password the ladder is
+// rebuilt from a copy encrypted with their vault key. If the owner only checks
+// in without it until the last copy opens before their deadline, the timelock
+// is turned off for that recipient, see DisableOpenedQuestionTimelocks.
+
+const (
+	// questionTimelockCopies is how many copies of the questions are timelocked ahead
+	questionTimelockCopies = 60
+
+	// questionTimelockSpacing is the time between the rounds of two copies
+	questionTimelockSpacing = 24 * time.Hour
+)
+
+var (
+	// ErrQuestionsLocked is returned when timelocked questions are asked for before their round
+	ErrQuestionsLocked = errors.New("the questions are timelocked until the owner's deadline")
+
+	// ErrNoTimelock is returned when timelocked questions exist but no beacon is configured
+	ErrNoTimelock = errors.New("the questions are timelocked but no timelock beacon is configured")
+)
+
+// questionBundle is what gets timelocked: the questions and the locked shares of their answers
+type questionBundle struct {
+	Questions    []string `json:"questions"`
+	LockedShares []string `json:"locked_shares"`
+}
+
+// TimelockEnabled reports whether a timelock beacon is configured
+func (s *Sealer) TimelockEnabled() bool {
+	return s.timelock != nil
+}
+
+// questionsOpenAt returns when a user's questions may open, the deadline of their last check-in
+func questionsOpenAt(user *models.User) time.Time {
+	return user.LastActivity.Add(time.Duration(user.PingDeadline) * 24 * time.Hour)
+}
+
+// timelockQuestions stores a fresh ladder of timelocked copies of a bundle for
+// a recipient. The first copy opens at the user's deadline.
+func (s *Sealer) timelockQuestions(ctx context.Context, user *models.User, recipient *models.Recipient, bundle []byte) error {
+	opensAt := questionsOpenAt(user)
+
+	timelocks := make([]*models.QuestionTimelock, 0, questionTimelockCopies)
+	for i := 0; i < questionTimelockCopies; i++ {
+		at := opensAt.Add(time.Duration(i) * questionTimelockSpacing)
+		round := s.timelock.RoundAt(at)
+
+		data, err := s.timelock.Encrypt(bundle, round)
+		if err != nil {
+			return err
+		}
+
+		timelocks = append(timelocks, &models.QuestionTimelock{
+			UserID:  recipient.UserID,
+			Round:   round,
+			OpensAt: at.UTC(),
+			Data:    base64.StdEncoding.EncodeToString(data),
+		})
+	}
+
+	if err := s.repo.ReplaceQuestionTimelocks(ctx, recipient.ID, timelocks); err != nil {
+		return fmt.Errorf("failed to store question timelocks: %w", err)
+	}
+
+	return nil
+}
+
+// lockQuestions moves questions of a recipient behind the timelock. The
+// questions stay readable for the owner, the locked shares are only kept in
+// the timelocked copies and, if the vault key is given, in a copy encrypted
+// with it to renew the timelock later.
+func (s *Sealer) lockQuestions(ctx context.Context, recipient *models.Recipient, questions []*models.RecipientQuestion, vaultKey []byte) error {
+	user, err := s.repo.GetUserByID(ctx, recipient.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var bundle questionBundle
+	for _, question := range questions {
+		bundle.Questions = append(bundle.Questions, question.Question)
+		bundle.LockedShares = append(bundle.LockedShares, question.LockedShare)
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to encode questions: %w", err)
+	}
+
+	if err := s.timelockQuestions(ctx, user, recipient, data); err != nil {
+		return err
+	}
+
+	recipient.QuestionBundle = ""
+	if vaultKey != nil {
+		if recipient.QuestionBundle, err = crypto.EncryptSecret(data, vaultKey); err != nil {
+			return fmt.Errorf("failed to encrypt questions: %w", err)
+		}
+	}
+	if err := s.repo.UpdateRecipient(ctx, recipient); err != nil {
+		return fmt.Errorf("failed to update recipient: %w", err)
+	}
+
+	for _, question := range questions {
+		question.LockedShare = ""
+	}
+	if err := s.repo.ReplaceRecipientQuestions(ctx, recipient.ID, questions); err != nil {
+		return fmt.Errorf("failed to store questions: %w", err)
+	}
+
+	return nil
+}
+
+// OpenQuestions returns a recipient's questions with their locked shares. If
+// they are timelocked, the earliest copy is opened with the beacon and
+// ErrQuestionsLocked is returned until its round is published.
+func (s *Sealer) OpenQuestions(ctx context.Context, recipient *models.Recipient) ([]*models.RecipientQuestion, error) {
+	questions, err := s.repo.ListRecipientQuestions(ctx, recipient.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list questions: %w", err)
+	}
+
+	timelocks, err := s.repo.ListQuestionTimelocks(ctx, recipient.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list question timelocks: %w", err)
+	}
+	if len(timelocks) == 0 {
+		return questions, nil
+	}
+	if s.timelock == nil {
+		return nil, ErrNoTimelock
+	}
+
+	data, err := base64.StdEncoding.DecodeString(timelocks[0].Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode question timelock: %w", err)
+	}
+
+	data, err = s.timelock.Decrypt(data)
+	if errors.Is(err, crypto.ErrTooEarly) {
+		return nil, ErrQuestionsLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle questionBundle
+	if err := json.Unmarshal(data, &bundle); err != nil || len(bundle.Questions) != len(bundle.LockedShares) {
+		return nil, fmt.Errorf("failed to decode timelocked questions: %w", crypto.ErrInvalidData)
+	}
+
+	opened := make([]*models.RecipientQuestion, len(bundle.Questions))
+	for i := range bundle.Questions {
+		opened[i] = &models.RecipientQuestion{
+			RecipientID: recipient.ID,
+			UserID:      recipient.UserID,
+			Position:    i + 1,
+			Question:    bundle.Questions[i],
+			LockedShare: bundle.LockedShares[i],
+		}
+	}
+
+	return opened, nil
+}
+
+// QuestionsOpenAt returns when a recipient's timelocked questions open, or the
+// zero time if they are not timelocked
+func (s *Sealer) QuestionsOpenAt(ctx context.Context, recipientID string) (time.Time, error) {
+	timelocks, err := s.repo.ListQuestionTimelocks(ctx, recipientID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to list question timelocks: %w", err)
+	}
+	if len(timelocks) == 0 {
+		return time.Time{}, nil
+	}
+
+	return timelocks[0].OpensAt, nil
+}
+
+// RenewQuestionTimelocks moves the timelocks of a user's questions to the
+// deadline of their last check-in. It has to be called wherever LastActivity
+// is reset. Copies that would open before the new deadline are dropped, the
+// last one is always kept so the recipients aren't locked out for good. With
+// the vault key, ladders that are running short are rebuilt and questions
+// stored before a beacon was configured are timelocked.
+func (s *Sealer) RenewQuestionTimelocks(ctx context.Context, user *models.User, vaultKey []byte) error {
+	if s.timelock == nil {
+		return nil
+	}
+
+	timelocks, err := s.repo.ListQuestionTimelocksByUserID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list question timelocks: %w", err)
+	}
+
+	// Copies are grouped by recipient, earliest round first
+	remaining := make(map[string]int)
+	target := s.timelock.RoundAt(questionsOpenAt(user))
+	for i, timelock := range timelocks {
+		last := i == len(timelocks)-1 || timelocks[i+1].RecipientID != timelock.RecipientID
+		if timelock.Round >= target || last {
+			if timelock.Round < target {
+				log.Printf("Timelock of the questions of recipient %s can't be moved past %s until user %s unlocks their vault, it is turned off once it opens",
+					timelock.RecipientID, timelock.OpensAt.Format(time.RFC3339), user.ID)
+			}
+			remaining[timelock.RecipientID]++
+			continue
+		}
+
+		if err := s.repo.DeleteQuestionTimelock(ctx, timelock.ID); err != nil {
+			return fmt.Errorf("failed to delete question timelock: %w", err)
+		}
+	}
+
+	if vaultKey == nil {
+		return nil
+	}
+
+	recipients, err := s.repo.ListRecipientsByUserID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list recipients: %w", err)
+	}
+
+	for _, recipient := range recipients {
+		if !recipient.HasQuestions() {
+			continue
+		}
+
+		count, timelocked := remaining[recipient.ID]
+		switch {
+		case !timelocked:
+			// Questions from before the beacon was configured still hold their locked shares
+			questions, err := s.repo.ListRecipientQuestions(ctx, recipient.ID)
+			if err != nil {
+				return fmt.Errorf("failed to list questions: %w", err)
+			}
+			if len(questions) == 0 || questions[0].LockedShare == "" {
+				continue
+			}
+			if err := s.lockQuestions(ctx, recipient, questions, vaultKey); err != nil {
+				return err
+			}
+		case count < questionTimelockCopies/2 && recipient.QuestionBundle != "":
+			bundle, err := crypto.DecryptSecret(recipient.QuestionBundle, vaultKey)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt questions: %w", err)
+			}
+			if err := s.timelockQuestions(ctx, user, recipient, bundle); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DisableOpenedQuestionTimelocks turns off the timelock of the recipients
+// whose last copy opened before the user's deadline, because the user didn't
+// sign in with their password for the 60 days the ladder covers. Their questions are
+// stored with the locked shares again, like before a beacon was configured,
+// so the next password login timelocks them again. It returns the recipients
+// whose timelock was turned off, to tell the user.
+func (s *Sealer) DisableOpenedQuestionTimelocks(ctx context.Context, user *models.User) ([]*models.Recipient, error) {
+	if s.timelock == nil {
+		return nil, nil
+	}
+
+	timelocks, err := s.repo.ListQuestionTimelocksByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list question timelocks: %w", err)
+	}
+
+	// Copies are grouped by recipient, earliest round first
+	var stale []string
+	target := s.timelock.RoundAt(questionsOpenAt(user))
+	for i, timelock := range timelocks {
+		last := i == len(timelocks)-1 || timelocks[i+1].RecipientID != timelock.RecipientID
+		if last && timelock.Round < target {
+			stale = append(stale, timelock.RecipientID)
+		}
+	}
+
+	var disabled []*models.Recipient
+	for _, recipientID := range stale {
+		recipient, err := s.repo.GetRecipientByID(ctx, recipientID)
+		if err != nil {
+			return disabled, fmt.Errorf("failed to get recipient: %w", err)
+		}
+
+		questions, err := s.OpenQuestions(ctx, recipient)
+		if errors.Is(err, ErrQuestionsLocked) {
+			continue
+		}
+		if err != nil {
+			return disabled, err
+		}
+
+		if err := s.unlockQuestions(ctx, recipient, questions); err != nil {
+			return disabled, err
+		}
+		disabled = append(disabled, recipient)
+	}
+
+	return disabled, nil
+}
+
+// unlockQuestions stores opened questions with their locked shares and drops
+// the timelocked copies, in one transaction so the shares are never lost
+func (s *Sealer) unlockQuestions(ctx context.Context, recipient *models.Recipient, questions []*models.RecipientQuestion) error {
+	tx, err := s.repo.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.ReplaceRecipientQuestions(ctx, recipient.ID, questions); err != nil {
+		return fmt.Errorf("failed to store questions: %w", err)
+	}
+	if err := tx.ReplaceQuestionTimelocks(ctx, recipient.ID, nil); err != nil {
+		return fmt.Errorf("failed to delete question timelocks: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit questions: %w", err)
+	}
+	return nil
+}
+
+// LockedQuestions returns a recipient's questions with the locked shares of
+// their answers, to back them up. Timelocked questions are read from the copy
+// encrypted with the owner's vault key. ErrQuestionsLocked is returned if
+// there is no such copy.
+func (s *Sealer) LockedQuestions(ctx context.Context, recipient *models.Recipient, vaultKey []byte) ([]*models.RecipientQuestion, error) {
+	questions, err := s.repo.ListRecipientQuestions(ctx, recipient.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list questions: %w", err)
+	}
+	if len(questions) == 0 || questions[0].LockedShare != "" {
+		return questions, nil
+	}
+
+	if recipient.QuestionBundle == "" {
+		return nil, ErrQuestionsLocked
+	}
+
+	data, err := crypto.DecryptSecret(recipient.QuestionBundle, vaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt questions: %w", err)
+	}
+
+	var bundle questionBundle
+	if err := json.Unmarshal(data, &bundle); err != nil || len(bundle.Questions) != len(bundle.LockedShares) {
+		return nil, fmt.Errorf("failed to decode questions: %w", crypto.ErrInvalidData)
+	}
+
+	locked := make([]*models.RecipientQuestion, len(bundle.Questions))
+	for i := range bundle.Questions {
+		locked[i] = &models.RecipientQuestion{
+			RecipientID: recipient.ID,
+			UserID:      recipient.UserID,
+			Position:    i + 1,
+			Question:    bundle.Questions[i],
+			LockedShare: bundle.LockedShares[i],
+		}
+	}
+
+	return locked, nil
+}
+
+// RestoreQuestions stores questions with the locked shares of their answers
+// from a backup, so the recipient opens their copies with the same answers.
+// The question key and threshold have to be set on the recipient. If a
+// timelock beacon is configured the questions are timelocked like new ones.
+func (s *Sealer) RestoreQuestions(ctx context.Context, recipient *models.Recipient, questions []*models.RecipientQuestion, vaultKey []byte) error {
+	if err := s.repo.ReplaceRecipientQuestions(ctx, recipient.ID, questions); err != nil {
+		return fmt.Errorf("failed to store questions: %w", err)
+	}
+
+	if s.timelock != nil && len(questions) > 0 {
+		return s.lockQuestions(ctx, recipient, questions, vaultKey)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+func TestQuestionTimelocks(t *testing.T) {
+	repo := storage.NewMockRepository()
+	ctx := context.Background()
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	timelock, err := crypto.NewLocalTimelock(bytes.Repeat([]byte{7}, 32), func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("Failed to create timelock: %v", err)
+	}
+	sealer := NewSealer(repo, testMasterKey, timelock)
+
+	vaultKey := []byte("abcdef0123456789abcdef0123456789")
+	user := &models.User{ID: "user123", LastActivity: start, PingDeadline: 14}
+	recipient := &models.Recipient{ID: "alice", UserID: "user123"}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients, recipient)
+
+	answers := []string{"Paris", "Rex", "Blue"}
+	if err := sealer.SetQuestions(ctx, recipient, []string{"Where did we meet?", "First dog?", "Favourite colour?"}, answers, 2, vaultKey); err != nil {
+		t.Fatalf("Failed to set questions: %v", err)
+	}
+
+	// The locked shares are only kept behind the timelock and in the vault copy
+	if len(repo.QuestionTimelocks) != questionTimelockCopies {
+		t.Fatalf("Expected %d timelocked copies, got %d", questionTimelockCopies, len(repo.QuestionTimelocks))
+	}
+	for _, question := range repo.RecipientQuestions {
+		if question.LockedShare != "" {
+			t.Errorf("Expected the locked share of %q to be removed", question.Question)
+		}
+	}
+	if recipient.QuestionBundle == "" {
+		t.Error("Expected a copy of the questions encrypted with the vault key")
+	}
+
+	deadline := start.Add(14 * 24 * time.Hour)
+	if opensAt, err := sealer.QuestionsOpenAt(ctx, recipient.ID); err != nil || !opensAt.Equal(deadline) {
+		t.Errorf("Expected the questions to open at %v, got %v (%v)", deadline, opensAt, err)
+	}
+
+	// Nobody can open the questions before the deadline
+	if _, err := sealer.OpenWithAnswers(ctx, recipient, answers); !errors.Is(err, ErrQuestionsLocked) {
+		t.Errorf("Expected ErrQuestionsLocked, got %v", err)
+	}
+
+	// A check-in without the vault key drops the copies that open too early
+	now = start.Add(3 * 24 * time.Hour)
+	user.LastActivity = now
+	if err := sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		t.Fatalf("Failed to renew question timelocks: %v", err)
+	}
+	if len(repo.QuestionTimelocks) != questionTimelockCopies-3 {
+		t.Errorf("Expected %d timelocked copies, got %d", questionTimelockCopies-3, len(repo.QuestionTimelocks))
+	}
+	deadline = now.Add(14 * 24 * time.Hour)
+	if opensAt, _ := sealer.QuestionsOpenAt(ctx, recipient.ID); !opensAt.Equal(deadline) {
+		t.Errorf("Expected the questions to open at %v, got %v", deadline, opensAt)
+	}
+
+	// The old deadline has passed, but the questions are still locked
+	now = start.Add(15 * 24 * time.Hour)
+	if _, err := sealer.OpenWithAnswers(ctx, recipient, answers); !errors.Is(err, ErrQuestionsLocked) {
+		t.Errorf("Expected ErrQuestionsLocked after the old deadline, got %v", err)
+	}
+
+	// Signing in with the password rebuilds a ladder that is running short
+	now = start.Add(40 * 24 * time.Hour)
+	user.LastActivity = now
+	if err := sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		t.Fatalf("Failed to renew question timelocks: %v", err)
+	}
+	if len(repo.QuestionTimelocks) != questionTimelockCopies-40 {
+		t.Errorf("Expected %d timelocked copies, got %d", questionTimelockCopies-40, len(repo.QuestionTimelocks))
+	}
+	if err := sealer.RenewQuestionTimelocks(ctx, user, vaultKey); err != nil {
+		t.Fatalf("Failed to renew question timelocks with the vault key: %v", err)
+	}
+	if len(repo.QuestionTimelocks) != questionTimelockCopies {
+		t.Errorf("Expected the ladder to be rebuilt with %d copies, got %d", questionTimelockCopies, len(repo.QuestionTimelocks))
+	}
+
+	// Without the vault key the last copy is kept, even if it opens too early
+	now = start.Add(100 * 24 * time.Hour)
+	user.LastActivity = now
+	if err := sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		t.Fatalf("Failed to renew question timelocks: %v", err)
+	}
+	if len(repo.QuestionTimelocks) != 1 {
+		t.Errorf("Expected the last copy to be kept, got %d", len(repo.QuestionTimelocks))
+	}
+	if disabled, err := sealer.DisableOpenedQuestionTimelocks(ctx, user); err != nil || len(disabled) != 0 {
+		t.Errorf("Expected the timelock to stay until the last copy opens, got %d (%v)", len(disabled), err)
+	}
+
+	// Once it opened before the deadline, the timelock is turned off and the owner is told
+	now = start.Add(120 * 24 * time.Hour)
+	user.LastActivity = now
+	if err := sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		t.Fatalf("Failed to renew question timelocks: %v", err)
+	}
+	disabled, err := sealer.DisableOpenedQuestionTimelocks(ctx, user)
+	if err != nil || len(disabled) != 1 || disabled[0].ID != recipient.ID {
+		t.Fatalf("Expected the timelock of the recipient to be turned off, got %v (%v)", disabled, err)
+	}
+	if len(repo.QuestionTimelocks) != 0 || repo.RecipientQuestions[0].LockedShare == "" {
+		t.Error("Expected the questions to be stored with their locked shares instead of the timelock")
+	}
+	if identity, err := sealer.OpenWithAnswers(ctx, recipient, answers); err != nil || identity == "" {
+		t.Errorf("Expected the answers to open the questions without the timelock, got %v", err)
+	}
+
+	// Signing in with the password timelocks them again
+	if err := sealer.RenewQuestionTimelocks(ctx, user, vaultKey); err != nil {
+		t.Fatalf("Failed to renew question timelocks with the vault key: %v", err)
+	}
+	if len(repo.QuestionTimelocks) != questionTimelockCopies {
+		t.Errorf("Expected the questions to be timelocked again, got %d copies", len(repo.QuestionTimelocks))
+	}
+	if _, err := sealer.OpenWithAnswers(ctx, recipient, answers); !errors.Is(err, ErrQuestionsLocked) {
+		t.Errorf("Expected ErrQuestionsLocked, got %v", err)
+	}
+
+	// Once the deadline passes the answers open the questions again
+	now = now.Add(15 * 24 * time.Hour)
+	if identity, err := sealer.OpenWithAnswers(ctx, recipient, []string{"", "rex", "BLUE"}); err != nil || identity == "" {
+		t.Errorf("Expected the answers to open the questions, got %v", err)
+	}
+	if _, err := sealer.OpenWithAnswers(ctx, recipient, []string{"paris", "", ""}); !errors.Is(err, crypto.ErrNotEnoughAnswers) {
+		t.Errorf("Expected ErrNotEnoughAnswers, got %v", err)
+	}
+}
+
+func TestRenewQuestionTimelocksLocksExistingQuestions(t *testing.T) {
+	repo := storage.NewMockRepository()
+	ctx := context.Background()
+
+	vaultKey := []byte("abcdef0123456789abcdef0123456789")
+	user := &models.User{ID: "user123", LastActivity: time.Now(), PingDeadline: 14}
+	recipient := &models.Recipient{ID: "alice", UserID: "user123"}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients, recipient)
+
+	// Questions stored before a beacon was configured
+	if err := NewSealer(repo, testMasterKey, nil).SetQuestions(ctx, recipient, []string{"Where did we meet?", "First dog?"}, []string{"Paris", "Rex"}, 2, vaultKey); err != nil {
+		t.Fatalf("Failed to set questions: %v", err)
+	}
+
+	timelock, err := crypto.NewLocalTimelock(bytes.Repeat([]byte{7}, 32), nil)
+	if err != nil {
+		t.Fatalf("Failed to create timelock: %v", err)
+	}
+	sealer := NewSealer(repo, testMasterKey, timelock)
+
+	// Without the vault key there is nothing to build the copies from
+	if err := sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		t.Fatalf("Failed to renew question timelocks: %v", err)
+	}
+	if len(repo.QuestionTimelocks) != 0 {
+		t.Fatalf("Expected no timelocked copies, got %d", len(repo.QuestionTimelocks))
+	}
+
+	if err := sealer.RenewQuestionTimelocks(ctx, user, vaultKey); err != nil {
+		t.Fatalf("Failed to renew question timelocks: %v", err)
+	}
+	if len(repo.QuestionTimelocks) != questionTimelockCopies {
+		t.Errorf("Expected %d timelocked copies, got %d", questionTimelockCopies, len(repo.QuestionTimelocks))
+	}
+	if repo.RecipientQuestions[0].LockedShare != "" || recipient.QuestionBundle == "" {
+		t.Error("Expected the locked shares to move behind the timelock")
+	}
+	if _, err := sealer.OpenWithAnswers(ctx, recipient, []string{"Paris", "Rex"}); !errors.Is(err, ErrQuestionsLocked) {
+		t.Errorf("Expected ErrQuestionsLocked, got %v", err)
+	}
+}
@@ -20,6 +20,14 @@ type Client struct {
 	templates *template.Template
 }
 
+// QuorumShare is a key share handed to a recipient of a quorum protected secret
+type QuorumShare struct {
+	SecretName string
+	Share      string
+	Threshold  int
+	Total      int
+}
+
 // MessageOptions defines options for an email message
 type MessageOptions struct {
 	From    string
@@ -206,7 +214,8 @@ func (c *Client) getSubjectByUrgency(urgency string) string {
 }
 
 // SendSecretDeliveryEmail sends an email with access to a user's secrets
-func (c *Client) SendSecretDeliveryEmail(recipientEmail, recipientName, message string, accessCode string) error {
+// Shares are included for secrets that need several recipients to unlock them together
+func (c *Client) SendSecretDeliveryEmail(recipientEmail, recipientName, message string, accessCode string, shares []QuorumShare) error {
 	baseURL := fmt.Sprintf("https://%s", c.config.BaseDomain)
 	accessURL := fmt.Sprintf("%s/access/%s", baseURL, accessCode)
 
@@ -215,6 +224,7 @@ func (c *Client) SendSecretDeliveryEmail(recipientEmail, recipientName, message
 		"RecipientName": recipientName,
 		"Message":       message,
 		"AccessURL":     accessURL,
+		"Shares":        shares,
 	}
 
 	// Render template
@@ -325,10 +325,13 @@ func TestSendSecretDeliveryEmail(t *testing.T) {
 	recipientName := "Test Recipient"
 	message := "Here are my secrets"
 	accessCode := "xyz789"
+	shares := []QuorumShare{
+		{SecretName: "Seed phrase", Share: "1-c2hhcmU", Threshold: 2, Total: 3},
+	}
 
 	// This will fail because we're not actually connecting to an SMTP server
 	// but we can verify that it attempts to send the email
-	err = client.SendSecretDeliveryEmail(recipientEmail, recipientName, message, accessCode)
+	err = client.SendSecretDeliveryEmail(recipientEmail, recipientName, message, accessCode, shares)
 	if err == nil {
 		t.Fatal("Expected error for SMTP connection, got nil")
 	}
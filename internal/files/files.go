@@ -0,0 +1,139 @@
+// Package files stores the uploaded files of file secrets. Each file is
+// encrypted while it is written to disk, to an age identity of its own that
+// only the secret's content holds, so neither the upload nor the download
+// ever has the whole file in memory.
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// ErrTooLarge is returned when a file is larger than the limit it was stored with
+var ErrTooLarge = errors.New("file is too large")
+
+// DefaultType is the MIME type of files whose type is unknown
+const DefaultType = "application/octet-stream"
+
+// Encrypt reads a file from r, encrypts it to a new age identity and stores
+// it. It returns the reference of the stored file and the content of the file
+// secret. If r has more than limit bytes nothing is stored and ErrTooLarge is
+// returned.
+func (s *Store) Encrypt(r io.Reader, name, contentType string, limit int64) (string, *models.SecretFile, error) {
+	identity, recipient, err := crypto.GenerateAgeIdentity()
+	if err != nil {
+		return "", nil, err
+	}
+
+	upload, err := s.Create()
+	if err != nil {
+		return "", nil, err
+	}
+
+	w, err := crypto.NewAgeWriter(upload, recipient)
+	if err != nil {
+		upload.Abort()
+		return "", nil, err
+	}
+
+	// Read one byte more than allowed to tell a file of exactly limit bytes from a larger one
+	size, err := io.Copy(w, io.LimitReader(r, limit+1))
+	if err != nil {
+		upload.Abort()
+		return "", nil, fmt.Errorf("failed to encrypt file: %w", err)
+	}
+	if size > limit {
+		upload.Abort()
+		return "", nil, ErrTooLarge
+	}
+
+	if err := w.Close(); err != nil {
+		upload.Abort()
+		return "", nil, fmt.Errorf("failed to encrypt file: %w", err)
+	}
+	if err := upload.Commit(); err != nil {
+		return "", nil, err
+	}
+
+	name = CleanName(name)
+	return upload.Ref, &models.SecretFile{
+		Name:     name,
+		Type:     cleanType(contentType, name),
+		Size:     size,
+		Identity: identity,
+	}, nil
+}
+
+// Decrypt opens a stored file and returns a reader of its plaintext. Reading
+// fails with crypto.ErrDecryptionFailed if the file was tampered with.
+func (s *Store) Decrypt(ref string, file *models.SecretFile) (io.ReadCloser, error) {
+	encrypted, err := s.Open(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := crypto.NewAgeReader(encrypted, file.Identity)
+	if err != nil {
+		encrypted.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, encrypted}, nil
+}
+
+// Marshal returns the content of a file secret
+func Marshal(file *models.SecretFile) ([]byte, error) {
+	return json.Marshal(file)
+}
+
+// Parse parses the content of a file secret
+func Parse(content []byte) (*models.SecretFile, error) {
+	var file models.SecretFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid file secret: %w", err)
+	}
+	if file.Identity == "" {
+		return nil, errors.New("invalid file secret: missing identity")
+	}
+	return &file, nil
+}
+
+// CleanName returns the base name of an uploaded file without control
+// characters, so it can be sent back in a Content-Disposition header
+func CleanName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// cleanType returns the MIME type sent with an upload, or the type of the file
+// extension if the browser didn't send a usable one
+func cleanType(contentType, name string) string {
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil && mediaType != DefaultType {
+		return mime.FormatMediaType(mediaType, params)
+	}
+	if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+		return byExtension
+	}
+	return DefaultType
+}
@@ -0,0 +1,82 @@
+package files
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("will "), 50000)
+	ref, file, err := store.Encrypt(bytes.NewReader(data), `C:\Scans\will.pdf`, "", int64(len(data)))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if file.Name != "will.pdf" || file.Type != "application/pdf" || file.Size != int64(len(data)) {
+		t.Errorf("Unexpected file %+v", file)
+	}
+
+	// Only ciphertext is written to disk
+	stored, _ := os.ReadFile(dir + "/" + ref)
+	if bytes.Contains(stored, []byte("will will")) {
+		t.Error("Expected the stored file to be encrypted")
+	}
+
+	content, err := Marshal(file)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	parsed, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	r, err := store.Decrypt(ref, parsed)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	decrypted, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Error("Decrypted file doesn't match")
+	}
+
+	// A file over the limit is not stored
+	if _, _, err := store.Encrypt(bytes.NewReader(data), "big.bin", "", int64(len(data))-1); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the first file to be stored, got %d entries", len(entries))
+	}
+
+	if _, err := Parse([]byte(`{"name":"x"}`)); err == nil {
+		t.Error("Expected an error for content without an identity")
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	tests := map[string]string{
+		"backup.kdbx":          "backup.kdbx",
+		"../../etc/passwd":     "passwd",
+		`C:\Users\me\seed.png`: "seed.png",
+		"evil\r\nname.txt":     "evilname.txt",
+		"":                     "file",
+		"/":                    "file",
+	}
+	for name, expected := range tests {
+		if got := CleanName(name); got != expected {
+			t.Errorf("CleanName(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
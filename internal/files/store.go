@@ -0,0 +1,116 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+// ErrInvalidRef is returned for a file reference that wasn't created by a Store
+var ErrInvalidRef = errors.New("invalid file reference")
+
+// Store keeps the encrypted files of file secrets in a directory. The
+// database only references them by name, see models.Secret.FileRef.
+type Store struct {
+	dir string
+}
+
+// NewStore creates the directory if needed and returns a store for it
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create files directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Upload is a file being written to a Store. It is written under a
+// temporary name and only shows up under its reference once committed.
+type Upload struct {
+	Ref  string
+	file *os.File
+	path string
+}
+
+// Create starts a new file with a random reference
+func (s *Store) Create() (*Upload, error) {
+	ref := uuid.New().String()
+	path := filepath.Join(s.dir, ref)
+
+	file, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	return &Upload{Ref: ref, file: file, path: path}, nil
+}
+
+// Write writes to the temporary file
+func (u *Upload) Write(p []byte) (int, error) {
+	return u.file.Write(p)
+}
+
+// Commit flushes the file to disk and moves it to its reference
+func (u *Upload) Commit() error {
+	if err := u.file.Sync(); err != nil {
+		u.Abort()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := u.file.Close(); err != nil {
+		u.Abort()
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := os.Rename(u.path+".tmp", u.path); err != nil {
+		u.Abort()
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	return nil
+}
+
+// Abort removes a file that won't be committed
+func (u *Upload) Abort() {
+	u.file.Close()
+	os.Remove(u.path + ".tmp")
+}
+
+// Open opens a stored file for reading, it is still encrypted
+func (s *Store) Open(ref string) (*os.File, error) {
+	path, err := s.path(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// Delete removes a stored file, a file that doesn't exist is not an error
+func (s *Store) Delete(ref string) error {
+	path, err := s.path(ref)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// path returns the path of a reference, only references made by Create are accepted
+func (s *Store) path(ref string) (string, error) {
+	if _, err := uuid.Parse(ref); err != nil || len(ref) != 36 {
+		return "", ErrInvalidRef
+	}
+	return filepath.Join(s.dir, ref), nil
+}
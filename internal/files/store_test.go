@@ -0,0 +1,73 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+func TestStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "files")
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+
+	upload, err := store.Create()
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if _, err := upload.Write([]byte("encrypted")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// The file only appears under its reference once committed
+	if _, err := store.Open(upload.Ref); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound before commit, got %v", err)
+	}
+	if err := upload.Commit(); err != nil {
+		t.Fatalf("Failed to commit file: %v", err)
+	}
+
+	file, err := store.Open(upload.Ref)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	data, _ := io.ReadAll(file)
+	file.Close()
+	if string(data) != "encrypted" {
+		t.Errorf("Expected %q, got %q", "encrypted", data)
+	}
+
+	if err := store.Delete(upload.Ref); err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+	if _, err := store.Open(upload.Ref); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.Delete(upload.Ref); err != nil {
+		t.Errorf("Deleting a missing file should not fail, got %v", err)
+	}
+
+	// An aborted upload leaves nothing behind
+	aborted, err := store.Create()
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	aborted.Write([]byte("partial"))
+	aborted.Abort()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected an empty directory, got %d entries", len(entries))
+	}
+
+	// References can't point outside the directory
+	for _, ref := range []string{"", "../db.sqlite", "/etc/passwd"} {
+		if _, err := store.Open(ref); !errors.Is(err, ErrInvalidRef) {
+			t.Errorf("Expected ErrInvalidRef for %q, got %v", ref, err)
+		}
+	}
+}
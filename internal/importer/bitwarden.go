@@ -0,0 +1,101 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// bitwardenLogin is the type of Bitwarden login items, the other types
+// (notes, cards, identities, SSH keys) become notes
+const bitwardenLogin = 1
+
+type bitwardenExport struct {
+	Encrypted bool `json:"encrypted"`
+	Folders   []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []struct {
+		Type     int    `json:"type"`
+		Name     string `json:"name"`
+		Notes    string `json:"notes"`
+		FolderID string `json:"folderId"`
+		Login    *struct {
+			URIs []struct {
+				URI string `json:"uri"`
+			} `json:"uris"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+			TOTP     string `json:"totp"`
+		} `json:"login"`
+		Card     map[string]interface{} `json:"card"`
+		Identity map[string]interface{} `json:"identity"`
+		SSHKey   map[string]interface{} `json:"sshKey"`
+		Fields   []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"fields"`
+	} `json:"items"`
+}
+
+// parseJSON reads a Bitwarden JSON export, or the export.data of a 1PUX
+// export that was unpacked first
+func parseJSON(data []byte) (*Result, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+
+	switch {
+	case probe["accounts"] != nil:
+		return parse1PasswordData(data)
+	case probe["items"] != nil || probe["encrypted"] != nil:
+		return parseBitwarden(data)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+func parseBitwarden(data []byte) (*Result, error) {
+	var export bitwardenExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("failed to read Bitwarden export: %w", err)
+	}
+	if export.Encrypted {
+		return nil, ErrEncryptedExport
+	}
+
+	folders := make(map[string]string, len(export.Folders))
+	for _, folder := range export.Folders {
+		folders[folder.ID] = folder.Name
+	}
+
+	items := make([]*item, 0, len(export.Items))
+	for _, bw := range export.Items {
+		it := &item{name: bw.Name, folder: folders[bw.FolderID], notes: bw.Notes}
+
+		if bw.Type == bitwardenLogin && bw.Login != nil {
+			it.username = bw.Login.Username
+			it.password = bw.Login.Password
+			it.totp = bw.Login.TOTP
+			for i, uri := range bw.Login.URIs {
+				if i == 0 {
+					it.url = uri.URI
+				} else {
+					it.add("Website", uri.URI)
+				}
+			}
+		}
+
+		it.addMap(bw.Card)
+		it.addMap(bw.Identity)
+		it.addMap(bw.SSHKey)
+		for _, field := range bw.Fields {
+			it.add(field.Name, field.Value)
+		}
+
+		items = append(items, it)
+	}
+
+	return &Result{Format: FormatBitwarden, Entries: entries(items)}, nil
+}
@@ -0,0 +1,126 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvColumns maps the column names used by the CSV exports of 1Password,
+// Bitwarden, KeePass and most browsers to the fields of an entry
+var csvColumns = map[string]string{
+	"title":             "name",
+	"name":              "name",
+	"account":           "name",
+	"entry":             "name",
+	"url":               "url",
+	"website":           "url",
+	"web site":          "url",
+	"login_uri":         "url",
+	"uri":               "url",
+	"username":          "username",
+	"user name":         "username",
+	"login name":        "username",
+	"login_username":    "username",
+	"login":             "username",
+	"password":          "password",
+	"login_password":    "password",
+	"totp":              "totp",
+	"otp":               "totp",
+	"otpauth":           "totp",
+	"one-time password": "totp",
+	"login_totp":        "totp",
+	"notes":             "notes",
+	"comments":          "notes",
+	"notesplain":        "notes",
+	"note":              "notes",
+	"group":             "folder",
+	"folder":            "folder",
+	"vault":             "folder",
+}
+
+// csvIgnored are columns with metadata about an entry rather than its content
+var csvIgnored = map[string]bool{
+	"favorite":      true,
+	"archived":      true,
+	"icon":          true,
+	"last modified": true,
+	"created":       true,
+	"tags":          true,
+	"type":          true,
+	"reprompt":      true,
+	"fields":        true,
+}
+
+// parseCSV reads a CSV export with a header row
+func parseCSV(data []byte) (*Result, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV export: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	known := 0
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if field, ok := csvColumns[name]; ok {
+			columns[i] = field
+			known++
+		} else if !csvIgnored[name] {
+			columns[i] = "extra"
+		}
+	}
+	// Without a single known column this is not an export with a header
+	if known == 0 {
+		return nil, ErrUnsupportedFormat
+	}
+
+	var items []*item
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV export: %w", err)
+		}
+		if len(items) > MaxEntries {
+			return nil, ErrTooManyEntries
+		}
+
+		it := &item{}
+		for i, value := range record {
+			if i >= len(columns) {
+				break
+			}
+			switch columns[i] {
+			case "name":
+				it.name = value
+			case "url":
+				it.url = value
+			case "username":
+				it.username = value
+			case "password":
+				it.password = value
+			case "totp":
+				it.totp = value
+			case "notes":
+				it.notes = value
+			case "folder":
+				it.folder = value
+			case "extra":
+				it.add(header[i], value)
+			}
+		}
+		items = append(items, it)
+	}
+
+	return &Result{Format: FormatCSV, Entries: entries(items)}, nil
+}
@@ -0,0 +1,222 @@
+// Package importer reads the exports of password managers, so their entries
+// can be brought in as secrets. Exports are parsed in memory and never
+// written to disk. Logins become website login secrets, everything else
+// becomes a note with the fields of the entry.
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
+)
+
+// Formats of the exports that can be imported
+const (
+	FormatBitwarden = "Bitwarden JSON"
+	Format1PUX      = "1Password 1PUX"
+	FormatKeePass   = "KeePass XML"
+	FormatCSV       = "CSV"
+)
+
+// MaxEntries is the largest number of entries read from one export
+const MaxEntries = 5000
+
+var (
+	// ErrUnsupportedFormat is returned for a file that isn't a known export
+	ErrUnsupportedFormat = errors.New("unsupported export format, use a Bitwarden JSON, 1Password 1PUX or CSV, KeePass XML or CSV export")
+	// ErrEncryptedExport is returned for a password protected export
+	ErrEncryptedExport = errors.New("the export is encrypted, export the vault again without a password")
+	// ErrNoEntries is returned for an export without entries
+	ErrNoEntries = errors.New("the export has no entries")
+	// ErrTooManyEntries is returned for an export with more than MaxEntries entries
+	ErrTooManyEntries = fmt.Errorf("the export has more than %d entries", MaxEntries)
+)
+
+// Entry is an entry of an export, ready to be stored as a secret
+type Entry struct {
+	Name   string             `json:"name"`
+	Folder string             `json:"folder,omitempty"`
+	Type   string             `json:"type"`
+	Values secrettypes.Values `json:"values"`
+}
+
+// URL returns the web address of a login entry
+func (e *Entry) URL() string {
+	return e.Values["url"]
+}
+
+// Username returns the username of a login entry
+func (e *Entry) Username() string {
+	return e.Values["username"]
+}
+
+// Content returns the content of the secret for the entry
+func (e *Entry) Content() ([]byte, error) {
+	typ, err := secrettypes.Lookup(e.Type)
+	if err != nil {
+		return nil, err
+	}
+	return typ.Encode(e.Values)
+}
+
+// Result is a parsed export
+type Result struct {
+	Format  string
+	Entries []*Entry
+}
+
+// Parse detects the format of an export and reads its entries
+func Parse(data []byte) (*Result, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimSpace(data)
+
+	var result *Result
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		result, err = parse1PUX(data)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		result, err = parseJSON(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		result, err = parseKeePassXML(trimmed)
+	case len(trimmed) > 0:
+		result, err = parseCSV(data)
+	default:
+		return nil, ErrNoEntries
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Entries) == 0 {
+		return nil, ErrNoEntries
+	}
+	if len(result.Entries) > MaxEntries {
+		return nil, ErrTooManyEntries
+	}
+	return result, nil
+}
+
+// item collects the fields of an entry while an export is read
+type item struct {
+	name     string
+	folder   string
+	url      string
+	username string
+	password string
+	totp     string
+	notes    string
+	extra    [][2]string // Other fields, label and value
+}
+
+// add keeps a field that has no place of its own
+func (it *item) add(label, value string) {
+	label, value = strings.TrimSpace(label), strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	if label == "" {
+		label = "Field"
+	}
+	it.extra = append(it.extra, [2]string{label, value})
+}
+
+// addMap keeps the string fields of an object, sorted by name
+func (it *item) addMap(fields map[string]interface{}) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch value := fields[name].(type) {
+		case string:
+			it.add(name, value)
+		case float64:
+			it.add(name, fmt.Sprint(value))
+		}
+	}
+}
+
+// entry turns the item into a login secret if it is a complete login, and
+// into a note with all its fields otherwise
+func (it *item) entry() *Entry {
+	name := strings.TrimSpace(it.name)
+	if name == "" {
+		name = hostName(it.url)
+	}
+	if name == "" {
+		name = "Imported entry"
+	}
+
+	notes := strings.TrimSpace(it.notes)
+	var extra strings.Builder
+	for _, field := range it.extra {
+		fmt.Fprintf(&extra, "%s: %s\n", field[0], field[1])
+	}
+	if extra.Len() > 0 {
+		if notes != "" {
+			notes += "\n\n"
+		}
+		notes += strings.TrimSuffix(extra.String(), "\n")
+	}
+
+	values := secrettypes.Values{
+		"url":      it.url,
+		"username": it.username,
+		"password": it.password,
+		"totp":     it.totp,
+		"notes":    notes,
+	}
+
+	login, _ := secrettypes.Lookup(secrettypes.Login)
+	if it.url != "" || it.username != "" || it.password != "" || it.totp != "" {
+		if validated, err := login.Validate(values); err == nil {
+			return &Entry{Name: name, Folder: it.folder, Type: secrettypes.Login, Values: validated}
+		}
+
+		// A login without a web address or with a seed that can't be read keeps all its fields as text
+		notes = login.Format(values)
+	}
+
+	return &Entry{
+		Name:   name,
+		Folder: it.folder,
+		Type:   secrettypes.Note,
+		Values: secrettypes.Values{"content": notes},
+	}
+}
+
+// hostName returns the host of a web address, to name entries without a name
+func hostName(address string) string {
+	if address == "" {
+		return ""
+	}
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// entries turns the items that have any content into entries
+func entries(items []*item) []*Entry {
+	result := make([]*Entry, 0, len(items))
+	for _, it := range items {
+		e := it.entry()
+		if e.Type == secrettypes.Note && strings.TrimSpace(e.Values["content"]) == "" {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
@@ -0,0 +1,237 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
+)
+
+const testSeed = "JBSWY3DPEHPK3PXP"
+
+const bitwardenSample = `{
+  "encrypted": false,
+  "folders": [{"id": "f1", "name": "Banking"}],
+  "items": [
+    {
+      "type": 1, "name": "Example", "folderId": "f1", "notes": "main account",
+      "login": {
+        "uris": [{"uri": "https://example.com/login"}, {"uri": "https://m.example.com"}],
+        "username": "alice", "password": "hunter2", "totp": "` + testSeed + `"
+      },
+      "fields": [{"name": "PIN", "value": "1234"}]
+    },
+    {"type": 2, "name": "Safe combination", "notes": "12-34-56"},
+    {"type": 3, "name": "Visa", "card": {"cardholderName": "Alice", "number": "4111111111111111", "code": "123"}},
+    {"type": 2, "name": "Empty note", "notes": ""}
+  ]
+}`
+
+func TestParseBitwarden(t *testing.T) {
+	result, err := Parse([]byte(bitwardenSample))
+	if err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+	if result.Format != FormatBitwarden || len(result.Entries) != 3 {
+		t.Fatalf("Expected 3 Bitwarden entries, got %s with %d", result.Format, len(result.Entries))
+	}
+
+	login := result.Entries[0]
+	if login.Type != secrettypes.Login || login.Folder != "Banking" {
+		t.Errorf("Expected a login in Banking, got %s in %q", login.Type, login.Folder)
+	}
+	if login.URL() != "https://example.com/login" || login.Username() != "alice" || login.Values["password"] != "hunter2" || login.Values["totp"] != testSeed {
+		t.Errorf("Unexpected login values: %v", login.Values)
+	}
+	if !strings.Contains(login.Values["notes"], "main account") || !strings.Contains(login.Values["notes"], "Website: https://m.example.com") || !strings.Contains(login.Values["notes"], "PIN: 1234") {
+		t.Errorf("Expected the notes to keep the other fields, got %q", login.Values["notes"])
+	}
+
+	if note := result.Entries[1]; note.Type != secrettypes.Note || note.Values["content"] != "12-34-56" {
+		t.Errorf("Expected a note, got %s %v", note.Type, note.Values)
+	}
+	if card := result.Entries[2]; card.Type != secrettypes.Note || !strings.Contains(card.Values["content"], "number: 4111111111111111") {
+		t.Errorf("Expected the card as a note, got %v", card.Values)
+	}
+
+	content, err := login.Content()
+	if err != nil || !bytes.Contains(content, []byte(`"username":"alice"`)) {
+		t.Errorf("Expected the login to be encoded, got %s %v", content, err)
+	}
+
+	if _, err := Parse([]byte(`{"encrypted": true, "encKeyValidation_DO_NOT_EDIT": "x", "data": "x"}`)); !errors.Is(err, ErrEncryptedExport) {
+		t.Errorf("Expected an encrypted export error, got %v", err)
+	}
+}
+
+const onePasswordSample = `{
+  "accounts": [{
+    "vaults": [{
+      "attrs": {"name": "Private"},
+      "items": [
+        {
+          "state": "active",
+          "overview": {"title": "GitHub", "url": "https://github.com"},
+          "details": {
+            "loginFields": [
+              {"designation": "username", "name": "login", "value": "octocat"},
+              {"designation": "password", "name": "password", "value": "s3cret"}
+            ],
+            "notesPlain": "work",
+            "sections": [{"title": "", "fields": [{"title": "one-time password", "value": {"totp": "otpauth://totp/GitHub?secret=` + testSeed + `"}}]}]
+          }
+        },
+        {
+          "state": "archived",
+          "overview": {"title": "Home"},
+          "details": {
+            "sections": [{"title": "Address", "fields": [{"title": "street", "value": {"address": {"street": "1 Main St", "city": "Springfield"}}}]}]
+          }
+        }
+      ]
+    }]
+  }]
+}`
+
+func TestParse1PUX(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("export.attributes")
+	w.Write([]byte(`{"version": 3}`))
+	w, _ = zw.Create("export.data")
+	w.Write([]byte(onePasswordSample))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	result, err := Parse(archive.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+	if result.Format != Format1PUX || len(result.Entries) != 2 {
+		t.Fatalf("Expected 2 1PUX entries, got %s with %d", result.Format, len(result.Entries))
+	}
+
+	login := result.Entries[0]
+	if login.Type != secrettypes.Login || login.Folder != "Private" || login.Username() != "octocat" || login.Values["password"] != "s3cret" {
+		t.Errorf("Unexpected login: %s %q %v", login.Type, login.Folder, login.Values)
+	}
+	if login.Values["totp"] != testSeed {
+		t.Errorf("Expected the seed of the TOTP URI, got %q", login.Values["totp"])
+	}
+
+	home := result.Entries[1]
+	if home.Folder != "Private (archived)" || home.Values["content"] != "Address street: Springfield, 1 Main St" {
+		t.Errorf("Unexpected archived entry: %q %v", home.Folder, home.Values)
+	}
+
+	// The export.data can be uploaded on its own too
+	if result, err := Parse([]byte(onePasswordSample)); err != nil || result.Format != Format1PUX {
+		t.Errorf("Expected the unpacked export.data to be read, got %v", err)
+	}
+}
+
+const keePassSample = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+  <Meta><RecycleBinUUID>bin</RecycleBinUUID></Meta>
+  <Root>
+    <Group>
+      <UUID>root</UUID><Name>Database</Name>
+      <Entry>
+        <String><Key>Title</Key><Value>Router</Value></String>
+        <String><Key>UserName</Key><Value>admin</Value></String>
+        <String><Key>Password</Key><Value ProtectMemory="True">changeme</Value></String>
+        <String><Key>Serial</Key><Value>AB-12</Value></String>
+      </Entry>
+      <Group>
+        <UUID>web</UUID><Name>Internet</Name>
+        <Group>
+          <UUID>mail</UUID><Name>Mail</Name>
+          <Entry>
+            <String><Key>Title</Key><Value>Mailbox</Value></String>
+            <String><Key>URL</Key><Value>mail.example.com</Value></String>
+            <String><Key>UserName</Key><Value>bob</Value></String>
+            <String><Key>Password</Key><Value>pw</Value></String>
+            <String><Key>otp</Key><Value>otpauth://totp/Mail?secret=` + testSeed + `</Value></String>
+            <History>
+              <Entry><String><Key>Password</Key><Value>old</Value></String></Entry>
+            </History>
+          </Entry>
+        </Group>
+      </Group>
+      <Group>
+        <UUID>bin</UUID><Name>Recycle Bin</Name>
+        <Entry><String><Key>Title</Key><Value>Deleted</Value></String><String><Key>Notes</Key><Value>gone</Value></String></Entry>
+      </Group>
+    </Group>
+  </Root>
+</KeePassFile>`
+
+func TestParseKeePassXML(t *testing.T) {
+	result, err := Parse([]byte(keePassSample))
+	if err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+	if result.Format != FormatKeePass || len(result.Entries) != 2 {
+		t.Fatalf("Expected 2 KeePass entries, got %s with %d", result.Format, len(result.Entries))
+	}
+
+	// A login without a web address is kept as a note with its fields
+	router := result.Entries[0]
+	if router.Type != secrettypes.Note || router.Folder != "" {
+		t.Errorf("Expected the router as a note at the top, got %s in %q", router.Type, router.Folder)
+	}
+	for _, want := range []string{"Username: admin", "Password: changeme", "Serial: AB-12"} {
+		if !strings.Contains(router.Values["content"], want) {
+			t.Errorf("Expected %q in %q", want, router.Values["content"])
+		}
+	}
+
+	mail := result.Entries[1]
+	if mail.Type != secrettypes.Login || mail.Folder != "Internet/Mail" || mail.URL() != "https://mail.example.com" || mail.Values["password"] != "pw" {
+		t.Errorf("Unexpected mail entry: %s %q %v", mail.Type, mail.Folder, mail.Values)
+	}
+
+	if _, err := Parse([]byte(`<html><body>nope</body></html>`)); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected an unsupported format error, got %v", err)
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := map[string]string{
+		"1Password": "Title,Url,Username,Password,OTPAuth,Favorite,Archived,Tags,Notes\n" +
+			"Shop,https://shop.example.com,carol,pw1,,false,false,,\"line one\nline two\"\n",
+		"Bitwarden": "folder,favorite,type,name,notes,fields,reprompt,login_uri,login_username,login_password,login_totp\n" +
+			",,login,Shop,\"line one\nline two\",,0,https://shop.example.com,carol,pw1,\n",
+		"KeePass": "\xef\xbb\xbf\"Group\",\"Title\",\"Username\",\"Password\",\"URL\",\"Notes\",\"TOTP\",\"Icon\",\"Last Modified\",\"Created\"\n" +
+			"\"Root\",\"Shop\",\"carol\",\"pw1\",\"https://shop.example.com\",\"line one\nline two\",\"\",\"0\",\"2024-01-01\",\"2024-01-01\"\n",
+	}
+	for name, export := range tests {
+		result, err := Parse([]byte(export))
+		if err != nil {
+			t.Errorf("%s: failed to parse export: %v", name, err)
+			continue
+		}
+		if result.Format != FormatCSV || len(result.Entries) != 1 {
+			t.Errorf("%s: expected 1 CSV entry, got %s with %d", name, result.Format, len(result.Entries))
+			continue
+		}
+		entry := result.Entries[0]
+		if entry.Name != "Shop" || entry.Type != secrettypes.Login || entry.Username() != "carol" || entry.Values["password"] != "pw1" || entry.Values["notes"] != "line one\nline two" {
+			t.Errorf("%s: unexpected entry %q %s %v", name, entry.Name, entry.Type, entry.Values)
+		}
+	}
+
+	if _, err := Parse([]byte("a,b,c\n1,2,3\n")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected an unsupported format error, got %v", err)
+	}
+	if _, err := Parse([]byte("title,notes\n")); !errors.Is(err, ErrNoEntries) {
+		t.Errorf("Expected a no entries error, got %v", err)
+	}
+	if _, err := Parse([]byte("title,notes\n" + strings.Repeat("x,y\n", MaxEntries+1))); !errors.Is(err, ErrTooManyEntries) {
+		t.Errorf("Expected a too many entries error, got %v", err)
+	}
+}
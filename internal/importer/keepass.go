@@ -0,0 +1,97 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+type keePassFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    struct {
+		RecycleBinUUID string `xml:"RecycleBinUUID"`
+	} `xml:"Meta"`
+	Root struct {
+		Groups []keePassGroup `xml:"Group"`
+	} `xml:"Root"`
+}
+
+type keePassGroup struct {
+	UUID    string         `xml:"UUID"`
+	Name    string         `xml:"Name"`
+	Entries []keePassEntry `xml:"Entry"`
+	Groups  []keePassGroup `xml:"Group"`
+}
+
+// keePassEntry is an entry of a group. Its former versions in History are
+// not read, only the current one is imported.
+type keePassEntry struct {
+	Strings []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"String"`
+}
+
+// parseKeePassXML reads a KeePass 2 XML export. Entries in the recycle bin
+// are left out.
+func parseKeePassXML(data []byte) (*Result, error) {
+	var file keePassFile
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&file); err != nil {
+		if strings.Contains(err.Error(), "expected element type <KeePassFile>") {
+			return nil, ErrUnsupportedFormat
+		}
+		return nil, fmt.Errorf("failed to read KeePass export: %w", err)
+	}
+
+	var items []*item
+	var walk func(group keePassGroup, path []string)
+	walk = func(group keePassGroup, path []string) {
+		if file.Meta.RecycleBinUUID != "" && group.UUID == file.Meta.RecycleBinUUID {
+			return
+		}
+
+		folder := strings.Join(path, "/")
+		for _, entry := range group.Entries {
+			items = append(items, keePassItem(entry, folder))
+		}
+		for _, child := range group.Groups {
+			walk(child, append(path[:len(path):len(path)], child.Name))
+		}
+	}
+
+	// The top group is the database itself, folders start below it
+	for _, group := range file.Root.Groups {
+		walk(group, nil)
+	}
+
+	return &Result{Format: FormatKeePass, Entries: entries(items)}, nil
+}
+
+func keePassItem(entry keePassEntry, folder string) *item {
+	it := &item{folder: folder}
+	for _, s := range entry.Strings {
+		switch s.Key {
+		case "Title":
+			it.name = s.Value
+		case "UserName":
+			it.username = s.Value
+		case "Password":
+			it.password = s.Value
+		case "URL":
+			it.url = s.Value
+		case "Notes":
+			it.notes = s.Value
+		case "otp", "TOTP Seed", "TimeOtp-Secret-Base32":
+			if it.totp == "" {
+				it.totp = s.Value
+			}
+		case "TOTP Settings", "TimeOtp-Length", "TimeOtp-Period", "TimeOtp-Algorithm":
+			// Settings of the one-time codes, the seed is what matters
+		default:
+			it.add(s.Key, s.Value)
+		}
+	}
+	return it
+}
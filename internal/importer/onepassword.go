@@ -0,0 +1,177 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// max1PUXData limits how much of the export.data of a 1PUX archive is
+// unpacked, so a crafted archive can't exhaust memory
+const max1PUXData = 64 << 20
+
+type onePasswordExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []onePasswordItem `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+type onePasswordItem struct {
+	State    string `json:"state"`
+	Overview struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+		URLs  []struct {
+			URL string `json:"url"`
+		} `json:"urls"`
+	} `json:"overview"`
+	Details struct {
+		LoginFields []struct {
+			Name        string `json:"name"`
+			Value       string `json:"value"`
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		NotesPlain string `json:"notesPlain"`
+		Password   string `json:"password"`
+		Sections   []struct {
+			Title  string `json:"title"`
+			Fields []struct {
+				Title string                 `json:"title"`
+				Value map[string]interface{} `json:"value"`
+			} `json:"fields"`
+		} `json:"sections"`
+	} `json:"details"`
+}
+
+// parse1PUX reads a 1PUX export, a zip archive with the items in export.data
+func parse1PUX(data []byte) (*Result, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read 1PUX export: %w", err)
+	}
+
+	for _, file := range archive.File {
+		if file.Name != "export.data" {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read 1PUX export: %w", err)
+		}
+		exportData, err := io.ReadAll(io.LimitReader(reader, max1PUXData+1))
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read 1PUX export: %w", err)
+		}
+		if len(exportData) > max1PUXData {
+			return nil, fmt.Errorf("failed to read 1PUX export: export.data is larger than %d bytes", max1PUXData)
+		}
+		return parse1PasswordData(exportData)
+	}
+
+	return nil, fmt.Errorf("%w: the archive has no export.data", ErrUnsupportedFormat)
+}
+
+// parse1PasswordData reads the export.data of a 1PUX export
+func parse1PasswordData(data []byte) (*Result, error) {
+	var export onePasswordExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("failed to read 1PUX export: %w", err)
+	}
+
+	var items []*item
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			for _, op := range vault.Items {
+				folder := vault.Attrs.Name
+				if op.State == "archived" {
+					folder = strings.TrimSpace(folder + " (archived)")
+				}
+				items = append(items, onePasswordEntry(op, folder))
+			}
+		}
+	}
+
+	return &Result{Format: Format1PUX, Entries: entries(items)}, nil
+}
+
+func onePasswordEntry(op onePasswordItem, folder string) *item {
+	it := &item{name: op.Overview.Title, folder: folder, url: op.Overview.URL, notes: op.Details.NotesPlain}
+
+	for _, u := range op.Overview.URLs {
+		if u.URL != it.url {
+			it.add("Website", u.URL)
+		}
+	}
+
+	for _, field := range op.Details.LoginFields {
+		switch {
+		case field.Designation == "username" && it.username == "":
+			it.username = field.Value
+		case field.Designation == "password" && it.password == "":
+			it.password = field.Value
+		default:
+			it.add(field.Name, field.Value)
+		}
+	}
+	// Password items keep the password outside the login fields
+	if it.password == "" {
+		it.password = op.Details.Password
+	}
+
+	for _, section := range op.Details.Sections {
+		for _, field := range section.Fields {
+			if totp, ok := field.Value["totp"].(string); ok && it.totp == "" {
+				it.totp = totp
+				continue
+			}
+
+			label := field.Title
+			if section.Title != "" {
+				label = section.Title + " " + label
+			}
+			it.add(label, onePasswordValue(field.Value))
+		}
+	}
+
+	return it
+}
+
+// onePasswordValue returns the value of a section field, which is an
+// object with a single key naming the kind of value
+func onePasswordValue(value map[string]interface{}) string {
+	for _, v := range value {
+		switch v := v.(type) {
+		case string:
+			return v
+		case float64:
+			return fmt.Sprint(v)
+		case map[string]interface{}:
+			// Addresses and similar values are objects of strings
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			var parts []string
+			for _, key := range keys {
+				if s, ok := v[key].(string); ok && s != "" {
+					parts = append(parts, s)
+				}
+			}
+			return strings.Join(parts, ", ")
+		}
+	}
+	return ""
+}
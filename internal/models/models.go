@@ -20,10 +20,15 @@ type User struct {
 	PingingEnabled    bool      `json:"pinging_enabled"`
 	PingMethod        string    `json:"ping_method"` // "telegram", "email", or "both"
 	NextScheduledPing time.Time `json:"next_scheduled_ping"`
+	// EmergencyAccessDays is how long a recipient's emergency access request waits for a denial, 0 disables them
+	EmergencyAccessDays int `json:"emergency_access_days"`
 	// 2FA fields
 	TOTPSecret   string `json:"totp_secret,omitempty"` // Secret for TOTP-based 2FA
 	TOTPEnabled  bool   `json:"totp_enabled"`          // Whether 2FA is enabled
 	TOTPVerified bool   `json:"totp_verified"`         // Whether 2FA has been verified
+	// Vault key fields
+	VaultKeySalt      string `json:"-"` // Base64 salt for deriving the key-encryption key from the password
+	EncryptedVaultKey string `json:"-"` // Base64 vault key wrapped with the key-encryption key
 }
 
 // Secret represents an encrypted secret note
@@ -35,8 +40,76 @@ type Secret struct {
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 	EncryptionType string    `json:"encryption_type"` // e.g., "aes-256-gcm"
+	// Quorum protection fields
+	QuorumThreshold int    `json:"quorum_threshold"` // Number of recipients needed to open the secret, 0 if not quorum protected
+	QuorumData      string `json:"-"`                // Secret content encrypted with the key that was split among recipients
+	// File secret fields
+	FileRef  string `json:"-"`         // Name of the encrypted file in the file store, empty for text secrets
+	FileSize int64  `json:"file_size"` // Size of the plaintext file in bytes
+	// Type of a text secret, see package secrettypes. Empty or "note" for free-form text.
+	Type string `json:"type"`
 }
 
+// IsQuorumProtected reports whether the secret needs several recipients to open it
+func (s *Secret) IsQuorumProtected() bool {
+	return s.QuorumThreshold > 0
+}
+
+// IsFile reports whether the secret is an uploaded file. Its content is a
+// SecretFile that holds the key of the encrypted file.
+func (s *Secret) IsFile() bool {
+	return s.FileRef != ""
+}
+
+// SecretFile is the content of a file secret. The file itself is stored
+// encrypted to an age identity that is only kept here, so it is protected
+// exactly like the content of a text secret.
+type SecretFile struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Size     int64  `json:"size"`
+	Identity string `json:"identity"`
+}
+
+// SecretVersion is an earlier revision of a secret, kept when its name or
+// content changes. The content stays encrypted exactly as it was stored.
+type SecretVersion struct {
+	ID             string    `json:"id"`
+	SecretID       string    `json:"secret_id"`
+	UserID         string    `json:"user_id"`
+	Name           string    `json:"name"`
+	EncryptedData  string    `json:"-"`
+	EncryptionType string    `json:"encryption_type"`
+	FileRef        string    `json:"-"` // File of a file secret, kept until the revision is pruned
+	FileSize       int64     `json:"file_size"`
+	SavedAt        time.Time `json:"saved_at"`   // When the revision was saved
+	CreatedAt      time.Time `json:"created_at"` // When it was replaced by a newer one
+}
+
+// IsFile reports whether the revision is of a file secret
+func (v *SecretVersion) IsFile() bool {
+	return v.FileRef != ""
+}
+
+// IsClientEncrypted reports whether the revision was encrypted in the owner's browser
+func (v *SecretVersion) IsClientEncrypted() bool {
+	return v.EncryptionType == EncryptionTypeClient
+}
+
+// IsClientEncrypted reports whether the secret was encrypted in the owner's browser
+func (s *Secret) IsClientEncrypted() bool {
+	return s.EncryptionType == EncryptionTypeClient
+}
+
+const (
+	// EncryptionTypeLegacy marks secrets encrypted with the old hardcoded demo key
+	EncryptionTypeLegacy = "aes-256-gcm"
+	// EncryptionTypeVault marks secrets encrypted with the owner's vault key
+	EncryptionTypeVault = "aes-256-gcm-vault"
+	// EncryptionTypeClient marks secrets encrypted in the owner's browser, the server only has the envelope
+	EncryptionTypeClient = "aes-256-gcm-client"
+)
+
 // Recipient represents someone who will receive secrets
 type Recipient struct {
 	ID                 string     `json:"id"`
@@ -51,6 +124,49 @@ type Recipient struct {
 	ConfirmedAt        *time.Time `json:"confirmed_at,omitempty"`
 	ConfirmationCode   string     `json:"confirmation_code,omitempty"`
 	ConfirmationSentAt *time.Time `json:"confirmation_sent_at,omitempty"`
+	ReleaseDelayDays   int        `json:"release_delay_days"`   // Days after the switch fires before this recipient's secrets are released
+	PublicKey          string     `json:"public_key,omitempty"` // age recipient or OpenPGP key the recipient's copies are encrypted to
+	// Personal question fields
+	QuestionThreshold int    `json:"question_threshold"` // Number of questions the recipient must answer, 0 if none are set
+	QuestionKey       string `json:"-"`                  // age recipient the copies are encrypted to, its identity is locked with the answers
+	QuestionBundle    string `json:"-"`                  // Questions and locked shares encrypted with the owner's vault key, used to renew their timelock
+}
+
+// HasQuestions reports whether the recipient must answer personal questions to open their copies
+func (r *Recipient) HasQuestions() bool {
+	return r.QuestionThreshold > 0
+}
+
+// MaxReleaseDelayDays is the longest a recipient's release can be delayed after the switch fires
+const MaxReleaseDelayDays = 365
+
+// RecipientQuestion is a personal question a recipient must answer. The
+// answer is never stored, only a share of the recipient's question key that
+// is encrypted with it.
+type RecipientQuestion struct {
+	ID          string    `json:"id"`
+	RecipientID string    `json:"recipient_id"`
+	UserID      string    `json:"user_id"`
+	Position    int       `json:"position"`
+	Question    string    `json:"question"`
+	LockedShare string    `json:"-"` // Share of the question key encrypted with a key derived from the answer
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// MaxRecipientQuestions is the largest number of questions a recipient can be asked
+const MaxRecipientQuestions = 10
+
+// QuestionTimelock is a copy of a recipient's questions and locked shares that
+// is timelocked to a round of the beacon. Nobody can read it before the round
+// is published, so the shares can't be attacked offline before the switch fires.
+type QuestionTimelock struct {
+	ID          string    `json:"id"`
+	RecipientID string    `json:"recipient_id"`
+	UserID      string    `json:"user_id"`
+	Round       uint64    `json:"round"`
+	OpensAt     time.Time `json:"opens_at"` // When the round is expected to be published
+	Data        string    `json:"-"`        // Base64 timelocked bundle
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 // SecretAssignment links secrets to recipients
@@ -61,6 +177,18 @@ type SecretAssignment struct {
 	UserID      string    `json:"user_id"` // The user who created this assignment
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// DeliveryData is the material released to the recipient, sealed with the server master key
+	DeliveryData string `json:"-"`
+}
+
+// ShareSubmission records a recipient's share of a quorum protected secret
+type ShareSubmission struct {
+	ID          string    `json:"id"`
+	SecretID    string    `json:"secret_id"`
+	RecipientID string    `json:"recipient_id"`
+	ShareIndex  int       `json:"share_index"`
+	SealedShare string    `json:"-"` // Share sealed with the server master key
+	SubmittedAt time.Time `json:"submitted_at"`
 }
 
 // PingHistory records all pings sent to a user
@@ -83,14 +211,87 @@ type PingVerification struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Delivery event statuses
+const (
+	DeliveryStatusScheduled = "scheduled" // Waiting for the release delay of the recipient
+	DeliveryStatusPending   = "pending"   // Queued, not attempted yet
+	DeliveryStatusRetrying  = "retrying"  // The last attempt failed, another one is scheduled
+	DeliveryStatusSent      = "sent"      // The delivery email was sent
+	DeliveryStatusFailed    = "failed"    // Gave up after the retry horizon
+	DeliveryStatusViewed    = "viewed"    // The recipient opened the access link
+	DeliveryStatusCancelled = "cancelled" // The owner came back before the release
+)
+
+// Delivery run statuses
+const (
+	DeliveryRunStatusArmed      = "armed"       // In the grace period, the owner can still abort
+	DeliveryRunStatusAborted    = "aborted"     // The owner aborted the trigger during the grace period
+	DeliveryRunStatusInProgress = "in_progress" // Some deliveries of the run are still queued
+	DeliveryRunStatusCompleted  = "completed"   // Every delivery of the run was sent, given up or cancelled
+	DeliveryRunStatusRecovered  = "recovered"   // The owner revoked the release afterwards and re-armed the switch
+)
+
+// DeliveryRun is a single trigger of a user's switch. It has a delivery event per
+// recipient, so a restarted server resumes only the recipients that are unfinished.
+type DeliveryRun struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Status      string     `json:"status"`
+	StartedAt   time.Time  `json:"started_at"`
+	FiresAt     time.Time  `json:"fires_at"`                // End of the grace period
+	AbortCode   string     `json:"-"`                       // Code of the one-click abort link
+	LastAlertAt *time.Time `json:"last_alert_at,omitempty"` // Last time the owner was alerted during the grace period
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
 // DeliveryEvent records when secrets are delivered to recipients
 type DeliveryEvent struct {
-	ID           string    `json:"id"`
-	UserID       string    `json:"user_id"`
-	RecipientID  string    `json:"recipient_id"`
-	SentAt       time.Time `json:"sent_at"`
-	Status       string    `json:"status"` // "sent", "delivered", "failed"
-	ErrorMessage string    `json:"error_message,omitempty"`
+	ID            string     `json:"id"`
+	UserID        string     `json:"user_id"`
+	RunID         string     `json:"run_id,omitempty"`
+	RecipientID   string     `json:"recipient_id"`
+	SentAt        time.Time  `json:"sent_at"` // When the delivery was queued, or is released if it is delayed
+	Status        string     `json:"status"`  // "scheduled", "pending", "retrying", "sent", "delivered", "failed", "viewed", "cancelled"
+	ErrorMessage  string     `json:"error_message,omitempty"`
+	Attempts      int        `json:"attempts"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // NULL once the event is sent or given up
+}
+
+// IsQueued reports whether the delivery event still has an attempt ahead of it
+func (e *DeliveryEvent) IsQueued() bool {
+	return e.Status == DeliveryStatusScheduled || e.Status == DeliveryStatusPending || e.Status == DeliveryStatusRetrying
+}
+
+// DeliveryAttempt records a single try at sending a delivery event
+type DeliveryAttempt struct {
+	ID              string    `json:"id"`
+	DeliveryEventID string    `json:"delivery_event_id"`
+	AttemptedAt     time.Time `json:"attempted_at"`
+	Success         bool      `json:"success"`
+	ErrorMessage    string    `json:"error_message,omitempty"`
+}
+
+// Emergency access request statuses
+const (
+	EmergencyAccessStatusUnverified = "unverified" // The recipient hasn't confirmed the request from their inbox yet
+	EmergencyAccessStatusPending    = "pending"    // The owner was notified and can deny until the waiting period is over
+	EmergencyAccessStatusDenied     = "denied"     // The owner denied the request
+	EmergencyAccessStatusGranted    = "granted"    // The waiting period passed and the recipient's secrets were released
+)
+
+// EmergencyAccessRequest is a recipient's claim that the owner is incapacitated. Unless the
+// owner denies it within their waiting period, the secrets of that recipient are released.
+type EmergencyAccessRequest struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	RecipientID string     `json:"recipient_id"`
+	Status      string     `json:"status"`
+	VerifyCode  string     `json:"-"` // Code of the link that confirms the request from the recipient's inbox
+	DenyCode    string     `json:"-"` // Code of the owner's one-click deny link
+	RequestedAt time.Time  `json:"requested_at"`
+	ReleasesAt  *time.Time `json:"releases_at,omitempty"` // Set once the recipient confirmed the request
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
 }
 
 // AuditLog stores important security events
@@ -129,3 +330,40 @@ type AccessCode struct {
 	AttemptCount    int        `json:"attempt_count"`     // Track failed attempts
 	MaxAttempts     int        `json:"max_attempts"`      // Default: 5
 }
+
+// API token scopes
+const (
+	APITokenScopeCheckIn = "check_in" // Check in to reset the switch
+	APITokenScopeRead    = "read"     // Read account data
+	APITokenScopeWrite   = "write"    // Change account data
+)
+
+// APITokenScopes lists all scopes a token can be granted
+var APITokenScopes = []string{APITokenScopeCheckIn, APITokenScopeRead, APITokenScopeWrite}
+
+// APIToken represents a personal token for scripted access to the API
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"` // SHA-256 of the token, the token itself is only shown once
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the token was granted the given scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRevoked reports whether the token has been revoked
+func (t *APIToken) IsRevoked() bool {
+	return t.RevokedAt != nil
+}
@@ -0,0 +1,318 @@
+package recoverykit
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+)
+
+// The kit is a plain A4 PDF with the standard fonts, written by hand so the
+// server needs no PDF library. QR modules are drawn as filled rectangles.
+const (
+	pageWidth  = 595.28
+	pageHeight = 841.89
+	margin     = 56.0
+	textWidth  = pageWidth - 2*margin
+
+	// Fonts of the content streams
+	fontRegular = "F1"
+	fontBold    = "F2"
+	fontMono    = "F3"
+
+	// Size of a QR code with its quiet zone, two columns and three rows fit a page
+	qrSize    = 210.0
+	qrColumns = 2
+	qrRows    = 3
+
+	// Base32 characters per printed line, in groups of eight
+	textLineSize  = 48
+	textGroupSize = 8
+)
+
+// pdfFonts maps the font resources to the standard fonts they use
+var pdfFonts = []struct{ name, base string }{
+	{fontRegular, "Helvetica"},
+	{fontBold, "Helvetica-Bold"},
+	{fontMono, "Courier"},
+}
+
+// WritePDF writes the printable kit: the instructions and the plain text
+// fields of the kit on the first page, then the parts as QR codes and as
+// text. The secrets only appear in the encrypted parts.
+func WritePDF(w io.Writer, kit *Kit, parts []string) error {
+	doc := &pdfDocument{}
+	doc.newPage()
+
+	doc.line(fontBold, 20, "Dead Man's Switch Recovery Kit", 30)
+	doc.line(fontRegular, 12, fmt.Sprintf("For %s <%s>", kit.Recipient, kit.RecipientEmail), 17)
+	doc.line(fontRegular, 12, "From "+kit.Owner, 17)
+	doc.line(fontRegular, 12, "Made on "+kit.CreatedAt.Format("2 January 2006"), 17)
+	doc.line(fontRegular, 12, "Server: "+kit.ServerURL, 30)
+
+	doc.line(fontBold, 13, "What this is", 18)
+	doc.paragraph("", fmt.Sprintf("%s uses Dead Man's Switch to pass secrets on to the people they trust. If %s stops checking in, the server at %s emails you a link to the secrets meant for you. This kit is a paper copy of them, in case the server is gone by then.", kit.Owner, kit.Owner, kit.ServerURL))
+	doc.paragraph("", fmt.Sprintf("The kit is encrypted. Nobody can read it without the passphrase %s tells you separately, it is not printed here. Keep the kit somewhere safe all the same.", kit.Owner))
+
+	doc.line(fontBold, 13, "How to open it", 18)
+	doc.paragraph("1.", fmt.Sprintf("Try the server first: %s. As long as it works, the link in your email has the latest secrets, this kit only holds them as of %s.", kit.ServerURL, kit.CreatedAt.Format("2 January 2006")))
+	doc.paragraph("2.", "Otherwise get dms-recover, the recovery tool of Dead Man's Switch, from https://github.com/korjavin/deadmanswitch (cmd/dms-recover) and run it on a computer, offline if you can.")
+	doc.paragraph("3.", "Scan every QR code of this kit into a text file, one code per line, in any order. If the codes can't be scanned, type the text parts at the end of the kit instead, each starting with DMSK1. Spaces and line breaks don't matter, and a typo is reported with the number of the part to check.")
+	doc.paragraph("4.", "Run \"dms-recover kit.txt\" and enter the passphrase. It prints the secrets.")
+
+	doc.line(fontBold, 13, "Contents", 18)
+	doc.paragraph("", fmt.Sprintf("Secrets: %d. Parts: %d, each printed once as a QR code and once as text.", len(kit.Secrets), len(parts)))
+
+	// The QR codes
+	for i, part := range parts {
+		if i%(qrColumns*qrRows) == 0 {
+			doc.newPage()
+			doc.line(fontBold, 13, "QR codes, scan each one in any order", 18)
+		}
+
+		code, err := qr.Encode(part, qr.M, qr.AlphaNumeric)
+		if err != nil {
+			return fmt.Errorf("failed to encode part %d as QR code: %w", i+1, err)
+		}
+
+		slot := i % (qrColumns * qrRows)
+		x := margin + float64(slot%qrColumns)*(textWidth-qrSize)
+		top := pageHeight - margin - 24 - float64(slot/qrColumns)*(qrSize+32)
+		doc.qrCode(code, x, top-qrSize, qrSize)
+		doc.text(fontRegular, 10, x+qrSize/2-24, top-qrSize-14, fmt.Sprintf("Part %d of %d", i+1, len(parts)))
+	}
+
+	// The same parts as text
+	doc.newPage()
+	doc.line(fontBold, 13, "Text of the parts, to type if the QR codes can't be scanned", 24)
+	for i, part := range parts {
+		header, data := part, ""
+		if pos := strings.LastIndex(part, ":"); pos >= 0 {
+			header, data = part[:pos+1], part[pos+1:]
+		}
+
+		lines := (len(data) + textLineSize - 1) / textLineSize
+		if !doc.fits(float64(lines+2) * 13) {
+			doc.newPage()
+		}
+		doc.line(fontBold, 10, fmt.Sprintf("Part %d of %d", i+1, len(parts)), 14)
+		doc.line(fontMono, 10, header, 13)
+		for start := 0; start < len(data); start += textLineSize {
+			doc.line(fontMono, 10, groupText(data[start:min(start+textLineSize, len(data))]), 13)
+		}
+		doc.y -= 12
+	}
+
+	footer := fmt.Sprintf("Recovery kit for %s from %s", kit.Recipient, kit.Owner)
+	return doc.writeTo(w, footer)
+}
+
+// groupText splits a line of base32 text into groups that are easier to type
+func groupText(line string) string {
+	var groups []string
+	for start := 0; start < len(line); start += textGroupSize {
+		groups = append(groups, line[start:min(start+textGroupSize, len(line))])
+	}
+	return strings.Join(groups, " ")
+}
+
+// pdfDocument collects the content streams of the pages of a PDF
+type pdfDocument struct {
+	pages []*bytes.Buffer
+	y     float64 // Baseline of the next line on the current page
+}
+
+// newPage starts a new page at the top margin
+func (d *pdfDocument) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pageHeight - margin
+}
+
+// page returns the content stream of the current page
+func (d *pdfDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// fits reports whether height points still fit on the current page
+func (d *pdfDocument) fits(height float64) bool {
+	return d.y-height >= margin
+}
+
+// text writes a string at a position
+func (d *pdfDocument) text(font string, size, x, y float64, s string) {
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+// line writes a line at the left margin and moves down by leading, on a new page if needed
+func (d *pdfDocument) line(font string, size float64, s string, leading float64) {
+	if !d.fits(leading) {
+		d.newPage()
+	}
+	d.text(font, size, margin, d.y, s)
+	d.y -= leading
+}
+
+// paragraph writes wrapped text, indented behind a label such as "1." if there is one
+func (d *pdfDocument) paragraph(label, s string) {
+	const size, leading = 10.5, 14.0
+
+	indent := 0.0
+	if label != "" {
+		indent = 18
+	}
+
+	// Helvetica is about half as wide as high on average
+	maxChars := int((textWidth - indent) / (size * 0.52))
+
+	for i, line := range wrapText(s, maxChars) {
+		if !d.fits(leading) {
+			d.newPage()
+		}
+		if i == 0 && label != "" {
+			d.text(fontRegular, size, margin, d.y, label)
+		}
+		d.text(fontRegular, size, margin+indent, d.y, line)
+		d.y -= leading
+	}
+	d.y -= 6
+}
+
+// qrCode draws a QR code with its quiet zone into a square at x, y
+func (d *pdfDocument) qrCode(code barcode.Barcode, x, y, size float64) {
+	const quietZone = 4
+
+	bounds := code.Bounds()
+	modules := bounds.Dx()
+	module := size / float64(modules+2*quietZone)
+
+	page := d.page()
+	page.WriteString("0 g\n")
+	for row := 0; row < modules; row++ {
+		top := y + size - float64(quietZone+row+1)*module
+
+		// Neighbouring dark modules of a row are drawn as one rectangle
+		for col := 0; col < modules; {
+			if !isDark(code.At(bounds.Min.X+col, bounds.Min.Y+row)) {
+				col++
+				continue
+			}
+			start := col
+			for col < modules && isDark(code.At(bounds.Min.X+col, bounds.Min.Y+row)) {
+				col++
+			}
+			fmt.Fprintf(page, "%.2f %.2f %.2f %.2f re\n", x+float64(quietZone+start)*module, top, float64(col-start)*module, module)
+		}
+	}
+	page.WriteString("f\n")
+}
+
+// isDark reports whether a QR module is dark
+func isDark(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r+g+b < 3*0x8000
+}
+
+// writeTo writes the document with a footer and page numbers on every page
+func (d *pdfDocument) writeTo(w io.Writer, footer string) error {
+	var out bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 and 2 are the catalog and the page tree, the fonts follow
+	// and then each page with its content stream
+	firstPage := 3 + len(pdfFonts)
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	var fonts []string
+	for i, font := range pdfFonts {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font.base))
+		fonts = append(fonts, fmt.Sprintf("/%s %d 0 R", font.name, 3+i))
+	}
+
+	for i, content := range d.pages {
+		fmt.Fprintf(content, "BT /%s 8 Tf %.2f %.2f Td (%s) Tj ET\n", fontRegular, margin, margin/2, pdfString(fmt.Sprintf("%s - page %d of %d", footer, i+1, len(d.pages))))
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, strings.Join(fonts, " "), firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfString escapes text for a PDF string in WinAnsiEncoding. Characters
+// the standard fonts don't have are replaced by a question mark.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// wrapText breaks text into lines of at most maxChars characters at spaces.
+// Longer words, such as URLs, are broken where they have to be.
+func wrapText(s string, maxChars int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for utf8.RuneCountInString(word) > maxChars {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			runes := []rune(word)
+			lines = append(lines, string(runes[:maxChars]))
+			word = string(runes[maxChars:])
+		}
+
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= maxChars:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,345 @@
+// Package recoverykit puts the secrets meant for one recipient on paper, for
+// the case the server is gone when the recipient needs them. The secrets are
+// compressed and encrypted with a passphrase the owner tells the recipient
+// separately, in the same envelope as crypto.EncryptSecret, and printed in
+// parts as QR codes and as base32 text. cmd/dms-recover decrypts a kit
+// offline.
+package recoverykit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+const (
+	// Version is the version of the kit written by Seal. Open reads this and
+	// all earlier versions.
+	Version = 1
+
+	// PartPrefix starts every part of a kit. It only uses characters of the
+	// QR alphanumeric mode, like the rest of the part.
+	PartPrefix = "DMSK1:"
+
+	// MinPassphraseLength is the shortest passphrase a kit can be encrypted with
+	MinPassphraseLength = 12
+
+	// partSize is the number of base32 characters of a part, small enough for
+	// a QR code that prints well at the size of a quarter page
+	partSize = 600
+
+	// checkSize is the number of base32 characters of the checksum of a part
+	checkSize = 4
+
+	// maxParts limits a kit to what is still reasonable to scan or type
+	maxParts = 30
+
+	// maxPayloadSize limits the decompressed payload, so a damaged kit can't exhaust memory
+	maxPayloadSize = 4 << 20
+)
+
+var (
+	// ErrWeakPassphrase is returned for a passphrase shorter than MinPassphraseLength
+	ErrWeakPassphrase = fmt.Errorf("the passphrase must be at least %d characters", MinPassphraseLength)
+
+	// ErrWrongPassphrase is returned when a kit can't be decrypted with the passphrase
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+
+	// ErrTooLarge is returned when the secrets don't fit on a kit
+	ErrTooLarge = fmt.Errorf("the secrets don't fit on %d parts", maxParts)
+
+	// ErrInvalidKit is returned for text that is not a recovery kit
+	ErrInvalidKit = errors.New("not a Dead Man's Switch recovery kit")
+
+	// ErrDamagedPart is returned for a part that doesn't match its checksum
+	ErrDamagedPart = errors.New("damaged part")
+
+	// ErrMissingParts is returned when not all parts of a kit are there
+	ErrMissingParts = errors.New("missing parts")
+
+	// ErrUnsupportedVersion is returned for a kit written by a newer version
+	ErrUnsupportedVersion = errors.New("the kit was written by a newer version, update dms-recover first")
+)
+
+// base32Encoding encodes the encrypted kit. Its alphabet fits the QR
+// alphanumeric mode and has no 0, 1 or 8 to confuse with letters.
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// typoReplacer corrects digits that are not in the base32 alphabet to the
+// letters they look like
+var typoReplacer = strings.NewReplacer("0", "O", "1", "I", "8", "B")
+
+// Kit is the content of a recovery kit. Only the fields up to the secrets
+// are printed in plain text, the whole kit is encrypted.
+type Kit struct {
+	Version        int       `json:"version"`
+	CreatedAt      time.Time `json:"created_at"`
+	Owner          string    `json:"owner"` // Email of the owner
+	Recipient      string    `json:"recipient"`
+	RecipientEmail string    `json:"recipient_email"`
+	ServerURL      string    `json:"server_url"`
+	Secrets        []Secret  `json:"secrets"`
+}
+
+// Secret is a secret in a kit. Typed secrets are formatted as text with one
+// field per line, secrets encrypted in the owner's browser keep their envelope.
+type Secret struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"` // Name of the secret type, such as "Note"
+	Content       string `json:"content"`
+	ZeroKnowledge bool   `json:"zero_knowledge,omitempty"`
+}
+
+// CheckPassphrase returns ErrWeakPassphrase for a passphrase that is too short
+func CheckPassphrase(passphrase string) error {
+	if utf8.RuneCountInString(passphrase) < MinPassphraseLength {
+		return ErrWeakPassphrase
+	}
+	return nil
+}
+
+// Collect returns the secrets of a user that are assigned to a recipient,
+// decrypted with the vault key. Secrets that can't go on paper are left out
+// and explained in the notes: quorum protected secrets need more than one
+// recipient, files are too large, and legacy secrets need an upgrade first.
+func Collect(ctx context.Context, repo storage.Repository, user *models.User, recipient *models.Recipient, vaultKey []byte) ([]Secret, []string, error) {
+	assignments, err := repo.ListSecretAssignmentsByRecipientID(ctx, recipient.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list secret assignments: %w", err)
+	}
+
+	secrets := []Secret{}
+	var notes []string
+	for _, assignment := range assignments {
+		if assignment.UserID != user.ID {
+			continue
+		}
+
+		secret, err := repo.GetSecretByID(ctx, assignment.SecretID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get secret %s: %w", assignment.SecretID, err)
+		}
+
+		switch {
+		case secret.IsQuorumProtected():
+			notes = append(notes, fmt.Sprintf("%q was left out, it needs %d recipients together to open it", secret.Name, secret.QuorumThreshold))
+			continue
+		case secret.IsFile():
+			notes = append(notes, fmt.Sprintf("%q was left out, files are too large for paper", secret.Name))
+			continue
+		case secret.IsClientEncrypted():
+			// The envelope is already encrypted with the owner's own passphrase
+			secrets = append(secrets, Secret{Name: secret.Name, Type: "Zero-knowledge", Content: secret.EncryptedData, ZeroKnowledge: true})
+			continue
+		case secret.EncryptionType != models.EncryptionTypeVault:
+			notes = append(notes, fmt.Sprintf("%q is still encrypted with the old demo key and was left out, unlock your vault once to upgrade it", secret.Name))
+			continue
+		}
+
+		plaintext, err := crypto.DecryptSecret(secret.EncryptedData, vaultKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt secret %s: %w", secret.ID, err)
+		}
+
+		entry := Secret{Name: secret.Name, Type: "Note", Content: string(plaintext)}
+		if typ, err := secrettypes.Lookup(secret.Type); err == nil {
+			if values, err := typ.Decode(plaintext); err == nil {
+				entry.Type = typ.Name
+				entry.Content = typ.Format(values)
+			}
+		}
+		secrets = append(secrets, entry)
+	}
+
+	return secrets, notes, nil
+}
+
+// Seal encrypts a kit with a passphrase and splits it into parts of the form
+// PartPrefix + "<index>/<count>:<checksum>:<data>"
+func Seal(kit *Kit, passphrase string) ([]string, error) {
+	if err := CheckPassphrase(passphrase); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(kit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode kit: %w", err)
+	}
+
+	var compressed bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&compressed, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(payload); err != nil {
+		return nil, fmt.Errorf("failed to compress kit: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress kit: %w", err)
+	}
+
+	encrypted, err := crypto.EncryptWithPassphrase(compressed.Bytes(), []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt kit: %w", err)
+	}
+
+	// The envelope is base64, paper and QR codes get the raw bytes as base32
+	envelope, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	data := base32Encoding.EncodeToString(envelope)
+
+	count := (len(data) + partSize - 1) / partSize
+	if count > maxParts {
+		return nil, ErrTooLarge
+	}
+
+	parts := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := data[i*partSize : min((i+1)*partSize, len(data))]
+		parts = append(parts, fmt.Sprintf("%s%d/%d:%s:%s", PartPrefix, i+1, count, partChecksum(i+1, count, chunk), chunk))
+	}
+	return parts, nil
+}
+
+// Open joins the parts in text and decrypts the kit with the passphrase
+func Open(text string, passphrase string) (*Kit, error) {
+	data, err := Join(text)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope, err := base32Encoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKit, err)
+	}
+
+	compressed, err := crypto.DecryptSecret(base64.StdEncoding.EncodeToString(envelope), []byte(passphrase))
+	if err != nil {
+		// The parts passed their checksums, so the passphrase is the likely cause
+		if errors.Is(err, crypto.ErrInvalidData) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKit, err)
+		}
+		return nil, ErrWrongPassphrase
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKit, err)
+	}
+	payload, err := io.ReadAll(io.LimitReader(zr, maxPayloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKit, err)
+	}
+	if len(payload) > maxPayloadSize {
+		return nil, fmt.Errorf("%w: the content is too large", ErrInvalidKit)
+	}
+
+	var kit Kit
+	if err := json.Unmarshal(payload, &kit); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKit, err)
+	}
+	if kit.Version > Version {
+		return nil, ErrUnsupportedVersion
+	}
+	return &kit, nil
+}
+
+// Join checks the parts in text against their checksums and returns their
+// data in order. The parts can come in any order and be repeated, and
+// whitespace and case don't matter, so scanned codes can be pasted one per
+// line and typed text doesn't have to match the printed layout. A damaged
+// part is reported with its index.
+func Join(text string) (string, error) {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, text)
+
+	pieces := strings.Split(text, PartPrefix)
+	if len(pieces) < 2 || pieces[0] != "" {
+		return "", ErrInvalidKit
+	}
+
+	var count int
+	parts := make(map[int]string)
+	for _, piece := range pieces[1:] {
+		index, n, data, err := parsePart(piece)
+		if err != nil {
+			return "", err
+		}
+		if count != 0 && n != count {
+			return "", fmt.Errorf("%w: the parts belong to different kits", ErrInvalidKit)
+		}
+		count = n
+
+		if existing, ok := parts[index]; ok && existing != data {
+			return "", fmt.Errorf("%w: part %d of %d appears twice with different text", ErrInvalidKit, index, count)
+		}
+		parts[index] = data
+	}
+
+	var missing []string
+	var b strings.Builder
+	for i := 1; i <= count; i++ {
+		data, ok := parts[i]
+		if !ok {
+			missing = append(missing, strconv.Itoa(i))
+		}
+		b.WriteString(data)
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: %s of %d", ErrMissingParts, strings.Join(missing, ", "), count)
+	}
+	return b.String(), nil
+}
+
+// parsePart returns the index, the count and the data of a part without its prefix
+func parsePart(piece string) (int, int, string, error) {
+	fields := strings.SplitN(piece, ":", 3)
+	if len(fields) != 3 {
+		return 0, 0, "", fmt.Errorf("%w: a part is incomplete", ErrInvalidKit)
+	}
+
+	position, check, data := fields[0], fields[1], fields[2]
+	indexText, countText, ok := strings.Cut(position, "/")
+	index, indexErr := strconv.Atoi(indexText)
+	count, countErr := strconv.Atoi(countText)
+	if !ok || indexErr != nil || countErr != nil || count < 1 || count > maxParts || index < 1 || index > count {
+		return 0, 0, "", fmt.Errorf("%w: a part has an invalid number %q", ErrInvalidKit, position)
+	}
+
+	// 0, 1 and 8 are not in the alphabet, they are O, I and B typed wrong
+	data = typoReplacer.Replace(data)
+	check = typoReplacer.Replace(check)
+	if check != partChecksum(index, count, data) {
+		return 0, 0, "", fmt.Errorf("%w: part %d of %d doesn't match its checksum, check it for typos", ErrDamagedPart, index, count)
+	}
+	return index, count, data, nil
+}
+
+// partChecksum returns the checksum of the data of a part at its position
+func partChecksum(index, count int, data string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d/%d:%s", index, count, data)))
+	return base32Encoding.EncodeToString(sum[:])[:checkSize]
+}
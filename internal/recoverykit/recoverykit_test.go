@@ -0,0 +1,170 @@
+package recoverykit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+func newTestKit(secrets ...Secret) *Kit {
+	return &Kit{
+		Version:        Version,
+		CreatedAt:      time.Now().UTC(),
+		Owner:          "owner@example.com",
+		Recipient:      "Alice",
+		RecipientEmail: "alice@example.com",
+		ServerURL:      "https://dms.example.com",
+		Secrets:        secrets,
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	// Random content doesn't compress, so the kit needs several parts
+	var random []string
+	for i := 0; i < 60; i++ {
+		key, _ := crypto.GenerateDataEncryptionKey()
+		random = append(random, crypto.KeyID(key))
+	}
+	content := strings.Join(random, " ")
+	kit := newTestKit(Secret{Name: "Bank", Type: "Note", Content: "PIN 1234"}, Secret{Name: "Keys", Type: "Note", Content: content})
+
+	if _, err := Seal(kit, "too short"); !errors.Is(err, ErrWeakPassphrase) {
+		t.Errorf("Expected ErrWeakPassphrase, got %v", err)
+	}
+
+	parts, err := Seal(kit, testPassphrase)
+	if err != nil {
+		t.Fatalf("Failed to seal kit: %v", err)
+	}
+	if len(parts) < 2 {
+		t.Fatalf("Expected several parts, got %d", len(parts))
+	}
+	for _, part := range parts {
+		if !strings.HasPrefix(part, PartPrefix) || strings.Contains(part, "PIN") {
+			t.Errorf("Unexpected part %q", part)
+		}
+	}
+
+	// Scanned in any order, with typed whitespace and digits for letters
+	reversed := make([]string, len(parts))
+	for i, part := range parts {
+		reversed[len(parts)-1-i] = strings.ToLower(part)
+	}
+	text := strings.Join(reversed, "\n") + "\n" + groupText(parts[0])
+	text = strings.Replace(text, "o", "0", 1)
+
+	if _, err := Open(text, "wrong horse battery staple"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+
+	opened, err := Open(text, testPassphrase)
+	if err != nil {
+		t.Fatalf("Failed to open kit: %v", err)
+	}
+	if opened.Recipient != "Alice" || len(opened.Secrets) != 2 || opened.Secrets[0].Content != "PIN 1234" || opened.Secrets[1].Content != content {
+		t.Errorf("Unexpected kit %+v", opened)
+	}
+
+	// A typo is reported with the part it is in
+	replacement := "A"
+	if strings.HasSuffix(parts[1], replacement) {
+		replacement = "B"
+	}
+	damaged := parts[1][:len(parts[1])-1] + replacement
+	_, err = Open(strings.Join(append([]string{parts[0], damaged}, parts[2:]...), "\n"), testPassphrase)
+	if !errors.Is(err, ErrDamagedPart) || !strings.Contains(err.Error(), "part 2 of") {
+		t.Errorf("Expected ErrDamagedPart for part 2, got %v", err)
+	}
+
+	if _, err := Open(strings.Join(parts[1:], "\n"), testPassphrase); !errors.Is(err, ErrMissingParts) || !strings.Contains(err.Error(), "1 of") {
+		t.Errorf("Expected ErrMissingParts for part 1, got %v", err)
+	}
+	if _, err := Open("hello world", testPassphrase); !errors.Is(err, ErrInvalidKit) {
+		t.Errorf("Expected ErrInvalidKit, got %v", err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewMockRepository()
+	vaultKey := []byte("abcdef0123456789abcdef0123456789")
+	user := &models.User{ID: "user123", Email: "owner@example.com"}
+	alice := &models.Recipient{ID: "alice", UserID: user.ID, Email: "alice@example.com", Name: "Alice"}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients, alice)
+
+	addSecret := func(secret *models.Secret, content string) {
+		t.Helper()
+		if content != "" {
+			encrypted, err := crypto.EncryptSecret([]byte(content), vaultKey)
+			if err != nil {
+				t.Fatalf("Failed to encrypt secret: %v", err)
+			}
+			secret.EncryptedData = encrypted
+		}
+		secret.UserID = user.ID
+		repo.Secrets = append(repo.Secrets, secret)
+		repo.SecretAssignments = append(repo.SecretAssignments, &models.SecretAssignment{ID: "assignment-" + secret.ID, SecretID: secret.ID, RecipientID: alice.ID, UserID: user.ID})
+	}
+	addSecret(&models.Secret{ID: "note", Name: "Bank", EncryptionType: models.EncryptionTypeVault}, "PIN 1234")
+	addSecret(&models.Secret{ID: "login", Name: "Mail", Type: "login", EncryptionType: models.EncryptionTypeVault}, `{"username":"alice","password":"hunter2"}`)
+	addSecret(&models.Secret{ID: "client", Name: "Diary", EncryptionType: models.EncryptionTypeClient, EncryptedData: "envelope"}, "")
+	addSecret(&models.Secret{ID: "quorum", Name: "Seed", EncryptionType: models.EncryptionTypeVault, QuorumThreshold: 2}, "abandon ability able")
+	addSecret(&models.Secret{ID: "file", Name: "Will", EncryptionType: models.EncryptionTypeVault, FileRef: "will"}, "{}")
+	addSecret(&models.Secret{ID: "legacy", Name: "Old note", EncryptionType: models.EncryptionTypeLegacy}, "")
+
+	secrets, notes, err := Collect(ctx, repo, user, alice, vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to collect secrets: %v", err)
+	}
+	if len(secrets) != 3 || len(notes) != 3 {
+		t.Fatalf("Expected 3 secrets and 3 notes, got %+v and %v", secrets, notes)
+	}
+	if secrets[0].Content != "PIN 1234" {
+		t.Errorf("Expected the decrypted note, got %q", secrets[0].Content)
+	}
+	if !strings.Contains(secrets[1].Content, "hunter2") || strings.Contains(secrets[1].Content, "{") {
+		t.Errorf("Expected the login formatted as text, got %q", secrets[1].Content)
+	}
+	if !secrets[2].ZeroKnowledge || secrets[2].Content != "envelope" {
+		t.Errorf("Expected the zero-knowledge envelope as it is, got %+v", secrets[2])
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	kit := newTestKit(Secret{Name: "Bank", Type: "Note", Content: "PIN 1234"})
+	kit.Recipient = "Zoë (Alice)"
+
+	parts, err := Seal(kit, testPassphrase)
+	if err != nil {
+		t.Fatalf("Failed to seal kit: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePDF(&buf, kit, parts); err != nil {
+		t.Fatalf("Failed to write PDF: %v", err)
+	}
+
+	pdf := buf.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Errorf("Expected a PDF document, got %q", pdf[:20])
+	}
+	if strings.Contains(pdf, "PIN 1234") {
+		t.Error("Expected the secret to be encrypted in the PDF")
+	}
+	if !strings.Contains(pdf, `Zo\353 \(Alice\)`) {
+		t.Error("Expected the escaped name of the recipient")
+	}
+	if !strings.Contains(pdf, groupText(parts[0][strings.LastIndex(parts[0], ":")+1:][:textLineSize])) {
+		t.Error("Expected the text of the first part")
+	}
+}
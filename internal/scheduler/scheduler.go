@@ -2,7 +2,7 @@ package scheduler
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -13,6 +13,7 @@ import (
 	"github.com/korjavin/deadmanswitch/internal/activity"
 	"github.com/korjavin/deadmanswitch/internal/config"
 	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/email"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
@@ -28,20 +29,23 @@ type Task struct {
 	Handler    TaskHandler
 }
 
+// triggerAlertInterval is how often the owner of an armed switch is alerted again
+const triggerAlertInterval = 2 * time.Hour
+
 // TaskHandler is a function that runs a task
 type TaskHandler func(ctx context.Context) error
 
 // EmailClient is an interface for email clients
 type EmailClient interface {
 	SendPingEmail(email, verificationCode, urgency string) error
-	SendSecretDeliveryEmail(recipientEmail, recipientName, message, accessCode string) error
+	SendSecretDeliveryEmail(recipientEmail, recipientName, message, accessCode string, shares []email.QuorumShare) error
 	SendEmail(options *email.MessageOptions) error
 	SendEmailSimple(to []string, subject, body string, isHTML bool) error
 }
 
 // TelegramBot is an interface for telegram bots
 type TelegramBot interface {
-	SendPingMessage(ctx context.Context, user *models.User, pingID string) error
+	SendPingMessage(ctx context.Context, user *models.User, pingID string, urgency string) error
 }
 
 // Scheduler handles periodic tasks
@@ -52,6 +56,7 @@ type Scheduler struct {
 	telegramBot      TelegramBot
 	config           *config.Config
 	activityRegistry *activity.Registry
+	sealer           *delivery.Sealer
 	mu               sync.RWMutex
 	stopChan         chan struct{}
 	deliveryLock     sync.Mutex
@@ -68,6 +73,18 @@ func NewScheduler(
 	activityRegistry := activity.NewRegistry()
 	activityRegistry.Register(activity.NewGitHubProvider())
 
+	// Delivery material is sealed with the server master key, questions are timelocked with the beacon
+	var masterKey []byte
+	var previousKeys [][]byte
+	var timelock crypto.Timelock
+	if config != nil {
+		masterKey = config.MasterKey
+		previousKeys = config.PreviousMasterKeys
+		timelock = config.Timelock
+	}
+	sealer := delivery.NewSealer(repo, masterKey, timelock)
+	sealer.SetPreviousMasterKeys(previousKeys)
+
 	return &Scheduler{
 		tasks:            make(map[string]*Task),
 		repo:             repo,
@@ -75,6 +92,7 @@ func NewScheduler(
 		telegramBot:      telegramBot,
 		config:           config,
 		activityRegistry: activityRegistry,
+		sealer:           sealer,
 		stopChan:         make(chan struct{}),
 	}
 }
@@ -139,6 +157,24 @@ func (s *Scheduler) registerTasks() error {
 		Handler:    s.deadSwitchTask,
 	})
 
+	// Task for retrying failed secret deliveries
+	s.AddTask(&Task{
+		ID:         uuid.New().String(),
+		Name:       "DeliveryRetryTask",
+		Duration:   5 * time.Minute, // Check for due delivery retries every 5 minutes
+		RunOnStart: true,
+		Handler:    s.deliveryRetryTask,
+	})
+
+	// Task for releasing secrets of emergency access requests the owner didn't deny
+	s.AddTask(&Task{
+		ID:         uuid.New().String(),
+		Name:       "EmergencyAccessTask",
+		Duration:   5 * time.Minute, // Check for expired waiting periods every 5 minutes
+		RunOnStart: true,
+		Handler:    s.emergencyAccessTask,
+	})
+
 	// Task for checking external activity (GitHub, etc.)
 	s.AddTask(&Task{
 		ID:         uuid.New().String(),
@@ -148,6 +184,15 @@ func (s *Scheduler) registerTasks() error {
 		Handler:    s.externalActivityTask,
 	})
 
+	// Task for turning off question timelocks that opened before the owner's deadline
+	s.AddTask(&Task{
+		ID:         uuid.New().String(),
+		Name:       "QuestionTimelockTask",
+		Duration:   1 * time.Hour, // Check for opened timelocks hourly
+		RunOnStart: true,
+		Handler:    s.questionTimelockTask,
+	})
+
 	// Task for cleaning up expired access codes
 	s.AddTask(&Task{
 		ID:         uuid.New().String(),
@@ -261,7 +306,7 @@ func (s *Scheduler) pingTask(ctx context.Context) error {
 					log.Printf("Failed to create ping history for user %s: %v", user.ID, err)
 					continue
 				}
-				if err := s.telegramBot.SendPingMessage(ctx, user, ping.ID); err != nil {
+				if err := s.telegramBot.SendPingMessage(ctx, user, ping.ID, string(models.ReminderNormal)); err != nil {
 					log.Printf("Failed to send Telegram ping to user %s: %v", user.ID, err)
 				}
 			} else {
@@ -287,7 +332,7 @@ func (s *Scheduler) pingTask(ctx context.Context) error {
 				if err := s.repo.CreatePingHistory(ctx, telegramPing); err != nil {
 					log.Printf("Failed to create telegram ping history for user %s: %v", user.ID, err)
 				} else {
-					if err := s.telegramBot.SendPingMessage(ctx, user, telegramPing.ID); err != nil {
+					if err := s.telegramBot.SendPingMessage(ctx, user, telegramPing.ID, string(models.ReminderNormal)); err != nil {
 						log.Printf("Failed to send Telegram ping to user %s: %v", user.ID, err)
 					}
 				}
@@ -350,6 +395,11 @@ func (s *Scheduler) deadSwitchTask(ctx context.Context) error {
 	s.deliveryLock.Lock()
 	defer s.deliveryLock.Unlock()
 
+	// Fire or abort the switches that are in their grace period
+	if err := s.processArmedRuns(ctx); err != nil {
+		log.Printf("Failed to process armed switches: %v", err)
+	}
+
 	// Get users who have exceeded their ping deadline
 	users, err := s.repo.GetUsersWithExpiredPings(ctx)
 	if err != nil {
@@ -359,6 +409,25 @@ func (s *Scheduler) deadSwitchTask(ctx context.Context) error {
 	log.Printf("Found %d users with expired pings", len(users))
 
 	for _, user := range users {
+		// A switch that triggered before a restart is finished without checking activity again
+		run, err := s.repo.GetActiveDeliveryRunByUserID(ctx, user.ID)
+		if err == nil {
+			if run.Status == models.DeliveryRunStatusArmed {
+				// Still in the grace period, processArmedRuns fires it once the period is over
+				continue
+			}
+
+			log.Printf("Resuming delivery run %s for user %s", run.ID, user.ID)
+			if err := s.deliverSecrets(ctx, user, run); err != nil {
+				log.Printf("Failed to deliver secrets for user %s: %v", user.ID, err)
+			}
+			continue
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to get delivery run for user %s: %v", user.ID, err)
+			continue
+		}
+
 		// Perform a final check of all activity sources before triggering the switch
 		log.Printf("Performing final activity check for user %s before triggering switch", user.ID)
 
@@ -386,6 +455,9 @@ func (s *Scheduler) deadSwitchTask(ctx context.Context) error {
 				if err := s.repo.UpdateUser(ctx, user); err != nil {
 					log.Printf("Failed to update user after detecting external activity: %v", err)
 				}
+				if err := s.sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+					log.Printf("Failed to renew question timelocks for user %s: %v", user.ID, err)
+				}
 
 				// Create audit log entry
 				auditLog := &models.AuditLog{
@@ -421,6 +493,9 @@ func (s *Scheduler) deadSwitchTask(ctx context.Context) error {
 				if err := s.repo.UpdateUser(ctx, user); err != nil {
 					log.Printf("Failed to update user after detecting ping response: %v", err)
 				}
+				if err := s.sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+					log.Printf("Failed to renew question timelocks for user %s: %v", user.ID, err)
+				}
 
 				// Create audit log entry
 				auditLog := &models.AuditLog{
@@ -438,35 +513,202 @@ func (s *Scheduler) deadSwitchTask(ctx context.Context) error {
 			}
 		}
 
-		// If no activity was detected, trigger the switch
+		// If no activity was detected, arm the switch or trigger it right away
 		if !active {
+			if s.config.TriggerGracePeriod > 0 {
+				log.Printf("No activity detected for user %s, arming switch", user.ID)
+				if err := s.armSwitch(ctx, user); err != nil {
+					log.Printf("Failed to arm switch for user %s: %v", user.ID, err)
+				}
+				continue
+			}
+
 			log.Printf("No activity detected for user %s, triggering switch", user.ID)
+			s.triggerSwitch(ctx, user, nil)
+		}
+	}
+
+	return nil
+}
+
+// triggerSwitch records the trigger in the audit log and delivers the user's secrets
+func (s *Scheduler) triggerSwitch(ctx context.Context, user *models.User, run *models.DeliveryRun) {
+	// Create audit log entry for switch trigger
+	auditLog := &models.AuditLog{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Action:    "switch_triggered",
+		Timestamp: time.Now().UTC(),
+		Details:   fmt.Sprintf("Dead man's switch triggered after no activity for %d days", user.PingDeadline),
+	}
+
+	if err := s.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Failed to create audit log for switch trigger: %v", err)
+	}
+
+	// Deliver secrets
+	if err := s.deliverSecrets(ctx, user, run); err != nil {
+		log.Printf("Failed to deliver secrets for user %s: %v", user.ID, err)
+	}
+}
+
+// armSwitch starts the grace period of a user's switch. Nothing is released until the
+// period is over, and the owner is alerted on every channel with a link to abort.
+func (s *Scheduler) armSwitch(ctx context.Context, user *models.User) error {
+	now := time.Now().UTC()
+	run := &models.DeliveryRun{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Status:    models.DeliveryRunStatusArmed,
+		StartedAt: now,
+		FiresAt:   now.Add(s.config.TriggerGracePeriod),
+		AbortCode: generateAbortCode(),
+	}
+	if err := s.repo.CreateDeliveryRun(ctx, run); err != nil {
+		return fmt.Errorf("failed to create delivery run: %w", err)
+	}
+
+	auditLog := &models.AuditLog{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Action:    "switch_armed",
+		Timestamp: now,
+		Details: fmt.Sprintf("Dead man's switch armed after no activity for %d days, secrets will be released at %s",
+			user.PingDeadline, run.FiresAt.Format(time.RFC3339)),
+	}
+
+	if err := s.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Failed to create audit log for armed switch: %v", err)
+	}
+
+	s.sendTriggerAlerts(ctx, user, run)
+	return nil
+}
+
+// processArmedRuns aborts the armed switches whose owner has been active since, fires
+// those whose grace period is over and keeps alerting the owners of the others
+func (s *Scheduler) processArmedRuns(ctx context.Context) error {
+	runs, err := s.repo.ListDeliveryRunsByStatus(ctx, models.DeliveryRunStatusArmed)
+	if err != nil {
+		return fmt.Errorf("failed to get armed delivery runs: %w", err)
+	}
+
+	now := time.Now().UTC()
+	for _, run := range runs {
+		user, err := s.repo.GetUserByID(ctx, run.UserID)
+		if err != nil {
+			log.Printf("Failed to get user %s of armed switch: %v", run.UserID, err)
+			continue
+		}
+
+		switch {
+		case user.LastActivity.After(run.StartedAt):
+			// The owner checked in, e.g. through Telegram or an API token
+			log.Printf("User %s has been active during the grace period, aborting switch", user.ID)
+
+			run.Status = models.DeliveryRunStatusAborted
+			run.CompletedAt = &now
+			if err := s.repo.UpdateDeliveryRun(ctx, run); err != nil {
+				log.Printf("Failed to abort delivery run %s: %v", run.ID, err)
+				continue
+			}
 
-			// Create audit log entry for switch trigger
 			auditLog := &models.AuditLog{
 				ID:        uuid.New().String(),
 				UserID:    user.ID,
-				Action:    "switch_triggered",
-				Timestamp: time.Now().UTC(),
-				Details:   fmt.Sprintf("Dead man's switch triggered after no activity for %d days", user.PingDeadline),
+				Action:    "switch_trigger_aborted",
+				Timestamp: now,
+				Details: fmt.Sprintf("Armed switch aborted due to activity at %s",
+					user.LastActivity.UTC().Format(time.RFC3339)),
 			}
 
 			if err := s.repo.CreateAuditLog(ctx, auditLog); err != nil {
-				log.Printf("Failed to create audit log for switch trigger: %v", err)
+				log.Printf("Failed to create audit log for aborted switch: %v", err)
 			}
 
-			// Deliver secrets
-			if err := s.deliverSecrets(ctx, user); err != nil {
-				log.Printf("Failed to deliver secrets for user %s: %v", user.ID, err)
+		case !now.Before(run.FiresAt):
+			log.Printf("Grace period of user %s is over, triggering switch", user.ID)
+
+			run.Status = models.DeliveryRunStatusInProgress
+			if err := s.repo.UpdateDeliveryRun(ctx, run); err != nil {
+				log.Printf("Failed to start delivery run %s: %v", run.ID, err)
+				continue
 			}
+
+			s.triggerSwitch(ctx, user, run)
+
+		case run.LastAlertAt == nil || now.Sub(*run.LastAlertAt) >= triggerAlertInterval:
+			s.sendTriggerAlerts(ctx, user, run)
 		}
 	}
 
 	return nil
 }
 
-// deliverSecrets delivers a user's secrets to their recipients
-func (s *Scheduler) deliverSecrets(ctx context.Context, user *models.User) error {
+// sendTriggerAlerts tells the owner of an armed switch on every channel that their
+// secrets are about to be released, with a link to abort
+func (s *Scheduler) sendTriggerAlerts(ctx context.Context, user *models.User, run *models.DeliveryRun) {
+	abortURL := fmt.Sprintf("https://%s/abort/%s", s.config.BaseDomain, run.AbortCode)
+	remaining := formatDuration(time.Until(run.FiresAt))
+
+	body := fmt.Sprintf(`You haven't checked in for %d days, so your Dead Man's Switch has been armed.
+
+Your secrets will be released to your recipients in %s, at %s.
+
+If you are OK, open this link to abort the release and reset your switch:
+
+%s
+
+Checking in any other way, for example on the dashboard or in Telegram, aborts it too.
+`,
+		user.PingDeadline, remaining, run.FiresAt.Format("Jan 2, 2006 15:04 MST"), abortURL)
+
+	if err := s.emailClient.SendEmailSimple([]string{user.Email}, "Your Dead Man's Switch has been armed", body, false); err != nil {
+		log.Printf("Failed to send trigger alert email to user %s: %v", user.ID, err)
+	}
+
+	if user.TelegramID != "" && s.telegramBot != nil {
+		if err := s.telegramBot.SendPingMessage(ctx, user, "0", "switch_armed"); err != nil {
+			log.Printf("Failed to send trigger alert via Telegram to user %s: %v", user.ID, err)
+		}
+	}
+
+	now := time.Now().UTC()
+	run.LastAlertAt = &now
+	if err := s.repo.UpdateDeliveryRun(ctx, run); err != nil {
+		log.Printf("Failed to update delivery run %s: %v", run.ID, err)
+	}
+}
+
+// deliverSecrets queues a delivery for each of a user's recipients and makes the first attempt right away,
+// except for the recipients with a release delay whose deliveries are scheduled for later.
+// The deliveries belong to a delivery run; passing the unfinished run of an earlier trigger resumes it
+// without queueing or mailing the recipients that were already handled.
+func (s *Scheduler) deliverSecrets(ctx context.Context, user *models.User, run *models.DeliveryRun) error {
+	if run == nil {
+		now := time.Now().UTC()
+		run = &models.DeliveryRun{
+			ID:        uuid.New().String(),
+			UserID:    user.ID,
+			Status:    models.DeliveryRunStatusInProgress,
+			StartedAt: now,
+			FiresAt:   now,
+		}
+		if err := s.repo.CreateDeliveryRun(ctx, run); err != nil {
+			return fmt.Errorf("failed to create delivery run for user %s: %w", user.ID, err)
+		}
+	}
+
+	// Recipients that already have a delivery in this run were queued before a restart
+	events, err := s.repo.ListDeliveryEventsByRunID(ctx, run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get deliveries of run %s: %w", run.ID, err)
+	}
+	queued := make(map[string]bool, len(events))
+	for _, event := range events {
+		queued[event.RecipientID] = true
+	}
+
 	// Get all recipients for this user
 	recipients, err := s.repo.ListRecipientsByUserID(ctx, user.ID)
 	if err != nil {
@@ -475,7 +717,13 @@ func (s *Scheduler) deliverSecrets(ctx context.Context, user *models.User) error
 
 	log.Printf("Delivering secrets for user %s to %d recipients", user.ID, len(recipients))
 
+	recipientsByID := make(map[string]*models.Recipient, len(recipients))
 	for _, recipient := range recipients {
+		recipientsByID[recipient.ID] = recipient
+		if queued[recipient.ID] {
+			continue
+		}
+
 		// Get secret assignments for this recipient
 		assignments, err := s.repo.ListSecretAssignmentsByRecipientID(ctx, recipient.ID)
 		if err != nil {
@@ -488,90 +736,505 @@ func (s *Scheduler) deliverSecrets(ctx context.Context, user *models.User) error
 			continue
 		}
 
-		// Create delivery event first to get an ID
+		// Queue the delivery first, so it is retried even if the first attempt never finishes.
+		// A recipient with a release delay gets theirs scheduled for later instead.
+		now := time.Now().UTC()
+		releaseAt := run.FiresAt.AddDate(0, 0, recipient.ReleaseDelayDays)
+		status := models.DeliveryStatusScheduled
+		if !releaseAt.After(now) {
+			releaseAt = now
+			status = models.DeliveryStatusPending
+		}
+
 		deliveryEvent := &models.DeliveryEvent{
-			ID:          uuid.New().String(),
-			UserID:      user.ID,
-			RecipientID: recipient.ID,
-			SentAt:      time.Now().UTC(),
-			Status:      "pending",
+			ID:            uuid.New().String(),
+			UserID:        user.ID,
+			RunID:         run.ID,
+			RecipientID:   recipient.ID,
+			SentAt:        releaseAt,
+			Status:        status,
+			NextAttemptAt: &releaseAt,
 		}
 		if err := s.repo.CreateDeliveryEvent(ctx, deliveryEvent); err != nil {
 			log.Printf("Failed to create delivery event: %v", err)
 			continue
 		}
+		events = append(events, deliveryEvent)
+	}
+
+	// Every recipient has a queued delivery now, so the switch doesn't need to trigger again.
+	// Until this point a restarted server resumes the run from deadSwitchTask.
+	user.PingingEnabled = false
+	if err := s.repo.UpdateUser(ctx, user); err != nil {
+		log.Printf("Failed to update user after secret delivery: %v", err)
+	}
+
+	// Attempt the deliveries that are due; those waiting for a retry or their release are left to deliveryRetryTask
+	now := time.Now().UTC()
+	for _, event := range events {
+		if !event.IsQueued() {
+			continue
+		}
+		if event.NextAttemptAt != nil && event.NextAttemptAt.After(now) {
+			continue
+		}
+
+		recipient, ok := recipientsByID[event.RecipientID]
+		if !ok {
+			continue
+		}
+
+		s.attemptDelivery(ctx, event, recipient)
+	}
+
+	s.finishDeliveryRun(ctx, run.ID)
+
+	// Log the delivery
+	log.Printf("Delivered all secrets for user %s", user.ID)
+
+	return nil
+}
+
+// finishDeliveryRun completes a delivery run once none of its deliveries are queued anymore
+func (s *Scheduler) finishDeliveryRun(ctx context.Context, runID string) {
+	events, err := s.repo.ListDeliveryEventsByRunID(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to get deliveries of run %s: %v", runID, err)
+		return
+	}
+
+	for _, event := range events {
+		if event.IsQueued() {
+			return
+		}
+	}
+
+	if err := s.repo.CompleteDeliveryRun(ctx, runID); err != nil {
+		log.Printf("Failed to complete delivery run %s: %v", runID, err)
+	}
+}
+
+// deliveryRetryTask retries the queued deliveries whose next attempt is due and makes
+// the delayed releases whose time has come
+func (s *Scheduler) deliveryRetryTask(ctx context.Context) error {
+	s.deliveryLock.Lock()
+	defer s.deliveryLock.Unlock()
+
+	// An owner who came back stops the releases that haven't happened yet
+	if err := s.cancelStagedReleases(ctx); err != nil {
+		log.Printf("Failed to cancel staged releases: %v", err)
+	}
+
+	events, err := s.repo.ListDueDeliveryEvents(ctx, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to get due delivery events: %w", err)
+	}
+
+	if len(events) > 0 {
+		log.Printf("Retrying %d queued deliveries", len(events))
+	}
+
+	for _, event := range events {
+		recipient, err := s.repo.GetRecipientByID(ctx, event.RecipientID)
+		if err != nil {
+			s.recordDeliveryAttempt(ctx, event, nil, fmt.Errorf("failed to get recipient: %w", err))
+			continue
+		}
+
+		s.attemptDelivery(ctx, event, recipient)
+	}
+
+	// Complete the runs whose last queued delivery was just handled
+	checked := make(map[string]bool)
+	for _, event := range events {
+		if event.RunID == "" || checked[event.RunID] {
+			continue
+		}
+		checked[event.RunID] = true
+		s.finishDeliveryRun(ctx, event.RunID)
+	}
+
+	return nil
+}
+
+// cancelStagedReleases cancels the scheduled deliveries of the runs whose owner has been
+// active since the switch fired. Deliveries that were already released are left alone.
+func (s *Scheduler) cancelStagedReleases(ctx context.Context) error {
+	runs, err := s.repo.ListDeliveryRunsByStatus(ctx, models.DeliveryRunStatusInProgress)
+	if err != nil {
+		return fmt.Errorf("failed to get delivery runs in progress: %w", err)
+	}
+
+	for _, run := range runs {
+		user, err := s.repo.GetUserByID(ctx, run.UserID)
+		if err != nil {
+			log.Printf("Failed to get user %s of delivery run %s: %v", run.UserID, run.ID, err)
+			continue
+		}
 
-		// Generate and hash access code
-		accessCode := generateAccessCode()
-		hashedCode, err := crypto.HashPassword(accessCode, nil)
+		if !user.LastActivity.After(run.FiresAt) {
+			continue
+		}
+
+		events, err := s.repo.ListDeliveryEventsByRunID(ctx, run.ID)
 		if err != nil {
-			log.Printf("Failed to hash access code: %v", err)
-			continue
-		}
-		hashedCodeStr := base64.StdEncoding.EncodeToString(hashedCode)
-
-		// Store access code securely with TTL
-		accessCodeModel := &models.AccessCode{
-			ID:              uuid.New().String(),
-			Code:            hashedCodeStr,
-			RecipientID:     recipient.ID,
-			UserID:          user.ID,
-			DeliveryEventID: deliveryEvent.ID,
-			CreatedAt:       time.Now().UTC(),
-			ExpiresAt:       time.Now().UTC().Add(time.Duration(s.config.AccessCodeExpirationDays) * 24 * time.Hour),
-			MaxAttempts:     s.config.AccessCodeMaxAttempts,
-		}
-
-		if err := s.repo.CreateAccessCode(ctx, accessCodeModel); err != nil {
-			log.Printf("Failed to store access code for recipient %s: %v", recipient.ID, err)
-			// Update delivery event to failed
-			deliveryEvent.Status = "failed"
-			deliveryEvent.ErrorMessage = fmt.Sprintf("Failed to store access code: %v", err)
-			if updateErr := s.repo.UpdateDeliveryEvent(ctx, deliveryEvent); updateErr != nil {
-				log.Printf("Failed to update delivery event: %v", updateErr)
-			}
+			log.Printf("Failed to get deliveries of run %s: %v", run.ID, err)
 			continue
 		}
 
-		// Send delivery email
-		if err := s.emailClient.SendSecretDeliveryEmail(
-			recipient.Email,
-			recipient.Name,
-			recipient.Message,
-			accessCode,
-		); err != nil {
-			log.Printf("Failed to send delivery email to %s: %v", recipient.Email, err)
+		cancelled := 0
+		for _, event := range events {
+			if event.Status != models.DeliveryStatusScheduled {
+				continue
+			}
 
-			// Update delivery event to failed
-			deliveryEvent.Status = "failed"
-			deliveryEvent.ErrorMessage = err.Error()
-			if updateErr := s.repo.UpdateDeliveryEvent(ctx, deliveryEvent); updateErr != nil {
-				log.Printf("Failed to update delivery event: %v", updateErr)
+			event.Status = models.DeliveryStatusCancelled
+			event.NextAttemptAt = nil
+			if err := s.repo.UpdateDeliveryEvent(ctx, event); err != nil {
+				log.Printf("Failed to cancel delivery event %s: %v", event.ID, err)
+				continue
 			}
+			cancelled++
+		}
 
+		if cancelled == 0 {
 			continue
 		}
 
-		// Update delivery event to sent
-		deliveryEvent.Status = "sent"
-		deliveryEvent.ErrorMessage = ""
-		if err := s.repo.UpdateDeliveryEvent(ctx, deliveryEvent); err != nil {
-			log.Printf("Failed to update delivery event: %v", err)
+		log.Printf("User %s has been active since their switch fired, cancelled %d scheduled releases", user.ID, cancelled)
+
+		auditLog := &models.AuditLog{
+			ID:        uuid.New().String(),
+			UserID:    user.ID,
+			Action:    "staged_release_cancelled",
+			Timestamp: time.Now().UTC(),
+			Details: fmt.Sprintf("Cancelled %d scheduled releases due to activity at %s",
+				cancelled, user.LastActivity.UTC().Format(time.RFC3339)),
+		}
+
+		if err := s.repo.CreateAuditLog(ctx, auditLog); err != nil {
+			log.Printf("Failed to create audit log for cancelled releases: %v", err)
 		}
+
+		s.finishDeliveryRun(ctx, run.ID)
 	}
 
-	// Disable pinging for this user now that secrets have been delivered
-	user.PingingEnabled = false
-	if err := s.repo.UpdateUser(ctx, user); err != nil {
-		log.Printf("Failed to update user after secret delivery: %v", err)
+	return nil
+}
+
+// NotifyEmergencyAccessRequest tells the owner on every channel that a recipient has requested
+// emergency access, with a link to deny it
+func (s *Scheduler) NotifyEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	user, err := s.repo.GetUserByID(ctx, request.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
 	}
 
-	// Log the delivery
-	log.Printf("Delivered all secrets for user %s", user.ID)
+	recipient, err := s.repo.GetRecipientByID(ctx, request.RecipientID)
+	if err != nil {
+		return fmt.Errorf("failed to get recipient: %w", err)
+	}
+
+	denyURL := fmt.Sprintf("https://%s/emergency-access/deny/%s", s.config.BaseDomain, request.DenyCode)
+
+	body := fmt.Sprintf(`%s <%s> has requested emergency access to the secrets you assigned to them, because they believe you are incapacitated.
+
+Unless you deny the request, their secrets will be released at %s.
+
+If you are OK, open this link to deny the request:
+
+%s
+`,
+		recipient.Name, recipient.Email, request.ReleasesAt.Format("Jan 2, 2006 15:04 MST"), denyURL)
+
+	var sendErr error
+	if err := s.emailClient.SendEmailSimple([]string{user.Email}, "Emergency access to your secrets was requested", body, false); err != nil {
+		log.Printf("Failed to send emergency access email to user %s: %v", user.ID, err)
+		sendErr = err
+	}
+
+	if user.TelegramID != "" && s.telegramBot != nil {
+		if err := s.telegramBot.SendPingMessage(ctx, user, request.DenyCode, "emergency_access"); err != nil {
+			log.Printf("Failed to send emergency access alert via Telegram to user %s: %v", user.ID, err)
+		} else {
+			sendErr = nil
+		}
+	}
+
+	return sendErr
+}
+
+// emergencyAccessTask releases the secrets of the recipients whose emergency access
+// request wasn't denied within the owner's waiting period
+func (s *Scheduler) emergencyAccessTask(ctx context.Context) error {
+	s.deliveryLock.Lock()
+	defer s.deliveryLock.Unlock()
+
+	requests, err := s.repo.ListDueEmergencyAccessRequests(ctx, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to get due emergency access requests: %w", err)
+	}
+
+	for _, request := range requests {
+		recipient, err := s.repo.GetRecipientByID(ctx, request.RecipientID)
+		if err != nil {
+			log.Printf("Failed to get recipient %s of emergency access request %s: %v", request.RecipientID, request.ID, err)
+			continue
+		}
+
+		now := time.Now().UTC()
+		request.Status = models.EmergencyAccessStatusGranted
+		request.ResolvedAt = &now
+		if err := s.repo.UpdateEmergencyAccessRequest(ctx, request); err != nil {
+			log.Printf("Failed to grant emergency access request %s: %v", request.ID, err)
+			continue
+		}
+
+		log.Printf("Emergency access request %s was not denied, releasing secrets to recipient %s", request.ID, recipient.ID)
+
+		auditLog := &models.AuditLog{
+			ID:        uuid.New().String(),
+			UserID:    request.UserID,
+			Action:    "emergency_access_granted",
+			Timestamp: now,
+			Details: fmt.Sprintf("Emergency access of %s <%s> was not denied, their secrets were released",
+				recipient.Name, recipient.Email),
+		}
+
+		if err := s.repo.CreateAuditLog(ctx, auditLog); err != nil {
+			log.Printf("Failed to create audit log for emergency access: %v", err)
+		}
+
+		// The delivery doesn't belong to a run, the switch itself hasn't fired
+		event := &models.DeliveryEvent{
+			ID:            uuid.New().String(),
+			UserID:        request.UserID,
+			RecipientID:   recipient.ID,
+			SentAt:        now,
+			Status:        models.DeliveryStatusPending,
+			NextAttemptAt: &now,
+		}
+		if err := s.repo.CreateDeliveryEvent(ctx, event); err != nil {
+			log.Printf("Failed to create delivery event: %v", err)
+			continue
+		}
+
+		s.attemptDelivery(ctx, event, recipient)
+	}
 
 	return nil
 }
 
+// attemptDelivery makes one attempt at sending a delivery event and records the outcome
+func (s *Scheduler) attemptDelivery(ctx context.Context, event *models.DeliveryEvent, recipient *models.Recipient) {
+	err := s.sendDelivery(ctx, event, recipient)
+	if err != nil {
+		log.Printf("Failed to deliver secrets to %s: %v", recipient.Email, err)
+	}
+
+	s.recordDeliveryAttempt(ctx, event, recipient, err)
+}
+
+// sendDelivery generates a fresh access code for a delivery event and emails it to the recipient
+func (s *Scheduler) sendDelivery(ctx context.Context, event *models.DeliveryEvent, recipient *models.Recipient) error {
+	assignments, err := s.repo.ListSecretAssignmentsByRecipientID(ctx, recipient.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get secret assignments: %w", err)
+	}
+
+	// Generate and hash access code. Codes of earlier failed attempts stay valid until
+	// they expire, a send error doesn't prove that the email never arrived.
+	accessCode := generateAccessCode()
+
+	// Store access code securely with TTL, the keyed hash lets the portal look it up directly
+	accessCodeModel := &models.AccessCode{
+		ID:              uuid.New().String(),
+		Code:            s.sealer.HashAccessCode(accessCode),
+		RecipientID:     recipient.ID,
+		UserID:          event.UserID,
+		DeliveryEventID: event.ID,
+		CreatedAt:       time.Now().UTC(),
+		ExpiresAt:       time.Now().UTC().Add(time.Duration(s.config.AccessCodeExpirationDays) * 24 * time.Hour),
+		MaxAttempts:     s.config.AccessCodeMaxAttempts,
+	}
+
+	if err := s.repo.CreateAccessCode(ctx, accessCodeModel); err != nil {
+		return fmt.Errorf("failed to store access code: %w", err)
+	}
+
+	// Collect key shares for quorum protected secrets
+	shares, shareAssignments := s.collectQuorumShares(ctx, assignments)
+
+	// Send delivery email
+	if err := s.emailClient.SendSecretDeliveryEmail(
+		recipient.Email,
+		recipient.Name,
+		recipient.Message,
+		accessCode,
+		shares,
+	); err != nil {
+		return err
+	}
+
+	// The recipient holds their shares now, so the server forgets them
+	for _, assignment := range shareAssignments {
+		assignment.DeliveryData = ""
+		if err := s.repo.UpdateSecretAssignment(ctx, assignment); err != nil {
+			log.Printf("Failed to clear delivered share for assignment %s: %v", assignment.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// recordDeliveryAttempt stores the outcome of a delivery attempt and schedules the next one.
+// Once the retry horizon has passed the event is given up and the admin is alerted.
+func (s *Scheduler) recordDeliveryAttempt(ctx context.Context, event *models.DeliveryEvent, recipient *models.Recipient, sendErr error) {
+	now := time.Now().UTC()
+
+	event.Attempts++
+	event.LastAttemptAt = &now
+
+	attempt := &models.DeliveryAttempt{
+		ID:              uuid.New().String(),
+		DeliveryEventID: event.ID,
+		AttemptedAt:     now,
+		Success:         sendErr == nil,
+	}
+
+	switch {
+	case sendErr == nil:
+		event.Status = models.DeliveryStatusSent
+		event.ErrorMessage = ""
+		event.NextAttemptAt = nil
+	case now.Sub(event.SentAt) >= s.config.DeliveryRetryHorizon:
+		attempt.ErrorMessage = sendErr.Error()
+		event.Status = models.DeliveryStatusFailed
+		event.ErrorMessage = sendErr.Error()
+		event.NextAttemptAt = nil
+	default:
+		attempt.ErrorMessage = sendErr.Error()
+		next := now.Add(s.retryDelay(event.Attempts))
+		event.Status = models.DeliveryStatusRetrying
+		event.ErrorMessage = sendErr.Error()
+		event.NextAttemptAt = &next
+	}
+
+	if err := s.repo.CreateDeliveryAttempt(ctx, attempt); err != nil {
+		log.Printf("Failed to record delivery attempt: %v", err)
+	}
+
+	if err := s.repo.UpdateDeliveryEvent(ctx, event); err != nil {
+		log.Printf("Failed to update delivery event: %v", err)
+	}
+
+	if event.Status == models.DeliveryStatusFailed {
+		s.reportFailedDelivery(ctx, event, recipient)
+	}
+}
+
+// retryDelay returns how long to wait after the given number of failed attempts.
+// The delay doubles with every attempt, up to the configured maximum.
+func (s *Scheduler) retryDelay(attempts int) time.Duration {
+	delay := s.config.DeliveryRetryBaseDelay
+	for i := 1; i < attempts && delay < s.config.DeliveryRetryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > s.config.DeliveryRetryMaxDelay {
+		delay = s.config.DeliveryRetryMaxDelay
+	}
+
+	return delay
+}
+
+// reportFailedDelivery records a delivery that was given up in the audit log and alerts the admin
+func (s *Scheduler) reportFailedDelivery(ctx context.Context, event *models.DeliveryEvent, recipient *models.Recipient) {
+	recipientName := event.RecipientID
+	if recipient != nil {
+		recipientName = fmt.Sprintf("%s <%s>", recipient.Name, recipient.Email)
+	}
+
+	log.Printf("Giving up delivery %s to %s after %d attempts", event.ID, recipientName, event.Attempts)
+
+	auditLog := &models.AuditLog{
+		ID:        uuid.New().String(),
+		UserID:    event.UserID,
+		Action:    "delivery_failed",
+		Timestamp: time.Now().UTC(),
+		Details: fmt.Sprintf("Delivery to recipient %s failed after %d attempts: %s",
+			recipientName, event.Attempts, event.ErrorMessage),
+	}
+
+	if err := s.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Failed to create audit log for failed delivery: %v", err)
+	}
+
+	if s.config.AdminEmail == "" {
+		return
+	}
+
+	body := fmt.Sprintf(`Delivering the secrets of user %s to recipient %s has failed and will not be retried.
+
+Delivery event: %s
+Queued at: %s
+Attempts: %d
+Last error: %s
+
+The recipient may not have received an access link. Check the mail server and contact the recipient directly.
+`,
+		event.UserID, recipientName, event.ID, event.SentAt.Format(time.RFC3339), event.Attempts, event.ErrorMessage)
+
+	if err := s.emailClient.SendEmailSimple([]string{s.config.AdminEmail}, "Secret delivery failed", body, false); err != nil {
+		log.Printf("Failed to alert admin about failed delivery %s: %v", event.ID, err)
+	}
+}
+
+// collectQuorumShares opens the sealed key shares held for a recipient's quorum
+// protected secrets. It returns the shares and the assignments they came from.
+func (s *Scheduler) collectQuorumShares(ctx context.Context, assignments []*models.SecretAssignment) ([]email.QuorumShare, []*models.SecretAssignment) {
+	var shares []email.QuorumShare
+	var shareAssignments []*models.SecretAssignment
+
+	for _, assignment := range assignments {
+		if assignment.DeliveryData == "" {
+			continue
+		}
+
+		secret, err := s.repo.GetSecretByID(ctx, assignment.SecretID)
+		if err != nil {
+			log.Printf("Failed to get secret %s: %v", assignment.SecretID, err)
+			continue
+		}
+
+		if !secret.IsQuorumProtected() {
+			continue
+		}
+
+		share, err := s.sealer.Open(assignment.DeliveryData)
+		if err != nil {
+			log.Printf("Failed to open key share for secret %s: %v", secret.ID, err)
+			continue
+		}
+
+		siblings, err := s.repo.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+		if err != nil {
+			log.Printf("Failed to get secret assignments for secret %s: %v", secret.ID, err)
+			continue
+		}
+
+		shares = append(shares, email.QuorumShare{
+			SecretName: secret.Name,
+			Share:      string(share),
+			Threshold:  secret.QuorumThreshold,
+			Total:      len(siblings),
+		})
+		shareAssignments = append(shareAssignments, assignment)
+	}
+
+	return shares, shareAssignments
+}
+
 // externalActivityTask checks for user activity on external platforms
 func (s *Scheduler) externalActivityTask(ctx context.Context) error {
 	log.Println("Running externalActivityTask")
@@ -628,6 +1291,9 @@ func (s *Scheduler) externalActivityTask(ctx context.Context) error {
 				log.Printf("Failed to update user after external activity: %v", err)
 				continue
 			}
+			if err := s.sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+				log.Printf("Failed to renew question timelocks for user %s: %v", user.ID, err)
+			}
 
 			// Create detailed audit log entries for each active provider
 			for _, providerName := range activeProviderNames {
@@ -776,6 +1442,61 @@ func (s *Scheduler) reminderTask(ctx context.Context) error {
 	return nil
 }
 
+// questionTimelockTask turns off the timelock of personal questions whose last
+// copy opened before the owner's deadline, because the owner didn't sign in with
+// their password to move it, and tells the owner
+func (s *Scheduler) questionTimelockTask(ctx context.Context) error {
+	if !s.sealer.TimelockEnabled() {
+		return nil
+	}
+
+	users, err := s.repo.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+
+	for _, user := range users {
+		disabled, err := s.sealer.DisableOpenedQuestionTimelocks(ctx, user)
+		if err != nil {
+			log.Printf("Failed to turn off opened question timelocks of user %s: %v", user.ID, err)
+		}
+		if len(disabled) == 0 {
+			continue
+		}
+
+		names := make([]string, 0, len(disabled))
+		for _, recipient := range disabled {
+			names = append(names, fmt.Sprintf("%s <%s>", recipient.Name, recipient.Email))
+		}
+
+		body := fmt.Sprintf(`You haven't signed in to your Dead Man's Switch with your password for two months, so the timelock of the personal questions of these recipients couldn't be moved to your deadline and has opened:
+
+%s
+
+Their questions are no longer timelocked, anyone who gets hold of the server's data can try to guess the answers. Sign in with your password to timelock them again.
+`,
+			strings.Join(names, "\n"))
+
+		if err := s.emailClient.SendEmailSimple([]string{user.Email}, "The timelock of your personal questions was turned off", body, false); err != nil {
+			log.Printf("Failed to send question timelock email to user %s: %v", user.ID, err)
+		}
+
+		auditLog := &models.AuditLog{
+			ID:        uuid.New().String(),
+			UserID:    user.ID,
+			Action:    "question_timelock_disabled",
+			Timestamp: time.Now().UTC(),
+			Details:   "Question timelock opened before the deadline and was turned off for: " + strings.Join(names, ", "),
+		}
+
+		if err := s.repo.CreateAuditLog(ctx, auditLog); err != nil {
+			log.Printf("Failed to create audit log for question timelocks: %v", err)
+		}
+	}
+
+	return nil
+}
+
 // cleanupTask handles cleanup operations
 func (s *Scheduler) cleanupTask(ctx context.Context) error {
 	log.Println("Running cleanupTask")
@@ -814,6 +1535,11 @@ func generateAccessCode() string {
 	return uuid.New().String()
 }
 
+// generateAbortCode creates the code of the abort link for an armed switch
+func generateAbortCode() string {
+	return uuid.New().String()
+}
+
 // formatDuration formats a duration in a human-readable way
 func formatDuration(d time.Duration) string {
 	d = d.Round(time.Minute)
@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -21,6 +22,9 @@ type MockRepository struct {
 	pingHistories         []*models.PingHistory
 	pingVerifications     []*models.PingVerification
 	deliveryEvents        []*models.DeliveryEvent
+	deliveryAttempts      []*models.DeliveryAttempt
+	deliveryRuns          []*models.DeliveryRun
+	emergencyRequests     []*models.EmergencyAccessRequest
 	auditLogs             []*models.AuditLog
 	sessions              []*models.Session
 	usersForPinging       []*models.User
@@ -43,6 +47,9 @@ func NewMockRepository() *MockRepository {
 		pingHistories:         make([]*models.PingHistory, 0),
 		pingVerifications:     make([]*models.PingVerification, 0),
 		deliveryEvents:        make([]*models.DeliveryEvent, 0),
+		deliveryAttempts:      make([]*models.DeliveryAttempt, 0),
+		deliveryRuns:          make([]*models.DeliveryRun, 0),
+		emergencyRequests:     make([]*models.EmergencyAccessRequest, 0),
 		auditLogs:             make([]*models.AuditLog, 0),
 		sessions:              make([]*models.Session, 0),
 		usersForPinging:       make([]*models.User, 0),
@@ -85,6 +92,10 @@ func (m *MockRepository) UpdateUser(ctx context.Context, user *models.User) erro
 	return nil
 }
 
+func (m *MockRepository) UpdateUserVaultKey(ctx context.Context, userID, salt, encryptedVaultKey string) error {
+	return nil
+}
+
 func (m *MockRepository) ListRecipientsByUserID(ctx context.Context, userID string) ([]*models.Recipient, error) {
 	var result []*models.Recipient
 	for _, r := range m.recipients {
@@ -136,7 +147,12 @@ func (m *MockRepository) DeleteExpiredSessions(ctx context.Context) error {
 // Implement other methods of the Repository interface with empty implementations
 func (m *MockRepository) CreateUser(ctx context.Context, user *models.User) error { return nil }
 func (m *MockRepository) GetUserByID(ctx context.Context, id string) (*models.User, error) {
-	return nil, nil
+	for _, u := range m.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, storage.ErrNotFound
 }
 func (m *MockRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	return nil, nil
@@ -164,12 +180,36 @@ func (m *MockRepository) CreateRecipient(ctx context.Context, recipient *models.
 	return nil
 }
 func (m *MockRepository) GetRecipientByID(ctx context.Context, id string) (*models.Recipient, error) {
+	for _, r := range m.recipients {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+func (m *MockRepository) ListRecipientsByEmail(ctx context.Context, email string) ([]*models.Recipient, error) {
 	return nil, nil
 }
 func (m *MockRepository) UpdateRecipient(ctx context.Context, recipient *models.Recipient) error {
 	return nil
 }
 func (m *MockRepository) DeleteRecipient(ctx context.Context, id string) error { return nil }
+func (m *MockRepository) ListRecipientQuestions(ctx context.Context, recipientID string) ([]*models.RecipientQuestion, error) {
+	return nil, nil
+}
+func (m *MockRepository) ReplaceRecipientQuestions(ctx context.Context, recipientID string, questions []*models.RecipientQuestion) error {
+	return nil
+}
+func (m *MockRepository) ListQuestionTimelocks(ctx context.Context, recipientID string) ([]*models.QuestionTimelock, error) {
+	return nil, nil
+}
+func (m *MockRepository) ListQuestionTimelocksByUserID(ctx context.Context, userID string) ([]*models.QuestionTimelock, error) {
+	return nil, nil
+}
+func (m *MockRepository) ReplaceQuestionTimelocks(ctx context.Context, recipientID string, timelocks []*models.QuestionTimelock) error {
+	return nil
+}
+func (m *MockRepository) DeleteQuestionTimelock(ctx context.Context, id string) error { return nil }
 func (m *MockRepository) CreateSecretAssignment(ctx context.Context, assignment *models.SecretAssignment) error {
 	return nil
 }
@@ -182,6 +222,9 @@ func (m *MockRepository) ListSecretAssignmentsBySecretID(ctx context.Context, se
 func (m *MockRepository) ListSecretAssignmentsByUserID(ctx context.Context, userID string) ([]*models.SecretAssignment, error) {
 	return nil, nil
 }
+func (m *MockRepository) UpdateSecretAssignment(ctx context.Context, assignment *models.SecretAssignment) error {
+	return nil
+}
 func (m *MockRepository) DeleteSecretAssignment(ctx context.Context, id string) error { return nil }
 func (m *MockRepository) UpdatePingHistory(ctx context.Context, ping *models.PingHistory) error {
 	return nil
@@ -248,6 +291,9 @@ func (m *MockRepository) CreateAccessCode(ctx context.Context, code *models.Acce
 func (m *MockRepository) GetAccessCodeByCode(ctx context.Context, code string) (*models.AccessCode, error) {
 	return nil, nil
 }
+func (m *MockRepository) VerifyAccessCodeHash(ctx context.Context, codeHash string) (*models.AccessCode, error) {
+	return nil, nil
+}
 func (m *MockRepository) VerifyAccessCode(ctx context.Context, code string) (*models.AccessCode, error) {
 	return nil, nil
 }
@@ -257,55 +303,165 @@ func (m *MockRepository) MarkAccessCodeAsUsed(ctx context.Context, id string) er
 func (m *MockRepository) IncrementAccessCodeAttempts(ctx context.Context, id string) error {
 	return nil
 }
+func (m *MockRepository) ListAccessCodesByUserID(ctx context.Context, userID string) ([]*models.AccessCode, error) {
+	return nil, nil
+}
+func (m *MockRepository) RevokeAccessCodesByUserID(ctx context.Context, userID string) (int, error) {
+	return 0, nil
+}
 func (m *MockRepository) DeleteExpiredAccessCodes(ctx context.Context) error {
 	return nil
 }
 
-// SecretQuestion methods
-func (m *MockRepository) CreateSecretQuestion(ctx context.Context, question *models.SecretQuestion) error {
+// Secret version methods
+func (m *MockRepository) CreateSecretVersion(ctx context.Context, version *models.SecretVersion) error {
 	return nil
 }
-func (m *MockRepository) GetSecretQuestion(ctx context.Context, id string) (*models.SecretQuestion, error) {
+func (m *MockRepository) GetSecretVersionByID(ctx context.Context, id string) (*models.SecretVersion, error) {
 	return nil, nil
 }
-func (m *MockRepository) UpdateSecretQuestion(ctx context.Context, question *models.SecretQuestion) error {
-	return nil
+func (m *MockRepository) ListSecretVersionsBySecretID(ctx context.Context, secretID string) ([]*models.SecretVersion, error) {
+	return nil, nil
+}
+func (m *MockRepository) ListSecretVersionsByUserID(ctx context.Context, userID string) ([]*models.SecretVersion, error) {
+	return nil, nil
 }
-func (m *MockRepository) DeleteSecretQuestion(ctx context.Context, id string) error {
+func (m *MockRepository) DeleteSecretVersion(ctx context.Context, id string) error { return nil }
+
+// Share submission methods
+func (m *MockRepository) CreateShareSubmission(ctx context.Context, submission *models.ShareSubmission) error {
 	return nil
 }
-func (m *MockRepository) ListSecretQuestionsByAssignmentID(ctx context.Context, assignmentID string) ([]*models.SecretQuestion, error) {
+func (m *MockRepository) ListShareSubmissionsBySecretID(ctx context.Context, secretID string) ([]*models.ShareSubmission, error) {
 	return nil, nil
 }
+func (m *MockRepository) DeleteShareSubmissionsBySecretID(ctx context.Context, secretID string) error {
+	return nil
+}
 
-// SecretQuestionSet methods
-func (m *MockRepository) CreateSecretQuestionSet(ctx context.Context, set *models.SecretQuestionSet) error {
+// API token methods
+func (m *MockRepository) CreateAPIToken(ctx context.Context, token *models.APIToken) error {
 	return nil
 }
-func (m *MockRepository) GetSecretQuestionSet(ctx context.Context, id string) (*models.SecretQuestionSet, error) {
+func (m *MockRepository) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
 	return nil, nil
 }
-func (m *MockRepository) GetSecretQuestionSetByAssignmentID(ctx context.Context, assignmentID string) (*models.SecretQuestionSet, error) {
+func (m *MockRepository) ListAPITokensByUserID(ctx context.Context, userID string) ([]*models.APIToken, error) {
 	return nil, nil
 }
-func (m *MockRepository) UpdateSecretQuestionSet(ctx context.Context, set *models.SecretQuestionSet) error {
+func (m *MockRepository) UpdateAPITokenLastUsed(ctx context.Context, id string) error {
 	return nil
 }
-func (m *MockRepository) DeleteSecretQuestionSet(ctx context.Context, id string) error {
+func (m *MockRepository) RevokeAPIToken(ctx context.Context, id string) error {
 	return nil
 }
-func (m *MockRepository) ListSecretQuestionSetsNeedingReencryption(ctx context.Context, safeMarginSeconds int64) ([]*models.SecretQuestionSet, error) {
-	return nil, nil
-}
 
 // DeliveryEvent update method
 func (m *MockRepository) UpdateDeliveryEvent(ctx context.Context, event *models.DeliveryEvent) error {
 	return nil
 }
 
+// Delivery retry and run methods
+func (m *MockRepository) ListDueDeliveryEvents(ctx context.Context, now time.Time) ([]*models.DeliveryEvent, error) {
+	var result []*models.DeliveryEvent
+	for _, e := range m.deliveryEvents {
+		if !e.IsQueued() {
+			continue
+		}
+		if e.NextAttemptAt == nil || !e.NextAttemptAt.After(now) {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+func (m *MockRepository) ListDeliveryEventsByRunID(ctx context.Context, runID string) ([]*models.DeliveryEvent, error) {
+	var result []*models.DeliveryEvent
+	for _, e := range m.deliveryEvents {
+		if e.RunID == runID {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+func (m *MockRepository) CreateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error {
+	m.deliveryRuns = append(m.deliveryRuns, run)
+	return nil
+}
+func (m *MockRepository) GetActiveDeliveryRunByUserID(ctx context.Context, userID string) (*models.DeliveryRun, error) {
+	for _, r := range m.deliveryRuns {
+		if r.UserID == userID && (r.Status == models.DeliveryRunStatusArmed || r.Status == models.DeliveryRunStatusInProgress) {
+			return r, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+func (m *MockRepository) GetDeliveryRunByAbortCode(ctx context.Context, code string) (*models.DeliveryRun, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockRepository) ListDeliveryRunsByStatus(ctx context.Context, status string) ([]*models.DeliveryRun, error) {
+	var result []*models.DeliveryRun
+	for _, r := range m.deliveryRuns {
+		if r.Status == status {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+func (m *MockRepository) ListDeliveryRunsByUserID(ctx context.Context, userID string) ([]*models.DeliveryRun, error) {
+	return nil, nil
+}
+func (m *MockRepository) UpdateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error {
+	return nil
+}
+func (m *MockRepository) CompleteDeliveryRun(ctx context.Context, id string) error {
+	for _, r := range m.deliveryRuns {
+		if r.ID == id {
+			r.Status = models.DeliveryRunStatusCompleted
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+func (m *MockRepository) CreateDeliveryAttempt(ctx context.Context, attempt *models.DeliveryAttempt) error {
+	m.deliveryAttempts = append(m.deliveryAttempts, attempt)
+	return nil
+}
+func (m *MockRepository) ListDeliveryAttemptsByEventID(ctx context.Context, eventID string) ([]*models.DeliveryAttempt, error) {
+	return nil, nil
+}
+
+// Emergency access methods
+func (m *MockRepository) CreateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	m.emergencyRequests = append(m.emergencyRequests, request)
+	return nil
+}
+func (m *MockRepository) GetEmergencyAccessRequestByVerifyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockRepository) GetEmergencyAccessRequestByDenyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockRepository) ListEmergencyAccessRequestsByUserID(ctx context.Context, userID string) ([]*models.EmergencyAccessRequest, error) {
+	return nil, nil
+}
+func (m *MockRepository) ListDueEmergencyAccessRequests(ctx context.Context, now time.Time) ([]*models.EmergencyAccessRequest, error) {
+	var result []*models.EmergencyAccessRequest
+	for _, r := range m.emergencyRequests {
+		if r.Status == models.EmergencyAccessStatusPending && r.ReleasesAt != nil && !r.ReleasesAt.After(now) {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+func (m *MockRepository) UpdateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	return nil
+}
+
 // MockEmailClient is a mock implementation of the email client
 type MockEmailClient struct {
-	sentEmails int
+	sentEmails  int
+	deliveryErr error    // Returned by SendSecretDeliveryEmail when set
+	simpleTo    []string // Recipients of SendEmailSimple
 }
 
 func (m *MockEmailClient) SendPingEmail(email, verificationCode, urgency string) error {
@@ -313,7 +469,10 @@ func (m *MockEmailClient) SendPingEmail(email, verificationCode, urgency string)
 	return nil
 }
 
-func (m *MockEmailClient) SendSecretDeliveryEmail(recipientEmail, recipientName, message, accessCode string) error {
+func (m *MockEmailClient) SendSecretDeliveryEmail(recipientEmail, recipientName, message, accessCode string, shares []email.QuorumShare) error {
+	if m.deliveryErr != nil {
+		return m.deliveryErr
+	}
 	m.sentEmails++
 	return nil
 }
@@ -325,6 +484,7 @@ func (m *MockEmailClient) SendEmail(options *email.MessageOptions) error {
 
 func (m *MockEmailClient) SendEmailSimple(to []string, subject, body string, isHTML bool) error {
 	m.sentEmails++
+	m.simpleTo = append(m.simpleTo, to...)
 	return nil
 }
 
@@ -403,12 +563,12 @@ func TestRegisterTasks(t *testing.T) {
 		t.Fatalf("registerTasks failed: %v", err)
 	}
 
-	if len(scheduler.tasks) != 5 {
-		t.Errorf("Expected 5 tasks, got %d", len(scheduler.tasks))
+	if len(scheduler.tasks) != 9 {
+		t.Errorf("Expected 9 tasks, got %d", len(scheduler.tasks))
 	}
 
 	// Check that the expected tasks are registered
-	var hasPingTask, hasReminderTask, hasDeadSwitchTask, hasCleanupTask, hasExternalActivityTask bool
+	var hasPingTask, hasReminderTask, hasDeadSwitchTask, hasDeliveryRetryTask, hasEmergencyAccessTask, hasCleanupTask, hasExternalActivityTask, hasQuestionTimelockTask bool
 	for _, task := range scheduler.tasks {
 		switch task.Name {
 		case "PingTask":
@@ -435,6 +595,22 @@ func TestRegisterTasks(t *testing.T) {
 			if !task.RunOnStart {
 				t.Error("Expected DeadSwitchTask.RunOnStart to be true")
 			}
+		case "DeliveryRetryTask":
+			hasDeliveryRetryTask = true
+			if task.Duration != 5*time.Minute {
+				t.Errorf("Expected DeliveryRetryTask duration to be 5 minutes, got %v", task.Duration)
+			}
+			if !task.RunOnStart {
+				t.Error("Expected DeliveryRetryTask.RunOnStart to be true")
+			}
+		case "EmergencyAccessTask":
+			hasEmergencyAccessTask = true
+			if task.Duration != 5*time.Minute {
+				t.Errorf("Expected EmergencyAccessTask duration to be 5 minutes, got %v", task.Duration)
+			}
+			if !task.RunOnStart {
+				t.Error("Expected EmergencyAccessTask.RunOnStart to be true")
+			}
 		case "CleanupTask":
 			hasCleanupTask = true
 			if task.Duration != 24*time.Hour {
@@ -451,6 +627,11 @@ func TestRegisterTasks(t *testing.T) {
 			if !task.RunOnStart {
 				t.Error("Expected ExternalActivityTask.RunOnStart to be true")
 			}
+		case "QuestionTimelockTask":
+			hasQuestionTimelockTask = true
+			if task.Duration != 1*time.Hour {
+				t.Errorf("Expected QuestionTimelockTask duration to be 1 hour, got %v", task.Duration)
+			}
 		}
 	}
 
@@ -463,12 +644,21 @@ func TestRegisterTasks(t *testing.T) {
 	if !hasReminderTask {
 		t.Error("Expected ReminderTask to be registered")
 	}
+	if !hasDeliveryRetryTask {
+		t.Error("Expected DeliveryRetryTask to be registered")
+	}
+	if !hasEmergencyAccessTask {
+		t.Error("Expected EmergencyAccessTask to be registered")
+	}
 	if !hasCleanupTask {
 		t.Error("Expected CleanupTask to be registered")
 	}
 	if !hasExternalActivityTask {
 		t.Error("Expected ExternalActivityTask to be registered")
 	}
+	if !hasQuestionTimelockTask {
+		t.Error("Expected QuestionTimelockTask to be registered")
+	}
 }
 
 func TestStartStop(t *testing.T) {
@@ -901,6 +1091,16 @@ func TestDeadSwitchTask(t *testing.T) {
 	if user.PingingEnabled {
 		t.Error("Expected PingingEnabled to be false after delivery")
 	}
+
+	// Check that both deliveries belong to one completed run
+	if len(repo.deliveryRuns) != 1 || repo.deliveryRuns[0].Status != models.DeliveryRunStatusCompleted {
+		t.Fatalf("Expected 1 completed delivery run, got %d", len(repo.deliveryRuns))
+	}
+	for _, event := range repo.deliveryEvents {
+		if event.RunID != repo.deliveryRuns[0].ID {
+			t.Errorf("Expected delivery event %s to belong to the run", event.ID)
+		}
+	}
 }
 
 func TestCleanupTask(t *testing.T) {
@@ -1197,3 +1397,481 @@ func TestDeadSwitchTaskWithNoActivity(t *testing.T) {
 		t.Error("Expected user's PingingEnabled to be set to false")
 	}
 }
+
+func TestDeliveryRetry(t *testing.T) {
+	repo := NewMockRepository()
+	emailClient := &MockEmailClient{deliveryErr: fmt.Errorf("smtp: connection refused")}
+	scheduler := NewScheduler(repo, emailClient, &MockTelegramBot{}, &config.Config{
+		AdminEmail:             "admin@example.com",
+		DeliveryRetryBaseDelay: 5 * time.Minute,
+		DeliveryRetryMaxDelay:  time.Hour,
+		DeliveryRetryHorizon:   72 * time.Hour,
+	})
+
+	user := &models.User{ID: "user1", Email: "user1@example.com", PingingEnabled: true}
+	repo.usersWithExpiredPings = []*models.User{user}
+	repo.recipients = []*models.Recipient{{ID: "recipient1", UserID: "user1", Email: "recipient1@example.com", Name: "Recipient 1"}}
+	repo.secretAssignments = []*models.SecretAssignment{{ID: "assignment1", UserID: "user1", SecretID: "secret1", RecipientID: "recipient1"}}
+
+	ctx := context.Background()
+	if err := scheduler.deadSwitchTask(ctx); err != nil {
+		t.Fatalf("deadSwitchTask failed: %v", err)
+	}
+
+	if len(repo.deliveryEvents) != 1 {
+		t.Fatalf("Expected 1 delivery event, got %d", len(repo.deliveryEvents))
+	}
+	event := repo.deliveryEvents[0]
+	if event.Status != models.DeliveryStatusRetrying || event.Attempts != 1 || event.NextAttemptAt == nil {
+		t.Fatalf("Expected a scheduled retry after the first attempt, got status %q after %d attempts", event.Status, event.Attempts)
+	}
+	if delay := event.NextAttemptAt.Sub(*event.LastAttemptAt); delay != 5*time.Minute {
+		t.Errorf("Expected the first retry after 5 minutes, got %v", delay)
+	}
+
+	// The retry isn't due yet
+	if err := scheduler.deliveryRetryTask(ctx); err != nil {
+		t.Fatalf("deliveryRetryTask failed: %v", err)
+	}
+	if event.Attempts != 1 {
+		t.Errorf("Expected no attempt before the retry is due, got %d attempts", event.Attempts)
+	}
+
+	// The mail server is back
+	past := time.Now().UTC().Add(-time.Minute)
+	event.NextAttemptAt = &past
+	emailClient.deliveryErr = nil
+
+	if err := scheduler.deliveryRetryTask(ctx); err != nil {
+		t.Fatalf("deliveryRetryTask failed: %v", err)
+	}
+
+	if event.Status != models.DeliveryStatusSent || event.Attempts != 2 || event.NextAttemptAt != nil || event.ErrorMessage != "" {
+		t.Errorf("Expected the event to be sent on the second attempt, got status %q after %d attempts", event.Status, event.Attempts)
+	}
+	if emailClient.sentEmails != 1 {
+		t.Errorf("Expected 1 delivery email, got %d", emailClient.sentEmails)
+	}
+	if len(repo.deliveryAttempts) != 2 || repo.deliveryAttempts[0].Success || !repo.deliveryAttempts[1].Success {
+		t.Errorf("Expected a failed and a successful attempt to be recorded, got %d attempts", len(repo.deliveryAttempts))
+	}
+}
+
+func TestDeliveryGivesUpAfterHorizon(t *testing.T) {
+	repo := NewMockRepository()
+	emailClient := &MockEmailClient{deliveryErr: fmt.Errorf("smtp: connection refused")}
+	scheduler := NewScheduler(repo, emailClient, &MockTelegramBot{}, &config.Config{
+		AdminEmail:             "admin@example.com",
+		DeliveryRetryBaseDelay: 5 * time.Minute,
+		DeliveryRetryMaxDelay:  time.Hour,
+		DeliveryRetryHorizon:   72 * time.Hour,
+	})
+
+	repo.recipients = []*models.Recipient{{ID: "recipient1", UserID: "user1", Email: "recipient1@example.com", Name: "Recipient 1"}}
+	repo.secretAssignments = []*models.SecretAssignment{{ID: "assignment1", UserID: "user1", SecretID: "secret1", RecipientID: "recipient1"}}
+
+	// A delivery that has been failing for longer than the horizon
+	event := &models.DeliveryEvent{
+		ID:          "event1",
+		UserID:      "user1",
+		RecipientID: "recipient1",
+		SentAt:      time.Now().UTC().Add(-73 * time.Hour),
+		Status:      models.DeliveryStatusRetrying,
+		Attempts:    12,
+	}
+	repo.deliveryEvents = []*models.DeliveryEvent{event}
+
+	if err := scheduler.deliveryRetryTask(context.Background()); err != nil {
+		t.Fatalf("deliveryRetryTask failed: %v", err)
+	}
+
+	if event.Status != models.DeliveryStatusFailed || event.NextAttemptAt != nil || event.Attempts != 13 {
+		t.Errorf("Expected the event to be given up after 13 attempts, got status %q after %d attempts", event.Status, event.Attempts)
+	}
+	if len(emailClient.simpleTo) != 1 || emailClient.simpleTo[0] != "admin@example.com" {
+		t.Errorf("Expected the admin to be alerted, got emails to %v", emailClient.simpleTo)
+	}
+
+	var logged bool
+	for _, log := range repo.auditLogs {
+		if log.Action == "delivery_failed" && log.UserID == "user1" {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Error("Expected a delivery_failed audit log entry")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	scheduler := NewScheduler(nil, nil, nil, &config.Config{
+		DeliveryRetryBaseDelay: 5 * time.Minute,
+		DeliveryRetryMaxDelay:  time.Hour,
+	})
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Minute},
+		{2, 10 * time.Minute},
+		{3, 20 * time.Minute},
+		{4, 40 * time.Minute},
+		{5, time.Hour},
+		{50, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := scheduler.retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDeadSwitchTaskResumesDeliveryRun(t *testing.T) {
+	repo := NewMockRepository()
+	emailClient := &MockEmailClient{}
+	scheduler := NewScheduler(repo, emailClient, &MockTelegramBot{}, &config.Config{
+		DeliveryRetryBaseDelay: 5 * time.Minute,
+		DeliveryRetryMaxDelay:  time.Hour,
+		DeliveryRetryHorizon:   72 * time.Hour,
+	})
+
+	// The server died while delivering: the first recipient got their email, the second one
+	// wasn't queued yet and pinging is still enabled
+	user := &models.User{ID: "user1", Email: "user1@example.com", PingingEnabled: true}
+	repo.usersWithExpiredPings = []*models.User{user}
+	repo.recipients = []*models.Recipient{
+		{ID: "recipient1", UserID: "user1", Email: "recipient1@example.com", Name: "Recipient 1"},
+		{ID: "recipient2", UserID: "user1", Email: "recipient2@example.com", Name: "Recipient 2"},
+	}
+	repo.secretAssignments = []*models.SecretAssignment{
+		{ID: "assignment1", UserID: "user1", SecretID: "secret1", RecipientID: "recipient1"},
+		{ID: "assignment2", UserID: "user1", SecretID: "secret2", RecipientID: "recipient2"},
+	}
+	run := &models.DeliveryRun{ID: "run1", UserID: "user1", Status: models.DeliveryRunStatusInProgress, StartedAt: time.Now().UTC()}
+	repo.deliveryRuns = []*models.DeliveryRun{run}
+	repo.deliveryEvents = []*models.DeliveryEvent{
+		{ID: "event1", UserID: "user1", RunID: "run1", RecipientID: "recipient1", SentAt: run.StartedAt, Status: models.DeliveryStatusSent, Attempts: 1},
+	}
+
+	if err := scheduler.deadSwitchTask(context.Background()); err != nil {
+		t.Fatalf("deadSwitchTask failed: %v", err)
+	}
+
+	if emailClient.sentEmails != 1 {
+		t.Errorf("Expected only the unfinished recipient to be mailed, got %d emails", emailClient.sentEmails)
+	}
+	if len(repo.deliveryEvents) != 2 {
+		t.Fatalf("Expected 2 delivery events, got %d", len(repo.deliveryEvents))
+	}
+	if event := repo.deliveryEvents[1]; event.RecipientID != "recipient2" || event.RunID != "run1" || event.Status != models.DeliveryStatusSent {
+		t.Errorf("Expected a sent delivery to recipient2 in run1, got %+v", event)
+	}
+	if len(repo.deliveryRuns) != 1 || run.Status != models.DeliveryRunStatusCompleted {
+		t.Errorf("Expected the run to be resumed and completed, got %d runs with status %q", len(repo.deliveryRuns), run.Status)
+	}
+	if user.PingingEnabled {
+		t.Error("Expected PingingEnabled to be false after delivery")
+	}
+	for _, log := range repo.auditLogs {
+		if log.Action == "switch_triggered" {
+			t.Error("Expected a resumed run not to trigger the switch again")
+		}
+	}
+
+	// The retry queue doesn't mail anyone twice either
+	if err := scheduler.deliveryRetryTask(context.Background()); err != nil {
+		t.Fatalf("deliveryRetryTask failed: %v", err)
+	}
+	if emailClient.sentEmails != 1 {
+		t.Errorf("Expected no further emails, got %d", emailClient.sentEmails)
+	}
+}
+
+func TestDeadSwitchTaskGracePeriod(t *testing.T) {
+	repo := NewMockRepository()
+	emailClient := &MockEmailClient{}
+	telegramBot := &MockTelegramBot{}
+	scheduler := NewScheduler(repo, emailClient, telegramBot, &config.Config{
+		BaseDomain:           "example.com",
+		TriggerGracePeriod:   24 * time.Hour,
+		DeliveryRetryHorizon: 72 * time.Hour,
+	})
+
+	user := &models.User{
+		ID:             "user1",
+		Email:          "user1@example.com",
+		TelegramID:     "12345",
+		PingingEnabled: true,
+		LastActivity:   time.Now().UTC().Add(-15 * 24 * time.Hour),
+	}
+	repo.users = []*models.User{user}
+	repo.usersWithExpiredPings = []*models.User{user}
+	repo.recipients = []*models.Recipient{{ID: "recipient1", UserID: "user1", Email: "recipient1@example.com", Name: "Recipient 1"}}
+	repo.secretAssignments = []*models.SecretAssignment{{ID: "assignment1", UserID: "user1", SecretID: "secret1", RecipientID: "recipient1"}}
+
+	ctx := context.Background()
+	if err := scheduler.deadSwitchTask(ctx); err != nil {
+		t.Fatalf("deadSwitchTask failed: %v", err)
+	}
+
+	// The switch is armed, nothing is released yet and the owner is alerted
+	if len(repo.deliveryRuns) != 1 || repo.deliveryRuns[0].Status != models.DeliveryRunStatusArmed {
+		t.Fatalf("Expected an armed delivery run, got %d runs", len(repo.deliveryRuns))
+	}
+	run := repo.deliveryRuns[0]
+	if run.AbortCode == "" || run.LastAlertAt == nil {
+		t.Error("Expected an abort code and an alert")
+	}
+	if len(repo.deliveryEvents) != 0 {
+		t.Errorf("Expected no deliveries during the grace period, got %d", len(repo.deliveryEvents))
+	}
+	if len(emailClient.simpleTo) != 1 || emailClient.simpleTo[0] != "user1@example.com" {
+		t.Errorf("Expected an alert email to the owner, got emails to %v", emailClient.simpleTo)
+	}
+	if telegramBot.sentMessages != 1 {
+		t.Errorf("Expected a Telegram alert, got %d messages", telegramBot.sentMessages)
+	}
+
+	// Running again within the alert interval neither arms again nor alerts again
+	if err := scheduler.deadSwitchTask(ctx); err != nil {
+		t.Fatalf("deadSwitchTask failed: %v", err)
+	}
+	if len(repo.deliveryRuns) != 1 || len(emailClient.simpleTo) != 1 {
+		t.Errorf("Expected 1 run and 1 alert, got %d runs and %d alerts", len(repo.deliveryRuns), len(emailClient.simpleTo))
+	}
+
+	// The grace period is over without a response
+	run.FiresAt = time.Now().UTC().Add(-time.Minute)
+	repo.usersWithExpiredPings = nil
+	if err := scheduler.deadSwitchTask(ctx); err != nil {
+		t.Fatalf("deadSwitchTask failed: %v", err)
+	}
+
+	if run.Status != models.DeliveryRunStatusCompleted {
+		t.Errorf("Expected the run to be completed, got %q", run.Status)
+	}
+	if len(repo.deliveryEvents) != 1 || repo.deliveryEvents[0].RunID != run.ID || repo.deliveryEvents[0].Status != models.DeliveryStatusSent {
+		t.Errorf("Expected the secrets to be delivered in the armed run, got %d deliveries", len(repo.deliveryEvents))
+	}
+}
+
+func TestDeadSwitchTaskGracePeriodAborted(t *testing.T) {
+	repo := NewMockRepository()
+	scheduler := NewScheduler(repo, &MockEmailClient{}, &MockTelegramBot{}, &config.Config{
+		TriggerGracePeriod: 24 * time.Hour,
+	})
+
+	startedAt := time.Now().UTC().Add(-2 * time.Hour)
+	user := &models.User{ID: "user1", Email: "user1@example.com", LastActivity: time.Now().UTC().Add(-time.Hour)}
+	repo.users = []*models.User{user}
+	run := &models.DeliveryRun{
+		ID:        "run1",
+		UserID:    "user1",
+		Status:    models.DeliveryRunStatusArmed,
+		StartedAt: startedAt,
+		FiresAt:   startedAt.Add(-time.Minute),
+	}
+	repo.deliveryRuns = []*models.DeliveryRun{run}
+
+	if err := scheduler.deadSwitchTask(context.Background()); err != nil {
+		t.Fatalf("deadSwitchTask failed: %v", err)
+	}
+
+	// The owner checked in after the switch was armed, so nothing is released
+	if run.Status != models.DeliveryRunStatusAborted {
+		t.Errorf("Expected the run to be aborted, got %q", run.Status)
+	}
+	if len(repo.deliveryEvents) != 0 {
+		t.Errorf("Expected no deliveries, got %d", len(repo.deliveryEvents))
+	}
+	if len(repo.auditLogs) != 1 || repo.auditLogs[0].Action != "switch_trigger_aborted" {
+		t.Errorf("Expected a switch_trigger_aborted audit log entry, got %d entries", len(repo.auditLogs))
+	}
+}
+
+// setupStagedRelease triggers the switch of a user with an immediate and a delayed recipient
+func setupStagedRelease(t *testing.T) (*MockRepository, *Scheduler, *models.User) {
+	t.Helper()
+
+	repo := NewMockRepository()
+	scheduler := NewScheduler(repo, &MockEmailClient{}, &MockTelegramBot{}, &config.Config{
+		DeliveryRetryBaseDelay: 5 * time.Minute,
+		DeliveryRetryMaxDelay:  6 * time.Hour,
+		DeliveryRetryHorizon:   72 * time.Hour,
+	})
+
+	user := &models.User{
+		ID:             "user1",
+		Email:          "user1@example.com",
+		PingingEnabled: true,
+		LastActivity:   time.Now().UTC().Add(-15 * 24 * time.Hour),
+	}
+	repo.users = []*models.User{user}
+	repo.usersWithExpiredPings = []*models.User{user}
+	repo.recipients = []*models.Recipient{
+		{ID: "spouse", UserID: "user1", Email: "spouse@example.com", Name: "Spouse"},
+		{ID: "partner", UserID: "user1", Email: "partner@example.com", Name: "Partner", ReleaseDelayDays: 7},
+	}
+	repo.secretAssignments = []*models.SecretAssignment{
+		{ID: "assignment1", UserID: "user1", SecretID: "bank", RecipientID: "spouse"},
+		{ID: "assignment2", UserID: "user1", SecretID: "infrastructure", RecipientID: "partner"},
+	}
+
+	if err := scheduler.deadSwitchTask(context.Background()); err != nil {
+		t.Fatalf("deadSwitchTask failed: %v", err)
+	}
+	repo.usersWithExpiredPings = nil
+
+	if len(repo.deliveryEvents) != 2 {
+		t.Fatalf("Expected 2 delivery events, got %d", len(repo.deliveryEvents))
+	}
+
+	return repo, scheduler, user
+}
+
+func TestStagedRelease(t *testing.T) {
+	repo, scheduler, _ := setupStagedRelease(t)
+	ctx := context.Background()
+
+	spouse, partner := repo.deliveryEvents[0], repo.deliveryEvents[1]
+	if spouse.Status != models.DeliveryStatusSent {
+		t.Errorf("Expected the recipient without a delay to get their secrets right away, got %q", spouse.Status)
+	}
+	if partner.Status != models.DeliveryStatusScheduled || partner.Attempts != 0 {
+		t.Errorf("Expected the delayed release to be scheduled, got %q after %d attempts", partner.Status, partner.Attempts)
+	}
+	run := repo.deliveryRuns[0]
+	if want := run.FiresAt.AddDate(0, 0, 7); partner.NextAttemptAt == nil || !partner.NextAttemptAt.Equal(want) {
+		t.Errorf("Expected the release at %v, got %v", want, partner.NextAttemptAt)
+	}
+	if run.Status != models.DeliveryRunStatusInProgress {
+		t.Errorf("Expected the run to wait for the delayed release, got %q", run.Status)
+	}
+
+	// Nothing is due yet
+	if err := scheduler.deliveryRetryTask(ctx); err != nil {
+		t.Fatalf("deliveryRetryTask failed: %v", err)
+	}
+	if partner.Status != models.DeliveryStatusScheduled {
+		t.Errorf("Expected the release to stay scheduled, got %q", partner.Status)
+	}
+
+	// A week later
+	releaseAt := time.Now().UTC().Add(-time.Minute)
+	partner.NextAttemptAt = &releaseAt
+	partner.SentAt = releaseAt
+	if err := scheduler.deliveryRetryTask(ctx); err != nil {
+		t.Fatalf("deliveryRetryTask failed: %v", err)
+	}
+	if partner.Status != models.DeliveryStatusSent {
+		t.Errorf("Expected the delayed release to be sent, got %q", partner.Status)
+	}
+	if run.Status != models.DeliveryRunStatusCompleted {
+		t.Errorf("Expected the run to be completed, got %q", run.Status)
+	}
+}
+
+func TestStagedReleaseCancelled(t *testing.T) {
+	repo, scheduler, user := setupStagedRelease(t)
+	ctx := context.Background()
+
+	// The owner checks in after the switch fired
+	run := repo.deliveryRuns[0]
+	user.LastActivity = run.FiresAt.Add(time.Hour)
+
+	if err := scheduler.deliveryRetryTask(ctx); err != nil {
+		t.Fatalf("deliveryRetryTask failed: %v", err)
+	}
+
+	spouse, partner := repo.deliveryEvents[0], repo.deliveryEvents[1]
+	if spouse.Status != models.DeliveryStatusSent {
+		t.Errorf("Expected the released delivery to stay sent, got %q", spouse.Status)
+	}
+	if partner.Status != models.DeliveryStatusCancelled || partner.NextAttemptAt != nil {
+		t.Errorf("Expected the scheduled release to be cancelled, got %q", partner.Status)
+	}
+	if run.Status != models.DeliveryRunStatusCompleted {
+		t.Errorf("Expected the run to be completed, got %q", run.Status)
+	}
+
+	cancelled := false
+	for _, log := range repo.auditLogs {
+		if log.Action == "staged_release_cancelled" {
+			cancelled = true
+		}
+	}
+	if !cancelled {
+		t.Error("Expected a staged_release_cancelled audit log entry")
+	}
+}
+
+func TestEmergencyAccessTask(t *testing.T) {
+	repo := NewMockRepository()
+	emailClient := &MockEmailClient{}
+	scheduler := NewScheduler(repo, emailClient, &MockTelegramBot{}, &config.Config{
+		DeliveryRetryBaseDelay: 5 * time.Minute,
+		DeliveryRetryMaxDelay:  6 * time.Hour,
+		DeliveryRetryHorizon:   72 * time.Hour,
+	})
+
+	repo.users = []*models.User{{
+		ID:                  "user1",
+		Email:               "user1@example.com",
+		PingingEnabled:      true,
+		LastActivity:        time.Now().UTC().Add(-5 * 24 * time.Hour),
+		EmergencyAccessDays: 3,
+	}}
+	repo.recipients = []*models.Recipient{
+		{ID: "spouse", UserID: "user1", Email: "spouse@example.com", Name: "Spouse"},
+		{ID: "partner", UserID: "user1", Email: "partner@example.com", Name: "Partner"},
+	}
+	repo.secretAssignments = []*models.SecretAssignment{
+		{ID: "assignment1", UserID: "user1", SecretID: "bank", RecipientID: "spouse"},
+		{ID: "assignment2", UserID: "user1", SecretID: "infrastructure", RecipientID: "partner"},
+	}
+
+	due := time.Now().UTC().Add(-time.Minute)
+	later := time.Now().UTC().Add(24 * time.Hour)
+	repo.emergencyRequests = []*models.EmergencyAccessRequest{
+		{ID: "request1", UserID: "user1", RecipientID: "spouse", Status: models.EmergencyAccessStatusPending, ReleasesAt: &due},
+		{ID: "request2", UserID: "user1", RecipientID: "partner", Status: models.EmergencyAccessStatusPending, ReleasesAt: &later},
+	}
+
+	if err := scheduler.emergencyAccessTask(context.Background()); err != nil {
+		t.Fatalf("emergencyAccessTask failed: %v", err)
+	}
+
+	granted, waiting := repo.emergencyRequests[0], repo.emergencyRequests[1]
+	if granted.Status != models.EmergencyAccessStatusGranted || granted.ResolvedAt == nil {
+		t.Errorf("Expected the due request to be granted, got %q", granted.Status)
+	}
+	if waiting.Status != models.EmergencyAccessStatusPending {
+		t.Errorf("Expected the request in its waiting period to stay pending, got %q", waiting.Status)
+	}
+
+	// Only the recipient of the granted request gets their secrets, outside of any run
+	if len(repo.deliveryEvents) != 1 {
+		t.Fatalf("Expected 1 delivery event, got %d", len(repo.deliveryEvents))
+	}
+	event := repo.deliveryEvents[0]
+	if event.RecipientID != "spouse" || event.RunID != "" || event.Status != models.DeliveryStatusSent {
+		t.Errorf("Expected a sent delivery to the spouse without a run, got %+v", event)
+	}
+	if len(repo.deliveryRuns) != 0 {
+		t.Errorf("Expected the switch not to fire, got %d delivery runs", len(repo.deliveryRuns))
+	}
+
+	if len(repo.auditLogs) != 1 || repo.auditLogs[0].Action != "emergency_access_granted" {
+		t.Errorf("Expected an emergency_access_granted audit log entry, got %d entries", len(repo.auditLogs))
+	}
+
+	// A granted request isn't released twice
+	if err := scheduler.emergencyAccessTask(context.Background()); err != nil {
+		t.Fatalf("emergencyAccessTask failed: %v", err)
+	}
+	if len(repo.deliveryEvents) != 1 {
+		t.Errorf("Expected no further deliveries, got %d events", len(repo.deliveryEvents))
+	}
+}
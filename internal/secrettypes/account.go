@@ -0,0 +1,53 @@
+package secrettypes
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ibanPattern = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{11,30}$`)
+	bicPattern  = regexp.MustCompile(`^[A-Z]{6}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+)
+
+// ValidateIBAN checks the format and the check digits of an IBAN and returns
+// it in upper case, in the groups of four it is usually printed in
+func ValidateIBAN(iban string) (string, error) {
+	compact := strings.ToUpper(strings.Join(strings.Fields(iban), ""))
+	if !ibanPattern.MatchString(compact) {
+		return "", errors.New("an IBAN starts with the country code and two check digits, followed by up to 30 letters and digits")
+	}
+
+	// The country code and check digits move to the end, letters count as
+	// 10 to 35 and the number has to leave a remainder of 1 when divided by 97
+	remainder := 0
+	for _, c := range compact[4:] + compact[:4] {
+		if c >= 'A' {
+			remainder = (remainder*100 + int(c-'A') + 10) % 97
+		} else {
+			remainder = (remainder*10 + int(c-'0')) % 97
+		}
+	}
+	if remainder != 1 {
+		return "", fmt.Errorf("the check digits of %s don't match, please check for a typo", compact)
+	}
+
+	var groups []string
+	for len(compact) > 4 {
+		groups = append(groups, compact[:4])
+		compact = compact[4:]
+	}
+	return strings.Join(append(groups, compact), " "), nil
+}
+
+// ValidateBIC checks the format of a BIC, also known as a SWIFT code, and
+// returns it in upper case
+func ValidateBIC(bic string) (string, error) {
+	bic = strings.ToUpper(strings.Join(strings.Fields(bic), ""))
+	if !bicPattern.MatchString(bic) {
+		return "", errors.New("a BIC has 8 or 11 letters and digits")
+	}
+	return bic, nil
+}
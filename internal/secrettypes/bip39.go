@@ -0,0 +1,83 @@
+package secrettypes
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The English BIP39 word list, one word per line
+//
+//go:embed bip39_english.txt
+var bip39English string
+
+var (
+	bip39Words = strings.Fields(bip39English)
+	bip39Index = func() map[string]int {
+		index := make(map[string]int, len(bip39Words))
+		for i, word := range bip39Words {
+			index[word] = i
+		}
+		return index
+	}()
+)
+
+// ErrMnemonicChecksum is returned for a seed phrase whose words are all in
+// the word list but whose checksum doesn't match, usually because a word was
+// swapped for another one or two words are in the wrong order
+var ErrMnemonicChecksum = errors.New("the checksum of the seed phrase doesn't match, please check the words and their order")
+
+// ValidateMnemonic checks a BIP39 seed phrase in English and returns its
+// words in lower case
+func ValidateMnemonic(phrase string) ([]string, error) {
+	words := strings.Fields(strings.ToLower(phrase))
+	switch len(words) {
+	case 12, 15, 18, 21, 24:
+	default:
+		return nil, fmt.Errorf("a seed phrase has 12, 15, 18, 21 or 24 words, this one has %d", len(words))
+	}
+
+	// Every word holds 11 bits, the last bits of the last word are the checksum
+	bits := make([]byte, (len(words)*11+7)/8)
+	for i, word := range words {
+		index, ok := bip39Index[word]
+		if !ok {
+			if suggestion := suggestWord(word); suggestion != "" {
+				return nil, fmt.Errorf("word %d %q is not in the BIP39 word list, did you mean %q?", i+1, word, suggestion)
+			}
+			return nil, fmt.Errorf("word %d %q is not in the BIP39 word list", i+1, word)
+		}
+		for b := 0; b < 11; b++ {
+			if index&(1<<(10-b)) != 0 {
+				pos := i*11 + b
+				bits[pos/8] |= 1 << (7 - pos%8)
+			}
+		}
+	}
+
+	checksumBits := len(words) * 11 / 33
+	entropy := bits[:(len(words)*11-checksumBits)/8]
+	checksum := bits[len(entropy)] >> (8 - checksumBits)
+
+	hash := sha256.Sum256(entropy)
+	if hash[0]>>(8-checksumBits) != checksum {
+		return nil, ErrMnemonicChecksum
+	}
+	return words, nil
+}
+
+// suggestWord returns the word of the list that starts with the same four
+// letters, they are unique in the BIP39 list
+func suggestWord(word string) string {
+	if len(word) < 4 {
+		return ""
+	}
+	for _, candidate := range bip39Words {
+		if strings.HasPrefix(candidate, word[:4]) {
+			return candidate
+		}
+	}
+	return ""
+}
@@ -0,0 +1,271 @@
+// Package secrettypes describes the kinds of text secrets an owner can
+// create. A free-form note keeps its content as it was typed, every other
+// type stores its fields as a JSON object and checks them when they are
+// entered, so a typo in a seed phrase or an IBAN is caught while the owner
+// can still fix it.
+package secrettypes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Note is a free-form text secret, the content is stored as it is
+	Note = "note"
+	// SeedPhrase is the BIP39 recovery phrase of a cryptocurrency wallet
+	SeedPhrase = "seed_phrase"
+	// BankAccount is a bank or brokerage account
+	BankAccount = "bank_account"
+	// Login is the login of a website
+	Login = "login"
+)
+
+// ErrUnknownType is returned for a type that isn't defined
+var ErrUnknownType = errors.New("unknown secret type")
+
+// Field is one input of a secret type
+type Field struct {
+	Name     string // Name of the form input and the JSON key
+	Label    string
+	Input    string // "text", "textarea", "password" or "url"
+	Required bool
+	Help     string
+}
+
+// FieldError is returned by Validate for a value that can't be used
+type FieldError struct {
+	Field   string // Name of the field
+	Label   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Label + ": " + e.Message
+}
+
+// Values are the field values of a secret, keyed by field name
+type Values map[string]string
+
+// Type is a kind of secret with its fields
+type Type struct {
+	ID          string
+	Name        string
+	Description string
+	Fields      []Field
+
+	// check validates the trimmed values and may normalize them in place
+	check func(Values) error
+}
+
+var types = []*Type{
+	{
+		ID:          Note,
+		Name:        "Note",
+		Description: "Free-form text, for anything that doesn't fit the other types.",
+		Fields: []Field{
+			{Name: "content", Label: "Secret Content", Input: "textarea", Required: true},
+		},
+	},
+	{
+		ID:          SeedPhrase,
+		Name:        "Seed Phrase",
+		Description: "The recovery phrase of a cryptocurrency wallet. The words and the checksum are checked against the BIP39 word list.",
+		Fields: []Field{
+			{Name: "wallet", Label: "Wallet", Input: "text", Help: "The wallet or hardware device the phrase belongs to."},
+			{Name: "words", Label: "Recovery Words", Input: "textarea", Required: true, Help: "12, 15, 18, 21 or 24 words, separated by spaces or line breaks."},
+			{Name: "passphrase", Label: "Passphrase", Input: "password", Help: "The optional BIP39 passphrase, sometimes called the 25th word."},
+			{Name: "notes", Label: "Notes", Input: "textarea"},
+		},
+		check: checkSeedPhrase,
+	},
+	{
+		ID:          BankAccount,
+		Name:        "Bank Account",
+		Description: "A bank or brokerage account. An IBAN is checked with its check digits.",
+		Fields: []Field{
+			{Name: "institution", Label: "Bank or Broker", Input: "text", Required: true},
+			{Name: "holder", Label: "Account Holder", Input: "text"},
+			{Name: "iban", Label: "IBAN", Input: "text"},
+			{Name: "account_number", Label: "Account Number", Input: "text", Help: "For accounts without an IBAN, such as most brokerage accounts."},
+			{Name: "bic", Label: "BIC / SWIFT", Input: "text"},
+			{Name: "notes", Label: "Notes", Input: "textarea", Help: "Where the statements are, who to contact, how to close the account."},
+		},
+		check: checkBankAccount,
+	},
+	{
+		ID:          Login,
+		Name:        "Website Login",
+		Description: "The login of a website, with the seed of its authenticator app if it has one.",
+		Fields: []Field{
+			{Name: "url", Label: "Website", Input: "url", Required: true},
+			{Name: "username", Label: "Username", Input: "text", Required: true},
+			{Name: "password", Label: "Password", Input: "password"},
+			{Name: "totp", Label: "Authenticator Seed", Input: "text", Help: "The base32 secret or the otpauth:// link behind the QR code of the authenticator app."},
+			{Name: "notes", Label: "Notes", Input: "textarea"},
+		},
+		check: checkLogin,
+	},
+}
+
+// All returns the secret types in the order they are offered
+func All() []*Type {
+	return types
+}
+
+// Lookup returns the type with the given ID. Secrets stored before types
+// existed have no type and are notes.
+func Lookup(id string) (*Type, error) {
+	if id == "" {
+		id = Note
+	}
+	for _, t := range types {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownType, id)
+}
+
+// IsNote reports whether the content is stored as free-form text
+func (t *Type) IsNote() bool {
+	return t.ID == Note
+}
+
+// Validate trims the values, checks that the required fields are filled in
+// and runs the checks of the type. Values of unknown fields are dropped.
+func (t *Type) Validate(values Values) (Values, error) {
+	clean := make(Values, len(t.Fields))
+	for _, field := range t.Fields {
+		value := values[field.Name]
+		if !t.IsNote() {
+			value = strings.TrimSpace(value)
+		}
+		if value == "" {
+			if field.Required {
+				return nil, &FieldError{Field: field.Name, Label: field.Label, Message: "this field is required"}
+			}
+			continue
+		}
+		clean[field.Name] = value
+	}
+
+	if t.check != nil {
+		if err := t.check(clean); err != nil {
+			var fieldErr *FieldError
+			if errors.As(err, &fieldErr) {
+				fieldErr.Label = t.label(fieldErr.Field)
+			}
+			return nil, err
+		}
+	}
+	return clean, nil
+}
+
+func (t *Type) label(name string) string {
+	for _, field := range t.Fields {
+		if field.Name == name {
+			return field.Label
+		}
+	}
+	return name
+}
+
+// Encode returns the content stored for validated values
+func (t *Type) Encode(values Values) ([]byte, error) {
+	if t.IsNote() {
+		return []byte(values["content"]), nil
+	}
+	return json.Marshal(values)
+}
+
+// Decode returns the values of stored content
+func (t *Type) Decode(content []byte) (Values, error) {
+	if t.IsNote() {
+		return Values{"content": string(content)}, nil
+	}
+
+	var values Values
+	if err := json.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", t.Name, err)
+	}
+	return values, nil
+}
+
+// Format returns the values as text with one "Label: value" entry per
+// field, in the order of the fields. A note is returned as it is.
+func (t *Type) Format(values Values) string {
+	if t.IsNote() {
+		return values["content"]
+	}
+
+	var b strings.Builder
+	for _, field := range t.Fields {
+		value := values[field.Name]
+		if value == "" {
+			continue
+		}
+		if strings.Contains(value, "\n") {
+			fmt.Fprintf(&b, "%s:\n%s\n", field.Label, value)
+		} else {
+			fmt.Fprintf(&b, "%s: %s\n", field.Label, value)
+		}
+	}
+	return b.String()
+}
+
+func checkSeedPhrase(values Values) error {
+	words, err := ValidateMnemonic(values["words"])
+	if err != nil {
+		return &FieldError{Field: "words", Message: err.Error()}
+	}
+	values["words"] = strings.Join(words, " ")
+	return nil
+}
+
+func checkBankAccount(values Values) error {
+	if values["iban"] == "" && values["account_number"] == "" {
+		return &FieldError{Field: "iban", Message: "enter the IBAN or the account number"}
+	}
+
+	if values["iban"] != "" {
+		iban, err := ValidateIBAN(values["iban"])
+		if err != nil {
+			return &FieldError{Field: "iban", Message: err.Error()}
+		}
+		values["iban"] = iban
+	}
+
+	if values["bic"] != "" {
+		bic, err := ValidateBIC(values["bic"])
+		if err != nil {
+			return &FieldError{Field: "bic", Message: err.Error()}
+		}
+		values["bic"] = bic
+	}
+	return nil
+}
+
+func checkLogin(values Values) error {
+	address := values["url"]
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &FieldError{Field: "url", Message: "enter a web address such as https://example.com"}
+	}
+	values["url"] = u.String()
+
+	if values["totp"] != "" {
+		seed, err := ValidateTOTPSeed(values["totp"])
+		if err != nil {
+			return &FieldError{Field: "totp", Message: err.Error()}
+		}
+		values["totp"] = seed
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package secrettypes
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateMnemonic(t *testing.T) {
+	valid := []string{
+		strings.Repeat("abandon ", 11) + "about",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		strings.Repeat("zoo ", 11) + "wrong",
+		strings.Repeat("abandon ", 17) + "agent",
+		strings.Repeat("abandon ", 23) + "art",
+		"  Legal WINNER thank year wave sausage\nworth useful legal winner thank yellow ",
+	}
+	for _, phrase := range valid {
+		words, err := ValidateMnemonic(phrase)
+		if err != nil {
+			t.Errorf("Expected %q to be valid, got %v", phrase, err)
+			continue
+		}
+		if strings.Join(words, " ") != strings.ToLower(strings.Join(strings.Fields(phrase), " ")) {
+			t.Errorf("Unexpected words for %q: %v", phrase, words)
+		}
+	}
+
+	if _, err := ValidateMnemonic(strings.Repeat("abandon ", 12)); !errors.Is(err, ErrMnemonicChecksum) {
+		t.Errorf("Expected a checksum error, got %v", err)
+	}
+	if _, err := ValidateMnemonic("legal winner thank year wave sausage worth useful legal winner yellow thank"); !errors.Is(err, ErrMnemonicChecksum) {
+		t.Errorf("Expected a checksum error for swapped words, got %v", err)
+	}
+	if _, err := ValidateMnemonic(strings.Repeat("abandon ", 11)); err == nil || !strings.Contains(err.Error(), "has 11") {
+		t.Errorf("Expected an error for 11 words, got %v", err)
+	}
+	_, err := ValidateMnemonic(strings.Repeat("abandon ", 10) + "abandonn about")
+	if err == nil || !strings.Contains(err.Error(), `word 11 "abandonn"`) || !strings.Contains(err.Error(), `did you mean "abandon"`) {
+		t.Errorf("Expected an error naming the misspelled word, got %v", err)
+	}
+
+	if len(bip39Words) != 2048 || bip39Words[0] != "abandon" || bip39Words[2047] != "zoo" {
+		t.Errorf("Unexpected word list: %d words", len(bip39Words))
+	}
+}
+
+func TestValidateIBAN(t *testing.T) {
+	tests := map[string]string{
+		"DE89370400440532013000":            "DE89 3704 0044 0532 0130 00",
+		"gb82 west 1234 5698 7654 32":       "GB82 WEST 1234 5698 7654 32",
+		"NL91 ABNA 0417 1643 00":            "NL91 ABNA 0417 1643 00",
+		"FR14 2004 1010 0505 0001 3M02 606": "FR14 2004 1010 0505 0001 3M02 606",
+	}
+	for input, want := range tests {
+		got, err := ValidateIBAN(input)
+		if err != nil || got != want {
+			t.Errorf("ValidateIBAN(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"DE89370400440532013001", "GB82WEST12345698765433", "DE89", "1234567890123456", ""} {
+		if _, err := ValidateIBAN(input); err == nil {
+			t.Errorf("Expected ValidateIBAN(%q) to fail", input)
+		}
+	}
+}
+
+func TestValidateTOTPSeed(t *testing.T) {
+	tests := map[string]string{
+		"JBSWY3DPEHPK3PXP":     "JBSWY3DPEHPK3PXP",
+		"jbsw y3dp ehpk 3pxp":  "JBSWY3DPEHPK3PXP",
+		"JBSWY3DPEHPK3PXP====": "JBSWY3DPEHPK3PXP",
+		"otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example": "JBSWY3DPEHPK3PXP",
+	}
+	for input, want := range tests {
+		got, err := ValidateTOTPSeed(input)
+		if err != nil || got != want {
+			t.Errorf("ValidateTOTPSeed(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"JBSWY3DP", "not base32!", "otpauth://totp/Example?issuer=Example"} {
+		if _, err := ValidateTOTPSeed(input); err == nil {
+			t.Errorf("Expected ValidateTOTPSeed(%q) to fail", input)
+		}
+	}
+}
+
+func TestTypes(t *testing.T) {
+	if typ, err := Lookup(""); err != nil || typ.ID != Note {
+		t.Errorf("Expected secrets without a type to be notes, got %v, %v", typ, err)
+	}
+	if _, err := Lookup("crypto_wallet"); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Expected ErrUnknownType, got %v", err)
+	}
+
+	login, _ := Lookup(Login)
+	values, err := login.Validate(Values{"url": " example.com/login ", "username": "alice", "totp": "jbsw y3dp ehpk 3pxp", "unknown": "dropped"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if values["url"] != "https://example.com/login" || values["totp"] != "JBSWY3DPEHPK3PXP" || values["unknown"] != "" {
+		t.Errorf("Unexpected values: %v", values)
+	}
+
+	content, err := login.Encode(values)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	decoded, err := login.Decode(content)
+	if err != nil || decoded["username"] != "alice" {
+		t.Errorf("Expected the values to survive encoding, got %v, %v", decoded, err)
+	}
+	if text := login.Format(decoded); text != "Website: https://example.com/login\nUsername: alice\nAuthenticator Seed: JBSWY3DPEHPK3PXP\n" {
+		t.Errorf("Unexpected text: %q", text)
+	}
+
+	// Errors name the field so the form can point at it
+	var fieldErr *FieldError
+	if _, err := login.Validate(Values{"url": "example.com"}); !errors.As(err, &fieldErr) || fieldErr.Field != "username" {
+		t.Errorf("Expected a required field error for the username, got %v", err)
+	}
+	bank, _ := Lookup(BankAccount)
+	if _, err := bank.Validate(Values{"institution": "Bank", "iban": "DE89370400440532013001"}); !errors.As(err, &fieldErr) || fieldErr.Label != "IBAN" {
+		t.Errorf("Expected an IBAN error, got %v", err)
+	}
+	if _, err := bank.Validate(Values{"institution": "Broker", "account_number": "U1234567"}); err != nil {
+		t.Errorf("Expected an account without IBAN to be valid, got %v", err)
+	}
+
+	// Notes are kept exactly as they were typed
+	note, _ := Lookup(Note)
+	values, _ = note.Validate(Values{"content": "  indented\n"})
+	if content, _ := note.Encode(values); string(content) != "  indented\n" {
+		t.Errorf("Expected the note to be kept as it is, got %q", content)
+	}
+}
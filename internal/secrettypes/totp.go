@@ -0,0 +1,37 @@
+package secrettypes
+
+import (
+	"encoding/base32"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ValidateTOTPSeed checks the seed of an authenticator app and returns it
+// as base32 in upper case. The otpauth:// link of a QR code is accepted too,
+// the seed is its secret parameter.
+func ValidateTOTPSeed(seed string) (string, error) {
+	seed = strings.TrimSpace(seed)
+	if strings.HasPrefix(strings.ToLower(seed), "otpauth://") {
+		u, err := url.Parse(seed)
+		if err != nil {
+			return "", errors.New("the otpauth:// link can't be read")
+		}
+		seed = u.Query().Get("secret")
+		if seed == "" {
+			return "", errors.New("the otpauth:// link has no secret")
+		}
+	}
+
+	seed = strings.ToUpper(strings.Join(strings.Fields(seed), ""))
+	seed = strings.TrimRight(seed, "=")
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(seed)
+	if err != nil {
+		return "", errors.New("the seed must be base32, the letters A to Z and the digits 2 to 7")
+	}
+	// RFC 4226 requires at least 128 bits, some sites still hand out 80
+	if len(key) < 10 {
+		return "", errors.New("the seed is too short, it needs at least 16 characters")
+	}
+	return seed, nil
+}
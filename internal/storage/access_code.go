@@ -75,17 +75,58 @@ func (r *SQLiteRepository) GetAccessCodeByCode(ctx context.Context, code string)
 	return accessCode, nil
 }
 
-// VerifyAccessCode verifies an access code and checks expiration and attempts
-// It returns the access code if valid, or an error if invalid, expired, or max attempts exceeded
+// VerifyAccessCodeHash looks up an unexpired access code by the hash of
+// crypto.HashAccessCode and checks its attempts. It returns ErrNotFound for an
+// unknown or expired code and ErrAccessCodeLocked once the attempts are used up.
+// A used code keeps working until it expires, the recipient comes back to
+// download files and to submit shares.
+func (r *SQLiteRepository) VerifyAccessCodeHash(ctx context.Context, codeHash string) (*models.AccessCode, error) {
+	accessCode := &models.AccessCode{}
+	var usedAt sql.NullTime
+
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, code, recipient_id, user_id, delivery_event_id,
+			created_at, expires_at, used_at, attempt_count, max_attempts
+		FROM access_codes
+		WHERE code = ? AND expires_at > ?
+	`, codeHash, time.Now().UTC()).Scan(
+		&accessCode.ID, &accessCode.Code, &accessCode.RecipientID, &accessCode.UserID,
+		&accessCode.DeliveryEventID, &accessCode.CreatedAt, &accessCode.ExpiresAt,
+		&usedAt, &accessCode.AttemptCount, &accessCode.MaxAttempts,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access code: %w", err)
+	}
+
+	if usedAt.Valid {
+		accessCode.UsedAt = &usedAt.Time
+	}
+
+	if accessCode.AttemptCount >= accessCode.MaxAttempts {
+		return nil, ErrAccessCodeLocked
+	}
+
+	return accessCode, nil
+}
+
+// VerifyAccessCode verifies an access code against the codes stored before they
+// were hashed with crypto.HashAccessCode, and checks expiration and attempts.
+// Those codes are Argon2 hashes with a salt each, so every one of them has to be
+// hashed again; no new ones are written and they go away as they expire.
+// It returns the access code if valid, or an error if invalid, expired, or max attempts exceeded.
+// A used code keeps working until it expires, the recipient comes back to download files
+// and to submit shares.
 func (r *SQLiteRepository) VerifyAccessCode(ctx context.Context, code string) (*models.AccessCode, error) {
-	// First, we need to find all access codes and verify each one since we store hashed codes
 	rows, err := r.db.QueryContext(ctx, `
 		SELECT id, code, recipient_id, user_id, delivery_event_id,
 			created_at, expires_at, used_at, attempt_count, max_attempts
 		FROM access_codes
-		WHERE used_at IS NULL
-		AND expires_at > ?
-	`, time.Now().UTC())
+		WHERE expires_at > ? AND code NOT LIKE ?
+	`, time.Now().UTC(), crypto.AccessCodeHashPrefix+"%")
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to query access codes: %w", err)
@@ -124,11 +165,6 @@ func (r *SQLiteRepository) VerifyAccessCode(ctx context.Context, code string) (*
 		if matches {
 			accessCode.Code = codeStr
 
-			// Check if already used
-			if accessCode.UsedAt != nil {
-				return nil, fmt.Errorf("access code already used")
-			}
-
 			// Check if expired
 			if time.Now().UTC().After(accessCode.ExpiresAt) {
 				return nil, fmt.Errorf("access code expired")
@@ -136,7 +172,7 @@ func (r *SQLiteRepository) VerifyAccessCode(ctx context.Context, code string) (*
 
 			// Check if max attempts exceeded
 			if accessCode.AttemptCount >= accessCode.MaxAttempts {
-				return nil, fmt.Errorf("access code locked due to too many failed attempts")
+				return nil, ErrAccessCodeLocked
 			}
 
 			return accessCode, nil
@@ -151,13 +187,13 @@ func (r *SQLiteRepository) VerifyAccessCode(ctx context.Context, code string) (*
 	return nil, ErrNotFound
 }
 
-// MarkAccessCodeAsUsed marks an access code as used
+// MarkAccessCodeAsUsed records when an access code was first used, later uses keep that time
 func (r *SQLiteRepository) MarkAccessCodeAsUsed(ctx context.Context, id string) error {
 	now := time.Now().UTC()
 
 	result, err := r.db.ExecContext(ctx, `
 		UPDATE access_codes
-		SET used_at = ?
+		SET used_at = COALESCE(used_at, ?)
 		WHERE id = ?
 	`, now, id)
 
@@ -201,6 +237,74 @@ func (r *SQLiteRepository) IncrementAccessCodeAttempts(ctx context.Context, id s
 	return nil
 }
 
+// ListAccessCodesByUserID lists all access codes sent on behalf of a user, oldest first
+func (r *SQLiteRepository) ListAccessCodesByUserID(ctx context.Context, userID string) ([]*models.AccessCode, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, code, recipient_id, user_id, delivery_event_id,
+			created_at, expires_at, used_at, attempt_count, max_attempts
+		FROM access_codes
+		WHERE user_id = ?
+		ORDER BY created_at ASC
+	`, userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query access codes: %w", err)
+	}
+	defer rows.Close()
+
+	var accessCodes []*models.AccessCode
+	for rows.Next() {
+		accessCode := &models.AccessCode{}
+		var usedAt sql.NullTime
+
+		if err := rows.Scan(
+			&accessCode.ID, &accessCode.Code, &accessCode.RecipientID, &accessCode.UserID,
+			&accessCode.DeliveryEventID, &accessCode.CreatedAt, &accessCode.ExpiresAt,
+			&usedAt, &accessCode.AttemptCount, &accessCode.MaxAttempts,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan access code: %w", err)
+		}
+
+		if usedAt.Valid {
+			accessCode.UsedAt = &usedAt.Time
+		}
+
+		accessCodes = append(accessCodes, accessCode)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating access codes: %w", err)
+	}
+
+	return accessCodes, nil
+}
+
+// RevokeAccessCodesByUserID revokes all live access codes of a user by expiring
+// them right away, and returns how many were revoked. This covers the codes of
+// every release as well as those sent for emergency access, which belong to no
+// run. Codes that were used are revoked as well, they still open the secrets.
+func (r *SQLiteRepository) RevokeAccessCodesByUserID(ctx context.Context, userID string) (int, error) {
+	now := time.Now().UTC()
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE access_codes
+		SET expires_at = ?
+		WHERE user_id = ?
+		AND expires_at > ?
+	`, now, userID, now)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke access codes: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
 // DeleteExpiredAccessCodes deletes all expired access codes
 func (r *SQLiteRepository) DeleteExpiredAccessCodes(ctx context.Context) error {
 	now := time.Now().UTC()
@@ -175,6 +175,62 @@ func TestVerifyAccessCodeExpired(t *testing.T) {
 	}
 }
 
+func TestVerifyAccessCodeLocked(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Create test user and recipient
+	user := createTestUser(t, repo, "test5@example.com")
+	recipient := createTestRecipient(t, repo, user.ID, "recipient5@example.com")
+
+	// Create delivery event
+	deliveryEvent := &models.DeliveryEvent{
+		UserID:      user.ID,
+		RecipientID: recipient.ID,
+		SentAt:      time.Now().UTC(),
+		Status:      "pending",
+	}
+	err := repo.CreateDeliveryEvent(context.Background(), deliveryEvent)
+	if err != nil {
+		t.Fatalf("Failed to create delivery event: %v", err)
+	}
+
+	// Hash an access code
+	plainCode := "test-access-code-locked"
+	hashedCode, err := crypto.HashPassword(plainCode, nil)
+	if err != nil {
+		t.Fatalf("Failed to hash code: %v", err)
+	}
+	hashedCodeStr := base64.StdEncoding.EncodeToString(hashedCode)
+
+	// Create access code with a single allowed attempt
+	accessCode := &models.AccessCode{
+		Code:            hashedCodeStr,
+		RecipientID:     recipient.ID,
+		UserID:          user.ID,
+		DeliveryEventID: deliveryEvent.ID,
+		ExpiresAt:       time.Now().UTC().Add(24 * time.Hour),
+		MaxAttempts:     1,
+	}
+
+	err = repo.CreateAccessCode(context.Background(), accessCode)
+	if err != nil {
+		t.Fatalf("Failed to create access code: %v", err)
+	}
+
+	// Record a failed attempt
+	err = repo.IncrementAccessCodeAttempts(context.Background(), accessCode.ID)
+	if err != nil {
+		t.Fatalf("Failed to increment attempts: %v", err)
+	}
+
+	// Test locked code verification
+	_, err = repo.VerifyAccessCode(context.Background(), plainCode)
+	if err != ErrAccessCodeLocked {
+		t.Errorf("Expected ErrAccessCodeLocked, got %v", err)
+	}
+}
+
 func TestMarkAccessCodeAsUsed(t *testing.T) {
 	repo, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -224,10 +280,24 @@ func TestMarkAccessCodeAsUsed(t *testing.T) {
 		t.Fatalf("Failed to mark access code as used: %v", err)
 	}
 
-	// Try to verify the used code
-	_, err = repo.VerifyAccessCode(context.Background(), plainCode)
-	if err == nil {
-		t.Error("Expected used code to fail verification")
+	// The used code keeps working and remembers when it was first used
+	used, err := repo.VerifyAccessCode(context.Background(), plainCode)
+	if err != nil {
+		t.Fatalf("Expected used code to verify until it expires, got %v", err)
+	}
+	if used.UsedAt == nil {
+		t.Fatal("Expected the code to be marked as used")
+	}
+
+	if err := repo.MarkAccessCodeAsUsed(context.Background(), accessCode.ID); err != nil {
+		t.Fatalf("Failed to mark access code as used again: %v", err)
+	}
+	again, err := repo.VerifyAccessCode(context.Background(), plainCode)
+	if err != nil {
+		t.Fatalf("Failed to verify access code: %v", err)
+	}
+	if again.UsedAt == nil || !again.UsedAt.Equal(*used.UsedAt) {
+		t.Errorf("Expected the first use to be kept, got %v and %v", used.UsedAt, again.UsedAt)
 	}
 }
 
@@ -291,6 +361,183 @@ func TestDeleteExpiredAccessCodes(t *testing.T) {
 	// but at least we verified the operation doesn't error
 }
 
+func TestRevokeAccessCodes(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test5@example.com")
+	recipient := createTestRecipient(t, repo, user.ID, "recipient5@example.com")
+
+	run := &models.DeliveryRun{UserID: user.ID, Status: models.DeliveryRunStatusCompleted, StartedAt: time.Now().UTC()}
+	if err := repo.CreateDeliveryRun(ctx, run); err != nil {
+		t.Fatalf("Failed to create delivery run: %v", err)
+	}
+
+	deliveryEvent := &models.DeliveryEvent{
+		UserID:      user.ID,
+		RunID:       run.ID,
+		RecipientID: recipient.ID,
+		SentAt:      time.Now().UTC(),
+		Status:      models.DeliveryStatusSent,
+	}
+	if err := repo.CreateDeliveryEvent(ctx, deliveryEvent); err != nil {
+		t.Fatalf("Failed to create delivery event: %v", err)
+	}
+
+	// A delivery outside the run, e.g. of an emergency access request
+	otherEvent := &models.DeliveryEvent{
+		UserID:      user.ID,
+		RecipientID: recipient.ID,
+		SentAt:      time.Now().UTC(),
+		Status:      models.DeliveryStatusSent,
+	}
+	if err := repo.CreateDeliveryEvent(ctx, otherEvent); err != nil {
+		t.Fatalf("Failed to create delivery event: %v", err)
+	}
+	otherHash, err := crypto.HashPassword("revoke-other", nil)
+	if err != nil {
+		t.Fatalf("Failed to hash code: %v", err)
+	}
+	otherCode := &models.AccessCode{
+		Code:            base64.StdEncoding.EncodeToString(otherHash),
+		RecipientID:     recipient.ID,
+		UserID:          user.ID,
+		DeliveryEventID: otherEvent.ID,
+		ExpiresAt:       time.Now().UTC().Add(30 * 24 * time.Hour),
+		MaxAttempts:     5,
+	}
+	if err := repo.CreateAccessCode(ctx, otherCode); err != nil {
+		t.Fatalf("Failed to create access code: %v", err)
+	}
+
+	// One valid, one used and one expired code
+	plainCodes := []string{"revoke-valid", "revoke-used", "revoke-expired"}
+	var codes []*models.AccessCode
+	for i, plainCode := range plainCodes {
+		hashedCode, err := crypto.HashPassword(plainCode, nil)
+		if err != nil {
+			t.Fatalf("Failed to hash code: %v", err)
+		}
+
+		expiresAt := time.Now().UTC().Add(30 * 24 * time.Hour)
+		if i == 2 {
+			expiresAt = time.Now().UTC().Add(-time.Hour)
+		}
+
+		accessCode := &models.AccessCode{
+			Code:            base64.StdEncoding.EncodeToString(hashedCode),
+			RecipientID:     recipient.ID,
+			UserID:          user.ID,
+			DeliveryEventID: deliveryEvent.ID,
+			ExpiresAt:       expiresAt,
+			MaxAttempts:     5,
+		}
+		if err := repo.CreateAccessCode(ctx, accessCode); err != nil {
+			t.Fatalf("Failed to create access code: %v", err)
+		}
+		codes = append(codes, accessCode)
+	}
+	if err := repo.MarkAccessCodeAsUsed(ctx, codes[1].ID); err != nil {
+		t.Fatalf("Failed to mark access code as used: %v", err)
+	}
+
+	listed, err := repo.ListAccessCodesByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list access codes: %v", err)
+	}
+	if len(listed) != 4 {
+		t.Fatalf("Expected 4 access codes, got %d", len(listed))
+	}
+
+	revoked, err := repo.RevokeAccessCodesByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to revoke access codes: %v", err)
+	}
+	if revoked != 3 {
+		t.Errorf("Expected the valid, the used and the emergency access code to be revoked, got %d", revoked)
+	}
+
+	for _, plainCode := range []string{"revoke-valid", "revoke-used", "revoke-other"} {
+		if _, err := repo.VerifyAccessCode(ctx, plainCode); err != ErrNotFound {
+			t.Errorf("Expected revoked code %s to be rejected, got %v", plainCode, err)
+		}
+	}
+
+	// Nothing is left to revoke
+	revoked, err = repo.RevokeAccessCodesByUserID(ctx, user.ID)
+	if err != nil || revoked != 0 {
+		t.Errorf("Expected nothing to revoke a second time, got %d: %v", revoked, err)
+	}
+}
+
+func TestVerifyAccessCodeHash(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	user := createTestUser(t, repo, "test6@example.com")
+	recipient := createTestRecipient(t, repo, user.ID, "recipient6@example.com")
+
+	deliveryEvent := &models.DeliveryEvent{
+		UserID:      user.ID,
+		RecipientID: recipient.ID,
+		SentAt:      time.Now().UTC(),
+		Status:      models.DeliveryStatusSent,
+	}
+	if err := repo.CreateDeliveryEvent(ctx, deliveryEvent); err != nil {
+		t.Fatalf("Failed to create delivery event: %v", err)
+	}
+
+	// One valid, one expired and one locked code
+	var codes []*models.AccessCode
+	for i, plainCode := range []string{"keyed-valid", "keyed-expired", "keyed-locked"} {
+		expiresAt := time.Now().UTC().Add(24 * time.Hour)
+		if i == 1 {
+			expiresAt = time.Now().UTC().Add(-time.Hour)
+		}
+
+		accessCode := &models.AccessCode{
+			Code:            crypto.HashAccessCode(plainCode, key),
+			RecipientID:     recipient.ID,
+			UserID:          user.ID,
+			DeliveryEventID: deliveryEvent.ID,
+			ExpiresAt:       expiresAt,
+			MaxAttempts:     1,
+		}
+		if err := repo.CreateAccessCode(ctx, accessCode); err != nil {
+			t.Fatalf("Failed to create access code: %v", err)
+		}
+		codes = append(codes, accessCode)
+	}
+	if err := repo.IncrementAccessCodeAttempts(ctx, codes[2].ID); err != nil {
+		t.Fatalf("Failed to increment attempts: %v", err)
+	}
+
+	verified, err := repo.VerifyAccessCodeHash(ctx, crypto.HashAccessCode("keyed-valid", key))
+	if err != nil || verified.ID != codes[0].ID {
+		t.Fatalf("Expected the valid code to verify, got %v", err)
+	}
+
+	if _, err := repo.VerifyAccessCodeHash(ctx, crypto.HashAccessCode("keyed-valid", []byte("another key"))); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a hash with another key, got %v", err)
+	}
+	if _, err := repo.VerifyAccessCodeHash(ctx, crypto.HashAccessCode("keyed-expired", key)); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired code, got %v", err)
+	}
+	if _, err := repo.VerifyAccessCodeHash(ctx, crypto.HashAccessCode("keyed-locked", key)); err != ErrAccessCodeLocked {
+		t.Errorf("Expected ErrAccessCodeLocked, got %v", err)
+	}
+
+	// Keyed codes are never hashed again by the lookup of older codes
+	if _, err := repo.VerifyAccessCode(ctx, "keyed-valid"); err != ErrNotFound {
+		t.Errorf("Expected the lookup of older codes to skip keyed codes, got %v", err)
+	}
+}
+
 // Helper functions
 
 func setupTestDB(t *testing.T) (Repository, func()) {
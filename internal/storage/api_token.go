@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// CreateAPIToken creates a new API token. Only the token hash is stored.
+func (r *SQLiteRepository) CreateAPIToken(ctx context.Context, token *models.APIToken) error {
+	if token.ID == "" {
+		token.ID = generateID()
+	}
+
+	token.CreatedAt = time.Now().UTC()
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO api_tokens (
+			id, user_id, name, token_hash, scopes, created_at, last_used_at, revoked_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		token.ID, token.UserID, token.Name, token.TokenHash,
+		strings.Join(token.Scopes, ","), token.CreatedAt, token.LastUsedAt, token.RevokedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create API token: %w", err)
+	}
+
+	return nil
+}
+
+// GetAPITokenByHash retrieves an API token by the hash of the token
+func (r *SQLiteRepository) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at, revoked_at
+		FROM api_tokens
+		WHERE token_hash = ?
+	`, tokenHash)
+
+	token, err := scanAPIToken(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API token: %w", err)
+	}
+
+	return token, nil
+}
+
+// ListAPITokensByUserID lists all API tokens of a user, including revoked ones
+func (r *SQLiteRepository) ListAPITokensByUserID(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at, revoked_at
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*models.APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// UpdateAPITokenLastUsed records that an API token has just been used
+func (r *SQLiteRepository) UpdateAPITokenLastUsed(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE api_tokens
+		SET last_used_at = ?
+		WHERE id = ?
+	`, time.Now().UTC(), id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update API token last used: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeAPIToken revokes an API token. Revoked tokens are kept so the audit log stays readable.
+func (r *SQLiteRepository) RevokeAPIToken(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_tokens
+		SET revoked_at = ?
+		WHERE id = ? AND revoked_at IS NULL
+	`, time.Now().UTC(), id)
+
+	if err != nil {
+		return fmt.Errorf("failed to revoke API token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// apiTokenScanner is implemented by both *sql.Row and *sql.Rows
+type apiTokenScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIToken scans an api_tokens row into a model
+func scanAPIToken(row apiTokenScanner) (*models.APIToken, error) {
+	token := &models.APIToken{}
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+
+	if err := row.Scan(
+		&token.ID, &token.UserID, &token.Name, &token.TokenHash, &scopes,
+		&token.CreatedAt, &lastUsedAt, &revokedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return token, nil
+}
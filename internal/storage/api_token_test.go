@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+func TestAPITokenOperations(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test@example.com")
+
+	// Test CreateAPIToken
+	token := &models.APIToken{
+		UserID:    user.ID,
+		Name:      "laptop cron",
+		TokenHash: "hash_1",
+		Scopes:    []string{models.APITokenScopeCheckIn, models.APITokenScopeRead},
+	}
+	if err := repo.CreateAPIToken(ctx, token); err != nil {
+		t.Fatalf("Failed to create API token: %v", err)
+	}
+	if token.ID == "" {
+		t.Fatal("API token ID was not generated")
+	}
+
+	// Test GetAPITokenByHash
+	retrieved, err := repo.GetAPITokenByHash(ctx, "hash_1")
+	if err != nil {
+		t.Fatalf("Failed to get API token: %v", err)
+	}
+	if retrieved.Name != "laptop cron" {
+		t.Errorf("Expected name %s, got %s", "laptop cron", retrieved.Name)
+	}
+	if !retrieved.HasScope(models.APITokenScopeCheckIn) || !retrieved.HasScope(models.APITokenScopeRead) {
+		t.Errorf("Expected check_in and read scopes, got %v", retrieved.Scopes)
+	}
+	if retrieved.HasScope(models.APITokenScopeWrite) {
+		t.Error("Expected token not to have the write scope")
+	}
+	if retrieved.LastUsedAt != nil || retrieved.IsRevoked() {
+		t.Error("Expected a new token to be unused and not revoked")
+	}
+
+	if _, err := repo.GetAPITokenByHash(ctx, "unknown"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an unknown hash, got %v", err)
+	}
+
+	// Test UpdateAPITokenLastUsed
+	if err := repo.UpdateAPITokenLastUsed(ctx, token.ID); err != nil {
+		t.Fatalf("Failed to update last used: %v", err)
+	}
+	retrieved, err = repo.GetAPITokenByHash(ctx, "hash_1")
+	if err != nil {
+		t.Fatalf("Failed to get API token: %v", err)
+	}
+	if retrieved.LastUsedAt == nil {
+		t.Error("Expected last used time to be set")
+	}
+
+	// Test ListAPITokensByUserID
+	second := &models.APIToken{
+		UserID:    user.ID,
+		Name:      "phone",
+		TokenHash: "hash_2",
+		Scopes:    []string{models.APITokenScopeCheckIn},
+	}
+	if err := repo.CreateAPIToken(ctx, second); err != nil {
+		t.Fatalf("Failed to create API token: %v", err)
+	}
+	tokens, err := repo.ListAPITokensByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list API tokens: %v", err)
+	}
+	if len(tokens) != 2 {
+		t.Errorf("Expected 2 API tokens, got %d", len(tokens))
+	}
+
+	// Test RevokeAPIToken
+	if err := repo.RevokeAPIToken(ctx, token.ID); err != nil {
+		t.Fatalf("Failed to revoke API token: %v", err)
+	}
+	retrieved, err = repo.GetAPITokenByHash(ctx, "hash_1")
+	if err != nil {
+		t.Fatalf("Failed to get API token: %v", err)
+	}
+	if !retrieved.IsRevoked() {
+		t.Error("Expected token to be revoked")
+	}
+
+	// Revoking twice reports the token as not found
+	if err := repo.RevokeAPIToken(ctx, token.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when revoking twice, got %v", err)
+	}
+}
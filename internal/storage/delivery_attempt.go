@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// CreateDeliveryAttempt records an attempt at sending a delivery event
+func (r *SQLiteRepository) CreateDeliveryAttempt(ctx context.Context, attempt *models.DeliveryAttempt) error {
+	if attempt.ID == "" {
+		attempt.ID = generateID()
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO delivery_attempts (
+			id, delivery_event_id, attempted_at, success, error_message
+		) VALUES (?, ?, ?, ?, ?)
+	`,
+		attempt.ID, attempt.DeliveryEventID, attempt.AttemptedAt,
+		attempt.Success, attempt.ErrorMessage,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create delivery attempt: %w", err)
+	}
+
+	return nil
+}
+
+// ListDeliveryAttemptsByEventID lists the attempts of a delivery event, oldest first
+func (r *SQLiteRepository) ListDeliveryAttemptsByEventID(ctx context.Context, eventID string) ([]*models.DeliveryAttempt, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, delivery_event_id, attempted_at, success, error_message
+		FROM delivery_attempts
+		WHERE delivery_event_id = ?
+		ORDER BY attempted_at
+	`, eventID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query delivery attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []*models.DeliveryAttempt
+	for rows.Next() {
+		attempt := &models.DeliveryAttempt{}
+		if err := rows.Scan(
+			&attempt.ID, &attempt.DeliveryEventID, &attempt.AttemptedAt,
+			&attempt.Success, &attempt.ErrorMessage,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery attempt: %w", err)
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating delivery attempts: %w", err)
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+func TestDeliveryRetryOperations(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test@example.com")
+	recipient := createTestRecipient(t, repo, user.ID, "recipient@example.com")
+
+	now := time.Now().UTC()
+	later := now.Add(time.Hour)
+
+	// One delivery is due, one is scheduled for later and one was already sent
+	due := &models.DeliveryEvent{UserID: user.ID, RecipientID: recipient.ID, SentAt: now, Status: models.DeliveryStatusPending, NextAttemptAt: &now}
+	scheduled := &models.DeliveryEvent{UserID: user.ID, RecipientID: recipient.ID, SentAt: now, Status: models.DeliveryStatusRetrying, NextAttemptAt: &later}
+	sent := &models.DeliveryEvent{UserID: user.ID, RecipientID: recipient.ID, SentAt: now, Status: models.DeliveryStatusSent}
+	for _, event := range []*models.DeliveryEvent{due, scheduled, sent} {
+		if err := repo.CreateDeliveryEvent(ctx, event); err != nil {
+			t.Fatalf("Failed to create delivery event: %v", err)
+		}
+	}
+
+	events, err := repo.ListDueDeliveryEvents(ctx, now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to list due delivery events: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != due.ID {
+		t.Fatalf("Expected only the due delivery event, got %d events", len(events))
+	}
+
+	// Record a failed attempt and schedule the next one
+	attempt := &models.DeliveryAttempt{DeliveryEventID: due.ID, AttemptedAt: now, ErrorMessage: "connection refused"}
+	if err := repo.CreateDeliveryAttempt(ctx, attempt); err != nil {
+		t.Fatalf("Failed to create delivery attempt: %v", err)
+	}
+
+	due.Status = models.DeliveryStatusRetrying
+	due.ErrorMessage = "connection refused"
+	due.Attempts = 1
+	due.LastAttemptAt = &now
+	due.NextAttemptAt = &later
+	if err := repo.UpdateDeliveryEvent(ctx, due); err != nil {
+		t.Fatalf("Failed to update delivery event: %v", err)
+	}
+
+	events, err = repo.ListDueDeliveryEvents(ctx, now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to list due delivery events: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no due delivery events, got %d", len(events))
+	}
+
+	events, err = repo.ListDueDeliveryEvents(ctx, later.Add(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to list due delivery events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("Expected 2 due delivery events, got %d", len(events))
+	}
+
+	// The retry fields are stored
+	allEvents, err := repo.ListDeliveryEventsByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list delivery events: %v", err)
+	}
+	for _, event := range allEvents {
+		if event.ID != due.ID {
+			continue
+		}
+		if event.Attempts != 1 || event.LastAttemptAt == nil || event.NextAttemptAt == nil || event.ErrorMessage != "connection refused" {
+			t.Errorf("Retry fields were not stored: %+v", event)
+		}
+	}
+
+	attempts, err := repo.ListDeliveryAttemptsByEventID(ctx, due.ID)
+	if err != nil {
+		t.Fatalf("Failed to list delivery attempts: %v", err)
+	}
+	if len(attempts) != 1 || attempts[0].Success || attempts[0].ErrorMessage != "connection refused" {
+		t.Errorf("Unexpected delivery attempts: %+v", attempts)
+	}
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// CreateDeliveryRun creates a new delivery run
+func (r *SQLiteRepository) CreateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error {
+	if run.ID == "" {
+		run.ID = generateID()
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO delivery_runs (
+			id, user_id, status, started_at, fires_at, abort_code, last_alert_at, completed_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		run.ID, run.UserID, run.Status, run.StartedAt, run.FiresAt,
+		run.AbortCode, run.LastAlertAt, run.CompletedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create delivery run: %w", err)
+	}
+
+	return nil
+}
+
+// GetActiveDeliveryRunByUserID retrieves the delivery run of a user that is armed or still in progress
+func (r *SQLiteRepository) GetActiveDeliveryRunByUserID(ctx context.Context, userID string) (*models.DeliveryRun, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, user_id, status, started_at, fires_at, abort_code, last_alert_at, completed_at
+		FROM delivery_runs
+		WHERE user_id = ? AND status IN (?, ?)
+		ORDER BY started_at DESC
+		LIMIT 1
+	`, userID, models.DeliveryRunStatusArmed, models.DeliveryRunStatusInProgress)
+
+	run, err := scanDeliveryRun(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active delivery run: %w", err)
+	}
+
+	return run, nil
+}
+
+// GetDeliveryRunByAbortCode retrieves a delivery run by the code of its abort link
+func (r *SQLiteRepository) GetDeliveryRunByAbortCode(ctx context.Context, code string) (*models.DeliveryRun, error) {
+	if code == "" {
+		return nil, ErrNotFound
+	}
+
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, user_id, status, started_at, fires_at, abort_code, last_alert_at, completed_at
+		FROM delivery_runs
+		WHERE abort_code = ?
+	`, code)
+
+	run, err := scanDeliveryRun(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery run: %w", err)
+	}
+
+	return run, nil
+}
+
+// ListDeliveryRunsByStatus lists all delivery runs with the given status
+func (r *SQLiteRepository) ListDeliveryRunsByStatus(ctx context.Context, status string) ([]*models.DeliveryRun, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, status, started_at, fires_at, abort_code, last_alert_at, completed_at
+		FROM delivery_runs
+		WHERE status = ?
+		ORDER BY started_at ASC
+	`, status)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query delivery runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []*models.DeliveryRun
+	for rows.Next() {
+		run, err := scanDeliveryRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan delivery run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating delivery runs: %w", err)
+	}
+
+	return runs, nil
+}
+
+// ListDeliveryRunsByUserID lists all delivery runs of a user, newest first
+func (r *SQLiteRepository) ListDeliveryRunsByUserID(ctx context.Context, userID string) ([]*models.DeliveryRun, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, status, started_at, fires_at, abort_code, last_alert_at, completed_at
+		FROM delivery_runs
+		WHERE user_id = ?
+		ORDER BY started_at DESC
+	`, userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query delivery runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []*models.DeliveryRun
+	for rows.Next() {
+		run, err := scanDeliveryRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan delivery run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating delivery runs: %w", err)
+	}
+
+	return runs, nil
+}
+
+// UpdateDeliveryRun updates an existing delivery run
+func (r *SQLiteRepository) UpdateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE delivery_runs
+		SET status = ?, fires_at = ?, last_alert_at = ?, completed_at = ?
+		WHERE id = ?
+	`, run.Status, run.FiresAt, run.LastAlertAt, run.CompletedAt, run.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update delivery run: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// CompleteDeliveryRun marks a delivery run as completed
+func (r *SQLiteRepository) CompleteDeliveryRun(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE delivery_runs
+		SET status = ?, completed_at = ?
+		WHERE id = ?
+	`, models.DeliveryRunStatusCompleted, time.Now().UTC(), id)
+
+	if err != nil {
+		return fmt.Errorf("failed to complete delivery run: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// deliveryRunScanner is implemented by both *sql.Row and *sql.Rows
+type deliveryRunScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDeliveryRun scans a delivery_runs row into a model
+func scanDeliveryRun(row deliveryRunScanner) (*models.DeliveryRun, error) {
+	run := &models.DeliveryRun{}
+	var firesAt, lastAlertAt, completedAt sql.NullTime
+
+	if err := row.Scan(
+		&run.ID, &run.UserID, &run.Status, &run.StartedAt, &firesAt,
+		&run.AbortCode, &lastAlertAt, &completedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	run.FiresAt = run.StartedAt
+	if firesAt.Valid {
+		run.FiresAt = firesAt.Time
+	}
+	if lastAlertAt.Valid {
+		run.LastAlertAt = &lastAlertAt.Time
+	}
+	if completedAt.Valid {
+		run.CompletedAt = &completedAt.Time
+	}
+
+	return run, nil
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+func TestDeliveryRunOperations(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test@example.com")
+	recipient := createTestRecipient(t, repo, user.ID, "recipient@example.com")
+
+	if _, err := repo.GetActiveDeliveryRunByUserID(ctx, user.ID); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound without a run, got %v", err)
+	}
+
+	run := &models.DeliveryRun{UserID: user.ID, Status: models.DeliveryRunStatusInProgress, StartedAt: time.Now().UTC()}
+	if err := repo.CreateDeliveryRun(ctx, run); err != nil {
+		t.Fatalf("Failed to create delivery run: %v", err)
+	}
+	if run.ID == "" {
+		t.Fatal("Delivery run ID was not generated")
+	}
+
+	active, err := repo.GetActiveDeliveryRunByUserID(ctx, user.ID)
+	if err != nil || active.ID != run.ID {
+		t.Fatalf("Expected the active run %s, got %v: %v", run.ID, active, err)
+	}
+
+	// A recipient gets at most one delivery per run
+	event := &models.DeliveryEvent{UserID: user.ID, RunID: run.ID, RecipientID: recipient.ID, SentAt: time.Now().UTC(), Status: models.DeliveryStatusPending}
+	if err := repo.CreateDeliveryEvent(ctx, event); err != nil {
+		t.Fatalf("Failed to create delivery event: %v", err)
+	}
+	duplicate := &models.DeliveryEvent{UserID: user.ID, RunID: run.ID, RecipientID: recipient.ID, SentAt: time.Now().UTC(), Status: models.DeliveryStatusPending}
+	if err := repo.CreateDeliveryEvent(ctx, duplicate); err == nil {
+		t.Error("Expected a second delivery to the same recipient in the same run to fail")
+	}
+
+	events, err := repo.ListDeliveryEventsByRunID(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("Failed to list delivery events of run: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != event.ID || events[0].RunID != run.ID {
+		t.Errorf("Expected the delivery event of the run, got %d events", len(events))
+	}
+
+	older := &models.DeliveryRun{UserID: user.ID, Status: models.DeliveryRunStatusRecovered, StartedAt: time.Now().UTC().Add(-30 * 24 * time.Hour)}
+	if err := repo.CreateDeliveryRun(ctx, older); err != nil {
+		t.Fatalf("Failed to create delivery run: %v", err)
+	}
+	runs, err := repo.ListDeliveryRunsByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list delivery runs of user: %v", err)
+	}
+	if len(runs) != 2 || runs[0].ID != run.ID || runs[1].Status != models.DeliveryRunStatusRecovered {
+		t.Errorf("Expected both runs of the user, newest first, got %d runs", len(runs))
+	}
+
+	if err := repo.CompleteDeliveryRun(ctx, run.ID); err != nil {
+		t.Fatalf("Failed to complete delivery run: %v", err)
+	}
+	if _, err := repo.GetActiveDeliveryRunByUserID(ctx, user.ID); err != ErrNotFound {
+		t.Errorf("Expected no active run after completing it, got %v", err)
+	}
+	if err := repo.CompleteDeliveryRun(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an unknown run, got %v", err)
+	}
+}
+
+func TestDeliveryRunGracePeriod(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test@example.com")
+
+	now := time.Now().UTC()
+	run := &models.DeliveryRun{
+		UserID:    user.ID,
+		Status:    models.DeliveryRunStatusArmed,
+		StartedAt: now,
+		FiresAt:   now.Add(24 * time.Hour),
+		AbortCode: "abort-code",
+	}
+	if err := repo.CreateDeliveryRun(ctx, run); err != nil {
+		t.Fatalf("Failed to create delivery run: %v", err)
+	}
+
+	// An armed run counts as active
+	active, err := repo.GetActiveDeliveryRunByUserID(ctx, user.ID)
+	if err != nil || active.ID != run.ID {
+		t.Fatalf("Expected the armed run %s to be active, got %v: %v", run.ID, active, err)
+	}
+
+	found, err := repo.GetDeliveryRunByAbortCode(ctx, "abort-code")
+	if err != nil || found.ID != run.ID || !found.FiresAt.Equal(run.FiresAt) {
+		t.Fatalf("Expected to find run %s by its abort code, got %v: %v", run.ID, found, err)
+	}
+	if _, err := repo.GetDeliveryRunByAbortCode(ctx, ""); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an empty abort code, got %v", err)
+	}
+
+	armed, err := repo.ListDeliveryRunsByStatus(ctx, models.DeliveryRunStatusArmed)
+	if err != nil || len(armed) != 1 {
+		t.Fatalf("Expected 1 armed run, got %d: %v", len(armed), err)
+	}
+
+	completedAt := time.Now().UTC()
+	run.Status = models.DeliveryRunStatusAborted
+	run.LastAlertAt = &completedAt
+	run.CompletedAt = &completedAt
+	if err := repo.UpdateDeliveryRun(ctx, run); err != nil {
+		t.Fatalf("Failed to update delivery run: %v", err)
+	}
+
+	found, err = repo.GetDeliveryRunByAbortCode(ctx, "abort-code")
+	if err != nil {
+		t.Fatalf("Failed to get delivery run: %v", err)
+	}
+	if found.Status != models.DeliveryRunStatusAborted || found.LastAlertAt == nil || found.CompletedAt == nil {
+		t.Errorf("Expected an aborted run with alert and completion times, got %+v", found)
+	}
+	if _, err := repo.GetActiveDeliveryRunByUserID(ctx, user.ID); err != ErrNotFound {
+		t.Errorf("Expected no active run after aborting it, got %v", err)
+	}
+
+	run.ID = "missing"
+	if err := repo.UpdateDeliveryRun(ctx, run); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an unknown run, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// CreateEmergencyAccessRequest creates a new emergency access request
+func (r *SQLiteRepository) CreateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	if request.ID == "" {
+		request.ID = generateID()
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO emergency_access_requests (
+			id, user_id, recipient_id, status, verify_code, deny_code,
+			requested_at, releases_at, resolved_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		request.ID, request.UserID, request.RecipientID, request.Status, request.VerifyCode, request.DenyCode,
+		request.RequestedAt, request.ReleasesAt, request.ResolvedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create emergency access request: %w", err)
+	}
+
+	return nil
+}
+
+// GetEmergencyAccessRequestByVerifyCode retrieves an emergency access request by the code the recipient confirms it with
+func (r *SQLiteRepository) GetEmergencyAccessRequestByVerifyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error) {
+	return r.getEmergencyAccessRequest(ctx, "verify_code", code)
+}
+
+// GetEmergencyAccessRequestByDenyCode retrieves an emergency access request by the code the owner denies it with
+func (r *SQLiteRepository) GetEmergencyAccessRequestByDenyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error) {
+	return r.getEmergencyAccessRequest(ctx, "deny_code", code)
+}
+
+// getEmergencyAccessRequest retrieves an emergency access request by one of its codes
+func (r *SQLiteRepository) getEmergencyAccessRequest(ctx context.Context, column, code string) (*models.EmergencyAccessRequest, error) {
+	if code == "" {
+		return nil, ErrNotFound
+	}
+
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, user_id, recipient_id, status, verify_code, deny_code,
+			requested_at, releases_at, resolved_at
+		FROM emergency_access_requests
+		WHERE `+column+` = ?
+	`, code)
+
+	request, err := scanEmergencyAccessRequest(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get emergency access request: %w", err)
+	}
+
+	return request, nil
+}
+
+// ListEmergencyAccessRequestsByUserID lists the emergency access requests for a user's secrets, newest first
+func (r *SQLiteRepository) ListEmergencyAccessRequestsByUserID(ctx context.Context, userID string) ([]*models.EmergencyAccessRequest, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, recipient_id, status, verify_code, deny_code,
+			requested_at, releases_at, resolved_at
+		FROM emergency_access_requests
+		WHERE user_id = ?
+		ORDER BY requested_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list emergency access requests: %w", err)
+	}
+	defer rows.Close()
+
+	return scanEmergencyAccessRequests(rows)
+}
+
+// ListDueEmergencyAccessRequests lists the pending emergency access requests whose waiting period is over
+func (r *SQLiteRepository) ListDueEmergencyAccessRequests(ctx context.Context, now time.Time) ([]*models.EmergencyAccessRequest, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, recipient_id, status, verify_code, deny_code,
+			requested_at, releases_at, resolved_at
+		FROM emergency_access_requests
+		WHERE status = ? AND releases_at <= ?
+		ORDER BY releases_at ASC
+	`, models.EmergencyAccessStatusPending, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due emergency access requests: %w", err)
+	}
+	defer rows.Close()
+
+	return scanEmergencyAccessRequests(rows)
+}
+
+// UpdateEmergencyAccessRequest updates an existing emergency access request
+func (r *SQLiteRepository) UpdateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE emergency_access_requests
+		SET status = ?, releases_at = ?, resolved_at = ?
+		WHERE id = ?
+	`, request.Status, request.ReleasesAt, request.ResolvedAt, request.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update emergency access request: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// scanEmergencyAccessRequests scans all emergency_access_requests rows into models
+func scanEmergencyAccessRequests(rows *sql.Rows) ([]*models.EmergencyAccessRequest, error) {
+	var requests []*models.EmergencyAccessRequest
+	for rows.Next() {
+		request, err := scanEmergencyAccessRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan emergency access request: %w", err)
+		}
+		requests = append(requests, request)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating emergency access requests: %w", err)
+	}
+
+	return requests, nil
+}
+
+// emergencyAccessRequestScanner is implemented by both *sql.Row and *sql.Rows
+type emergencyAccessRequestScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEmergencyAccessRequest scans an emergency_access_requests row into a model
+func scanEmergencyAccessRequest(row emergencyAccessRequestScanner) (*models.EmergencyAccessRequest, error) {
+	request := &models.EmergencyAccessRequest{}
+	var releasesAt, resolvedAt sql.NullTime
+
+	if err := row.Scan(
+		&request.ID, &request.UserID, &request.RecipientID, &request.Status, &request.VerifyCode, &request.DenyCode,
+		&request.RequestedAt, &releasesAt, &resolvedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if releasesAt.Valid {
+		request.ReleasesAt = &releasesAt.Time
+	}
+	if resolvedAt.Valid {
+		request.ResolvedAt = &resolvedAt.Time
+	}
+
+	return request, nil
+}
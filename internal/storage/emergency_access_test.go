@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+func TestEmergencyAccessRequestOperations(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test@example.com")
+	recipient := createTestRecipient(t, repo, user.ID, "Recipient@Example.com")
+
+	// The emergency access setting survives a round trip through the users table
+	user.EmergencyAccessDays = 3
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	retrievedUser, err := repo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if retrievedUser.EmergencyAccessDays != 3 {
+		t.Errorf("Expected 3 emergency access days, got %d", retrievedUser.EmergencyAccessDays)
+	}
+
+	// Test ListRecipientsByEmail ignores case
+	recipients, err := repo.ListRecipientsByEmail(ctx, "recipient@example.com")
+	if err != nil {
+		t.Fatalf("Failed to list recipients by email: %v", err)
+	}
+	if len(recipients) != 1 || recipients[0].ID != recipient.ID {
+		t.Errorf("Expected the recipient to be found by email, got %d recipients", len(recipients))
+	}
+
+	// Test CreateEmergencyAccessRequest
+	request := &models.EmergencyAccessRequest{
+		UserID:      user.ID,
+		RecipientID: recipient.ID,
+		Status:      models.EmergencyAccessStatusUnverified,
+		VerifyCode:  "verify1",
+		DenyCode:    "deny1",
+		RequestedAt: time.Now().UTC(),
+	}
+	if err := repo.CreateEmergencyAccessRequest(ctx, request); err != nil {
+		t.Fatalf("Failed to create emergency access request: %v", err)
+	}
+	if request.ID == "" {
+		t.Fatal("Emergency access request ID was not generated")
+	}
+
+	// Test GetEmergencyAccessRequestByVerifyCode
+	retrieved, err := repo.GetEmergencyAccessRequestByVerifyCode(ctx, "verify1")
+	if err != nil {
+		t.Fatalf("Failed to get emergency access request: %v", err)
+	}
+	if retrieved.ID != request.ID || retrieved.Status != models.EmergencyAccessStatusUnverified || retrieved.ReleasesAt != nil {
+		t.Errorf("Unexpected emergency access request %+v", retrieved)
+	}
+
+	if _, err := repo.GetEmergencyAccessRequestByDenyCode(ctx, "verify1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a verify code used as deny code, got %v", err)
+	}
+	if _, err := repo.GetEmergencyAccessRequestByDenyCode(ctx, ""); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an empty code, got %v", err)
+	}
+
+	// Nothing is due before the request is confirmed
+	due, err := repo.ListDueEmergencyAccessRequests(ctx, time.Now().UTC().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to list due emergency access requests: %v", err)
+	}
+	if len(due) != 0 {
+		t.Errorf("Expected no due requests, got %d", len(due))
+	}
+
+	// Test UpdateEmergencyAccessRequest
+	releasesAt := time.Now().UTC().Add(time.Hour)
+	request.Status = models.EmergencyAccessStatusPending
+	request.ReleasesAt = &releasesAt
+	if err := repo.UpdateEmergencyAccessRequest(ctx, request); err != nil {
+		t.Fatalf("Failed to update emergency access request: %v", err)
+	}
+
+	retrieved, err = repo.GetEmergencyAccessRequestByDenyCode(ctx, "deny1")
+	if err != nil {
+		t.Fatalf("Failed to get emergency access request: %v", err)
+	}
+	if retrieved.Status != models.EmergencyAccessStatusPending || retrieved.ReleasesAt == nil {
+		t.Errorf("Expected a pending request with a release time, got %+v", retrieved)
+	}
+
+	// Test ListDueEmergencyAccessRequests
+	due, err = repo.ListDueEmergencyAccessRequests(ctx, time.Now().UTC())
+	if err != nil {
+		t.Fatalf("Failed to list due emergency access requests: %v", err)
+	}
+	if len(due) != 0 {
+		t.Errorf("Expected no due requests before the waiting period ends, got %d", len(due))
+	}
+
+	due, err = repo.ListDueEmergencyAccessRequests(ctx, releasesAt.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to list due emergency access requests: %v", err)
+	}
+	if len(due) != 1 || due[0].ID != request.ID {
+		t.Errorf("Expected the request to be due after the waiting period, got %d", len(due))
+	}
+
+	// Test ListEmergencyAccessRequestsByUserID
+	requests, err := repo.ListEmergencyAccessRequestsByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list emergency access requests: %v", err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("Expected 1 emergency access request, got %d", len(requests))
+	}
+
+	// The codes are unique
+	duplicate := &models.EmergencyAccessRequest{
+		UserID:      user.ID,
+		RecipientID: recipient.ID,
+		Status:      models.EmergencyAccessStatusUnverified,
+		VerifyCode:  "verify1",
+		DenyCode:    "deny2",
+		RequestedAt: time.Now().UTC(),
+	}
+	if err := repo.CreateEmergencyAccessRequest(ctx, duplicate); err == nil || !strings.Contains(err.Error(), "failed to create") {
+		t.Errorf("Expected a duplicate verify code to be rejected, got %v", err)
+	}
+
+	if err := repo.UpdateEmergencyAccessRequest(ctx, &models.EmergencyAccessRequest{ID: "missing"}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an unknown request, got %v", err)
+	}
+}
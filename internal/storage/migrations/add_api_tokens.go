@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"database/sql"
+	"log"
+)
+
+// AddAPITokensTable creates the api_tokens table for personal API tokens
+func AddAPITokensTable(db *sql.DB) error {
+	log.Println("Adding api_tokens table...")
+
+	query := `
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,  -- SHA-256 of the token
+		scopes TEXT NOT NULL,             -- Comma separated list of scopes
+		created_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+	`
+
+	_, err := db.Exec(query)
+	if err != nil {
+		log.Printf("Failed to create api_tokens table: %v", err)
+		return err
+	}
+
+	log.Println("api_tokens table added successfully")
+	return nil
+}
@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// AddDeliveryRetries adds the retry columns to delivery_events and the delivery_attempts table
+func AddDeliveryRetries(db *sql.DB) error {
+	log.Println("Running migration: Adding delivery retry fields")
+
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"last_attempt_at", "DATETIME"},
+		{"next_attempt_at", "DATETIME"},
+	}
+
+	requeue := false
+	for _, column := range columns {
+		// Check if the column already exists
+		var count int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info('delivery_events')
+			WHERE name = ?
+		`, column.name).Scan(&count)
+
+		if err != nil {
+			return fmt.Errorf("failed to check if delivery_events.%s column exists: %w", column.name, err)
+		}
+
+		if count > 0 {
+			log.Printf("delivery_events.%s column already exists, skipping", column.name)
+			continue
+		}
+
+		// Add the column
+		_, err = db.Exec(fmt.Sprintf(`
+			ALTER TABLE delivery_events
+			ADD COLUMN %s %s
+		`, column.name, column.definition))
+
+		if err != nil {
+			return fmt.Errorf("failed to add delivery_events.%s column: %w", column.name, err)
+		}
+
+		if column.name == "next_attempt_at" {
+			requeue = true
+		}
+	}
+
+	// Deliveries that failed before retries existed get one more chance
+	if requeue {
+		_, err := db.Exec(`
+			UPDATE delivery_events
+			SET status = 'retrying', next_attempt_at = ?
+			WHERE status IN ('pending', 'failed')
+		`, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("failed to requeue failed deliveries: %w", err)
+		}
+	}
+
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS delivery_attempts (
+		id TEXT PRIMARY KEY,
+		delivery_event_id TEXT NOT NULL,
+		attempted_at DATETIME NOT NULL,
+		success BOOLEAN NOT NULL DEFAULT 0,
+		error_message TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (delivery_event_id) REFERENCES delivery_events(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_delivery_attempts_delivery_event_id ON delivery_attempts(delivery_event_id);
+	CREATE INDEX IF NOT EXISTS idx_delivery_events_next_attempt_at ON delivery_events(next_attempt_at);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create delivery_attempts table: %w", err)
+	}
+
+	log.Println("Successfully added delivery retry fields")
+	return nil
+}
@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddDeliveryRuns adds the delivery_runs table and links delivery events to their run
+func AddDeliveryRuns(db *sql.DB) error {
+	log.Println("Running migration: Adding delivery runs")
+
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS delivery_runs (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		started_at DATETIME NOT NULL,
+		completed_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_delivery_runs_user_id ON delivery_runs(user_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create delivery_runs table: %w", err)
+	}
+
+	// Check if the column already exists
+	var count int
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('delivery_events')
+		WHERE name = 'run_id'
+	`).Scan(&count)
+
+	if err != nil {
+		return fmt.Errorf("failed to check if delivery_events.run_id column exists: %w", err)
+	}
+
+	if count == 0 {
+		// Events created before delivery runs existed keep an empty run ID
+		_, err = db.Exec(`
+			ALTER TABLE delivery_events
+			ADD COLUMN run_id TEXT NOT NULL DEFAULT ''
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to add delivery_events.run_id column: %w", err)
+		}
+	} else {
+		log.Println("delivery_events.run_id column already exists, skipping")
+	}
+
+	// A recipient gets at most one delivery per run
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_delivery_events_run_recipient
+		ON delivery_events(run_id, recipient_id)
+		WHERE run_id != ''
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create delivery run index: %w", err)
+	}
+
+	log.Println("Successfully added delivery runs")
+	return nil
+}
@@ -0,0 +1,63 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddEmergencyAccess adds the emergency access waiting period to users and the emergency_access_requests table
+func AddEmergencyAccess(db *sql.DB) error {
+	log.Println("Running migration: Adding emergency access")
+
+	// Check if the column already exists
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('users')
+		WHERE name = 'emergency_access_days'
+	`).Scan(&count)
+
+	if err != nil {
+		return fmt.Errorf("failed to check if emergency_access_days column exists: %w", err)
+	}
+
+	if count == 0 {
+		// Emergency access stays disabled until the owner sets a waiting period
+		_, err = db.Exec(`
+			ALTER TABLE users
+			ADD COLUMN emergency_access_days INTEGER NOT NULL DEFAULT 0
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to add emergency_access_days column: %w", err)
+		}
+	} else {
+		log.Println("emergency_access_days column already exists, skipping")
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS emergency_access_requests (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		recipient_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		verify_code TEXT NOT NULL,
+		deny_code TEXT NOT NULL,
+		requested_at DATETIME NOT NULL,
+		releases_at DATETIME,
+		resolved_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (recipient_id) REFERENCES recipients(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_emergency_access_requests_user_id ON emergency_access_requests(user_id);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_emergency_access_requests_verify_code ON emergency_access_requests(verify_code);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_emergency_access_requests_deny_code ON emergency_access_requests(deny_code);
+	CREATE INDEX IF NOT EXISTS idx_emergency_access_requests_status ON emergency_access_requests(status, releases_at);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create emergency_access_requests table: %w", err)
+	}
+
+	log.Println("Successfully added emergency access")
+	return nil
+}
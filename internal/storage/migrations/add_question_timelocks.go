@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddQuestionTimelocks adds the question_bundle column to the recipients table
+// and the question_timelocks table
+func AddQuestionTimelocks(db *sql.DB) error {
+	log.Println("Running migration: Adding question timelocks")
+
+	// Check if the column already exists
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('recipients')
+		WHERE name = 'question_bundle'
+	`).Scan(&count)
+
+	if err != nil {
+		return fmt.Errorf("failed to check if recipients.question_bundle column exists: %w", err)
+	}
+
+	if count == 0 {
+		_, err = db.Exec(`
+			ALTER TABLE recipients
+			ADD COLUMN question_bundle TEXT NOT NULL DEFAULT ''
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to add recipients.question_bundle column: %w", err)
+		}
+	} else {
+		log.Println("recipients.question_bundle column already exists, skipping")
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS question_timelocks (
+		id TEXT PRIMARY KEY,
+		recipient_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		round INTEGER NOT NULL,
+		opens_at TIMESTAMP NOT NULL,
+		data TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (recipient_id) REFERENCES recipients(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_question_timelocks_recipient_id ON question_timelocks(recipient_id);
+	CREATE INDEX IF NOT EXISTS idx_question_timelocks_user_id ON question_timelocks(user_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create question_timelocks table: %w", err)
+	}
+
+	log.Println("Successfully added question timelocks")
+	return nil
+}
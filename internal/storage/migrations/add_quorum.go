@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddQuorumFields adds the quorum protection columns and the share_submissions table
+func AddQuorumFields(db *sql.DB) error {
+	log.Println("Running migration: Adding quorum protection fields")
+
+	columns := []struct {
+		table      string
+		name       string
+		definition string
+	}{
+		{"secrets", "quorum_threshold", "INTEGER NOT NULL DEFAULT 0"},
+		{"secrets", "quorum_data", "TEXT NOT NULL DEFAULT ''"},
+		{"secret_assignments", "delivery_data", "TEXT NOT NULL DEFAULT ''"},
+	}
+
+	for _, column := range columns {
+		// Check if the column already exists
+		var count int
+		err := db.QueryRow(fmt.Sprintf(`
+			SELECT COUNT(*) FROM pragma_table_info('%s')
+			WHERE name = ?
+		`, column.table), column.name).Scan(&count)
+
+		if err != nil {
+			return fmt.Errorf("failed to check if %s.%s column exists: %w", column.table, column.name, err)
+		}
+
+		if count > 0 {
+			log.Printf("%s.%s column already exists, skipping", column.table, column.name)
+			continue
+		}
+
+		// Add the column
+		_, err = db.Exec(fmt.Sprintf(`
+			ALTER TABLE %s
+			ADD COLUMN %s %s
+		`, column.table, column.name, column.definition))
+
+		if err != nil {
+			return fmt.Errorf("failed to add %s.%s column: %w", column.table, column.name, err)
+		}
+	}
+
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS share_submissions (
+		id TEXT PRIMARY KEY,
+		secret_id TEXT NOT NULL,
+		recipient_id TEXT NOT NULL,
+		share_index INTEGER NOT NULL,
+		sealed_share TEXT NOT NULL,
+		submitted_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (secret_id) REFERENCES secrets(id) ON DELETE CASCADE,
+		FOREIGN KEY (recipient_id) REFERENCES recipients(id) ON DELETE CASCADE,
+		UNIQUE (secret_id, recipient_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_share_submissions_secret_id ON share_submissions(secret_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create share_submissions table: %w", err)
+	}
+
+	log.Println("Successfully added quorum protection fields")
+	return nil
+}
@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddRecipientPublicKey adds the public_key field to the recipients table
+func AddRecipientPublicKey(db *sql.DB) error {
+	log.Println("Running migration: Adding public_key field to recipients table")
+
+	// Check if the column already exists
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('recipients')
+		WHERE name = 'public_key'
+	`).Scan(&count)
+
+	if err != nil {
+		return fmt.Errorf("failed to check if public_key column exists: %w", err)
+	}
+
+	if count > 0 {
+		log.Println("public_key column already exists, skipping migration")
+		return nil
+	}
+
+	// Add the column, existing recipients have no key and get plain copies as before
+	_, err = db.Exec(`
+		ALTER TABLE recipients
+		ADD COLUMN public_key TEXT NOT NULL DEFAULT ''
+	`)
+
+	if err != nil {
+		return fmt.Errorf("failed to add public_key column: %w", err)
+	}
+
+	log.Println("Successfully added public_key field to recipients table")
+	return nil
+}
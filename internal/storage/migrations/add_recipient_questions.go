@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddRecipientQuestions adds the personal question fields to the recipients table
+// and the recipient_questions table
+func AddRecipientQuestions(db *sql.DB) error {
+	log.Println("Running migration: Adding personal question fields")
+
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"question_threshold", "INTEGER NOT NULL DEFAULT 0"},
+		{"question_key", "TEXT NOT NULL DEFAULT ''"},
+	}
+
+	for _, column := range columns {
+		// Check if the column already exists
+		var count int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info('recipients')
+			WHERE name = ?
+		`, column.name).Scan(&count)
+
+		if err != nil {
+			return fmt.Errorf("failed to check if recipients.%s column exists: %w", column.name, err)
+		}
+
+		if count > 0 {
+			log.Printf("recipients.%s column already exists, skipping", column.name)
+			continue
+		}
+
+		// Add the column
+		_, err = db.Exec(fmt.Sprintf(`
+			ALTER TABLE recipients
+			ADD COLUMN %s %s
+		`, column.name, column.definition))
+
+		if err != nil {
+			return fmt.Errorf("failed to add recipients.%s column: %w", column.name, err)
+		}
+	}
+
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS recipient_questions (
+		id TEXT PRIMARY KEY,
+		recipient_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		question TEXT NOT NULL,
+		locked_share TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (recipient_id) REFERENCES recipients(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		UNIQUE (recipient_id, position)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_recipient_questions_recipient_id ON recipient_questions(recipient_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create recipient_questions table: %w", err)
+	}
+
+	log.Println("Successfully added personal question fields")
+	return nil
+}
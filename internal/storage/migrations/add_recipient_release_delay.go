@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddRecipientReleaseDelay adds the release_delay_days field to the recipients table
+func AddRecipientReleaseDelay(db *sql.DB) error {
+	log.Println("Running migration: Adding release_delay_days field to recipients table")
+
+	// Check if the column already exists
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('recipients')
+		WHERE name = 'release_delay_days'
+	`).Scan(&count)
+
+	if err != nil {
+		return fmt.Errorf("failed to check if release_delay_days column exists: %w", err)
+	}
+
+	if count > 0 {
+		log.Println("release_delay_days column already exists, skipping migration")
+		return nil
+	}
+
+	// Add the column, existing recipients keep getting their secrets right away
+	_, err = db.Exec(`
+		ALTER TABLE recipients
+		ADD COLUMN release_delay_days INTEGER NOT NULL DEFAULT 0
+	`)
+
+	if err != nil {
+		return fmt.Errorf("failed to add release_delay_days column: %w", err)
+	}
+
+	log.Println("Successfully added release_delay_days field to recipients table")
+	return nil
+}
@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddSecretFiles adds the columns that reference the encrypted file of a file secret
+func AddSecretFiles(db *sql.DB) error {
+	log.Println("Running migration: Adding secret files")
+
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"file_ref", "TEXT NOT NULL DEFAULT ''"},
+		{"file_size", "INTEGER NOT NULL DEFAULT 0"},
+	}
+
+	for _, column := range columns {
+		// Check if the column already exists
+		var count int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info('secrets')
+			WHERE name = ?
+		`, column.name).Scan(&count)
+
+		if err != nil {
+			return fmt.Errorf("failed to check if secrets.%s column exists: %w", column.name, err)
+		}
+
+		if count > 0 {
+			log.Printf("secrets.%s column already exists, skipping", column.name)
+			continue
+		}
+
+		// Add the column
+		_, err = db.Exec(fmt.Sprintf(`
+			ALTER TABLE secrets
+			ADD COLUMN %s %s
+		`, column.name, column.definition))
+
+		if err != nil {
+			return fmt.Errorf("failed to add secrets.%s column: %w", column.name, err)
+		}
+	}
+
+	log.Println("Successfully added secret files")
+	return nil
+}
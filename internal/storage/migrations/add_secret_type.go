@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddSecretType adds the secret_type field to the secrets table
+func AddSecretType(db *sql.DB) error {
+	log.Println("Running migration: Adding secret_type field to secrets table")
+
+	// Check if the column already exists
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('secrets')
+		WHERE name = 'secret_type'
+	`).Scan(&count)
+
+	if err != nil {
+		return fmt.Errorf("failed to check if secret_type column exists: %w", err)
+	}
+
+	if count > 0 {
+		log.Println("secret_type column already exists, skipping migration")
+		return nil
+	}
+
+	// Add the column, existing secrets have no type and stay free-form notes
+	_, err = db.Exec(`
+		ALTER TABLE secrets
+		ADD COLUMN secret_type TEXT NOT NULL DEFAULT ''
+	`)
+
+	if err != nil {
+		return fmt.Errorf("failed to add secret_type column: %w", err)
+	}
+
+	log.Println("Successfully added secret_type field to secrets table")
+	return nil
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddSecretVersions adds the secret_versions table that keeps earlier revisions of secrets
+func AddSecretVersions(db *sql.DB) error {
+	log.Println("Running migration: Adding secret versions")
+
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS secret_versions (
+		id TEXT PRIMARY KEY,
+		secret_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		encrypted_data TEXT NOT NULL,
+		encryption_type TEXT NOT NULL,
+		file_ref TEXT NOT NULL DEFAULT '',
+		file_size INTEGER NOT NULL DEFAULT 0,
+		saved_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (secret_id) REFERENCES secrets(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_secret_versions_secret_id ON secret_versions(secret_id);
+	CREATE INDEX IF NOT EXISTS idx_secret_versions_user_id ON secret_versions(user_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create secret_versions table: %w", err)
+	}
+
+	log.Println("Successfully added secret versions")
+	return nil
+}
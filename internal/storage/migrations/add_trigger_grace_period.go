@@ -0,0 +1,63 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddTriggerGracePeriod adds the grace period columns to the delivery_runs table
+func AddTriggerGracePeriod(db *sql.DB) error {
+	log.Println("Running migration: Adding trigger grace period fields")
+
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"fires_at", "DATETIME"},
+		{"abort_code", "TEXT NOT NULL DEFAULT ''"},
+		{"last_alert_at", "DATETIME"},
+	}
+
+	for _, column := range columns {
+		// Check if the column already exists
+		var count int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info('delivery_runs')
+			WHERE name = ?
+		`, column.name).Scan(&count)
+
+		if err != nil {
+			return fmt.Errorf("failed to check if delivery_runs.%s column exists: %w", column.name, err)
+		}
+
+		if count > 0 {
+			log.Printf("delivery_runs.%s column already exists, skipping", column.name)
+			continue
+		}
+
+		// Add the column
+		_, err = db.Exec(fmt.Sprintf(`
+			ALTER TABLE delivery_runs
+			ADD COLUMN %s %s
+		`, column.name, column.definition))
+
+		if err != nil {
+			return fmt.Errorf("failed to add delivery_runs.%s column: %w", column.name, err)
+		}
+	}
+
+	// Runs started before the grace period existed fired right away
+	_, err := db.Exec(`
+		UPDATE delivery_runs SET fires_at = started_at WHERE fires_at IS NULL;
+
+		CREATE INDEX IF NOT EXISTS idx_delivery_runs_abort_code ON delivery_runs(abort_code);
+		CREATE INDEX IF NOT EXISTS idx_delivery_runs_status ON delivery_runs(status);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery_runs: %w", err)
+	}
+
+	log.Println("Successfully added trigger grace period fields")
+	return nil
+}
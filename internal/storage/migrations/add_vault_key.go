@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// AddVaultKeyFields adds the vault_key_salt and encrypted_vault_key fields to the users table
+func AddVaultKeyFields(db *sql.DB) error {
+	log.Println("Running migration: Adding vault key fields to users table")
+
+	for _, column := range []string{"vault_key_salt", "encrypted_vault_key"} {
+		// Check if the column already exists
+		var count int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pragma_table_info('users')
+			WHERE name = ?
+		`, column).Scan(&count)
+
+		if err != nil {
+			return fmt.Errorf("failed to check if %s column exists: %w", column, err)
+		}
+
+		if count > 0 {
+			log.Printf("%s column already exists, skipping", column)
+			continue
+		}
+
+		// Add the column
+		_, err = db.Exec(fmt.Sprintf(`
+			ALTER TABLE users
+			ADD COLUMN %s TEXT NOT NULL DEFAULT ''
+		`, column))
+
+		if err != nil {
+			return fmt.Errorf("failed to add %s column: %w", column, err)
+		}
+	}
+
+	log.Println("Successfully added vault key fields to users table")
+	return nil
+}
@@ -19,6 +19,76 @@ func RunMigrations(db *sql.DB) error {
 		return err
 	}
 
+	// Add vault key fields to users table
+	if err := AddVaultKeyFields(db); err != nil {
+		return err
+	}
+
+	// Add quorum protection fields and share submissions table
+	if err := AddQuorumFields(db); err != nil {
+		return err
+	}
+
+	// Add API tokens table
+	if err := AddAPITokensTable(db); err != nil {
+		return err
+	}
+
+	// Add delivery retry fields and delivery attempts table
+	if err := AddDeliveryRetries(db); err != nil {
+		return err
+	}
+
+	// Add delivery runs table
+	if err := AddDeliveryRuns(db); err != nil {
+		return err
+	}
+
+	// Add trigger grace period fields to delivery runs
+	if err := AddTriggerGracePeriod(db); err != nil {
+		return err
+	}
+
+	// Add release delays to recipients
+	if err := AddRecipientReleaseDelay(db); err != nil {
+		return err
+	}
+
+	// Add emergency access requests
+	if err := AddEmergencyAccess(db); err != nil {
+		return err
+	}
+
+	// Add public keys to recipients
+	if err := AddRecipientPublicKey(db); err != nil {
+		return err
+	}
+
+	// Add personal questions to recipients
+	if err := AddRecipientQuestions(db); err != nil {
+		return err
+	}
+
+	// Add timelocked copies of the personal questions
+	if err := AddQuestionTimelocks(db); err != nil {
+		return err
+	}
+
+	// Add file references to secrets
+	if err := AddSecretFiles(db); err != nil {
+		return err
+	}
+
+	// Add earlier revisions of secrets
+	if err := AddSecretVersions(db); err != nil {
+		return err
+	}
+
+	// Add types of text secrets
+	if err := AddSecretType(db); err != nil {
+		return err
+	}
+
 	log.Println("All migrations completed successfully")
 	return nil
 }
@@ -2,8 +2,12 @@ package storage
 
 import (
 	"context"
+	"encoding/base64"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/korjavin/deadmanswitch/internal/crypto"
 	"github.com/korjavin/deadmanswitch/internal/models"
 )
 
@@ -11,13 +15,21 @@ import (
 type MockRepository struct {
 	Users                 []*models.User
 	Secrets               []*models.Secret
+	SecretVersions        []*models.SecretVersion
 	Recipients            []*models.Recipient
+	RecipientQuestions    []*models.RecipientQuestion
+	QuestionTimelocks     []*models.QuestionTimelock
 	SecretAssignments     []*models.SecretAssignment
 	Passkeys              []*models.Passkey
 	PingHistories         []*models.PingHistory
 	PingVerifications     []*models.PingVerification
 	DeliveryEvents        []*models.DeliveryEvent
+	DeliveryAttempts      []*models.DeliveryAttempt
+	DeliveryRuns          []*models.DeliveryRun
+	EmergencyRequests     []*models.EmergencyAccessRequest
 	AccessCodes           []*models.AccessCode
+	ShareSubmissions      []*models.ShareSubmission
+	APITokens             []*models.APIToken
 	Sessions              []*models.Session
 	AuditLogs             []*models.AuditLog
 	UsersForPinging       []*models.User
@@ -29,13 +41,21 @@ func NewMockRepository() *MockRepository {
 	return &MockRepository{
 		Users:                 make([]*models.User, 0),
 		Secrets:               make([]*models.Secret, 0),
+		SecretVersions:        make([]*models.SecretVersion, 0),
 		Recipients:            make([]*models.Recipient, 0),
+		RecipientQuestions:    make([]*models.RecipientQuestion, 0),
+		QuestionTimelocks:     make([]*models.QuestionTimelock, 0),
 		SecretAssignments:     make([]*models.SecretAssignment, 0),
 		Passkeys:              make([]*models.Passkey, 0),
 		PingHistories:         make([]*models.PingHistory, 0),
 		PingVerifications:     make([]*models.PingVerification, 0),
 		DeliveryEvents:        make([]*models.DeliveryEvent, 0),
+		DeliveryAttempts:      make([]*models.DeliveryAttempt, 0),
+		DeliveryRuns:          make([]*models.DeliveryRun, 0),
+		EmergencyRequests:     make([]*models.EmergencyAccessRequest, 0),
 		AccessCodes:           make([]*models.AccessCode, 0),
+		ShareSubmissions:      make([]*models.ShareSubmission, 0),
+		APITokens:             make([]*models.APIToken, 0),
 		Sessions:              make([]*models.Session, 0),
 		AuditLogs:             make([]*models.AuditLog, 0),
 		UsersForPinging:       make([]*models.User, 0),
@@ -86,6 +106,17 @@ func (m *MockRepository) UpdateUser(ctx context.Context, user *models.User) erro
 	return ErrNotFound
 }
 
+func (m *MockRepository) UpdateUserVaultKey(ctx context.Context, userID, salt, encryptedVaultKey string) error {
+	for _, u := range m.Users {
+		if u.ID == userID {
+			u.VaultKeySalt = salt
+			u.EncryptedVaultKey = encryptedVaultKey
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
 func (m *MockRepository) DeleteUser(ctx context.Context, id string) error {
 	for i, u := range m.Users {
 		if u.ID == id {
@@ -102,6 +133,9 @@ func (m *MockRepository) ListUsers(ctx context.Context) ([]*models.User, error)
 
 // Secret methods
 func (m *MockRepository) CreateSecret(ctx context.Context, secret *models.Secret) error {
+	if secret.ID == "" {
+		secret.ID = generateID()
+	}
 	m.Secrets = append(m.Secrets, secret)
 	return nil
 }
@@ -139,14 +173,76 @@ func (m *MockRepository) DeleteSecret(ctx context.Context, id string) error {
 	for i, s := range m.Secrets {
 		if s.ID == id {
 			m.Secrets = append(m.Secrets[:i], m.Secrets[i+1:]...)
+
+			// Earlier revisions are deleted with the secret
+			var versions []*models.SecretVersion
+			for _, v := range m.SecretVersions {
+				if v.SecretID != id {
+					versions = append(versions, v)
+				}
+			}
+			m.SecretVersions = versions
 			return nil
 		}
 	}
 	return ErrNotFound
 }
 
+// SecretVersion methods
+func (m *MockRepository) CreateSecretVersion(ctx context.Context, version *models.SecretVersion) error {
+	if version.ID == "" {
+		version.ID = generateID()
+	}
+	version.CreatedAt = time.Now().UTC()
+	m.SecretVersions = append(m.SecretVersions, version)
+	return nil
+}
+
+func (m *MockRepository) GetSecretVersionByID(ctx context.Context, id string) (*models.SecretVersion, error) {
+	for _, v := range m.SecretVersions {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ListSecretVersionsBySecretID returns the revisions newest first, in the reverse order they were created
+func (m *MockRepository) ListSecretVersionsBySecretID(ctx context.Context, secretID string) ([]*models.SecretVersion, error) {
+	var result []*models.SecretVersion
+	for i := len(m.SecretVersions) - 1; i >= 0; i-- {
+		if m.SecretVersions[i].SecretID == secretID {
+			result = append(result, m.SecretVersions[i])
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) ListSecretVersionsByUserID(ctx context.Context, userID string) ([]*models.SecretVersion, error) {
+	var result []*models.SecretVersion
+	for i := len(m.SecretVersions) - 1; i >= 0; i-- {
+		if m.SecretVersions[i].UserID == userID {
+			result = append(result, m.SecretVersions[i])
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) DeleteSecretVersion(ctx context.Context, id string) error {
+	for i, v := range m.SecretVersions {
+		if v.ID == id {
+			m.SecretVersions = append(m.SecretVersions[:i], m.SecretVersions[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
 // Recipient methods
 func (m *MockRepository) CreateRecipient(ctx context.Context, recipient *models.Recipient) error {
+	if recipient.ID == "" {
+		recipient.ID = generateID()
+	}
 	m.Recipients = append(m.Recipients, recipient)
 	return nil
 }
@@ -170,6 +266,16 @@ func (m *MockRepository) ListRecipientsByUserID(ctx context.Context, userID stri
 	return result, nil
 }
 
+func (m *MockRepository) ListRecipientsByEmail(ctx context.Context, email string) ([]*models.Recipient, error) {
+	var result []*models.Recipient
+	for _, r := range m.Recipients {
+		if strings.EqualFold(r.Email, email) {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
 func (m *MockRepository) UpdateRecipient(ctx context.Context, recipient *models.Recipient) error {
 	for i, r := range m.Recipients {
 		if r.ID == recipient.ID {
@@ -190,8 +296,102 @@ func (m *MockRepository) DeleteRecipient(ctx context.Context, id string) error {
 	return ErrNotFound
 }
 
+// RecipientQuestion methods
+func (m *MockRepository) ListRecipientQuestions(ctx context.Context, recipientID string) ([]*models.RecipientQuestion, error) {
+	var result []*models.RecipientQuestion
+	for _, q := range m.RecipientQuestions {
+		if q.RecipientID == recipientID {
+			result = append(result, q)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) ReplaceRecipientQuestions(ctx context.Context, recipientID string, questions []*models.RecipientQuestion) error {
+	var filtered []*models.RecipientQuestion
+	for _, q := range m.RecipientQuestions {
+		if q.RecipientID != recipientID {
+			filtered = append(filtered, q)
+		}
+	}
+	for i, q := range questions {
+		if q.ID == "" {
+			q.ID = generateID()
+		}
+		q.RecipientID = recipientID
+		q.Position = i + 1
+		filtered = append(filtered, q)
+	}
+	m.RecipientQuestions = filtered
+	return nil
+}
+
+// QuestionTimelock methods
+func (m *MockRepository) ListQuestionTimelocks(ctx context.Context, recipientID string) ([]*models.QuestionTimelock, error) {
+	var result []*models.QuestionTimelock
+	for _, tl := range m.QuestionTimelocks {
+		if tl.RecipientID == recipientID {
+			result = append(result, tl)
+		}
+	}
+	sortQuestionTimelocks(result)
+	return result, nil
+}
+
+func (m *MockRepository) ListQuestionTimelocksByUserID(ctx context.Context, userID string) ([]*models.QuestionTimelock, error) {
+	var result []*models.QuestionTimelock
+	for _, tl := range m.QuestionTimelocks {
+		if tl.UserID == userID {
+			result = append(result, tl)
+		}
+	}
+	sortQuestionTimelocks(result)
+	return result, nil
+}
+
+func (m *MockRepository) ReplaceQuestionTimelocks(ctx context.Context, recipientID string, timelocks []*models.QuestionTimelock) error {
+	var filtered []*models.QuestionTimelock
+	for _, tl := range m.QuestionTimelocks {
+		if tl.RecipientID != recipientID {
+			filtered = append(filtered, tl)
+		}
+	}
+	for _, tl := range timelocks {
+		if tl.ID == "" {
+			tl.ID = generateID()
+		}
+		tl.RecipientID = recipientID
+		filtered = append(filtered, tl)
+	}
+	m.QuestionTimelocks = filtered
+	return nil
+}
+
+func (m *MockRepository) DeleteQuestionTimelock(ctx context.Context, id string) error {
+	for i, tl := range m.QuestionTimelocks {
+		if tl.ID == id {
+			m.QuestionTimelocks = append(m.QuestionTimelocks[:i], m.QuestionTimelocks[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// sortQuestionTimelocks orders copies like the SQLite repository does
+func sortQuestionTimelocks(timelocks []*models.QuestionTimelock) {
+	sort.SliceStable(timelocks, func(i, j int) bool {
+		if timelocks[i].RecipientID != timelocks[j].RecipientID {
+			return timelocks[i].RecipientID < timelocks[j].RecipientID
+		}
+		return timelocks[i].Round < timelocks[j].Round
+	})
+}
+
 // SecretAssignment methods
 func (m *MockRepository) CreateSecretAssignment(ctx context.Context, assignment *models.SecretAssignment) error {
+	if assignment.ID == "" {
+		assignment.ID = generateID()
+	}
 	m.SecretAssignments = append(m.SecretAssignments, assignment)
 	return nil
 }
@@ -235,6 +435,16 @@ func (m *MockRepository) ListSecretAssignmentsByUserID(ctx context.Context, user
 	return result, nil
 }
 
+func (m *MockRepository) UpdateSecretAssignment(ctx context.Context, assignment *models.SecretAssignment) error {
+	for i, a := range m.SecretAssignments {
+		if a.ID == assignment.ID {
+			m.SecretAssignments[i] = assignment
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
 func (m *MockRepository) DeleteSecretAssignment(ctx context.Context, id string) error {
 	for i, a := range m.SecretAssignments {
 		if a.ID == id {
@@ -406,6 +616,165 @@ func (m *MockRepository) ListDeliveryEventsByUserID(ctx context.Context, userID
 	return result, nil
 }
 
+func (m *MockRepository) ListDeliveryEventsByRunID(ctx context.Context, runID string) ([]*models.DeliveryEvent, error) {
+	var result []*models.DeliveryEvent
+	for _, e := range m.DeliveryEvents {
+		if e.RunID == runID {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) ListDueDeliveryEvents(ctx context.Context, now time.Time) ([]*models.DeliveryEvent, error) {
+	var result []*models.DeliveryEvent
+	for _, e := range m.DeliveryEvents {
+		if !e.IsQueued() {
+			continue
+		}
+		if e.NextAttemptAt == nil || !e.NextAttemptAt.After(now) {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// DeliveryRun methods
+func (m *MockRepository) CreateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error {
+	m.DeliveryRuns = append(m.DeliveryRuns, run)
+	return nil
+}
+
+func (m *MockRepository) GetActiveDeliveryRunByUserID(ctx context.Context, userID string) (*models.DeliveryRun, error) {
+	for _, r := range m.DeliveryRuns {
+		if r.UserID == userID && (r.Status == models.DeliveryRunStatusArmed || r.Status == models.DeliveryRunStatusInProgress) {
+			return r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) GetDeliveryRunByAbortCode(ctx context.Context, code string) (*models.DeliveryRun, error) {
+	for _, r := range m.DeliveryRuns {
+		if code != "" && r.AbortCode == code {
+			return r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) ListDeliveryRunsByStatus(ctx context.Context, status string) ([]*models.DeliveryRun, error) {
+	var result []*models.DeliveryRun
+	for _, r := range m.DeliveryRuns {
+		if r.Status == status {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) ListDeliveryRunsByUserID(ctx context.Context, userID string) ([]*models.DeliveryRun, error) {
+	var result []*models.DeliveryRun
+	for i := len(m.DeliveryRuns) - 1; i >= 0; i-- {
+		if m.DeliveryRuns[i].UserID == userID {
+			result = append(result, m.DeliveryRuns[i])
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) UpdateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error {
+	for i, r := range m.DeliveryRuns {
+		if r.ID == run.ID {
+			m.DeliveryRuns[i] = run
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *MockRepository) CompleteDeliveryRun(ctx context.Context, id string) error {
+	for _, r := range m.DeliveryRuns {
+		if r.ID == id {
+			now := time.Now().UTC()
+			r.Status = models.DeliveryRunStatusCompleted
+			r.CompletedAt = &now
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// EmergencyAccessRequest methods
+func (m *MockRepository) CreateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	m.EmergencyRequests = append(m.EmergencyRequests, request)
+	return nil
+}
+
+func (m *MockRepository) GetEmergencyAccessRequestByVerifyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error) {
+	for _, r := range m.EmergencyRequests {
+		if code != "" && r.VerifyCode == code {
+			return r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) GetEmergencyAccessRequestByDenyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error) {
+	for _, r := range m.EmergencyRequests {
+		if code != "" && r.DenyCode == code {
+			return r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) ListEmergencyAccessRequestsByUserID(ctx context.Context, userID string) ([]*models.EmergencyAccessRequest, error) {
+	var result []*models.EmergencyAccessRequest
+	for _, r := range m.EmergencyRequests {
+		if r.UserID == userID {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) ListDueEmergencyAccessRequests(ctx context.Context, now time.Time) ([]*models.EmergencyAccessRequest, error) {
+	var result []*models.EmergencyAccessRequest
+	for _, r := range m.EmergencyRequests {
+		if r.Status == models.EmergencyAccessStatusPending && r.ReleasesAt != nil && !r.ReleasesAt.After(now) {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) UpdateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	for i, r := range m.EmergencyRequests {
+		if r.ID == request.ID {
+			m.EmergencyRequests[i] = request
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// DeliveryAttempt methods
+func (m *MockRepository) CreateDeliveryAttempt(ctx context.Context, attempt *models.DeliveryAttempt) error {
+	m.DeliveryAttempts = append(m.DeliveryAttempts, attempt)
+	return nil
+}
+
+func (m *MockRepository) ListDeliveryAttemptsByEventID(ctx context.Context, eventID string) ([]*models.DeliveryAttempt, error) {
+	var result []*models.DeliveryAttempt
+	for _, a := range m.DeliveryAttempts {
+		if a.DeliveryEventID == eventID {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
 // AccessCode methods
 func (m *MockRepository) CreateAccessCode(ctx context.Context, code *models.AccessCode) error {
 	m.AccessCodes = append(m.AccessCodes, code)
@@ -421,24 +790,46 @@ func (m *MockRepository) GetAccessCodeByCode(ctx context.Context, code string) (
 	return nil, ErrNotFound
 }
 
-func (m *MockRepository) VerifyAccessCode(ctx context.Context, code string) (*models.AccessCode, error) {
-	// This is a simplified mock - just search for matching code
-	// Real implementation uses password hashing
+func (m *MockRepository) VerifyAccessCodeHash(ctx context.Context, codeHash string) (*models.AccessCode, error) {
 	for _, c := range m.AccessCodes {
-		if c.UsedAt == nil && time.Now().Before(c.ExpiresAt) {
-			// In mock, we'll just do a simple comparison
-			// Real implementation would verify hash
+		if c.Code == codeHash && time.Now().Before(c.ExpiresAt) {
+			if c.MaxAttempts > 0 && c.AttemptCount >= c.MaxAttempts {
+				return nil, ErrAccessCodeLocked
+			}
 			return c, nil
 		}
 	}
 	return nil, ErrNotFound
 }
 
+func (m *MockRepository) VerifyAccessCode(ctx context.Context, code string) (*models.AccessCode, error) {
+	// Only codes stored before HashAccessCode are checked against their Argon2 hash
+	for _, c := range m.AccessCodes {
+		if strings.HasPrefix(c.Code, crypto.AccessCodeHashPrefix) || !time.Now().Before(c.ExpiresAt) {
+			continue
+		}
+		storedHash, err := base64.StdEncoding.DecodeString(c.Code)
+		if err != nil {
+			continue
+		}
+		if matches, err := crypto.VerifyPassword(code, storedHash); err != nil || !matches {
+			continue
+		}
+		if c.MaxAttempts > 0 && c.AttemptCount >= c.MaxAttempts {
+			return nil, ErrAccessCodeLocked
+		}
+		return c, nil
+	}
+	return nil, ErrNotFound
+}
+
 func (m *MockRepository) MarkAccessCodeAsUsed(ctx context.Context, id string) error {
 	for _, c := range m.AccessCodes {
 		if c.ID == id {
-			now := time.Now()
-			c.UsedAt = &now
+			if c.UsedAt == nil {
+				now := time.Now()
+				c.UsedAt = &now
+			}
 			return nil
 		}
 	}
@@ -455,6 +846,28 @@ func (m *MockRepository) IncrementAccessCodeAttempts(ctx context.Context, id str
 	return ErrNotFound
 }
 
+func (m *MockRepository) ListAccessCodesByUserID(ctx context.Context, userID string) ([]*models.AccessCode, error) {
+	var result []*models.AccessCode
+	for _, c := range m.AccessCodes {
+		if c.UserID == userID {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) RevokeAccessCodesByUserID(ctx context.Context, userID string) (int, error) {
+	revoked := 0
+	now := time.Now()
+	for _, c := range m.AccessCodes {
+		if c.UserID == userID && c.ExpiresAt.After(now) {
+			c.ExpiresAt = now
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
 func (m *MockRepository) DeleteExpiredAccessCodes(ctx context.Context) error {
 	var filtered []*models.AccessCode
 	now := time.Now()
@@ -467,6 +880,89 @@ func (m *MockRepository) DeleteExpiredAccessCodes(ctx context.Context) error {
 	return nil
 }
 
+// ShareSubmission methods
+func (m *MockRepository) CreateShareSubmission(ctx context.Context, submission *models.ShareSubmission) error {
+	for i, s := range m.ShareSubmissions {
+		if s.SecretID == submission.SecretID && s.RecipientID == submission.RecipientID {
+			m.ShareSubmissions[i] = submission
+			return nil
+		}
+	}
+	m.ShareSubmissions = append(m.ShareSubmissions, submission)
+	return nil
+}
+
+func (m *MockRepository) ListShareSubmissionsBySecretID(ctx context.Context, secretID string) ([]*models.ShareSubmission, error) {
+	var result []*models.ShareSubmission
+	for _, s := range m.ShareSubmissions {
+		if s.SecretID == secretID {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) DeleteShareSubmissionsBySecretID(ctx context.Context, secretID string) error {
+	var filtered []*models.ShareSubmission
+	for _, s := range m.ShareSubmissions {
+		if s.SecretID != secretID {
+			filtered = append(filtered, s)
+		}
+	}
+	m.ShareSubmissions = filtered
+	return nil
+}
+
+// APIToken methods
+func (m *MockRepository) CreateAPIToken(ctx context.Context, token *models.APIToken) error {
+	if token.ID == "" {
+		token.ID = generateID()
+	}
+	m.APITokens = append(m.APITokens, token)
+	return nil
+}
+
+func (m *MockRepository) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	for _, t := range m.APITokens {
+		if t.TokenHash == tokenHash {
+			return t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) ListAPITokensByUserID(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	var result []*models.APIToken
+	for _, t := range m.APITokens {
+		if t.UserID == userID {
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockRepository) UpdateAPITokenLastUsed(ctx context.Context, id string) error {
+	for _, t := range m.APITokens {
+		if t.ID == id {
+			now := time.Now().UTC()
+			t.LastUsedAt = &now
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *MockRepository) RevokeAPIToken(ctx context.Context, id string) error {
+	for _, t := range m.APITokens {
+		if t.ID == id && t.RevokedAt == nil {
+			now := time.Now().UTC()
+			t.RevokedAt = &now
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
 // AuditLog methods
 func (m *MockRepository) CreateAuditLog(ctx context.Context, log *models.AuditLog) error {
 	m.AuditLogs = append(m.AuditLogs, log)
@@ -577,6 +1073,10 @@ func (t *MockTransaction) UpdateUser(ctx context.Context, user *models.User) err
 	return t.repo.UpdateUser(ctx, user)
 }
 
+func (t *MockTransaction) UpdateUserVaultKey(ctx context.Context, userID, salt, encryptedVaultKey string) error {
+	return t.repo.UpdateUserVaultKey(ctx, userID, salt, encryptedVaultKey)
+}
+
 func (t *MockTransaction) DeleteUser(ctx context.Context, id string) error {
 	return t.repo.DeleteUser(ctx, id)
 }
@@ -605,6 +1105,26 @@ func (t *MockTransaction) DeleteSecret(ctx context.Context, id string) error {
 	return t.repo.DeleteSecret(ctx, id)
 }
 
+func (t *MockTransaction) CreateSecretVersion(ctx context.Context, version *models.SecretVersion) error {
+	return t.repo.CreateSecretVersion(ctx, version)
+}
+
+func (t *MockTransaction) GetSecretVersionByID(ctx context.Context, id string) (*models.SecretVersion, error) {
+	return t.repo.GetSecretVersionByID(ctx, id)
+}
+
+func (t *MockTransaction) ListSecretVersionsBySecretID(ctx context.Context, secretID string) ([]*models.SecretVersion, error) {
+	return t.repo.ListSecretVersionsBySecretID(ctx, secretID)
+}
+
+func (t *MockTransaction) ListSecretVersionsByUserID(ctx context.Context, userID string) ([]*models.SecretVersion, error) {
+	return t.repo.ListSecretVersionsByUserID(ctx, userID)
+}
+
+func (t *MockTransaction) DeleteSecretVersion(ctx context.Context, id string) error {
+	return t.repo.DeleteSecretVersion(ctx, id)
+}
+
 func (t *MockTransaction) CreateRecipient(ctx context.Context, recipient *models.Recipient) error {
 	return t.repo.CreateRecipient(ctx, recipient)
 }
@@ -617,6 +1137,10 @@ func (t *MockTransaction) ListRecipientsByUserID(ctx context.Context, userID str
 	return t.repo.ListRecipientsByUserID(ctx, userID)
 }
 
+func (t *MockTransaction) ListRecipientsByEmail(ctx context.Context, email string) ([]*models.Recipient, error) {
+	return t.repo.ListRecipientsByEmail(ctx, email)
+}
+
 func (t *MockTransaction) UpdateRecipient(ctx context.Context, recipient *models.Recipient) error {
 	return t.repo.UpdateRecipient(ctx, recipient)
 }
@@ -625,6 +1149,30 @@ func (t *MockTransaction) DeleteRecipient(ctx context.Context, id string) error
 	return t.repo.DeleteRecipient(ctx, id)
 }
 
+func (t *MockTransaction) ListRecipientQuestions(ctx context.Context, recipientID string) ([]*models.RecipientQuestion, error) {
+	return t.repo.ListRecipientQuestions(ctx, recipientID)
+}
+
+func (t *MockTransaction) ReplaceRecipientQuestions(ctx context.Context, recipientID string, questions []*models.RecipientQuestion) error {
+	return t.repo.ReplaceRecipientQuestions(ctx, recipientID, questions)
+}
+
+func (t *MockTransaction) ListQuestionTimelocks(ctx context.Context, recipientID string) ([]*models.QuestionTimelock, error) {
+	return t.repo.ListQuestionTimelocks(ctx, recipientID)
+}
+
+func (t *MockTransaction) ListQuestionTimelocksByUserID(ctx context.Context, userID string) ([]*models.QuestionTimelock, error) {
+	return t.repo.ListQuestionTimelocksByUserID(ctx, userID)
+}
+
+func (t *MockTransaction) ReplaceQuestionTimelocks(ctx context.Context, recipientID string, timelocks []*models.QuestionTimelock) error {
+	return t.repo.ReplaceQuestionTimelocks(ctx, recipientID, timelocks)
+}
+
+func (t *MockTransaction) DeleteQuestionTimelock(ctx context.Context, id string) error {
+	return t.repo.DeleteQuestionTimelock(ctx, id)
+}
+
 func (t *MockTransaction) CreateSecretAssignment(ctx context.Context, assignment *models.SecretAssignment) error {
 	return t.repo.CreateSecretAssignment(ctx, assignment)
 }
@@ -645,6 +1193,10 @@ func (t *MockTransaction) ListSecretAssignmentsByUserID(ctx context.Context, use
 	return t.repo.ListSecretAssignmentsByUserID(ctx, userID)
 }
 
+func (t *MockTransaction) UpdateSecretAssignment(ctx context.Context, assignment *models.SecretAssignment) error {
+	return t.repo.UpdateSecretAssignment(ctx, assignment)
+}
+
 func (t *MockTransaction) DeleteSecretAssignment(ctx context.Context, id string) error {
 	return t.repo.DeleteSecretAssignment(ctx, id)
 }
@@ -689,6 +1241,74 @@ func (t *MockTransaction) ListDeliveryEventsByUserID(ctx context.Context, userID
 	return t.repo.ListDeliveryEventsByUserID(ctx, userID)
 }
 
+func (t *MockTransaction) ListDeliveryEventsByRunID(ctx context.Context, runID string) ([]*models.DeliveryEvent, error) {
+	return t.repo.ListDeliveryEventsByRunID(ctx, runID)
+}
+
+func (t *MockTransaction) CreateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error {
+	return t.repo.CreateDeliveryRun(ctx, run)
+}
+
+func (t *MockTransaction) GetActiveDeliveryRunByUserID(ctx context.Context, userID string) (*models.DeliveryRun, error) {
+	return t.repo.GetActiveDeliveryRunByUserID(ctx, userID)
+}
+
+func (t *MockTransaction) GetDeliveryRunByAbortCode(ctx context.Context, code string) (*models.DeliveryRun, error) {
+	return t.repo.GetDeliveryRunByAbortCode(ctx, code)
+}
+
+func (t *MockTransaction) ListDeliveryRunsByStatus(ctx context.Context, status string) ([]*models.DeliveryRun, error) {
+	return t.repo.ListDeliveryRunsByStatus(ctx, status)
+}
+
+func (t *MockTransaction) ListDeliveryRunsByUserID(ctx context.Context, userID string) ([]*models.DeliveryRun, error) {
+	return t.repo.ListDeliveryRunsByUserID(ctx, userID)
+}
+
+func (t *MockTransaction) UpdateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error {
+	return t.repo.UpdateDeliveryRun(ctx, run)
+}
+
+func (t *MockTransaction) CompleteDeliveryRun(ctx context.Context, id string) error {
+	return t.repo.CompleteDeliveryRun(ctx, id)
+}
+
+func (t *MockTransaction) ListDueDeliveryEvents(ctx context.Context, now time.Time) ([]*models.DeliveryEvent, error) {
+	return t.repo.ListDueDeliveryEvents(ctx, now)
+}
+
+func (t *MockTransaction) CreateDeliveryAttempt(ctx context.Context, attempt *models.DeliveryAttempt) error {
+	return t.repo.CreateDeliveryAttempt(ctx, attempt)
+}
+
+func (t *MockTransaction) ListDeliveryAttemptsByEventID(ctx context.Context, eventID string) ([]*models.DeliveryAttempt, error) {
+	return t.repo.ListDeliveryAttemptsByEventID(ctx, eventID)
+}
+
+func (t *MockTransaction) CreateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	return t.repo.CreateEmergencyAccessRequest(ctx, request)
+}
+
+func (t *MockTransaction) GetEmergencyAccessRequestByVerifyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error) {
+	return t.repo.GetEmergencyAccessRequestByVerifyCode(ctx, code)
+}
+
+func (t *MockTransaction) GetEmergencyAccessRequestByDenyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error) {
+	return t.repo.GetEmergencyAccessRequestByDenyCode(ctx, code)
+}
+
+func (t *MockTransaction) ListEmergencyAccessRequestsByUserID(ctx context.Context, userID string) ([]*models.EmergencyAccessRequest, error) {
+	return t.repo.ListEmergencyAccessRequestsByUserID(ctx, userID)
+}
+
+func (t *MockTransaction) ListDueEmergencyAccessRequests(ctx context.Context, now time.Time) ([]*models.EmergencyAccessRequest, error) {
+	return t.repo.ListDueEmergencyAccessRequests(ctx, now)
+}
+
+func (t *MockTransaction) UpdateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	return t.repo.UpdateEmergencyAccessRequest(ctx, request)
+}
+
 func (t *MockTransaction) CreateAuditLog(ctx context.Context, log *models.AuditLog) error {
 	return t.repo.CreateAuditLog(ctx, log)
 }
@@ -765,6 +1385,10 @@ func (t *MockTransaction) GetAccessCodeByCode(ctx context.Context, code string)
 	return t.repo.GetAccessCodeByCode(ctx, code)
 }
 
+func (t *MockTransaction) VerifyAccessCodeHash(ctx context.Context, codeHash string) (*models.AccessCode, error) {
+	return t.repo.VerifyAccessCodeHash(ctx, codeHash)
+}
+
 func (t *MockTransaction) VerifyAccessCode(ctx context.Context, code string) (*models.AccessCode, error) {
 	return t.repo.VerifyAccessCode(ctx, code)
 }
@@ -777,6 +1401,46 @@ func (t *MockTransaction) IncrementAccessCodeAttempts(ctx context.Context, id st
 	return t.repo.IncrementAccessCodeAttempts(ctx, id)
 }
 
+func (t *MockTransaction) ListAccessCodesByUserID(ctx context.Context, userID string) ([]*models.AccessCode, error) {
+	return t.repo.ListAccessCodesByUserID(ctx, userID)
+}
+
+func (t *MockTransaction) RevokeAccessCodesByUserID(ctx context.Context, userID string) (int, error) {
+	return t.repo.RevokeAccessCodesByUserID(ctx, userID)
+}
+
 func (t *MockTransaction) DeleteExpiredAccessCodes(ctx context.Context) error {
 	return t.repo.DeleteExpiredAccessCodes(ctx)
 }
+
+func (t *MockTransaction) CreateShareSubmission(ctx context.Context, submission *models.ShareSubmission) error {
+	return t.repo.CreateShareSubmission(ctx, submission)
+}
+
+func (t *MockTransaction) ListShareSubmissionsBySecretID(ctx context.Context, secretID string) ([]*models.ShareSubmission, error) {
+	return t.repo.ListShareSubmissionsBySecretID(ctx, secretID)
+}
+
+func (t *MockTransaction) DeleteShareSubmissionsBySecretID(ctx context.Context, secretID string) error {
+	return t.repo.DeleteShareSubmissionsBySecretID(ctx, secretID)
+}
+
+func (t *MockTransaction) CreateAPIToken(ctx context.Context, token *models.APIToken) error {
+	return t.repo.CreateAPIToken(ctx, token)
+}
+
+func (t *MockTransaction) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	return t.repo.GetAPITokenByHash(ctx, tokenHash)
+}
+
+func (t *MockTransaction) ListAPITokensByUserID(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	return t.repo.ListAPITokensByUserID(ctx, userID)
+}
+
+func (t *MockTransaction) UpdateAPITokenLastUsed(ctx context.Context, id string) error {
+	return t.repo.UpdateAPITokenLastUsed(ctx, id)
+}
+
+func (t *MockTransaction) RevokeAPIToken(ctx context.Context, id string) error {
+	return t.repo.RevokeAPIToken(ctx, id)
+}
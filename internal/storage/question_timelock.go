@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// ListQuestionTimelocks lists the timelocked question copies of a recipient, earliest round first
+func (r *SQLiteRepository) ListQuestionTimelocks(ctx context.Context, recipientID string) ([]*models.QuestionTimelock, error) {
+	return r.listQuestionTimelocks(ctx, "recipient_id", recipientID)
+}
+
+// ListQuestionTimelocksByUserID lists the timelocked question copies of all
+// recipients of a user, grouped by recipient with the earliest round first
+func (r *SQLiteRepository) ListQuestionTimelocksByUserID(ctx context.Context, userID string) ([]*models.QuestionTimelock, error) {
+	return r.listQuestionTimelocks(ctx, "user_id", userID)
+}
+
+func (r *SQLiteRepository) listQuestionTimelocks(ctx context.Context, column, value string) ([]*models.QuestionTimelock, error) {
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, recipient_id, user_id, round, opens_at, data, created_at
+		FROM question_timelocks
+		WHERE %s = ?
+		ORDER BY recipient_id, round
+	`, column), value)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query question timelocks: %w", err)
+	}
+	defer rows.Close()
+
+	var timelocks []*models.QuestionTimelock
+	for rows.Next() {
+		timelock := &models.QuestionTimelock{}
+		if err := rows.Scan(
+			&timelock.ID, &timelock.RecipientID, &timelock.UserID, &timelock.Round,
+			&timelock.OpensAt, &timelock.Data, &timelock.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan question timelock: %w", err)
+		}
+		timelocks = append(timelocks, timelock)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating question timelocks: %w", err)
+	}
+
+	return timelocks, nil
+}
+
+// ReplaceQuestionTimelocks replaces all timelocked question copies of a
+// recipient. An empty list removes them.
+func (r *SQLiteRepository) ReplaceQuestionTimelocks(ctx context.Context, recipientID string, timelocks []*models.QuestionTimelock) error {
+	return r.inTx(ctx, func(tx dbConn) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM question_timelocks WHERE recipient_id = ?", recipientID); err != nil {
+			return fmt.Errorf("failed to delete question timelocks: %w", err)
+		}
+
+		now := time.Now().UTC()
+		for _, timelock := range timelocks {
+			if timelock.ID == "" {
+				timelock.ID = generateID()
+			}
+			timelock.RecipientID = recipientID
+			timelock.CreatedAt = now
+
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO question_timelocks (
+					id, recipient_id, user_id, round, opens_at, data, created_at
+				) VALUES (?, ?, ?, ?, ?, ?, ?)
+			`,
+				timelock.ID, timelock.RecipientID, timelock.UserID, timelock.Round,
+				timelock.OpensAt, timelock.Data, timelock.CreatedAt,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to create question timelock: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteQuestionTimelock deletes a timelocked question copy
+func (r *SQLiteRepository) DeleteQuestionTimelock(ctx context.Context, id string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM question_timelocks WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete question timelock: %w", err)
+	}
+	return nil
+}
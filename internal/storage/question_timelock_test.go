@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+func TestQuestionTimelockOperations(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test@example.com")
+	recipient := createTestRecipient(t, repo, user.ID, "recipient@example.com")
+	other := createTestRecipient(t, repo, user.ID, "other@example.com")
+
+	// The vault copy of the questions is stored with the recipient
+	recipient.QuestionBundle = "vault_bundle"
+	if err := repo.UpdateRecipient(ctx, recipient); err != nil {
+		t.Fatalf("Failed to update recipient: %v", err)
+	}
+	retrieved, err := repo.GetRecipientByID(ctx, recipient.ID)
+	if err != nil {
+		t.Fatalf("Failed to get recipient: %v", err)
+	}
+	if retrieved.QuestionBundle != "vault_bundle" {
+		t.Errorf("Expected question bundle vault_bundle, got %s", retrieved.QuestionBundle)
+	}
+
+	// Test ReplaceQuestionTimelocks, rounds come back in order
+	opensAt := time.Now().UTC().Add(14 * 24 * time.Hour).Truncate(time.Second)
+	if err := repo.ReplaceQuestionTimelocks(ctx, recipient.ID, []*models.QuestionTimelock{
+		{UserID: user.ID, Round: 29000002, OpensAt: opensAt.Add(24 * time.Hour), Data: "copy_2"},
+		{UserID: user.ID, Round: 29000001, OpensAt: opensAt, Data: "copy_1"},
+	}); err != nil {
+		t.Fatalf("Failed to replace question timelocks: %v", err)
+	}
+	if err := repo.ReplaceQuestionTimelocks(ctx, other.ID, []*models.QuestionTimelock{
+		{UserID: user.ID, Round: 29000005, OpensAt: opensAt, Data: "other_copy"},
+	}); err != nil {
+		t.Fatalf("Failed to replace question timelocks: %v", err)
+	}
+
+	// Test ListQuestionTimelocks
+	timelocks, err := repo.ListQuestionTimelocks(ctx, recipient.ID)
+	if err != nil {
+		t.Fatalf("Failed to list question timelocks: %v", err)
+	}
+	if len(timelocks) != 2 {
+		t.Fatalf("Expected 2 timelocked copies, got %d", len(timelocks))
+	}
+	if timelocks[0].Round != 29000001 || timelocks[0].Data != "copy_1" || !timelocks[0].OpensAt.Equal(opensAt) {
+		t.Errorf("Unexpected first copy: %+v", timelocks[0])
+	}
+
+	// Test ListQuestionTimelocksByUserID
+	all, err := repo.ListQuestionTimelocksByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list question timelocks: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("Expected 3 timelocked copies for the user, got %d", len(all))
+	}
+
+	// Test DeleteQuestionTimelock
+	if err := repo.DeleteQuestionTimelock(ctx, timelocks[0].ID); err != nil {
+		t.Fatalf("Failed to delete question timelock: %v", err)
+	}
+	timelocks, err = repo.ListQuestionTimelocks(ctx, recipient.ID)
+	if err != nil {
+		t.Fatalf("Failed to list question timelocks: %v", err)
+	}
+	if len(timelocks) != 1 || timelocks[0].Data != "copy_2" {
+		t.Errorf("Expected only the second copy to be left, got %d copies", len(timelocks))
+	}
+
+	// Deleting the recipient deletes their copies
+	if err := repo.DeleteRecipient(ctx, recipient.ID); err != nil {
+		t.Fatalf("Failed to delete recipient: %v", err)
+	}
+	all, err = repo.ListQuestionTimelocksByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list question timelocks: %v", err)
+	}
+	if len(all) != 1 || all[0].RecipientID != other.ID {
+		t.Errorf("Expected only the other recipient's copy to be left, got %d copies", len(all))
+	}
+}
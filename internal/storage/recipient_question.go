@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// ListRecipientQuestions lists the personal questions of a recipient in the order they are asked
+func (r *SQLiteRepository) ListRecipientQuestions(ctx context.Context, recipientID string) ([]*models.RecipientQuestion, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, recipient_id, user_id, position, question, locked_share, created_at
+		FROM recipient_questions
+		WHERE recipient_id = ?
+		ORDER BY position
+	`, recipientID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recipient questions: %w", err)
+	}
+	defer rows.Close()
+
+	var questions []*models.RecipientQuestion
+	for rows.Next() {
+		question := &models.RecipientQuestion{}
+		if err := rows.Scan(
+			&question.ID, &question.RecipientID, &question.UserID, &question.Position,
+			&question.Question, &question.LockedShare, &question.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan recipient question: %w", err)
+		}
+		questions = append(questions, question)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recipient questions: %w", err)
+	}
+
+	return questions, nil
+}
+
+// ReplaceRecipientQuestions replaces all questions of a recipient. The shares
+// of the old questions belong to a key that is no longer used, so they are
+// never kept alongside new ones. An empty list removes the questions.
+func (r *SQLiteRepository) ReplaceRecipientQuestions(ctx context.Context, recipientID string, questions []*models.RecipientQuestion) error {
+	return r.inTx(ctx, func(tx dbConn) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM recipient_questions WHERE recipient_id = ?", recipientID); err != nil {
+			return fmt.Errorf("failed to delete recipient questions: %w", err)
+		}
+
+		now := time.Now().UTC()
+		for i, question := range questions {
+			if question.ID == "" {
+				question.ID = generateID()
+			}
+			question.RecipientID = recipientID
+			question.Position = i + 1
+			question.CreatedAt = now
+
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO recipient_questions (
+					id, recipient_id, user_id, position, question, locked_share, created_at
+				) VALUES (?, ?, ?, ?, ?, ?, ?)
+			`,
+				question.ID, question.RecipientID, question.UserID, question.Position,
+				question.Question, question.LockedShare, question.CreatedAt,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to create recipient question: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
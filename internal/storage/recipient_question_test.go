@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+func TestRecipientQuestionOperations(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test@example.com")
+	recipient := createTestRecipient(t, repo, user.ID, "recipient@example.com")
+	other := createTestRecipient(t, repo, user.ID, "other@example.com")
+
+	// The question fields of the recipient are stored
+	recipient.QuestionThreshold = 2
+	recipient.QuestionKey = "age1questionkey"
+	if err := repo.UpdateRecipient(ctx, recipient); err != nil {
+		t.Fatalf("Failed to update recipient: %v", err)
+	}
+
+	retrieved, err := repo.GetRecipientByID(ctx, recipient.ID)
+	if err != nil {
+		t.Fatalf("Failed to get recipient: %v", err)
+	}
+	if retrieved.QuestionThreshold != 2 || retrieved.QuestionKey != "age1questionkey" {
+		t.Errorf("Expected question threshold 2 and key age1questionkey, got %d and %s", retrieved.QuestionThreshold, retrieved.QuestionKey)
+	}
+	if !retrieved.HasQuestions() {
+		t.Error("Expected the recipient to have questions")
+	}
+
+	// Test ReplaceRecipientQuestions
+	questions := []*models.RecipientQuestion{
+		{UserID: user.ID, Question: "Where did we meet?", LockedShare: "share_1"},
+		{UserID: user.ID, Question: "Name of our first dog?", LockedShare: "share_2"},
+		{UserID: user.ID, Question: "Favourite colour?", LockedShare: "share_3"},
+	}
+	if err := repo.ReplaceRecipientQuestions(ctx, recipient.ID, questions); err != nil {
+		t.Fatalf("Failed to replace recipient questions: %v", err)
+	}
+	if err := repo.ReplaceRecipientQuestions(ctx, other.ID, []*models.RecipientQuestion{
+		{UserID: user.ID, Question: "Other question", LockedShare: "other_share"},
+	}); err != nil {
+		t.Fatalf("Failed to replace recipient questions: %v", err)
+	}
+
+	// Test ListRecipientQuestions
+	stored, err := repo.ListRecipientQuestions(ctx, recipient.ID)
+	if err != nil {
+		t.Fatalf("Failed to list recipient questions: %v", err)
+	}
+	if len(stored) != 3 {
+		t.Fatalf("Expected 3 questions, got %d", len(stored))
+	}
+	for i, question := range stored {
+		if question.Position != i+1 || question.Question != questions[i].Question || question.LockedShare != questions[i].LockedShare {
+			t.Errorf("Unexpected question at position %d: %+v", i+1, question)
+		}
+	}
+
+	// Replacing drops the old questions
+	if err := repo.ReplaceRecipientQuestions(ctx, recipient.ID, []*models.RecipientQuestion{
+		{UserID: user.ID, Question: "New question", LockedShare: "new_share"},
+	}); err != nil {
+		t.Fatalf("Failed to replace recipient questions: %v", err)
+	}
+	stored, err = repo.ListRecipientQuestions(ctx, recipient.ID)
+	if err != nil {
+		t.Fatalf("Failed to list recipient questions: %v", err)
+	}
+	if len(stored) != 1 || stored[0].Question != "New question" {
+		t.Errorf("Expected only the new question, got %d questions", len(stored))
+	}
+
+	// Deleting the recipient deletes their questions
+	if err := repo.DeleteRecipient(ctx, recipient.ID); err != nil {
+		t.Fatalf("Failed to delete recipient: %v", err)
+	}
+	stored, err = repo.ListRecipientQuestions(ctx, recipient.ID)
+	if err != nil {
+		t.Fatalf("Failed to list recipient questions: %v", err)
+	}
+	if len(stored) != 0 {
+		t.Errorf("Expected the questions to be deleted with the recipient, got %d", len(stored))
+	}
+
+	// The other recipient keeps theirs
+	stored, err = repo.ListRecipientQuestions(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("Failed to list recipient questions: %v", err)
+	}
+	if len(stored) != 1 {
+		t.Errorf("Expected 1 question for the other recipient, got %d", len(stored))
+	}
+}
@@ -98,6 +98,8 @@ func TestSQLiteRepository_RecipientOperations(t *testing.T) {
 	recipient.Name = "Updated Recipient"
 	recipient.IsConfirmed = true
 	recipient.ConfirmedAt = &confirmedAt
+	recipient.ReleaseDelayDays = 30
+	recipient.PublicKey = "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"
 	err = repo.UpdateRecipient(ctx, recipient)
 	if err != nil {
 		t.Fatalf("Failed to update recipient: %v", err)
@@ -114,6 +116,12 @@ func TestSQLiteRepository_RecipientOperations(t *testing.T) {
 	if !retrievedRecipient.IsConfirmed {
 		t.Errorf("Expected IsConfirmed to be true")
 	}
+	if retrievedRecipient.ReleaseDelayDays != 30 {
+		t.Errorf("Expected a release delay of 30 days, got %d", retrievedRecipient.ReleaseDelayDays)
+	}
+	if retrievedRecipient.PublicKey != recipient.PublicKey {
+		t.Errorf("Expected public key %s, got %q", recipient.PublicKey, retrievedRecipient.PublicKey)
+	}
 	if retrievedRecipient.ConfirmedAt == nil {
 		t.Errorf("Expected non-nil ConfirmedAt")
 	} else if recipient.ConfirmedAt == nil {
@@ -247,6 +255,21 @@ func TestSQLiteRepository_SecretAssignmentOperations(t *testing.T) {
 		t.Errorf("Expected 1 secret assignment, got %d", len(userAssignments))
 	}
 
+	// Test UpdateSecretAssignment
+	assignment.DeliveryData = "sealed_share"
+	err = repo.UpdateSecretAssignment(ctx, assignment)
+	if err != nil {
+		t.Fatalf("Failed to update secret assignment: %v", err)
+	}
+
+	retrievedAssignment, err = repo.GetSecretAssignmentByID(ctx, assignment.ID)
+	if err != nil {
+		t.Fatalf("Failed to get updated secret assignment: %v", err)
+	}
+	if retrievedAssignment.DeliveryData != "sealed_share" {
+		t.Errorf("Expected delivery data %s, got %s", "sealed_share", retrievedAssignment.DeliveryData)
+	}
+
 	// Test DeleteSecretAssignment
 	err = repo.DeleteSecretAssignment(ctx, assignment.ID)
 	if err != nil {
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+const secretVersionColumns = `id, secret_id, user_id, name, encrypted_data, encryption_type,
+		file_ref, file_size, saved_at, created_at`
+
+// CreateSecretVersion keeps an earlier revision of a secret
+func (r *SQLiteRepository) CreateSecretVersion(ctx context.Context, version *models.SecretVersion) error {
+	if version.ID == "" {
+		version.ID = generateID()
+	}
+
+	version.CreatedAt = time.Now().UTC()
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO secret_versions (`+secretVersionColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		version.ID, version.SecretID, version.UserID, version.Name, version.EncryptedData,
+		version.EncryptionType, version.FileRef, version.FileSize, version.SavedAt, version.CreatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create secret version: %w", err)
+	}
+
+	return nil
+}
+
+// GetSecretVersionByID retrieves an earlier revision of a secret
+func (r *SQLiteRepository) GetSecretVersionByID(ctx context.Context, id string) (*models.SecretVersion, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT `+secretVersionColumns+`
+		FROM secret_versions
+		WHERE id = ?
+	`, id)
+
+	version, err := scanSecretVersion(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret version: %w", err)
+	}
+
+	return version, nil
+}
+
+// ListSecretVersionsBySecretID lists the earlier revisions of a secret, newest first
+func (r *SQLiteRepository) ListSecretVersionsBySecretID(ctx context.Context, secretID string) ([]*models.SecretVersion, error) {
+	return r.listSecretVersions(ctx, "secret_id", secretID)
+}
+
+// ListSecretVersionsByUserID lists the earlier revisions of all secrets of a user, newest first
+func (r *SQLiteRepository) ListSecretVersionsByUserID(ctx context.Context, userID string) ([]*models.SecretVersion, error) {
+	return r.listSecretVersions(ctx, "user_id", userID)
+}
+
+func (r *SQLiteRepository) listSecretVersions(ctx context.Context, column, value string) ([]*models.SecretVersion, error) {
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT `+secretVersionColumns+`
+		FROM secret_versions
+		WHERE %s = ?
+		ORDER BY created_at DESC, rowid DESC
+	`, column), value)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query secret versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*models.SecretVersion
+	for rows.Next() {
+		version, err := scanSecretVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan secret version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating secret versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// DeleteSecretVersion deletes an earlier revision of a secret
+func (r *SQLiteRepository) DeleteSecretVersion(ctx context.Context, id string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM secret_versions WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete secret version: %w", err)
+	}
+	return nil
+}
+
+// secretVersionScanner is implemented by both *sql.Row and *sql.Rows
+type secretVersionScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSecretVersion scans a secret_versions row into a model
+func scanSecretVersion(row secretVersionScanner) (*models.SecretVersion, error) {
+	version := &models.SecretVersion{}
+	if err := row.Scan(
+		&version.ID, &version.SecretID, &version.UserID, &version.Name, &version.EncryptedData,
+		&version.EncryptionType, &version.FileRef, &version.FileSize, &version.SavedAt, &version.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
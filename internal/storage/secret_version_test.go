@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+func TestSecretVersionOperations(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test@example.com")
+
+	secret := &models.Secret{
+		UserID:         user.ID,
+		Name:           "Seed phrase",
+		EncryptedData:  "encrypted_data",
+		EncryptionType: models.EncryptionTypeVault,
+	}
+	if err := repo.CreateSecret(ctx, secret); err != nil {
+		t.Fatalf("Failed to create secret: %v", err)
+	}
+
+	// Test CreateSecretVersion
+	savedAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	first := &models.SecretVersion{
+		SecretID:       secret.ID,
+		UserID:         user.ID,
+		Name:           "Seed phrase",
+		EncryptedData:  "revision_1",
+		EncryptionType: models.EncryptionTypeVault,
+		SavedAt:        savedAt,
+	}
+	if err := repo.CreateSecretVersion(ctx, first); err != nil {
+		t.Fatalf("Failed to create secret version: %v", err)
+	}
+	if first.ID == "" {
+		t.Fatal("Secret version ID was not generated")
+	}
+
+	second := &models.SecretVersion{
+		SecretID:       secret.ID,
+		UserID:         user.ID,
+		Name:           "Seed phrase",
+		EncryptedData:  "revision_2",
+		EncryptionType: models.EncryptionTypeVault,
+		FileRef:        "file_ref",
+		FileSize:       42,
+		SavedAt:        savedAt.Add(time.Minute),
+	}
+	if err := repo.CreateSecretVersion(ctx, second); err != nil {
+		t.Fatalf("Failed to create second secret version: %v", err)
+	}
+
+	// Test GetSecretVersionByID
+	retrieved, err := repo.GetSecretVersionByID(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("Failed to get secret version: %v", err)
+	}
+	if retrieved.EncryptedData != "revision_1" || retrieved.SecretID != secret.ID || !retrieved.SavedAt.Equal(savedAt) {
+		t.Errorf("Unexpected secret version %+v", retrieved)
+	}
+	if _, err := repo.GetSecretVersionByID(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing version, got %v", err)
+	}
+
+	// Test ListSecretVersionsBySecretID, newest first
+	versions, err := repo.ListSecretVersionsBySecretID(ctx, secret.ID)
+	if err != nil {
+		t.Fatalf("Failed to list secret versions: %v", err)
+	}
+	if len(versions) != 2 || versions[0].ID != second.ID || versions[1].ID != first.ID {
+		t.Fatalf("Expected both versions newest first, got %d", len(versions))
+	}
+	if versions[0].FileRef != "file_ref" || versions[0].FileSize != 42 {
+		t.Errorf("Expected the file fields to be stored, got %s and %d", versions[0].FileRef, versions[0].FileSize)
+	}
+
+	// Test ListSecretVersionsByUserID
+	versions, err = repo.ListSecretVersionsByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list secret versions of user: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Errorf("Expected 2 versions of the user, got %d", len(versions))
+	}
+
+	// Test DeleteSecretVersion
+	if err := repo.DeleteSecretVersion(ctx, first.ID); err != nil {
+		t.Fatalf("Failed to delete secret version: %v", err)
+	}
+	versions, _ = repo.ListSecretVersionsBySecretID(ctx, secret.ID)
+	if len(versions) != 1 {
+		t.Errorf("Expected 1 version after delete, got %d", len(versions))
+	}
+
+	// Deleting the secret deletes its versions
+	if err := repo.DeleteSecret(ctx, secret.ID); err != nil {
+		t.Fatalf("Failed to delete secret: %v", err)
+	}
+	versions, _ = repo.ListSecretVersionsBySecretID(ctx, secret.ID)
+	if len(versions) != 0 {
+		t.Errorf("Expected versions to be deleted with the secret, got %d", len(versions))
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// CreateShareSubmission records a quorum share submitted by a recipient.
+// A recipient can only hold one submission per secret; resubmitting replaces it.
+func (r *SQLiteRepository) CreateShareSubmission(ctx context.Context, submission *models.ShareSubmission) error {
+	if submission.ID == "" {
+		submission.ID = generateID()
+	}
+
+	submission.SubmittedAt = time.Now().UTC()
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO share_submissions (
+			id, secret_id, recipient_id, share_index, sealed_share, submitted_at
+		) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (secret_id, recipient_id) DO UPDATE SET
+			share_index = excluded.share_index,
+			sealed_share = excluded.sealed_share,
+			submitted_at = excluded.submitted_at
+	`,
+		submission.ID, submission.SecretID, submission.RecipientID,
+		submission.ShareIndex, submission.SealedShare, submission.SubmittedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create share submission: %w", err)
+	}
+
+	return nil
+}
+
+// ListShareSubmissionsBySecretID lists all share submissions for a secret
+func (r *SQLiteRepository) ListShareSubmissionsBySecretID(ctx context.Context, secretID string) ([]*models.ShareSubmission, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, secret_id, recipient_id, share_index, sealed_share, submitted_at
+		FROM share_submissions
+		WHERE secret_id = ?
+		ORDER BY submitted_at
+	`, secretID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query share submissions: %w", err)
+	}
+	defer rows.Close()
+
+	var submissions []*models.ShareSubmission
+	for rows.Next() {
+		submission := &models.ShareSubmission{}
+		if err := rows.Scan(
+			&submission.ID, &submission.SecretID, &submission.RecipientID,
+			&submission.ShareIndex, &submission.SealedShare, &submission.SubmittedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan share submission: %w", err)
+		}
+		submissions = append(submissions, submission)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating share submissions: %w", err)
+	}
+
+	return submissions, nil
+}
+
+// DeleteShareSubmissionsBySecretID deletes all share submissions for a secret
+func (r *SQLiteRepository) DeleteShareSubmissionsBySecretID(ctx context.Context, secretID string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM share_submissions WHERE secret_id = ?", secretID)
+	if err != nil {
+		return fmt.Errorf("failed to delete share submissions: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+func TestShareSubmissionOperations(t *testing.T) {
+	repo, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := createTestUser(t, repo, "test@example.com")
+	recipient1 := createTestRecipient(t, repo, user.ID, "first@example.com")
+	recipient2 := createTestRecipient(t, repo, user.ID, "second@example.com")
+
+	// Create a quorum protected secret
+	secret := &models.Secret{
+		UserID:          user.ID,
+		Name:            "Seed phrase",
+		EncryptedData:   "encrypted_data",
+		EncryptionType:  models.EncryptionTypeVault,
+		QuorumThreshold: 2,
+		QuorumData:      "quorum_data",
+	}
+	if err := repo.CreateSecret(ctx, secret); err != nil {
+		t.Fatalf("Failed to create secret: %v", err)
+	}
+
+	// Check that the quorum fields are stored
+	retrievedSecret, err := repo.GetSecretByID(ctx, secret.ID)
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if retrievedSecret.QuorumThreshold != 2 {
+		t.Errorf("Expected quorum threshold 2, got %d", retrievedSecret.QuorumThreshold)
+	}
+	if retrievedSecret.QuorumData != "quorum_data" {
+		t.Errorf("Expected quorum data %s, got %s", "quorum_data", retrievedSecret.QuorumData)
+	}
+
+	// Test CreateShareSubmission
+	submission := &models.ShareSubmission{
+		SecretID:    secret.ID,
+		RecipientID: recipient1.ID,
+		ShareIndex:  1,
+		SealedShare: "sealed_1",
+	}
+	if err := repo.CreateShareSubmission(ctx, submission); err != nil {
+		t.Fatalf("Failed to create share submission: %v", err)
+	}
+	if submission.ID == "" {
+		t.Fatal("Share submission ID was not generated")
+	}
+
+	// Resubmitting replaces the earlier submission of the same recipient
+	resubmission := &models.ShareSubmission{
+		SecretID:    secret.ID,
+		RecipientID: recipient1.ID,
+		ShareIndex:  1,
+		SealedShare: "sealed_1_again",
+	}
+	if err := repo.CreateShareSubmission(ctx, resubmission); err != nil {
+		t.Fatalf("Failed to resubmit share: %v", err)
+	}
+
+	if err := repo.CreateShareSubmission(ctx, &models.ShareSubmission{
+		SecretID:    secret.ID,
+		RecipientID: recipient2.ID,
+		ShareIndex:  2,
+		SealedShare: "sealed_2",
+	}); err != nil {
+		t.Fatalf("Failed to create second share submission: %v", err)
+	}
+
+	// Test ListShareSubmissionsBySecretID
+	submissions, err := repo.ListShareSubmissionsBySecretID(ctx, secret.ID)
+	if err != nil {
+		t.Fatalf("Failed to list share submissions: %v", err)
+	}
+	if len(submissions) != 2 {
+		t.Fatalf("Expected 2 share submissions, got %d", len(submissions))
+	}
+	for _, s := range submissions {
+		if s.RecipientID == recipient1.ID && s.SealedShare != "sealed_1_again" {
+			t.Errorf("Expected resubmitted share, got %s", s.SealedShare)
+		}
+	}
+
+	// Test DeleteShareSubmissionsBySecretID
+	if err := repo.DeleteShareSubmissionsBySecretID(ctx, secret.ID); err != nil {
+		t.Fatalf("Failed to delete share submissions: %v", err)
+	}
+
+	submissions, err = repo.ListShareSubmissionsBySecretID(ctx, secret.ID)
+	if err != nil {
+		t.Fatalf("Failed to list share submissions: %v", err)
+	}
+	if len(submissions) != 0 {
+		t.Errorf("Expected 0 share submissions after delete, got %d", len(submissions))
+	}
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -17,7 +18,15 @@ import (
 
 // SQLiteRepository implements the Repository interface using SQLite
 type SQLiteRepository struct {
-	db *sql.DB
+	db    dbConn  // Runs the queries, the transaction for the repository of a SQLiteTx
+	sqlDB *sql.DB // The database itself, nil inside a transaction
+}
+
+// dbConn runs queries, it is implemented by *sql.DB and *sql.Tx
+type dbConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 // SQLiteTx is a transaction wrapper for SQLite
@@ -46,7 +55,7 @@ func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
 	db.SetConnMaxLifetime(time.Hour)
 
 	// Create repo
-	repo := &SQLiteRepository{db: db}
+	repo := &SQLiteRepository{db: db, sqlDB: db}
 
 	// Initialize database
 	if err := repo.initialize(); err != nil {
@@ -60,7 +69,7 @@ func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
 // initialize creates tables if they don't exist
 func (r *SQLiteRepository) initialize() error {
 	// Create tables
-	_, err := r.db.Exec(`
+	_, err := r.sqlDB.Exec(`
 	-- Users table
 	CREATE TABLE IF NOT EXISTS users (
 		id TEXT PRIMARY KEY,
@@ -224,7 +233,7 @@ func (r *SQLiteRepository) initialize() error {
 	// Run migrations
 	log.Println("Running database migrations...")
 
-	if err := migrations.RunMigrations(r.db); err != nil {
+	if err := migrations.RunMigrations(r.sqlDB); err != nil {
 		return err
 	}
 
@@ -232,18 +241,48 @@ func (r *SQLiteRepository) initialize() error {
 	return nil
 }
 
-// BeginTx starts a new transaction
+// BeginTx starts a new transaction. All operations on the returned
+// transaction run inside it, the database only allows one connection, so the
+// repository itself must not be used until it is committed or rolled back.
 func (r *SQLiteRepository) BeginTx(ctx context.Context) (Transaction, error) {
-	tx, err := r.db.BeginTx(ctx, nil)
+	if r.sqlDB == nil {
+		return nil, errors.New("nested transactions are not supported")
+	}
+
+	tx, err := r.sqlDB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	return &SQLiteTx{
 		tx:               tx,
-		SQLiteRepository: &SQLiteRepository{db: r.db},
+		SQLiteRepository: &SQLiteRepository{db: tx},
 	}, nil
 }
 
+// inTx runs fn in a new transaction, or in the surrounding one if the
+// repository belongs to a SQLiteTx
+func (r *SQLiteRepository) inTx(ctx context.Context, fn func(tx dbConn) error) error {
+	if r.sqlDB == nil {
+		return fn(r.db)
+	}
+
+	tx, err := r.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
 // Commit commits the transaction
 func (t *SQLiteTx) Commit() error {
 	return t.tx.Commit()
@@ -280,13 +319,15 @@ func (r *SQLiteRepository) CreateUser(ctx context.Context, user *models.User) er
 			id, email, password_hash, telegram_id, telegram_username, github_username,
 			last_activity, created_at, updated_at,
 			ping_frequency, ping_deadline, pinging_enabled, ping_method, next_scheduled_ping,
-			totp_secret, totp_enabled, totp_verified
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			totp_secret, totp_enabled, totp_verified,
+			vault_key_salt, encrypted_vault_key, emergency_access_days
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		user.ID, user.Email, user.PasswordHash, user.TelegramID, user.TelegramUsername, user.GitHubUsername,
 		user.LastActivity, user.CreatedAt, user.UpdatedAt,
 		user.PingFrequency, user.PingDeadline, user.PingingEnabled, user.PingMethod, user.NextScheduledPing,
 		user.TOTPSecret, user.TOTPEnabled, user.TOTPVerified,
+		user.VaultKeySalt, user.EncryptedVaultKey, user.EmergencyAccessDays,
 	)
 
 	if err != nil {
@@ -304,7 +345,8 @@ func (r *SQLiteRepository) GetUserByID(ctx context.Context, id string) (*models.
 			id, email, password_hash, telegram_id, telegram_username, github_username,
 			last_activity, created_at, updated_at,
 			ping_frequency, ping_deadline, pinging_enabled, ping_method, next_scheduled_ping,
-			totp_secret, totp_enabled, totp_verified
+			totp_secret, totp_enabled, totp_verified,
+			vault_key_salt, encrypted_vault_key, emergency_access_days
 		FROM users
 		WHERE id = ?
 	`, id).Scan(
@@ -312,6 +354,7 @@ func (r *SQLiteRepository) GetUserByID(ctx context.Context, id string) (*models.
 		&user.LastActivity, &user.CreatedAt, &user.UpdatedAt,
 		&user.PingFrequency, &user.PingDeadline, &user.PingingEnabled, &user.PingMethod, &user.NextScheduledPing,
 		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPVerified,
+		&user.VaultKeySalt, &user.EncryptedVaultKey, &user.EmergencyAccessDays,
 	)
 
 	if err != nil {
@@ -332,7 +375,8 @@ func (r *SQLiteRepository) GetUserByEmail(ctx context.Context, email string) (*m
 			id, email, password_hash, telegram_id, telegram_username, github_username,
 			last_activity, created_at, updated_at,
 			ping_frequency, ping_deadline, pinging_enabled, ping_method, next_scheduled_ping,
-			totp_secret, totp_enabled, totp_verified
+			totp_secret, totp_enabled, totp_verified,
+			vault_key_salt, encrypted_vault_key, emergency_access_days
 		FROM users
 		WHERE email = ?
 	`, email).Scan(
@@ -340,6 +384,7 @@ func (r *SQLiteRepository) GetUserByEmail(ctx context.Context, email string) (*m
 		&user.LastActivity, &user.CreatedAt, &user.UpdatedAt,
 		&user.PingFrequency, &user.PingDeadline, &user.PingingEnabled, &user.PingMethod, &user.NextScheduledPing,
 		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPVerified,
+		&user.VaultKeySalt, &user.EncryptedVaultKey, &user.EmergencyAccessDays,
 	)
 
 	if err != nil {
@@ -360,7 +405,8 @@ func (r *SQLiteRepository) GetUserByTelegramID(ctx context.Context, telegramID s
 			id, email, password_hash, telegram_id, telegram_username, github_username,
 			last_activity, created_at, updated_at,
 			ping_frequency, ping_deadline, pinging_enabled, ping_method, next_scheduled_ping,
-			totp_secret, totp_enabled, totp_verified
+			totp_secret, totp_enabled, totp_verified,
+			vault_key_salt, encrypted_vault_key, emergency_access_days
 		FROM users
 		WHERE telegram_id = ?
 	`, telegramID).Scan(
@@ -368,6 +414,7 @@ func (r *SQLiteRepository) GetUserByTelegramID(ctx context.Context, telegramID s
 		&user.LastActivity, &user.CreatedAt, &user.UpdatedAt,
 		&user.PingFrequency, &user.PingDeadline, &user.PingingEnabled, &user.PingMethod, &user.NextScheduledPing,
 		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPVerified,
+		&user.VaultKeySalt, &user.EncryptedVaultKey, &user.EmergencyAccessDays,
 	)
 
 	if err != nil {
@@ -403,13 +450,15 @@ func (r *SQLiteRepository) UpdateUser(ctx context.Context, user *models.User) er
 			next_scheduled_ping = ?,
 			totp_secret = ?,
 			totp_enabled = ?,
-			totp_verified = ?
+			totp_verified = ?,
+			emergency_access_days = ?
 		WHERE id = ?
 	`,
 		user.Email, user.PasswordHash, user.TelegramID, user.TelegramUsername, user.GitHubUsername,
 		user.LastActivity, user.UpdatedAt,
 		user.PingFrequency, user.PingDeadline, user.PingingEnabled, user.PingMethod, user.NextScheduledPing,
 		user.TOTPSecret, user.TOTPEnabled, user.TOTPVerified,
+		user.EmergencyAccessDays,
 		user.ID,
 	)
 
@@ -422,6 +471,23 @@ func (r *SQLiteRepository) UpdateUser(ctx context.Context, user *models.User) er
 	return nil
 }
 
+// UpdateUserVaultKey stores the wrapped vault key for a user
+func (r *SQLiteRepository) UpdateUserVaultKey(ctx context.Context, userID, salt, encryptedVaultKey string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE users SET
+			vault_key_salt = ?,
+			encrypted_vault_key = ?,
+			updated_at = ?
+		WHERE id = ?
+	`, salt, encryptedVaultKey, time.Now().UTC(), userID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update user vault key: %w", err)
+	}
+
+	return nil
+}
+
 // DeleteUser deletes a user
 func (r *SQLiteRepository) DeleteUser(ctx context.Context, id string) error {
 	_, err := r.db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
@@ -438,7 +504,8 @@ func (r *SQLiteRepository) ListUsers(ctx context.Context) ([]*models.User, error
 			id, email, password_hash, telegram_id, telegram_username, github_username,
 			last_activity, created_at, updated_at,
 			ping_frequency, ping_deadline, pinging_enabled, ping_method, next_scheduled_ping,
-			totp_secret, totp_enabled, totp_verified
+			totp_secret, totp_enabled, totp_verified,
+			vault_key_salt, encrypted_vault_key, emergency_access_days
 		FROM users
 		ORDER BY created_at DESC
 	`)
@@ -455,6 +522,7 @@ func (r *SQLiteRepository) ListUsers(ctx context.Context) ([]*models.User, error
 			&user.LastActivity, &user.CreatedAt, &user.UpdatedAt,
 			&user.PingFrequency, &user.PingDeadline, &user.PingingEnabled, &user.PingMethod, &user.NextScheduledPing,
 			&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPVerified,
+			&user.VaultKeySalt, &user.EncryptedVaultKey, &user.EmergencyAccessDays,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan user row: %w", err)
 		}
@@ -482,11 +550,13 @@ func (r *SQLiteRepository) CreateSecret(ctx context.Context, secret *models.Secr
 
 	_, err := r.db.ExecContext(ctx, `
 		INSERT INTO secrets (
-			id, user_id, name, encrypted_data, created_at, updated_at, encryption_type
-		) VALUES (?, ?, ?, ?, ?, ?, ?)
+			id, user_id, name, encrypted_data, created_at, updated_at, encryption_type,
+			quorum_threshold, quorum_data, file_ref, file_size, secret_type
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		secret.ID, secret.UserID, secret.Name, secret.EncryptedData,
 		secret.CreatedAt, secret.UpdatedAt, secret.EncryptionType,
+		secret.QuorumThreshold, secret.QuorumData, secret.FileRef, secret.FileSize, secret.Type,
 	)
 
 	if err != nil {
@@ -500,12 +570,14 @@ func (r *SQLiteRepository) CreateSecret(ctx context.Context, secret *models.Secr
 func (r *SQLiteRepository) GetSecretByID(ctx context.Context, id string) (*models.Secret, error) {
 	secret := &models.Secret{}
 	err := r.db.QueryRowContext(ctx, `
-		SELECT id, user_id, name, encrypted_data, created_at, updated_at, encryption_type
+		SELECT id, user_id, name, encrypted_data, created_at, updated_at, encryption_type,
+			quorum_threshold, quorum_data, file_ref, file_size, secret_type
 		FROM secrets
 		WHERE id = ?
 	`, id).Scan(
 		&secret.ID, &secret.UserID, &secret.Name, &secret.EncryptedData,
 		&secret.CreatedAt, &secret.UpdatedAt, &secret.EncryptionType,
+		&secret.QuorumThreshold, &secret.QuorumData, &secret.FileRef, &secret.FileSize, &secret.Type,
 	)
 
 	if err != nil {
@@ -521,7 +593,8 @@ func (r *SQLiteRepository) GetSecretByID(ctx context.Context, id string) (*model
 // ListSecretsByUserID lists all secrets for a user
 func (r *SQLiteRepository) ListSecretsByUserID(ctx context.Context, userID string) ([]*models.Secret, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, user_id, name, encrypted_data, created_at, updated_at, encryption_type
+		SELECT id, user_id, name, encrypted_data, created_at, updated_at, encryption_type,
+			quorum_threshold, quorum_data, file_ref, file_size, secret_type
 		FROM secrets
 		WHERE user_id = ?
 		ORDER BY created_at DESC
@@ -537,6 +610,7 @@ func (r *SQLiteRepository) ListSecretsByUserID(ctx context.Context, userID strin
 		if err := rows.Scan(
 			&secret.ID, &secret.UserID, &secret.Name, &secret.EncryptedData,
 			&secret.CreatedAt, &secret.UpdatedAt, &secret.EncryptionType,
+			&secret.QuorumThreshold, &secret.QuorumData, &secret.FileRef, &secret.FileSize, &secret.Type,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan secret row: %w", err)
 		}
@@ -559,10 +633,16 @@ func (r *SQLiteRepository) UpdateSecret(ctx context.Context, secret *models.Secr
 			name = ?,
 			encrypted_data = ?,
 			updated_at = ?,
-			encryption_type = ?
+			encryption_type = ?,
+			quorum_threshold = ?,
+			quorum_data = ?,
+			file_ref = ?,
+			file_size = ?,
+			secret_type = ?
 		WHERE id = ? AND user_id = ?
 	`,
 		secret.Name, secret.EncryptedData, secret.UpdatedAt, secret.EncryptionType,
+		secret.QuorumThreshold, secret.QuorumData, secret.FileRef, secret.FileSize, secret.Type,
 		secret.ID, secret.UserID,
 	)
 
@@ -573,13 +653,17 @@ func (r *SQLiteRepository) UpdateSecret(ctx context.Context, secret *models.Secr
 	return nil
 }
 
-// DeleteSecret deletes a secret
+// DeleteSecret deletes a secret and its earlier revisions
 func (r *SQLiteRepository) DeleteSecret(ctx context.Context, id string) error {
-	_, err := r.db.ExecContext(ctx, "DELETE FROM secrets WHERE id = ?", id)
-	if err != nil {
-		return fmt.Errorf("failed to delete secret: %w", err)
-	}
-	return nil
+	return r.inTx(ctx, func(tx dbConn) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM secret_versions WHERE secret_id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete secret versions: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM secrets WHERE id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete secret: %w", err)
+		}
+		return nil
+	})
 }
 
 // ===== Recipient operations =====
@@ -603,12 +687,15 @@ func (r *SQLiteRepository) CreateRecipient(ctx context.Context, recipient *model
 	_, err := r.db.ExecContext(ctx, `
 		INSERT INTO recipients (
 			id, user_id, email, name, message, created_at, updated_at, phone_number,
-			is_confirmed, confirmed_at, confirmation_code, confirmation_sent_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			is_confirmed, confirmed_at, confirmation_code, confirmation_sent_at, release_delay_days, public_key,
+			question_threshold, question_key, question_bundle
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		recipient.ID, recipient.UserID, recipient.Email, recipient.Name,
 		recipient.Message, recipient.CreatedAt, recipient.UpdatedAt, recipient.PhoneNumber,
 		recipient.IsConfirmed, recipient.ConfirmedAt, recipient.ConfirmationCode, recipient.ConfirmationSentAt,
+		recipient.ReleaseDelayDays, recipient.PublicKey, recipient.QuestionThreshold, recipient.QuestionKey,
+		recipient.QuestionBundle,
 	)
 
 	if err != nil {
@@ -623,13 +710,16 @@ func (r *SQLiteRepository) GetRecipientByID(ctx context.Context, id string) (*mo
 	recipient := &models.Recipient{}
 	err := r.db.QueryRowContext(ctx, `
 		SELECT id, user_id, email, name, message, created_at, updated_at, phone_number,
-		       is_confirmed, confirmed_at, confirmation_code, confirmation_sent_at
+		       is_confirmed, confirmed_at, confirmation_code, confirmation_sent_at, release_delay_days, public_key,
+		       question_threshold, question_key, question_bundle
 		FROM recipients
 		WHERE id = ?
 	`, id).Scan(
 		&recipient.ID, &recipient.UserID, &recipient.Email, &recipient.Name,
 		&recipient.Message, &recipient.CreatedAt, &recipient.UpdatedAt, &recipient.PhoneNumber,
 		&recipient.IsConfirmed, &recipient.ConfirmedAt, &recipient.ConfirmationCode, &recipient.ConfirmationSentAt,
+		&recipient.ReleaseDelayDays, &recipient.PublicKey, &recipient.QuestionThreshold, &recipient.QuestionKey,
+		&recipient.QuestionBundle,
 	)
 
 	if err != nil {
@@ -646,7 +736,8 @@ func (r *SQLiteRepository) GetRecipientByID(ctx context.Context, id string) (*mo
 func (r *SQLiteRepository) ListRecipientsByUserID(ctx context.Context, userID string) ([]*models.Recipient, error) {
 	rows, err := r.db.QueryContext(ctx, `
 		SELECT id, user_id, email, name, message, created_at, updated_at, phone_number,
-		       is_confirmed, confirmed_at, confirmation_code, confirmation_sent_at
+		       is_confirmed, confirmed_at, confirmation_code, confirmation_sent_at, release_delay_days, public_key,
+		       question_threshold, question_key, question_bundle
 		FROM recipients
 		WHERE user_id = ?
 		ORDER BY name ASC
@@ -663,6 +754,45 @@ func (r *SQLiteRepository) ListRecipientsByUserID(ctx context.Context, userID st
 			&recipient.ID, &recipient.UserID, &recipient.Email, &recipient.Name,
 			&recipient.Message, &recipient.CreatedAt, &recipient.UpdatedAt, &recipient.PhoneNumber,
 			&recipient.IsConfirmed, &recipient.ConfirmedAt, &recipient.ConfirmationCode, &recipient.ConfirmationSentAt,
+			&recipient.ReleaseDelayDays, &recipient.PublicKey, &recipient.QuestionThreshold, &recipient.QuestionKey,
+			&recipient.QuestionBundle,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan recipient row: %w", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recipient rows: %w", err)
+	}
+
+	return recipients, nil
+}
+
+// ListRecipientsByEmail lists the recipients of all users with the given email address
+func (r *SQLiteRepository) ListRecipientsByEmail(ctx context.Context, email string) ([]*models.Recipient, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, email, name, message, created_at, updated_at, phone_number,
+		       is_confirmed, confirmed_at, confirmation_code, confirmation_sent_at, release_delay_days, public_key,
+		       question_threshold, question_key, question_bundle
+		FROM recipients
+		WHERE LOWER(email) = LOWER(?)
+		ORDER BY created_at ASC
+	`, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipients by email: %w", err)
+	}
+	defer rows.Close()
+
+	var recipients []*models.Recipient
+	for rows.Next() {
+		recipient := &models.Recipient{}
+		if err := rows.Scan(
+			&recipient.ID, &recipient.UserID, &recipient.Email, &recipient.Name,
+			&recipient.Message, &recipient.CreatedAt, &recipient.UpdatedAt, &recipient.PhoneNumber,
+			&recipient.IsConfirmed, &recipient.ConfirmedAt, &recipient.ConfirmationCode, &recipient.ConfirmationSentAt,
+			&recipient.ReleaseDelayDays, &recipient.PublicKey, &recipient.QuestionThreshold, &recipient.QuestionKey,
+			&recipient.QuestionBundle,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan recipient row: %w", err)
 		}
@@ -690,12 +820,19 @@ func (r *SQLiteRepository) UpdateRecipient(ctx context.Context, recipient *model
 			is_confirmed = ?,
 			confirmed_at = ?,
 			confirmation_code = ?,
-			confirmation_sent_at = ?
+			confirmation_sent_at = ?,
+			release_delay_days = ?,
+			public_key = ?,
+			question_threshold = ?,
+			question_key = ?,
+			question_bundle = ?
 		WHERE id = ? AND user_id = ?
 	`,
 		recipient.Email, recipient.Name, recipient.Message,
 		recipient.UpdatedAt, recipient.PhoneNumber,
 		recipient.IsConfirmed, recipient.ConfirmedAt, recipient.ConfirmationCode, recipient.ConfirmationSentAt,
+		recipient.ReleaseDelayDays, recipient.PublicKey, recipient.QuestionThreshold, recipient.QuestionKey,
+		recipient.QuestionBundle,
 		recipient.ID, recipient.UserID,
 	)
 
@@ -708,6 +845,14 @@ func (r *SQLiteRepository) UpdateRecipient(ctx context.Context, recipient *model
 
 // DeleteRecipient deletes a recipient
 func (r *SQLiteRepository) DeleteRecipient(ctx context.Context, id string) error {
+	// The locked shares are useless without the recipient, don't leave them behind
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM recipient_questions WHERE recipient_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete recipient questions: %w", err)
+	}
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM question_timelocks WHERE recipient_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete question timelocks: %w", err)
+	}
+
 	_, err := r.db.ExecContext(ctx, "DELETE FROM recipients WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("failed to delete recipient: %w", err)
@@ -729,11 +874,12 @@ func (r *SQLiteRepository) CreateSecretAssignment(ctx context.Context, assignmen
 
 	_, err := r.db.ExecContext(ctx, `
 		INSERT INTO secret_assignments (
-			id, secret_id, recipient_id, user_id, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?)
+			id, secret_id, recipient_id, user_id, created_at, updated_at, delivery_data
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
 	`,
 		assignment.ID, assignment.SecretID, assignment.RecipientID,
 		assignment.UserID, assignment.CreatedAt, assignment.UpdatedAt,
+		assignment.DeliveryData,
 	)
 
 	if err != nil {
@@ -747,12 +893,13 @@ func (r *SQLiteRepository) CreateSecretAssignment(ctx context.Context, assignmen
 func (r *SQLiteRepository) GetSecretAssignmentByID(ctx context.Context, id string) (*models.SecretAssignment, error) {
 	assignment := &models.SecretAssignment{}
 	err := r.db.QueryRowContext(ctx, `
-		SELECT id, secret_id, recipient_id, user_id, created_at, updated_at
+		SELECT id, secret_id, recipient_id, user_id, created_at, updated_at, delivery_data
 		FROM secret_assignments
 		WHERE id = ?
 	`, id).Scan(
 		&assignment.ID, &assignment.SecretID, &assignment.RecipientID,
 		&assignment.UserID, &assignment.CreatedAt, &assignment.UpdatedAt,
+		&assignment.DeliveryData,
 	)
 
 	if err != nil {
@@ -768,7 +915,7 @@ func (r *SQLiteRepository) GetSecretAssignmentByID(ctx context.Context, id strin
 // ListSecretAssignmentsBySecretID lists all assignments for a secret
 func (r *SQLiteRepository) ListSecretAssignmentsBySecretID(ctx context.Context, secretID string) ([]*models.SecretAssignment, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, secret_id, recipient_id, user_id, created_at, updated_at
+		SELECT id, secret_id, recipient_id, user_id, created_at, updated_at, delivery_data
 		FROM secret_assignments
 		WHERE secret_id = ?
 	`, secretID)
@@ -783,6 +930,7 @@ func (r *SQLiteRepository) ListSecretAssignmentsBySecretID(ctx context.Context,
 		if err := rows.Scan(
 			&assignment.ID, &assignment.SecretID, &assignment.RecipientID,
 			&assignment.UserID, &assignment.CreatedAt, &assignment.UpdatedAt,
+			&assignment.DeliveryData,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan secret assignment row: %w", err)
 		}
@@ -800,7 +948,7 @@ func (r *SQLiteRepository) ListSecretAssignmentsBySecretID(ctx context.Context,
 // ListSecretAssignmentsByRecipientID lists all assignments for a recipient
 func (r *SQLiteRepository) ListSecretAssignmentsByRecipientID(ctx context.Context, recipientID string) ([]*models.SecretAssignment, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, secret_id, recipient_id, user_id, created_at, updated_at
+		SELECT id, secret_id, recipient_id, user_id, created_at, updated_at, delivery_data
 		FROM secret_assignments
 		WHERE recipient_id = ?
 	`, recipientID)
@@ -815,6 +963,7 @@ func (r *SQLiteRepository) ListSecretAssignmentsByRecipientID(ctx context.Contex
 		if err := rows.Scan(
 			&assignment.ID, &assignment.SecretID, &assignment.RecipientID,
 			&assignment.UserID, &assignment.CreatedAt, &assignment.UpdatedAt,
+			&assignment.DeliveryData,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan secret assignment row: %w", err)
 		}
@@ -832,7 +981,7 @@ func (r *SQLiteRepository) ListSecretAssignmentsByRecipientID(ctx context.Contex
 // ListSecretAssignmentsByUserID lists all assignments for a user
 func (r *SQLiteRepository) ListSecretAssignmentsByUserID(ctx context.Context, userID string) ([]*models.SecretAssignment, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, secret_id, recipient_id, user_id, created_at, updated_at
+		SELECT id, secret_id, recipient_id, user_id, created_at, updated_at, delivery_data
 		FROM secret_assignments
 		WHERE user_id = ?
 	`, userID)
@@ -847,6 +996,7 @@ func (r *SQLiteRepository) ListSecretAssignmentsByUserID(ctx context.Context, us
 		if err := rows.Scan(
 			&assignment.ID, &assignment.SecretID, &assignment.RecipientID,
 			&assignment.UserID, &assignment.CreatedAt, &assignment.UpdatedAt,
+			&assignment.DeliveryData,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan secret assignment row: %w", err)
 		}
@@ -861,6 +1011,27 @@ func (r *SQLiteRepository) ListSecretAssignmentsByUserID(ctx context.Context, us
 	return assignments, nil
 }
 
+// UpdateSecretAssignment updates an existing secret assignment
+func (r *SQLiteRepository) UpdateSecretAssignment(ctx context.Context, assignment *models.SecretAssignment) error {
+	assignment.UpdatedAt = time.Now().UTC()
+
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE secret_assignments SET
+			delivery_data = ?,
+			updated_at = ?
+		WHERE id = ?
+	`,
+		assignment.DeliveryData, assignment.UpdatedAt,
+		assignment.ID,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to update secret assignment: %w", err)
+	}
+
+	return nil
+}
+
 // DeleteSecretAssignment deletes a secret assignment
 func (r *SQLiteRepository) DeleteSecretAssignment(ctx context.Context, id string) error {
 	_, err := r.db.ExecContext(ctx, "DELETE FROM secret_assignments WHERE id = ?", id)
@@ -1041,11 +1212,13 @@ func (r *SQLiteRepository) CreateDeliveryEvent(ctx context.Context, event *model
 
 	_, err := r.db.ExecContext(ctx, `
 		INSERT INTO delivery_events (
-			id, user_id, recipient_id, sent_at, status, error_message
-		) VALUES (?, ?, ?, ?, ?, ?)
+			id, user_id, run_id, recipient_id, sent_at, status, error_message,
+			attempts, last_attempt_at, next_attempt_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
-		event.ID, event.UserID, event.RecipientID,
+		event.ID, event.UserID, event.RunID, event.RecipientID,
 		event.SentAt, event.Status, event.ErrorMessage,
+		event.Attempts, event.LastAttemptAt, event.NextAttemptAt,
 	)
 
 	if err != nil {
@@ -1059,9 +1232,9 @@ func (r *SQLiteRepository) CreateDeliveryEvent(ctx context.Context, event *model
 func (r *SQLiteRepository) UpdateDeliveryEvent(ctx context.Context, event *models.DeliveryEvent) error {
 	result, err := r.db.ExecContext(ctx, `
 		UPDATE delivery_events
-		SET status = ?, error_message = ?
+		SET status = ?, error_message = ?, attempts = ?, last_attempt_at = ?, next_attempt_at = ?
 		WHERE id = ?
-	`, event.Status, event.ErrorMessage, event.ID)
+	`, event.Status, event.ErrorMessage, event.Attempts, event.LastAttemptAt, event.NextAttemptAt, event.ID)
 
 	if err != nil {
 		return fmt.Errorf("failed to update delivery event: %w", err)
@@ -1082,7 +1255,8 @@ func (r *SQLiteRepository) UpdateDeliveryEvent(ctx context.Context, event *model
 // ListDeliveryEventsByUserID lists all delivery events for a user
 func (r *SQLiteRepository) ListDeliveryEventsByUserID(ctx context.Context, userID string) ([]*models.DeliveryEvent, error) {
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, user_id, recipient_id, sent_at, status, error_message
+		SELECT id, user_id, run_id, recipient_id, sent_at, status, error_message,
+			attempts, last_attempt_at, next_attempt_at
 		FROM delivery_events
 		WHERE user_id = ?
 		ORDER BY sent_at DESC
@@ -1092,15 +1266,67 @@ func (r *SQLiteRepository) ListDeliveryEventsByUserID(ctx context.Context, userI
 	}
 	defer rows.Close()
 
+	return scanDeliveryEvents(rows)
+}
+
+// ListDueDeliveryEvents lists the scheduled, pending and retrying delivery events whose next attempt is due
+func (r *SQLiteRepository) ListDueDeliveryEvents(ctx context.Context, now time.Time) ([]*models.DeliveryEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, run_id, recipient_id, sent_at, status, error_message,
+			attempts, last_attempt_at, next_attempt_at
+		FROM delivery_events
+		WHERE status IN (?, ?, ?)
+		AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+		ORDER BY sent_at ASC
+	`, models.DeliveryStatusScheduled, models.DeliveryStatusPending, models.DeliveryStatusRetrying, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due delivery events: %w", err)
+	}
+	defer rows.Close()
+
+	return scanDeliveryEvents(rows)
+}
+
+// ListDeliveryEventsByRunID lists the delivery events of a delivery run
+func (r *SQLiteRepository) ListDeliveryEventsByRunID(ctx context.Context, runID string) ([]*models.DeliveryEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, run_id, recipient_id, sent_at, status, error_message,
+			attempts, last_attempt_at, next_attempt_at
+		FROM delivery_events
+		WHERE run_id = ?
+		ORDER BY sent_at ASC
+	`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list delivery events of run: %w", err)
+	}
+	defer rows.Close()
+
+	return scanDeliveryEvents(rows)
+}
+
+// scanDeliveryEvents scans delivery event rows
+func scanDeliveryEvents(rows *sql.Rows) ([]*models.DeliveryEvent, error) {
 	var events []*models.DeliveryEvent
 	for rows.Next() {
 		event := &models.DeliveryEvent{}
+		var errorMessage sql.NullString
+		var lastAttemptAt, nextAttemptAt sql.NullTime
 		if err := rows.Scan(
-			&event.ID, &event.UserID, &event.RecipientID,
-			&event.SentAt, &event.Status, &event.ErrorMessage,
+			&event.ID, &event.UserID, &event.RunID, &event.RecipientID,
+			&event.SentAt, &event.Status, &errorMessage,
+			&event.Attempts, &lastAttemptAt, &nextAttemptAt,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan delivery event row: %w", err)
 		}
+
+		event.ErrorMessage = errorMessage.String
+		if lastAttemptAt.Valid {
+			event.LastAttemptAt = &lastAttemptAt.Time
+		}
+		if nextAttemptAt.Valid {
+			event.NextAttemptAt = &nextAttemptAt.Time
+		}
+
 		events = append(events, event)
 	}
 
@@ -1267,7 +1493,8 @@ func (r *SQLiteRepository) GetUsersForPinging(ctx context.Context) ([]*models.Us
 		SELECT
 			id, email, password_hash, telegram_id, telegram_username, github_username,
 			last_activity, created_at, updated_at,
-			ping_frequency, ping_deadline, pinging_enabled, ping_method, next_scheduled_ping
+			ping_frequency, ping_deadline, pinging_enabled, ping_method, next_scheduled_ping,
+			emergency_access_days
 		FROM users
 		WHERE pinging_enabled = 1 AND (next_scheduled_ping IS NULL OR next_scheduled_ping <= ?)
 		ORDER BY next_scheduled_ping ASC
@@ -1284,6 +1511,7 @@ func (r *SQLiteRepository) GetUsersForPinging(ctx context.Context) ([]*models.Us
 			&user.ID, &user.Email, &user.PasswordHash, &user.TelegramID, &user.TelegramUsername, &user.GitHubUsername,
 			&user.LastActivity, &user.CreatedAt, &user.UpdatedAt,
 			&user.PingFrequency, &user.PingDeadline, &user.PingingEnabled, &user.PingMethod, &user.NextScheduledPing,
+			&user.EmergencyAccessDays,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan user row: %w", err)
 		}
@@ -1305,7 +1533,8 @@ func (r *SQLiteRepository) GetUsersWithExpiredPings(ctx context.Context) ([]*mod
 		SELECT
 			u.id, u.email, u.password_hash, u.telegram_id, u.telegram_username, u.github_username,
 			u.last_activity, u.created_at, u.updated_at,
-			u.ping_frequency, u.ping_deadline, u.pinging_enabled, u.ping_method, u.next_scheduled_ping
+			u.ping_frequency, u.ping_deadline, u.pinging_enabled, u.ping_method, u.next_scheduled_ping,
+			u.emergency_access_days
 		FROM users u
 		WHERE u.pinging_enabled = 1
 		AND (
@@ -1326,6 +1555,7 @@ func (r *SQLiteRepository) GetUsersWithExpiredPings(ctx context.Context) ([]*mod
 			&user.ID, &user.Email, &user.PasswordHash, &user.TelegramID, &user.TelegramUsername, &user.GitHubUsername,
 			&user.LastActivity, &user.CreatedAt, &user.UpdatedAt,
 			&user.PingFrequency, &user.PingDeadline, &user.PingingEnabled, &user.PingMethod, &user.NextScheduledPing,
+			&user.EmergencyAccessDays,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan user row: %w", err)
 		}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/korjavin/deadmanswitch/internal/models"
 )
@@ -16,6 +17,9 @@ var (
 
 	// ErrInvalidCredentials is returned when login credentials are invalid
 	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrAccessCodeLocked is returned when an access code had too many failed attempts
+	ErrAccessCodeLocked = errors.New("access code locked due to too many failed attempts")
 )
 
 // Repository defines the interface for database operations
@@ -26,6 +30,7 @@ type Repository interface {
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
 	GetUserByTelegramID(ctx context.Context, telegramID string) (*models.User, error)
 	UpdateUser(ctx context.Context, user *models.User) error
+	UpdateUserVaultKey(ctx context.Context, userID, salt, encryptedVaultKey string) error
 	DeleteUser(ctx context.Context, id string) error
 	ListUsers(ctx context.Context) ([]*models.User, error)
 
@@ -36,19 +41,38 @@ type Repository interface {
 	UpdateSecret(ctx context.Context, secret *models.Secret) error
 	DeleteSecret(ctx context.Context, id string) error
 
+	// SecretVersion operations
+	CreateSecretVersion(ctx context.Context, version *models.SecretVersion) error
+	GetSecretVersionByID(ctx context.Context, id string) (*models.SecretVersion, error)
+	ListSecretVersionsBySecretID(ctx context.Context, secretID string) ([]*models.SecretVersion, error)
+	ListSecretVersionsByUserID(ctx context.Context, userID string) ([]*models.SecretVersion, error)
+	DeleteSecretVersion(ctx context.Context, id string) error
+
 	// Recipient operations
 	CreateRecipient(ctx context.Context, recipient *models.Recipient) error
 	GetRecipientByID(ctx context.Context, id string) (*models.Recipient, error)
 	ListRecipientsByUserID(ctx context.Context, userID string) ([]*models.Recipient, error)
+	ListRecipientsByEmail(ctx context.Context, email string) ([]*models.Recipient, error)
 	UpdateRecipient(ctx context.Context, recipient *models.Recipient) error
 	DeleteRecipient(ctx context.Context, id string) error
 
+	// RecipientQuestion operations
+	ListRecipientQuestions(ctx context.Context, recipientID string) ([]*models.RecipientQuestion, error)
+	ReplaceRecipientQuestions(ctx context.Context, recipientID string, questions []*models.RecipientQuestion) error
+
+	// QuestionTimelock operations
+	ListQuestionTimelocks(ctx context.Context, recipientID string) ([]*models.QuestionTimelock, error)
+	ListQuestionTimelocksByUserID(ctx context.Context, userID string) ([]*models.QuestionTimelock, error)
+	ReplaceQuestionTimelocks(ctx context.Context, recipientID string, timelocks []*models.QuestionTimelock) error
+	DeleteQuestionTimelock(ctx context.Context, id string) error
+
 	// SecretAssignment operations
 	CreateSecretAssignment(ctx context.Context, assignment *models.SecretAssignment) error
 	GetSecretAssignmentByID(ctx context.Context, id string) (*models.SecretAssignment, error)
 	ListSecretAssignmentsBySecretID(ctx context.Context, secretID string) ([]*models.SecretAssignment, error)
 	ListSecretAssignmentsByRecipientID(ctx context.Context, recipientID string) ([]*models.SecretAssignment, error)
 	ListSecretAssignmentsByUserID(ctx context.Context, userID string) ([]*models.SecretAssignment, error)
+	UpdateSecretAssignment(ctx context.Context, assignment *models.SecretAssignment) error
 	DeleteSecretAssignment(ctx context.Context, id string) error
 
 	// Ping operations
@@ -66,6 +90,29 @@ type Repository interface {
 	CreateDeliveryEvent(ctx context.Context, event *models.DeliveryEvent) error
 	UpdateDeliveryEvent(ctx context.Context, event *models.DeliveryEvent) error
 	ListDeliveryEventsByUserID(ctx context.Context, userID string) ([]*models.DeliveryEvent, error)
+	ListDeliveryEventsByRunID(ctx context.Context, runID string) ([]*models.DeliveryEvent, error)
+	ListDueDeliveryEvents(ctx context.Context, now time.Time) ([]*models.DeliveryEvent, error)
+
+	// DeliveryRun operations
+	CreateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error
+	GetActiveDeliveryRunByUserID(ctx context.Context, userID string) (*models.DeliveryRun, error)
+	GetDeliveryRunByAbortCode(ctx context.Context, code string) (*models.DeliveryRun, error)
+	ListDeliveryRunsByStatus(ctx context.Context, status string) ([]*models.DeliveryRun, error)
+	ListDeliveryRunsByUserID(ctx context.Context, userID string) ([]*models.DeliveryRun, error)
+	UpdateDeliveryRun(ctx context.Context, run *models.DeliveryRun) error
+	CompleteDeliveryRun(ctx context.Context, id string) error
+
+	// DeliveryAttempt operations
+	CreateDeliveryAttempt(ctx context.Context, attempt *models.DeliveryAttempt) error
+	ListDeliveryAttemptsByEventID(ctx context.Context, eventID string) ([]*models.DeliveryAttempt, error)
+
+	// EmergencyAccessRequest operations
+	CreateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error
+	GetEmergencyAccessRequestByVerifyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error)
+	GetEmergencyAccessRequestByDenyCode(ctx context.Context, code string) (*models.EmergencyAccessRequest, error)
+	ListEmergencyAccessRequestsByUserID(ctx context.Context, userID string) ([]*models.EmergencyAccessRequest, error)
+	ListDueEmergencyAccessRequests(ctx context.Context, now time.Time) ([]*models.EmergencyAccessRequest, error)
+	UpdateEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error
 
 	// Audit log operations
 	CreateAuditLog(ctx context.Context, log *models.AuditLog) error
@@ -91,11 +138,26 @@ type Repository interface {
 	// AccessCode operations
 	CreateAccessCode(ctx context.Context, code *models.AccessCode) error
 	GetAccessCodeByCode(ctx context.Context, code string) (*models.AccessCode, error)
+	VerifyAccessCodeHash(ctx context.Context, codeHash string) (*models.AccessCode, error)
 	VerifyAccessCode(ctx context.Context, code string) (*models.AccessCode, error)
 	MarkAccessCodeAsUsed(ctx context.Context, id string) error
 	IncrementAccessCodeAttempts(ctx context.Context, id string) error
+	ListAccessCodesByUserID(ctx context.Context, userID string) ([]*models.AccessCode, error)
+	RevokeAccessCodesByUserID(ctx context.Context, userID string) (int, error)
 	DeleteExpiredAccessCodes(ctx context.Context) error
 
+	// ShareSubmission operations
+	CreateShareSubmission(ctx context.Context, submission *models.ShareSubmission) error
+	ListShareSubmissionsBySecretID(ctx context.Context, secretID string) ([]*models.ShareSubmission, error)
+	DeleteShareSubmissionsBySecretID(ctx context.Context, secretID string) error
+
+	// APIToken operations
+	CreateAPIToken(ctx context.Context, token *models.APIToken) error
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error)
+	ListAPITokensByUserID(ctx context.Context, userID string) ([]*models.APIToken, error)
+	UpdateAPITokenLastUsed(ctx context.Context, id string) error
+	RevokeAPIToken(ctx context.Context, id string) error
+
 	// Scheduler operations
 	GetUsersForPinging(ctx context.Context) ([]*models.User, error)
 	GetUsersWithExpiredPings(ctx context.Context) ([]*models.User, error)
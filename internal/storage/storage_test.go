@@ -102,6 +102,28 @@ func TestSQLiteRepository_UserOperations(t *testing.T) {
 		t.Errorf("Expected updated email 'updated@example.com', got %s", retrievedUser.Email)
 	}
 
+	// Test UpdateUserVaultKey
+	err = repo.UpdateUserVaultKey(ctx, user.ID, "salt", "wrapped-key")
+	if err != nil {
+		t.Fatalf("Failed to update user vault key: %v", err)
+	}
+
+	// Verify the vault key survives a regular user update
+	retrievedUser, err = repo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if err := repo.UpdateUser(ctx, retrievedUser); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	retrievedUser, err = repo.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("Failed to get user by email: %v", err)
+	}
+	if retrievedUser.VaultKeySalt != "salt" || retrievedUser.EncryptedVaultKey != "wrapped-key" {
+		t.Errorf("Expected vault key fields to be stored, got salt %q key %q", retrievedUser.VaultKeySalt, retrievedUser.EncryptedVaultKey)
+	}
+
 	// Test ListUsers
 	users, err := repo.ListUsers(ctx)
 	if err != nil {
@@ -158,6 +180,7 @@ func TestSQLiteRepository_SecretOperations(t *testing.T) {
 		Name:           "Test Secret",
 		EncryptedData:  "encrypted_data",
 		EncryptionType: "aes-256-gcm",
+		Type:           "seed_phrase",
 	}
 
 	// Test CreateSecret
@@ -179,6 +202,9 @@ func TestSQLiteRepository_SecretOperations(t *testing.T) {
 	if retrievedSecret.Name != secret.Name {
 		t.Errorf("Expected name %s, got %s", secret.Name, retrievedSecret.Name)
 	}
+	if retrievedSecret.Type != "seed_phrase" {
+		t.Errorf("Expected type seed_phrase, got %q", retrievedSecret.Type)
+	}
 
 	// Test ListSecretsByUserID
 	secrets, err := repo.ListSecretsByUserID(ctx, user.ID)
@@ -191,6 +217,8 @@ func TestSQLiteRepository_SecretOperations(t *testing.T) {
 
 	// Test UpdateSecret
 	secret.Name = "Updated Secret"
+	secret.FileRef = "file-ref"
+	secret.FileSize = 1024
 	err = repo.UpdateSecret(ctx, secret)
 	if err != nil {
 		t.Fatalf("Failed to update secret: %v", err)
@@ -204,6 +232,9 @@ func TestSQLiteRepository_SecretOperations(t *testing.T) {
 	if retrievedSecret.Name != "Updated Secret" {
 		t.Errorf("Expected updated name 'Updated Secret', got %s", retrievedSecret.Name)
 	}
+	if !retrievedSecret.IsFile() || retrievedSecret.FileRef != "file-ref" || retrievedSecret.FileSize != 1024 {
+		t.Errorf("Expected file reference file-ref of 1024 bytes, got %q of %d bytes", retrievedSecret.FileRef, retrievedSecret.FileSize)
+	}
 
 	// Test DeleteSecret
 	err = repo.DeleteSecret(ctx, secret.ID)
@@ -504,9 +535,6 @@ func TestSQLiteRepository_SessionOperations(t *testing.T) {
 
 // TestSQLiteRepository_Transaction tests transaction operations
 func TestSQLiteRepository_Transaction(t *testing.T) {
-	// Skip this test for now as it's causing issues
-	t.Skip("Skipping transaction test due to issues with SQLite transactions")
-
 	// Create a temporary database file
 	dbPath := "./test_transaction.sqlite"
 	defer os.Remove(dbPath)
@@ -591,4 +619,49 @@ func TestSQLiteRepository_Transaction(t *testing.T) {
 			t.Errorf("Expected ErrNotFound, got %v", err)
 		}
 	})
+
+	// Test operations that use a transaction of their own
+	t.Run("Operations Join The Transaction", func(t *testing.T) {
+		tx, err := repo.BeginTx(ctx)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+
+		user := &models.User{
+			Email:          "tx_join@example.com",
+			PasswordHash:   []byte("hashed_password"),
+			PingFrequency:  3,
+			PingDeadline:   14,
+			PingingEnabled: true,
+			PingMethod:     "both",
+		}
+		if err := tx.CreateUser(ctx, user); err != nil {
+			t.Fatalf("Failed to create user in transaction: %v", err)
+		}
+		recipient := &models.Recipient{UserID: user.ID, Email: "tx_join_recipient@example.com", Name: "Recipient"}
+		if err := tx.CreateRecipient(ctx, recipient); err != nil {
+			t.Fatalf("Failed to create recipient in transaction: %v", err)
+		}
+
+		timelocks := []*models.QuestionTimelock{{UserID: user.ID, Round: 1, OpensAt: time.Now(), Data: "copy"}}
+		if err := tx.ReplaceQuestionTimelocks(ctx, recipient.ID, timelocks); err != nil {
+			t.Fatalf("Failed to replace question timelocks in transaction: %v", err)
+		}
+
+		if _, err := tx.BeginTx(ctx); err == nil {
+			t.Error("Expected an error for a nested transaction")
+		}
+
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Failed to rollback transaction: %v", err)
+		}
+
+		stored, err := repo.ListQuestionTimelocks(ctx, recipient.ID)
+		if err != nil {
+			t.Fatalf("Failed to list question timelocks: %v", err)
+		}
+		if len(stored) != 0 {
+			t.Errorf("Expected the rollback to drop the question timelocks, got %d", len(stored))
+		}
+	})
 }
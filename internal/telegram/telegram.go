@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/korjavin/deadmanswitch/internal/config"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 
@@ -20,6 +21,7 @@ type Bot struct {
 	bot      *tgbotapi.BotAPI
 	config   *config.Config
 	repo     storage.Repository
+	sealer   *delivery.Sealer
 	handlers map[string]CommandHandler
 	updates  tgbotapi.UpdatesChannel
 }
@@ -45,8 +47,10 @@ func NewBot(cfg *config.Config, repo storage.Repository) (*Bot, error) {
 		bot:      bot,
 		config:   cfg,
 		repo:     repo,
+		sealer:   delivery.NewSealer(repo, cfg.MasterKey, cfg.Timelock),
 		handlers: make(map[string]CommandHandler),
 	}
+	b.sealer.SetPreviousMasterKeys(cfg.PreviousMasterKeys)
 
 	// Register command handlers
 	b.registerHandlers()
@@ -137,6 +141,9 @@ func (b *Bot) handleMessage(ctx context.Context, message *tgbotapi.Message) {
 			if err := b.repo.UpdateUser(ctx, user); err != nil {
 				log.Printf("Error updating user activity: %v", err)
 			}
+			if err := b.sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+				log.Printf("Error renewing question timelocks: %v", err)
+			}
 
 			// Mark any pending pings as responded
 			latestPing, err := b.repo.GetLatestPingByUserID(ctx, user.ID)
@@ -208,6 +215,9 @@ func (b *Bot) handleCallbackQuery(ctx context.Context, query *tgbotapi.CallbackQ
 		if err := b.repo.UpdateUser(ctx, user); err != nil {
 			log.Printf("Error updating user activity: %v", err)
 		}
+		if err := b.sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+			log.Printf("Error renewing question timelocks: %v", err)
+		}
 
 		// Update ping status if it exists
 		if ping != nil && ping.Status == "sent" {
@@ -251,6 +261,8 @@ func (b *Bot) SendPingMessage(ctx context.Context, user *models.User, pingID str
 	buttonText := "I'm OK - Confirm"
 	if urgency == "final_warning" {
 		buttonText = "I'm OK NOW - Confirm"
+	} else if urgency == "switch_armed" {
+		buttonText = "I'm OK - Abort Release"
 	}
 
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
@@ -259,6 +271,15 @@ func (b *Bot) SendPingMessage(ctx context.Context, user *models.User, pingID str
 		),
 	)
 
+	// An emergency access request is denied on the website, pingID is the code of the deny link
+	if urgency == "emergency_access" {
+		keyboard = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonURL("Deny Access", fmt.Sprintf("https://%s/emergency-access/deny/%s", b.config.BaseDomain, pingID)),
+			),
+		)
+	}
+
 	message := b.getMessageByUrgency(user, urgency)
 
 	msg := tgbotapi.NewMessage(chatID, message)
@@ -272,6 +293,18 @@ func (b *Bot) SendPingMessage(ctx context.Context, user *models.User, pingID str
 // getMessageByUrgency returns an urgency-appropriate Telegram message
 func (b *Bot) getMessageByUrgency(user *models.User, urgency string) string {
 	switch urgency {
+	case "emergency_access":
+		return "🚨 *EMERGENCY ACCESS REQUESTED* 🚨\n\n" +
+			"One of your recipients has requested emergency access to the secrets you assigned to them, " +
+			"because they believe you are incapacitated.\n\n" +
+			"Unless you deny the request, their secrets will be released when your waiting period ends.\n\n" +
+			"Click 'Deny Access' if you are OK."
+	case "switch_armed":
+		return "🚨 *YOUR DEAD MAN'S SWITCH HAS BEEN ARMED* 🚨\n\n" +
+			"You missed your check-in deadline.\n\n" +
+			"Your secrets will be released to your recipients when the grace period ends. " +
+			"The dashboard shows how much time is left.\n\n" +
+			"Click 'I'm OK - Abort Release' to stop the release and reset your switch."
 	case "final_warning":
 		return fmt.Sprintf(
 			"🚨 *FINAL WARNING* 🚨\n\n"+
@@ -465,6 +498,9 @@ func (b *Bot) handleConnect(ctx context.Context, message *tgbotapi.Message, args
 	if err := b.repo.UpdateUser(ctx, user); err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
+	if err := b.sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		log.Printf("Error renewing question timelocks: %v", err)
+	}
 
 	return b.sendMessage(message.Chat.ID, fmt.Sprintf(
 		"✅ Success! Your Telegram account is now connected to %s.\n\nType /status to see your current settings.",
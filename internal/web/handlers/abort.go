@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// AbortHandler handles the abort links sent while a switch is armed
+type AbortHandler struct {
+	repo   storage.Repository
+	sealer *delivery.Sealer
+}
+
+// NewAbortHandler creates a new AbortHandler
+func NewAbortHandler(repo storage.Repository, sealer *delivery.Sealer) *AbortHandler {
+	return &AbortHandler{
+		repo:   repo,
+		sealer: sealer,
+	}
+}
+
+// HandleAbort handles a click on the abort link of an armed switch
+func (h *AbortHandler) HandleAbort(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	if code == "" {
+		http.Error(w, "Abort code is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	run, err := h.repo.GetDeliveryRunByAbortCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Invalid abort link", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error checking abort link", http.StatusInternalServerError)
+		log.Printf("Error fetching delivery run by abort code: %v", err)
+		return
+	}
+
+	if run.Status == models.DeliveryRunStatusInProgress || run.Status == models.DeliveryRunStatusCompleted {
+		http.Error(w, "The grace period is over and your secrets have already been released", http.StatusGone)
+		return
+	}
+
+	user, err := h.repo.GetUserByID(ctx, run.UserID)
+	if err != nil {
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		log.Printf("Error fetching user for abort link: %v", err)
+		return
+	}
+
+	// A link of an aborted run only shows the result again
+	if run.Status == models.DeliveryRunStatusArmed {
+		// Clicking the link is a check-in as well, otherwise the switch would arm again right away
+		now := time.Now().UTC()
+		user.LastActivity = now
+		user.NextScheduledPing = now.AddDate(0, 0, user.PingFrequency)
+		if err := h.repo.UpdateUser(ctx, user); err != nil {
+			http.Error(w, "Error updating user", http.StatusInternalServerError)
+			log.Printf("Error updating user last activity: %v", err)
+			return
+		}
+		if err := h.sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+			log.Printf("Error renewing question timelocks: %v", err)
+		}
+
+		abortRun(ctx, h.repo, r, run, "Armed switch aborted via the abort link")
+	}
+
+	data := templates.TemplateData{
+		Title:           "Release Aborted",
+		ActivePage:      "",
+		IsAuthenticated: false,
+		Data: map[string]interface{}{
+			"NextCheckIn": user.NextScheduledPing.Format("Jan 2, 2006 15:04 MST"),
+			"Deadline":    user.LastActivity.AddDate(0, 0, user.PingDeadline).Format("Jan 2, 2006 15:04 MST"),
+		},
+	}
+
+	if err := templates.RenderTemplate(w, "abort-success.html", data); err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// abortArmedSwitch aborts the armed switch of a user, if there is one. Every check-in
+// calls it, so the owner can abort the release in whatever way they check in.
+func abortArmedSwitch(ctx context.Context, repo storage.Repository, r *http.Request, user *models.User, details string) {
+	run, err := repo.GetActiveDeliveryRunByUserID(ctx, user.ID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error fetching active delivery run: %v", err)
+		}
+		return
+	}
+
+	if run.Status != models.DeliveryRunStatusArmed {
+		return
+	}
+
+	abortRun(ctx, repo, r, run, details)
+}
+
+// abortRun marks an armed delivery run as aborted and records it in the audit log
+func abortRun(ctx context.Context, repo storage.Repository, r *http.Request, run *models.DeliveryRun, details string) {
+	now := time.Now().UTC()
+	run.Status = models.DeliveryRunStatusAborted
+	run.CompletedAt = &now
+	if err := repo.UpdateDeliveryRun(ctx, run); err != nil {
+		log.Printf("Error aborting delivery run %s: %v", run.ID, err)
+		return
+	}
+
+	auditLog := &models.AuditLog{
+		ID:        uuid.New().String(),
+		UserID:    run.UserID,
+		Action:    "switch_trigger_aborted",
+		Timestamp: now,
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	}
+
+	if err := repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log for aborted switch: %v", err)
+		// Continue anyway, the switch is aborted
+	}
+}
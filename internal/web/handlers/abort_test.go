@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// setupAbortTest creates a user whose switch is armed with the abort code "abc123"
+func setupAbortTest(t *testing.T) (*storage.MockRepository, *AbortHandler) {
+	t.Helper()
+
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+
+	startedAt := time.Now().UTC().Add(-2 * time.Hour)
+	repo.Users = append(repo.Users, &models.User{
+		ID:             "user123",
+		Email:          "test@example.com",
+		LastActivity:   time.Now().UTC().Add(-15 * 24 * time.Hour),
+		PingFrequency:  7,
+		PingDeadline:   14,
+		PingingEnabled: true,
+	})
+	repo.DeliveryRuns = append(repo.DeliveryRuns, &models.DeliveryRun{
+		ID:        "run1",
+		UserID:    "user123",
+		Status:    models.DeliveryRunStatusArmed,
+		StartedAt: startedAt,
+		FiresAt:   startedAt.Add(24 * time.Hour),
+		AbortCode: "abc123",
+	})
+
+	return repo, NewAbortHandler(repo, delivery.NewSealer(repo, nil, nil))
+}
+
+func TestHandleAbort(t *testing.T) {
+	repo, handler := setupAbortTest(t)
+
+	rr := httptest.NewRecorder()
+	handler.HandleAbort(rr, newCodeRequest("/abort/", "abc123"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	run := repo.DeliveryRuns[0]
+	if run.Status != models.DeliveryRunStatusAborted || run.CompletedAt == nil {
+		t.Errorf("Expected the run to be aborted, got status %q", run.Status)
+	}
+
+	if time.Since(repo.Users[0].LastActivity) > time.Minute {
+		t.Errorf("Expected last activity to be reset, got %v", repo.Users[0].LastActivity)
+	}
+
+	if len(repo.AuditLogs) != 1 || repo.AuditLogs[0].Action != "switch_trigger_aborted" {
+		t.Errorf("Expected a switch_trigger_aborted audit log entry, got %d entries", len(repo.AuditLogs))
+	}
+
+	// Opening the link again only shows the result
+	lastActivity := repo.Users[0].LastActivity
+	rr = httptest.NewRecorder()
+	handler.HandleAbort(rr, newCodeRequest("/abort/", "abc123"))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for an aborted run, got %d", rr.Code)
+	}
+	if !repo.Users[0].LastActivity.Equal(lastActivity) || len(repo.AuditLogs) != 1 {
+		t.Error("Expected opening the link again to change nothing")
+	}
+}
+
+func TestHandleAbortInvalid(t *testing.T) {
+	repo, handler := setupAbortTest(t)
+
+	rr := httptest.NewRecorder()
+	handler.HandleAbort(rr, newCodeRequest("/abort/", "nope"))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown code, got %d", rr.Code)
+	}
+
+	// Once the grace period is over the link can't stop the release
+	repo.DeliveryRuns[0].Status = models.DeliveryRunStatusInProgress
+	rr = httptest.NewRecorder()
+	handler.HandleAbort(rr, newCodeRequest("/abort/", "abc123"))
+	if rr.Code != http.StatusGone {
+		t.Errorf("Expected status 410 for a fired switch, got %d", rr.Code)
+	}
+}
+
+func TestCheckInAbortsArmedSwitch(t *testing.T) {
+	repo, _ := setupAbortTest(t)
+	user := repo.Users[0]
+
+	req := httptest.NewRequest("POST", "/api/check-in", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, user))
+
+	rr := httptest.NewRecorder()
+	NewAPIHandler(repo, delivery.NewSealer(repo, nil, nil)).HandleCheckIn(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if status := repo.DeliveryRuns[0].Status; status != models.DeliveryRunStatusAborted {
+		t.Errorf("Expected the check-in to abort the armed switch, got status %q", status)
+	}
+}
@@ -0,0 +1,536 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/files"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+const (
+	// MaxAccessCodeFailures is how many unknown access links one address may try
+	MaxAccessCodeFailures = 10
+	// AccessCodeBlockDuration is how long the access portal stays blocked for the address after that
+	AccessCodeBlockDuration = 15 * time.Minute
+)
+
+// accessFailures counts the unknown access links tried from one address
+type accessFailures struct {
+	count        int
+	blockedUntil time.Time
+	lastFailure  time.Time
+}
+
+// AccessHandler handles the portal where recipients open delivered secrets
+type AccessHandler struct {
+	repo     storage.Repository
+	sealer   *delivery.Sealer
+	files    *files.Store
+	failures map[string]*accessFailures // Unknown access links, keyed by client address
+	mutex    sync.Mutex                 // Mutex to protect the failures map
+}
+
+// NewAccessHandler creates a new AccessHandler
+func NewAccessHandler(repo storage.Repository, sealer *delivery.Sealer) *AccessHandler {
+	return &AccessHandler{
+		repo:     repo,
+		sealer:   sealer,
+		failures: make(map[string]*accessFailures),
+	}
+}
+
+// SetFileStore lets recipients download the files of file secrets
+func (h *AccessHandler) SetFileStore(store *files.Store) {
+	h.files = store
+}
+
+// HandleAccessForm handles the access portal page where the recipient confirms their email address
+func (h *AccessHandler) HandleAccessForm(w http.ResponseWriter, r *http.Request) {
+	// Get the access code from the URL
+	code := r.PathValue("code")
+	if code == "" {
+		http.Error(w, "Access code is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.verifyAccessCode(w, r, code); !ok {
+		return
+	}
+
+	h.renderAccess(w, http.StatusOK, map[string]interface{}{
+		"Code": code,
+	})
+}
+
+// HandleAccess handles the access portal form submission and shows the recipient's secrets
+func (h *AccessHandler) HandleAccess(w http.ResponseWriter, r *http.Request) {
+	// Get the access code from the URL
+	code := r.PathValue("code")
+	if code == "" {
+		http.Error(w, "Access code is required", http.StatusBadRequest)
+		return
+	}
+
+	accessCode, ok := h.verifyAccessCode(w, r, code)
+	if !ok {
+		return
+	}
+
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	recipient, err := h.repo.GetRecipientByID(ctx, accessCode.RecipientID)
+	if err != nil {
+		http.Error(w, "Error fetching recipient", http.StatusInternalServerError)
+		log.Printf("Error fetching recipient: %v", err)
+		return
+	}
+
+	// The link alone is not enough, the recipient also has to know their email address
+	emailAddress := strings.TrimSpace(r.FormValue("email"))
+	if !strings.EqualFold(emailAddress, recipient.Email) {
+		remaining := accessCode.MaxAttempts - accessCode.AttemptCount - 1
+		if err := h.repo.IncrementAccessCodeAttempts(ctx, accessCode.ID); err != nil {
+			log.Printf("Error incrementing access code attempts: %v", err)
+		}
+
+		h.createAuditLog(ctx, accessCode.UserID, "access_code_failed",
+			fmt.Sprintf("Failed access attempt for recipient: %s", recipient.Name))
+
+		if remaining <= 0 {
+			http.Error(w, "This access link has been locked after too many failed attempts", http.StatusForbidden)
+			return
+		}
+
+		h.renderAccess(w, http.StatusUnauthorized, map[string]interface{}{
+			"Code":  code,
+			"Error": fmt.Sprintf("The email address does not match. %d attempts remaining.", remaining),
+		})
+		return
+	}
+
+	// Recipients with personal questions have to answer them before their copies can be opened
+	var questionIdentity string
+	answers := r.Form["answer"]
+	if recipient.HasQuestions() {
+		var ok bool
+		if questionIdentity, ok = h.answerQuestions(ctx, w, code, emailAddress, accessCode, recipient, answers); !ok {
+			return
+		}
+	}
+
+	// Collect the secrets assigned to this recipient
+	assignments, err := h.repo.ListSecretAssignmentsByRecipientID(ctx, recipient.ID)
+	if err != nil {
+		http.Error(w, "Error fetching secret assignments", http.StatusInternalServerError)
+		log.Printf("Error fetching secret assignments: %v", err)
+		return
+	}
+
+	// Files are downloaded with the same form that opened the secrets, so the checks above apply
+	if downloadID := r.FormValue("download"); downloadID != "" {
+		h.downloadFile(ctx, w, accessCode, recipient, assignments, downloadID, questionIdentity)
+		return
+	}
+
+	shareSecretID := r.FormValue("secret_id")
+	share := strings.TrimSpace(r.FormValue("share"))
+
+	secrets := make([]map[string]interface{}, 0, len(assignments))
+	for _, assignment := range assignments {
+		secret, err := h.repo.GetSecretByID(ctx, assignment.SecretID)
+		if err != nil {
+			log.Printf("Error fetching secret %s: %v", assignment.SecretID, err)
+			continue
+		}
+
+		// Only deliver secrets of the user this access code was issued for
+		if secret.UserID != accessCode.UserID {
+			continue
+		}
+
+		entry := map[string]interface{}{
+			"ID":   secret.ID,
+			"Name": secret.Name,
+			// The content is an envelope the recipient decrypts in the browser
+			"ClientEncrypted": secret.IsClientEncrypted(),
+		}
+
+		if secret.IsFile() {
+			entry["File"] = true
+			entry["FileSize"] = secret.FileSize
+		}
+
+		if secret.IsQuorumProtected() {
+			h.openQuorumSecret(ctx, entry, secret, recipient, shareSecretID, share)
+		} else {
+			content, err := h.sealer.OpenFor(recipient, assignment.DeliveryData, questionIdentity)
+			if err != nil {
+				log.Printf("Error opening recipient copy of secret %s: %v", secret.ID, err)
+				entry["Error"] = "This secret can't be opened. Please contact the service administrator."
+			} else {
+				setSecretContent(entry, secret, content, recipient.PublicKey != "")
+			}
+		}
+
+		secrets = append(secrets, entry)
+	}
+
+	// Record the view on the access code and the delivery event
+	if accessCode.UsedAt == nil {
+		if err := h.repo.MarkAccessCodeAsUsed(ctx, accessCode.ID); err != nil {
+			log.Printf("Error marking access code as used: %v", err)
+		}
+	}
+	h.markDeliveryViewed(ctx, accessCode)
+
+	h.createAuditLog(ctx, accessCode.UserID, "secrets_accessed",
+		fmt.Sprintf("Recipient %s opened %d secrets", recipient.Name, len(secrets)))
+
+	h.renderAccess(w, http.StatusOK, map[string]interface{}{
+		"Code":      code,
+		"Email":     emailAddress,
+		"Recipient": recipient.Name,
+		"Message":   recipient.Message,
+		"Secrets":   secrets,
+		"Answers":   answers,
+		"Unlocked":  true,
+	})
+}
+
+// downloadFile sends the file of a file secret assigned to the recipient.
+// Recipients with a public key get the file as it is stored, encrypted to the
+// identity in their copy of the secret, everyone else gets it decrypted.
+func (h *AccessHandler) downloadFile(ctx context.Context, w http.ResponseWriter, accessCode *models.AccessCode, recipient *models.Recipient, assignments []*models.SecretAssignment, secretID, questionIdentity string) {
+	var assignment *models.SecretAssignment
+	for _, a := range assignments {
+		if a.SecretID == secretID {
+			assignment = a
+			break
+		}
+	}
+
+	var secret *models.Secret
+	if assignment != nil && h.files != nil {
+		var err error
+		if secret, err = h.repo.GetSecretByID(ctx, secretID); err != nil {
+			log.Printf("Error fetching secret %s: %v", secretID, err)
+		}
+	}
+	if secret == nil || secret.UserID != accessCode.UserID || !secret.IsFile() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	h.createAuditLog(ctx, accessCode.UserID, "secret_file_downloaded",
+		fmt.Sprintf("Recipient %s downloaded the file of secret: %s", recipient.Name, secret.Name))
+
+	if recipient.PublicKey != "" && !secret.IsQuorumProtected() {
+		if err := serveEncryptedFile(w, h.files, secret.FileRef, secret.Name); err != nil {
+			http.Error(w, "Error fetching file", http.StatusInternalServerError)
+			log.Printf("Error fetching file of secret %s: %v", secret.ID, err)
+		}
+		return
+	}
+
+	var content []byte
+	var err error
+	if secret.IsQuorumProtected() {
+		content, _, err = h.sealer.Combine(ctx, secret)
+	} else {
+		content, err = h.sealer.OpenFor(recipient, assignment.DeliveryData, questionIdentity)
+	}
+	if errors.Is(err, delivery.ErrQuorumNotMet) {
+		http.Error(w, "Not enough recipients have submitted their shares yet", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "This file can't be opened. Please contact the service administrator.", http.StatusInternalServerError)
+		log.Printf("Error opening recipient copy of secret %s: %v", secret.ID, err)
+		return
+	}
+
+	file, err := files.Parse(content)
+	if err == nil {
+		err = serveSecretFile(w, h.files, secret.FileRef, file)
+	}
+	if err != nil {
+		http.Error(w, "This file can't be opened. Please contact the service administrator.", http.StatusInternalServerError)
+		log.Printf("Error decrypting file of secret %s: %v", secret.ID, err)
+	}
+}
+
+// answerQuestions asks a recipient their personal questions and rebuilds their
+// question key from the answers. Wrong answers count as failed attempts just
+// like a wrong email address. It writes a response and returns false if the
+// recipient can't continue yet.
+func (h *AccessHandler) answerQuestions(ctx context.Context, w http.ResponseWriter, code, emailAddress string, accessCode *models.AccessCode, recipient *models.Recipient, answers []string) (string, bool) {
+	data := map[string]interface{}{
+		"Code":      code,
+		"Email":     emailAddress,
+		"Recipient": recipient.Name,
+		"Threshold": recipient.QuestionThreshold,
+	}
+
+	// Timelocked questions can't be asked before the owner's deadline
+	questions, err := h.sealer.OpenQuestions(ctx, recipient)
+	if errors.Is(err, delivery.ErrQuestionsLocked) {
+		opensAt, err := h.sealer.QuestionsOpenAt(ctx, recipient.ID)
+		if err != nil {
+			log.Printf("Error fetching question timelocks: %v", err)
+		}
+		data["QuestionsLocked"] = true
+		data["QuestionsOpenAt"] = opensAt
+		h.renderAccess(w, http.StatusOK, data)
+		return "", false
+	}
+	if err != nil {
+		http.Error(w, "Error fetching questions", http.StatusInternalServerError)
+		log.Printf("Error fetching questions: %v", err)
+		return "", false
+	}
+	data["Questions"] = questions
+
+	// The email address was just confirmed, the questions haven't been asked yet
+	answered := false
+	for _, answer := range answers {
+		if strings.TrimSpace(answer) != "" {
+			answered = true
+			break
+		}
+	}
+	if !answered {
+		h.renderAccess(w, http.StatusOK, data)
+		return "", false
+	}
+
+	identity, err := h.sealer.OpenWithAnswers(ctx, recipient, answers)
+	if err == nil {
+		return identity, true
+	}
+	if !errors.Is(err, crypto.ErrNotEnoughAnswers) {
+		http.Error(w, "Error checking answers", http.StatusInternalServerError)
+		log.Printf("Error checking answers of recipient %s: %v", recipient.ID, err)
+		return "", false
+	}
+
+	remaining := accessCode.MaxAttempts - accessCode.AttemptCount - 1
+	if err := h.repo.IncrementAccessCodeAttempts(ctx, accessCode.ID); err != nil {
+		log.Printf("Error incrementing access code attempts: %v", err)
+	}
+
+	h.createAuditLog(ctx, accessCode.UserID, "access_questions_failed",
+		fmt.Sprintf("Wrong answers to the personal questions of recipient: %s", recipient.Name))
+
+	if remaining <= 0 {
+		http.Error(w, "This access link has been locked after too many failed attempts", http.StatusForbidden)
+		return "", false
+	}
+
+	// Don't tell how many answers were right, that would let answers be guessed one by one
+	data["Error"] = fmt.Sprintf("Not enough answers are correct. %d attempts remaining.", remaining)
+	h.renderAccess(w, http.StatusUnauthorized, data)
+	return "", false
+}
+
+// openQuorumSecret submits the recipient's share if one was entered and tries
+// to rebuild a quorum protected secret from the shares submitted so far
+func (h *AccessHandler) openQuorumSecret(ctx context.Context, entry map[string]interface{}, secret *models.Secret, recipient *models.Recipient, shareSecretID, share string) {
+	entry["Quorum"] = secret.QuorumThreshold
+
+	var content []byte
+	var remaining int
+	var err error
+	if share != "" && shareSecretID == secret.ID {
+		content, remaining, err = h.sealer.SubmitShare(ctx, secret, recipient.ID, share)
+		if err == nil || errors.Is(err, delivery.ErrQuorumNotMet) {
+			h.createAuditLog(ctx, secret.UserID, "quorum_share_submitted",
+				fmt.Sprintf("Recipient %s submitted a share for secret: %s", recipient.Name, secret.Name))
+		}
+	} else {
+		content, remaining, err = h.sealer.Combine(ctx, secret)
+	}
+
+	switch {
+	case err == nil:
+		setSecretContent(entry, secret, content, false)
+	case errors.Is(err, delivery.ErrQuorumNotMet):
+		entry["Remaining"] = remaining
+	case errors.Is(err, crypto.ErrInvalidShare):
+		entry["Error"] = "One of the submitted shares is not valid. Please check your share and submit it again."
+	default:
+		log.Printf("Error opening quorum secret %s: %v", secret.ID, err)
+		entry["Error"] = "This secret can't be opened. Please contact the service administrator."
+	}
+}
+
+// setSecretContent adds the opened content of a secret to its entry. The
+// fields of a typed secret are shown one by one, unless the content is
+// encrypted to the recipient's public key.
+func setSecretContent(entry map[string]interface{}, secret *models.Secret, content []byte, encrypted bool) {
+	entry["Content"] = string(content)
+	entry["Encrypted"] = encrypted
+
+	typ := secretType(secret)
+	if encrypted || secret.IsFile() || secret.IsClientEncrypted() || typ.IsNote() {
+		return
+	}
+
+	values, err := typ.Decode(content)
+	if err != nil {
+		log.Printf("Error reading secret %s: %v", secret.ID, err)
+		return
+	}
+	entry["Typed"] = secretTypeData(typ, values, false)
+}
+
+// verifyAccessCode checks the access code and writes an error response if it can't be used.
+// Addresses that tried too many unknown codes are turned away before anything is looked up.
+func (h *AccessHandler) verifyAccessCode(w http.ResponseWriter, r *http.Request, code string) (*models.AccessCode, bool) {
+	address := clientAddress(r)
+	if h.accessBlocked(address) {
+		http.Error(w, "Too many invalid access links, please try again later", http.StatusTooManyRequests)
+		return nil, false
+	}
+
+	accessCode, err := h.lookupAccessCode(r.Context(), code)
+	switch {
+	case err == nil:
+		return accessCode, true
+	case errors.Is(err, storage.ErrNotFound):
+		h.failedAccess(address)
+		http.Error(w, "This access link is invalid or has expired", http.StatusNotFound)
+	case errors.Is(err, storage.ErrAccessCodeLocked):
+		http.Error(w, "This access link has been locked after too many failed attempts", http.StatusForbidden)
+	default:
+		http.Error(w, "Error verifying access code", http.StatusInternalServerError)
+		log.Printf("Error verifying access code: %v", err)
+	}
+	return nil, false
+}
+
+// lookupAccessCode finds an access code by its keyed hash, with the current or a
+// previous master key, and falls back to the codes stored before the hash was keyed
+func (h *AccessHandler) lookupAccessCode(ctx context.Context, code string) (*models.AccessCode, error) {
+	for _, codeHash := range h.sealer.AccessCodeHashes(code) {
+		accessCode, err := h.repo.VerifyAccessCodeHash(ctx, codeHash)
+		if !errors.Is(err, storage.ErrNotFound) {
+			return accessCode, err
+		}
+	}
+	return h.repo.VerifyAccessCode(ctx, code)
+}
+
+// accessBlocked reports whether an address is blocked after too many unknown access links
+func (h *AccessHandler) accessBlocked(address string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	failures, ok := h.failures[address]
+	return ok && time.Now().Before(failures.blockedUntil)
+}
+
+// failedAccess records an unknown access link tried from an address. The count
+// starts over once a block ends or after a block duration without failures.
+func (h *AccessHandler) failedAccess(address string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	for key, failures := range h.failures {
+		if now.Sub(failures.lastFailure) > AccessCodeBlockDuration && now.After(failures.blockedUntil) {
+			delete(h.failures, key)
+		}
+	}
+
+	failures, ok := h.failures[address]
+	if !ok || (!failures.blockedUntil.IsZero() && now.After(failures.blockedUntil)) {
+		failures = &accessFailures{}
+		h.failures[address] = failures
+	}
+
+	failures.count++
+	failures.lastFailure = now
+	if failures.count >= MaxAccessCodeFailures {
+		failures.blockedUntil = now.Add(AccessCodeBlockDuration)
+	}
+}
+
+// clientAddress returns the IP address a request came from
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// markDeliveryViewed sets the delivery event of an access code to viewed
+func (h *AccessHandler) markDeliveryViewed(ctx context.Context, accessCode *models.AccessCode) {
+	events, err := h.repo.ListDeliveryEventsByUserID(ctx, accessCode.UserID)
+	if err != nil {
+		log.Printf("Error fetching delivery events: %v", err)
+		return
+	}
+
+	for _, event := range events {
+		if event.ID != accessCode.DeliveryEventID {
+			continue
+		}
+
+		event.Status = models.DeliveryStatusViewed
+		if err := h.repo.UpdateDeliveryEvent(ctx, event); err != nil {
+			log.Printf("Error updating delivery event: %v", err)
+		}
+		return
+	}
+}
+
+// createAuditLog records an event in the owner's audit log
+func (h *AccessHandler) createAuditLog(ctx context.Context, userID, action, details string) {
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		Action:    action,
+		Timestamp: time.Now(),
+		Details:   details,
+	}
+
+	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+}
+
+// renderAccess renders the access portal page
+func (h *AccessHandler) renderAccess(w http.ResponseWriter, status int, data map[string]interface{}) {
+	// Decrypted secrets must not end up in any cache
+	w.Header().Set("Cache-Control", "no-store")
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+
+	if err := templates.RenderTemplate(w, "access.html", templates.TemplateData{
+		Title: "Access Confidential Information",
+		Data:  data,
+	}); err != nil {
+		log.Printf("Error rendering access template: %v", err)
+	}
+}
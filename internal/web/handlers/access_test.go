@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/files"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// setupAccessTest creates a delivered secret for a recipient and returns the handler
+func setupAccessTest(t *testing.T) (*storage.MockRepository, *AccessHandler, *models.AccessCode) {
+	t.Helper()
+
+	// Render the real templates so the page content can be checked
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+	sealer := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil)
+
+	recipient := &models.Recipient{
+		ID:     "recipient1",
+		UserID: "user123",
+		Name:   "Test Recipient",
+		Email:  "recipient@example.com",
+	}
+	repo.Recipients = append(repo.Recipients, recipient)
+
+	secret := &models.Secret{ID: "secret1", UserID: "user123", Name: "Bank login"}
+	repo.Secrets = append(repo.Secrets, secret)
+	repo.SecretAssignments = append(repo.SecretAssignments, &models.SecretAssignment{
+		ID:          "assignment1",
+		SecretID:    secret.ID,
+		RecipientID: recipient.ID,
+		UserID:      "user123",
+	})
+	if err := sealer.Reseal(context.Background(), secret, []byte("hunter2")); err != nil {
+		t.Fatalf("Failed to seal secret: %v", err)
+	}
+
+	repo.DeliveryEvents = append(repo.DeliveryEvents, &models.DeliveryEvent{
+		ID:          "event1",
+		UserID:      "user123",
+		RecipientID: recipient.ID,
+		Status:      "sent",
+	})
+
+	accessCode := &models.AccessCode{
+		ID:              "code1",
+		Code:            sealer.HashAccessCode("the-code"),
+		RecipientID:     recipient.ID,
+		UserID:          "user123",
+		DeliveryEventID: "event1",
+		ExpiresAt:       time.Now().Add(time.Hour),
+		MaxAttempts:     2,
+	}
+	repo.AccessCodes = append(repo.AccessCodes, accessCode)
+
+	return repo, NewAccessHandler(repo, sealer), accessCode
+}
+
+func newAccessRequest(method, email string) *http.Request {
+	form := url.Values{}
+	form.Set("email", email)
+
+	req := newFormRequest(method, "/access/the-code", form)
+	req.SetPathValue("code", "the-code")
+	return req
+}
+
+// TestHandleAccess tests that a recipient can open their secrets
+func TestHandleAccess(t *testing.T) {
+	repo, handler, accessCode := setupAccessTest(t)
+
+	rr := httptest.NewRecorder()
+	handler.HandleAccess(rr, newAccessRequest("POST", "Recipient@Example.com"))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), "hunter2") {
+		t.Error("Expected the decrypted secret on the page")
+	}
+
+	if cache := rr.Header().Get("Cache-Control"); cache != "no-store" {
+		t.Errorf("Expected Cache-Control no-store, got %q", cache)
+	}
+
+	// Check that the view was recorded
+	if repo.DeliveryEvents[0].Status != "viewed" {
+		t.Errorf("Expected delivery event status 'viewed', got '%s'", repo.DeliveryEvents[0].Status)
+	}
+	if accessCode.UsedAt == nil {
+		t.Fatal("Expected the access code to be marked as used")
+	}
+	firstUse := *accessCode.UsedAt
+
+	// The link keeps working and remembers the first view
+	rr = httptest.NewRecorder()
+	handler.HandleAccess(rr, newAccessRequest("POST", "recipient@example.com"))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code for a second view: got %v want %v", status, http.StatusOK)
+	}
+	if !accessCode.UsedAt.Equal(firstUse) {
+		t.Errorf("Expected the first view to be kept, got %v", accessCode.UsedAt)
+	}
+
+	found := false
+	for _, l := range repo.AuditLogs {
+		if l.Action == "secrets_accessed" && l.UserID == "user123" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected a secrets_accessed audit log entry for the owner")
+	}
+}
+
+// TestHandleAccessWrongEmail tests that a wrong email address counts as a failed attempt
+func TestHandleAccessWrongEmail(t *testing.T) {
+	repo, handler, accessCode := setupAccessTest(t)
+
+	rr := httptest.NewRecorder()
+	handler.HandleAccess(rr, newAccessRequest("POST", "someone@example.com"))
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	if strings.Contains(rr.Body.String(), "hunter2") {
+		t.Error("Secret must not be shown for a wrong email address")
+	}
+	if accessCode.AttemptCount != 1 {
+		t.Errorf("Expected 1 failed attempt, got %d", accessCode.AttemptCount)
+	}
+	if repo.DeliveryEvents[0].Status != "sent" {
+		t.Errorf("Expected delivery event status to stay 'sent', got '%s'", repo.DeliveryEvents[0].Status)
+	}
+
+	// The second failure locks the code
+	rr = httptest.NewRecorder()
+	handler.HandleAccess(rr, newAccessRequest("POST", "someone@example.com"))
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+
+	// Even the right email address no longer works
+	rr = httptest.NewRecorder()
+	handler.HandleAccess(rr, newAccessRequest("POST", "recipient@example.com"))
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+}
+
+// TestHandleAccessExpired tests that an expired code is rejected
+func TestHandleAccessExpired(t *testing.T) {
+	_, handler, accessCode := setupAccessTest(t)
+	accessCode.ExpiresAt = time.Now().Add(-time.Hour)
+
+	rr := httptest.NewRecorder()
+	handler.HandleAccessForm(rr, newAccessRequest("GET", ""))
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+// TestHandleAccessUnknownCodes tests that an address trying unknown codes is blocked for a while
+func TestHandleAccessUnknownCodes(t *testing.T) {
+	_, handler, _ := setupAccessTest(t)
+
+	request := func(code, address string) int {
+		req := httptest.NewRequest("GET", "/access/"+code, nil)
+		req.SetPathValue("code", code)
+		req.RemoteAddr = address
+		rr := httptest.NewRecorder()
+		handler.HandleAccessForm(rr, req)
+		return rr.Code
+	}
+
+	for i := 0; i < MaxAccessCodeFailures; i++ {
+		if status := request("guess", "192.0.2.1:1234"); status != http.StatusNotFound {
+			t.Fatalf("Expected status 404 for an unknown code, got %d", status)
+		}
+	}
+
+	// Even the right code is turned away from that address, other addresses still get in
+	if status := request("the-code", "192.0.2.1:5678"); status != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 after too many unknown codes, got %d", status)
+	}
+	if status := request("the-code", "198.51.100.7:1234"); status != http.StatusOK {
+		t.Errorf("Expected status 200 from another address, got %d", status)
+	}
+}
+
+// TestHandleAccessOlderCodes tests that codes hashed with a previous master key or
+// stored before the hash was keyed keep working
+func TestHandleAccessOlderCodes(t *testing.T) {
+	repo, _, accessCode := setupAccessTest(t)
+
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	sealer := delivery.NewSealer(repo, []byte("fedcba9876543210fedcba9876543210"), nil)
+	sealer.SetPreviousMasterKeys([][]byte{oldKey})
+	handler := NewAccessHandler(repo, sealer)
+
+	legacyHash, err := crypto.HashPassword("legacy-code", nil)
+	if err != nil {
+		t.Fatalf("Failed to hash code: %v", err)
+	}
+	legacy := *accessCode
+	legacy.ID = "code2"
+	legacy.Code = base64.StdEncoding.EncodeToString(legacyHash)
+	repo.AccessCodes = append(repo.AccessCodes, &legacy)
+
+	for _, code := range []string{"the-code", "legacy-code"} {
+		req := httptest.NewRequest("GET", "/access/"+code, nil)
+		req.SetPathValue("code", code)
+		rr := httptest.NewRecorder()
+		handler.HandleAccessForm(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 for %s, got %d", code, rr.Code)
+		}
+	}
+}
+
+// TestHandleAccessQuestions tests that a recipient with personal questions has to answer them
+func TestHandleAccessQuestions(t *testing.T) {
+	repo, handler, accessCode := setupAccessTest(t)
+	ctx := context.Background()
+
+	key, lockedShares, err := crypto.NewAnswerLock([]string{"Paris", "Rex", "Blue"}, 2)
+	if err != nil {
+		t.Fatalf("Failed to create answer lock: %v", err)
+	}
+	recipient := repo.Recipients[0]
+	recipient.QuestionThreshold = 2
+	recipient.QuestionKey = key
+	if err := repo.ReplaceRecipientQuestions(ctx, recipient.ID, []*models.RecipientQuestion{
+		{UserID: "user123", Question: "Where did we meet?", LockedShare: lockedShares[0]},
+		{UserID: "user123", Question: "Name of our first dog?", LockedShare: lockedShares[1]},
+		{UserID: "user123", Question: "Favourite colour?", LockedShare: lockedShares[2]},
+	}); err != nil {
+		t.Fatalf("Failed to store questions: %v", err)
+	}
+	if err := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil).Reseal(ctx, repo.Secrets[0], []byte("hunter2")); err != nil {
+		t.Fatalf("Failed to seal secret: %v", err)
+	}
+
+	newAnswersRequest := func(answers ...string) *http.Request {
+		form := url.Values{"email": {"recipient@example.com"}, "answer": answers}
+		req := newFormRequest("POST", "/access/the-code", form)
+		req.SetPathValue("code", "the-code")
+		return req
+	}
+
+	// The right email address leads to the questions, which is not a failed attempt
+	rr := httptest.NewRecorder()
+	handler.HandleAccess(rr, newAccessRequest("POST", "recipient@example.com"))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "Name of our first dog?") || strings.Contains(rr.Body.String(), "hunter2") {
+		t.Error("Expected the questions and not the secret on the page")
+	}
+	if accessCode.AttemptCount != 0 {
+		t.Errorf("Expected no failed attempts, got %d", accessCode.AttemptCount)
+	}
+
+	// One right answer is not enough and counts as a failed attempt
+	rr = httptest.NewRecorder()
+	handler.HandleAccess(rr, newAnswersRequest("paris", "Max", ""))
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	if strings.Contains(rr.Body.String(), "hunter2") {
+		t.Error("Secret must not be shown for wrong answers")
+	}
+	if accessCode.AttemptCount != 1 {
+		t.Errorf("Expected 1 failed attempt, got %d", accessCode.AttemptCount)
+	}
+
+	// Two right answers open the secret
+	rr = httptest.NewRecorder()
+	handler.HandleAccess(rr, newAnswersRequest("", " rex", "BLUE"))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "hunter2") {
+		t.Error("Expected the decrypted secret on the page")
+	}
+}
+
+// TestHandleAccessQuestionsTimelocked tests that timelocked questions are only asked after the owner's deadline
+func TestHandleAccessQuestionsTimelocked(t *testing.T) {
+	repo, _, accessCode := setupAccessTest(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	timelock, err := crypto.NewLocalTimelock(bytes.Repeat([]byte{7}, 32), func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("Failed to create timelock: %v", err)
+	}
+	sealer := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), timelock)
+	handler := NewAccessHandler(repo, sealer)
+
+	vaultKey := []byte("abcdef0123456789abcdef0123456789")
+	repo.Users = append(repo.Users, &models.User{ID: "user123", LastActivity: now, PingDeadline: 14})
+	if repo.Secrets[0].EncryptedData, err = crypto.EncryptSecret([]byte("hunter2"), vaultKey); err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+	if err := sealer.SetQuestions(ctx, repo.Recipients[0], []string{"Where did we meet?", "Name of our first dog?"}, []string{"Paris", "Rex"}, 2, vaultKey); err != nil {
+		t.Fatalf("Failed to set questions: %v", err)
+	}
+
+	newAnswersRequest := func(answers ...string) *http.Request {
+		form := url.Values{"email": {"recipient@example.com"}, "answer": answers}
+		req := newFormRequest("POST", "/access/the-code", form)
+		req.SetPathValue("code", "the-code")
+		return req
+	}
+
+	// Before the deadline neither the questions nor the secret are shown, and answers are not checked
+	for _, req := range []*http.Request{newAccessRequest("POST", "recipient@example.com"), newAnswersRequest("Paris", "Rex")} {
+		rr := httptest.NewRecorder()
+		handler.HandleAccess(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		body := rr.Body.String()
+		if !strings.Contains(body, "Not Open Yet") || strings.Contains(body, "Name of our first dog?") || strings.Contains(body, "hunter2") {
+			t.Error("Expected the timelock notice and neither the questions nor the secret")
+		}
+	}
+	if accessCode.AttemptCount != 0 {
+		t.Errorf("Expected no failed attempts, got %d", accessCode.AttemptCount)
+	}
+
+	// After the deadline the questions open and the answers open the secret
+	now = now.Add(15 * 24 * time.Hour)
+	rr := httptest.NewRecorder()
+	handler.HandleAccess(rr, newAccessRequest("POST", "recipient@example.com"))
+	if !strings.Contains(rr.Body.String(), "Name of our first dog?") {
+		t.Error("Expected the questions on the page")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleAccess(rr, newAnswersRequest("paris", "REX"))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "hunter2") {
+		t.Error("Expected the decrypted secret on the page")
+	}
+}
+
+// TestHandleAccessFileDownload tests that a recipient can download the file of a file secret
+func TestHandleAccessFileDownload(t *testing.T) {
+	repo, handler, _ := setupAccessTest(t)
+	sealer := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil)
+
+	store, err := files.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	handler.SetFileStore(store)
+
+	data := bytes.Repeat([]byte("scan"), 1000)
+	ref, file, err := store.Encrypt(bytes.NewReader(data), "will.pdf", "application/pdf", 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to store file: %v", err)
+	}
+	content, _ := files.Marshal(file)
+
+	secret := &models.Secret{ID: "secret2", UserID: "user123", Name: "Will", FileRef: ref, FileSize: file.Size}
+	repo.Secrets = append(repo.Secrets, secret)
+	repo.SecretAssignments = append(repo.SecretAssignments, &models.SecretAssignment{
+		ID: "assignment2", SecretID: secret.ID, RecipientID: "recipient1", UserID: "user123",
+	})
+	if err := sealer.Reseal(context.Background(), secret, content); err != nil {
+		t.Fatalf("Failed to seal secret: %v", err)
+	}
+
+	// The page offers the download without showing the file key
+	rr := httptest.NewRecorder()
+	handler.HandleAccess(rr, newAccessRequest("POST", "recipient@example.com"))
+	if !strings.Contains(rr.Body.String(), "Download File") || strings.Contains(rr.Body.String(), file.Identity) {
+		t.Error("Expected a download button and no file key on the page")
+	}
+
+	download := func(secretID, email string) *httptest.ResponseRecorder {
+		req := newAccessRequest("POST", email)
+		req.Form = url.Values{"email": {email}, "download": {secretID}}
+		rr := httptest.NewRecorder()
+		handler.HandleAccess(rr, req)
+		return rr
+	}
+
+	rr = download(secret.ID, "recipient@example.com")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !bytes.Equal(rr.Body.Bytes(), data) {
+		t.Error("Downloaded file doesn't match")
+	}
+	if disposition := rr.Header().Get("Content-Disposition"); disposition != `attachment; filename=will.pdf` {
+		t.Errorf("Unexpected Content-Disposition %q", disposition)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/pdf" {
+		t.Errorf("Expected Content-Type application/pdf, got %q", contentType)
+	}
+
+	// Text secrets and unknown secrets can't be downloaded, the email address is still checked
+	if rr := download("secret1", "recipient@example.com"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a text secret, got %d", rr.Code)
+	}
+	if rr := download(secret.ID, "someone@example.com"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong email address, got %d", rr.Code)
+	}
+
+	// Recipients with a public key get the file as it is stored
+	identity, ageRecipient, _ := crypto.GenerateAgeIdentity()
+	repo.Recipients[0].PublicKey = ageRecipient
+	if err := sealer.Reseal(context.Background(), secret, content); err != nil {
+		t.Fatalf("Failed to seal secret: %v", err)
+	}
+
+	rr = download(secret.ID, "recipient@example.com")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if bytes.Contains(rr.Body.Bytes(), []byte("scan")) {
+		t.Error("Expected the file to stay encrypted for a recipient with a public key")
+	}
+	// The recipient decrypts their copy to get the identity of the file
+	opened, err := sealer.Open(repo.SecretAssignments[1].DeliveryData)
+	if err != nil {
+		t.Fatalf("Failed to open recipient copy: %v", err)
+	}
+	copyContent, err := crypto.DecryptWithAgeIdentity(opened, identity)
+	if err != nil {
+		t.Fatalf("Failed to decrypt recipient copy: %v", err)
+	}
+	copyFile, err := files.Parse(copyContent)
+	if err != nil {
+		t.Fatalf("Failed to parse recipient copy: %v", err)
+	}
+	decrypted, err := crypto.DecryptWithAgeIdentity(rr.Body.Bytes(), copyFile.Identity)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("Expected the download to decrypt with the identity in the recipient copy, got %v", err)
+	}
+}
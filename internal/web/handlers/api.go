@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 	"github.com/korjavin/deadmanswitch/internal/web/middleware"
@@ -14,13 +17,15 @@ import (
 
 // APIHandler handles API requests
 type APIHandler struct {
-	repo storage.Repository
+	repo   storage.Repository
+	sealer *delivery.Sealer
 }
 
 // NewAPIHandler creates a new APIHandler
-func NewAPIHandler(repo storage.Repository) *APIHandler {
+func NewAPIHandler(repo storage.Repository, sealer *delivery.Sealer) *APIHandler {
 	return &APIHandler{
-		repo: repo,
+		repo:   repo,
+		sealer: sealer,
 	}
 }
 
@@ -34,6 +39,35 @@ func (h *APIHandler) HandleCheckIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := recordCheckIn(ctx, h.repo, h.sealer, r, user); err != nil {
+		log.Printf("Error updating user last activity: %v", err)
+		http.Error(w, "Error updating user", http.StatusInternalServerError)
+		return
+	}
+
+	// Format the next check-in time for display
+	nextCheckInFormatted := user.NextScheduledPing.Format("Jan 2, 2006 15:04 MST")
+
+	// Calculate and format the deadline
+	deadline := user.LastActivity.AddDate(0, 0, user.PingDeadline)
+	deadlineFormatted := deadline.Format("Jan 2, 2006 15:04 MST")
+
+	// Return success response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"message":       "Check-in successful",
+		"next_check_in": user.NextScheduledPing.Format(time.RFC3339),
+		"nextCheckIn":   nextCheckInFormatted,
+		"deadline":      deadlineFormatted,
+	}); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// recordCheckIn resets the switch for the user and records the check-in in the
+// ping history and audit log. Check-ins made with an API token are attributed to it.
+func recordCheckIn(ctx context.Context, repo storage.Repository, sealer *delivery.Sealer, r *http.Request, user *models.User) error {
 	// Update the user's last activity time
 	user.LastActivity = time.Now()
 
@@ -45,10 +79,21 @@ func (h *APIHandler) HandleCheckIn(w http.ResponseWriter, r *http.Request) {
 		user.PingingEnabled = true
 	}
 
-	if err := h.repo.UpdateUser(ctx, user); err != nil {
-		log.Printf("Error updating user last activity: %v", err)
-		http.Error(w, "Error updating user", http.StatusInternalServerError)
-		return
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		return err
+	}
+	if err := sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		log.Printf("Error renewing question timelocks: %v", err)
+	}
+
+	abortArmedSwitch(ctx, repo, r, user, "Armed switch aborted by check-in")
+
+	// Record whether the check-in came from the web interface or from an API token
+	method := "web"
+	details := "Manual user check-in via web interface"
+	if token, ok := middleware.GetAPITokenFromContext(r); ok {
+		method = "api"
+		details = fmt.Sprintf("Check-in via API token %q (%s)", token.Name, token.ID)
 	}
 
 	// Create a ping history entry
@@ -56,12 +101,12 @@ func (h *APIHandler) HandleCheckIn(w http.ResponseWriter, r *http.Request) {
 		ID:          uuid.New().String(),
 		UserID:      user.ID,
 		SentAt:      time.Now().UTC(),
-		Method:      "web",
+		Method:      method,
 		Status:      "responded",
 		RespondedAt: &user.LastActivity,
 	}
 
-	if err := h.repo.CreatePingHistory(ctx, pingHistory); err != nil {
+	if err := repo.CreatePingHistory(ctx, pingHistory); err != nil {
 		log.Printf("Error creating ping history during check-in: %v", err)
 		// Non-fatal error, continue
 	}
@@ -74,30 +119,13 @@ func (h *APIHandler) HandleCheckIn(w http.ResponseWriter, r *http.Request) {
 		Timestamp: time.Now().UTC(),
 		IPAddress: r.RemoteAddr,
 		UserAgent: r.UserAgent(),
-		Details:   "Manual user check-in via web interface",
+		Details:   details,
 	}
 
-	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+	if err := repo.CreateAuditLog(ctx, auditLog); err != nil {
 		log.Printf("Error creating audit log for check-in: %v", err)
 		// Non-fatal error, continue
 	}
 
-	// Format the next check-in time for display
-	nextCheckInFormatted := user.NextScheduledPing.Format("Jan 2, 2006 15:04 MST")
-
-	// Calculate and format the deadline
-	deadline := user.LastActivity.AddDate(0, 0, user.PingDeadline)
-	deadlineFormatted := deadline.Format("Jan 2, 2006 15:04 MST")
-
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":       true,
-		"message":       "Check-in successful",
-		"next_check_in": user.NextScheduledPing.Format(time.RFC3339),
-		"nextCheckIn":   nextCheckInFormatted,
-		"deadline":      deadlineFormatted,
-	}); err != nil {
-		log.Printf("Failed to encode JSON response: %v", err)
-	}
+	return nil
 }
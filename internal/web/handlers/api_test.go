@@ -6,9 +6,11 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 
@@ -29,7 +31,7 @@ func TestHandleCheckInSuccess(t *testing.T) {
 	repo.Users = append(repo.Users, user)
 
 	// Create the handler
-	handler := NewAPIHandler(repo)
+	handler := NewAPIHandler(repo, delivery.NewSealer(repo, nil, nil))
 
 	// Create a test request
 	req := httptest.NewRequest("POST", "/api/check-in", nil)
@@ -118,12 +120,55 @@ func TestHandleCheckInSuccess(t *testing.T) {
 	}
 }
 
+func TestHandleCheckInWithAPIToken(t *testing.T) {
+	repo := storage.NewMockRepository()
+
+	user := &models.User{
+		ID:            "user123",
+		Email:         "test@example.com",
+		PingFrequency: 7,
+	}
+	repo.Users = append(repo.Users, user)
+
+	handler := NewAPIHandler(repo, delivery.NewSealer(repo, nil, nil))
+
+	// Authenticate the request with an API token
+	token := &models.APIToken{ID: "token123", UserID: user.ID, Name: "laptop cron"}
+	req := httptest.NewRequest("POST", "/api/check-in", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserContextKey, user)
+	ctx = context.WithValue(ctx, middleware.APITokenContextKey, token)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler.HandleCheckIn(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if len(repo.PingHistories) != 1 || repo.PingHistories[0].Method != "api" {
+		t.Errorf("Expected one ping history entry with method 'api', got %+v", repo.PingHistories)
+	}
+
+	// The audit log names the token that checked in
+	if len(repo.AuditLogs) != 1 {
+		t.Fatalf("Expected 1 audit log entry, got %d", len(repo.AuditLogs))
+	}
+	auditLog := repo.AuditLogs[0]
+	if auditLog.Action != "check_in" {
+		t.Errorf("Expected log action 'check_in', got '%s'", auditLog.Action)
+	}
+	if !strings.Contains(auditLog.Details, "laptop cron") || !strings.Contains(auditLog.Details, "token123") {
+		t.Errorf("Expected audit log details to name the token, got %q", auditLog.Details)
+	}
+}
+
 func TestHandleCheckInUnauthorized(t *testing.T) {
 	// Create mock repository
 	repo := storage.NewMockRepository()
 
 	// Create the handler
-	handler := NewAPIHandler(repo)
+	handler := NewAPIHandler(repo, delivery.NewSealer(repo, nil, nil))
 
 	// Create a test request with no user in context
 	req := httptest.NewRequest("POST", "/api/check-in", nil)
@@ -153,7 +198,7 @@ func TestHandleCheckInUpdateUserError(t *testing.T) {
 	}
 
 	// Create the handler
-	handler := NewAPIHandler(repo)
+	handler := NewAPIHandler(repo, delivery.NewSealer(repo, nil, nil))
 
 	// Create a test request
 	req := httptest.NewRequest("POST", "/api/check-in", nil)
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// maxAPITokenNameLength is the maximum length of an API token name
+const maxAPITokenNameLength = 100
+
+// APITokenHandler handles the management of personal API tokens
+type APITokenHandler struct {
+	repo storage.Repository
+}
+
+// NewAPITokenHandler creates a new APITokenHandler
+func NewAPITokenHandler(repo storage.Repository) *APITokenHandler {
+	return &APITokenHandler{
+		repo: repo,
+	}
+}
+
+// HandleListTokens handles the API token management page
+func (h *APITokenHandler) HandleListTokens(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.renderTokens(w, r, user, http.StatusOK, map[string]interface{}{})
+}
+
+// HandleCreateToken handles the creation of a new API token.
+// The token is shown once on the rendered page and only its hash is stored.
+func (h *APITokenHandler) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	scopes, err := parseAPITokenScopes(r.Form["scopes"])
+	if err == nil && name == "" {
+		err = fmt.Errorf("token name is required")
+	}
+	if err == nil && len(name) > maxAPITokenNameLength {
+		err = fmt.Errorf("token name must be at most %d characters", maxAPITokenNameLength)
+	}
+	if err != nil {
+		h.renderTokens(w, r, user, http.StatusBadRequest, map[string]interface{}{
+			"Error": err.Error(),
+		})
+		return
+	}
+
+	tokenString, tokenHash, err := auth.GenerateAPIToken()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		log.Printf("Error generating API token: %v", err)
+		return
+	}
+
+	token := &models.APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: tokenHash,
+		Scopes:    scopes,
+	}
+
+	ctx := context.Background()
+	if err := h.repo.CreateAPIToken(ctx, token); err != nil {
+		http.Error(w, "Error creating token", http.StatusInternalServerError)
+		log.Printf("Error creating API token: %v", err)
+		return
+	}
+
+	// Create an audit log entry
+	auditLog := &models.AuditLog{
+		ID:        generateID(),
+		UserID:    user.ID,
+		Action:    "create_api_token",
+		Timestamp: time.Now().UTC(),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details:   fmt.Sprintf("Created API token %q (%s) with scopes: %s", token.Name, token.ID, strings.Join(scopes, ", ")),
+	}
+
+	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	h.renderTokens(w, r, user, http.StatusOK, map[string]interface{}{
+		"NewToken": map[string]interface{}{
+			"Name":  token.Name,
+			"Token": tokenString,
+		},
+	})
+}
+
+// HandleRevokeToken handles revoking an API token
+func (h *APITokenHandler) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Get the token ID from the URL
+	tokenID := r.PathValue("id")
+	if tokenID == "" {
+		http.Error(w, "Token ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify ownership
+	ctx := context.Background()
+	tokens, err := h.repo.ListAPITokensByUserID(ctx, user.ID)
+	if err != nil {
+		http.Error(w, "Error fetching tokens", http.StatusInternalServerError)
+		log.Printf("Error fetching API tokens: %v", err)
+		return
+	}
+
+	var token *models.APIToken
+	for _, t := range tokens {
+		if t.ID == tokenID {
+			token = t
+			break
+		}
+	}
+	if token == nil {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	if !token.IsRevoked() {
+		if err := h.repo.RevokeAPIToken(ctx, token.ID); err != nil {
+			http.Error(w, "Error revoking token", http.StatusInternalServerError)
+			log.Printf("Error revoking API token: %v", err)
+			return
+		}
+
+		// Create an audit log entry
+		auditLog := &models.AuditLog{
+			ID:        generateID(),
+			UserID:    user.ID,
+			Action:    "revoke_api_token",
+			Timestamp: time.Now().UTC(),
+			IPAddress: r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+			Details:   fmt.Sprintf("Revoked API token %q (%s)", token.Name, token.ID),
+		}
+
+		if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+			log.Printf("Error creating audit log: %v", err)
+			// Continue anyway, don't fail the whole request
+		}
+	}
+
+	// Redirect back to the token management page
+	http.Redirect(w, r, "/profile/tokens", http.StatusSeeOther)
+}
+
+// renderTokens renders the API token management page
+func (h *APITokenHandler) renderTokens(w http.ResponseWriter, r *http.Request, user *models.User, status int, data map[string]interface{}) {
+	tokens, err := h.repo.ListAPITokensByUserID(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Error fetching tokens", http.StatusInternalServerError)
+		log.Printf("Error fetching API tokens: %v", err)
+		return
+	}
+
+	// Prepare token data for the template
+	tokensData := make([]map[string]interface{}, len(tokens))
+	for i, token := range tokens {
+		lastUsed := "Never"
+		if token.LastUsedAt != nil {
+			lastUsed = token.LastUsedAt.Format("January 2, 2006 at 3:04 PM")
+		}
+		tokensData[i] = map[string]interface{}{
+			"ID":        token.ID,
+			"Name":      token.Name,
+			"Scopes":    strings.Join(token.Scopes, ", "),
+			"CreatedAt": token.CreatedAt.Format("January 2, 2006"),
+			"LastUsed":  lastUsed,
+			"Revoked":   token.IsRevoked(),
+		}
+	}
+
+	data["Tokens"] = tokensData
+	data["Scopes"] = models.APITokenScopes
+
+	// A newly created token must never be cached
+	if _, ok := data["NewToken"]; ok {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+
+	if err := templates.RenderTemplate(w, "api-tokens.html", templates.TemplateData{
+		Title:           "API Tokens",
+		ActivePage:      "profile",
+		IsAuthenticated: true,
+		User: map[string]interface{}{
+			"Email": user.Email,
+			"Name":  user.Email, // Use email as name since we don't have a separate name field
+		},
+		Data: data,
+	}); err != nil {
+		log.Printf("Error rendering API tokens template: %v", err)
+	}
+}
+
+// parseAPITokenScopes validates the scopes selected for a new API token
+func parseAPITokenScopes(values []string) ([]string, error) {
+	var scopes []string
+	for _, value := range values {
+		known := false
+		for _, scope := range models.APITokenScopes {
+			if value == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown scope: %s", value)
+		}
+
+		duplicate := false
+		for _, scope := range scopes {
+			if value == scope {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			scopes = append(scopes, value)
+		}
+	}
+
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("select at least one scope")
+	}
+
+	return scopes, nil
+}
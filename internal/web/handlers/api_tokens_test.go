@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+func newAPITokenRequest(method, target string, form url.Values, user *models.User) *http.Request {
+	req := newFormRequest(method, target, form)
+	return req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, user))
+}
+
+func TestHandleCreateToken(t *testing.T) {
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user123", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+	handler := NewAPITokenHandler(repo)
+
+	form := url.Values{"name": {"laptop cron"}, "scopes": {models.APITokenScopeCheckIn}}
+	rr := httptest.NewRecorder()
+	handler.HandleCreateToken(rr, newAPITokenRequest("POST", "/profile/tokens", form, user))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(repo.APITokens) != 1 {
+		t.Fatalf("Expected 1 token, got %d", len(repo.APITokens))
+	}
+
+	token := repo.APITokens[0]
+	if token.Name != "laptop cron" || !token.HasScope(models.APITokenScopeCheckIn) {
+		t.Errorf("Unexpected token: %+v", token)
+	}
+
+	// The page shows the token once and only its hash is stored
+	body := rr.Body.String()
+	start := strings.Index(body, auth.APITokenPrefix)
+	if start < 0 {
+		t.Fatal("Expected the new token to be shown")
+	}
+	end := strings.IndexAny(body[start:], "< \n\"")
+	if tokenString := body[start : start+end]; auth.HashAPIToken(tokenString) != token.TokenHash {
+		t.Error("Expected the stored hash to match the shown token")
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Error("Expected the new token page not to be cached")
+	}
+
+	// Invalid input is rejected
+	for _, form := range []url.Values{
+		{"name": {""}, "scopes": {models.APITokenScopeCheckIn}},
+		{"name": {"no scopes"}},
+		{"name": {"bad scope"}, "scopes": {"admin"}},
+	} {
+		rr := httptest.NewRecorder()
+		handler.HandleCreateToken(rr, newAPITokenRequest("POST", "/profile/tokens", form, user))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %v, got %d", form, rr.Code)
+		}
+	}
+	if len(repo.APITokens) != 1 {
+		t.Errorf("Expected no tokens to be created from invalid input, got %d", len(repo.APITokens))
+	}
+}
+
+func TestHandleRevokeToken(t *testing.T) {
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user123", Email: "test@example.com"}
+	repo.APITokens = append(repo.APITokens,
+		&models.APIToken{ID: "token1", UserID: user.ID, Name: "mine", TokenHash: "hash1"},
+		&models.APIToken{ID: "token2", UserID: "other", Name: "theirs", TokenHash: "hash2"},
+	)
+	handler := NewAPITokenHandler(repo)
+
+	// Another user's token can't be revoked
+	req := newAPITokenRequest("POST", "/profile/tokens/token2/revoke", nil, user)
+	req.SetPathValue("id", "token2")
+	rr := httptest.NewRecorder()
+	handler.HandleRevokeToken(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+	if repo.APITokens[1].IsRevoked() {
+		t.Error("Expected the other user's token not to be revoked")
+	}
+
+	req = newAPITokenRequest("POST", "/profile/tokens/token1/revoke", nil, user)
+	req.SetPathValue("id", "token1")
+	rr = httptest.NewRecorder()
+	handler.HandleRevokeToken(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected status 303, got %d", rr.Code)
+	}
+	if !repo.APITokens[0].IsRevoked() {
+		t.Error("Expected the token to be revoked")
+	}
+}
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/backup"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/email"
+	"github.com/korjavin/deadmanswitch/internal/files"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+)
+
+const (
+	// APIV1Prefix is the path prefix of the versioned JSON API
+	APIV1Prefix = "/api/v1"
+
+	// maxAPIRequestBody limits the size of JSON request bodies
+	maxAPIRequestBody = 1 << 20
+
+	// apiVaultUnlockDuration is how long a vault unlocked with an API token stays unlocked
+	apiVaultUnlockDuration = 15 * time.Minute
+)
+
+// APIRoute describes an endpoint of the versioned JSON API.
+// The route table is used both to register the handlers and to generate the OpenAPI document.
+type APIRoute struct {
+	Method      string
+	Path        string // Path relative to APIV1Prefix, with {name} path parameters
+	Scope       string // API token scope required to call the endpoint
+	Summary     string
+	Tag         string
+	Query       []APIParam  // Query parameters
+	Request     interface{} // Zero value of the JSON request body, nil if there is none
+	Response    interface{} // Zero value of the JSON response body, nil if there is none
+	Status      int         // Status code of a successful response
+	HandlerFunc http.HandlerFunc
+}
+
+// APIParam describes a query parameter of an API endpoint
+type APIParam struct {
+	Name        string
+	Type        string // "string" or "integer"
+	Format      string
+	Description string
+}
+
+// apiError is the body of every API error response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// APIV1Handler handles the versioned JSON API
+type APIV1Handler struct {
+	repo        storage.Repository
+	emailClient *email.Client
+	vault       *auth.VaultService
+	sealer      *delivery.Sealer
+	adminToken  string // Token the admin endpoints require in the X-Admin-Token header
+	files       *files.Store
+	backups     *backup.Service
+
+	// Number of earlier revisions kept per secret
+	keepVersions int
+}
+
+// SetFileStore lets deleting a file secret remove its file and includes file
+// secrets in backups, restored files are limited like uploaded ones
+func (h *APIV1Handler) SetFileStore(store *files.Store, maxFileSize, fileQuota int64) {
+	h.files = store
+	h.backups.SetFileStore(store, maxFileSize, fileQuota)
+}
+
+// NewAPIV1Handler creates a new APIV1Handler
+func NewAPIV1Handler(repo storage.Repository, emailClient *email.Client, vault *auth.VaultService, sealer *delivery.Sealer, adminToken string) *APIV1Handler {
+	return &APIV1Handler{
+		repo:         repo,
+		emailClient:  emailClient,
+		vault:        vault,
+		sealer:       sealer,
+		adminToken:   adminToken,
+		backups:      backup.NewService(repo, sealer),
+		keepVersions: defaultSecretVersions,
+	}
+}
+
+// Routes returns the route table of the API
+func (h *APIV1Handler) Routes() []APIRoute {
+	limit := APIParam{Name: "limit", Type: "integer", Description: "Maximum number of entries to return, newest first"}
+
+	return []APIRoute{
+		// Account
+		{Method: "GET", Path: "/status", Scope: models.APITokenScopeRead, Tag: "account",
+			Summary: "Get the state of the switch", Response: apiStatus{}, Status: http.StatusOK, HandlerFunc: h.HandleGetStatus},
+		{Method: "POST", Path: "/check-in", Scope: models.APITokenScopeCheckIn, Tag: "account",
+			Summary: "Check in and reset the switch", Response: apiStatus{}, Status: http.StatusOK, HandlerFunc: h.HandleCheckIn},
+		{Method: "GET", Path: "/settings", Scope: models.APITokenScopeRead, Tag: "account",
+			Summary: "Get the switch settings", Response: apiSettings{}, Status: http.StatusOK, HandlerFunc: h.HandleGetSettings},
+		{Method: "PATCH", Path: "/settings", Scope: models.APITokenScopeWrite, Tag: "account",
+			Summary: "Update the switch settings", Request: apiUpdateSettingsRequest{}, Response: apiSettings{}, Status: http.StatusOK, HandlerFunc: h.HandleUpdateSettings},
+		{Method: "GET", Path: "/ping-history", Scope: models.APITokenScopeRead, Tag: "account",
+			Summary: "List sent pings and check-ins", Query: []APIParam{limit}, Response: []models.PingHistory{}, Status: http.StatusOK, HandlerFunc: h.HandleListPingHistory},
+		{Method: "GET", Path: "/audit-logs", Scope: models.APITokenScopeRead, Tag: "account",
+			Summary: "List audit log entries",
+			Query: []APIParam{
+				{Name: "since", Type: "string", Format: "date-time", Description: "Only return entries after this time"},
+				limit,
+			},
+			Response: []models.AuditLog{}, Status: http.StatusOK, HandlerFunc: h.HandleListAuditLogs},
+		{Method: "GET", Path: "/recovery", Scope: models.APITokenScopeRead, Tag: "account",
+			Summary: "Show what the last release of the switch sent to whom", Response: apiRecovery{}, Status: http.StatusOK, HandlerFunc: h.HandleGetRecovery},
+		{Method: "POST", Path: "/recovery", Scope: models.APITokenScopeWrite, Tag: "account",
+			Summary: "Revoke the last release of the switch and re-arm it", Request: apiRecoverRequest{}, Response: recoverySummary{}, Status: http.StatusOK, HandlerFunc: h.HandleRecover},
+
+		// Vault
+		{Method: "POST", Path: "/vault/unlock", Scope: models.APITokenScopeWrite, Tag: "vault",
+			Summary: "Unlock the vault to read or change secret content", Request: apiUnlockVaultRequest{}, Response: apiVaultStatus{}, Status: http.StatusOK, HandlerFunc: h.HandleUnlockVault},
+		{Method: "POST", Path: "/vault/lock", Scope: models.APITokenScopeRead, Tag: "vault",
+			Summary: "Lock the vault again", Status: http.StatusNoContent, HandlerFunc: h.HandleLockVault},
+		{Method: "POST", Path: "/backup/export", Scope: models.APITokenScopeWrite, Tag: "vault",
+			Summary: "Export secrets, recipients, assignments and settings as a backup encrypted with a passphrase, needs an unlocked vault", Request: apiExportBackupRequest{}, Response: apiBackupExport{}, Status: http.StatusOK, HandlerFunc: h.HandleExportBackup},
+		{Method: "POST", Path: "/backup/restore", Scope: models.APITokenScopeWrite, Tag: "vault",
+			Summary: "Restore a backup into the account, needs an unlocked vault", Request: apiRestoreBackupRequest{}, Response: backup.Report{}, Status: http.StatusOK, HandlerFunc: h.HandleRestoreBackup},
+
+		// Secrets
+		{Method: "GET", Path: "/secrets", Scope: models.APITokenScopeRead, Tag: "secrets",
+			Summary: "List secrets", Response: []apiSecret{}, Status: http.StatusOK, HandlerFunc: h.HandleListSecrets},
+		{Method: "POST", Path: "/secrets", Scope: models.APITokenScopeWrite, Tag: "secrets",
+			Summary: "Create a secret, needs an unlocked vault", Request: apiCreateSecretRequest{}, Response: apiSecret{}, Status: http.StatusCreated, HandlerFunc: h.HandleCreateSecret},
+		{Method: "GET", Path: "/secrets/{id}", Scope: models.APITokenScopeRead, Tag: "secrets",
+			Summary: "Get a secret", Response: apiSecret{}, Status: http.StatusOK, HandlerFunc: h.HandleGetSecret},
+		{Method: "GET", Path: "/secrets/{id}/content", Scope: models.APITokenScopeWrite, Tag: "secrets",
+			Summary: "Get the decrypted content of a secret, needs an unlocked vault", Response: apiSecretContent{}, Status: http.StatusOK, HandlerFunc: h.HandleGetSecretContent},
+		{Method: "PATCH", Path: "/secrets/{id}", Scope: models.APITokenScopeWrite, Tag: "secrets",
+			Summary: "Update a secret, needs an unlocked vault", Request: apiUpdateSecretRequest{}, Response: apiSecret{}, Status: http.StatusOK, HandlerFunc: h.HandleUpdateSecret},
+		{Method: "DELETE", Path: "/secrets/{id}", Scope: models.APITokenScopeWrite, Tag: "secrets",
+			Summary: "Delete a secret", Status: http.StatusNoContent, HandlerFunc: h.HandleDeleteSecret},
+		{Method: "POST", Path: "/secrets/import/preview", Scope: models.APITokenScopeRead, Tag: "secrets",
+			Summary: "List the entries of a password manager export without importing them", Request: apiImportPreviewRequest{}, Response: apiImportPreview{}, Status: http.StatusOK, HandlerFunc: h.HandlePreviewImport},
+		{Method: "POST", Path: "/secrets/import", Scope: models.APITokenScopeWrite, Tag: "secrets",
+			Summary: "Import the entries of a password manager export as secrets, needs an unlocked vault", Request: apiImportRequest{}, Response: []apiSecret{}, Status: http.StatusCreated, HandlerFunc: h.HandleImport},
+
+		// Recipients
+		{Method: "GET", Path: "/recipients", Scope: models.APITokenScopeRead, Tag: "recipients",
+			Summary: "List recipients", Response: []apiRecipient{}, Status: http.StatusOK, HandlerFunc: h.HandleListRecipients},
+		{Method: "POST", Path: "/recipients", Scope: models.APITokenScopeWrite, Tag: "recipients",
+			Summary: "Create a recipient", Request: apiCreateRecipientRequest{}, Response: apiRecipient{}, Status: http.StatusCreated, HandlerFunc: h.HandleCreateRecipient},
+		{Method: "GET", Path: "/recipients/{id}", Scope: models.APITokenScopeRead, Tag: "recipients",
+			Summary: "Get a recipient", Response: apiRecipient{}, Status: http.StatusOK, HandlerFunc: h.HandleGetRecipient},
+		{Method: "PATCH", Path: "/recipients/{id}", Scope: models.APITokenScopeWrite, Tag: "recipients",
+			Summary: "Update a recipient", Request: apiUpdateRecipientRequest{}, Response: apiRecipient{}, Status: http.StatusOK, HandlerFunc: h.HandleUpdateRecipient},
+		{Method: "DELETE", Path: "/recipients/{id}", Scope: models.APITokenScopeWrite, Tag: "recipients",
+			Summary: "Delete a recipient", Status: http.StatusNoContent, HandlerFunc: h.HandleDeleteRecipient},
+		{Method: "POST", Path: "/recipients/{id}/test", Scope: models.APITokenScopeWrite, Tag: "recipients",
+			Summary: "Send a test contact email to a recipient", Response: apiRecipient{}, Status: http.StatusOK, HandlerFunc: h.HandleTestRecipient},
+		{Method: "GET", Path: "/recipients/{id}/questions", Scope: models.APITokenScopeRead, Tag: "recipients",
+			Summary: "List the personal questions of a recipient", Response: apiRecipientQuestions{}, Status: http.StatusOK, HandlerFunc: h.HandleGetRecipientQuestions},
+		{Method: "PUT", Path: "/recipients/{id}/questions", Scope: models.APITokenScopeWrite, Tag: "recipients",
+			Summary: "Replace the personal questions of a recipient, needs an unlocked vault", Request: apiSetRecipientQuestionsRequest{}, Response: apiRecipientQuestions{}, Status: http.StatusOK, HandlerFunc: h.HandleSetRecipientQuestions},
+
+		// Assignments
+		{Method: "GET", Path: "/assignments", Scope: models.APITokenScopeRead, Tag: "assignments",
+			Summary: "List which secrets are assigned to which recipients", Response: []models.SecretAssignment{}, Status: http.StatusOK, HandlerFunc: h.HandleListAssignments},
+		{Method: "POST", Path: "/assignments", Scope: models.APITokenScopeWrite, Tag: "assignments",
+			Summary: "Assign a secret to a recipient, may need an unlocked vault", Request: apiCreateAssignmentRequest{}, Response: models.SecretAssignment{}, Status: http.StatusCreated, HandlerFunc: h.HandleCreateAssignment},
+		{Method: "DELETE", Path: "/assignments/{id}", Scope: models.APITokenScopeWrite, Tag: "assignments",
+			Summary: "Remove an assignment, may need an unlocked vault", Status: http.StatusNoContent, HandlerFunc: h.HandleDeleteAssignment},
+
+		// Admin
+		{Method: "POST", Path: "/admin/rotate-keys", Scope: models.APITokenScopeWrite, Tag: "admin",
+			Summary: "Move all sealed delivery material to the current master key, only for the administrator", Response: delivery.KeyRotationReport{}, Status: http.StatusOK, HandlerFunc: h.HandleRotateKeys},
+	}
+}
+
+// HandleNotFound answers API requests that match no route. Requests for a known path
+// with another method get a 405 with the allowed methods.
+func (h *APIV1Handler) HandleNotFound(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, APIV1Prefix), "/")
+
+	var allowed []string
+	for _, route := range h.Routes() {
+		if apiPathMatches(route.Path, path) {
+			allowed = append(allowed, route.Method)
+		}
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeAPIError(w, http.StatusNotFound, "not found")
+}
+
+// apiPathMatches reports whether a request path matches a route path with {name} parameters
+func apiPathMatches(pattern, path string) bool {
+	patternParts := strings.Split(pattern, "/")
+	pathParts := strings.Split(path, "/")
+	if len(patternParts) != len(pathParts) {
+		return false
+	}
+
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if pathParts[i] == "" {
+				return false
+			}
+			continue
+		}
+		if part != pathParts[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apiUser returns the authenticated user or answers with 401
+func apiUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		writeAPIError(w, http.StatusUnauthorized, "authentication required")
+		return nil, false
+	}
+	return user, true
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// writeAPIError writes a JSON error response
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// decodeJSON decodes a JSON request body, rejecting unknown fields and oversized bodies.
+// Requiring the JSON content type also keeps cross-site form posts out of cookie authenticated requests.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeJSONLimit(w, r, v, maxAPIRequestBody)
+}
+
+// decodeJSONLimit decodes a JSON request body of up to limit bytes
+func decodeJSONLimit(w http.ResponseWriter, r *http.Request, v interface{}, limit int64) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "request body must be application/json")
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return false
+		}
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: unexpected data after the JSON object")
+		return false
+	}
+
+	return true
+}
+
+// parseLimit reads the optional limit query parameter
+func parseLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return 0, true
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		writeAPIError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return 0, false
+	}
+
+	return limit, true
+}
+
+// vaultKeyID returns the key the vault key of the current request is held under.
+// Session requests share the vault with the web interface, token requests get their own.
+func vaultKeyID(r *http.Request) (string, bool) {
+	if token, ok := middleware.GetAPITokenFromContext(r); ok {
+		return "api-token:" + token.ID, true
+	}
+	if session, ok := middleware.GetSessionFromContext(r); ok && session != nil {
+		return session.ID, true
+	}
+	return "", false
+}
+
+// requireAPIVaultKey returns the unlocked vault key for the request or answers with 423
+func (h *APIV1Handler) requireAPIVaultKey(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	keyID, ok := vaultKeyID(r)
+	if !ok || h.vault == nil {
+		writeAPIError(w, http.StatusUnauthorized, "authentication required")
+		return nil, false
+	}
+
+	key, err := h.vault.Key(keyID)
+	if err != nil {
+		writeAPIError(w, http.StatusLocked, "vault is locked, unlock it with POST "+APIV1Prefix+"/vault/unlock")
+		return nil, false
+	}
+
+	return key, true
+}
+
+// audit writes an audit log entry for an API request, naming the API token if one was used
+func (h *APIV1Handler) audit(r *http.Request, user *models.User, action, details string) {
+	if token, ok := middleware.GetAPITokenFromContext(r); ok {
+		details = fmt.Sprintf("%s (via API token %q, %s)", details, token.Name, token.ID)
+	}
+
+	auditLog := &models.AuditLog{
+		ID:        generateID(),
+		UserID:    user.ID,
+		Action:    action,
+		Timestamp: time.Now().UTC(),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	}
+
+	if err := h.repo.CreateAuditLog(r.Context(), auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+}
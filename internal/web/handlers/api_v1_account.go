@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/utils"
+)
+
+// apiStatus is the state of a user's switch
+type apiStatus struct {
+	PingingEnabled       bool      `json:"pinging_enabled"`
+	LastActivity         time.Time `json:"last_activity"`
+	NextPing             time.Time `json:"next_ping"`
+	Deadline             time.Time `json:"deadline"`
+	SecondsUntilDeadline int64     `json:"seconds_until_deadline"`
+	Secrets              int       `json:"secrets"`
+	Recipients           int       `json:"recipients"`
+}
+
+// apiSettings are the switch settings of a user
+type apiSettings struct {
+	PingFrequency       int    `json:"ping_frequency"` // Days
+	PingDeadline        int    `json:"ping_deadline"`  // Days
+	PingMethod          string `json:"ping_method"`
+	PingingEnabled      bool   `json:"pinging_enabled"`
+	EmergencyAccessDays int    `json:"emergency_access_days"` // 0 disables emergency access requests
+}
+
+// apiUpdateSettingsRequest changes the settings that are set
+type apiUpdateSettingsRequest struct {
+	PingFrequency       *int    `json:"ping_frequency,omitempty"`
+	PingDeadline        *int    `json:"ping_deadline,omitempty"`
+	PingMethod          *string `json:"ping_method,omitempty"`
+	PingingEnabled      *bool   `json:"pinging_enabled,omitempty"`
+	EmergencyAccessDays *int    `json:"emergency_access_days,omitempty"`
+}
+
+// apiReleasedDelivery is what one recipient was sent when the switch fired
+type apiReleasedDelivery struct {
+	RecipientID    string     `json:"recipient_id"`
+	RecipientName  string     `json:"recipient_name"`
+	RecipientEmail string     `json:"recipient_email"`
+	Status         string     `json:"status"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	Secrets        []string   `json:"secrets"`    // Names of the secrets assigned to the recipient
+	Opened         bool       `json:"opened"`     // The recipient opened one of their access links
+	OpenCodes      int        `json:"open_codes"` // Access links that still work
+}
+
+// apiRecovery is the last release of the switch, which can still be recovered
+type apiRecovery struct {
+	RunID      string                `json:"run_id"`
+	FiredAt    time.Time             `json:"fired_at"`
+	Deliveries []apiReleasedDelivery `json:"deliveries"`
+}
+
+// apiRecoverRequest revokes the last release of the switch
+type apiRecoverRequest struct {
+	Notify bool `json:"notify,omitempty"` // Tell the recipients who were sent something
+}
+
+// apiUnlockVaultRequest carries the login password that unwraps the vault key
+type apiUnlockVaultRequest struct {
+	Password string `json:"password"`
+}
+
+// apiVaultStatus tells until when the vault stays unlocked
+type apiVaultStatus struct {
+	Unlocked  bool      `json:"unlocked"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HandleGetStatus returns the state of the switch
+func (h *APIV1Handler) HandleGetStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeStatus(w, r, user)
+}
+
+// HandleCheckIn checks the user in and returns the new state of the switch
+func (h *APIV1Handler) HandleCheckIn(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := recordCheckIn(r.Context(), h.repo, h.sealer, r, user); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error updating user")
+		log.Printf("Error updating user last activity: %v", err)
+		return
+	}
+
+	h.writeStatus(w, r, user)
+}
+
+// writeStatus writes the state of the user's switch
+func (h *APIV1Handler) writeStatus(w http.ResponseWriter, r *http.Request, user *models.User) {
+	secrets, err := h.repo.ListSecretsByUserID(r.Context(), user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secrets")
+		log.Printf("Error fetching secrets: %v", err)
+		return
+	}
+
+	recipients, err := h.repo.ListRecipientsByUserID(r.Context(), user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching recipients")
+		log.Printf("Error fetching recipients: %v", err)
+		return
+	}
+
+	deadline := user.LastActivity.AddDate(0, 0, user.PingDeadline)
+	untilDeadline := time.Until(deadline)
+	if untilDeadline < 0 {
+		untilDeadline = 0
+	}
+
+	writeJSON(w, http.StatusOK, apiStatus{
+		PingingEnabled:       user.PingingEnabled,
+		LastActivity:         user.LastActivity,
+		NextPing:             user.NextScheduledPing,
+		Deadline:             deadline,
+		SecondsUntilDeadline: int64(untilDeadline.Seconds()),
+		Secrets:              len(secrets),
+		Recipients:           len(recipients),
+	})
+}
+
+// HandleGetSettings returns the switch settings
+func (h *APIV1Handler) HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPISettings(user))
+}
+
+// HandleUpdateSettings changes the switch settings. Unlike the settings form,
+// out of range values are rejected instead of replaced with defaults.
+func (h *APIV1Handler) HandleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiUpdateSettingsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.PingFrequency != nil {
+		if *req.PingFrequency < 1 || *req.PingFrequency > 30 {
+			writeAPIError(w, http.StatusBadRequest, "ping_frequency must be between 1 and 30 days")
+			return
+		}
+		user.PingFrequency = *req.PingFrequency
+	}
+
+	if req.PingDeadline != nil {
+		if *req.PingDeadline < 3 || *req.PingDeadline > 30 {
+			writeAPIError(w, http.StatusBadRequest, "ping_deadline must be between 3 and 30 days")
+			return
+		}
+		user.PingDeadline = *req.PingDeadline
+	}
+
+	if req.PingMethod != nil {
+		if *req.PingMethod != "email" && *req.PingMethod != "telegram" && *req.PingMethod != "both" {
+			writeAPIError(w, http.StatusBadRequest, "ping_method must be email, telegram or both")
+			return
+		}
+		user.PingMethod = *req.PingMethod
+	}
+
+	if req.PingingEnabled != nil {
+		user.PingingEnabled = *req.PingingEnabled
+	}
+
+	// Checked against the new deadline, lowering the deadline alone can make the waiting period too long
+	emergencyAccessDays := user.EmergencyAccessDays
+	if req.EmergencyAccessDays != nil {
+		emergencyAccessDays = *req.EmergencyAccessDays
+	}
+	if emergencyAccessDays < 0 || (emergencyAccessDays > 0 && emergencyAccessDays >= user.PingDeadline) {
+		writeAPIError(w, http.StatusBadRequest, "emergency_access_days must be 0 or shorter than ping_deadline")
+		return
+	}
+	user.EmergencyAccessDays = emergencyAccessDays
+
+	if err := h.repo.UpdateUser(r.Context(), user); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to update settings")
+		log.Printf("Error updating user settings: %v", err)
+		return
+	}
+
+	h.audit(r, user, "update_settings", "Updated switch settings")
+
+	writeJSON(w, http.StatusOK, newAPISettings(user))
+}
+
+// newAPISettings returns the switch settings of a user
+func newAPISettings(user *models.User) apiSettings {
+	return apiSettings{
+		PingFrequency:       user.PingFrequency,
+		PingDeadline:        user.PingDeadline,
+		PingMethod:          user.PingMethod,
+		PingingEnabled:      user.PingingEnabled,
+		EmergencyAccessDays: user.EmergencyAccessDays,
+	}
+}
+
+// HandleListPingHistory lists the pings sent to the user and their check-ins
+func (h *APIV1Handler) HandleListPingHistory(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+
+	pings, err := h.repo.ListPingHistoryByUserID(r.Context(), user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching ping history")
+		log.Printf("Error fetching ping history: %v", err)
+		return
+	}
+
+	if limit > 0 && len(pings) > limit {
+		pings = pings[:limit]
+	}
+	if pings == nil {
+		pings = []*models.PingHistory{}
+	}
+
+	writeJSON(w, http.StatusOK, pings)
+}
+
+// HandleListAuditLogs lists the audit log of the user, newest first
+func (h *APIV1Handler) HandleListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+			return
+		}
+	}
+
+	logs, err := h.repo.ListAuditLogsByUserID(r.Context(), user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching audit logs")
+		log.Printf("Error fetching audit logs: %v", err)
+		return
+	}
+
+	entries := []*models.AuditLog{}
+	for _, entry := range logs {
+		if !since.IsZero() && !entry.Timestamp.After(since) {
+			continue
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// HandleGetRecovery shows what the last release of the switch sent to whom
+func (h *APIV1Handler) HandleGetRecovery(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	run, ok := h.releasedRun(w, r, user)
+	if !ok {
+		return
+	}
+
+	deliveries, err := listReleasedDeliveries(r.Context(), h.repo, user, run)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching deliveries")
+		log.Printf("Error fetching released deliveries: %v", err)
+		return
+	}
+
+	recovery := apiRecovery{
+		RunID:      run.ID,
+		FiredAt:    run.FiresAt,
+		Deliveries: []apiReleasedDelivery{},
+	}
+	for _, delivery := range deliveries {
+		secrets := delivery.Secrets
+		if secrets == nil {
+			secrets = []string{}
+		}
+		recovery.Deliveries = append(recovery.Deliveries, apiReleasedDelivery{
+			RecipientID:    delivery.Recipient.ID,
+			RecipientName:  delivery.Recipient.Name,
+			RecipientEmail: delivery.Recipient.Email,
+			Status:         delivery.Event.Status,
+			LastAttemptAt:  delivery.Event.LastAttemptAt,
+			Secrets:        secrets,
+			Opened:         delivery.Opened,
+			OpenCodes:      delivery.OpenCodes,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, recovery)
+}
+
+// HandleRecover revokes the last release of the switch and re-arms it
+func (h *APIV1Handler) HandleRecover(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiRecoverRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	run, ok := h.releasedRun(w, r, user)
+	if !ok {
+		return
+	}
+
+	// Quorum protected secrets whose shares were sent are resealed
+	vaultKey, ok := h.requireAPIVaultKey(w, r)
+	if !ok {
+		return
+	}
+
+	summary, err := recoverRun(r.Context(), h.repo, h.sealer, h.emailClient, user, vaultKey, run, req.Notify)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error recovering switch")
+		log.Printf("Error recovering delivery run %s: %v", run.ID, err)
+		return
+	}
+
+	h.audit(r, user, "switch_recovered", recoveryDetails(run, summary))
+
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// releasedRun returns the last release of the user's switch and writes a 404 if there is none
+func (h *APIV1Handler) releasedRun(w http.ResponseWriter, r *http.Request, user *models.User) (*models.DeliveryRun, bool) {
+	run, err := latestReleasedRun(r.Context(), h.repo, user.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, "the switch hasn't released anything that could be recovered")
+			return nil, false
+		}
+		writeAPIError(w, http.StatusInternalServerError, "error fetching delivery runs")
+		log.Printf("Error fetching delivery runs: %v", err)
+		return nil, false
+	}
+
+	return run, true
+}
+
+// HandleUnlockVault unlocks the vault for the current session or API token.
+// A vault unlocked with an API token stays unlocked for a short time only.
+func (h *APIV1Handler) HandleUnlockVault(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiUnlockVaultRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	keyID, ok := vaultKeyID(r)
+	if !ok || h.vault == nil {
+		writeAPIError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	// Wrong passwords count against the same limit as in the web interface
+	if err := h.vault.CheckUnlockAttempts(user.ID); err != nil {
+		writeAPIError(w, http.StatusTooManyRequests, "too many failed unlock attempts, try again later")
+		return
+	}
+
+	if !utils.VerifyPassword(user.PasswordHash, req.Password) {
+		h.audit(r, user, "unlock_vault_failed", "Vault unlock failed: invalid password")
+		if h.vault.FailedUnlock(user.ID) == 0 {
+			writeAPIError(w, http.StatusTooManyRequests, "too many failed unlock attempts, try again later")
+			return
+		}
+		writeAPIError(w, http.StatusForbidden, "invalid password")
+		return
+	}
+
+	expiresAt := time.Now().Add(apiVaultUnlockDuration)
+	if session, ok := middleware.GetSessionFromContext(r); ok && session != nil && keyID == session.ID {
+		expiresAt = session.ExpiresAt
+	}
+
+	if err := h.vault.Unlock(r.Context(), user, req.Password, &models.Session{ID: keyID, ExpiresAt: expiresAt}); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error unlocking vault")
+		log.Printf("Error unlocking vault: %v", err)
+		return
+	}
+
+	// The vault key is at hand, so the question timelocks can be rebuilt
+	if key, err := h.vault.Key(keyID); err == nil {
+		if err := h.sealer.RenewQuestionTimelocks(r.Context(), user, key); err != nil {
+			log.Printf("Error renewing question timelocks for user %s: %v", user.ID, err)
+		}
+	}
+
+	h.audit(r, user, "unlock_vault", "Vault unlocked")
+
+	writeJSON(w, http.StatusOK, apiVaultStatus{Unlocked: true, ExpiresAt: expiresAt})
+}
+
+// HandleLockVault forgets the vault key of the current session or API token
+func (h *APIV1Handler) HandleLockVault(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, ok := vaultKeyID(r)
+	if !ok || h.vault == nil {
+		writeAPIError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	h.vault.Lock(keyID)
+	h.audit(r, user, "lock_vault", "Vault locked")
+
+	w.WriteHeader(http.StatusNoContent)
+}
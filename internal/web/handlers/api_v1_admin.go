@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+)
+
+// HandleRotateKeys moves all sealed delivery material to the current master
+// key, after MASTER_KEY was replaced and the old key moved to
+// MASTER_KEY_PREVIOUS. The run can be repeated until nothing fails.
+func (h *APIV1Handler) HandleRotateKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.apiAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := h.sealer.RotateKeys(r.Context(), func(report *delivery.KeyRotationReport) {
+		log.Printf("Key rotation: %d of %d secrets", report.Secrets, report.TotalSecrets)
+	})
+	if errors.Is(err, delivery.ErrNoMasterKey) {
+		writeAPIError(w, http.StatusConflict, "no master key is configured")
+		return
+	}
+	if report == nil {
+		writeAPIError(w, http.StatusInternalServerError, "error rotating keys")
+		log.Printf("Error rotating keys: %v", err)
+		return
+	}
+	if err != nil {
+		log.Printf("Key rotation incomplete: %v", err)
+	}
+
+	h.audit(r, user, "rotate_keys", fmt.Sprintf("Rotated the master key: %d rewrapped, %d re-encrypted, %d already current, %d of %d secrets verified",
+		report.Rewrapped, report.Reencrypted, report.Current, report.Verified, report.TotalSecrets))
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// apiAdmin returns the current user if the request carries the ADMIN_TOKEN
+// configured on the server, and writes a 403 otherwise. Email addresses
+// aren't verified at registration, so they can't tell who the administrator is.
+func (h *APIV1Handler) apiAdmin(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	token := r.Header.Get("X-Admin-Token")
+	if h.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+		writeAPIError(w, http.StatusForbidden, "only the administrator can do this")
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/korjavin/deadmanswitch/internal/backup"
+)
+
+// maxAPIBackupBody limits the size of restore request bodies
+const maxAPIBackupBody = maxBackupSize + maxAPIRequestBody
+
+// apiExportBackupRequest exports a backup
+type apiExportBackupRequest struct {
+	Passphrase string `json:"passphrase"` // Encrypts the backup, at least backup.MinPassphraseLength characters
+}
+
+// apiBackupExport is an exported backup
+type apiBackupExport struct {
+	Backup   backup.File `json:"backup"`             // Store as it is, restore sends it back
+	Warnings []string    `json:"warnings,omitempty"` // What was left out of the backup
+}
+
+// apiRestoreBackupRequest restores a backup
+type apiRestoreBackupRequest struct {
+	Backup     backup.File `json:"backup"`
+	Passphrase string      `json:"passphrase"`
+}
+
+// HandleExportBackup exports the secrets, recipients, assignments and settings
+// of the user as a backup encrypted with a passphrase
+func (h *APIV1Handler) HandleExportBackup(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiExportBackupRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := backup.CheckPassphrase(req.Passphrase); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	vaultKey, ok := h.requireAPIVaultKey(w, r)
+	if !ok {
+		return
+	}
+
+	bundle, warnings, err := h.backups.Export(r.Context(), user, vaultKey)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error exporting backup")
+		log.Printf("Error exporting backup: %v", err)
+		return
+	}
+
+	file, err := backup.Seal(bundle, req.Passphrase)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error encrypting backup")
+		log.Printf("Error encrypting backup: %v", err)
+		return
+	}
+
+	h.audit(r, user, "export_backup", fmt.Sprintf("Exported a backup (secrets: %d, recipients: %d)", len(bundle.Secrets), len(bundle.Recipients)))
+
+	writeJSON(w, http.StatusOK, apiBackupExport{Backup: *file, Warnings: warnings})
+}
+
+// HandleRestoreBackup restores a backup into the account of the user
+func (h *APIV1Handler) HandleRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiRestoreBackupRequest
+	if !decodeJSONLimit(w, r, &req, maxAPIBackupBody) {
+		return
+	}
+
+	bundle, err := backup.Open(&req.Backup, req.Passphrase)
+	if err != nil {
+		if errors.Is(err, backup.ErrWrongPassphrase) || errors.Is(err, backup.ErrUnsupportedVersion) || errors.Is(err, backup.ErrInvalidBackup) {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "error opening backup")
+		log.Printf("Error opening backup: %v", err)
+		return
+	}
+
+	vaultKey, ok := h.requireAPIVaultKey(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := h.backups.Restore(r.Context(), user, vaultKey, bundle)
+	sendRestoreConfirmations(r, h.repo, h.emailClient, user, report)
+	h.audit(r, user, "restore_backup", restoreDetails(bundle, report))
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("error restoring backup, %d of %d secrets were restored", report.SecretsCreated, len(bundle.Secrets)))
+		log.Printf("Error restoring backup: %v", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
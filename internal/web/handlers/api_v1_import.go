@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/korjavin/deadmanswitch/internal/importer"
+)
+
+// maxAPIImportBody limits the size of import request bodies, which carry a
+// base64 encoded export of up to maxImportSize bytes
+const maxAPIImportBody = maxImportSize*4/3 + maxAPIRequestBody
+
+// apiImportPreviewRequest is a password manager export to list
+type apiImportPreviewRequest struct {
+	Data []byte `json:"data"` // The export file, base64 encoded
+}
+
+// apiImportEntry is an entry of an export, without its passwords
+type apiImportEntry struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name"`
+	Folder   string `json:"folder,omitempty"`
+	Type     string `json:"type"`
+	URL      string `json:"url,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// apiImportPreview lists the entries of an export
+type apiImportPreview struct {
+	Format  string           `json:"format"`
+	Entries []apiImportEntry `json:"entries"`
+}
+
+// apiImportRequest imports entries of an export as secrets
+type apiImportRequest struct {
+	Data         []byte   `json:"data"`                    // The export file, base64 encoded
+	Entries      []int    `json:"entries,omitempty"`       // Indices of the entries to import, all if omitted
+	RecipientIDs []string `json:"recipient_ids,omitempty"` // Recipients to assign all imported secrets to
+}
+
+// HandlePreviewImport lists the entries of a password manager export without importing them
+func (h *APIV1Handler) HandlePreviewImport(w http.ResponseWriter, r *http.Request) {
+	if _, ok := apiUser(w, r); !ok {
+		return
+	}
+
+	var req apiImportPreviewRequest
+	if !decodeJSONLimit(w, r, &req, maxAPIImportBody) {
+		return
+	}
+
+	result, ok := parseAPIImport(w, req.Data)
+	if !ok {
+		return
+	}
+
+	preview := apiImportPreview{Format: result.Format, Entries: make([]apiImportEntry, 0, len(result.Entries))}
+	for i, entry := range result.Entries {
+		preview.Entries = append(preview.Entries, apiImportEntry{
+			Index:    i,
+			Name:     entry.Name,
+			Folder:   entry.Folder,
+			Type:     entry.Type,
+			URL:      entry.URL(),
+			Username: entry.Username(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, preview)
+}
+
+// HandleImport imports the entries of a password manager export as secrets
+func (h *APIV1Handler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiImportRequest
+	if !decodeJSONLimit(w, r, &req, maxAPIImportBody) {
+		return
+	}
+
+	result, ok := parseAPIImport(w, req.Data)
+	if !ok {
+		return
+	}
+
+	entries, err := selectImportEntries(result.Entries, req.Entries)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	recipientIDs := uniqueStrings(req.RecipientIDs)
+	for _, recipientID := range recipientIDs {
+		if _, ok := h.ownRecipient(w, r, user, recipientID, http.StatusBadRequest); !ok {
+			return
+		}
+	}
+
+	vaultKey, ok := h.requireAPIVaultKey(w, r)
+	if !ok {
+		return
+	}
+
+	secrets, err := importEntries(r.Context(), h.repo, h.sealer, user, vaultKey, entries, recipientIDs)
+	if len(secrets) > 0 {
+		h.audit(r, user, "import_secrets", fmt.Sprintf("Imported secrets from a password manager export: %d", len(secrets)))
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("error importing secrets, %d of %d were imported", len(secrets), len(entries)))
+		log.Printf("Error importing secrets: %v", err)
+		return
+	}
+
+	created := make([]apiSecret, 0, len(secrets))
+	for _, secret := range secrets {
+		created = append(created, newAPISecret(secret, recipientIDs))
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// parseAPIImport reads an export or answers with 400
+func parseAPIImport(w http.ResponseWriter, data []byte) (*importer.Result, bool) {
+	if len(data) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "data is required")
+		return nil, false
+	}
+	if len(data) > maxImportSize {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "export too large")
+		return nil, false
+	}
+
+	result, err := importer.Parse(data)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	return result, true
+}
@@ -0,0 +1,628 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+// apiRecipient is a recipient without its confirmation code
+type apiRecipient struct {
+	ID                 string     `json:"id"`
+	Name               string     `json:"name"`
+	Email              string     `json:"email"`
+	Message            string     `json:"message"`
+	PhoneNumber        string     `json:"phone_number,omitempty"`
+	IsConfirmed        bool       `json:"is_confirmed"`
+	ConfirmedAt        *time.Time `json:"confirmed_at,omitempty"`
+	ConfirmationSentAt *time.Time `json:"confirmation_sent_at,omitempty"`
+	ReleaseDelayDays   int        `json:"release_delay_days"`
+	PublicKey          string     `json:"public_key,omitempty"` // age recipient or OpenPGP key the recipient's copies are encrypted to
+	QuestionThreshold  int        `json:"question_threshold"`   // Number of personal questions the recipient must answer, 0 if none are set
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// apiCreateRecipientRequest creates a recipient
+type apiCreateRecipientRequest struct {
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	Message          string `json:"message,omitempty"`
+	PhoneNumber      string `json:"phone_number,omitempty"`
+	ReleaseDelayDays int    `json:"release_delay_days,omitempty"`
+	PublicKey        string `json:"public_key,omitempty"`
+}
+
+// apiUpdateRecipientRequest changes the fields that are set
+type apiUpdateRecipientRequest struct {
+	Name             *string `json:"name,omitempty"`
+	Email            *string `json:"email,omitempty"`
+	Message          *string `json:"message,omitempty"`
+	PhoneNumber      *string `json:"phone_number,omitempty"`
+	ReleaseDelayDays *int    `json:"release_delay_days,omitempty"`
+	PublicKey        *string `json:"public_key,omitempty"` // An empty string removes the key
+}
+
+// apiRecipientQuestions lists a recipient's personal questions, the answers are never returned
+type apiRecipientQuestions struct {
+	Threshold int      `json:"threshold"` // Number of questions that must be answered, 0 if none are set
+	Questions []string `json:"questions"`
+}
+
+// apiRecipientQuestion is a personal question with its answer
+type apiRecipientQuestion struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// apiSetRecipientQuestionsRequest replaces a recipient's personal questions
+type apiSetRecipientQuestionsRequest struct {
+	Questions []apiRecipientQuestion `json:"questions"`           // An empty list removes the questions
+	Threshold int                    `json:"threshold,omitempty"` // Defaults to two thirds of the questions
+}
+
+// apiCreateAssignmentRequest assigns a secret to a recipient
+type apiCreateAssignmentRequest struct {
+	SecretID    string `json:"secret_id"`
+	RecipientID string `json:"recipient_id"`
+}
+
+// HandleListRecipients lists the user's recipients
+func (h *APIV1Handler) HandleListRecipients(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	recipients, err := h.repo.ListRecipientsByUserID(r.Context(), user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching recipients")
+		log.Printf("Error fetching recipients: %v", err)
+		return
+	}
+
+	result := make([]apiRecipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		result = append(result, newAPIRecipient(recipient))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// HandleCreateRecipient creates a recipient
+func (h *APIV1Handler) HandleCreateRecipient(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiCreateRecipientRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name == "" || req.Email == "" {
+		writeAPIError(w, http.StatusBadRequest, "name and email are required")
+		return
+	}
+
+	if !validReleaseDelay(w, req.ReleaseDelayDays) {
+		return
+	}
+
+	publicKey, ok := validPublicKey(w, req.PublicKey)
+	if !ok {
+		return
+	}
+
+	recipient := &models.Recipient{
+		UserID:           user.ID,
+		Name:             req.Name,
+		Email:            req.Email,
+		Message:          req.Message,
+		PhoneNumber:      req.PhoneNumber,
+		ReleaseDelayDays: req.ReleaseDelayDays,
+		PublicKey:        publicKey,
+	}
+
+	if err := h.repo.CreateRecipient(r.Context(), recipient); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error creating recipient")
+		log.Printf("Error creating recipient: %v", err)
+		return
+	}
+
+	h.audit(r, user, "create_recipient", "Created recipient: "+recipient.Name)
+
+	writeJSON(w, http.StatusCreated, newAPIRecipient(recipient))
+}
+
+// HandleGetRecipient returns a recipient
+func (h *APIV1Handler) HandleGetRecipient(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	recipient, ok := h.ownRecipient(w, r, user, r.PathValue("id"), http.StatusNotFound)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIRecipient(recipient))
+}
+
+// HandleUpdateRecipient changes the fields of a recipient that are set
+func (h *APIV1Handler) HandleUpdateRecipient(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	recipient, ok := h.ownRecipient(w, r, user, r.PathValue("id"), http.StatusNotFound)
+	if !ok {
+		return
+	}
+
+	var req apiUpdateRecipientRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if (req.Name != nil && *req.Name == "") || (req.Email != nil && *req.Email == "") {
+		writeAPIError(w, http.StatusBadRequest, "name and email must not be empty")
+		return
+	}
+
+	if req.ReleaseDelayDays != nil && !validReleaseDelay(w, *req.ReleaseDelayDays) {
+		return
+	}
+
+	// The recipient's copies are rebuilt for a new key from the owner's copies
+	keyChanged := false
+	var publicKey string
+	var vaultKey []byte
+	if req.PublicKey != nil {
+		if publicKey, ok = validPublicKey(w, *req.PublicKey); !ok {
+			return
+		}
+
+		keyChanged = publicKey != recipient.PublicKey
+		if keyChanged && h.sealer.Enabled() {
+			if vaultKey, ok = h.requireAPIVaultKey(w, r); !ok {
+				return
+			}
+		}
+	}
+
+	if req.Name != nil {
+		recipient.Name = *req.Name
+	}
+	if req.Email != nil {
+		recipient.Email = *req.Email
+	}
+	if req.Message != nil {
+		recipient.Message = *req.Message
+	}
+	if req.PhoneNumber != nil {
+		recipient.PhoneNumber = *req.PhoneNumber
+	}
+	if req.ReleaseDelayDays != nil {
+		recipient.ReleaseDelayDays = *req.ReleaseDelayDays
+	}
+	if req.PublicKey != nil {
+		recipient.PublicKey = publicKey
+	}
+
+	if err := h.repo.UpdateRecipient(r.Context(), recipient); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error updating recipient")
+		log.Printf("Error updating recipient: %v", err)
+		return
+	}
+
+	if keyChanged {
+		if err := h.sealer.ResealRecipient(r.Context(), recipient.ID, vaultKey); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error sealing secrets for recipient")
+			log.Printf("Error resealing secrets for recipient %s: %v", recipient.ID, err)
+			return
+		}
+	}
+
+	h.audit(r, user, "update_recipient", "Updated recipient: "+recipient.Name)
+
+	writeJSON(w, http.StatusOK, newAPIRecipient(recipient))
+}
+
+// HandleDeleteRecipient deletes a recipient unless that leaves a quorum protected secret unrecoverable
+func (h *APIV1Handler) HandleDeleteRecipient(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	recipient, ok := h.ownRecipient(w, r, user, r.PathValue("id"), http.StatusNotFound)
+	if !ok {
+		return
+	}
+
+	assignments, err := h.repo.ListSecretAssignmentsByRecipientID(r.Context(), recipient.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secret assignments")
+		log.Printf("Error fetching secret assignments: %v", err)
+		return
+	}
+
+	if !h.checkQuorumRemoval(w, r, assignments) {
+		return
+	}
+
+	if err := h.repo.DeleteRecipient(r.Context(), recipient.ID); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error deleting recipient")
+		log.Printf("Error deleting recipient: %v", err)
+		return
+	}
+
+	h.audit(r, user, "delete_recipient", "Deleted recipient: "+recipient.Name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleTestRecipient sends a test contact email with a confirmation link to a recipient
+func (h *APIV1Handler) HandleTestRecipient(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	recipient, ok := h.ownRecipient(w, r, user, r.PathValue("id"), http.StatusNotFound)
+	if !ok {
+		return
+	}
+
+	if h.emailClient == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "email is not configured on this server")
+		return
+	}
+
+	if err := sendTestContact(r.Context(), h.repo, h.emailClient, r, user, recipient); err != nil {
+		writeAPIError(w, http.StatusBadGateway, "error sending test contact email")
+		log.Printf("Error sending test contact email: %v", err)
+		return
+	}
+
+	h.audit(r, user, "test_contact_recipient", "Sent test contact to recipient: "+recipient.Name)
+
+	writeJSON(w, http.StatusOK, newAPIRecipient(recipient))
+}
+
+// HandleGetRecipientQuestions lists a recipient's personal questions
+func (h *APIV1Handler) HandleGetRecipientQuestions(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	recipient, ok := h.ownRecipient(w, r, user, r.PathValue("id"), http.StatusNotFound)
+	if !ok {
+		return
+	}
+
+	h.writeRecipientQuestions(w, r, recipient)
+}
+
+// HandleSetRecipientQuestions replaces a recipient's personal questions and
+// rebuilds their copies, which needs an unlocked vault
+func (h *APIV1Handler) HandleSetRecipientQuestions(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	recipient, ok := h.ownRecipient(w, r, user, r.PathValue("id"), http.StatusNotFound)
+	if !ok {
+		return
+	}
+
+	var req apiSetRecipientQuestionsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	questions := make([]string, 0, len(req.Questions))
+	answers := make([]string, 0, len(req.Questions))
+	for _, question := range req.Questions {
+		questions = append(questions, question.Question)
+		answers = append(answers, question.Answer)
+	}
+
+	threshold := req.Threshold
+	if threshold == 0 {
+		threshold = (2*len(questions) + 2) / 3
+	}
+
+	var vaultKey []byte
+	if h.sealer.Enabled() {
+		if vaultKey, ok = h.requireAPIVaultKey(w, r); !ok {
+			return
+		}
+	}
+
+	err := h.sealer.SetQuestions(r.Context(), recipient, questions, answers, threshold, vaultKey)
+	if errors.Is(err, delivery.ErrInvalidQuestions) {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error saving questions")
+		log.Printf("Error saving questions for recipient %s: %v", recipient.ID, err)
+		return
+	}
+
+	if len(questions) == 0 {
+		h.audit(r, user, "update_recipient_questions", "Removed the personal questions of recipient: "+recipient.Name)
+	} else {
+		h.audit(r, user, "update_recipient_questions",
+			fmt.Sprintf("Set %d personal questions for recipient %s, %d must be answered", len(questions), recipient.Name, threshold))
+	}
+
+	h.writeRecipientQuestions(w, r, recipient)
+}
+
+// writeRecipientQuestions answers with the questions of a recipient
+func (h *APIV1Handler) writeRecipientQuestions(w http.ResponseWriter, r *http.Request, recipient *models.Recipient) {
+	questions, err := h.repo.ListRecipientQuestions(r.Context(), recipient.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching questions")
+		log.Printf("Error fetching questions: %v", err)
+		return
+	}
+
+	response := apiRecipientQuestions{Threshold: recipient.QuestionThreshold, Questions: []string{}}
+	for _, question := range questions {
+		response.Questions = append(response.Questions, question.Question)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// HandleListAssignments lists which secrets are assigned to which recipients
+func (h *APIV1Handler) HandleListAssignments(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	assignments, err := h.repo.ListSecretAssignmentsByUserID(r.Context(), user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secret assignments")
+		log.Printf("Error fetching secret assignments: %v", err)
+		return
+	}
+
+	if assignments == nil {
+		assignments = []*models.SecretAssignment{}
+	}
+
+	writeJSON(w, http.StatusOK, assignments)
+}
+
+// HandleCreateAssignment assigns a secret to a recipient and reseals the recipient copies
+func (h *APIV1Handler) HandleCreateAssignment(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiCreateAssignmentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.SecretID == "" || req.RecipientID == "" {
+		writeAPIError(w, http.StatusBadRequest, "secret_id and recipient_id are required")
+		return
+	}
+
+	secret, ok := h.ownSecret(w, r, user, req.SecretID)
+	if !ok {
+		return
+	}
+
+	recipient, ok := h.ownRecipient(w, r, user, req.RecipientID, http.StatusNotFound)
+	if !ok {
+		return
+	}
+
+	existing, err := h.repo.ListSecretAssignmentsBySecretID(r.Context(), secret.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secret assignments")
+		log.Printf("Error fetching secret assignments: %v", err)
+		return
+	}
+
+	for _, assignment := range existing {
+		if assignment.RecipientID == recipient.ID {
+			writeAPIError(w, http.StatusConflict, "secret is already assigned to this recipient")
+			return
+		}
+	}
+
+	// Recipient copies are resealed for the new recipient,
+	// which needs the owner's copy of the content
+	resealNeeded := h.sealer.Enabled() || secret.IsQuorumProtected()
+
+	var vaultKey []byte
+	if resealNeeded {
+		if vaultKey, ok = h.requireAPIVaultKey(w, r); !ok {
+			return
+		}
+	}
+
+	assignment := &models.SecretAssignment{
+		SecretID:    secret.ID,
+		RecipientID: recipient.ID,
+		UserID:      user.ID,
+	}
+
+	if err := h.repo.CreateSecretAssignment(r.Context(), assignment); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error creating secret assignment")
+		log.Printf("Error creating secret assignment: %v", err)
+		return
+	}
+
+	if resealNeeded {
+		if err := h.sealer.ResealSecret(r.Context(), secret, vaultKey); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error sealing secret for recipients")
+			log.Printf("Error resealing secret %s: %v", secret.ID, err)
+			return
+		}
+	}
+
+	h.audit(r, user, "assign_secret", fmt.Sprintf("Assigned secret %s to recipient %s", secret.Name, recipient.Name))
+
+	writeJSON(w, http.StatusCreated, assignment)
+}
+
+// HandleDeleteAssignment removes an assignment unless that leaves a quorum protected secret unrecoverable
+func (h *APIV1Handler) HandleDeleteAssignment(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	assignment, err := h.repo.GetSecretAssignmentByID(r.Context(), r.PathValue("id"))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secret assignment")
+		log.Printf("Error fetching secret assignment: %v", err)
+		return
+	}
+	if assignment == nil || assignment.UserID != user.ID {
+		writeAPIError(w, http.StatusNotFound, "assignment not found")
+		return
+	}
+
+	secret, ok := h.ownSecret(w, r, user, assignment.SecretID)
+	if !ok {
+		return
+	}
+
+	if !h.checkQuorumRemoval(w, r, []*models.SecretAssignment{assignment}) {
+		return
+	}
+
+	resealNeeded := secret.IsQuorumProtected()
+
+	var vaultKey []byte
+	if resealNeeded {
+		if vaultKey, ok = h.requireAPIVaultKey(w, r); !ok {
+			return
+		}
+	}
+
+	if err := h.repo.DeleteSecretAssignment(r.Context(), assignment.ID); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error deleting secret assignment")
+		log.Printf("Error deleting secret assignment: %v", err)
+		return
+	}
+
+	// The key of a quorum protected secret is split again among the remaining recipients
+	if resealNeeded {
+		if err := h.sealer.ResealSecret(r.Context(), secret, vaultKey); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error sealing secret for recipients")
+			log.Printf("Error resealing secret %s: %v", secret.ID, err)
+			return
+		}
+	}
+
+	h.audit(r, user, "unassign_secret", "Removed a recipient from secret: "+secret.Name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownRecipient fetches a recipient of the user. Recipients of other users are
+// reported as not found with the given status.
+func (h *APIV1Handler) ownRecipient(w http.ResponseWriter, r *http.Request, user *models.User, id string, notFoundStatus int) (*models.Recipient, bool) {
+	recipient, err := h.repo.GetRecipientByID(r.Context(), id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching recipient")
+		log.Printf("Error fetching recipient: %v", err)
+		return nil, false
+	}
+
+	if recipient == nil || recipient.UserID != user.ID {
+		writeAPIError(w, notFoundStatus, fmt.Sprintf("recipient %s not found", id))
+		return nil, false
+	}
+
+	return recipient, true
+}
+
+// checkQuorumRemoval answers with 409 if removing the assignments would leave
+// a quorum protected secret with fewer recipients than its threshold
+func (h *APIV1Handler) checkQuorumRemoval(w http.ResponseWriter, r *http.Request, removed []*models.SecretAssignment) bool {
+	blocked, err := findQuorumBlockedByRemoval(r.Context(), h.repo, removed)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error checking quorum protected secrets")
+		log.Printf("Error checking quorum protected secrets: %v", err)
+		return false
+	}
+
+	if blocked != nil {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("secret %q needs at least %d recipients", blocked.Name, blocked.QuorumThreshold))
+		return false
+	}
+
+	return true
+}
+
+// validReleaseDelay checks the release delay of a recipient and writes an error if it is out of range
+func validReleaseDelay(w http.ResponseWriter, days int) bool {
+	if days < 0 || days > models.MaxReleaseDelayDays {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("release_delay_days must be between 0 and %d", models.MaxReleaseDelayDays))
+		return false
+	}
+
+	return true
+}
+
+// validPublicKey answers with 400 if a public key is set but can't be encrypted to.
+// It returns the key without surrounding whitespace.
+func validPublicKey(w http.ResponseWriter, publicKey string) (string, bool) {
+	publicKey = strings.TrimSpace(publicKey)
+	if publicKey == "" {
+		return "", true
+	}
+
+	if _, err := crypto.ParsePublicKey(publicKey); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+
+	return publicKey, true
+}
+
+// newAPIRecipient converts a recipient for the API
+func newAPIRecipient(recipient *models.Recipient) apiRecipient {
+	return apiRecipient{
+		ID:                 recipient.ID,
+		Name:               recipient.Name,
+		Email:              recipient.Email,
+		Message:            recipient.Message,
+		PhoneNumber:        recipient.PhoneNumber,
+		IsConfirmed:        recipient.IsConfirmed,
+		ConfirmedAt:        recipient.ConfirmedAt,
+		ConfirmationSentAt: recipient.ConfirmationSentAt,
+		ReleaseDelayDays:   recipient.ReleaseDelayDays,
+		PublicKey:          recipient.PublicKey,
+		QuestionThreshold:  recipient.QuestionThreshold,
+		CreatedAt:          recipient.CreatedAt,
+		UpdatedAt:          recipient.UpdatedAt,
+	}
+}
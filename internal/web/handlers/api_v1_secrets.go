@@ -0,0 +1,550 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+// apiSecret is a secret without its content
+type apiSecret struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	EncryptionType  string    `json:"encryption_type"`
+	Type            string    `json:"type"` // Type of a text secret, or "file" for a file secret
+	QuorumThreshold int       `json:"quorum_threshold"`
+	FileSize        int64     `json:"file_size,omitempty"` // Size of the uploaded file of a file secret
+	RecipientIDs    []string  `json:"recipient_ids"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// apiSecretContent is the decrypted content of a secret. For secrets encrypted
+// on the client the content is the envelope, for file secrets it is the JSON
+// with the name, type, size and age identity of the file. The fields of a
+// typed secret are returned separately too.
+type apiSecretContent struct {
+	ID             string             `json:"id"`
+	Name           string             `json:"name"`
+	EncryptionType string             `json:"encryption_type"`
+	Content        string             `json:"content"`
+	Fields         secrettypes.Values `json:"fields,omitempty"`
+}
+
+// apiCreateSecretRequest creates a secret and assigns it to recipients
+type apiCreateSecretRequest struct {
+	Name            string   `json:"name"`
+	Content         string   `json:"content,omitempty"`         // Required unless the secret is typed
+	EncryptionType  string   `json:"encryption_type,omitempty"` // EncryptionTypeClient if content is an envelope
+	RecipientIDs    []string `json:"recipient_ids,omitempty"`
+	QuorumThreshold int      `json:"quorum_threshold,omitempty"`
+	// A typed secret is created from its fields instead of the content
+	Type   string             `json:"type,omitempty"`
+	Fields secrettypes.Values `json:"fields,omitempty"`
+}
+
+// apiUpdateSecretRequest changes the fields that are set
+type apiUpdateSecretRequest struct {
+	Name            *string            `json:"name,omitempty"`
+	Content         *string            `json:"content,omitempty"`
+	Fields          secrettypes.Values `json:"fields,omitempty"` // Replaces the fields of a typed secret
+	QuorumThreshold *int               `json:"quorum_threshold,omitempty"`
+}
+
+// HandleListSecrets lists the user's secrets
+func (h *APIV1Handler) HandleListSecrets(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	secrets, err := h.repo.ListSecretsByUserID(r.Context(), user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secrets")
+		log.Printf("Error fetching secrets: %v", err)
+		return
+	}
+
+	assignments, err := h.repo.ListSecretAssignmentsByUserID(r.Context(), user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secret assignments")
+		log.Printf("Error fetching secret assignments: %v", err)
+		return
+	}
+
+	recipientIDs := make(map[string][]string)
+	for _, assignment := range assignments {
+		recipientIDs[assignment.SecretID] = append(recipientIDs[assignment.SecretID], assignment.RecipientID)
+	}
+
+	result := make([]apiSecret, 0, len(secrets))
+	for _, secret := range secrets {
+		result = append(result, newAPISecret(secret, recipientIDs[secret.ID]))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// HandleCreateSecret creates a secret encrypted with the user's vault key, or
+// stores a secret the client already encrypted
+func (h *APIV1Handler) HandleCreateSecret(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiCreateSecretRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	typ, err := secrettypes.Lookup(req.Type)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The fields of a typed secret are checked and stored as its content
+	if !typ.IsNote() {
+		if req.Content != "" || req.EncryptionType == models.EncryptionTypeClient {
+			writeAPIError(w, http.StatusBadRequest, "typed secrets are created from their fields")
+			return
+		}
+
+		content, err := typedContent(typ, req.Fields)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Content = string(content)
+	}
+
+	if req.Name == "" || req.Content == "" {
+		writeAPIError(w, http.StatusBadRequest, "name and content are required")
+		return
+	}
+
+	clientEncrypted := req.EncryptionType == models.EncryptionTypeClient
+	if req.EncryptionType != "" && !clientEncrypted && req.EncryptionType != models.EncryptionTypeVault {
+		writeAPIError(w, http.StatusBadRequest, "unsupported encryption_type")
+		return
+	}
+	if clientEncrypted && !validEnvelope(w, req.Content) {
+		return
+	}
+
+	recipientIDs := uniqueStrings(req.RecipientIDs)
+	for _, recipientID := range recipientIDs {
+		if _, ok := h.ownRecipient(w, r, user, recipientID, http.StatusBadRequest); !ok {
+			return
+		}
+	}
+
+	if req.QuorumThreshold != 0 {
+		if !h.sealer.Enabled() {
+			writeAPIError(w, http.StatusBadRequest, "quorum protection requires a server master key")
+			return
+		}
+
+		if err := delivery.ValidateQuorum(req.QuorumThreshold, len(recipientIDs)); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Envelopes are stored as they are
+	encryptedData, encryptionType := req.Content, models.EncryptionTypeClient
+	if !clientEncrypted {
+		vaultKey, ok := h.requireAPIVaultKey(w, r)
+		if !ok {
+			return
+		}
+
+		encryptedData, err = crypto.EncryptSecret([]byte(req.Content), vaultKey)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error encrypting secret")
+			log.Printf("Error encrypting secret: %v", err)
+			return
+		}
+		encryptionType = models.EncryptionTypeVault
+	}
+
+	secret := &models.Secret{
+		UserID:          user.ID,
+		Name:            req.Name,
+		EncryptedData:   encryptedData,
+		EncryptionType:  encryptionType,
+		QuorumThreshold: req.QuorumThreshold,
+		Type:            typ.ID,
+	}
+
+	if err := h.repo.CreateSecret(r.Context(), secret); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error creating secret")
+		log.Printf("Error creating secret: %v", err)
+		return
+	}
+
+	for _, recipientID := range recipientIDs {
+		assignment := &models.SecretAssignment{
+			SecretID:    secret.ID,
+			RecipientID: recipientID,
+			UserID:      user.ID,
+		}
+
+		if err := h.repo.CreateSecretAssignment(r.Context(), assignment); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error creating secret assignment")
+			log.Printf("Error creating secret assignment: %v", err)
+			return
+		}
+	}
+
+	// Seal the recipient copies now that the recipients are assigned
+	if h.sealer.Enabled() {
+		if err := h.sealer.Reseal(r.Context(), secret, []byte(req.Content)); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error sealing secret for recipients")
+			log.Printf("Error sealing secret %s: %v", secret.ID, err)
+			return
+		}
+	}
+
+	h.audit(r, user, "create_secret", "Created secret: "+secret.Name)
+
+	writeJSON(w, http.StatusCreated, newAPISecret(secret, recipientIDs))
+}
+
+// HandleGetSecret returns a secret without its content
+func (h *APIV1Handler) HandleGetSecret(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, ok := h.ownSecret(w, r, user, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	h.writeSecret(w, r, http.StatusOK, secret)
+}
+
+// HandleGetSecretContent returns the decrypted content of a secret
+func (h *APIV1Handler) HandleGetSecretContent(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, ok := h.ownSecret(w, r, user, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	content := []byte(secret.EncryptedData)
+	if !secret.IsClientEncrypted() {
+		vaultKey, ok := h.requireAPIVaultKey(w, r)
+		if !ok {
+			return
+		}
+
+		var err error
+		content, err = crypto.DecryptSecret(secret.EncryptedData, vaultKey)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error decrypting secret")
+			log.Printf("Error decrypting secret %s: %v", secret.ID, err)
+			return
+		}
+
+		if err := h.vault.UpgradeSecret(r.Context(), secret, content, vaultKey); err != nil {
+			log.Printf("Error upgrading secret %s: %v", secret.ID, err)
+		}
+	}
+
+	result := apiSecretContent{
+		ID:             secret.ID,
+		Name:           secret.Name,
+		EncryptionType: secret.EncryptionType,
+		Content:        string(content),
+	}
+	if typ := secretType(secret); !typ.IsNote() && !secret.IsFile() && !secret.IsClientEncrypted() {
+		fields, err := typ.Decode(content)
+		if err != nil {
+			log.Printf("Error reading secret %s: %v", secret.ID, err)
+		}
+		result.Fields = fields
+	}
+
+	h.audit(r, user, "view_secret", "Viewed secret: "+secret.Name)
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// HandleUpdateSecret changes the name, content or quorum threshold of a secret
+func (h *APIV1Handler) HandleUpdateSecret(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, ok := h.ownSecret(w, r, user, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	var req apiUpdateSecretRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name != nil && *req.Name == "" {
+		writeAPIError(w, http.StatusBadRequest, "name must not be empty")
+		return
+	}
+	if req.Content != nil && *req.Content == "" {
+		writeAPIError(w, http.StatusBadRequest, "content must not be empty")
+		return
+	}
+	if req.Content != nil && secret.IsFile() {
+		writeAPIError(w, http.StatusBadRequest, "the content of a file secret can only be changed by uploading a new file")
+		return
+	}
+	if req.Content != nil && secret.IsClientEncrypted() && !validEnvelope(w, *req.Content) {
+		return
+	}
+
+	// Typed secrets are changed field by field, all fields are replaced
+	typ := secretType(secret)
+	switch {
+	case req.Fields != nil && (typ.IsNote() || secret.IsFile() || secret.IsClientEncrypted()):
+		writeAPIError(w, http.StatusBadRequest, "only typed secrets have fields")
+		return
+	case req.Content != nil && !typ.IsNote():
+		writeAPIError(w, http.StatusBadRequest, "the content of a typed secret is changed with its fields")
+		return
+	case req.Fields != nil:
+		content, err := typedContent(typ, req.Fields)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		typed := string(content)
+		req.Content = &typed
+	}
+
+	wasQuorumProtected := secret.IsQuorumProtected()
+
+	// The revision as it is now is kept if the name or the content change
+	previous := newSecretVersion(secret)
+
+	if req.QuorumThreshold != nil && *req.QuorumThreshold != 0 {
+		if !h.sealer.Enabled() {
+			writeAPIError(w, http.StatusBadRequest, "quorum protection requires a server master key")
+			return
+		}
+
+		assignments, err := h.repo.ListSecretAssignmentsBySecretID(r.Context(), secret.ID)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error fetching secret assignments")
+			log.Printf("Error fetching secret assignments: %v", err)
+			return
+		}
+
+		if err := delivery.ValidateQuorum(*req.QuorumThreshold, len(assignments)); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Recipient copies are rebuilt whenever the content or the quorum changes
+	resealNeeded := (req.Content != nil || req.QuorumThreshold != nil) &&
+		(h.sealer.Enabled() || wasQuorumProtected)
+
+	// Secrets encrypted on the client are stored and resealed as they are
+	var vaultKey []byte
+	if (req.Content != nil || resealNeeded) && !secret.IsClientEncrypted() {
+		if vaultKey, ok = h.requireAPIVaultKey(w, r); !ok {
+			return
+		}
+	}
+
+	if req.Content != nil && secret.IsClientEncrypted() {
+		secret.EncryptedData = *req.Content
+	} else if req.Content != nil {
+		encryptedData, err := crypto.EncryptSecret([]byte(*req.Content), vaultKey)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error encrypting secret")
+			log.Printf("Error encrypting secret: %v", err)
+			return
+		}
+		secret.EncryptedData = encryptedData
+		secret.EncryptionType = models.EncryptionTypeVault
+	}
+
+	if req.Name != nil {
+		secret.Name = *req.Name
+	}
+	if req.QuorumThreshold != nil {
+		secret.QuorumThreshold = *req.QuorumThreshold
+	}
+	secret.UpdatedAt = time.Now().UTC()
+
+	versionKept := versionChanged(previous, secret)
+	if !versionKept {
+		previous = nil
+	}
+
+	if err := updateSecretKeepingVersion(r.Context(), h.repo, secret, previous, h.keepVersions); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error updating secret")
+		log.Printf("Error updating secret: %v", err)
+		return
+	}
+
+	if versionKept {
+		pruneSecretVersions(r.Context(), h.repo, h.files, secret.ID, h.keepVersions)
+	}
+
+	if resealNeeded {
+		if err := h.sealer.ResealSecret(r.Context(), secret, vaultKey); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error sealing secret for recipients")
+			log.Printf("Error resealing secret %s: %v", secret.ID, err)
+			return
+		}
+	}
+
+	h.audit(r, user, "update_secret", "Updated secret: "+secret.Name)
+
+	h.writeSecret(w, r, http.StatusOK, secret)
+}
+
+// HandleDeleteSecret deletes a secret with its assignments and revisions
+func (h *APIV1Handler) HandleDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	user, ok := apiUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, ok := h.ownSecret(w, r, user, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	assignments, err := h.repo.ListSecretAssignmentsBySecretID(r.Context(), secret.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secret assignments")
+		log.Printf("Error fetching secret assignments: %v", err)
+		return
+	}
+
+	for _, assignment := range assignments {
+		if err := h.repo.DeleteSecretAssignment(r.Context(), assignment.ID); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "error deleting secret assignment")
+			log.Printf("Error deleting secret assignment: %v", err)
+			return
+		}
+	}
+
+	// The files of the secret and its revisions are deleted once the secret is gone
+	fileRefs := secretFileRefs(r.Context(), h.repo, secret)
+
+	if err := h.repo.DeleteSecret(r.Context(), secret.ID); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error deleting secret")
+		log.Printf("Error deleting secret: %v", err)
+		return
+	}
+
+	deleteFiles(h.files, fileRefs)
+
+	h.audit(r, user, "delete_secret", "Deleted secret: "+secret.Name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownSecret fetches a secret of the user. Secrets of other users are reported as not found.
+func (h *APIV1Handler) ownSecret(w http.ResponseWriter, r *http.Request, user *models.User, id string) (*models.Secret, bool) {
+	secret, err := h.repo.GetSecretByID(r.Context(), id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secret")
+		log.Printf("Error fetching secret: %v", err)
+		return nil, false
+	}
+
+	if secret == nil || secret.UserID != user.ID {
+		writeAPIError(w, http.StatusNotFound, "secret not found")
+		return nil, false
+	}
+
+	return secret, true
+}
+
+// writeSecret writes a secret together with its recipients
+func (h *APIV1Handler) writeSecret(w http.ResponseWriter, r *http.Request, status int, secret *models.Secret) {
+	assignments, err := h.repo.ListSecretAssignmentsBySecretID(r.Context(), secret.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "error fetching secret assignments")
+		log.Printf("Error fetching secret assignments: %v", err)
+		return
+	}
+
+	recipientIDs := make([]string, 0, len(assignments))
+	for _, assignment := range assignments {
+		recipientIDs = append(recipientIDs, assignment.RecipientID)
+	}
+
+	writeJSON(w, status, newAPISecret(secret, recipientIDs))
+}
+
+// newAPISecret converts a secret for the API
+func newAPISecret(secret *models.Secret, recipientIDs []string) apiSecret {
+	if recipientIDs == nil {
+		recipientIDs = []string{}
+	}
+
+	return apiSecret{
+		ID:              secret.ID,
+		Name:            secret.Name,
+		EncryptionType:  secret.EncryptionType,
+		Type:            apiSecretType(secret),
+		QuorumThreshold: secret.QuorumThreshold,
+		FileSize:        secret.FileSize,
+		RecipientIDs:    recipientIDs,
+		CreatedAt:       secret.CreatedAt,
+		UpdatedAt:       secret.UpdatedAt,
+	}
+}
+
+// apiSecretType returns the type of a secret as it is shown in the API
+func apiSecretType(secret *models.Secret) string {
+	if secret.IsFile() {
+		return "file"
+	}
+	return secretType(secret).ID
+}
+
+// validEnvelope checks that content was encrypted on the client and writes an error response if not
+func validEnvelope(w http.ResponseWriter, content string) bool {
+	if _, err := crypto.ParseClientEnvelope(content); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// uniqueStrings removes duplicates while keeping the order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		result = append(result, value)
+	}
+	return result
+}
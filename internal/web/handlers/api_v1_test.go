@@ -0,0 +1,875 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/utils"
+)
+
+// setupAPIV1Test creates a user with a password and one recipient
+func setupAPIV1Test(t *testing.T, masterKey []byte) (*storage.MockRepository, *APIV1Handler, *models.User) {
+	t.Helper()
+
+	repo := storage.NewMockRepository()
+
+	passwordHash, err := utils.HashPassword("password")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	user := &models.User{
+		ID:            "user123",
+		Email:         "test@example.com",
+		PasswordHash:  passwordHash,
+		LastActivity:  time.Now().UTC().Add(-24 * time.Hour),
+		PingFrequency: 7,
+		PingDeadline:  14,
+		PingMethod:    "email",
+	}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients, &models.Recipient{
+		ID:     "recipient1",
+		UserID: user.ID,
+		Name:   "Alice",
+		Email:  "alice@example.com",
+	})
+
+	handler := NewAPIV1Handler(repo, nil, auth.NewVaultService(repo), delivery.NewSealer(repo, masterKey, nil), testAdminToken)
+	return repo, handler, user
+}
+
+// testAdminToken is the ADMIN_TOKEN of the handlers in the API tests
+const testAdminToken = "admin-token-for-testing-purposes"
+
+// newAPIV1Request creates a request authenticated with an API token of the user
+func newAPIV1Request(user *models.User, method, target, body string) *http.Request {
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	token := &models.APIToken{
+		ID:     "token1",
+		UserID: user.ID,
+		Name:   "laptop",
+		Scopes: models.APITokenScopes,
+	}
+
+	ctx := context.WithValue(req.Context(), middleware.UserContextKey, user)
+	ctx = context.WithValue(ctx, middleware.APITokenContextKey, token)
+	return req.WithContext(ctx)
+}
+
+func decodeAPIResponse(t *testing.T, rr *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Expected JSON response, got content type %q", ct)
+	}
+	if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+		t.Fatalf("Could not decode response: %v", err)
+	}
+}
+
+func TestAPIV1SecretLifecycle(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, nil)
+
+	// Creating a secret needs the vault
+	rr := httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newAPIV1Request(user, "POST", "/api/v1/secrets", `{"name":"Bank","content":"1234"}`))
+	if rr.Code != http.StatusLocked {
+		t.Fatalf("Expected status 423 with a locked vault, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleUnlockVault(rr, newAPIV1Request(user, "POST", "/api/v1/vault/unlock", `{"password":"wrong"}`))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 for a wrong password, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleUnlockVault(rr, newAPIV1Request(user, "POST", "/api/v1/vault/unlock", `{"password":"password"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 when unlocking, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var vaultStatus apiVaultStatus
+	decodeAPIResponse(t, rr, &vaultStatus)
+	if until := time.Until(vaultStatus.ExpiresAt); until > apiVaultUnlockDuration || until < apiVaultUnlockDuration-time.Minute {
+		t.Errorf("Expected the vault to stay unlocked for %v, got %v", apiVaultUnlockDuration, until)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newAPIV1Request(user, "POST", "/api/v1/secrets", `{"name":"Bank","content":"1234","recipient_ids":["recipient1"]}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created apiSecret
+	decodeAPIResponse(t, rr, &created)
+	if created.Name != "Bank" || len(created.RecipientIDs) != 1 || created.RecipientIDs[0] != "recipient1" {
+		t.Errorf("Unexpected secret in response: %+v", created)
+	}
+	if len(repo.SecretAssignments) != 1 {
+		t.Errorf("Expected 1 secret assignment, got %d", len(repo.SecretAssignments))
+	}
+
+	// The listing never contains the encrypted content
+	rr = httptest.NewRecorder()
+	handler.HandleListSecrets(rr, newAPIV1Request(user, "GET", "/api/v1/secrets", ""))
+	if strings.Contains(rr.Body.String(), repo.Secrets[0].EncryptedData) {
+		t.Error("Expected the secret listing to leave out the encrypted data")
+	}
+
+	req := newAPIV1Request(user, "GET", "/api/v1/secrets/"+created.ID+"/content", "")
+	req.SetPathValue("id", created.ID)
+	rr = httptest.NewRecorder()
+	handler.HandleGetSecretContent(rr, req)
+	var content apiSecretContent
+	decodeAPIResponse(t, rr, &content)
+	if content.Content != "1234" {
+		t.Errorf("Expected decrypted content %q, got %q", "1234", content.Content)
+	}
+
+	req = newAPIV1Request(user, "DELETE", "/api/v1/secrets/"+created.ID, "")
+	req.SetPathValue("id", created.ID)
+	rr = httptest.NewRecorder()
+	handler.HandleDeleteSecret(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(repo.Secrets) != 0 || len(repo.SecretAssignments) != 0 {
+		t.Errorf("Expected secret and assignments to be deleted, got %d secrets and %d assignments", len(repo.Secrets), len(repo.SecretAssignments))
+	}
+
+	// Token requests are attributed in the audit log
+	if len(repo.AuditLogs) == 0 || !strings.Contains(repo.AuditLogs[len(repo.AuditLogs)-1].Details, `API token "laptop"`) {
+		t.Error("Expected the audit log to name the API token")
+	}
+}
+
+// TestAPIV1UnlockVault tests that the vault needs a write token and that wrong passwords are limited
+func TestAPIV1UnlockVault(t *testing.T) {
+	_, handler, user := setupAPIV1Test(t, nil)
+
+	// A read-only token can't get at secret content
+	for _, route := range handler.Routes() {
+		switch route.Method + " " + route.Path {
+		case "POST /vault/unlock", "GET /secrets/{id}/content", "POST /backup/export":
+			if route.Scope != models.APITokenScopeWrite {
+				t.Errorf("Expected %s %s to need the write scope, got %s", route.Method, route.Path, route.Scope)
+			}
+		}
+	}
+
+	for i := 1; i < auth.MaxUnlockAttempts; i++ {
+		rr := httptest.NewRecorder()
+		handler.HandleUnlockVault(rr, newAPIV1Request(user, "POST", "/api/v1/vault/unlock", `{"password":"wrong"}`))
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("Expected status 403 for a wrong password, got %d", rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.HandleUnlockVault(rr, newAPIV1Request(user, "POST", "/api/v1/vault/unlock", `{"password":"wrong"}`))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 after %d wrong passwords, got %d", auth.MaxUnlockAttempts, rr.Code)
+	}
+
+	// Not even the right password unlocks the vault now
+	rr = httptest.NewRecorder()
+	handler.HandleUnlockVault(rr, newAPIV1Request(user, "POST", "/api/v1/vault/unlock", `{"password":"password"}`))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 while unlocking is blocked, got %d", rr.Code)
+	}
+	if _, err := handler.vault.Key("api-token:token1"); err == nil {
+		t.Error("Expected the vault to stay locked")
+	}
+}
+
+func TestAPIV1OtherUsersResources(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, nil)
+
+	repo.Secrets = append(repo.Secrets, &models.Secret{ID: "foreign-secret", UserID: "someone-else", Name: "Theirs"})
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "foreign-recipient", UserID: "someone-else"})
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		id      string
+		body    string
+		want    int
+	}{
+		{"get foreign secret", handler.HandleGetSecret, "GET", "foreign-secret", "", http.StatusNotFound},
+		{"delete foreign secret", handler.HandleDeleteSecret, "DELETE", "foreign-secret", "", http.StatusNotFound},
+		{"get unknown secret", handler.HandleGetSecret, "GET", "missing", "", http.StatusNotFound},
+		{"update foreign recipient", handler.HandleUpdateRecipient, "PATCH", "foreign-recipient", `{"name":"x"}`, http.StatusNotFound},
+		{"assign foreign secret", handler.HandleCreateAssignment, "POST", "", `{"secret_id":"foreign-secret","recipient_id":"recipient1"}`, http.StatusNotFound},
+		{"create secret for foreign recipient", handler.HandleCreateSecret, "POST", "", `{"name":"a","content":"b","recipient_ids":["foreign-recipient"]}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newAPIV1Request(user, tt.method, "/api/v1/x", tt.body)
+			req.SetPathValue("id", tt.id)
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if repo.Secrets[0].Name != "Theirs" || len(repo.SecretAssignments) != 0 {
+		t.Error("Expected other users' resources to stay untouched")
+	}
+}
+
+func TestAPIV1UpdateSettings(t *testing.T) {
+	_, handler, user := setupAPIV1Test(t, nil)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"valid", `{"ping_frequency":3,"ping_method":"both"}`, http.StatusOK},
+		{"frequency out of range", `{"ping_frequency":31}`, http.StatusBadRequest},
+		{"deadline out of range", `{"ping_deadline":2}`, http.StatusBadRequest},
+		{"unknown method", `{"ping_method":"carrier pigeon"}`, http.StatusBadRequest},
+		{"emergency access", `{"emergency_access_days":3}`, http.StatusOK},
+		{"emergency access too long", `{"emergency_access_days":14}`, http.StatusBadRequest},
+		{"negative emergency access", `{"emergency_access_days":-1}`, http.StatusBadRequest},
+		{"unknown field", `{"ping_interval":3}`, http.StatusBadRequest},
+		{"not json", `ping_frequency=3`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.HandleUpdateSettings(rr, newAPIV1Request(user, "PATCH", "/api/v1/settings", tt.body))
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				var body apiError
+				decodeAPIResponse(t, rr, &body)
+				if body.Error == "" {
+					t.Error("Expected an error message in the response")
+				}
+			}
+		})
+	}
+
+	if user.PingFrequency != 3 || user.PingMethod != "both" || user.PingDeadline != 14 || user.EmergencyAccessDays != 3 {
+		t.Errorf("Expected only the valid updates to apply, got frequency %d, method %q, deadline %d, emergency access %d",
+			user.PingFrequency, user.PingMethod, user.PingDeadline, user.EmergencyAccessDays)
+	}
+
+	// Form posts are rejected
+	req := newAPIV1Request(user, "PATCH", "/api/v1/settings", "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.HandleUpdateSettings(rr, req)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415 for a form post, got %d", rr.Code)
+	}
+}
+
+func TestAPIV1RecipientReleaseDelay(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, nil)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"valid", `{"release_delay_days":30}`, http.StatusOK},
+		{"negative", `{"release_delay_days":-1}`, http.StatusBadRequest},
+		{"too long", `{"release_delay_days":366}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newAPIV1Request(user, "PATCH", "/api/v1/recipients/recipient1", tt.body)
+			req.SetPathValue("id", "recipient1")
+			rr := httptest.NewRecorder()
+			handler.HandleUpdateRecipient(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if delay := repo.Recipients[0].ReleaseDelayDays; delay != 30 {
+		t.Errorf("Expected only the valid release delay to apply, got %d", delay)
+	}
+}
+
+func TestAPIV1DeleteRecipientQuorum(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, []byte("0123456789abcdef0123456789abcdef"))
+
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient2", UserID: user.ID, Name: "Bob"})
+	repo.Secrets = append(repo.Secrets, &models.Secret{ID: "secret1", UserID: user.ID, Name: "Keys", QuorumThreshold: 2})
+	repo.SecretAssignments = append(repo.SecretAssignments,
+		&models.SecretAssignment{ID: "a1", SecretID: "secret1", RecipientID: "recipient1", UserID: user.ID},
+		&models.SecretAssignment{ID: "a2", SecretID: "secret1", RecipientID: "recipient2", UserID: user.ID},
+	)
+
+	req := newAPIV1Request(user, "DELETE", "/api/v1/recipients/recipient1", "")
+	req.SetPathValue("id", "recipient1")
+	rr := httptest.NewRecorder()
+	handler.HandleDeleteRecipient(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Expected status 409, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = newAPIV1Request(user, "DELETE", "/api/v1/assignments/a1", "")
+	req.SetPathValue("id", "a1")
+	rr = httptest.NewRecorder()
+	handler.HandleDeleteAssignment(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Expected status 409, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if len(repo.Recipients) != 2 || len(repo.SecretAssignments) != 2 {
+		t.Error("Expected recipients and assignments to stay in place")
+	}
+}
+
+func TestAPIV1ListAuditLogs(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, nil)
+
+	now := time.Now().UTC()
+	for i, action := range []string{"login", "check_in", "create_secret"} {
+		repo.AuditLogs = append(repo.AuditLogs, &models.AuditLog{
+			ID:        action,
+			UserID:    user.ID,
+			Action:    action,
+			Timestamp: now.Add(-time.Duration(i) * time.Hour),
+		})
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+		code  int
+	}{
+		{"all", "", 3, http.StatusOK},
+		{"limit", "?limit=1", 1, http.StatusOK},
+		{"since", "?since=" + now.Add(-90*time.Minute).Format(time.RFC3339), 2, http.StatusOK},
+		{"invalid since", "?since=yesterday", 0, http.StatusBadRequest},
+		{"invalid limit", "?limit=-1", 0, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.HandleListAuditLogs(rr, newAPIV1Request(user, "GET", "/api/v1/audit-logs"+tt.query, ""))
+			if rr.Code != tt.code {
+				t.Fatalf("Expected status %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			var logs []models.AuditLog
+			decodeAPIResponse(t, rr, &logs)
+			if len(logs) != tt.want {
+				t.Errorf("Expected %d entries, got %d", tt.want, len(logs))
+			}
+		})
+	}
+}
+
+func TestAPIV1Recovery(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, []byte("0123456789abcdef0123456789abcdef"))
+
+	// Nothing was released yet
+	rr := httptest.NewRecorder()
+	handler.HandleGetRecovery(rr, newAPIV1Request(user, "GET", "/api/v1/recovery", ""))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	firedAt := time.Now().UTC().Add(-time.Hour)
+	user.PingingEnabled = false
+	repo.DeliveryRuns = append(repo.DeliveryRuns, &models.DeliveryRun{
+		ID:        "run1",
+		UserID:    user.ID,
+		Status:    models.DeliveryRunStatusCompleted,
+		StartedAt: firedAt,
+		FiresAt:   firedAt,
+	})
+	repo.DeliveryEvents = append(repo.DeliveryEvents, &models.DeliveryEvent{
+		ID:          "event1",
+		UserID:      user.ID,
+		RunID:       "run1",
+		RecipientID: "recipient1",
+		SentAt:      firedAt,
+		Status:      models.DeliveryStatusSent,
+		Attempts:    1,
+	})
+	repo.AccessCodes = append(repo.AccessCodes, &models.AccessCode{
+		ID:              "code1",
+		RecipientID:     "recipient1",
+		UserID:          user.ID,
+		DeliveryEventID: "event1",
+		CreatedAt:       firedAt,
+		ExpiresAt:       firedAt.Add(7 * 24 * time.Hour),
+	})
+
+	rr = httptest.NewRecorder()
+	handler.HandleGetRecovery(rr, newAPIV1Request(user, "GET", "/api/v1/recovery", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var recovery apiRecovery
+	decodeAPIResponse(t, rr, &recovery)
+	if recovery.RunID != "run1" || len(recovery.Deliveries) != 1 {
+		t.Fatalf("Expected the delivery of run1, got %+v", recovery)
+	}
+	if delivery := recovery.Deliveries[0]; delivery.RecipientEmail != "alice@example.com" || delivery.Opened || delivery.OpenCodes != 1 {
+		t.Errorf("Unexpected delivery %+v", delivery)
+	}
+
+	// Recovering reseals quorum secrets, so it needs the vault
+	rr = httptest.NewRecorder()
+	handler.HandleRecover(rr, newAPIV1Request(user, "POST", "/api/v1/recovery", `{"notify":false}`))
+	if rr.Code != http.StatusLocked {
+		t.Fatalf("Expected status 423 with a locked vault, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleUnlockVault(rr, newAPIV1Request(user, "POST", "/api/v1/vault/unlock", `{"password":"password"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 when unlocking, got %d: %s", rr.Code, rr.Body.String())
+	}
+	vaultKey, err := handler.vault.Key("api-token:token1")
+	if err != nil {
+		t.Fatalf("Failed to get vault key: %v", err)
+	}
+
+	// A quorum secret whose shares were emailed, the server forgot them
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient2", UserID: user.ID, Name: "Bob", Email: "bob@example.com"})
+	encrypted, err := crypto.EncryptSecret([]byte("seed words"), vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+	repo.Secrets = append(repo.Secrets, &models.Secret{ID: "seed", UserID: user.ID, Name: "Seed", EncryptedData: encrypted, EncryptionType: models.EncryptionTypeVault, QuorumThreshold: 2})
+	repo.SecretAssignments = append(repo.SecretAssignments,
+		&models.SecretAssignment{ID: "assignment1", UserID: user.ID, SecretID: "seed", RecipientID: "recipient1"},
+		&models.SecretAssignment{ID: "assignment2", UserID: user.ID, SecretID: "seed", RecipientID: "recipient2"},
+	)
+
+	rr = httptest.NewRecorder()
+	handler.HandleRecover(rr, newAPIV1Request(user, "POST", "/api/v1/recovery", `{"notify":false}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var summary recoverySummary
+	decodeAPIResponse(t, rr, &summary)
+	if summary.RevokedCodes != 1 || summary.ResealedSecrets != 1 || summary.CancelledDeliveries != 0 || summary.NotifiedRecipients != 0 {
+		t.Errorf("Unexpected recovery summary %+v", summary)
+	}
+	for _, assignment := range repo.SecretAssignments {
+		if assignment.DeliveryData == "" {
+			t.Errorf("Expected a new share for %s", assignment.RecipientID)
+		}
+	}
+	if !user.PingingEnabled {
+		t.Error("Expected the switch to be re-armed")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleGetRecovery(rr, newAPIV1Request(user, "GET", "/api/v1/recovery", ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after the recovery, got %d", rr.Code)
+	}
+}
+
+func TestAPIV1NotFound(t *testing.T) {
+	_, handler, _ := setupAPIV1Test(t, nil)
+
+	rr := httptest.NewRecorder()
+	handler.HandleNotFound(rr, httptest.NewRequest("PUT", "/api/v1/secrets/abc", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status 405, got %d", rr.Code)
+	}
+	if allow := rr.Header().Get("Allow"); allow != "GET, PATCH, DELETE" {
+		t.Errorf("Expected Allow header %q, got %q", "GET, PATCH, DELETE", allow)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleNotFound(rr, httptest.NewRequest("GET", "/api/v1/nothing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestAPIV1RecipientPublicKey(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, nil)
+
+	_, ageRecipient, err := crypto.GenerateAgeIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+
+	req := newAPIV1Request(user, "POST", "/api/v1/recipients", `{"name":"Bob","email":"bob@example.com","public_key":"ssh-rsa AAAA"}`)
+	rr := httptest.NewRecorder()
+	handler.HandleCreateRecipient(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid key, got %d", rr.Code)
+	}
+
+	req = newAPIV1Request(user, "POST", "/api/v1/recipients", `{"name":"Bob","email":"bob@example.com","public_key":"`+ageRecipient+`"}`)
+	rr = httptest.NewRecorder()
+	handler.HandleCreateRecipient(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var created apiRecipient
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if created.PublicKey != ageRecipient || repo.Recipients[len(repo.Recipients)-1].PublicKey != ageRecipient {
+		t.Errorf("Expected the key to be saved, got %q", created.PublicKey)
+	}
+}
+
+func TestAPIV1ClientEncryptedSecret(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, nil)
+
+	envelope, err := crypto.EncryptClientEnvelope([]byte("1234"), "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Failed to encrypt envelope: %v", err)
+	}
+	body, err := json.Marshal(map[string]string{"name": "Bank", "content": envelope, "encryption_type": models.EncryptionTypeClient})
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+
+	// Plaintext is not an envelope
+	rr := httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newAPIV1Request(user, "POST", "/api/v1/secrets", `{"name":"Bank","content":"1234","encryption_type":"aes-256-gcm-client"}`))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for plaintext, got %d", rr.Code)
+	}
+
+	// Neither creating nor reading the secret needs the vault
+	rr = httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newAPIV1Request(user, "POST", "/api/v1/secrets", string(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created apiSecret
+	decodeAPIResponse(t, rr, &created)
+	if created.EncryptionType != models.EncryptionTypeClient || repo.Secrets[0].EncryptedData != envelope {
+		t.Errorf("Expected the envelope to be stored as it is, got %+v", created)
+	}
+
+	req := newAPIV1Request(user, "GET", "/api/v1/secrets/"+created.ID+"/content", "")
+	req.SetPathValue("id", created.ID)
+	rr = httptest.NewRecorder()
+	handler.HandleGetSecretContent(rr, req)
+	var content apiSecretContent
+	decodeAPIResponse(t, rr, &content)
+	if content.Content != envelope || content.EncryptionType != models.EncryptionTypeClient {
+		t.Errorf("Expected the envelope back, got %+v", content)
+	}
+
+	// Updates have to be envelopes too
+	req = newAPIV1Request(user, "PATCH", "/api/v1/secrets/"+created.ID, `{"content":"5678"}`)
+	req.SetPathValue("id", created.ID)
+	rr = httptest.NewRecorder()
+	handler.HandleUpdateSecret(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for plaintext, got %d", rr.Code)
+	}
+}
+
+func TestAPIV1TypedSecret(t *testing.T) {
+	_, handler, user := setupAPIV1Test(t, nil)
+
+	rr := httptest.NewRecorder()
+	handler.HandleUnlockVault(rr, newAPIV1Request(user, "POST", "/api/v1/vault/unlock", `{"password":"password"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 when unlocking, got %d", rr.Code)
+	}
+
+	// The fields are checked like in the web form
+	rr = httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newAPIV1Request(user, "POST", "/api/v1/secrets",
+		`{"name":"Savings","type":"bank_account","fields":{"institution":"Bank","iban":"DE89370400440532013001"}}`))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "IBAN") {
+		t.Errorf("Expected status 400 for a wrong IBAN, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newAPIV1Request(user, "POST", "/api/v1/secrets",
+		`{"name":"Savings","type":"bank_account","fields":{"institution":"Bank","iban":"de89370400440532013000"}}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created apiSecret
+	decodeAPIResponse(t, rr, &created)
+	if created.Type != "bank_account" {
+		t.Errorf("Expected type bank_account, got %q", created.Type)
+	}
+
+	req := newAPIV1Request(user, "GET", "/api/v1/secrets/"+created.ID+"/content", "")
+	req.SetPathValue("id", created.ID)
+	rr = httptest.NewRecorder()
+	handler.HandleGetSecretContent(rr, req)
+	var content apiSecretContent
+	decodeAPIResponse(t, rr, &content)
+	if content.Fields["iban"] != "DE89 3704 0044 0532 0130 00" {
+		t.Errorf("Expected the normalized IBAN in the fields, got %+v", content)
+	}
+
+	// Typed secrets are changed with their fields, not the content
+	req = newAPIV1Request(user, "PATCH", "/api/v1/secrets/"+created.ID, `{"content":"1234"}`)
+	req.SetPathValue("id", created.ID)
+	rr = httptest.NewRecorder()
+	handler.HandleUpdateSecret(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for content of a typed secret, got %d", rr.Code)
+	}
+}
+
+func TestAPIV1Import(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, nil)
+	data, _ := json.Marshal(map[string][]byte{"data": []byte(testBitwardenExport)})
+
+	// The preview leaves out the passwords
+	rr := httptest.NewRecorder()
+	handler.HandlePreviewImport(rr, newAPIV1Request(user, "POST", "/api/v1/secrets/import/preview", string(data)))
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "hunter2") {
+		t.Fatalf("Expected a preview without passwords, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var preview apiImportPreview
+	decodeAPIResponse(t, rr, &preview)
+	if preview.Format != "Bitwarden JSON" || len(preview.Entries) != 2 || preview.Entries[0].Username != "alice" || preview.Entries[1].Type != "note" {
+		t.Errorf("Unexpected preview: %+v", preview)
+	}
+
+	body := `{"data":"` + base64.StdEncoding.EncodeToString([]byte(testBitwardenExport)) + `","recipient_ids":["recipient1"]}`
+	rr = httptest.NewRecorder()
+	handler.HandleImport(rr, newAPIV1Request(user, "POST", "/api/v1/secrets/import", body))
+	if rr.Code != http.StatusLocked {
+		t.Fatalf("Expected status 423 with a locked vault, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleUnlockVault(rr, newAPIV1Request(user, "POST", "/api/v1/vault/unlock", `{"password":"password"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 when unlocking, got %d", rr.Code)
+	}
+
+	// Without a selection every entry is imported
+	rr = httptest.NewRecorder()
+	handler.HandleImport(rr, newAPIV1Request(user, "POST", "/api/v1/secrets/import", body))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created []apiSecret
+	decodeAPIResponse(t, rr, &created)
+	if len(created) != 2 || created[0].Type != "login" || created[1].Type != "note" || len(created[1].RecipientIDs) != 1 {
+		t.Errorf("Unexpected secrets: %+v", created)
+	}
+	if len(repo.Secrets) != 2 || len(repo.SecretAssignments) != 2 {
+		t.Errorf("Expected 2 secrets with 2 assignments, got %d and %d", len(repo.Secrets), len(repo.SecretAssignments))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleImport(rr, newAPIV1Request(user, "POST", "/api/v1/secrets/import", `{"data":"bm9wZQ==","entries":[0]}`))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown format, got %d", rr.Code)
+	}
+}
+
+func TestAPIV1Backup(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, nil)
+
+	rr := httptest.NewRecorder()
+	handler.HandleUnlockVault(rr, newAPIV1Request(user, "POST", "/api/v1/vault/unlock", `{"password":"password"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 when unlocking, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newAPIV1Request(user, "POST", "/api/v1/secrets", `{"name":"Bank","content":"PIN 1234","recipient_ids":["recipient1"]}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleExportBackup(rr, newAPIV1Request(user, "POST", "/api/v1/backup/export", `{"passphrase":"short"}`))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a short passphrase, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleExportBackup(rr, newAPIV1Request(user, "POST", "/api/v1/backup/export", `{"passphrase":"correct horse battery staple"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var export apiBackupExport
+	decodeAPIResponse(t, rr, &export)
+	if export.Backup.Format != "deadmanswitch-backup" || strings.Contains(rr.Body.String(), "PIN 1234") {
+		t.Fatalf("Expected an encrypted backup, got %s", rr.Body.String())
+	}
+
+	restore := func(passphrase string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(apiRestoreBackupRequest{Backup: export.Backup, Passphrase: passphrase})
+		rr := httptest.NewRecorder()
+		handler.HandleRestoreBackup(rr, newAPIV1Request(user, "POST", "/api/v1/backup/restore", string(body)))
+		return rr
+	}
+
+	if rr := restore("wrong horse battery staple"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a wrong passphrase, got %d", rr.Code)
+	}
+
+	// Restoring into the same account reuses the recipient and duplicates the secret
+	rr = restore("correct horse battery staple")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var report struct {
+		RecipientsCreated int `json:"recipients_created"`
+		RecipientsMatched int `json:"recipients_matched"`
+		SecretsCreated    int `json:"secrets_created"`
+	}
+	decodeAPIResponse(t, rr, &report)
+	if report.RecipientsCreated != 0 || report.RecipientsMatched != 1 || report.SecretsCreated != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if len(repo.Secrets) != 2 || len(repo.Recipients) != 1 || len(repo.SecretAssignments) != 2 {
+		t.Errorf("Expected 2 secrets, 1 recipient and 2 assignments, got %d, %d and %d", len(repo.Secrets), len(repo.Recipients), len(repo.SecretAssignments))
+	}
+}
+
+func TestAPIV1RecipientQuestions(t *testing.T) {
+	repo, handler, user := setupAPIV1Test(t, nil)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := newAPIV1Request(user, "PUT", "/api/v1/recipients/recipient1/questions", body)
+		req.SetPathValue("id", "recipient1")
+		rr := httptest.NewRecorder()
+		handler.HandleSetRecipientQuestions(rr, req)
+		return rr
+	}
+
+	// A single question is not enough
+	if rr := put(`{"questions":[{"question":"Where did we meet?","answer":"Paris"}]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a single question, got %d", rr.Code)
+	}
+
+	rr := put(`{"questions":[{"question":"Where did we meet?","answer":"Paris"},{"question":"First dog?","answer":"Rex"},{"question":"Favourite colour?","answer":"Blue"}]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var questions apiRecipientQuestions
+	decodeAPIResponse(t, rr, &questions)
+	if questions.Threshold != 2 || len(questions.Questions) != 3 || questions.Questions[1] != "First dog?" {
+		t.Errorf("Expected 3 questions with threshold 2, got %+v", questions)
+	}
+	if strings.Contains(rr.Body.String(), "Paris") {
+		t.Error("Answers must not be returned")
+	}
+
+	// The recipient shows that questions are set
+	req := newAPIV1Request(user, "GET", "/api/v1/recipients/recipient1", "")
+	req.SetPathValue("id", "recipient1")
+	rr = httptest.NewRecorder()
+	handler.HandleGetRecipient(rr, req)
+	var recipient apiRecipient
+	decodeAPIResponse(t, rr, &recipient)
+	if recipient.QuestionThreshold != 2 {
+		t.Errorf("Expected question threshold 2, got %d", recipient.QuestionThreshold)
+	}
+
+	// An empty list removes the questions
+	if rr := put(`{"questions":[]}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if repo.Recipients[0].HasQuestions() || len(repo.RecipientQuestions) != 0 {
+		t.Error("Expected the questions to be removed")
+	}
+}
+
+func TestAPIV1RotateKeys(t *testing.T) {
+	masterKey := []byte("0123456789abcdef0123456789abcdef")
+	repo, handler, user := setupAPIV1Test(t, masterKey)
+
+	rotate := func(handler *APIV1Handler, adminToken string) *httptest.ResponseRecorder {
+		req := newAPIV1Request(user, "POST", "/api/v1/admin/rotate-keys", "")
+		if adminToken != "" {
+			req.Header.Set("X-Admin-Token", adminToken)
+		}
+		rr := httptest.NewRecorder()
+		handler.HandleRotateKeys(rr, req)
+		return rr
+	}
+
+	// Only a request with the server's admin token may rotate keys, whatever the user's email
+	user.Email = "admin@example.com"
+	if rr := rotate(handler, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 without the admin token, got %d", rr.Code)
+	}
+	if rr := rotate(handler, "wrong-token-for-testing-purposes"); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 for a wrong admin token, got %d", rr.Code)
+	}
+	disabled := NewAPIV1Handler(repo, nil, auth.NewVaultService(repo), delivery.NewSealer(repo, masterKey, nil), "")
+	if rr := rotate(disabled, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 without an ADMIN_TOKEN on the server, got %d", rr.Code)
+	}
+
+	secret := &models.Secret{ID: "secret1", UserID: user.ID, Name: "Bank"}
+	repo.Secrets = append(repo.Secrets, secret)
+	sealed, _ := delivery.NewSealer(repo, masterKey, nil).Seal([]byte("copy"))
+	repo.SecretAssignments = append(repo.SecretAssignments, &models.SecretAssignment{ID: "a1", SecretID: secret.ID, RecipientID: "recipient1", DeliveryData: sealed})
+
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	sealer := delivery.NewSealer(repo, newKey, nil)
+	sealer.SetPreviousMasterKeys([][]byte{masterKey})
+	handler = NewAPIV1Handler(repo, nil, auth.NewVaultService(repo), sealer, testAdminToken)
+
+	rr := rotate(handler, testAdminToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var report delivery.KeyRotationReport
+	decodeAPIResponse(t, rr, &report)
+	if report.Rewrapped != 1 || report.Verified != 1 || len(report.Failed) != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	if _, err := delivery.NewSealer(repo, newKey, nil).Open(repo.SecretAssignments[0].DeliveryData); err != nil {
+		t.Errorf("Expected the copy to open with the new key: %v", err)
+	}
+	if len(repo.AuditLogs) == 0 || repo.AuditLogs[len(repo.AuditLogs)-1].Action != "rotate_keys" {
+		t.Error("Expected a rotate_keys audit log entry")
+	}
+}
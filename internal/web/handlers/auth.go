@@ -1,14 +1,19 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/email"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
 	"github.com/korjavin/deadmanswitch/internal/web/templates"
 	"github.com/korjavin/deadmanswitch/internal/web/utils"
 )
@@ -17,13 +22,17 @@ import (
 type AuthHandler struct {
 	repo        storage.Repository
 	emailClient *email.Client
+	vault       *auth.VaultService
+	sealer      *delivery.Sealer
 }
 
 // NewAuthHandler creates a new AuthHandler
-func NewAuthHandler(repo storage.Repository, emailClient *email.Client) *AuthHandler {
+func NewAuthHandler(repo storage.Repository, emailClient *email.Client, vault *auth.VaultService, sealer *delivery.Sealer) *AuthHandler {
 	return &AuthHandler{
 		repo:        repo,
 		emailClient: emailClient,
+		vault:       vault,
+		sealer:      sealer,
 	}
 }
 
@@ -150,12 +159,21 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Unlock the user's vault for this session
+	if err := h.vault.Unlock(ctx, user, password, session); err != nil {
+		log.Printf("Error unlocking vault: %v", err)
+		// Continue anyway, the user can unlock the vault later
+	} else {
+		h.sealMissingCopies(ctx, user, session)
+	}
+
 	// Update the user's last activity time
 	user.LastActivity = time.Now()
 	if err := h.repo.UpdateUser(ctx, user); err != nil {
 		log.Printf("Error updating user last activity: %v", err)
 		// Continue anyway, this is not critical
 	}
+	h.renewQuestionTimelocks(ctx, user, session)
 
 	// Set the session cookie
 	http.SetCookie(w, &http.Cookie{
@@ -286,6 +304,12 @@ func (h *AuthHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Create the user's vault key and unlock it for this session
+	if err := h.vault.Unlock(ctx, user, password, session); err != nil {
+		log.Printf("Error creating vault key: %v", err)
+		// Continue anyway, the vault key is created on the next unlock
+	}
+
 	// Set the session cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
@@ -343,6 +367,9 @@ func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		session, err := h.repo.GetSessionByToken(ctx, sessionToken)
 		if err == nil {
+			// Forget the vault key held for the session
+			h.vault.Lock(session.ID)
+
 			// Delete the session
 			if err := h.repo.DeleteSession(ctx, session.ID); err != nil {
 				log.Printf("Error deleting session: %v", err)
@@ -381,3 +408,171 @@ func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	// Redirect to the home page
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// HandleUnlockForm handles the vault unlock form page
+func (h *AuthHandler) HandleUnlockForm(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data := templates.TemplateData{
+		Title:           "Unlock Vault",
+		ActivePage:      "secrets",
+		IsAuthenticated: true,
+		User: map[string]interface{}{
+			"Email": user.Email,
+			"Name":  user.Email, // Use email as name since we don't have a separate name field
+		},
+		Data: map[string]interface{}{
+			"Next": safeRedirectPath(r.URL.Query().Get("next")),
+		},
+	}
+
+	if err := templates.RenderTemplate(w, "unlock.html", data); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Error rendering unlock template: %v", err)
+	}
+}
+
+// HandleUnlock handles the vault unlock form submission
+func (h *AuthHandler) HandleUnlock(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user and session from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	session, ok := middleware.GetSessionFromContext(r)
+	if !ok || session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	password := r.FormValue("password")
+	next := safeRedirectPath(r.FormValue("next"))
+
+	renderError := func(status int, message string) {
+		data := templates.TemplateData{
+			Title:           "Unlock Vault",
+			ActivePage:      "secrets",
+			IsAuthenticated: true,
+			User: map[string]interface{}{
+				"Email": user.Email,
+				"Name":  user.Email,
+			},
+			Data: map[string]interface{}{
+				"Next":  next,
+				"Error": message,
+			},
+		}
+
+		w.WriteHeader(status)
+		if err := templates.RenderTemplate(w, "unlock.html", data); err != nil {
+			log.Printf("Error rendering unlock template: %v", err)
+		}
+	}
+
+	tooManyAttempts := fmt.Sprintf("Too many failed attempts. Please try again in %d minutes.", int(auth.UnlockBlockDuration.Minutes()))
+	if err := h.vault.CheckUnlockAttempts(user.ID); err != nil {
+		renderError(http.StatusTooManyRequests, tooManyAttempts)
+		return
+	}
+
+	// Verify the password and unlock the vault
+	if !utils.VerifyPassword(user.PasswordHash, password) {
+		remaining := h.vault.FailedUnlock(user.ID)
+
+		auditLog := &models.AuditLog{
+			ID:        utils.GenerateID(),
+			UserID:    user.ID,
+			Action:    "unlock_vault_failed",
+			Timestamp: time.Now(),
+			IPAddress: r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+			Details:   "Vault unlock failed: invalid password",
+		}
+		if err := h.repo.CreateAuditLog(r.Context(), auditLog); err != nil {
+			log.Printf("Error creating audit log for failed unlock: %v", err)
+		}
+
+		if remaining == 0 {
+			renderError(http.StatusTooManyRequests, tooManyAttempts)
+			return
+		}
+		renderError(http.StatusUnauthorized, fmt.Sprintf("Invalid password. %d attempts remaining.", remaining))
+		return
+	}
+
+	if err := h.vault.Unlock(r.Context(), user, password, session); err != nil {
+		http.Error(w, "Error unlocking vault", http.StatusInternalServerError)
+		log.Printf("Error unlocking vault: %v", err)
+		return
+	}
+
+	h.sealMissingCopies(r.Context(), user, session)
+	h.renewQuestionTimelocks(r.Context(), user, session)
+
+	// Create audit log entry
+	auditLog := &models.AuditLog{
+		ID:        utils.GenerateID(),
+		UserID:    user.ID,
+		Action:    "unlock_vault",
+		Timestamp: time.Now(),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details:   "Vault unlocked",
+	}
+
+	if err := h.repo.CreateAuditLog(r.Context(), auditLog); err != nil {
+		// Non-fatal error, just log it
+		log.Printf("Error creating audit log for unlock: %v", err)
+	}
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// sealMissingCopies seals recipient copies for secrets that don't have one yet
+// while the vault key is at hand
+func (h *AuthHandler) sealMissingCopies(ctx context.Context, user *models.User, session *models.Session) {
+	key, err := h.vault.Key(session.ID)
+	if err != nil {
+		return
+	}
+
+	sealed, err := h.sealer.SealMissing(ctx, user.ID, key)
+	if err != nil {
+		log.Printf("Error sealing recipient copies for user %s: %v", user.ID, err)
+		return
+	}
+	if sealed > 0 {
+		log.Printf("Sealed recipient copies of %d secrets for user %s", sealed, user.ID)
+	}
+}
+
+// renewQuestionTimelocks moves the timelocks of the user's questions to their
+// new deadline. With the vault unlocked they are rebuilt in full.
+func (h *AuthHandler) renewQuestionTimelocks(ctx context.Context, user *models.User, session *models.Session) {
+	// A locked vault has no key, the copies are only pruned then
+	key, _ := h.vault.Key(session.ID)
+
+	if err := h.sealer.RenewQuestionTimelocks(ctx, user, key); err != nil {
+		log.Printf("Error renewing question timelocks for user %s: %v", user.ID, err)
+	}
+}
+
+// safeRedirectPath only allows local redirect targets
+func safeRedirectPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/secrets"
+	}
+	return next
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/backup"
+	"github.com/korjavin/deadmanswitch/internal/email"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// maxBackupSize limits the size of an uploaded backup, which includes the
+// files of file secrets
+const maxBackupSize = 256 << 20
+
+// BackupHandler handles the export and restore of an owner's data
+type BackupHandler struct {
+	repo        storage.Repository
+	emailClient *email.Client
+	vault       *auth.VaultService
+	backups     *backup.Service
+}
+
+// NewBackupHandler creates a new BackupHandler
+func NewBackupHandler(repo storage.Repository, emailClient *email.Client, vault *auth.VaultService, backups *backup.Service) *BackupHandler {
+	return &BackupHandler{
+		repo:        repo,
+		emailClient: emailClient,
+		vault:       vault,
+		backups:     backups,
+	}
+}
+
+// HandleBackup handles the backup page
+func (h *BackupHandler) HandleBackup(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Backups hold the decrypted secrets, make sure the vault is unlocked first
+	if _, ok := requireVaultKey(w, r, h.vault); !ok {
+		return
+	}
+
+	h.renderBackup(w, user, nil)
+}
+
+// HandleExportBackup downloads a backup of the user's secrets, recipients,
+// assignments and settings, encrypted with a passphrase of its own
+func (h *BackupHandler) HandleExportBackup(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	passphrase := r.FormValue("passphrase")
+	if passphrase != r.FormValue("confirm_passphrase") {
+		http.Error(w, "The passphrases don't match", http.StatusBadRequest)
+		return
+	}
+	if err := backup.CheckPassphrase(passphrase); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vaultKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return
+	}
+
+	bundle, warnings, err := h.backups.Export(r.Context(), user, vaultKey)
+	if err != nil {
+		http.Error(w, "Error exporting backup", http.StatusInternalServerError)
+		log.Printf("Error exporting backup: %v", err)
+		return
+	}
+
+	file, err := backup.Seal(bundle, passphrase)
+	if err != nil {
+		http.Error(w, "Error encrypting backup", http.StatusInternalServerError)
+		log.Printf("Error encrypting backup: %v", err)
+		return
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		http.Error(w, "Error encoding backup", http.StatusInternalServerError)
+		log.Printf("Error encoding backup: %v", err)
+		return
+	}
+
+	// The download can't show what was left out, the history does
+	details := fmt.Sprintf("Exported a backup (secrets: %d, recipients: %d)", len(bundle.Secrets), len(bundle.Recipients))
+	if len(warnings) > 0 {
+		details += ". " + strings.Join(warnings, ". ")
+	}
+	auditLog := &models.AuditLog{
+		UserID:    user.ID,
+		Action:    "export_backup",
+		Timestamp: time.Now(),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	}
+
+	if err := h.repo.CreateAuditLog(context.Background(), auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=deadmanswitch-backup-%s.json", bundle.CreatedAt.Format("20060102")))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// HandleRestoreBackup restores an uploaded backup into the user's account
+func (h *BackupHandler) HandleRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vaultKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBackupSize+maxFormFieldsSize)
+	upload, form, err := readUploadInMemory(r, maxBackupSize)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+	case errors.As(err, &maxBytesErr):
+		http.Error(w, fmt.Sprintf("The backup is too large, you can upload up to %d bytes", maxBackupSize), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, errNoFile):
+		http.Error(w, "Please choose a backup to upload", http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	bundle, ok := openBackup(w, upload, form.Get("passphrase"))
+	if !ok {
+		return
+	}
+
+	report, restoreErr := h.backups.Restore(r.Context(), user, vaultKey, bundle)
+	sendRestoreConfirmations(r, h.repo, h.emailClient, user, report)
+
+	// What was restored before an error is kept, it is logged either way
+	auditLog := &models.AuditLog{
+		UserID:    user.ID,
+		Action:    "restore_backup",
+		Timestamp: time.Now(),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details:   restoreDetails(bundle, report),
+	}
+
+	if err := h.repo.CreateAuditLog(context.Background(), auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	if restoreErr != nil {
+		http.Error(w, fmt.Sprintf("Error restoring backup, %d of %d secrets were restored", report.SecretsCreated, len(bundle.Secrets)), http.StatusInternalServerError)
+		log.Printf("Error restoring backup: %v", restoreErr)
+		return
+	}
+
+	h.renderBackup(w, user, report)
+}
+
+// renderBackup renders the backup page, with the report of a restore if there is one
+func (h *BackupHandler) renderBackup(w http.ResponseWriter, user *models.User, report *backup.Report) {
+	tmplData := templates.TemplateData{
+		Title:           "Backup",
+		ActivePage:      "settings",
+		IsAuthenticated: true,
+		User: map[string]interface{}{
+			"Email": user.Email,
+			"Name":  user.Email, // Use email as name since we don't have a separate name field
+		},
+		Data: map[string]interface{}{
+			"MinPassphraseLength": backup.MinPassphraseLength,
+			"MaxBackupSize":       int64(maxBackupSize),
+			"Report":              report,
+		},
+	}
+
+	if err := templates.RenderTemplate(w, "backup.html", tmplData); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Error rendering backup template: %v", err)
+	}
+}
+
+// sendRestoreConfirmations asks the restored recipients that were confirmed in
+// the backup to confirm their contact again. What can't be sent is added to
+// the warnings of the report, the owner can send a test contact later.
+func sendRestoreConfirmations(r *http.Request, repo storage.Repository, emailClient *email.Client, user *models.User, report *backup.Report) {
+	for _, id := range report.Reconfirm {
+		recipient, err := repo.GetRecipientByID(context.Background(), id)
+		if err == nil {
+			err = sendTestContact(context.Background(), repo, emailClient, r, user, recipient)
+		}
+		if err != nil {
+			log.Printf("Error sending confirmation to restored recipient %s: %v", id, err)
+			name := id
+			if recipient != nil {
+				name = recipient.Email
+			}
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s was restored unconfirmed and the confirmation email could not be sent, send a test contact from the recipients page", name))
+		}
+	}
+}
+
+// openBackup decrypts an uploaded backup or answers with 400
+func openBackup(w http.ResponseWriter, data []byte, passphrase string) (*backup.Bundle, bool) {
+	file, err := backup.Parse(data)
+	if err != nil {
+		http.Error(w, "The file is not a Dead Man's Switch backup", http.StatusBadRequest)
+		return nil, false
+	}
+
+	bundle, err := backup.Open(file, passphrase)
+	if err != nil {
+		http.Error(w, backupErrorMessage(err), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return bundle, true
+}
+
+// backupErrorMessage describes why a backup can't be opened
+func backupErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, backup.ErrWrongPassphrase):
+		return "The backup can't be decrypted, check the passphrase"
+	case errors.Is(err, backup.ErrUnsupportedVersion):
+		return "The backup was made by a newer version, update this server first"
+	default:
+		return "The backup is damaged and can't be read"
+	}
+}
+
+// restoreDetails describes a restore for the audit log
+func restoreDetails(bundle *backup.Bundle, report *backup.Report) string {
+	details := fmt.Sprintf("Restored a backup from %s (secrets: %d of %d, new recipients: %d, existing recipients: %d)",
+		bundle.CreatedAt.Format("2006-01-02"), report.SecretsCreated, len(bundle.Secrets), report.RecipientsCreated, report.RecipientsMatched)
+	if len(report.Warnings) > 0 {
+		details += ". " + strings.Join(report.Warnings, ". ")
+	}
+	return details
+}
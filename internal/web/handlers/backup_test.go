@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/backup"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+func TestBackupExportRestore(t *testing.T) {
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+	owner := &models.User{ID: "user123", Email: "test@example.com", PingFrequency: 3, PingDeadline: 7, PingMethod: "email"}
+	other := &models.User{ID: "user456", Email: "other@example.com", PingFrequency: 7, PingDeadline: 14, PingMethod: "email"}
+	repo.Users = append(repo.Users, owner, other)
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient1", UserID: owner.ID, Email: "recipient@example.com", Name: "Recipient", IsConfirmed: true})
+
+	vault := auth.NewVaultService(repo)
+	sealer := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil)
+	handler := NewBackupHandler(repo, nil, vault, backup.NewService(repo, sealer))
+
+	ownerSession, ownerKey := unlockTestVault(t, vault, owner)
+	otherSession, otherKey := unlockTestVault(t, vault, other)
+
+	encrypted, err := crypto.EncryptSecret([]byte("PIN 1234"), ownerKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+	repo.Secrets = append(repo.Secrets, &models.Secret{ID: "secret1", UserID: owner.ID, Name: "Bank", EncryptedData: encrypted, EncryptionType: models.EncryptionTypeVault})
+	repo.SecretAssignments = append(repo.SecretAssignments, &models.SecretAssignment{ID: "assignment1", SecretID: "secret1", RecipientID: "recipient1", UserID: owner.ID})
+
+	export := func(form url.Values) *httptest.ResponseRecorder {
+		req := newFormRequest("POST", "/backup/export", form)
+		req = withSession(req, owner, ownerSession)
+		rr := httptest.NewRecorder()
+		handler.HandleExportBackup(rr, req)
+		return rr
+	}
+
+	if rr := export(url.Values{"passphrase": {"short"}, "confirm_passphrase": {"short"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a short passphrase, got %d", rr.Code)
+	}
+	if rr := export(url.Values{"passphrase": {"correct horse battery staple"}, "confirm_passphrase": {"correct horse battery"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for passphrases that don't match, got %d", rr.Code)
+	}
+
+	rr := export(url.Values{"passphrase": {"correct horse battery staple"}, "confirm_passphrase": {"correct horse battery staple"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if disposition := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment; filename=deadmanswitch-backup-") {
+		t.Errorf("Unexpected Content-Disposition %q", disposition)
+	}
+	data := rr.Body.Bytes()
+	if strings.Contains(string(data), "PIN 1234") {
+		t.Error("Expected the secret to be encrypted in the backup")
+	}
+
+	// Restore the backup into another account
+	rr = httptest.NewRecorder()
+	handler.HandleRestoreBackup(rr, newUploadRequest(t, "/backup/restore", map[string]string{"passphrase": "wrong horse battery staple"}, "backup.json", data, other, otherSession))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "passphrase") {
+		t.Errorf("Expected status 400 for a wrong passphrase, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleRestoreBackup(rr, newUploadRequest(t, "/backup/restore", map[string]string{"passphrase": "correct horse battery staple"}, "backup.json", data, other, otherSession))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "Secrets: 1") {
+		t.Errorf("Expected the report on the page, got %s", rr.Body.String())
+	}
+
+	// Without an email client the confirmation can't be sent again, the owner is told
+	if !strings.Contains(rr.Body.String(), "recipient@example.com was restored unconfirmed") {
+		t.Errorf("Expected a warning about the confirmation, got %s", rr.Body.String())
+	}
+	restoredRecipients, _ := repo.ListRecipientsByUserID(context.Background(), other.ID)
+	if len(restoredRecipients) != 1 || restoredRecipients[0].IsConfirmed {
+		t.Errorf("Expected the recipient to be restored unconfirmed, got %+v", restoredRecipients)
+	}
+
+	restored, _ := repo.ListSecretsByUserID(context.Background(), other.ID)
+	if len(restored) != 1 {
+		t.Fatalf("Expected 1 restored secret, got %d", len(restored))
+	}
+	plaintext, err := crypto.DecryptSecret(restored[0].EncryptedData, otherKey)
+	if err != nil || string(plaintext) != "PIN 1234" {
+		t.Errorf("Expected the secret encrypted with the new vault key, got %q (%v)", plaintext, err)
+	}
+	if other.PingFrequency != 3 || other.PingDeadline != 7 {
+		t.Errorf("Expected the settings to be restored, got %d and %d", other.PingFrequency, other.PingDeadline)
+	}
+	assignments, _ := repo.ListSecretAssignmentsBySecretID(context.Background(), restored[0].ID)
+	if len(assignments) != 1 || assignments[0].DeliveryData == "" {
+		t.Errorf("Expected a sealed assignment, got %+v", assignments)
+	}
+
+	actions := make(map[string]bool)
+	for _, entry := range repo.AuditLogs {
+		actions[entry.Action] = true
+	}
+	if !actions["export_backup"] || !actions["restore_backup"] {
+		t.Errorf("Expected audit log entries for the export and restore, got %v", actions)
+	}
+}
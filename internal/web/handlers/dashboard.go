@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 	"github.com/korjavin/deadmanswitch/internal/web/middleware"
 	"github.com/korjavin/deadmanswitch/internal/web/templates"
@@ -80,6 +81,43 @@ func (h *DashboardHandler) HandleDashboard(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	// An armed switch is about to release the secrets, which overrides everything else
+	firesAt := ""
+	if run, err := h.repo.GetActiveDeliveryRunByUserID(r.Context(), user.ID); err == nil && run.Status == models.DeliveryRunStatusArmed {
+		status = "armed"
+		statusMessage = "Your check-in deadline has passed and your switch is armed. Your secrets will be released when the countdown ends unless you check in."
+		triggerTime = run.FiresAt.Format("Jan 2, 2006 15:04 MST")
+		firesAt = run.FiresAt.UTC().Format(time.RFC3339)
+		timeUntilDeadline = run.FiresAt.Sub(now)
+	}
+
+	// A switch that fired can be recovered if it was a mistake
+	_, err = latestReleasedRun(r.Context(), h.repo, user.ID)
+	released := err == nil
+
+	// Pending emergency access requests can still be denied from the dashboard
+	var emergencyRequests []map[string]string
+	if requests, err := h.repo.ListEmergencyAccessRequestsByUserID(r.Context(), user.ID); err == nil {
+		for _, request := range requests {
+			if request.Status != models.EmergencyAccessStatusPending {
+				continue
+			}
+
+			recipientName := request.RecipientID
+			for _, recipient := range recipients {
+				if recipient.ID == request.RecipientID {
+					recipientName = recipient.Name
+				}
+			}
+
+			emergencyRequests = append(emergencyRequests, map[string]string{
+				"Recipient":  recipientName,
+				"ReleasesAt": request.ReleasesAt.Format("Jan 2, 2006 15:04 MST"),
+				"DenyCode":   request.DenyCode,
+			})
+		}
+	}
+
 	// Get recent activity logs
 	activityLogs, err := h.repo.ListAuditLogsByUserID(r.Context(), user.ID)
 	activities := []map[string]string{{
@@ -132,6 +170,7 @@ func (h *DashboardHandler) HandleDashboard(w http.ResponseWriter, r *http.Reques
 			"NextCheckIn":   nextCheckIn.Format("Jan 2, 2006 15:04 MST"),
 			"Deadline":      deadline.Format("Jan 2, 2006 15:04 MST"),
 			"TriggerTime":   triggerTime,
+			"FiresAt":       firesAt,
 			"TimeRemaining": formatDuration(timeUntilDeadline),
 			"LastActivity":  user.LastActivity.Format("Jan 2, 2006 15:04 MST"),
 			"PingFrequency": user.PingFrequency,
@@ -143,7 +182,9 @@ func (h *DashboardHandler) HandleDashboard(w http.ResponseWriter, r *http.Reques
 				"ActiveRecipients": recipientCount,
 				"DaysActive":       daysActive,
 			},
-			"Activities": activities,
+			"Activities":        activities,
+			"EmergencyRequests": emergencyRequests,
+			"Released":          released,
 		},
 	}
 
@@ -174,6 +215,20 @@ func formatActivityDescription(action, details string) string {
 		return "Switch triggered"
 	case "switch_trigger_cancelled":
 		return "Switch trigger cancelled"
+	case "switch_armed":
+		return "Switch armed"
+	case "switch_trigger_aborted":
+		return "Switch release aborted"
+	case "staged_release_cancelled":
+		return "Delayed releases cancelled"
+	case "emergency_access_requested":
+		return "Emergency access requested"
+	case "emergency_access_denied":
+		return "Emergency access denied"
+	case "emergency_access_granted":
+		return "Emergency access granted"
+	case "switch_recovered":
+		return "Switch release revoked and re-armed"
 	default:
 		if details != "" {
 			return details
@@ -191,6 +246,10 @@ func formatPingMethod(method string) string {
 		return "Telegram"
 	case "both":
 		return "Email & Telegram"
+	case "web":
+		return "Web"
+	case "api":
+		return "API Token"
 	default:
 		return "Email"
 	}
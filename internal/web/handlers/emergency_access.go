@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/email"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// emergencyAccessVerifyWindow is how long a recipient has to confirm their request from their inbox
+const emergencyAccessVerifyWindow = 24 * time.Hour
+
+// EmergencyAccessNotifier tells the owner that a recipient has requested emergency access
+type EmergencyAccessNotifier interface {
+	NotifyEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error
+}
+
+// EmergencyAccessHandler handles the public emergency access flow of recipients
+type EmergencyAccessHandler struct {
+	repo        storage.Repository
+	emailClient *email.Client
+	notifier    EmergencyAccessNotifier
+	sealer      *delivery.Sealer
+	baseDomain  string
+}
+
+// NewEmergencyAccessHandler creates a new EmergencyAccessHandler
+func NewEmergencyAccessHandler(repo storage.Repository, emailClient *email.Client, notifier EmergencyAccessNotifier, sealer *delivery.Sealer) *EmergencyAccessHandler {
+	return &EmergencyAccessHandler{
+		repo:        repo,
+		emailClient: emailClient,
+		notifier:    notifier,
+		sealer:      sealer,
+	}
+}
+
+// SetBaseDomain sets the domain the confirmation links point to
+func (h *EmergencyAccessHandler) SetBaseDomain(domain string) {
+	h.baseDomain = domain
+}
+
+// HandleRequestForm shows the form in which a recipient requests emergency access
+func (h *EmergencyAccessHandler) HandleRequestForm(w http.ResponseWriter, r *http.Request) {
+	data := templates.TemplateData{
+		Title:           "Emergency Access",
+		ActivePage:      "",
+		IsAuthenticated: false,
+	}
+
+	if err := templates.RenderTemplate(w, "emergency-access.html", data); err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// HandleRequest creates an emergency access request for every owner who lets the
+// recipient with the submitted email request access
+func (h *EmergencyAccessHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	emailAddress := strings.TrimSpace(r.FormValue("email"))
+	if emailAddress == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	recipients, err := h.repo.ListRecipientsByEmail(ctx, emailAddress)
+	if err != nil {
+		http.Error(w, "Error checking recipients", http.StatusInternalServerError)
+		log.Printf("Error fetching recipients by email: %v", err)
+		return
+	}
+
+	for _, recipient := range recipients {
+		if err := h.createRequest(ctx, recipient); err != nil {
+			log.Printf("Error creating emergency access request for recipient %s: %v", recipient.ID, err)
+		}
+	}
+
+	// The page is the same whether the email belongs to a recipient or not, so the
+	// form can't be used to find out who is a recipient
+	h.renderResult(w, "Check Your Inbox",
+		"If this address belongs to a recipient who may request emergency access, we have sent a link to confirm the request. The link is valid for 24 hours.")
+}
+
+// createRequest creates an unverified request for one recipient and emails them the confirmation link
+func (h *EmergencyAccessHandler) createRequest(ctx context.Context, recipient *models.Recipient) error {
+	if !recipient.IsConfirmed {
+		return nil
+	}
+
+	user, err := h.repo.GetUserByID(ctx, recipient.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get owner: %w", err)
+	}
+	if user.EmergencyAccessDays <= 0 {
+		return nil
+	}
+
+	assignments, err := h.repo.ListSecretAssignmentsByRecipientID(ctx, recipient.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get assignments: %w", err)
+	}
+	if len(assignments) == 0 {
+		return nil
+	}
+
+	// Don't stack up requests while one is still open
+	requests, err := h.repo.ListEmergencyAccessRequestsByUserID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get emergency access requests: %w", err)
+	}
+	for _, existing := range requests {
+		if existing.RecipientID != recipient.ID {
+			continue
+		}
+		if existing.Status == models.EmergencyAccessStatusPending ||
+			(existing.Status == models.EmergencyAccessStatusUnverified && time.Since(existing.RequestedAt) < emergencyAccessVerifyWindow) {
+			return nil
+		}
+	}
+
+	if h.emailClient == nil {
+		return fmt.Errorf("email client not configured")
+	}
+
+	verifyCode, err := generateConfirmationCode()
+	if err != nil {
+		return fmt.Errorf("failed to generate verify code: %w", err)
+	}
+	denyCode, err := generateConfirmationCode()
+	if err != nil {
+		return fmt.Errorf("failed to generate deny code: %w", err)
+	}
+
+	request := &models.EmergencyAccessRequest{
+		ID:          uuid.New().String(),
+		UserID:      user.ID,
+		RecipientID: recipient.ID,
+		Status:      models.EmergencyAccessStatusUnverified,
+		VerifyCode:  verifyCode,
+		DenyCode:    denyCode,
+		RequestedAt: time.Now().UTC(),
+	}
+	if err := h.repo.CreateEmergencyAccessRequest(ctx, request); err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	confirmURL := fmt.Sprintf("https://%s/emergency-access/confirm/%s", h.baseDomain, verifyCode)
+
+	subject := "Dead Man's Switch - Confirm Emergency Access Request"
+	message := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Dead Man's Switch - Emergency Access</h2>
+			<p>Hello %s,</p>
+			<p>Someone requested emergency access to the information %s has left for you.</p>
+			<p>If this was you, please confirm the request:</p>
+			<p><a href="%s">Confirm Request</a></p>
+			<p>%s will be notified right away and has %d days to deny the request. If they don't, the information will be sent to you.</p>
+			<p>This link will be valid for 24 hours. If you didn't request emergency access, you can ignore this email.</p>
+			<p>Thank you,<br>Dead Man's Switch</p>
+		</body>
+		</html>
+	`, recipient.Name, user.Email, confirmURL, user.Email, user.EmergencyAccessDays)
+
+	if err := h.emailClient.SendEmailSimple([]string{recipient.Email}, subject, message, true); err != nil {
+		return fmt.Errorf("failed to send confirmation email: %w", err)
+	}
+
+	return nil
+}
+
+// HandleConfirm starts the waiting period of a request once the recipient confirms it
+func (h *EmergencyAccessHandler) HandleConfirm(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	if code == "" {
+		http.Error(w, "Confirmation code is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	request, err := h.repo.GetEmergencyAccessRequestByVerifyCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Invalid confirmation link", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error checking confirmation link", http.StatusInternalServerError)
+		log.Printf("Error fetching emergency access request by verify code: %v", err)
+		return
+	}
+
+	switch request.Status {
+	case models.EmergencyAccessStatusDenied:
+		h.renderResult(w, "Request Denied", "The owner has denied this emergency access request.")
+		return
+	case models.EmergencyAccessStatusGranted:
+		h.renderResult(w, "Access Granted", "The waiting period is over. The information has been sent to you by email.")
+		return
+	case models.EmergencyAccessStatusPending:
+		h.renderResult(w, "Request Confirmed",
+			fmt.Sprintf("The owner has been notified. Unless they deny the request, the information will be sent to you after %s.",
+				request.ReleasesAt.Format("Jan 2, 2006 15:04 MST")))
+		return
+	}
+
+	if time.Since(request.RequestedAt) > emergencyAccessVerifyWindow {
+		http.Error(w, "This confirmation link has expired, please request emergency access again", http.StatusGone)
+		return
+	}
+
+	user, err := h.repo.GetUserByID(ctx, request.UserID)
+	if err != nil {
+		http.Error(w, "Error fetching owner", http.StatusInternalServerError)
+		log.Printf("Error fetching owner of emergency access request: %v", err)
+		return
+	}
+
+	recipient, err := h.repo.GetRecipientByID(ctx, request.RecipientID)
+	if err != nil {
+		http.Error(w, "Error fetching recipient", http.StatusInternalServerError)
+		log.Printf("Error fetching recipient of emergency access request: %v", err)
+		return
+	}
+
+	releasesAt := time.Now().UTC().AddDate(0, 0, user.EmergencyAccessDays)
+	request.Status = models.EmergencyAccessStatusPending
+	request.ReleasesAt = &releasesAt
+	if err := h.repo.UpdateEmergencyAccessRequest(ctx, request); err != nil {
+		http.Error(w, "Error confirming request", http.StatusInternalServerError)
+		log.Printf("Error updating emergency access request: %v", err)
+		return
+	}
+
+	auditLog := &models.AuditLog{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Action:    "emergency_access_requested",
+		Timestamp: time.Now().UTC(),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details: fmt.Sprintf("%s <%s> requested emergency access, secrets are released on %s unless denied",
+			recipient.Name, recipient.Email, releasesAt.Format("Jan 2, 2006 15:04 MST")),
+	}
+
+	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log for emergency access request: %v", err)
+		// Continue anyway, the request is confirmed
+	}
+
+	if h.notifier != nil {
+		if err := h.notifier.NotifyEmergencyAccessRequest(ctx, request); err != nil {
+			log.Printf("Error notifying owner of emergency access request %s: %v", request.ID, err)
+			// Continue anyway, the request stays visible on the owner's dashboard
+		}
+	}
+
+	h.renderResult(w, "Request Confirmed",
+		fmt.Sprintf("The owner has been notified. Unless they deny the request, the information will be sent to you after %s.",
+			releasesAt.Format("Jan 2, 2006 15:04 MST")))
+}
+
+// HandleDeny handles a click on the deny link sent to the owner
+func (h *EmergencyAccessHandler) HandleDeny(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	if code == "" {
+		http.Error(w, "Deny code is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	request, err := h.repo.GetEmergencyAccessRequestByDenyCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Invalid deny link", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error checking deny link", http.StatusInternalServerError)
+		log.Printf("Error fetching emergency access request by deny code: %v", err)
+		return
+	}
+
+	if request.Status == models.EmergencyAccessStatusGranted {
+		http.Error(w, "The waiting period is over and the secrets have already been released", http.StatusGone)
+		return
+	}
+
+	// A link of a denied request only shows the result again
+	if request.Status != models.EmergencyAccessStatusDenied {
+		user, err := h.repo.GetUserByID(ctx, request.UserID)
+		if err != nil {
+			http.Error(w, "Error fetching user", http.StatusInternalServerError)
+			log.Printf("Error fetching user for deny link: %v", err)
+			return
+		}
+
+		recipient, err := h.repo.GetRecipientByID(ctx, request.RecipientID)
+		if err != nil {
+			http.Error(w, "Error fetching recipient", http.StatusInternalServerError)
+			log.Printf("Error fetching recipient for deny link: %v", err)
+			return
+		}
+
+		now := time.Now().UTC()
+		request.Status = models.EmergencyAccessStatusDenied
+		request.ResolvedAt = &now
+		if err := h.repo.UpdateEmergencyAccessRequest(ctx, request); err != nil {
+			http.Error(w, "Error denying request", http.StatusInternalServerError)
+			log.Printf("Error updating emergency access request: %v", err)
+			return
+		}
+
+		// Denying proves the owner is fine, so it counts as a check-in too
+		user.LastActivity = now
+		user.NextScheduledPing = now.AddDate(0, 0, user.PingFrequency)
+		if err := h.repo.UpdateUser(ctx, user); err != nil {
+			log.Printf("Error updating user last activity: %v", err)
+		}
+		if err := h.sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+			log.Printf("Error renewing question timelocks: %v", err)
+		}
+		abortArmedSwitch(ctx, h.repo, r, user, "Armed switch aborted by denying an emergency access request")
+
+		auditLog := &models.AuditLog{
+			ID:        uuid.New().String(),
+			UserID:    user.ID,
+			Action:    "emergency_access_denied",
+			Timestamp: now,
+			IPAddress: r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+			Details:   fmt.Sprintf("Denied the emergency access request of %s <%s>", recipient.Name, recipient.Email),
+		}
+
+		if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+			log.Printf("Error creating audit log for denied emergency access: %v", err)
+			// Continue anyway, the request is denied
+		}
+
+		if h.emailClient != nil {
+			message := fmt.Sprintf("Hello %s,\n\n%s has denied your emergency access request. Please contact them directly.\n\nDead Man's Switch\n",
+				recipient.Name, user.Email)
+			if err := h.emailClient.SendEmailSimple([]string{recipient.Email}, "Dead Man's Switch - Emergency Access Denied", message, false); err != nil {
+				log.Printf("Error notifying recipient of denied emergency access: %v", err)
+			}
+		}
+	}
+
+	h.renderResult(w, "Request Denied", "The emergency access request was denied and nothing will be released. Your Dead Man's Switch has been reset.")
+}
+
+// renderResult shows the outcome of a step of the emergency access flow
+func (h *EmergencyAccessHandler) renderResult(w http.ResponseWriter, title, message string) {
+	data := templates.TemplateData{
+		Title:           title,
+		ActivePage:      "",
+		IsAuthenticated: false,
+		Data: map[string]interface{}{
+			"Message": message,
+		},
+	}
+
+	if err := templates.RenderTemplate(w, "emergency-access-result.html", data); err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		log.Printf("Error rendering template: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// mockEmergencyAccessNotifier records the requests the owner was notified about
+type mockEmergencyAccessNotifier struct {
+	notified []*models.EmergencyAccessRequest
+}
+
+func (n *mockEmergencyAccessNotifier) NotifyEmergencyAccessRequest(ctx context.Context, request *models.EmergencyAccessRequest) error {
+	n.notified = append(n.notified, request)
+	return nil
+}
+
+// setupEmergencyAccessTest creates an owner with a 3 day waiting period and a recipient
+// with an unverified request, confirmed with "verify123" and denied with "deny123"
+func setupEmergencyAccessTest(t *testing.T) (*storage.MockRepository, *mockEmergencyAccessNotifier, *EmergencyAccessHandler) {
+	t.Helper()
+
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+	repo.Users = append(repo.Users, &models.User{
+		ID:                  "user123",
+		Email:               "test@example.com",
+		LastActivity:        time.Now().UTC().Add(-5 * 24 * time.Hour),
+		PingFrequency:       7,
+		PingDeadline:        14,
+		PingingEnabled:      true,
+		EmergencyAccessDays: 3,
+	})
+	repo.Recipients = append(repo.Recipients, &models.Recipient{
+		ID:          "recipient1",
+		UserID:      "user123",
+		Name:        "Alice",
+		Email:       "alice@example.com",
+		IsConfirmed: true,
+	})
+	repo.SecretAssignments = append(repo.SecretAssignments, &models.SecretAssignment{
+		ID:          "assignment1",
+		SecretID:    "secret1",
+		RecipientID: "recipient1",
+		UserID:      "user123",
+	})
+	repo.EmergencyRequests = append(repo.EmergencyRequests, &models.EmergencyAccessRequest{
+		ID:          "request1",
+		UserID:      "user123",
+		RecipientID: "recipient1",
+		Status:      models.EmergencyAccessStatusUnverified,
+		VerifyCode:  "verify123",
+		DenyCode:    "deny123",
+		RequestedAt: time.Now().UTC().Add(-time.Hour),
+	})
+
+	notifier := &mockEmergencyAccessNotifier{}
+	return repo, notifier, NewEmergencyAccessHandler(repo, nil, notifier, delivery.NewSealer(repo, nil, nil))
+}
+
+func TestHandleEmergencyAccessRequest(t *testing.T) {
+	repo, _, handler := setupEmergencyAccessTest(t)
+
+	// Unknown addresses get the same answer as recipients
+	for _, address := range []string{"alice@example.com", "nobody@example.com"} {
+		form := url.Values{"email": {address}}
+		req := newFormRequest("POST", "/emergency-access", form)
+		rr := httptest.NewRecorder()
+
+		handler.HandleRequest(rr, req)
+
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Check Your Inbox") {
+			t.Errorf("Expected the same page for %s, got %d", address, rr.Code)
+		}
+	}
+
+	// The open request isn't duplicated
+	if len(repo.EmergencyRequests) != 1 {
+		t.Errorf("Expected no new requests while one is open, got %d", len(repo.EmergencyRequests))
+	}
+
+	// Owners who haven't enabled emergency access don't get requests
+	repo.EmergencyRequests = nil
+	repo.Users[0].EmergencyAccessDays = 0
+	form := url.Values{"email": {"alice@example.com"}}
+	req := newFormRequest("POST", "/emergency-access", form)
+	handler.HandleRequest(httptest.NewRecorder(), req)
+
+	if len(repo.EmergencyRequests) != 0 {
+		t.Errorf("Expected no request when emergency access is disabled, got %d", len(repo.EmergencyRequests))
+	}
+}
+
+func TestHandleEmergencyAccessConfirm(t *testing.T) {
+	repo, notifier, handler := setupEmergencyAccessTest(t)
+
+	rr := httptest.NewRecorder()
+	handler.HandleConfirm(rr, newCodeRequest("/emergency-access/confirm/", "verify123"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	request := repo.EmergencyRequests[0]
+	if request.Status != models.EmergencyAccessStatusPending || request.ReleasesAt == nil {
+		t.Fatalf("Expected a pending request with a release time, got status %q", request.Status)
+	}
+	if until := time.Until(*request.ReleasesAt); until < 71*time.Hour || until > 72*time.Hour {
+		t.Errorf("Expected the request to be released in 3 days, got %v", until)
+	}
+
+	if len(notifier.notified) != 1 || notifier.notified[0].ID != "request1" {
+		t.Errorf("Expected the owner to be notified once, got %d notifications", len(notifier.notified))
+	}
+
+	if len(repo.AuditLogs) != 1 || repo.AuditLogs[0].Action != "emergency_access_requested" {
+		t.Errorf("Expected an emergency_access_requested audit log entry, got %d entries", len(repo.AuditLogs))
+	}
+
+	// Opening the link again doesn't restart the waiting period
+	releasesAt := *request.ReleasesAt
+	handler.HandleConfirm(httptest.NewRecorder(), newCodeRequest("/emergency-access/confirm/", "verify123"))
+	if !request.ReleasesAt.Equal(releasesAt) || len(notifier.notified) != 1 {
+		t.Error("Expected a confirmed request to stay unchanged")
+	}
+}
+
+func TestHandleEmergencyAccessConfirmExpired(t *testing.T) {
+	repo, notifier, handler := setupEmergencyAccessTest(t)
+	repo.EmergencyRequests[0].RequestedAt = time.Now().UTC().Add(-25 * time.Hour)
+
+	rr := httptest.NewRecorder()
+	handler.HandleConfirm(rr, newCodeRequest("/emergency-access/confirm/", "verify123"))
+
+	if rr.Code != http.StatusGone {
+		t.Errorf("Expected status 410 for an expired link, got %d", rr.Code)
+	}
+	if repo.EmergencyRequests[0].Status != models.EmergencyAccessStatusUnverified || len(notifier.notified) != 0 {
+		t.Error("Expected an expired request to stay unverified")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleConfirm(rr, newCodeRequest("/emergency-access/confirm/", "unknown"))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown code, got %d", rr.Code)
+	}
+}
+
+func TestHandleEmergencyAccessDeny(t *testing.T) {
+	repo, _, handler := setupEmergencyAccessTest(t)
+
+	releasesAt := time.Now().UTC().Add(72 * time.Hour)
+	repo.EmergencyRequests[0].Status = models.EmergencyAccessStatusPending
+	repo.EmergencyRequests[0].ReleasesAt = &releasesAt
+
+	rr := httptest.NewRecorder()
+	handler.HandleDeny(rr, newCodeRequest("/emergency-access/deny/", "deny123"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	request := repo.EmergencyRequests[0]
+	if request.Status != models.EmergencyAccessStatusDenied || request.ResolvedAt == nil {
+		t.Errorf("Expected the request to be denied, got status %q", request.Status)
+	}
+
+	if time.Since(repo.Users[0].LastActivity) > time.Minute {
+		t.Errorf("Expected denying to count as a check-in, got last activity %v", repo.Users[0].LastActivity)
+	}
+
+	if len(repo.AuditLogs) != 1 || repo.AuditLogs[0].Action != "emergency_access_denied" {
+		t.Errorf("Expected an emergency_access_denied audit log entry, got %d entries", len(repo.AuditLogs))
+	}
+
+	// A granted request can't be denied anymore
+	request.Status = models.EmergencyAccessStatusGranted
+	rr = httptest.NewRecorder()
+	handler.HandleDeny(rr, newCodeRequest("/emergency-access/deny/", "deny123"))
+	if rr.Code != http.StatusGone {
+		t.Errorf("Expected status 410 for a granted request, got %d", rr.Code)
+	}
+}
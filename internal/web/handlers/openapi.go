@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// OpenAPIDocument generates an OpenAPI 3 document for the API routes.
+// Request and response schemas are derived from the Go types of the route table,
+// so the document cannot drift from what the handlers actually accept and return.
+func OpenAPIDocument(routes []APIRoute) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	errorRef := schemaFor(reflect.TypeOf(apiError{}), schemas)
+
+	paths := map[string]interface{}{}
+	for _, route := range routes {
+		path := APIV1Prefix + route.Path
+
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+
+		item[strings.ToLower(route.Method)] = openAPIOperation(route, schemas, errorRef)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Dead Man's Switch API",
+			"version":     "1.0.0",
+			"description": "Manage the switch, secrets and recipients. Authenticate with a personal API token from the profile page or with a browser session.",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": "/"},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"sessionCookie": []string{}},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Personal API token, see /profile/tokens",
+				},
+				"sessionCookie": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": "session_token",
+				},
+			},
+		},
+	}
+}
+
+// openAPIOperation describes a single route
+func openAPIOperation(route APIRoute, schemas map[string]interface{}, errorRef map[string]interface{}) map[string]interface{} {
+	operation := map[string]interface{}{
+		"summary":     route.Summary,
+		"description": "Required API token scope: `" + route.Scope + "`",
+		"tags":        []string{route.Tag},
+	}
+
+	var parameters []interface{}
+	for _, part := range strings.Split(route.Path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     strings.Trim(part, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	for _, param := range route.Query {
+		schema := map[string]interface{}{"type": param.Type}
+		if param.Format != "" {
+			schema["format"] = param.Format
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name":        param.Name,
+			"in":          "query",
+			"description": param.Description,
+			"schema":      schema,
+		})
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if route.Request != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": schemaFor(reflect.TypeOf(route.Request), schemas),
+				},
+			},
+		}
+	}
+
+	success := map[string]interface{}{
+		"description": http.StatusText(route.Status),
+	}
+	if route.Response != nil {
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": schemaFor(reflect.TypeOf(route.Response), schemas),
+			},
+		}
+	}
+
+	operation["responses"] = map[string]interface{}{
+		strconv.Itoa(route.Status): success,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": errorRef},
+			},
+		},
+	}
+
+	return operation
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON schema of a Go type. Structs are added to the
+// component schemas and referenced by name.
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	// encoding/json writes byte slices as base64 strings
+	if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := schemaName(t)
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, ok := schemas[name]; ok {
+			return ref
+		}
+
+		// Register the name first so recursive types terminate
+		schemas[name] = map[string]interface{}{}
+		schemas[name] = structSchema(t, schemas)
+		return ref
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema returns the object schema of a struct, following its json tags.
+// Fields without omitempty are required.
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, omitEmpty, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+
+		properties[name] = schemaFor(field.Type, schemas)
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// jsonFieldName returns the JSON name of a struct field the way encoding/json sees it
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+
+	return name, omitEmpty, false
+}
+
+// schemaName names the component schema of a struct. The api prefix of
+// the request and response types is dropped.
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	if name == "" {
+		return t.Name()
+	}
+
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// HandleOpenAPI serves the OpenAPI document of the API
+func (h *APIV1Handler) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, OpenAPIDocument(h.Routes()))
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	handler := NewAPIV1Handler(nil, nil, nil, nil, "")
+
+	rr := httptest.NewRecorder()
+	handler.HandleOpenAPI(rr, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var doc struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+				Required   []string               `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&doc); err != nil {
+		t.Fatalf("Could not decode OpenAPI document: %v", err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("Expected an OpenAPI 3 document, got version %q", doc.OpenAPI)
+	}
+
+	// Every route is documented with its success status
+	for _, route := range handler.Routes() {
+		operation, ok := doc.Paths[APIV1Prefix+route.Path][strings.ToLower(route.Method)]
+		if !ok {
+			t.Errorf("Route %s %s is missing from the document", route.Method, route.Path)
+			continue
+		}
+
+		responses, _ := operation["responses"].(map[string]interface{})
+		if _, ok := responses[strconv.Itoa(route.Status)]; !ok {
+			t.Errorf("Route %s %s does not document status %d", route.Method, route.Path, route.Status)
+		}
+	}
+
+	params := doc.Paths[APIV1Prefix+"/secrets/{id}"]["get"]["parameters"].([]interface{})
+	if len(params) != 1 || params[0].(map[string]interface{})["in"] != "path" {
+		t.Errorf("Expected the id path parameter, got %v", params)
+	}
+
+	secret, ok := doc.Components.Schemas["Secret"]
+	if !ok {
+		t.Fatal("Expected a Secret schema")
+	}
+	if _, ok := secret.Properties["encrypted_data"]; ok {
+		t.Error("Expected the Secret schema to leave out the encrypted data")
+	}
+
+	// Fields with omitempty are optional in request bodies
+	request := doc.Components.Schemas["CreateSecretRequest"]
+	if strings.Join(request.Required, ",") != "name" {
+		t.Errorf("Expected only the name to be required, got %v", request.Required)
+	}
+
+	// Fields hidden from JSON are hidden from the schema too
+	if _, ok := doc.Components.Schemas["SecretAssignment"].Properties["DeliveryData"]; ok {
+		t.Error("Expected fields tagged json:\"-\" to be left out")
+	}
+}
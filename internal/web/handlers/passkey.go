@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 	"github.com/korjavin/deadmanswitch/internal/web/middleware"
@@ -21,13 +22,15 @@ import (
 type PasskeyHandler struct {
 	repo            storage.Repository
 	webAuthnService *auth.WebAuthnService
+	sealer          *delivery.Sealer
 }
 
 // NewPasskeyHandler creates a new PasskeyHandler
-func NewPasskeyHandler(repo storage.Repository, webAuthnService *auth.WebAuthnService) *PasskeyHandler {
+func NewPasskeyHandler(repo storage.Repository, webAuthnService *auth.WebAuthnService, sealer *delivery.Sealer) *PasskeyHandler {
 	return &PasskeyHandler{
 		repo:            repo,
 		webAuthnService: webAuthnService,
+		sealer:          sealer,
 	}
 }
 
@@ -477,6 +480,10 @@ func (h *PasskeyHandler) HandleFinishLogin(w http.ResponseWriter, r *http.Reques
 		log.Printf("Error updating user last activity: %v", err)
 		// Continue anyway, this is not critical
 	}
+	if err := h.sealer.RenewQuestionTimelocks(r.Context(), user, nil); err != nil {
+		log.Printf("Error renewing question timelocks: %v", err)
+		// Continue anyway, this is not critical
+	}
 
 	// Set the session cookie
 	http.SetCookie(w, &http.Cookie{
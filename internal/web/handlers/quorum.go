@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+// parseQuorumThreshold reads the quorum settings from a secret form.
+// It returns 0 when quorum protection is not enabled.
+func parseQuorumThreshold(r *http.Request) (int, error) {
+	if r.FormValue("quorum") == "" {
+		return 0, nil
+	}
+
+	threshold, err := strconv.Atoi(r.FormValue("quorum_threshold"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid quorum threshold")
+	}
+
+	return threshold, nil
+}
+
+// findQuorumBlockedByRemoval returns the first quorum protected secret that
+// would be left with fewer recipients than its threshold if the given
+// assignments were removed, or nil if all removals are safe.
+func findQuorumBlockedByRemoval(ctx context.Context, repo storage.Repository, removed []*models.SecretAssignment) (*models.Secret, error) {
+	for _, assignment := range removed {
+		secret, err := repo.GetSecretByID(ctx, assignment.SecretID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !secret.IsQuorumProtected() {
+			continue
+		}
+
+		assignments, err := repo.ListSecretAssignmentsBySecretID(ctx, secret.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(assignments)-1 < secret.QuorumThreshold {
+			return secret, nil
+		}
+	}
+
+	return nil, nil
+}
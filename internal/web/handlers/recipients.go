@@ -4,11 +4,18 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/email"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
@@ -20,16 +27,26 @@ import (
 type RecipientsHandler struct {
 	repo        storage.Repository
 	emailClient *email.Client
+	vault       *auth.VaultService
+	sealer      *delivery.Sealer
+	baseDomain  string
 }
 
 // NewRecipientsHandler creates a new RecipientsHandler
-func NewRecipientsHandler(repo storage.Repository, emailClient *email.Client) *RecipientsHandler {
+func NewRecipientsHandler(repo storage.Repository, emailClient *email.Client, vault *auth.VaultService, sealer *delivery.Sealer) *RecipientsHandler {
 	return &RecipientsHandler{
 		repo:        repo,
 		emailClient: emailClient,
+		vault:       vault,
+		sealer:      sealer,
 	}
 }
 
+// SetBaseDomain sets the domain this server is reached at, named in the recovery kits
+func (h *RecipientsHandler) SetBaseDomain(domain string) {
+	h.baseDomain = domain
+}
+
 // HandleListRecipients handles the recipients list page
 func (h *RecipientsHandler) HandleListRecipients(w http.ResponseWriter, r *http.Request) {
 	// Get the authenticated user from context
@@ -90,8 +107,13 @@ func (h *RecipientsHandler) HandleListRecipients(w http.ResponseWriter, r *http.
 			"IsConfirmed":        r.IsConfirmed,
 			"ConfirmedAt":        r.ConfirmedAt,
 			"ConfirmationSentAt": r.ConfirmationSentAt,
+			"ReleaseDelay":       r.ReleaseDelayDays,
 			"AssignedSecrets":    assignedSecrets,
 		}
+		if key, err := crypto.ParsePublicKey(r.PublicKey); err == nil {
+			recipientEntry["PublicKeyType"] = key.Type
+			recipientEntry["PublicKeyFingerprint"] = key.Fingerprint
+		}
 		recipients = append(recipients, recipientEntry)
 	}
 
@@ -165,12 +187,26 @@ func (h *RecipientsHandler) HandleCreateRecipient(w http.ResponseWriter, r *http
 		return
 	}
 
+	releaseDelay, err := parseReleaseDelay(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	publicKey, err := parsePublicKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Create the recipient in the database
 	recipient := &models.Recipient{
-		UserID:  user.ID,
-		Name:    name,
-		Email:   email,
-		Message: notes, // Use the notes field as the message
+		UserID:           user.ID,
+		Name:             name,
+		Email:            email,
+		Message:          notes, // Use the notes field as the message
+		ReleaseDelayDays: releaseDelay,
+		PublicKey:        publicKey,
 	}
 
 	if err := h.repo.CreateRecipient(context.Background(), recipient); err != nil {
@@ -236,6 +272,8 @@ func (h *RecipientsHandler) HandleEditRecipientForm(w http.ResponseWriter, r *ht
 		"Name":          recipient.Name,
 		"Email":         recipient.Email,
 		"Notes":         recipient.Message,
+		"ReleaseDelay":  recipient.ReleaseDelayDays,
+		"PublicKey":     recipient.PublicKey,
 		"CreatedAt":     recipient.CreatedAt,
 		"UpdatedAt":     recipient.UpdatedAt,
 		"Relationship":  "other", // Default value, not in the base model
@@ -308,10 +346,34 @@ func (h *RecipientsHandler) HandleUpdateRecipient(w http.ResponseWriter, r *http
 		return
 	}
 
+	releaseDelay, err := parseReleaseDelay(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	publicKey, err := parsePublicKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The recipient's copies are rebuilt for a new key from the owner's copies
+	keyChanged := publicKey != recipient.PublicKey
+	var vaultKey []byte
+	if keyChanged && h.sealer.Enabled() {
+		vaultKey, ok = requireVaultKey(w, r, h.vault)
+		if !ok {
+			return
+		}
+	}
+
 	// Update the recipient
 	recipient.Name = name
 	recipient.Email = email
 	recipient.Message = notes
+	recipient.ReleaseDelayDays = releaseDelay
+	recipient.PublicKey = publicKey
 
 	if err := h.repo.UpdateRecipient(context.Background(), recipient); err != nil {
 		http.Error(w, "Error updating recipient", http.StatusInternalServerError)
@@ -319,6 +381,14 @@ func (h *RecipientsHandler) HandleUpdateRecipient(w http.ResponseWriter, r *http
 		return
 	}
 
+	if keyChanged {
+		if err := h.sealer.ResealRecipient(context.Background(), recipient.ID, vaultKey); err != nil {
+			http.Error(w, "Error sealing secrets for recipient", http.StatusInternalServerError)
+			log.Printf("Error resealing secrets for recipient %s: %v", recipient.ID, err)
+			return
+		}
+	}
+
 	// Create an audit log entry
 	auditLog := &models.AuditLog{
 		UserID:    user.ID,
@@ -488,6 +558,52 @@ func (h *RecipientsHandler) HandleUpdateRecipientSecrets(w http.ResponseWriter,
 		selectedSecretMap[id] = true
 	}
 
+	// Removing a recipient must not leave a quorum protected secret unrecoverable
+	var removed []*models.SecretAssignment
+	for secretID, assignment := range currentAssignmentMap {
+		if !selectedSecretMap[secretID] {
+			removed = append(removed, assignment)
+		}
+	}
+
+	blocked, err := findQuorumBlockedByRemoval(context.Background(), h.repo, removed)
+	if err != nil {
+		http.Error(w, "Error checking quorum protected secrets", http.StatusInternalServerError)
+		log.Printf("Error checking quorum protected secrets: %v", err)
+		return
+	}
+	if blocked != nil {
+		http.Error(w, fmt.Sprintf("Secret %q needs at least %d recipients", blocked.Name, blocked.QuorumThreshold), http.StatusBadRequest)
+		return
+	}
+
+	// Newly assigned secrets need a recipient copy and quorum protected secrets
+	// get fresh shares, both need the owner's copy of the content
+	var resealSecrets []*models.Secret
+	for _, assignment := range removed {
+		secret, err := h.repo.GetSecretByID(context.Background(), assignment.SecretID)
+		if err == nil && secret.IsQuorumProtected() {
+			resealSecrets = append(resealSecrets, secret)
+		}
+	}
+	for _, secretID := range selectedSecretIDs {
+		if _, exists := currentAssignmentMap[secretID]; exists {
+			continue
+		}
+		secret, err := h.repo.GetSecretByID(context.Background(), secretID)
+		if err == nil && secret.UserID == user.ID && (h.sealer.Enabled() || secret.IsQuorumProtected()) {
+			resealSecrets = append(resealSecrets, secret)
+		}
+	}
+
+	var masterKey []byte
+	if len(resealSecrets) > 0 {
+		masterKey, ok = requireVaultKey(w, r, h.vault)
+		if !ok {
+			return
+		}
+	}
+
 	// Remove assignments that are no longer selected
 	for secretID, assignment := range currentAssignmentMap {
 		if !selectedSecretMap[secretID] {
@@ -515,6 +631,14 @@ func (h *RecipientsHandler) HandleUpdateRecipientSecrets(w http.ResponseWriter,
 		}
 	}
 
+	for _, secret := range resealSecrets {
+		if err := h.sealer.ResealSecret(context.Background(), secret, masterKey); err != nil {
+			http.Error(w, "Error sealing secret for recipients", http.StatusInternalServerError)
+			log.Printf("Error resealing secret %s: %v", secret.ID, err)
+			return
+		}
+	}
+
 	// Create an audit log entry
 	auditLog := &models.AuditLog{
 		UserID:    user.ID,
@@ -532,6 +656,174 @@ func (h *RecipientsHandler) HandleUpdateRecipientSecrets(w http.ResponseWriter,
 	http.Redirect(w, r, "/recipients", http.StatusSeeOther)
 }
 
+// recipientQuestionRows is the number of question fields shown for a recipient without questions
+const recipientQuestionRows = 3
+
+// HandleRecipientQuestionsForm handles the page where the owner manages a recipient's personal questions
+func (h *RecipientsHandler) HandleRecipientQuestionsForm(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	recipient, ok := h.ownedRecipient(w, r, user)
+	if !ok {
+		return
+	}
+
+	questions, err := h.repo.ListRecipientQuestions(context.Background(), recipient.ID)
+	if err != nil {
+		http.Error(w, "Error fetching questions", http.StatusInternalServerError)
+		log.Printf("Error fetching questions: %v", err)
+		return
+	}
+
+	// The answers are never stored, so they have to be entered again on every change
+	rows := make([]string, 0, models.MaxRecipientQuestions)
+	for _, question := range questions {
+		rows = append(rows, question.Question)
+	}
+	if len(rows) < models.MaxRecipientQuestions {
+		rows = append(rows, "")
+	}
+	for len(rows) < recipientQuestionRows {
+		rows = append(rows, "")
+	}
+
+	opensAt, err := h.sealer.QuestionsOpenAt(context.Background(), recipient.ID)
+	if err != nil {
+		log.Printf("Error fetching question timelocks: %v", err)
+		// Continue anyway, the questions can still be edited
+	}
+
+	data := templates.TemplateData{
+		Title:           "Secret Questions for " + recipient.Name,
+		ActivePage:      "recipients",
+		IsAuthenticated: true,
+		User: map[string]interface{}{
+			"Email": user.Email,
+			"Name":  user.Email, // Use email as name since we don't have a separate name field
+		},
+		Data: map[string]interface{}{
+			"Recipient": map[string]interface{}{
+				"ID":    recipient.ID,
+				"Name":  recipient.Name,
+				"Email": recipient.Email,
+			},
+			"Questions":    rows,
+			"HasQuestions": recipient.HasQuestions(),
+			"Threshold":    recipient.QuestionThreshold,
+			"Timelock":     h.sealer.TimelockEnabled(),
+			"OpensAt":      opensAt,
+		},
+	}
+
+	if err := templates.RenderTemplate(w, "recipient-questions.html", data); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Error rendering recipient-questions template: %v", err)
+	}
+}
+
+// HandleUpdateRecipientQuestions handles saving or removing a recipient's personal questions
+func (h *RecipientsHandler) HandleUpdateRecipientQuestions(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	recipient, ok := h.ownedRecipient(w, r, user)
+	if !ok {
+		return
+	}
+
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	var questions, answers []string
+	var threshold int
+	if r.FormValue("_method") != "DELETE" {
+		var err error
+		questions, answers, threshold, err = parseRecipientQuestions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The recipient's copies are rebuilt from the owner's copies
+	var vaultKey []byte
+	if h.sealer.Enabled() {
+		vaultKey, ok = requireVaultKey(w, r, h.vault)
+		if !ok {
+			return
+		}
+	}
+
+	err := h.sealer.SetQuestions(context.Background(), recipient, questions, answers, threshold, vaultKey)
+	if errors.Is(err, delivery.ErrInvalidQuestions) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving questions", http.StatusInternalServerError)
+		log.Printf("Error saving questions for recipient %s: %v", recipient.ID, err)
+		return
+	}
+
+	// Create an audit log entry
+	details := fmt.Sprintf("Set %d personal questions for recipient %s, %d must be answered", len(questions), recipient.Name, threshold)
+	if len(questions) == 0 {
+		details = "Removed the personal questions of recipient: " + recipient.Name
+	}
+	auditLog := &models.AuditLog{
+		UserID:    user.ID,
+		Action:    "update_recipient_questions",
+		Timestamp: time.Now(),
+		Details:   details,
+	}
+
+	if err := h.repo.CreateAuditLog(context.Background(), auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	// Redirect to the recipient list page
+	http.Redirect(w, r, "/recipients", http.StatusSeeOther)
+}
+
+// ownedRecipient fetches the recipient from the URL and checks that it belongs to the user
+func (h *RecipientsHandler) ownedRecipient(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Recipient, bool) {
+	// Get the recipient ID from the URL
+	recipientID := r.PathValue("id")
+	if recipientID == "" {
+		http.Error(w, "Recipient ID is required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Fetch the recipient from the database
+	recipient, err := h.repo.GetRecipientByID(context.Background(), recipientID)
+	if err != nil {
+		http.Error(w, "Error fetching recipient", http.StatusInternalServerError)
+		log.Printf("Error fetching recipient: %v", err)
+		return nil, false
+	}
+
+	// Verify that the recipient belongs to the user
+	if recipient.UserID != user.ID {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return recipient, true
+}
+
 // HandleTestContact handles the test contact request
 func (h *RecipientsHandler) HandleTestContact(w http.ResponseWriter, r *http.Request) {
 	// Get the authenticated user from context
@@ -562,19 +854,42 @@ func (h *RecipientsHandler) HandleTestContact(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Check if email client is configured
-	if h.emailClient == nil {
-		http.Error(w, "Email client not configured", http.StatusInternalServerError)
-		log.Printf("Email client not configured, cannot send test contact")
+	if err := sendTestContact(context.Background(), h.repo, h.emailClient, r, user, recipient); err != nil {
+		http.Error(w, "Error sending test contact email", http.StatusInternalServerError)
+		log.Printf("Error sending test contact: %v", err)
 		return
 	}
 
+	log.Printf("Test contact sent to recipient: %s (%s)", recipient.Name, recipient.Email)
+
+	// Create an audit log entry
+	auditLog := &models.AuditLog{
+		UserID:    user.ID,
+		Action:    "test_contact_recipient",
+		Timestamp: time.Now(),
+		Details:   "Sent test contact to recipient: " + recipient.Name,
+	}
+
+	if err := h.repo.CreateAuditLog(context.Background(), auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	// Redirect to the recipients list page with a success message
+	http.Redirect(w, r, "/recipients?test_contact=success", http.StatusSeeOther)
+}
+
+// sendTestContact emails a recipient a link to confirm that their contact details are correct
+func sendTestContact(ctx context.Context, repo storage.Repository, emailClient *email.Client, r *http.Request, user *models.User, recipient *models.Recipient) error {
+	// Check if email client is configured
+	if emailClient == nil {
+		return fmt.Errorf("email client not configured")
+	}
+
 	// Generate a confirmation code
 	confirmationCode, err := generateConfirmationCode()
 	if err != nil {
-		http.Error(w, "Error generating confirmation code", http.StatusInternalServerError)
-		log.Printf("Error generating confirmation code: %v", err)
-		return
+		return fmt.Errorf("failed to generate confirmation code: %w", err)
 	}
 
 	// Update the recipient with the confirmation code
@@ -584,10 +899,8 @@ func (h *RecipientsHandler) HandleTestContact(w http.ResponseWriter, r *http.Req
 	recipient.IsConfirmed = false
 	recipient.ConfirmedAt = nil
 
-	if err := h.repo.UpdateRecipient(context.Background(), recipient); err != nil {
-		http.Error(w, "Error updating recipient", http.StatusInternalServerError)
-		log.Printf("Error updating recipient with confirmation code: %v", err)
-		return
+	if err := repo.UpdateRecipient(ctx, recipient); err != nil {
+		return fmt.Errorf("failed to update recipient with confirmation code: %w", err)
 	}
 
 	// Construct the confirmation URL
@@ -617,61 +930,156 @@ func (h *RecipientsHandler) HandleTestContact(w http.ResponseWriter, r *http.Req
 	`, recipient.Name, user.Email, confirmationURL, user.Email)
 
 	// Send the email
-	if err := h.emailClient.SendEmailSimple([]string{recipient.Email}, subject, message, true); err != nil {
-		http.Error(w, "Error sending test contact email", http.StatusInternalServerError)
-		log.Printf("Error sending test contact email: %v", err)
+	if err := emailClient.SendEmailSimple([]string{recipient.Email}, subject, message, true); err != nil {
+		return fmt.Errorf("failed to send test contact email: %w", err)
+	}
+
+	return nil
+}
+
+// generateConfirmationCode generates a random confirmation code
+func generateConfirmationCode() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// HandleConfirmRecipient handles the confirmation of a recipient
+func (h *RecipientsHandler) HandleConfirmRecipient(w http.ResponseWriter, r *http.Request) {
+	recipient, ok := h.recipientByConfirmationCode(w, r)
+	if !ok {
 		return
 	}
 
-	log.Printf("Test contact sent to recipient: %s (%s)", recipient.Name, recipient.Email)
+	// Mark the recipient as confirmed
+	ctx := context.Background()
+	now := time.Now().UTC()
+	recipient.IsConfirmed = true
+	recipient.ConfirmedAt = &now
+
+	if err := h.repo.UpdateRecipient(ctx, recipient); err != nil {
+		http.Error(w, "Error updating recipient", http.StatusInternalServerError)
+		log.Printf("Error updating recipient confirmation status: %v", err)
+		return
+	}
 
 	// Create an audit log entry
 	auditLog := &models.AuditLog{
-		UserID:    user.ID,
-		Action:    "test_contact_recipient",
-		Timestamp: time.Now(),
-		Details:   "Sent test contact to recipient: " + recipient.Name,
+		UserID:    recipient.UserID,
+		Action:    "recipient_confirmed",
+		Timestamp: now,
+		Details:   "Recipient confirmed: " + recipient.Name,
 	}
 
-	if err := h.repo.CreateAuditLog(context.Background(), auditLog); err != nil {
+	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
 		log.Printf("Error creating audit log: %v", err)
 		// Continue anyway, don't fail the whole request
 	}
 
-	// Redirect to the recipients list page with a success message
-	http.Redirect(w, r, "/recipients?test_contact=success", http.StatusSeeOther)
+	// Notify the user that the recipient has confirmed
+	h.notifyOwner(ctx, recipient, "Contact Confirmed", fmt.Sprintf(
+		"<p>Your contact %s (%s) has confirmed receipt of your test message.</p>\n"+
+			"<p>This contact is now marked as confirmed in your Dead Man's Switch account.</p>",
+		html.EscapeString(recipient.Name), html.EscapeString(recipient.Email)))
+
+	h.renderConfirmation(w, recipient, "Thank you for confirming your contact information. The user has been notified.")
 }
 
-// generateConfirmationCode generates a random confirmation code
-func generateConfirmationCode() (string, error) {
-	bytes := make([]byte, 16)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
+// HandleRegisterRecipientKey lets a recipient register their own public key
+// through the confirmation link. Their copies of secrets are encrypted to it
+// right away. A key can only be registered once this way, replacing it is up
+// to the owner.
+func (h *RecipientsHandler) HandleRegisterRecipientKey(w http.ResponseWriter, r *http.Request) {
+	recipient, ok := h.recipientByConfirmationCode(w, r)
+	if !ok {
+		return
 	}
-	return hex.EncodeToString(bytes), nil
+
+	if recipient.PublicKey != "" {
+		http.Error(w, "A public key is already registered. Please ask the person who added you to replace it.", http.StatusConflict)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	publicKey, err := parsePublicKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if publicKey == "" {
+		http.Error(w, "Public key is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	recipient.PublicKey = publicKey
+
+	if err := h.repo.UpdateRecipient(ctx, recipient); err != nil {
+		http.Error(w, "Error updating recipient", http.StatusInternalServerError)
+		log.Printf("Error registering public key of recipient %s: %v", recipient.ID, err)
+		return
+	}
+
+	encrypted, err := h.sealer.EncryptForRecipient(ctx, recipient)
+	if err != nil {
+		http.Error(w, "Error encrypting secrets to your key", http.StatusInternalServerError)
+		log.Printf("Error encrypting secrets to the public key of recipient %s: %v", recipient.ID, err)
+		return
+	}
+
+	key, _ := crypto.ParsePublicKey(publicKey)
+
+	auditLog := &models.AuditLog{
+		UserID:    recipient.UserID,
+		Action:    "recipient_key_registered",
+		Timestamp: time.Now().UTC(),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details: fmt.Sprintf("Recipient %s registered %s key %s, %d secrets encrypted to it",
+			recipient.Name, key.Type, key.Fingerprint, encrypted),
+	}
+
+	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	// Someone else holding the link could register their own key, so the owner is told
+	h.notifyOwner(ctx, recipient, "Recipient Key Registered", fmt.Sprintf(
+		"<p>Your contact %s (%s) registered a public key: <code>%s</code></p>\n"+
+			"<p>Their copies of your secrets are now encrypted to this key. If you didn't expect this, replace or remove the key on the Recipients page.</p>",
+		html.EscapeString(recipient.Name), html.EscapeString(recipient.Email), html.EscapeString(key.Fingerprint)))
+
+	h.renderConfirmation(w, recipient, "Your public key was registered. Whatever is left for you will be encrypted to it.")
 }
 
-// HandleConfirmRecipient handles the confirmation of a recipient
-func (h *RecipientsHandler) HandleConfirmRecipient(w http.ResponseWriter, r *http.Request) {
+// recipientByConfirmationCode finds the recipient a confirmation link was sent to
+// and writes an error response if the link can't be used
+func (h *RecipientsHandler) recipientByConfirmationCode(w http.ResponseWriter, r *http.Request) (*models.Recipient, bool) {
 	// Get the confirmation code from the URL
 	code := r.PathValue("code")
 	if code == "" {
 		http.Error(w, "Confirmation code is required", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
 	// Find the recipient with this confirmation code
-	ctx := context.Background()
-	recipients, err := h.findRecipientsByConfirmationCode(ctx, code)
+	recipients, err := h.findRecipientsByConfirmationCode(context.Background(), code)
 	if err != nil {
 		http.Error(w, "Error finding recipient", http.StatusInternalServerError)
 		log.Printf("Error finding recipient by confirmation code: %v", err)
-		return
+		return nil, false
 	}
 
 	if len(recipients) == 0 {
 		http.Error(w, "Invalid confirmation code", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
 	recipient := recipients[0]
@@ -679,72 +1087,59 @@ func (h *RecipientsHandler) HandleConfirmRecipient(w http.ResponseWriter, r *htt
 	// Check if the confirmation code is expired (7 days)
 	if recipient.ConfirmationSentAt == nil {
 		http.Error(w, "Invalid confirmation code", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
 	expiration := recipient.ConfirmationSentAt.Add(7 * 24 * time.Hour)
 	if time.Now().UTC().After(expiration) {
 		http.Error(w, "Confirmation code has expired", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
-	// Mark the recipient as confirmed
-	now := time.Now().UTC()
-	recipient.IsConfirmed = true
-	recipient.ConfirmedAt = &now
+	return recipient, true
+}
 
-	if err := h.repo.UpdateRecipient(ctx, recipient); err != nil {
-		http.Error(w, "Error updating recipient", http.StatusInternalServerError)
-		log.Printf("Error updating recipient confirmation status: %v", err)
+// notifyOwner emails the owner of a recipient about something the recipient did
+func (h *RecipientsHandler) notifyOwner(ctx context.Context, recipient *models.Recipient, title, body string) {
+	if h.emailClient == nil {
 		return
 	}
 
-	// Create an audit log entry
-	auditLog := &models.AuditLog{
-		UserID:    recipient.UserID,
-		Action:    "recipient_confirmed",
-		Timestamp: now,
-		Details:   "Recipient confirmed: " + recipient.Name,
-	}
-
-	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
-		log.Printf("Error creating audit log: %v", err)
-		// Continue anyway, don't fail the whole request
-	}
-
-	// Notify the user that the recipient has confirmed
 	user, err := h.repo.GetUserByID(ctx, recipient.UserID)
 	if err != nil {
 		log.Printf("Error fetching user for notification: %v", err)
-		// Continue anyway, don't fail the whole request
-	} else if h.emailClient != nil {
-		// Send notification email to the user
-		subject := "Dead Man's Switch - Contact Confirmed"
-		message := fmt.Sprintf(`
+		return
+	}
+
+	subject := "Dead Man's Switch - " + title
+	message := fmt.Sprintf(`
 			<html>
 			<body>
-				<h2>Dead Man's Switch - Contact Confirmed</h2>
+				<h2>Dead Man's Switch - %s</h2>
 				<p>Hello,</p>
-				<p>Your contact %s (%s) has confirmed receipt of your test message.</p>
-				<p>This contact is now marked as confirmed in your Dead Man's Switch account.</p>
+				%s
 				<p>Thank you,<br>Dead Man's Switch</p>
 			</body>
 			</html>
-		`, recipient.Name, recipient.Email)
+		`, title, body)
 
-		if err := h.emailClient.SendEmailSimple([]string{user.Email}, subject, message, true); err != nil {
-			log.Printf("Error sending confirmation notification email: %v", err)
-			// Continue anyway, don't fail the whole request
-		}
+	if err := h.emailClient.SendEmailSimple([]string{user.Email}, subject, message, true); err != nil {
+		log.Printf("Error sending notification email: %v", err)
+		// Continue anyway, don't fail the whole request
 	}
+}
 
-	// Render a confirmation success page
+// renderConfirmation renders the confirmation page, which offers to register a
+// public key as long as the recipient has none
+func (h *RecipientsHandler) renderConfirmation(w http.ResponseWriter, recipient *models.Recipient, message string) {
 	data := templates.TemplateData{
 		Title:           "Confirmation Successful",
 		ActivePage:      "",
 		IsAuthenticated: false,
 		Data: map[string]interface{}{
-			"Message": "Thank you for confirming your contact information. The user has been notified.",
+			"Message": message,
+			"Code":    recipient.ConfirmationCode,
+			"HasKey":  recipient.PublicKey != "",
 		},
 	}
 
@@ -815,6 +1210,25 @@ func (h *RecipientsHandler) HandleDeleteRecipient(w http.ResponseWriter, r *http
 		return
 	}
 
+	// Deleting a recipient must not leave a quorum protected secret unrecoverable
+	assignments, err := h.repo.ListSecretAssignmentsByRecipientID(context.Background(), recipientID)
+	if err != nil {
+		http.Error(w, "Error fetching secret assignments", http.StatusInternalServerError)
+		log.Printf("Error fetching secret assignments: %v", err)
+		return
+	}
+
+	blocked, err := findQuorumBlockedByRemoval(context.Background(), h.repo, assignments)
+	if err != nil {
+		http.Error(w, "Error checking quorum protected secrets", http.StatusInternalServerError)
+		log.Printf("Error checking quorum protected secrets: %v", err)
+		return
+	}
+	if blocked != nil {
+		http.Error(w, fmt.Sprintf("Secret %q needs at least %d recipients", blocked.Name, blocked.QuorumThreshold), http.StatusBadRequest)
+		return
+	}
+
 	// Delete the recipient
 	if err := h.repo.DeleteRecipient(context.Background(), recipientID); err != nil {
 		http.Error(w, "Error deleting recipient", http.StatusInternalServerError)
@@ -838,3 +1252,67 @@ func (h *RecipientsHandler) HandleDeleteRecipient(w http.ResponseWriter, r *http
 	// Redirect to the recipients list page
 	http.Redirect(w, r, "/recipients", http.StatusSeeOther)
 }
+
+// parsePublicKey reads the optional public key from a recipient form
+func parsePublicKey(r *http.Request) (string, error) {
+	publicKey := strings.TrimSpace(r.FormValue("public_key"))
+	if publicKey == "" {
+		return "", nil
+	}
+
+	if _, err := crypto.ParsePublicKey(publicKey); err != nil {
+		return "", err
+	}
+
+	return publicKey, nil
+}
+
+// parseReleaseDelay reads the release delay in days from a recipient form.
+// An empty field means the secrets are released as soon as the switch fires.
+func parseReleaseDelay(r *http.Request) (int, error) {
+	value := r.FormValue("release_delay_days")
+	if value == "" {
+		return 0, nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 || days > models.MaxReleaseDelayDays {
+		return 0, fmt.Errorf("release delay must be between 0 and %d days", models.MaxReleaseDelayDays)
+	}
+
+	return days, nil
+}
+
+// parseRecipientQuestions reads the personal questions and their answers from
+// a form. Rows left empty are skipped. Without a threshold two thirds of the
+// questions have to be answered.
+func parseRecipientQuestions(r *http.Request) ([]string, []string, int, error) {
+	formQuestions := r.Form["question"]
+	formAnswers := r.Form["answer"]
+
+	var questions, answers []string
+	for i, question := range formQuestions {
+		answer := ""
+		if i < len(formAnswers) {
+			answer = formAnswers[i]
+		}
+		if strings.TrimSpace(question) == "" && strings.TrimSpace(answer) == "" {
+			continue
+		}
+		if strings.TrimSpace(question) == "" || strings.TrimSpace(answer) == "" {
+			return nil, nil, 0, errors.New("every question needs an answer")
+		}
+		questions = append(questions, question)
+		answers = append(answers, answer)
+	}
+
+	threshold := (2*len(questions) + 2) / 3
+	if value := r.FormValue("threshold"); value != "" {
+		var err error
+		if threshold, err = strconv.Atoi(value); err != nil {
+			return nil, nil, 0, errors.New("threshold must be a number")
+		}
+	}
+
+	return questions, answers, threshold, nil
+}
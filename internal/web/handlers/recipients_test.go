@@ -9,11 +9,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/email"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 
 	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
 )
 
 // TestHandleListRecipients tests the list recipients handler
@@ -74,7 +78,7 @@ func TestHandleListRecipients(t *testing.T) {
 	repo.SecretAssignments = append(repo.SecretAssignments, assignment)
 
 	// Create the handler
-	handler := NewRecipientsHandler(repo, emailClient)
+	handler := NewRecipientsHandler(repo, emailClient, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
 
 	// Create a test request
 	req := httptest.NewRequest("GET", "/recipients", nil)
@@ -104,7 +108,7 @@ func TestHandleListRecipientsUnauthorized(t *testing.T) {
 	emailClient := &email.Client{}
 
 	// Create the handler
-	handler := NewRecipientsHandler(repo, emailClient)
+	handler := NewRecipientsHandler(repo, emailClient, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
 
 	// Create a test request with no user in context
 	req := httptest.NewRequest("GET", "/recipients", nil)
@@ -135,13 +139,14 @@ func TestHandleCreateRecipient(t *testing.T) {
 	repo.Users = append(repo.Users, user)
 
 	// Create the handler
-	handler := NewRecipientsHandler(repo, emailClient)
+	handler := NewRecipientsHandler(repo, emailClient, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
 
 	// Create form data
 	form := url.Values{}
 	form.Set("name", "New Recipient")
 	form.Set("email", "newrecipient@example.com")
 	form.Set("notes", "Test notes")
+	form.Set("release_delay_days", "7")
 
 	// Create a test request
 	req := httptest.NewRequest("POST", "/recipients/new", strings.NewReader(form.Encode()))
@@ -181,6 +186,10 @@ func TestHandleCreateRecipient(t *testing.T) {
 		t.Errorf("Expected recipient email 'newrecipient@example.com', got '%s'", repo.Recipients[0].Email)
 	}
 
+	if repo.Recipients[0].ReleaseDelayDays != 7 {
+		t.Errorf("Expected a release delay of 7 days, got %d", repo.Recipients[0].ReleaseDelayDays)
+	}
+
 	// Check that an audit log was created
 	if len(repo.AuditLogs) != 1 {
 		t.Errorf("Expected 1 audit log entry, got %d", len(repo.AuditLogs))
@@ -200,7 +209,7 @@ func TestHandleCreateRecipientUnauthorized(t *testing.T) {
 	emailClient := &email.Client{}
 
 	// Create the handler
-	handler := NewRecipientsHandler(repo, emailClient)
+	handler := NewRecipientsHandler(repo, emailClient, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
 
 	// Create form data
 	form := url.Values{}
@@ -248,3 +257,166 @@ func TestGenerateConfirmationCode(t *testing.T) {
 		t.Error("Expected different confirmation codes")
 	}
 }
+
+// TestHandleCreateRecipientInvalidPublicKey tests that keys that can't be encrypted to are rejected
+func TestHandleCreateRecipientInvalidPublicKey(t *testing.T) {
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user123", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+
+	handler := NewRecipientsHandler(repo, nil, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
+
+	form := url.Values{}
+	form.Set("name", "New Recipient")
+	form.Set("email", "newrecipient@example.com")
+	form.Set("public_key", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl")
+
+	req := newFormRequest("POST", "/recipients/new", form)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, user))
+	rr := httptest.NewRecorder()
+
+	handler.HandleCreateRecipient(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rr.Code)
+	}
+	if len(repo.Recipients) != 0 {
+		t.Errorf("Expected no recipient to be created, got %d", len(repo.Recipients))
+	}
+}
+
+// TestHandleRegisterRecipientKey tests that a recipient can register their own key through the confirmation link
+func TestHandleRegisterRecipientKey(t *testing.T) {
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+	sealer := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil)
+	handler := NewRecipientsHandler(repo, nil, auth.NewVaultService(repo), sealer)
+
+	sentAt := time.Now().UTC().Add(-time.Hour)
+	repo.Users = append(repo.Users, &models.User{ID: "user123", Email: "test@example.com"})
+	repo.Recipients = append(repo.Recipients, &models.Recipient{
+		ID:                 "recipient1",
+		UserID:             "user123",
+		Name:               "Alice",
+		Email:              "alice@example.com",
+		ConfirmationCode:   "code123",
+		ConfirmationSentAt: &sentAt,
+	})
+	repo.Secrets = append(repo.Secrets, &models.Secret{ID: "secret1", UserID: "user123", Name: "Bank"})
+	repo.SecretAssignments = append(repo.SecretAssignments, &models.SecretAssignment{
+		ID:          "assignment1",
+		SecretID:    "secret1",
+		RecipientID: "recipient1",
+		UserID:      "user123",
+	})
+	if err := sealer.Reseal(context.Background(), repo.Secrets[0], []byte("bank login")); err != nil {
+		t.Fatalf("Failed to seal secret: %v", err)
+	}
+
+	identity, ageRecipient, err := crypto.GenerateAgeIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+
+	register := func(publicKey string) *httptest.ResponseRecorder {
+		form := url.Values{"public_key": {publicKey}}
+		req := newFormRequest("POST", "/confirm/code123", form)
+		req.SetPathValue("code", "code123")
+		rr := httptest.NewRecorder()
+		handler.HandleRegisterRecipientKey(rr, req)
+		return rr
+	}
+
+	if rr := register("not a key"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid key, got %d", rr.Code)
+	}
+
+	rr := register(ageRecipient)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if repo.Recipients[0].PublicKey != ageRecipient {
+		t.Errorf("Expected the key to be registered, got %q", repo.Recipients[0].PublicKey)
+	}
+
+	// The existing copy is encrypted to the key
+	copyForAlice, err := sealer.Open(repo.SecretAssignments[0].DeliveryData)
+	if err != nil {
+		t.Fatalf("Failed to open recipient copy: %v", err)
+	}
+	if plaintext, err := crypto.DecryptWithAgeIdentity(copyForAlice, identity); err != nil || string(plaintext) != "bank login" {
+		t.Errorf("Expected the copy to decrypt with the identity, got %q (%v)", plaintext, err)
+	}
+
+	if len(repo.AuditLogs) != 1 || repo.AuditLogs[0].Action != "recipient_key_registered" {
+		t.Errorf("Expected a recipient_key_registered audit log entry, got %d entries", len(repo.AuditLogs))
+	}
+
+	// Replacing the key is up to the owner
+	_, otherRecipient, err := crypto.GenerateAgeIdentity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+	if rr := register(otherRecipient); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a second key, got %d", rr.Code)
+	}
+}
+
+// TestHandleUpdateRecipientQuestions tests that the owner can set and remove a recipient's personal questions
+func TestHandleUpdateRecipientQuestions(t *testing.T) {
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user123", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient1", UserID: "user123", Name: "Alice"})
+
+	// Without a master key there are no copies to rebuild, so the vault isn't needed
+	handler := NewRecipientsHandler(repo, nil, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
+
+	update := func(form url.Values) *httptest.ResponseRecorder {
+		req := newFormRequest("POST", "/recipients/recipient1/questions", form)
+		req.SetPathValue("id", "recipient1")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, user))
+		rr := httptest.NewRecorder()
+		handler.HandleUpdateRecipientQuestions(rr, req)
+		return rr
+	}
+
+	// A question without an answer is rejected
+	rr := update(url.Values{
+		"question": {"Where did we meet?", "First dog?"},
+		"answer":   {"Paris", ""},
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a missing answer, got %d", rr.Code)
+	}
+
+	// Empty rows are skipped and two thirds of the answers are needed by default
+	rr = update(url.Values{
+		"question":  {"Where did we meet?", "First dog?", "", "Favourite colour?"},
+		"answer":    {"Paris", "Rex", "", "Blue"},
+		"threshold": {""},
+	})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	recipient := repo.Recipients[0]
+	if recipient.QuestionThreshold != 2 || recipient.QuestionKey == "" || len(repo.RecipientQuestions) != 3 {
+		t.Fatalf("Expected 3 questions with threshold 2, got %d with threshold %d", len(repo.RecipientQuestions), recipient.QuestionThreshold)
+	}
+	for _, question := range repo.RecipientQuestions {
+		if strings.Contains(question.LockedShare, "Paris") {
+			t.Error("Answers must not be stored")
+		}
+	}
+
+	rr = update(url.Values{"_method": {"DELETE"}})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d", rr.Code)
+	}
+	if recipient.HasQuestions() || len(repo.RecipientQuestions) != 0 {
+		t.Error("Expected the questions to be removed")
+	}
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/email"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// RecoveryHandler lets the owner undo a switch that fired by mistake
+type RecoveryHandler struct {
+	repo        storage.Repository
+	emailClient *email.Client
+	vault       *auth.VaultService
+	sealer      *delivery.Sealer
+}
+
+// NewRecoveryHandler creates a new RecoveryHandler
+func NewRecoveryHandler(repo storage.Repository, emailClient *email.Client, vault *auth.VaultService, sealer *delivery.Sealer) *RecoveryHandler {
+	return &RecoveryHandler{
+		repo:        repo,
+		emailClient: emailClient,
+		vault:       vault,
+		sealer:      sealer,
+	}
+}
+
+// releasedDelivery is what one recipient was sent when the switch fired
+type releasedDelivery struct {
+	Recipient *models.Recipient
+	Event     *models.DeliveryEvent
+	Secrets   []string // Names of the secrets assigned to the recipient
+	Opened    bool     // The recipient opened one of their access links
+	OpenCodes int      // Access links that still work
+}
+
+// recoverySummary counts what a recovery undid
+type recoverySummary struct {
+	RevokedCodes        int `json:"revoked_codes"`
+	ResealedSecrets     int `json:"resealed_secrets"` // Quorum protected secrets split again because shares were delivered
+	CancelledDeliveries int `json:"cancelled_deliveries"`
+	NotifiedRecipients  int `json:"notified_recipients"`
+}
+
+// HandleRecovery shows what the last release of the switch sent to whom
+func (h *RecoveryHandler) HandleRecovery(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Recovering reseals quorum protected secrets, unlock the vault before showing the page
+	if _, ok := requireVaultKey(w, r, h.vault); !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	pageData := map[string]interface{}{
+		"Recovered": r.URL.Query().Get("recovered") == "success",
+	}
+
+	run, err := latestReleasedRun(ctx, h.repo, user.ID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "Error fetching delivery runs", http.StatusInternalServerError)
+		log.Printf("Error fetching delivery runs: %v", err)
+		return
+	}
+
+	if run != nil {
+		deliveries, err := listReleasedDeliveries(ctx, h.repo, user, run)
+		if err != nil {
+			http.Error(w, "Error fetching deliveries", http.StatusInternalServerError)
+			log.Printf("Error fetching released deliveries: %v", err)
+			return
+		}
+
+		pageData["Run"] = run
+		pageData["FiredAt"] = run.FiresAt.Format("Jan 2, 2006 15:04 MST")
+		pageData["Deliveries"] = deliveries
+	}
+
+	data := templates.TemplateData{
+		Title:           "Recover Your Switch",
+		ActivePage:      "dashboard",
+		IsAuthenticated: true,
+		Data:            pageData,
+	}
+
+	if err := templates.RenderTemplate(w, "recovery.html", data); err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// HandleRecover revokes the last release of the switch and re-arms it
+func (h *RecoveryHandler) HandleRecover(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	notify := r.FormValue("notify") == "on"
+
+	vaultKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	run, err := latestReleasedRun(ctx, h.repo, user.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Your switch hasn't released anything that could be recovered", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error fetching delivery runs", http.StatusInternalServerError)
+		log.Printf("Error fetching delivery runs: %v", err)
+		return
+	}
+
+	summary, err := recoverRun(ctx, h.repo, h.sealer, h.emailClient, user, vaultKey, run, notify)
+	if err != nil {
+		http.Error(w, "Error recovering switch", http.StatusInternalServerError)
+		log.Printf("Error recovering delivery run %s: %v", run.ID, err)
+		return
+	}
+
+	auditLog := &models.AuditLog{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Action:    "switch_recovered",
+		Timestamp: time.Now().UTC(),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details:   recoveryDetails(run, summary),
+	}
+
+	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log for recovery: %v", err)
+		// Continue anyway, the switch is recovered
+	}
+
+	http.Redirect(w, r, "/recovery?recovered=success", http.StatusSeeOther)
+}
+
+// latestReleasedRun returns the most recent run of a user that released secrets and hasn't been recovered yet
+func latestReleasedRun(ctx context.Context, repo storage.Repository, userID string) (*models.DeliveryRun, error) {
+	runs, err := repo.ListDeliveryRunsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, run := range runs {
+		switch run.Status {
+		case models.DeliveryRunStatusInProgress, models.DeliveryRunStatusCompleted:
+			return run, nil
+		case models.DeliveryRunStatusRecovered:
+			// Runs before the last recovery were undone by it
+			return nil, storage.ErrNotFound
+		}
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+// listReleasedDeliveries lists what every recipient of a run was sent
+func listReleasedDeliveries(ctx context.Context, repo storage.Repository, user *models.User, run *models.DeliveryRun) ([]releasedDelivery, error) {
+	events, err := repo.ListDeliveryEventsByRunID(ctx, run.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery events: %w", err)
+	}
+
+	secrets, err := repo.ListSecretsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secrets: %w", err)
+	}
+	secretNames := make(map[string]string, len(secrets))
+	for _, secret := range secrets {
+		secretNames[secret.ID] = secret.Name
+	}
+
+	accessCodes, err := repo.ListAccessCodesByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access codes: %w", err)
+	}
+
+	now := time.Now().UTC()
+	deliveries := make([]releasedDelivery, 0, len(events))
+	for _, event := range events {
+		recipient, err := repo.GetRecipientByID(ctx, event.RecipientID)
+		if err != nil {
+			// The recipient was deleted since, the delivery still happened
+			recipient = &models.Recipient{ID: event.RecipientID, Name: "Deleted recipient"}
+		}
+
+		delivery := releasedDelivery{
+			Recipient: recipient,
+			Event:     event,
+			Opened:    event.Status == models.DeliveryStatusViewed,
+		}
+
+		assignments, err := repo.ListSecretAssignmentsByRecipientID(ctx, event.RecipientID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get assignments: %w", err)
+		}
+		for _, assignment := range assignments {
+			if name, ok := secretNames[assignment.SecretID]; ok {
+				delivery.Secrets = append(delivery.Secrets, name)
+			}
+		}
+
+		for _, code := range accessCodes {
+			if code.DeliveryEventID != event.ID {
+				continue
+			}
+			if code.UsedAt != nil {
+				delivery.Opened = true
+			}
+			if code.ExpiresAt.After(now) {
+				delivery.OpenCodes++
+			}
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// recoverRun undoes what a released switch handed out as far as possible: all access
+// links of the user stop working, including those sent for emergency access, quorum protected secrets whose shares were
+// sent get new shares, queued deliveries are cancelled and the switch is re-armed.
+// Secrets a recipient has already seen can't be taken back. The switch is only re-armed
+// once the quorum secrets are resealed, it couldn't release them again before.
+func recoverRun(ctx context.Context, repo storage.Repository, sealer *delivery.Sealer, emailClient *email.Client, user *models.User, vaultKey []byte, run *models.DeliveryRun, notify bool) (*recoverySummary, error) {
+	summary := &recoverySummary{}
+
+	revoked, err := repo.RevokeAccessCodesByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke access codes: %w", err)
+	}
+	summary.RevokedCodes = revoked
+
+	resealed, err := sealer.ResealDeliveredQuorums(ctx, user.ID, vaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reseal quorum protected secrets: %w", err)
+	}
+	summary.ResealedSecrets = resealed
+
+	events, err := repo.ListDeliveryEventsByRunID(ctx, run.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery events: %w", err)
+	}
+
+	for _, event := range events {
+		if !event.IsQueued() {
+			continue
+		}
+
+		event.Status = models.DeliveryStatusCancelled
+		event.NextAttemptAt = nil
+		if err := repo.UpdateDeliveryEvent(ctx, event); err != nil {
+			return nil, fmt.Errorf("failed to cancel delivery event %s: %w", event.ID, err)
+		}
+		summary.CancelledDeliveries++
+	}
+
+	now := time.Now().UTC()
+	run.Status = models.DeliveryRunStatusRecovered
+	if run.CompletedAt == nil {
+		run.CompletedAt = &now
+	}
+	if err := repo.UpdateDeliveryRun(ctx, run); err != nil {
+		return nil, fmt.Errorf("failed to update delivery run: %w", err)
+	}
+
+	// Re-arm the switch, the owner is obviously around
+	user.PingingEnabled = true
+	user.LastActivity = now
+	user.NextScheduledPing = now.AddDate(0, 0, user.PingFrequency)
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to re-arm switch: %w", err)
+	}
+	if err := sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		log.Printf("Error renewing question timelocks: %v", err)
+	}
+
+	if !notify {
+		return summary, nil
+	}
+
+	if emailClient == nil {
+		log.Printf("Email client not configured, recipients of run %s are not notified of the recovery", run.ID)
+		return summary, nil
+	}
+
+	// Only recipients who may have received something are told that it was a mistake
+	for _, event := range events {
+		if event.Attempts == 0 {
+			continue
+		}
+
+		recipient, err := repo.GetRecipientByID(ctx, event.RecipientID)
+		if err != nil {
+			log.Printf("Error fetching recipient %s to notify of the recovery: %v", event.RecipientID, err)
+			continue
+		}
+
+		subject := "Dead Man's Switch - Release Cancelled"
+		message := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Dead Man's Switch - Release Cancelled</h2>
+			<p>Hello %s,</p>
+			<p>You recently received an email with access to information %s left for you. It was sent by mistake: %s is fine and has cancelled the release.</p>
+			<p>The access links in that email no longer work. If you already saw the information, please keep it private.</p>
+			<p>If you have any questions, please contact %s directly.</p>
+			<p>Thank you,<br>Dead Man's Switch</p>
+		</body>
+		</html>
+	`, recipient.Name, user.Email, user.Email, user.Email)
+
+		if err := emailClient.SendEmailSimple([]string{recipient.Email}, subject, message, true); err != nil {
+			log.Printf("Error notifying recipient %s of the recovery: %v", recipient.ID, err)
+			continue
+		}
+		summary.NotifiedRecipients++
+	}
+
+	return summary, nil
+}
+
+// recoveryDetails describes a recovery for the audit log
+func recoveryDetails(run *models.DeliveryRun, summary *recoverySummary) string {
+	return fmt.Sprintf("Recovered the release of %s: revoked %d access codes, resealed %d quorum secrets, cancelled %d deliveries, notified %d recipients, switch re-armed",
+		run.FiresAt.UTC().Format(time.RFC3339), summary.RevokedCodes, summary.ResealedSecrets, summary.CancelledDeliveries, summary.NotifiedRecipients)
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/recoverykit"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// HandleRecoveryKitForm handles the page where the owner prints a recovery kit for a recipient
+func (h *RecipientsHandler) HandleRecoveryKitForm(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	recipient, ok := h.ownedRecipient(w, r, user)
+	if !ok {
+		return
+	}
+
+	// The page shows which secrets the kit would hold, they have to be decrypted for that
+	vaultKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return
+	}
+
+	secrets, notes, err := recoverykit.Collect(r.Context(), h.repo, user, recipient, vaultKey)
+	if err != nil {
+		http.Error(w, "Error fetching secrets", http.StatusInternalServerError)
+		log.Printf("Error collecting secrets for a recovery kit: %v", err)
+		return
+	}
+
+	names := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		names = append(names, secret.Name)
+	}
+
+	data := templates.TemplateData{
+		Title:           "Recovery Kit for " + recipient.Name,
+		ActivePage:      "recipients",
+		IsAuthenticated: true,
+		User: map[string]interface{}{
+			"Email": user.Email,
+			"Name":  user.Email, // Use email as name since we don't have a separate name field
+		},
+		Data: map[string]interface{}{
+			"Recipient": map[string]interface{}{
+				"ID":    recipient.ID,
+				"Name":  recipient.Name,
+				"Email": recipient.Email,
+			},
+			"Secrets":             names,
+			"Notes":               notes,
+			"ServerURL":           fmt.Sprintf("https://%s", h.baseDomain),
+			"MinPassphraseLength": recoverykit.MinPassphraseLength,
+		},
+	}
+
+	if err := templates.RenderTemplate(w, "recovery-kit.html", data); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Error rendering recovery-kit template: %v", err)
+	}
+}
+
+// HandleCreateRecoveryKit downloads the printable recovery kit of a recipient
+// as a PDF, encrypted with a passphrase the owner tells the recipient separately
+func (h *RecipientsHandler) HandleCreateRecoveryKit(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	recipient, ok := h.ownedRecipient(w, r, user)
+	if !ok {
+		return
+	}
+
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	passphrase := r.FormValue("passphrase")
+	if passphrase != r.FormValue("confirm_passphrase") {
+		http.Error(w, "The passphrases don't match", http.StatusBadRequest)
+		return
+	}
+	if err := recoverykit.CheckPassphrase(passphrase); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vaultKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return
+	}
+
+	secrets, notes, err := recoverykit.Collect(r.Context(), h.repo, user, recipient, vaultKey)
+	if err != nil {
+		http.Error(w, "Error fetching secrets", http.StatusInternalServerError)
+		log.Printf("Error collecting secrets for a recovery kit: %v", err)
+		return
+	}
+	if len(secrets) == 0 {
+		http.Error(w, fmt.Sprintf("None of the secrets of %s can go on paper", recipient.Name), http.StatusBadRequest)
+		return
+	}
+
+	kit := &recoverykit.Kit{
+		Version:        recoverykit.Version,
+		CreatedAt:      time.Now().UTC(),
+		Owner:          user.Email,
+		Recipient:      recipient.Name,
+		RecipientEmail: recipient.Email,
+		ServerURL:      fmt.Sprintf("https://%s", h.baseDomain),
+		Secrets:        secrets,
+	}
+
+	parts, err := recoverykit.Seal(kit, passphrase)
+	if errors.Is(err, recoverykit.ErrTooLarge) {
+		http.Error(w, fmt.Sprintf("The secrets of %s are too large for a paper kit", recipient.Name), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error encrypting recovery kit", http.StatusInternalServerError)
+		log.Printf("Error encrypting recovery kit: %v", err)
+		return
+	}
+
+	// Render before answering, so an error doesn't end up in a half written download
+	var pdf bytes.Buffer
+	if err := recoverykit.WritePDF(&pdf, kit, parts); err != nil {
+		http.Error(w, "Error creating recovery kit", http.StatusInternalServerError)
+		log.Printf("Error creating recovery kit: %v", err)
+		return
+	}
+
+	details := fmt.Sprintf("Printed a recovery kit for recipient %s (secrets: %d)", recipient.Name, len(secrets))
+	if len(notes) > 0 {
+		details += ". " + strings.Join(notes, ". ")
+	}
+	auditLog := &models.AuditLog{
+		UserID:    user.ID,
+		Action:    "create_recovery_kit",
+		Timestamp: time.Now(),
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	}
+
+	if err := h.repo.CreateAuditLog(context.Background(), auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=deadmanswitch-recovery-kit-%s.pdf", kit.CreatedAt.Format("20060102")))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(pdf.Bytes())
+}
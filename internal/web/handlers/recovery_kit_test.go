@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+func TestRecoveryKit(t *testing.T) {
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+	owner := &models.User{ID: "user123", Email: "test@example.com"}
+	other := &models.User{ID: "user456", Email: "other@example.com"}
+	repo.Users = append(repo.Users, owner, other)
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient1", UserID: owner.ID, Email: "recipient@example.com", Name: "Recipient"})
+
+	vault := auth.NewVaultService(repo)
+	handler := NewRecipientsHandler(repo, nil, vault, delivery.NewSealer(repo, nil, nil))
+	handler.SetBaseDomain("dms.example.com")
+
+	session, vaultKey := unlockTestVault(t, vault, owner)
+
+	encrypted, err := crypto.EncryptSecret([]byte("PIN 1234"), vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+	repo.Secrets = append(repo.Secrets,
+		&models.Secret{ID: "secret1", UserID: owner.ID, Name: "Bank", EncryptedData: encrypted, EncryptionType: models.EncryptionTypeVault},
+		&models.Secret{ID: "secret2", UserID: owner.ID, Name: "Seed", EncryptedData: encrypted, EncryptionType: models.EncryptionTypeVault, QuorumThreshold: 2},
+	)
+	repo.SecretAssignments = append(repo.SecretAssignments,
+		&models.SecretAssignment{ID: "assignment1", SecretID: "secret1", RecipientID: "recipient1", UserID: owner.ID},
+		&models.SecretAssignment{ID: "assignment2", SecretID: "secret2", RecipientID: "recipient1", UserID: owner.ID},
+	)
+
+	request := func(method string, form url.Values, user *models.User) *httptest.ResponseRecorder {
+		req := newFormRequest(method, "/recipients/recipient1/recovery-kit", form)
+		req.SetPathValue("id", "recipient1")
+		req.Host = "attacker.example.net"
+		req = withSession(req, user, session)
+		rr := httptest.NewRecorder()
+		if method == http.MethodGet {
+			handler.HandleRecoveryKitForm(rr, req)
+		} else {
+			handler.HandleCreateRecoveryKit(rr, req)
+		}
+		return rr
+	}
+
+	rr := request(http.MethodGet, nil, owner)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "Bank") || !strings.Contains(body, "needs 2 recipients") {
+		t.Errorf("Expected the included and the left out secrets on the page, got %s", body)
+	}
+	// The kit names the configured server, not the host the request claims
+	if body := rr.Body.String(); !strings.Contains(body, "https://dms.example.com") || strings.Contains(body, "attacker.example.net") {
+		t.Errorf("Expected the configured server on the page, got %s", body)
+	}
+
+	if rr := request(http.MethodGet, nil, other); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for another user's recipient, got %d", rr.Code)
+	}
+	if rr := request(http.MethodPost, url.Values{"passphrase": {"short"}, "confirm_passphrase": {"short"}}, owner); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a short passphrase, got %d", rr.Code)
+	}
+
+	rr = request(http.MethodPost, url.Values{"passphrase": {"correct horse battery staple"}, "confirm_passphrase": {"correct horse battery staple"}}, owner)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/pdf" || !strings.HasPrefix(rr.Body.String(), "%PDF") {
+		t.Errorf("Expected a PDF, got %q", contentType)
+	}
+	if strings.Contains(rr.Body.String(), "PIN 1234") {
+		t.Error("Expected the secret to be encrypted in the kit")
+	}
+
+	if len(repo.AuditLogs) != 1 || repo.AuditLogs[0].Action != "create_recovery_kit" {
+		t.Errorf("Expected an audit log entry for the kit, got %+v", repo.AuditLogs)
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// setupRecoveryTest creates a user whose switch fired a day ago, with an unlocked vault. The
+// spouse was sent their secrets and opened them, the partner's delayed release is still scheduled.
+func setupRecoveryTest(t *testing.T) (*storage.MockRepository, *RecoveryHandler, *models.User, *models.Session) {
+	t.Helper()
+
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+
+	firedAt := time.Now().UTC().Add(-24 * time.Hour)
+	user := &models.User{
+		ID:             "user123",
+		Email:          "test@example.com",
+		LastActivity:   firedAt.Add(-15 * 24 * time.Hour),
+		PingFrequency:  7,
+		PingDeadline:   14,
+		PingingEnabled: false,
+	}
+	repo.Users = append(repo.Users, user)
+	repo.Secrets = append(repo.Secrets,
+		&models.Secret{ID: "bank", UserID: "user123", Name: "Bank"},
+		&models.Secret{ID: "servers", UserID: "user123", Name: "Servers"},
+	)
+	repo.Recipients = append(repo.Recipients,
+		&models.Recipient{ID: "spouse", UserID: "user123", Name: "Spouse", Email: "spouse@example.com"},
+		&models.Recipient{ID: "partner", UserID: "user123", Name: "Partner", Email: "partner@example.com", ReleaseDelayDays: 7},
+	)
+	repo.SecretAssignments = append(repo.SecretAssignments,
+		&models.SecretAssignment{ID: "assignment1", UserID: "user123", SecretID: "bank", RecipientID: "spouse"},
+		&models.SecretAssignment{ID: "assignment2", UserID: "user123", SecretID: "servers", RecipientID: "partner"},
+	)
+
+	releaseAt := firedAt.AddDate(0, 0, 7)
+	repo.DeliveryRuns = append(repo.DeliveryRuns, &models.DeliveryRun{
+		ID:        "run1",
+		UserID:    "user123",
+		Status:    models.DeliveryRunStatusInProgress,
+		StartedAt: firedAt.Add(-24 * time.Hour),
+		FiresAt:   firedAt,
+	})
+	repo.DeliveryEvents = append(repo.DeliveryEvents,
+		&models.DeliveryEvent{ID: "event1", UserID: "user123", RunID: "run1", RecipientID: "spouse", SentAt: firedAt,
+			Status: models.DeliveryStatusViewed, Attempts: 1, LastAttemptAt: &firedAt},
+		&models.DeliveryEvent{ID: "event2", UserID: "user123", RunID: "run1", RecipientID: "partner", SentAt: releaseAt,
+			Status: models.DeliveryStatusScheduled, NextAttemptAt: &releaseAt},
+	)
+	repo.AccessCodes = append(repo.AccessCodes, &models.AccessCode{
+		ID:              "code1",
+		RecipientID:     "spouse",
+		UserID:          "user123",
+		DeliveryEventID: "event1",
+		CreatedAt:       firedAt,
+		ExpiresAt:       firedAt.Add(7 * 24 * time.Hour),
+		MaxAttempts:     5,
+	})
+
+	vault := auth.NewVaultService(repo)
+	session, _ := unlockTestVault(t, vault, user)
+
+	return repo, NewRecoveryHandler(repo, nil, vault, delivery.NewSealer(repo, nil, nil)), user, session
+}
+
+func TestHandleRecovery(t *testing.T) {
+	_, handler, user, session := setupRecoveryTest(t)
+
+	rr := httptest.NewRecorder()
+	handler.HandleRecovery(rr, withSession(httptest.NewRequest("GET", "/recovery", nil), user, session))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{"spouse@example.com", "Bank", "Opened", "partner@example.com", "Servers", "scheduled"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the page to show %q", want)
+		}
+	}
+}
+
+func TestHandleRecover(t *testing.T) {
+	repo, handler, user, session := setupRecoveryTest(t)
+
+	// A link sent outside the release for an emergency access request is revoked too
+	repo.DeliveryEvents = append(repo.DeliveryEvents, &models.DeliveryEvent{ID: "event3", UserID: "user123", RecipientID: "spouse", Status: models.DeliveryStatusSent})
+	repo.AccessCodes = append(repo.AccessCodes, &models.AccessCode{ID: "code2", RecipientID: "spouse", UserID: "user123", DeliveryEventID: "event3", ExpiresAt: time.Now().Add(24 * time.Hour)})
+
+	// Without the vault nothing is recovered
+	rr := httptest.NewRecorder()
+	handler.HandleRecover(rr, withSession(newFormRequest("POST", "/recovery", url.Values{"notify": {"on"}}), user, &models.Session{ID: "locked", UserID: user.ID}))
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(rr.Header().Get("Location"), "/unlock") {
+		t.Fatalf("Expected a redirect to unlock the vault, got %d", rr.Code)
+	}
+	if repo.DeliveryRuns[0].Status == models.DeliveryRunStatusRecovered {
+		t.Fatal("Expected the run to stay until the vault is unlocked")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleRecover(rr, withSession(newFormRequest("POST", "/recovery", url.Values{"notify": {"on"}}), user, session))
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if repo.AccessCodes[0].ExpiresAt.After(time.Now()) {
+		t.Error("Expected the unused access code to be revoked")
+	}
+	if repo.AccessCodes[1].ExpiresAt.After(time.Now()) {
+		t.Error("Expected the emergency access code to be revoked")
+	}
+
+	if repo.DeliveryEvents[0].Status != models.DeliveryStatusViewed {
+		t.Errorf("Expected the opened delivery to stay as it was, got %q", repo.DeliveryEvents[0].Status)
+	}
+	if repo.DeliveryEvents[1].Status != models.DeliveryStatusCancelled || repo.DeliveryEvents[1].NextAttemptAt != nil {
+		t.Errorf("Expected the scheduled release to be cancelled, got %q", repo.DeliveryEvents[1].Status)
+	}
+
+	run := repo.DeliveryRuns[0]
+	if run.Status != models.DeliveryRunStatusRecovered || run.CompletedAt == nil {
+		t.Errorf("Expected the run to be recovered, got %q", run.Status)
+	}
+
+	if !user.PingingEnabled || time.Since(user.LastActivity) > time.Minute {
+		t.Error("Expected the switch to be re-armed")
+	}
+
+	if len(repo.AuditLogs) != 1 || repo.AuditLogs[0].Action != "switch_recovered" {
+		t.Fatalf("Expected a switch_recovered audit log entry, got %d entries", len(repo.AuditLogs))
+	}
+	if details := repo.AuditLogs[0].Details; !strings.Contains(details, "revoked 2 access codes") || !strings.Contains(details, "cancelled 1 deliveries") {
+		t.Errorf("Expected the audit log to record what was undone, got %q", details)
+	}
+
+	// There is nothing left to recover
+	rr = httptest.NewRecorder()
+	handler.HandleRecover(rr, withSession(newFormRequest("POST", "/recovery", nil), user, session))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 once the run is recovered, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,580 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/files"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+const (
+	// maxFormFieldSize limits each text field sent along with a file
+	maxFormFieldSize = 64 * 1024
+
+	// maxFormFieldsSize limits all text fields of an upload together
+	maxFormFieldsSize = 1024 * 1024
+)
+
+var (
+	// errNoFile is returned when an upload form has no file
+	errNoFile = errors.New("no file was uploaded")
+
+	// errInvalidUpload is returned when an upload form can't be read
+	errInvalidUpload = errors.New("invalid upload")
+)
+
+// SetFileStore enables file secrets. Files are limited to maxFileSize bytes
+// and the files of a user to fileQuota bytes together.
+func (h *SecretsHandler) SetFileStore(store *files.Store, maxFileSize, fileQuota int64) {
+	h.files = store
+	h.maxFileSize = maxFileSize
+	h.fileQuota = fileQuota
+}
+
+// HandleNewFileSecretForm handles the page where a file is uploaded as a secret
+func (h *SecretsHandler) HandleNewFileSecretForm(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.files == nil {
+		http.Error(w, "File secrets are not available", http.StatusNotFound)
+		return
+	}
+
+	// Make sure the vault is unlocked before the user picks a file
+	if _, ok := requireVaultKey(w, r, h.vault); !ok {
+		return
+	}
+
+	limit, err := h.fileLimit(context.Background(), user.ID, nil)
+	if err != nil {
+		http.Error(w, "Error checking file quota", http.StatusInternalServerError)
+		log.Printf("Error checking file quota: %v", err)
+		return
+	}
+
+	// Fetch the user's recipients from the database
+	dbRecipients, err := h.repo.ListRecipientsByUserID(context.Background(), user.ID)
+	if err != nil {
+		http.Error(w, "Error fetching recipients", http.StatusInternalServerError)
+		log.Printf("Error fetching recipients: %v", err)
+		return
+	}
+
+	// Convert to template-friendly format
+	recipients := make([]map[string]interface{}, 0, len(dbRecipients))
+	for _, r := range dbRecipients {
+		recipients = append(recipients, map[string]interface{}{
+			"ID":    r.ID,
+			"Name":  r.Name,
+			"Email": r.Email,
+		})
+	}
+
+	data := templates.TemplateData{
+		Title:           "Upload a File",
+		ActivePage:      "secrets",
+		IsAuthenticated: true,
+		User: map[string]interface{}{
+			"Email": user.Email,
+			"Name":  user.Email, // Use email as name since we don't have a separate name field
+		},
+		Data: map[string]interface{}{
+			"Recipients":      recipients,
+			"QuorumAvailable": h.sealer.Enabled(),
+			"MaxFileSize":     h.maxFileSize,
+			"FileLimit":       limit,
+		},
+	}
+
+	if err := templates.RenderTemplate(w, "new-file-secret.html", data); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Error rendering new-file-secret template: %v", err)
+	}
+}
+
+// HandleCreateFileSecret handles the upload of a file as a new secret
+func (h *SecretsHandler) HandleCreateFileSecret(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.files == nil {
+		http.Error(w, "File secrets are not available", http.StatusNotFound)
+		return
+	}
+
+	// Get the vault key from the user's session before the upload is read
+	masterKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+
+	limit, ok := h.requireFileLimit(w, ctx, user.ID, nil)
+	if !ok {
+		return
+	}
+
+	form, ref, file, ok := h.readUpload(w, r, limit)
+	if !ok {
+		return
+	}
+
+	// The file is only kept if the secret referencing it is created
+	created := false
+	defer func() {
+		if !created {
+			h.deleteFile(ref)
+		}
+	}()
+
+	// The form values were read from the multipart stream
+	r.Form = form
+
+	title := r.FormValue("title")
+	if title == "" {
+		title = file.Name
+	}
+
+	recipientIDs := r.Form["recipients"]
+
+	quorumThreshold, err := parseQuorumThreshold(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if quorumThreshold > 0 {
+		if !h.sealer.Enabled() {
+			http.Error(w, "Quorum protection requires a server master key", http.StatusBadRequest)
+			return
+		}
+
+		if err := delivery.ValidateQuorum(quorumThreshold, len(recipientIDs)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The content of a file secret is the key of the file, it is protected like any other content
+	content, err := files.Marshal(file)
+	if err != nil {
+		http.Error(w, "Error encrypting secret", http.StatusInternalServerError)
+		log.Printf("Error encoding file secret: %v", err)
+		return
+	}
+
+	encryptedData, err := crypto.EncryptSecret(content, masterKey)
+	if err != nil {
+		http.Error(w, "Error encrypting secret", http.StatusInternalServerError)
+		log.Printf("Error encrypting secret: %v", err)
+		return
+	}
+
+	secret := &models.Secret{
+		UserID:          user.ID,
+		Name:            title,
+		EncryptedData:   encryptedData,
+		EncryptionType:  models.EncryptionTypeVault,
+		QuorumThreshold: quorumThreshold,
+		FileRef:         ref,
+		FileSize:        file.Size,
+	}
+
+	if !h.createSecret(w, user, secret, content, recipientIDs) {
+		// A secret that was saved references the file even if sealing failed
+		_, err := h.repo.GetSecretByID(ctx, secret.ID)
+		created = err == nil
+		return
+	}
+	created = true
+
+	log.Printf("Stored file secret %s of %d bytes", secret.ID, file.Size)
+
+	// Redirect to the secrets list page
+	http.Redirect(w, r, "/secrets", http.StatusSeeOther)
+}
+
+// HandleDownloadSecretFile lets the owner download the file of a file secret
+func (h *SecretsHandler) HandleDownloadSecretFile(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, file, ok := h.openFileSecret(w, r, user)
+	if !ok {
+		return
+	}
+
+	if err := serveSecretFile(w, h.files, secret.FileRef, file); err != nil {
+		http.Error(w, "Error decrypting file", http.StatusInternalServerError)
+		log.Printf("Error decrypting file of secret %s: %v", secret.ID, err)
+	}
+}
+
+// HandleReplaceSecretFile handles the upload of a new file for a file secret
+func (h *SecretsHandler) HandleReplaceSecretFile(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, _, ok := h.openFileSecret(w, r, user)
+	if !ok {
+		return
+	}
+
+	// openFileSecret made sure the vault is unlocked
+	masterKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+
+	// The file being replaced doesn't count against the quota
+	limit, ok := h.requireFileLimit(w, ctx, user.ID, secret)
+	if !ok {
+		return
+	}
+
+	_, ref, file, ok := h.readUpload(w, r, limit)
+	if !ok {
+		return
+	}
+
+	// The revision with the old file is kept, its file is deleted once the revision is pruned
+	previous := newSecretVersion(secret)
+
+	content, err := files.Marshal(file)
+	if err == nil {
+		secret.EncryptedData, err = crypto.EncryptSecret(content, masterKey)
+	}
+	if err != nil {
+		h.deleteFile(ref)
+		http.Error(w, "Error encrypting secret", http.StatusInternalServerError)
+		log.Printf("Error encrypting secret: %v", err)
+		return
+	}
+
+	secret.FileRef = ref
+	secret.FileSize = file.Size
+	secret.UpdatedAt = time.Now().UTC()
+
+	if err := updateSecretKeepingVersion(ctx, h.repo, secret, previous, h.keepVersions); err != nil {
+		h.deleteFile(ref)
+		http.Error(w, "Error updating secret", http.StatusInternalServerError)
+		log.Printf("Error updating secret: %v", err)
+		return
+	}
+
+	pruneSecretVersions(ctx, h.repo, h.files, secret.ID, h.keepVersions)
+	releaseSecretFile(ctx, h.repo, h.files, secret.ID, previous.FileRef)
+
+	if h.sealer.Enabled() || secret.IsQuorumProtected() {
+		if err := h.sealer.ResealSecret(ctx, secret, masterKey); err != nil {
+			http.Error(w, "Error sealing secret for recipients", http.StatusInternalServerError)
+			log.Printf("Error resealing secret %s: %v", secret.ID, err)
+			return
+		}
+	}
+
+	// Create an audit log entry
+	auditLog := &models.AuditLog{
+		UserID:    user.ID,
+		Action:    "update_secret",
+		Timestamp: time.Now(),
+		Details:   "Uploaded a new file for secret: " + secret.Name,
+	}
+
+	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	// Redirect to the secrets list page
+	http.Redirect(w, r, "/secrets", http.StatusSeeOther)
+}
+
+// openFileSecret fetches a file secret of the user and decrypts its content.
+// It writes an error response and returns false if that isn't possible.
+func (h *SecretsHandler) openFileSecret(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Secret, *models.SecretFile, bool) {
+	if h.files == nil {
+		http.Error(w, "File secrets are not available", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	secret, err := h.repo.GetSecretByID(context.Background(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Error fetching secret", http.StatusInternalServerError)
+		log.Printf("Error fetching secret: %v", err)
+		return nil, nil, false
+	}
+
+	// Verify that the secret belongs to the user
+	if secret.UserID != user.ID {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	if !secret.IsFile() {
+		http.Error(w, "This secret is not a file", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	masterKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return nil, nil, false
+	}
+
+	content, err := crypto.DecryptSecret(secret.EncryptedData, masterKey)
+	if err != nil {
+		http.Error(w, "Error decrypting secret", http.StatusInternalServerError)
+		log.Printf("Error decrypting secret %s: %v", secret.ID, err)
+		return nil, nil, false
+	}
+
+	file, err := files.Parse(content)
+	if err != nil {
+		http.Error(w, "Error decrypting secret", http.StatusInternalServerError)
+		log.Printf("Error reading file secret %s: %v", secret.ID, err)
+		return nil, nil, false
+	}
+
+	return secret, file, true
+}
+
+// fileLimit returns the size of the largest file the user can upload, the
+// smaller of the file size limit and what is left of their quota. Files kept
+// for earlier revisions count as used. The file of the secret being replaced
+// doesn't, unless it is kept as a revision.
+func (h *SecretsHandler) fileLimit(ctx context.Context, userID string, replacing *models.Secret) (int64, error) {
+	secrets, err := h.repo.ListSecretsByUserID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	versions, err := h.repo.ListSecretVersionsByUserID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Revisions of a renamed file secret share its file, every file counts once
+	used := make(map[string]int64)
+	for _, secret := range secrets {
+		if secret.IsFile() {
+			used[secret.FileRef] = secret.FileSize
+		}
+	}
+	for _, version := range versions {
+		if version.IsFile() {
+			used[version.FileRef] = version.FileSize
+		}
+	}
+	if replacing != nil && h.keepVersions <= 0 {
+		delete(used, replacing.FileRef)
+	}
+
+	remaining := h.fileQuota
+	for _, size := range used {
+		remaining -= size
+	}
+
+	if remaining > h.maxFileSize {
+		return h.maxFileSize, nil
+	}
+	if remaining < 0 {
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// requireFileLimit returns fileLimit and writes an error response if the user's quota is used up
+func (h *SecretsHandler) requireFileLimit(w http.ResponseWriter, ctx context.Context, userID string, replacing *models.Secret) (int64, bool) {
+	limit, err := h.fileLimit(ctx, userID, replacing)
+	if err != nil {
+		http.Error(w, "Error checking file quota", http.StatusInternalServerError)
+		log.Printf("Error checking file quota: %v", err)
+		return 0, false
+	}
+
+	if limit <= 0 {
+		http.Error(w, "Your file quota is used up, delete a file secret to upload another one", http.StatusRequestEntityTooLarge)
+		return 0, false
+	}
+
+	return limit, true
+}
+
+// readUpload stores the file of an upload form and returns the other form
+// values. It writes an error response and returns false if the upload fails.
+func (h *SecretsHandler) readUpload(w http.ResponseWriter, r *http.Request, limit int64) (url.Values, string, *models.SecretFile, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit+maxFormFieldsSize)
+
+	form, ref, file, err := readFileUpload(r, h.files, limit)
+
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+		return form, ref, file, true
+	case errors.Is(err, files.ErrTooLarge) || errors.As(err, &maxBytesErr):
+		http.Error(w, fmt.Sprintf("The file is too large, you can upload up to %d bytes", limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errNoFile):
+		http.Error(w, "Please choose a file to upload", http.StatusBadRequest)
+	case errors.Is(err, errInvalidUpload):
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+	default:
+		http.Error(w, "Error storing file", http.StatusInternalServerError)
+		log.Printf("Error storing uploaded file: %v", err)
+	}
+	return nil, "", nil, false
+}
+
+// deleteFile removes a stored file that is no longer referenced
+func (h *SecretsHandler) deleteFile(ref string) {
+	if err := h.files.Delete(ref); err != nil {
+		log.Printf("Error deleting file %s: %v", ref, err)
+	}
+}
+
+// readFileUpload reads a multipart form and encrypts the file in its "file"
+// field into the store while it is read, so the file neither has to fit in
+// memory nor touches the disk unencrypted the way ParseMultipartForm would
+// leave it. The other fields are returned as form values.
+func readFileUpload(r *http.Request, store *files.Store, limit int64) (url.Values, string, *models.SecretFile, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", errInvalidUpload, err)
+	}
+
+	form := make(url.Values)
+	var ref string
+	var file *models.SecretFile
+	fail := func(err error) (url.Values, string, *models.SecretFile, error) {
+		if ref != "" {
+			if err := store.Delete(ref); err != nil {
+				log.Printf("Error deleting file %s: %v", ref, err)
+			}
+		}
+		return nil, "", nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return fail(err)
+			}
+			return fail(fmt.Errorf("%w: %v", errInvalidUpload, err))
+		}
+
+		if part.FormName() == "file" && part.FileName() != "" {
+			if ref != "" {
+				return fail(fmt.Errorf("%w: more than one file", errInvalidUpload))
+			}
+			if ref, file, err = store.Encrypt(part, part.FileName(), part.Header.Get("Content-Type"), limit); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+		if err != nil {
+			return fail(fmt.Errorf("%w: %v", errInvalidUpload, err))
+		}
+		if len(value) > maxFormFieldSize {
+			return fail(fmt.Errorf("%w: field %s is too large", errInvalidUpload, part.FormName()))
+		}
+		form.Add(part.FormName(), string(value))
+	}
+
+	if ref == "" {
+		return nil, "", nil, errNoFile
+	}
+
+	return form, ref, file, nil
+}
+
+// serveSecretFile decrypts a stored file while it is sent as a download with
+// its original name and type. A file that fails to decrypt part way through
+// can't be reported anymore, the download is cut short instead.
+func serveSecretFile(w http.ResponseWriter, store *files.Store, ref string, file *models.SecretFile) error {
+	plaintext, err := store.Decrypt(ref, file)
+	if err != nil {
+		return err
+	}
+	defer plaintext.Close()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": file.Name})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Type", file.Type)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Decrypted files must not end up in any cache
+	w.Header().Set("Cache-Control", "no-store")
+
+	if _, err := io.Copy(w, plaintext); err != nil {
+		log.Printf("Error sending file %s: %v", ref, err)
+	}
+	return nil
+}
+
+// serveEncryptedFile sends a stored file as it is, for recipients with a
+// public key who decrypt it themselves with the identity in their copy
+func serveEncryptedFile(w http.ResponseWriter, store *files.Store, ref, name string) error {
+	encrypted, err := store.Open(ref)
+	if err != nil {
+		return err
+	}
+	defer encrypted.Close()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": files.CleanName(name) + ".age"})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Type", files.DefaultType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if _, err := io.Copy(w, encrypted); err != nil {
+		log.Printf("Error sending file %s: %v", ref, err)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/files"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+)
+
+// newUploadRequest builds a multipart upload of a file with form fields for the user's session
+func newUploadRequest(t *testing.T, target string, fields map[string]string, name string, data []byte, user *models.User, session *models.Session) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	part, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", target, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return withSession(req, user, session)
+}
+
+func TestFileSecrets(t *testing.T) {
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user123", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient1", UserID: user.ID, Email: "recipient@example.com"})
+
+	vault := auth.NewVaultService(repo)
+	sealer := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil)
+	handler := NewSecretsHandler(repo, vault, sealer)
+	session, vaultKey := unlockTestVault(t, vault, user)
+
+	// Without a file store file secrets are not available
+	rr := httptest.NewRecorder()
+	handler.HandleCreateFileSecret(rr, newUploadRequest(t, "/secrets/new-file", nil, "will.pdf", []byte("will"), user, session))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a file store, got %d", rr.Code)
+	}
+
+	dir := t.TempDir()
+	store, err := files.NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	handler.SetFileStore(store, 1000, 1500)
+
+	// Upload a file as a new secret
+	data := bytes.Repeat([]byte("w"), 600)
+	rr = httptest.NewRecorder()
+	handler.HandleCreateFileSecret(rr, newUploadRequest(t, "/secrets/new-file",
+		map[string]string{"title": "My will", "recipients": "recipient1"}, "will.pdf", data, user, session))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(repo.Secrets) != 1 || !repo.Secrets[0].IsFile() || repo.Secrets[0].FileSize != 600 || repo.Secrets[0].Name != "My will" {
+		t.Fatalf("Expected a file secret of 600 bytes, got %+v", repo.Secrets)
+	}
+	secret := repo.Secrets[0]
+
+	// The content holds the key of the file and is sealed for the recipient like any other content
+	content, err := crypto.DecryptSecret(secret.EncryptedData, vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to decrypt secret: %v", err)
+	}
+	file, err := files.Parse(content)
+	if err != nil || file.Name != "will.pdf" || file.Size != 600 {
+		t.Fatalf("Unexpected file secret content %s: %v", content, err)
+	}
+	if len(repo.SecretAssignments) != 1 || repo.SecretAssignments[0].DeliveryData == "" {
+		t.Error("Expected the recipient copy to be sealed")
+	}
+
+	download := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/secrets/"+secret.ID+"/file", nil)
+		req.SetPathValue("id", secret.ID)
+		req = withSession(req, user, session)
+		rr := httptest.NewRecorder()
+		handler.HandleDownloadSecretFile(rr, req)
+		return rr
+	}
+
+	rr = download()
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), data) {
+		t.Fatalf("Expected the file to download, got status %d", rr.Code)
+	}
+	if disposition := rr.Header().Get("Content-Disposition"); disposition != "attachment; filename=will.pdf" {
+		t.Errorf("Unexpected Content-Disposition %q", disposition)
+	}
+
+	// A second file doesn't fit in what is left of the quota
+	rr = httptest.NewRecorder()
+	handler.HandleCreateFileSecret(rr, newUploadRequest(t, "/secrets/new-file", nil, "big.bin", make([]byte, 901), user, session))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 over the quota, got %d", rr.Code)
+	}
+	if len(repo.Secrets) != 1 {
+		t.Errorf("Expected no secret for a rejected upload, got %d", len(repo.Secrets))
+	}
+
+	// A file without a file field is rejected
+	rr = httptest.NewRecorder()
+	handler.HandleCreateFileSecret(rr, newUploadRequest(t, "/secrets/new-file", nil, "", nil, user, session))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a file, got %d", rr.Code)
+	}
+
+	// The replaced file is kept for the revision history and still counts against the quota
+	newData := bytes.Repeat([]byte("n"), 900)
+	req := newUploadRequest(t, "/secrets/"+secret.ID+"/file", nil, "will-v2.pdf", newData, user, session)
+	req.SetPathValue("id", secret.ID)
+	oldRef := secret.FileRef
+	rr = httptest.NewRecorder()
+	handler.HandleReplaceSecretFile(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if secret.FileRef == oldRef || secret.FileSize != 900 {
+		t.Errorf("Expected a new file of 900 bytes, got %s of %d bytes", secret.FileRef, secret.FileSize)
+	}
+	if rr := download(); !bytes.Equal(rr.Body.Bytes(), newData) {
+		t.Error("Expected the new file to download")
+	}
+
+	// The old file is kept for the earlier revision, with the key that opens it
+	if len(repo.SecretVersions) != 1 || repo.SecretVersions[0].FileRef != oldRef {
+		t.Fatalf("Expected the old file to be kept as a revision, got %d revisions", len(repo.SecretVersions))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected 2 stored files, got %d", len(entries))
+	}
+	version := repo.SecretVersions[0]
+	content, err = crypto.DecryptSecret(version.EncryptedData, vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to decrypt revision: %v", err)
+	}
+	if file, err = files.Parse(content); err != nil || file.Name != "will.pdf" {
+		t.Fatalf("Expected the revision to hold the old file, got %s: %v", content, err)
+	}
+	reader, err := store.Decrypt(oldRef, file)
+	if err != nil {
+		t.Fatalf("Failed to open the old file with the revision's key: %v", err)
+	}
+	if old, err := io.ReadAll(reader); err != nil || !bytes.Equal(old, data) {
+		t.Errorf("Expected the revision to open the old file: %v", err)
+	}
+	reader.Close()
+
+	// Restoring the revision brings the old file back
+	req = newFormRequest("POST", "/secrets/"+secret.ID+"/versions/"+version.ID+"/restore", url.Values{})
+	req.SetPathValue("id", secret.ID)
+	req.SetPathValue("version", version.ID)
+	rr = httptest.NewRecorder()
+	handler.HandleRestoreSecretVersion(rr, withSession(req, user, session))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if secret.FileRef != oldRef {
+		t.Errorf("Expected the old file to be restored, got %s", secret.FileRef)
+	}
+	if rr := download(); rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), data) {
+		t.Errorf("Expected the restored file to download, got status %d", rr.Code)
+	}
+	if disposition := download().Header().Get("Content-Disposition"); disposition != "attachment; filename=will.pdf" {
+		t.Errorf("Expected the restored file name, got %q", disposition)
+	}
+
+	// Without a history the replaced file and the earlier revisions are deleted
+	handler.SetSecretVersions(0)
+	req = newUploadRequest(t, "/secrets/"+secret.ID+"/file", nil, "will-v3.pdf", []byte("v3"), user, session)
+	req.SetPathValue("id", secret.ID)
+	rr = httptest.NewRecorder()
+	handler.HandleReplaceSecretFile(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || len(repo.SecretVersions) != 0 {
+		t.Errorf("Expected only the current file without revisions, got %d files and %d revisions", len(entries), len(repo.SecretVersions))
+	}
+
+	// Deleting the secret removes its files
+	req = httptest.NewRequest("POST", "/secrets/"+secret.ID, nil)
+	req.SetPathValue("id", secret.ID)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, user))
+	handler.HandleDeleteSecret(httptest.NewRecorder(), req)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected the files to be deleted with the secret, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/importer"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// maxImportSize limits the size of an uploaded password manager export
+const maxImportSize = 10 << 20
+
+// errNoEntriesSelected is returned when no entry of an export was picked
+var errNoEntriesSelected = errors.New("no entries were selected")
+
+// HandleImportForm handles the page where a password manager export is uploaded
+func (h *SecretsHandler) HandleImportForm(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Imported entries are encrypted with the vault key, make sure it is unlocked first
+	if _, ok := requireVaultKey(w, r, h.vault); !ok {
+		return
+	}
+
+	h.renderImport(w, user, map[string]interface{}{
+		"MaxImportSize": int64(maxImportSize),
+	})
+}
+
+// HandleImportPreview reads an uploaded export and lists its entries, so the
+// owner can pick the ones to import. The export is only held in memory, the
+// parsed entries are passed on to the confirmation encrypted with the vault key.
+func (h *SecretsHandler) HandleImportPreview(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	masterKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize+maxFormFieldsSize)
+	export, _, err := readUploadInMemory(r, maxImportSize)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+	case errors.As(err, &maxBytesErr):
+		http.Error(w, fmt.Sprintf("The export is too large, you can upload up to %d bytes", maxImportSize), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, errNoFile):
+		http.Error(w, "Please choose an export to upload", http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	result, err := importer.Parse(export)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := sealImportEntries(result.Entries, masterKey)
+	if err != nil {
+		http.Error(w, "Error reading export", http.StatusInternalServerError)
+		log.Printf("Error encrypting import entries: %v", err)
+		return
+	}
+
+	// Fetch the user's recipients for the bulk assignment
+	dbRecipients, err := h.repo.ListRecipientsByUserID(context.Background(), user.ID)
+	if err != nil {
+		http.Error(w, "Error fetching recipients", http.StatusInternalServerError)
+		log.Printf("Error fetching recipients: %v", err)
+		return
+	}
+
+	recipients := make([]map[string]interface{}, 0, len(dbRecipients))
+	for _, r := range dbRecipients {
+		recipients = append(recipients, map[string]interface{}{
+			"ID":    r.ID,
+			"Name":  r.Name,
+			"Email": r.Email,
+		})
+	}
+
+	entries := make([]map[string]interface{}, 0, len(result.Entries))
+	for i, entry := range result.Entries {
+		typeName := "Note"
+		if typ, err := secrettypes.Lookup(entry.Type); err == nil {
+			typeName = typ.Name
+		}
+
+		entries = append(entries, map[string]interface{}{
+			"Index":    i,
+			"Name":     entry.Name,
+			"Folder":   entry.Folder,
+			"TypeName": typeName,
+			"URL":      entry.URL(),
+			"Username": entry.Username(),
+		})
+	}
+
+	h.renderImport(w, user, map[string]interface{}{
+		"Format":     result.Format,
+		"Entries":    entries,
+		"Recipients": recipients,
+		"ImportData": data,
+	})
+}
+
+// HandleImportSecrets imports the picked entries of an export as secrets
+func (h *SecretsHandler) HandleImportSecrets(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	masterKey, ok := requireVaultKey(w, r, h.vault)
+	if !ok {
+		return
+	}
+
+	entries, err := openImportEntries(r.FormValue("data"), masterKey)
+	if err != nil {
+		http.Error(w, "The export can't be read anymore, please upload it again", http.StatusBadRequest)
+		log.Printf("Error decrypting import entries: %v", err)
+		return
+	}
+
+	indices := make([]int, 0, len(r.Form["entries"]))
+	for _, value := range r.Form["entries"] {
+		index, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid entry selection", http.StatusBadRequest)
+			return
+		}
+		indices = append(indices, index)
+	}
+
+	selected, err := selectImportEntries(entries, indices)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only the user's own recipients can be assigned
+	recipientIDs := uniqueStrings(r.Form["recipients"])
+	for _, recipientID := range recipientIDs {
+		recipient, err := h.repo.GetRecipientByID(context.Background(), recipientID)
+		if err != nil || recipient == nil || recipient.UserID != user.ID {
+			http.Error(w, "Unknown recipient", http.StatusBadRequest)
+			return
+		}
+	}
+
+	secrets, importErr := importEntries(context.Background(), h.repo, h.sealer, user, masterKey, selected, recipientIDs)
+
+	// The secrets created before an error are kept, they are logged either way
+	if len(secrets) > 0 {
+		auditLog := &models.AuditLog{
+			UserID:    user.ID,
+			Action:    "import_secrets",
+			Timestamp: time.Now(),
+			Details:   fmt.Sprintf("Imported secrets from a password manager export: %d", len(secrets)),
+		}
+
+		if err := h.repo.CreateAuditLog(context.Background(), auditLog); err != nil {
+			log.Printf("Error creating audit log: %v", err)
+			// Continue anyway, don't fail the whole request
+		}
+	}
+
+	if importErr != nil {
+		http.Error(w, fmt.Sprintf("Error importing secrets, %d of %d were imported", len(secrets), len(selected)), http.StatusInternalServerError)
+		log.Printf("Error importing secrets: %v", importErr)
+		return
+	}
+
+	// Redirect to the secrets list page
+	http.Redirect(w, r, "/secrets", http.StatusSeeOther)
+}
+
+// renderImport renders the import page with the upload form or the preview
+func (h *SecretsHandler) renderImport(w http.ResponseWriter, user *models.User, data map[string]interface{}) {
+	tmplData := templates.TemplateData{
+		Title:           "Import Secrets",
+		ActivePage:      "secrets",
+		IsAuthenticated: true,
+		User: map[string]interface{}{
+			"Email": user.Email,
+			"Name":  user.Email, // Use email as name since we don't have a separate name field
+		},
+		Data: data,
+	}
+
+	if err := templates.RenderTemplate(w, "import-secrets.html", tmplData); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Error rendering import-secrets template: %v", err)
+	}
+}
+
+// readUploadInMemory reads the "file" field of a multipart form of up to
+// limit bytes and the other fields into memory. The file is never written to
+// disk, unlike with ParseMultipartForm.
+func readUploadInMemory(r *http.Request, limit int64) ([]byte, url.Values, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidUpload, err)
+	}
+
+	var upload []byte
+	form := make(url.Values)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("%w: %v", errInvalidUpload, err)
+		}
+
+		partLimit := int64(maxFormFieldSize)
+		isFile := part.FormName() == "file" && part.FileName() != ""
+		if isFile {
+			if upload != nil {
+				return nil, nil, fmt.Errorf("%w: more than one file", errInvalidUpload)
+			}
+			partLimit = limit
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, partLimit+1))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("%w: %v", errInvalidUpload, err)
+		}
+		if int64(len(value)) > partLimit {
+			return nil, nil, &http.MaxBytesError{Limit: partLimit}
+		}
+
+		if isFile {
+			upload = value
+		} else {
+			form.Add(part.FormName(), string(value))
+		}
+	}
+
+	if len(upload) == 0 {
+		return nil, nil, errNoFile
+	}
+
+	return upload, form, nil
+}
+
+// sealImportEntries encrypts the entries of a previewed export, so they can
+// be passed on to the confirmation without keeping them on the server
+func sealImportEntries(entries []*importer.Entry, key []byte) (string, error) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+	return crypto.EncryptSecret(data, key)
+}
+
+// openImportEntries decrypts the entries of a previewed export
+func openImportEntries(data string, key []byte) ([]*importer.Entry, error) {
+	plaintext, err := crypto.DecryptSecret(data, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*importer.Entry
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// selectImportEntries returns the entries at the given indices, in the order
+// of the export. All entries are returned if indices is nil.
+func selectImportEntries(entries []*importer.Entry, indices []int) ([]*importer.Entry, error) {
+	if indices == nil {
+		return entries, nil
+	}
+
+	picked := make(map[int]bool, len(indices))
+	for _, index := range indices {
+		if index < 0 || index >= len(entries) {
+			return nil, fmt.Errorf("entry %d is not part of the export", index)
+		}
+		picked[index] = true
+	}
+	if len(picked) == 0 {
+		return nil, errNoEntriesSelected
+	}
+
+	selected := make([]*importer.Entry, 0, len(picked))
+	for i, entry := range entries {
+		if picked[i] {
+			selected = append(selected, entry)
+		}
+	}
+	return selected, nil
+}
+
+// importEntries creates a secret for each entry, encrypted with the vault key
+// like any other secret, and assigns it to the recipients. It returns the
+// secrets created before an error.
+func importEntries(ctx context.Context, repo storage.Repository, sealer *delivery.Sealer, user *models.User, vaultKey []byte, entries []*importer.Entry, recipientIDs []string) ([]*models.Secret, error) {
+	secrets := make([]*models.Secret, 0, len(entries))
+	for _, entry := range entries {
+		content, err := entry.Content()
+		if err != nil {
+			return secrets, fmt.Errorf("entry %q: %w", entry.Name, err)
+		}
+
+		encryptedData, err := crypto.EncryptSecret(content, vaultKey)
+		if err != nil {
+			return secrets, fmt.Errorf("error encrypting secret: %w", err)
+		}
+
+		secret := &models.Secret{
+			UserID:         user.ID,
+			Name:           entry.Name,
+			EncryptedData:  encryptedData,
+			EncryptionType: models.EncryptionTypeVault,
+			Type:           entry.Type,
+		}
+		if err := repo.CreateSecret(ctx, secret); err != nil {
+			return secrets, fmt.Errorf("error creating secret: %w", err)
+		}
+		secrets = append(secrets, secret)
+
+		for _, recipientID := range recipientIDs {
+			assignment := &models.SecretAssignment{
+				SecretID:    secret.ID,
+				RecipientID: recipientID,
+				UserID:      user.ID,
+			}
+			if err := repo.CreateSecretAssignment(ctx, assignment); err != nil {
+				return secrets, fmt.Errorf("error creating secret assignment: %w", err)
+			}
+		}
+
+		// Seal the recipient copies now that the recipients are assigned
+		if sealer.Enabled() {
+			if err := sealer.Reseal(ctx, secret, content); err != nil {
+				return secrets, fmt.Errorf("error sealing secret %s: %w", secret.ID, err)
+			}
+		}
+	}
+
+	return secrets, nil
+}
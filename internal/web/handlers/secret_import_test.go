@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+const testBitwardenExport = `{"encrypted": false, "items": [
+  {"type": 1, "name": "Mail", "login": {"uris": [{"uri": "https://mail.example.com"}], "username": "alice", "password": "hunter2"}},
+  {"type": 2, "name": "Safe", "notes": "12-34-56"}
+]}`
+
+var importDataPattern = regexp.MustCompile(`name="data" value="([^"]+)"`)
+
+func TestImportSecrets(t *testing.T) {
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user123", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients,
+		&models.Recipient{ID: "recipient1", UserID: user.ID, Email: "recipient@example.com"},
+		&models.Recipient{ID: "recipient2", UserID: "other", Email: "other@example.com"},
+	)
+
+	vault := auth.NewVaultService(repo)
+	handler := NewSecretsHandler(repo, vault, delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil))
+	session, vaultKey := unlockTestVault(t, vault, user)
+
+	// Exports that can't be read are refused before anything is stored
+	rr := httptest.NewRecorder()
+	handler.HandleImportPreview(rr, newUploadRequest(t, "/secrets/import", nil, "export.json", []byte(`{"encrypted": true}`), user, session))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "encrypted") {
+		t.Errorf("Expected status 400 for an encrypted export, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The preview lists the entries without their passwords
+	rr = httptest.NewRecorder()
+	handler.HandleImportPreview(rr, newUploadRequest(t, "/secrets/import", nil, "export.json", []byte(testBitwardenExport), user, session))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Mail") || !strings.Contains(body, "Safe") || !strings.Contains(body, "Bitwarden JSON") {
+		t.Errorf("Expected both entries in the preview, got %s", body)
+	}
+	if strings.Contains(body, "hunter2") {
+		t.Error("Expected the preview not to show passwords")
+	}
+	match := importDataPattern.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatal("Expected the entries in the form")
+	}
+	data := html.UnescapeString(match[1])
+	if len(repo.Secrets) != 0 {
+		t.Fatalf("Expected nothing to be stored before the import is confirmed, got %d secrets", len(repo.Secrets))
+	}
+
+	confirm := func(form url.Values) *httptest.ResponseRecorder {
+		req := newFormRequest("POST", "/secrets/import/confirm", form)
+		req = withSession(req, user, session)
+		rr := httptest.NewRecorder()
+		handler.HandleImportSecrets(rr, req)
+		return rr
+	}
+
+	if rr := confirm(url.Values{"data": {data}, "entries": {"0"}, "recipients": {"recipient2"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for another user's recipient, got %d", rr.Code)
+	}
+	if rr := confirm(url.Values{"data": {data}, "entries": {"2"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown entry, got %d", rr.Code)
+	}
+	if rr := confirm(url.Values{"data": {data}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without entries, got %d", rr.Code)
+	}
+	if rr := confirm(url.Values{"data": {"tampered"}, "entries": {"0"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for data that can't be decrypted, got %d", rr.Code)
+	}
+
+	// Only the picked entry is imported and assigned
+	rr = confirm(url.Values{"data": {data}, "entries": {"0"}, "recipients": {"recipient1"}})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(repo.Secrets) != 1 {
+		t.Fatalf("Expected 1 secret, got %d", len(repo.Secrets))
+	}
+	secret := repo.Secrets[0]
+	if secret.Name != "Mail" || secret.Type != secrettypes.Login || secret.EncryptionType != models.EncryptionTypeVault {
+		t.Errorf("Unexpected secret: %+v", secret)
+	}
+	plaintext, err := crypto.DecryptSecret(secret.EncryptedData, vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to decrypt secret: %v", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(plaintext, &values); err != nil || values["password"] != "hunter2" || values["username"] != "alice" {
+		t.Errorf("Unexpected content %s", plaintext)
+	}
+	if len(repo.SecretAssignments) != 1 || repo.SecretAssignments[0].RecipientID != "recipient1" || repo.SecretAssignments[0].DeliveryData == "" {
+		t.Errorf("Expected a sealed assignment to recipient1, got %+v", repo.SecretAssignments)
+	}
+
+	found := false
+	for _, entry := range repo.AuditLogs {
+		if entry.Action == "import_secrets" && entry.Details == "Imported secrets from a password manager export: 1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected an audit log entry for the import")
+	}
+}
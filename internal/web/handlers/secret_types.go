@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
+)
+
+// secretType returns the type of a secret. Secrets of a type this version
+// doesn't know are shown as notes, so their content is at least readable.
+func secretType(secret *models.Secret) *secrettypes.Type {
+	typ, err := secrettypes.Lookup(secret.Type)
+	if err != nil {
+		log.Printf("Secret %s: %v", secret.ID, err)
+		typ, _ = secrettypes.Lookup(secrettypes.Note)
+	}
+	return typ
+}
+
+// secretTypeName returns the name of the type of a secret for listings
+func secretTypeName(secret *models.Secret) string {
+	if secret.IsFile() {
+		return "File"
+	}
+	return secretType(secret).Name
+}
+
+// typedContent validates the field values of a secret and returns its content
+func typedContent(typ *secrettypes.Type, values secrettypes.Values) ([]byte, error) {
+	values, err := typ.Validate(values)
+	if err != nil {
+		return nil, err
+	}
+	return typ.Encode(values)
+}
+
+// formFieldValues reads the values of the fields of a type from a form. It
+// returns nil if none of them were filled in.
+func formFieldValues(r *http.Request, typ *secrettypes.Type) secrettypes.Values {
+	values := make(secrettypes.Values, len(typ.Fields))
+	filled := false
+	for _, field := range typ.Fields {
+		values[field.Name] = r.FormValue(field.Name)
+		filled = filled || strings.TrimSpace(values[field.Name]) != ""
+	}
+	if !filled {
+		return nil
+	}
+	return values
+}
+
+// secretTypeData returns a type with the values of its fields for the forms
+// and the recipient portal. Empty fields are left out unless withEmpty is set.
+func secretTypeData(typ *secrettypes.Type, values secrettypes.Values, withEmpty bool) map[string]interface{} {
+	fields := make([]map[string]interface{}, 0, len(typ.Fields))
+	for _, field := range typ.Fields {
+		value := values[field.Name]
+		if value == "" && !withEmpty {
+			continue
+		}
+
+		entry := map[string]interface{}{
+			"Name":     field.Name,
+			"Label":    field.Label,
+			"Input":    field.Input,
+			"Required": field.Required,
+			"Help":     field.Help,
+			"Value":    value,
+		}
+		// The words of a seed phrase are numbered, their order matters
+		if typ.ID == secrettypes.SeedPhrase && field.Name == "words" {
+			entry["Words"] = strings.Fields(value)
+		}
+		fields = append(fields, entry)
+	}
+
+	return map[string]interface{}{
+		"ID":          typ.ID,
+		"Name":        typ.Name,
+		"Description": typ.Description,
+		"Fields":      fields,
+	}
+}
+
+// secretTypeOptions lists the types for the choice on the new secret page
+func secretTypeOptions() []map[string]interface{} {
+	options := make([]map[string]interface{}, 0, len(secrettypes.All()))
+	for _, typ := range secrettypes.All() {
+		options = append(options, map[string]interface{}{
+			"ID":   typ.ID,
+			"Name": typ.Name,
+		})
+	}
+	return options
+}
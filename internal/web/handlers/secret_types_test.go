@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+const testSeedPhrase = "legal winner thank year wave sausage worth useful legal winner thank yellow"
+
+func TestTypedSecrets(t *testing.T) {
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user123", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient1", UserID: user.ID, Email: "recipient@example.com"})
+
+	vault := auth.NewVaultService(repo)
+	handler := NewSecretsHandler(repo, vault, delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil))
+	session, vaultKey := unlockTestVault(t, vault, user)
+
+	request := func(method, target, id string, form url.Values, handle http.HandlerFunc) *httptest.ResponseRecorder {
+		req := newFormRequest(method, target, form)
+		req.SetPathValue("id", id)
+		req = withSession(req, user, session)
+		rr := httptest.NewRecorder()
+		handle(rr, req)
+		return rr
+	}
+
+	// Each type has its own form
+	rr := request("GET", "/secrets/new?type=seed_phrase", "", nil, handler.HandleNewSecretForm)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `name="words"`) || strings.Contains(rr.Body.String(), "data-zk-form") {
+		t.Errorf("Expected the seed phrase form without zero-knowledge mode, got %d", rr.Code)
+	}
+	if rr := request("GET", "/secrets/new?type=crypto", "", nil, handler.HandleNewSecretForm); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown type, got %d", rr.Code)
+	}
+
+	// A typo in the seed phrase is caught before it is stored
+	form := url.Values{
+		"title":      {"Hardware wallet"},
+		"type":       {secrettypes.SeedPhrase},
+		"words":      {strings.Replace(testSeedPhrase, "thank year", "year thank", 1)},
+		"recipients": {"recipient1"},
+	}
+	rr = request("POST", "/secrets/new", "", form, handler.HandleCreateSecret)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "checksum") {
+		t.Errorf("Expected a checksum error, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(repo.Secrets) != 0 {
+		t.Fatalf("Expected no secret to be stored, got %d", len(repo.Secrets))
+	}
+
+	form.Set("words", strings.ToUpper(testSeedPhrase))
+	rr = request("POST", "/secrets/new", "", form, handler.HandleCreateSecret)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+	secret := repo.Secrets[0]
+	if secret.Type != secrettypes.SeedPhrase {
+		t.Errorf("Expected type %s, got %q", secrettypes.SeedPhrase, secret.Type)
+	}
+	plaintext, err := crypto.DecryptSecret(secret.EncryptedData, vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to decrypt secret: %v", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(plaintext, &values); err != nil || values["words"] != testSeedPhrase {
+		t.Errorf("Expected the normalized words to be stored, got %s", plaintext)
+	}
+
+	// The edit form shows the fields, saving them unchanged keeps no revision
+	rr = request("GET", "/secrets/"+secret.ID, secret.ID, nil, handler.HandleViewSecretForm)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), testSeedPhrase) {
+		t.Errorf("Expected the edit form with the words, got %d", rr.Code)
+	}
+	form.Set("words", testSeedPhrase)
+	if rr := request("POST", "/secrets/"+secret.ID, secret.ID, form, handler.HandleUpdateSecret); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if versions, _ := repo.ListSecretVersionsBySecretID(context.Background(), secret.ID); len(versions) != 0 {
+		t.Errorf("Expected no revision for unchanged fields, got %d", len(versions))
+	}
+
+	form.Set("words", "legal winner thank")
+	if rr := request("POST", "/secrets/"+secret.ID, secret.ID, form, handler.HandleUpdateSecret); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid update, got %d", rr.Code)
+	}
+
+	form.Set("passphrase", "correct horse")
+	form.Set("words", testSeedPhrase)
+	if rr := request("POST", "/secrets/"+secret.ID, secret.ID, form, handler.HandleUpdateSecret); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Revisions are compared field by field
+	versions, _ := repo.ListSecretVersionsBySecretID(context.Background(), secret.ID)
+	if len(versions) != 1 {
+		t.Fatalf("Expected 1 revision, got %d", len(versions))
+	}
+	rr = request("GET", "/secrets/"+secret.ID+"/versions/diff?from="+versions[0].ID+"&to=current", secret.ID, nil, handler.HandleSecretVersionDiff)
+	if !strings.Contains(rr.Body.String(), `class="diff-added">+ Passphrase: correct horse`) {
+		t.Errorf("Expected the diff to show the new field, got %s", rr.Body.String())
+	}
+}
+
+// TestHandleAccessTypedSecret tests that recipients see the fields of a typed secret
+func TestHandleAccessTypedSecret(t *testing.T) {
+	repo, handler, _ := setupAccessTest(t)
+
+	secret := repo.Secrets[0]
+	secret.Type = secrettypes.SeedPhrase
+	content, _ := json.Marshal(map[string]string{"wallet": "Ledger", "words": testSeedPhrase})
+	if err := handler.sealer.Reseal(context.Background(), secret, content); err != nil {
+		t.Fatalf("Failed to seal secret: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.HandleAccess(rr, newAccessRequest("POST", "recipient@example.com"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "<dt>Wallet</dt>") || !strings.Contains(body, "<li>legal</li><li>winner</li>") {
+		t.Errorf("Expected the wallet and the numbered words, got %s", body)
+	}
+	if strings.Contains(body, `"words"`) {
+		t.Error("Expected the fields instead of the stored JSON")
+	}
+}
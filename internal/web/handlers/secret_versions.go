@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/files"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+const (
+	// defaultSecretVersions is how many earlier revisions are kept per secret
+	// until SetSecretVersions is called
+	defaultSecretVersions = 10
+
+	// currentVersion names the current revision of a secret when comparing revisions
+	currentVersion = "current"
+
+	// maxDiffLines limits the revisions that are compared line by line, longer
+	// ones are shown as removed and added as a whole
+	maxDiffLines = 2000
+)
+
+// SetSecretVersions sets how many earlier revisions are kept per secret, 0 keeps none
+func (h *SecretsHandler) SetSecretVersions(keep int) {
+	h.keepVersions = keep
+}
+
+// SetSecretVersions sets how many earlier revisions are kept per secret, 0 keeps none
+func (h *APIV1Handler) SetSecretVersions(keep int) {
+	h.keepVersions = keep
+}
+
+// HandleSecretVersionDiff handles the page that compares two revisions of a secret
+func (h *SecretsHandler) HandleSecretVersionDiff(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, ok := h.ownSecret(w, r, user)
+	if !ok {
+		return
+	}
+
+	from, ok := h.findVersion(w, r.Context(), secret, r.URL.Query().Get("from"))
+	if !ok {
+		return
+	}
+	to, ok := h.findVersion(w, r.Context(), secret, r.URL.Query().Get("to"))
+	if !ok {
+		return
+	}
+
+	diffData := map[string]interface{}{
+		"From":            versionEntry(from),
+		"To":              versionEntry(to),
+		"NameChanged":     from.Name != to.Name,
+		"ClientEncrypted": from.IsClientEncrypted() || to.IsClientEncrypted(),
+	}
+
+	// Revisions encrypted in the browser can't be read here, only their names are compared
+	if !from.IsClientEncrypted() && !to.IsClientEncrypted() {
+		vaultKey, ok := requireVaultKey(w, r, h.vault)
+		if !ok {
+			return
+		}
+
+		typ := secretType(secret)
+		fromContent, err := versionContent(from, typ, vaultKey)
+		if err != nil {
+			http.Error(w, "Error decrypting revision", http.StatusInternalServerError)
+			log.Printf("Error decrypting revision %s of secret %s: %v", from.ID, secret.ID, err)
+			return
+		}
+		toContent, err := versionContent(to, typ, vaultKey)
+		if err != nil {
+			http.Error(w, "Error decrypting revision", http.StatusInternalServerError)
+			log.Printf("Error decrypting revision %s of secret %s: %v", to.ID, secret.ID, err)
+			return
+		}
+
+		lines := diffLines(fromContent, toContent)
+		diffData["Lines"] = lines
+		diffData["ContentChanged"] = fromContent != toContent
+	}
+
+	data := templates.TemplateData{
+		Title:           "Compare Revisions",
+		ActivePage:      "secrets",
+		IsAuthenticated: true,
+		User: map[string]interface{}{
+			"Email": user.Email,
+			"Name":  user.Email, // Use email as name since we don't have a separate name field
+		},
+		Data: map[string]interface{}{
+			"Secret": map[string]interface{}{
+				"ID":   secret.ID,
+				"Name": secret.Name,
+			},
+			"Diff": diffData,
+		},
+	}
+
+	if err := templates.RenderTemplate(w, "secret-version-diff.html", data); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		log.Printf("Error rendering secret-version-diff template: %v", err)
+	}
+}
+
+// HandleRestoreSecretVersion makes an earlier revision the current content of
+// a secret again. The content it replaces is kept as a revision in turn.
+func (h *SecretsHandler) HandleRestoreSecretVersion(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from context
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, ok := h.ownSecret(w, r, user)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	version, ok := h.findVersion(w, ctx, secret, r.PathValue("version"))
+	if !ok {
+		return
+	}
+	if version.ID == currentVersion {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	// Recipient copies are rebuilt from the restored content
+	resealNeeded := h.sealer.Enabled() || secret.IsQuorumProtected()
+
+	var vaultKey []byte
+	if !version.IsClientEncrypted() {
+		if vaultKey, ok = requireVaultKey(w, r, h.vault); !ok {
+			return
+		}
+	}
+
+	previous := newSecretVersion(secret)
+	secret.Name = version.Name
+	secret.EncryptedData = version.EncryptedData
+	secret.EncryptionType = version.EncryptionType
+	secret.FileRef = version.FileRef
+	secret.FileSize = version.FileSize
+	secret.UpdatedAt = time.Now().UTC()
+
+	if err := updateSecretKeepingVersion(ctx, h.repo, secret, previous, h.keepVersions); err != nil {
+		http.Error(w, "Error updating secret", http.StatusInternalServerError)
+		log.Printf("Error updating secret: %v", err)
+		return
+	}
+
+	// The restored revision is the current content now
+	if err := h.repo.DeleteSecretVersion(ctx, version.ID); err != nil {
+		log.Printf("Error deleting restored revision %s: %v", version.ID, err)
+	}
+	pruneSecretVersions(ctx, h.repo, h.files, secret.ID, h.keepVersions)
+	releaseSecretFile(ctx, h.repo, h.files, secret.ID, previous.FileRef)
+
+	if resealNeeded {
+		if err := h.sealer.ResealSecret(ctx, secret, vaultKey); err != nil {
+			http.Error(w, "Error sealing secret for recipients", http.StatusInternalServerError)
+			log.Printf("Error resealing secret %s: %v", secret.ID, err)
+			return
+		}
+	}
+
+	// Create an audit log entry
+	auditLog := &models.AuditLog{
+		UserID:    user.ID,
+		Action:    "restore_secret_version",
+		Timestamp: time.Now(),
+		Details:   fmt.Sprintf("Restored the revision of secret %s saved %s", secret.Name, version.SavedAt.Format("Jan 2, 2006 15:04")),
+	}
+
+	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	http.Redirect(w, r, "/secrets/"+secret.ID, http.StatusSeeOther)
+}
+
+// ownSecret fetches the secret in the URL and checks that it belongs to the
+// user. It writes an error response and returns false if not.
+func (h *SecretsHandler) ownSecret(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Secret, bool) {
+	secretID := r.PathValue("id")
+	if secretID == "" {
+		http.Error(w, "Secret ID is required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	secret, err := h.repo.GetSecretByID(r.Context(), secretID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Error fetching secret", http.StatusInternalServerError)
+		log.Printf("Error fetching secret: %v", err)
+		return nil, false
+	}
+
+	if secret.UserID != user.ID {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return secret, true
+}
+
+// findVersion fetches a revision of a secret, "current" or an empty ID stands
+// for its current content. It writes an error response and returns false if
+// the secret has no such revision.
+func (h *SecretsHandler) findVersion(w http.ResponseWriter, ctx context.Context, secret *models.Secret, id string) (*models.SecretVersion, bool) {
+	if id == "" || id == currentVersion {
+		version := newSecretVersion(secret)
+		version.ID = currentVersion
+		return version, true
+	}
+
+	version, err := h.repo.GetSecretVersionByID(ctx, id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "Error fetching revision", http.StatusInternalServerError)
+		log.Printf("Error fetching revision: %v", err)
+		return nil, false
+	}
+
+	if version == nil || version.SecretID != secret.ID {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return version, true
+}
+
+// listVersions returns the earlier revisions of a secret for the secret page
+func (h *SecretsHandler) listVersions(ctx context.Context, secret *models.Secret) []map[string]interface{} {
+	versions, err := h.repo.ListSecretVersionsBySecretID(ctx, secret.ID)
+	if err != nil {
+		log.Printf("Error fetching revisions of secret %s: %v", secret.ID, err)
+	}
+
+	entries := make([]map[string]interface{}, 0, len(versions))
+	for _, version := range versions {
+		entries = append(entries, versionEntry(version))
+	}
+	return entries
+}
+
+// versionEntry converts a revision to a template-friendly format
+func versionEntry(version *models.SecretVersion) map[string]interface{} {
+	return map[string]interface{}{
+		"ID":        version.ID,
+		"Name":      version.Name,
+		"IsCurrent": version.ID == currentVersion,
+		"IsFile":    version.IsFile(),
+		"FileSize":  version.FileSize,
+		"SavedAt":   version.SavedAt,
+		"CreatedAt": version.CreatedAt,
+	}
+}
+
+// versionContent decrypts a revision for comparison. A file secret is
+// described by its file, the key of the file is left out, and the fields of
+// a typed secret are compared one per line.
+func versionContent(version *models.SecretVersion, typ *secrettypes.Type, vaultKey []byte) (string, error) {
+	plaintext, err := crypto.DecryptSecret(version.EncryptedData, vaultKey)
+	if err != nil {
+		return "", err
+	}
+
+	if version.IsFile() {
+		file, err := files.Parse(plaintext)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("File: %s\nType: %s\nSize: %d bytes", file.Name, file.Type, file.Size), nil
+	}
+
+	values, err := typ.Decode(plaintext)
+	if err != nil {
+		return "", err
+	}
+	return typ.Format(values), nil
+}
+
+// newSecretVersion returns the revision of a secret as it is stored now, to
+// be kept once the secret changes
+func newSecretVersion(secret *models.Secret) *models.SecretVersion {
+	return &models.SecretVersion{
+		SecretID:       secret.ID,
+		UserID:         secret.UserID,
+		Name:           secret.Name,
+		EncryptedData:  secret.EncryptedData,
+		EncryptionType: secret.EncryptionType,
+		FileRef:        secret.FileRef,
+		FileSize:       secret.FileSize,
+		SavedAt:        secret.UpdatedAt,
+	}
+}
+
+// versionChanged reports whether the name or the content of a secret differ
+// from a revision. Changes to recipients or the quorum don't make a new revision.
+func versionChanged(version *models.SecretVersion, secret *models.Secret) bool {
+	return version.Name != secret.Name || version.EncryptedData != secret.EncryptedData
+}
+
+// updateSecretKeepingVersion updates a secret and stores its earlier revision
+// in one transaction, so a failed update doesn't leave a revision behind.
+// No revision is stored if version is nil or no revisions are kept.
+func updateSecretKeepingVersion(ctx context.Context, repo storage.Repository, secret *models.Secret, version *models.SecretVersion, keep int) error {
+	tx, err := repo.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if version != nil && keep > 0 {
+		if err := tx.CreateSecretVersion(ctx, version); err != nil {
+			return fmt.Errorf("failed to keep revision: %w", err)
+		}
+	}
+
+	if err := tx.UpdateSecret(ctx, secret); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// pruneSecretVersions deletes the revisions of a secret beyond the newest
+// keep, together with the files only they used
+func pruneSecretVersions(ctx context.Context, repo storage.Repository, store *files.Store, secretID string, keep int) {
+	versions, err := repo.ListSecretVersionsBySecretID(ctx, secretID)
+	if err != nil {
+		log.Printf("Error fetching revisions of secret %s: %v", secretID, err)
+		return
+	}
+
+	if keep < 0 {
+		keep = 0
+	}
+	if len(versions) <= keep {
+		return
+	}
+
+	for _, version := range versions[keep:] {
+		if err := repo.DeleteSecretVersion(ctx, version.ID); err != nil {
+			log.Printf("Error deleting revision %s: %v", version.ID, err)
+			continue
+		}
+		releaseSecretFile(ctx, repo, store, secretID, version.FileRef)
+	}
+}
+
+// releaseSecretFile deletes a stored file once neither the secret nor one of
+// its revisions uses it anymore. When in doubt the file is kept.
+func releaseSecretFile(ctx context.Context, repo storage.Repository, store *files.Store, secretID, ref string) {
+	if ref == "" || store == nil {
+		return
+	}
+
+	secret, err := repo.GetSecretByID(ctx, secretID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Error fetching secret %s: %v", secretID, err)
+		return
+	}
+	if secret != nil && secret.FileRef == ref {
+		return
+	}
+
+	versions, err := repo.ListSecretVersionsBySecretID(ctx, secretID)
+	if err != nil {
+		log.Printf("Error fetching revisions of secret %s: %v", secretID, err)
+		return
+	}
+	for _, version := range versions {
+		if version.FileRef == ref {
+			return
+		}
+	}
+
+	if err := store.Delete(ref); err != nil {
+		log.Printf("Error deleting file %s: %v", ref, err)
+	}
+}
+
+// secretFileRefs returns the files used by a secret and its revisions. They
+// are collected before the secret is deleted, because its revisions go with it.
+func secretFileRefs(ctx context.Context, repo storage.Repository, secret *models.Secret) []string {
+	var refs []string
+	if secret.IsFile() {
+		refs = append(refs, secret.FileRef)
+	}
+
+	versions, err := repo.ListSecretVersionsBySecretID(ctx, secret.ID)
+	if err != nil {
+		log.Printf("Error fetching revisions of secret %s: %v", secret.ID, err)
+	}
+	for _, version := range versions {
+		if version.IsFile() {
+			refs = append(refs, version.FileRef)
+		}
+	}
+
+	return uniqueStrings(refs)
+}
+
+// deleteFiles removes stored files that are no longer referenced
+func deleteFiles(store *files.Store, refs []string) {
+	if store == nil {
+		return
+	}
+	for _, ref := range refs {
+		if err := store.Delete(ref); err != nil {
+			log.Printf("Error deleting file %s: %v", ref, err)
+		}
+	}
+}
+
+// diffLine is a line of the comparison of two revisions
+type diffLine struct {
+	Kind string // "same", "added" or "removed"
+	Text string
+}
+
+// diffLines compares two texts line by line and returns the lines of both,
+// marking the ones that were removed from and added to the first
+func diffLines(from, to string) []diffLine {
+	a := splitLines(from)
+	b := splitLines(to)
+
+	// Revisions too long for a line by line comparison are replaced as a whole
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		lines := make([]diffLine, 0, len(a)+len(b))
+		for _, line := range a {
+			lines = append(lines, diffLine{Kind: "removed", Text: line})
+		}
+		for _, line := range b {
+			lines = append(lines, diffLine{Kind: "added", Text: line})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{Kind: "same", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{Kind: "removed", Text: a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{Kind: "added", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{Kind: "removed", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{Kind: "added", Text: b[j]})
+	}
+
+	return lines
+}
+
+// splitLines splits a text into lines, an empty text has none
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+func TestSecretVersions(t *testing.T) {
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+	user := &models.User{ID: "user123", Email: "test@example.com"}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient1", UserID: user.ID, Email: "recipient@example.com"})
+
+	vault := auth.NewVaultService(repo)
+	handler := NewSecretsHandler(repo, vault, delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil))
+	handler.SetSecretVersions(2)
+	session, vaultKey := unlockTestVault(t, vault, user)
+
+	encrypted, _ := crypto.EncryptSecret([]byte("first\nshared"), vaultKey)
+	secret := &models.Secret{ID: "secret1", UserID: user.ID, Name: "Seed phrase", EncryptedData: encrypted, EncryptionType: models.EncryptionTypeVault}
+	repo.Secrets = append(repo.Secrets, secret)
+
+	request := func(method, target string, form url.Values, version string) *httptest.ResponseRecorder {
+		req := newFormRequest(method, target, form)
+		req.SetPathValue("id", secret.ID)
+		req.SetPathValue("version", version)
+		req = withSession(req, user, session)
+		rr := httptest.NewRecorder()
+
+		switch {
+		case strings.HasSuffix(target, "/restore"):
+			handler.HandleRestoreSecretVersion(rr, req)
+		case strings.Contains(target, "/versions/diff"):
+			handler.HandleSecretVersionDiff(rr, req)
+		default:
+			handler.HandleUpdateSecret(rr, req)
+		}
+		return rr
+	}
+
+	content := func() string {
+		plaintext, err := crypto.DecryptSecret(secret.EncryptedData, vaultKey)
+		if err != nil {
+			t.Fatalf("Failed to decrypt secret: %v", err)
+		}
+		return string(plaintext)
+	}
+
+	// Every change of the content keeps the previous revision, the oldest beyond two are pruned
+	for _, text := range []string{"second\nshared", "third\nshared", "fourth\nshared"} {
+		rr := request("POST", "/secrets/secret1", url.Values{"title": {"Seed phrase"}, "content": {text}, "recipients": {"recipient1"}}, "")
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	versions, _ := repo.ListSecretVersionsBySecretID(context.Background(), secret.ID)
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 revisions, got %d", len(versions))
+	}
+	if plaintext, _ := crypto.DecryptSecret(versions[0].EncryptedData, vaultKey); string(plaintext) != "third\nshared" {
+		t.Errorf("Expected the newest revision first, got %q", plaintext)
+	}
+
+	// Saving without a change doesn't make a revision
+	rr := request("POST", "/secrets/secret1", url.Values{"title": {"Seed phrase"}, "recipients": {"recipient1"}}, "")
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d", rr.Code)
+	}
+	if versions, _ := repo.ListSecretVersionsBySecretID(context.Background(), secret.ID); len(versions) != 2 {
+		t.Errorf("Expected no new revision without a change, got %d revisions", len(versions))
+	}
+
+	// Compare the oldest revision with the current content
+	oldest := versions[1]
+	rr = request("GET", "/secrets/secret1/versions/diff?from="+oldest.ID+"&to=current", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `class="diff-removed">- second`) || !strings.Contains(body, `class="diff-added">`) || !strings.Contains(body, "fourth") {
+		t.Errorf("Expected the diff to show the changed line, got %s", body)
+	}
+
+	// Revisions of other secrets can't be used
+	repo.SecretVersions = append(repo.SecretVersions, &models.SecretVersion{ID: "other", SecretID: "secret2", UserID: user.ID})
+	if rr := request("GET", "/secrets/secret1/versions/diff?from=other", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a revision of another secret, got %d", rr.Code)
+	}
+	if rr := request("POST", "/secrets/secret1/versions/other/restore", nil, "other"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 when restoring a revision of another secret, got %d", rr.Code)
+	}
+
+	// Restoring brings the revision back and keeps the content it replaces
+	rr = request("POST", "/secrets/secret1/versions/"+oldest.ID+"/restore", nil, oldest.ID)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := content(); got != "second\nshared" {
+		t.Errorf("Expected the restored content, got %q", got)
+	}
+	versions, _ = repo.ListSecretVersionsBySecretID(context.Background(), secret.ID)
+	if len(versions) != 2 || versions[0].ID == oldest.ID || versions[1].ID == oldest.ID {
+		t.Fatalf("Expected the restored revision to leave the history, got %d revisions", len(versions))
+	}
+	if plaintext, _ := crypto.DecryptSecret(versions[0].EncryptedData, vaultKey); string(plaintext) != "fourth\nshared" {
+		t.Errorf("Expected the replaced content to be kept, got %q", plaintext)
+	}
+
+	// The recipient copy holds the restored content
+	sealer := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil)
+	if plaintext, err := sealer.Open(repo.SecretAssignments[0].DeliveryData); err != nil || string(plaintext) != "second\nshared" {
+		t.Errorf("Expected the recipient copy to be resealed, got %q, %v", plaintext, err)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	lines := diffLines("a\nb\nc\n", "a\nx\nc\nd")
+
+	var got []string
+	for _, line := range lines {
+		got = append(got, line.Kind+" "+line.Text)
+	}
+	want := []string{"same a", "removed b", "added x", "same c", "added d"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if lines := diffLines("", ""); len(lines) != 0 {
+		t.Errorf("Expected no lines for empty texts, got %v", lines)
+	}
+}
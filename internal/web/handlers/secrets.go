@@ -6,8 +6,12 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/korjavin/deadmanswitch/internal/auth"
 	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/files"
 	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/secrettypes"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 	"github.com/korjavin/deadmanswitch/internal/web/middleware"
 	"github.com/korjavin/deadmanswitch/internal/web/templates"
@@ -15,13 +19,26 @@ import (
 
 // SecretsHandler handles secrets-related requests
 type SecretsHandler struct {
-	repo storage.Repository
+	repo   storage.Repository
+	vault  *auth.VaultService
+	sealer *delivery.Sealer
+
+	// File secrets are only available with a file store
+	files       *files.Store
+	maxFileSize int64
+	fileQuota   int64
+
+	// Number of earlier revisions kept per secret
+	keepVersions int
 }
 
 // NewSecretsHandler creates a new SecretsHandler
-func NewSecretsHandler(repo storage.Repository) *SecretsHandler {
+func NewSecretsHandler(repo storage.Repository, vault *auth.VaultService, sealer *delivery.Sealer) *SecretsHandler {
 	return &SecretsHandler{
-		repo: repo,
+		repo:         repo,
+		vault:        vault,
+		sealer:       sealer,
+		keepVersions: defaultSecretVersions,
 	}
 }
 
@@ -73,13 +90,17 @@ func (h *SecretsHandler) HandleListSecrets(w http.ResponseWriter, r *http.Reques
 		secretEntry := map[string]interface{}{
 			"ID":             s.ID,
 			"Title":          s.Name,
-			"Type":           "encrypted",
+			"Type":           secretTypeName(s),
 			"Description":    "Encrypted secret",
 			"Content":        "This content is encrypted", // Dummy content for the template
 			"CreatedAt":      s.CreatedAt,
 			"UpdatedAt":      s.UpdatedAt,
 			"EncryptionType": s.EncryptionType,
 			"Recipients":     recipients,
+			"Quorum":         s.QuorumThreshold,
+			"ZeroKnowledge":  s.IsClientEncrypted(),
+			"File":           s.IsFile(),
+			"FileSize":       s.FileSize,
 		}
 
 		secrets = append(secrets, secretEntry)
@@ -94,7 +115,8 @@ func (h *SecretsHandler) HandleListSecrets(w http.ResponseWriter, r *http.Reques
 			"Name":  user.Email, // Use email as name since we don't have a separate name field
 		},
 		Data: map[string]interface{}{
-			"Secrets": secrets,
+			"Secrets":        secrets,
+			"FilesAvailable": h.files != nil,
 		},
 	}
 
@@ -113,6 +135,18 @@ func (h *SecretsHandler) HandleNewSecretForm(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Each type of secret has its own form, a note by default
+	typ, err := secrettypes.Lookup(r.URL.Query().Get("type"))
+	if err != nil {
+		http.Error(w, "Unknown secret type", http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the vault is unlocked before the user starts typing
+	if _, ok := requireVaultKey(w, r, h.vault); !ok {
+		return
+	}
+
 	// Fetch the user's recipients from the database
 	dbRecipients, err := h.repo.ListRecipientsByUserID(context.Background(), user.ID)
 	if err != nil {
@@ -141,7 +175,10 @@ func (h *SecretsHandler) HandleNewSecretForm(w http.ResponseWriter, r *http.Requ
 			"Name":  user.Email, // Use email as name since we don't have a separate name field
 		},
 		Data: map[string]interface{}{
-			"Recipients": recipients,
+			"Recipients":      recipients,
+			"QuorumAvailable": h.sealer.Enabled(),
+			"Types":           secretTypeOptions(),
+			"SecretType":      secretTypeData(typ, nil, true),
 		},
 	}
 
@@ -281,6 +318,25 @@ func (h *SecretsHandler) HandleUpdateSecretRecipients(w http.ResponseWriter, r *
 	// Get the selected recipient IDs
 	selectedRecipientIDs := r.Form["recipients"]
 
+	if secret.IsQuorumProtected() {
+		if err := delivery.ValidateQuorum(secret.QuorumThreshold, len(selectedRecipientIDs)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Recipient copies are resealed for the new recipients,
+	// which needs the owner's copy of the content
+	resealNeeded := h.sealer.Enabled() || secret.IsQuorumProtected()
+
+	var masterKey []byte
+	if resealNeeded && !secret.IsClientEncrypted() {
+		masterKey, ok = requireVaultKey(w, r, h.vault)
+		if !ok {
+			return
+		}
+	}
+
 	// Fetch all current assignments for the secret
 	currentAssignments, err := h.repo.ListSecretAssignmentsBySecretID(context.Background(), secretID)
 	if err != nil {
@@ -328,6 +384,14 @@ func (h *SecretsHandler) HandleUpdateSecretRecipients(w http.ResponseWriter, r *
 		}
 	}
 
+	if resealNeeded {
+		if err := h.sealer.ResealSecret(context.Background(), secret, masterKey); err != nil {
+			http.Error(w, "Error sealing secret for recipients", http.StatusInternalServerError)
+			log.Printf("Error resealing secret %s: %v", secret.ID, err)
+			return
+		}
+	}
+
 	// Create an audit log entry
 	auditLog := &models.AuditLog{
 		UserID:    user.ID,
@@ -389,6 +453,9 @@ func (h *SecretsHandler) HandleDeleteSecret(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	// The files of the secret and its revisions are deleted once the secret is gone
+	fileRefs := secretFileRefs(context.Background(), h.repo, secret)
+
 	// Delete the secret
 	if err := h.repo.DeleteSecret(context.Background(), secretID); err != nil {
 		http.Error(w, "Error deleting secret", http.StatusInternalServerError)
@@ -396,6 +463,8 @@ func (h *SecretsHandler) HandleDeleteSecret(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	deleteFiles(h.files, fileRefs)
+
 	// Create an audit log entry
 	auditLog := &models.AuditLog{
 		UserID:    user.ID,
@@ -478,40 +547,77 @@ func (h *SecretsHandler) HandleViewSecretForm(w http.ResponseWriter, r *http.Req
 		recipients = append(recipients, recipientEntry)
 	}
 
-	// Decrypt the secret content for editing
-	// In a real implementation, we would get the master key from the user's session
-	// For now, we'll use a dummy master key for demonstration
-	masterKey := []byte("this-is-a-dummy-master-key-for-demo-only")
-
 	// Log the secret details for debugging
 	log.Printf("Secret details - ID: %s, Name: %s, EncryptionType: %s, EncryptedData length: %d",
 		secret.ID, secret.Name, secret.EncryptionType, len(secret.EncryptedData))
 
-	// Decrypt the secret content
+	// Decrypt the secret content, secrets encrypted in the browser are decrypted there too
 	decryptedContent := ""
-	log.Printf("Attempting to decrypt secret %s with encrypted data length: %d", secret.ID, len(secret.EncryptedData))
-	if secret.EncryptedData != "" {
-		decryptedBytes, err := crypto.DecryptSecret(secret.EncryptedData, masterKey)
-		if err != nil {
-			log.Printf("Error decrypting secret %s: %v", secret.ID, err)
-			// If decryption fails, we'll show a placeholder
-			decryptedContent = "[Unable to decrypt content. The encryption key may have changed.]"
+	var fileDetails, typeDetails map[string]interface{}
+	typ := secretType(secret)
+	if secret.IsClientEncrypted() {
+		log.Printf("Secret %s is encrypted in the browser", secret.ID)
+	} else {
+		// Get the vault key from the user's session to decrypt the content for editing
+		masterKey, ok := requireVaultKey(w, r, h.vault)
+		if !ok {
+			return
+		}
+
+		log.Printf("Attempting to decrypt secret %s with encrypted data length: %d", secret.ID, len(secret.EncryptedData))
+		if secret.EncryptedData != "" {
+			decryptedBytes, err := crypto.DecryptSecret(secret.EncryptedData, masterKey)
+			if err != nil {
+				log.Printf("Error decrypting secret %s: %v", secret.ID, err)
+				// If decryption fails, we'll show a placeholder
+				decryptedContent = "[Unable to decrypt content. The encryption key may have changed.]"
+			} else {
+				decryptedContent = string(decryptedBytes)
+				log.Printf("Successfully decrypted secret %s, content length: %d", secret.ID, len(decryptedContent))
+
+				// The content of a file secret holds the key of the file, only its details are shown
+				if secret.IsFile() {
+					decryptedContent = ""
+					if file, err := files.Parse(decryptedBytes); err != nil {
+						log.Printf("Error reading file secret %s: %v", secret.ID, err)
+					} else {
+						fileDetails = map[string]interface{}{"Name": file.Name, "Type": file.Type, "Size": file.Size}
+					}
+				} else if !typ.IsNote() {
+					// Typed secrets are edited field by field
+					if values, err := typ.Decode(decryptedBytes); err != nil {
+						log.Printf("Error reading secret %s: %v", secret.ID, err)
+					} else {
+						decryptedContent = ""
+						typeDetails = secretTypeData(typ, values, true)
+					}
+				}
+
+				if err := h.vault.UpgradeSecret(r.Context(), secret, decryptedBytes, masterKey); err != nil {
+					log.Printf("Error upgrading secret %s: %v", secret.ID, err)
+				}
+			}
 		} else {
-			decryptedContent = string(decryptedBytes)
-			log.Printf("Successfully decrypted secret %s, content length: %d", secret.ID, len(decryptedContent))
+			log.Printf("Secret %s has no encrypted data", secret.ID)
 		}
-	} else {
-		log.Printf("Secret %s has no encrypted data", secret.ID)
 	}
 
 	secretData := map[string]interface{}{
-		"ID":             secret.ID,
-		"Name":           secret.Name,
-		"Type":           "note", // Default type
-		"Content":        decryptedContent,
-		"CreatedAt":      secret.CreatedAt,
-		"LastModified":   secret.UpdatedAt,
-		"EncryptionType": secret.EncryptionType,
+		"ID":              secret.ID,
+		"Name":            secret.Name,
+		"Type":            typ.ID,
+		"TypeDetails":     typeDetails,
+		"Content":         decryptedContent,
+		"CreatedAt":       secret.CreatedAt,
+		"LastModified":    secret.UpdatedAt,
+		"EncryptionType":  secret.EncryptionType,
+		"Quorum":          secret.QuorumThreshold,
+		"ClientEncrypted": secret.IsClientEncrypted(),
+		"IsFile":          secret.IsFile(),
+		"File":            fileDetails,
+	}
+	if secret.IsClientEncrypted() {
+		secretData["Envelope"] = secret.EncryptedData
 	}
 
 	data := templates.TemplateData{
@@ -523,8 +629,11 @@ func (h *SecretsHandler) HandleViewSecretForm(w http.ResponseWriter, r *http.Req
 			"Name":  user.Email, // Use email as name since we don't have a separate name field
 		},
 		Data: map[string]interface{}{
-			"Secret":     secretData,
-			"Recipients": recipients,
+			"Secret":          secretData,
+			"Recipients":      recipients,
+			"QuorumAvailable": h.sealer.Enabled(),
+			"Versions":        h.listVersions(r.Context(), secret),
+			"KeepVersions":    h.keepVersions,
 		},
 	}
 
@@ -579,12 +688,77 @@ func (h *SecretsHandler) HandleUpdateSecret(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// In a real implementation, we would get the master key from the user's session
-	// For now, we'll use a dummy master key for demonstration
-	masterKey := []byte("this-is-a-dummy-master-key-for-demo-only")
+	if content != "" && secret.IsFile() {
+		http.Error(w, "Upload a new file to change the content of a file secret", http.StatusBadRequest)
+		return
+	}
+
+	// Typed secrets are edited field by field and checked like new ones
+	if typ := secretType(secret); !typ.IsNote() && !secret.IsFile() && !secret.IsClientEncrypted() {
+		if values := formFieldValues(r, typ); values != nil {
+			typed, err := typedContent(typ, values)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			content = string(typed)
+		}
+	}
+
+	// The revision as it is now is kept if the name or the content change
+	previous := newSecretVersion(secret)
+
+	// Process recipient assignments
+	recipientIDs := r.Form["recipients"]
+
+	quorumThreshold, err := parseQuorumThreshold(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if quorumThreshold > 0 {
+		if !h.sealer.Enabled() {
+			http.Error(w, "Quorum protection requires a server master key", http.StatusBadRequest)
+			return
+		}
+
+		if err := delivery.ValidateQuorum(quorumThreshold, len(recipientIDs)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Recipient copies are rebuilt whenever the secret changes
+	resealNeeded := h.sealer.Enabled() || secret.IsQuorumProtected()
+
+	var masterKey []byte
+	if secret.IsClientEncrypted() {
+		// The browser encrypted the new content, the vault key isn't needed
+		if content != "" {
+			if _, err := crypto.ParseClientEnvelope(content); err != nil {
+				http.Error(w, "Content must be encrypted in the browser in zero-knowledge mode", http.StatusBadRequest)
+				return
+			}
+			secret.EncryptedData = content
+		}
+	} else if content != "" || resealNeeded {
+		// Get the vault key from the user's session
+		masterKey, ok = requireVaultKey(w, r, h.vault)
+		if !ok {
+			return
+		}
+	}
+
+	// Content saved as it was isn't encrypted again, so it doesn't make a revision
+	if content != "" && !secret.IsClientEncrypted() {
+		if current, err := crypto.DecryptSecret(secret.EncryptedData, masterKey); err == nil && string(current) == content {
+			content = ""
+		}
+	}
 
 	// Only re-encrypt if content was provided
-	if content != "" {
+	if content != "" && !secret.IsClientEncrypted() {
 		// Encrypt the secret content
 		encryptedData, err := crypto.EncryptSecret([]byte(content), masterKey)
 		if err != nil {
@@ -593,20 +767,28 @@ func (h *SecretsHandler) HandleUpdateSecret(w http.ResponseWriter, r *http.Reque
 			return
 		}
 		secret.EncryptedData = encryptedData
+		secret.EncryptionType = models.EncryptionTypeVault
 	}
 
 	// Update the secret in the database
 	secret.Name = title
+	secret.QuorumThreshold = quorumThreshold
 	secret.UpdatedAt = time.Now().UTC()
 
-	if err := h.repo.UpdateSecret(context.Background(), secret); err != nil {
+	versionKept := versionChanged(previous, secret)
+	if !versionKept {
+		previous = nil
+	}
+
+	if err := updateSecretKeepingVersion(context.Background(), h.repo, secret, previous, h.keepVersions); err != nil {
 		http.Error(w, "Error updating secret", http.StatusInternalServerError)
 		log.Printf("Error updating secret: %v", err)
 		return
 	}
 
-	// Process recipient assignments
-	recipientIDs := r.Form["recipients"]
+	if versionKept {
+		pruneSecretVersions(context.Background(), h.repo, h.files, secret.ID, h.keepVersions)
+	}
 
 	// Fetch all current assignments for the secret
 	currentAssignments, err := h.repo.ListSecretAssignmentsBySecretID(context.Background(), secretID)
@@ -655,6 +837,14 @@ func (h *SecretsHandler) HandleUpdateSecret(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	if resealNeeded {
+		if err := h.sealer.ResealSecret(context.Background(), secret, masterKey); err != nil {
+			http.Error(w, "Error sealing secret for recipients", http.StatusInternalServerError)
+			log.Printf("Error resealing secret %s: %v", secret.ID, err)
+			return
+		}
+	}
+
 	// Create an audit log entry
 	auditLog := &models.AuditLog{
 		UserID:    user.ID,
@@ -696,44 +886,111 @@ func (h *SecretsHandler) HandleCreateSecret(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	typ, err := secrettypes.Lookup(r.FormValue("type"))
+	if err != nil {
+		http.Error(w, "Unknown secret type", http.StatusBadRequest)
+		return
+	}
+
+	// The fields of a typed secret are checked here, notes are stored as they are
+	if !typ.IsNote() {
+		if r.FormValue("encryption") == "client" {
+			http.Error(w, "Only notes can be encrypted in the browser", http.StatusBadRequest)
+			return
+		}
+
+		typed, err := typedContent(typ, formFieldValues(r, typ))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content = string(typed)
+	}
+
 	if content == "" {
 		http.Error(w, "Content is required", http.StatusBadRequest)
 		return
 	}
 
-	// In a real implementation, we would get the master key from the user's session
-	// For now, we'll use a dummy master key for demonstration
-	masterKey := []byte("this-is-a-dummy-master-key-for-demo-only")
+	// Process recipient assignments if any were selected
+	recipientIDs := r.Form["recipients"]
+	log.Printf("Selected recipient IDs: %v", recipientIDs)
 
-	// Encrypt the secret content
-	encryptedData, err := crypto.EncryptSecret([]byte(content), masterKey)
+	quorumThreshold, err := parseQuorumThreshold(r)
 	if err != nil {
-		http.Error(w, "Error encrypting secret", http.StatusInternalServerError)
-		log.Printf("Error encrypting secret: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Successfully encrypted content of length %d, resulting in encrypted data of length %d",
-		len(content), len(encryptedData))
+	if quorumThreshold > 0 {
+		if !h.sealer.Enabled() {
+			http.Error(w, "Quorum protection requires a server master key", http.StatusBadRequest)
+			return
+		}
+
+		if err := delivery.ValidateQuorum(quorumThreshold, len(recipientIDs)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var encryptedData, encryptionType string
+	if r.FormValue("encryption") == "client" {
+		// Zero-knowledge mode, the content was encrypted in the browser
+		if _, err := crypto.ParseClientEnvelope(content); err != nil {
+			http.Error(w, "Content must be encrypted in the browser in zero-knowledge mode", http.StatusBadRequest)
+			return
+		}
+		encryptedData = content
+		encryptionType = models.EncryptionTypeClient
+	} else {
+		// Get the vault key from the user's session
+		masterKey, ok := requireVaultKey(w, r, h.vault)
+		if !ok {
+			return
+		}
+
+		// Encrypt the secret content
+		encryptedData, err = crypto.EncryptSecret([]byte(content), masterKey)
+		if err != nil {
+			http.Error(w, "Error encrypting secret", http.StatusInternalServerError)
+			log.Printf("Error encrypting secret: %v", err)
+			return
+		}
+		encryptionType = models.EncryptionTypeVault
+
+		log.Printf("Successfully encrypted content of length %d, resulting in encrypted data of length %d",
+			len(content), len(encryptedData))
+	}
 
 	// Create the secret in the database
 	secret := &models.Secret{
-		UserID:         user.ID,
-		Name:           title,
-		EncryptedData:  encryptedData,
-		EncryptionType: "aes-256-gcm",
+		UserID:          user.ID,
+		Name:            title,
+		EncryptedData:   encryptedData,
+		EncryptionType:  encryptionType,
+		QuorumThreshold: quorumThreshold,
+		Type:            typ.ID,
+	}
+
+	if !h.createSecret(w, user, secret, []byte(content), recipientIDs) {
+		return
 	}
 
+	// Redirect to the secrets list page
+	http.Redirect(w, r, "/secrets", http.StatusSeeOther)
+}
+
+// createSecret saves a new secret, assigns it to the recipients and seals
+// their copies of the content. It writes an error response and returns false
+// if the secret can't be created.
+func (h *SecretsHandler) createSecret(w http.ResponseWriter, user *models.User, secret *models.Secret, content []byte, recipientIDs []string) bool {
 	if err := h.repo.CreateSecret(context.Background(), secret); err != nil {
 		http.Error(w, "Error creating secret", http.StatusInternalServerError)
 		log.Printf("Error creating secret: %v", err)
-		return
+		return false
 	}
 
-	// Process recipient assignments if any were selected
-	recipientIDs := r.Form["recipients"]
-	log.Printf("Selected recipient IDs: %v", recipientIDs)
-
 	if len(recipientIDs) == 0 {
 		log.Printf("No recipients selected for secret %s", secret.ID)
 	}
@@ -755,6 +1012,15 @@ func (h *SecretsHandler) HandleCreateSecret(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	// Seal the recipient copies now that the recipients are assigned
+	if h.sealer.Enabled() {
+		if err := h.sealer.Reseal(context.Background(), secret, content); err != nil {
+			http.Error(w, "Error sealing secret for recipients", http.StatusInternalServerError)
+			log.Printf("Error sealing secret %s: %v", secret.ID, err)
+			return false
+		}
+	}
+
 	// Create an audit log entry
 	auditLog := &models.AuditLog{
 		UserID:    user.ID,
@@ -768,6 +1034,5 @@ func (h *SecretsHandler) HandleCreateSecret(w http.ResponseWriter, r *http.Reque
 		// Continue anyway, don't fail the whole request
 	}
 
-	// Redirect to the secrets list page
-	http.Redirect(w, r, "/secrets", http.StatusSeeOther)
+	return true
 }
@@ -9,12 +9,42 @@ import (
 	"testing"
 	"time"
 
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/crypto"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 
 	"github.com/korjavin/deadmanswitch/internal/web/middleware"
 )
 
+// unlockTestVault unlocks the vault of the user with the password "password" for a new
+// session and returns the session and its vault key
+func unlockTestVault(t *testing.T, vault *auth.VaultService, user *models.User) (*models.Session, []byte) {
+	t.Helper()
+
+	session := &models.Session{ID: "session-" + user.ID, UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := vault.Unlock(context.Background(), user, "password", session); err != nil {
+		t.Fatalf("Failed to unlock vault: %v", err)
+	}
+	vaultKey, _ := vault.Key(session.ID)
+	return session, vaultKey
+}
+
+// withSession adds the signed in user and their session to the context of a request
+func withSession(req *http.Request, user *models.User, session *models.Session) *http.Request {
+	ctx := context.WithValue(req.Context(), middleware.UserContextKey, user)
+	ctx = context.WithValue(ctx, middleware.SessionContextKey, session)
+	return req.WithContext(ctx)
+}
+
+// newFormRequest creates a request posting a form
+func newFormRequest(method, target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
 // TestHandleListSecrets tests the list secrets handler
 func TestHandleListSecrets(t *testing.T) {
 	// Create mock repository
@@ -49,7 +79,7 @@ func TestHandleListSecrets(t *testing.T) {
 	repo.Secrets = append(repo.Secrets, secret1, secret2)
 
 	// Create the handler
-	handler := NewSecretsHandler(repo)
+	handler := NewSecretsHandler(repo, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
 
 	// Create a test request
 	req := httptest.NewRequest("GET", "/secrets", nil)
@@ -76,7 +106,7 @@ func TestHandleListSecretsUnauthorized(t *testing.T) {
 	repo := storage.NewMockRepository()
 
 	// Create the handler
-	handler := NewSecretsHandler(repo)
+	handler := NewSecretsHandler(repo, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
 
 	// Create a test request with no user in context
 	req := httptest.NewRequest("GET", "/secrets", nil)
@@ -112,8 +142,10 @@ func TestHandleCreateSecret(t *testing.T) {
 	}
 	repo.Recipients = append(repo.Recipients, recipient)
 
-	// Create the handler
-	handler := NewSecretsHandler(repo)
+	// Create the handler with an unlocked vault
+	vault := auth.NewVaultService(repo)
+	handler := NewSecretsHandler(repo, vault, delivery.NewSealer(repo, nil, nil))
+	session, _ := unlockTestVault(t, vault, user)
 
 	// Create form data
 	form := url.Values{}
@@ -125,9 +157,8 @@ func TestHandleCreateSecret(t *testing.T) {
 	req := httptest.NewRequest("POST", "/secrets/new", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// Create a context with the authenticated user
-	ctx := context.WithValue(req.Context(), middleware.UserContextKey, user)
-	req = req.WithContext(ctx)
+	// Create a context with the authenticated user and session
+	req = withSession(req, user, session)
 
 	// Parse the form before handling (simulates what http.Request does)
 	req.ParseForm()
@@ -159,6 +190,19 @@ func TestHandleCreateSecret(t *testing.T) {
 		t.Errorf("Expected secret name 'New Secret', got '%s'", repo.Secrets[0].Name)
 	}
 
+	// Check that the secret is encrypted with the user's vault key
+	if repo.Secrets[0].EncryptionType != models.EncryptionTypeVault {
+		t.Errorf("Expected encryption type %q, got %q", models.EncryptionTypeVault, repo.Secrets[0].EncryptionType)
+	}
+	vaultKey, _ := vault.Key(session.ID)
+	plaintext, err := crypto.DecryptSecret(repo.Secrets[0].EncryptedData, vaultKey)
+	if err != nil {
+		t.Fatalf("Failed to decrypt secret with vault key: %v", err)
+	}
+	if string(plaintext) != "This is a test secret" {
+		t.Errorf("Expected decrypted content 'This is a test secret', got '%s'", plaintext)
+	}
+
 	// Check that an audit log was created
 	if len(repo.AuditLogs) == 0 {
 		t.Errorf("Expected at least 1 audit log entry, got 0")
@@ -170,13 +214,189 @@ func TestHandleCreateSecret(t *testing.T) {
 	}
 }
 
+// TestHandleCreateSecretLockedVault tests that creating a secret with a locked vault redirects to the unlock page
+func TestHandleCreateSecretLockedVault(t *testing.T) {
+	// Create mock repository
+	repo := storage.NewMockRepository()
+
+	// Create a test user
+	user := &models.User{
+		ID:    "user123",
+		Email: "test@example.com",
+	}
+	repo.Users = append(repo.Users, user)
+
+	// Create the handler without unlocking the vault
+	handler := NewSecretsHandler(repo, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
+	session := &models.Session{ID: "session123", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+
+	// Create form data
+	form := url.Values{}
+	form.Set("title", "New Secret")
+	form.Set("content", "This is a test secret")
+
+	// Create a test request
+	req := newFormRequest("POST", "/secrets/new", form)
+	req = withSession(req, user, session)
+	rr := httptest.NewRecorder()
+
+	// Call the handler
+	handler.HandleCreateSecret(rr, req)
+
+	// Check for redirect to the unlock page
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+	if location := rr.Header().Get("Location"); !strings.HasPrefix(location, "/unlock") {
+		t.Errorf("Expected redirect to /unlock, got %s", location)
+	}
+
+	// Check that no secret was created
+	if len(repo.Secrets) != 0 {
+		t.Errorf("Expected no secrets, got %d", len(repo.Secrets))
+	}
+}
+
+// TestHandleCreateQuorumSecret tests creating a secret that needs several recipients to unlock it
+func TestHandleCreateQuorumSecret(t *testing.T) {
+	// Create mock repository
+	repo := storage.NewMockRepository()
+
+	// Create a test user
+	user := &models.User{
+		ID:    "user123",
+		Email: "test@example.com",
+	}
+	repo.Users = append(repo.Users, user)
+
+	// Create the handler with an unlocked vault and a master key
+	vault := auth.NewVaultService(repo)
+	sealer := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil)
+	handler := NewSecretsHandler(repo, vault, sealer)
+	session, _ := unlockTestVault(t, vault, user)
+
+	newRequest := func(threshold string) *http.Request {
+		form := url.Values{}
+		form.Set("title", "Seed phrase")
+		form.Set("content", "correct horse battery staple")
+		form.Add("recipients", "recipient1")
+		form.Add("recipients", "recipient2")
+		form.Add("recipients", "recipient3")
+		form.Set("quorum", "on")
+		form.Set("quorum_threshold", threshold)
+
+		req := newFormRequest("POST", "/secrets/new", form)
+		return withSession(req, user, session)
+	}
+
+	// A threshold above the number of recipients is rejected
+	rr := httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newRequest("4"))
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	if len(repo.Secrets) != 0 {
+		t.Fatalf("Expected no secrets, got %d", len(repo.Secrets))
+	}
+
+	// A 2 of 3 quorum is accepted
+	rr = httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newRequest("2"))
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+
+	if len(repo.Secrets) != 1 {
+		t.Fatalf("Expected 1 secret, got %d", len(repo.Secrets))
+	}
+	secret := repo.Secrets[0]
+	if secret.QuorumThreshold != 2 || secret.QuorumData == "" {
+		t.Errorf("Expected a sealed 2 of N quorum, got threshold %d", secret.QuorumThreshold)
+	}
+
+	// Every recipient holds a sealed share
+	for _, assignment := range repo.SecretAssignments {
+		if assignment.DeliveryData == "" {
+			t.Errorf("Expected a sealed share for %s", assignment.RecipientID)
+		}
+	}
+}
+
+// TestHandleCreateClientEncryptedSecret tests zero-knowledge mode, where the browser encrypts the content
+func TestHandleCreateClientEncryptedSecret(t *testing.T) {
+	// Create mock repository
+	repo := storage.NewMockRepository()
+
+	// Create a test user
+	user := &models.User{
+		ID:    "user123",
+		Email: "test@example.com",
+	}
+	repo.Users = append(repo.Users, user)
+	repo.Recipients = append(repo.Recipients, &models.Recipient{ID: "recipient1", UserID: user.ID, Name: "Alice"})
+
+	// The vault stays locked, the server never decrypts the content
+	sealer := delivery.NewSealer(repo, []byte("0123456789abcdef0123456789abcdef"), nil)
+	handler := NewSecretsHandler(repo, auth.NewVaultService(repo), sealer)
+	session := &models.Session{ID: "session123", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+
+	envelope, err := crypto.EncryptClientEnvelope([]byte("bank login"), "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Failed to encrypt envelope: %v", err)
+	}
+
+	newRequest := func(content string) *http.Request {
+		form := url.Values{}
+		form.Set("title", "Bank")
+		form.Set("content", content)
+		form.Set("encryption", "client")
+		form.Add("recipients", "recipient1")
+
+		req := newFormRequest("POST", "/secrets/new", form)
+		return withSession(req, user, session)
+	}
+
+	// Plaintext is rejected, e.g. when the browser couldn't encrypt
+	rr := httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newRequest("bank login"))
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	if len(repo.Secrets) != 0 {
+		t.Fatalf("Expected no secrets, got %d", len(repo.Secrets))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.HandleCreateSecret(rr, newRequest(envelope))
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+
+	if len(repo.Secrets) != 1 {
+		t.Fatalf("Expected 1 secret, got %d", len(repo.Secrets))
+	}
+	secret := repo.Secrets[0]
+	if secret.EncryptionType != models.EncryptionTypeClient || secret.EncryptedData != envelope {
+		t.Errorf("Expected the envelope to be stored as it is, got encryption type %q", secret.EncryptionType)
+	}
+
+	// The recipient gets the envelope
+	copyForAlice, err := sealer.Open(repo.SecretAssignments[0].DeliveryData)
+	if err != nil {
+		t.Fatalf("Failed to open recipient copy: %v", err)
+	}
+	if plaintext, err := crypto.DecryptClientEnvelope(string(copyForAlice), "correct horse battery staple"); err != nil || string(plaintext) != "bank login" {
+		t.Errorf("Expected the recipient copy to be the envelope, got %q (%v)", plaintext, err)
+	}
+}
+
 // TestHandleCreateSecretUnauthorized tests the create secret handler with no authenticated user
 func TestHandleCreateSecretUnauthorized(t *testing.T) {
 	// Create mock repository
 	repo := storage.NewMockRepository()
 
 	// Create the handler
-	handler := NewSecretsHandler(repo)
+	handler := NewSecretsHandler(repo, auth.NewVaultService(repo), delivery.NewSealer(repo, nil, nil))
 
 	// Create form data
 	form := url.Values{}
@@ -74,6 +74,7 @@ func (h *SettingsHandler) HandleUpdateDeadManSwitchSettings(w http.ResponseWrite
 	pingDeadlineStr := r.FormValue("pingDeadline")
 	pingMethod := r.FormValue("pingMethod")
 	pingingEnabled := r.FormValue("pingingEnabled") == "on"
+	emergencyAccessDaysStr := r.FormValue("emergencyAccessDays")
 
 	// Parse ping frequency
 	pingFrequency, err := strconv.Atoi(pingFrequencyStr)
@@ -87,6 +88,15 @@ func (h *SettingsHandler) HandleUpdateDeadManSwitchSettings(w http.ResponseWrite
 		pingDeadline = 14 // Default to 2 weeks
 	}
 
+	// Parse emergency access waiting period, it has to end before the switch would fire anyway
+	emergencyAccessDays, err := strconv.Atoi(emergencyAccessDaysStr)
+	if err != nil || emergencyAccessDays < 0 {
+		emergencyAccessDays = 0 // Default to disabled
+	}
+	if emergencyAccessDays >= pingDeadline {
+		emergencyAccessDays = pingDeadline - 1
+	}
+
 	// Validate ping method
 	if pingMethod != "email" && pingMethod != "telegram" && pingMethod != "both" {
 		pingMethod = "email" // Default to email
@@ -97,6 +107,7 @@ func (h *SettingsHandler) HandleUpdateDeadManSwitchSettings(w http.ResponseWrite
 	user.PingDeadline = pingDeadline
 	user.PingMethod = pingMethod
 	user.PingingEnabled = pingingEnabled
+	user.EmergencyAccessDays = emergencyAccessDays
 
 	// Save user settings
 	if err := h.repo.UpdateUser(r.Context(), user); err != nil {
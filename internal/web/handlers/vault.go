@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/web/middleware"
+)
+
+// requireVaultKey returns the unlocked vault key for the current session.
+// If the vault is locked, the user is redirected to the unlock page and ok is false.
+func requireVaultKey(w http.ResponseWriter, r *http.Request, vault *auth.VaultService) ([]byte, bool) {
+	session, ok := middleware.GetSessionFromContext(r)
+	if !ok || session == nil || vault == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	key, err := vault.Key(session.ID)
+	if err != nil {
+		next := r.URL.Path
+		if r.Method != http.MethodGet {
+			// Don't send the user back to a form submission endpoint
+			next = "/secrets"
+		}
+		http.Redirect(w, r, "/unlock?next="+url.QueryEscape(next), http.StatusSeeOther)
+		return nil, false
+	}
+
+	return key, true
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// VerifyHandler handles check-ins from the links in email pings
+type VerifyHandler struct {
+	repo   storage.Repository
+	sealer *delivery.Sealer
+}
+
+// NewVerifyHandler creates a new VerifyHandler
+func NewVerifyHandler(repo storage.Repository, sealer *delivery.Sealer) *VerifyHandler {
+	return &VerifyHandler{
+		repo:   repo,
+		sealer: sealer,
+	}
+}
+
+// HandleVerify handles a click on the check-in link of an email ping
+func (h *VerifyHandler) HandleVerify(w http.ResponseWriter, r *http.Request) {
+	// Get the verification code from the URL
+	code := r.PathValue("code")
+	if code == "" {
+		http.Error(w, "Verification code is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	verification, err := h.repo.GetPingVerificationByCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Invalid verification code", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error verifying code", http.StatusInternalServerError)
+		log.Printf("Error fetching ping verification: %v", err)
+		return
+	}
+
+	if verification.Used {
+		http.Error(w, "Verification code has already been used", http.StatusGone)
+		return
+	}
+
+	now := time.Now().UTC()
+	if now.After(verification.ExpiresAt) {
+		http.Error(w, "Verification code has expired", http.StatusGone)
+		return
+	}
+
+	user, err := h.repo.GetUserByID(ctx, verification.UserID)
+	if err != nil {
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		log.Printf("Error fetching user for ping verification: %v", err)
+		return
+	}
+
+	// Mark the code as used so the link only works once
+	verification.Used = true
+	if err := h.repo.UpdatePingVerification(ctx, verification); err != nil {
+		http.Error(w, "Error verifying code", http.StatusInternalServerError)
+		log.Printf("Error marking ping verification as used: %v", err)
+		return
+	}
+
+	// Mark the email ping that carried this code as responded
+	if ping := h.findPingForVerification(ctx, verification); ping != nil {
+		ping.Status = "responded"
+		ping.RespondedAt = &now
+		if err := h.repo.UpdatePingHistory(ctx, ping); err != nil {
+			log.Printf("Error updating ping history: %v", err)
+			// Continue anyway, the check-in itself is what matters
+		}
+	}
+
+	// Reset the switch
+	user.LastActivity = now
+	user.NextScheduledPing = now.AddDate(0, 0, user.PingFrequency)
+	if err := h.repo.UpdateUser(ctx, user); err != nil {
+		http.Error(w, "Error updating user", http.StatusInternalServerError)
+		log.Printf("Error updating user last activity: %v", err)
+		return
+	}
+	if err := h.sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		log.Printf("Error renewing question timelocks: %v", err)
+	}
+
+	abortArmedSwitch(ctx, h.repo, r, user, "Armed switch aborted by check-in via email verification link")
+
+	// Create audit log entry
+	auditLog := &models.AuditLog{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Action:    "check_in",
+		Timestamp: now,
+		IPAddress: r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Details:   "User check-in via email verification link",
+	}
+
+	if err := h.repo.CreateAuditLog(ctx, auditLog); err != nil {
+		log.Printf("Error creating audit log for check-in: %v", err)
+		// Continue anyway, don't fail the whole request
+	}
+
+	data := templates.TemplateData{
+		Title:           "Check-in Confirmed",
+		ActivePage:      "",
+		IsAuthenticated: false,
+		Data: map[string]interface{}{
+			"NextCheckIn": user.NextScheduledPing.Format("Jan 2, 2006 15:04 MST"),
+			"Deadline":    user.LastActivity.AddDate(0, 0, user.PingDeadline).Format("Jan 2, 2006 15:04 MST"),
+		},
+	}
+
+	if err := templates.RenderTemplate(w, "verify-success.html", data); err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// findPingForVerification finds the pending email ping that was sent together with the verification code.
+// Verification codes are not linked to pings directly, so the email ping sent closest to the code's
+// creation time is used.
+func (h *VerifyHandler) findPingForVerification(ctx context.Context, verification *models.PingVerification) *models.PingHistory {
+	pings, err := h.repo.ListPingHistoryByUserID(ctx, verification.UserID)
+	if err != nil {
+		log.Printf("Error listing ping history: %v", err)
+		return nil
+	}
+
+	var match *models.PingHistory
+	var matchDiff time.Duration
+	for _, ping := range pings {
+		if ping.Method != "email" || ping.Status != "sent" {
+			continue
+		}
+
+		diff := ping.SentAt.Sub(verification.CreatedAt)
+		if diff < 0 {
+			diff = -diff
+		}
+		if match == nil || diff < matchDiff {
+			match = ping
+			matchDiff = diff
+		}
+	}
+
+	return match
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+	"github.com/korjavin/deadmanswitch/internal/web/templates"
+)
+
+// setupVerifyTest creates a user with a pending email ping and returns the handler
+func setupVerifyTest(t *testing.T, expiresAt time.Time) (*storage.MockRepository, *VerifyHandler) {
+	t.Helper()
+
+	templates.TemplatePaths = append(templates.TemplatePaths, "../../../web/templates")
+
+	repo := storage.NewMockRepository()
+
+	sentAt := time.Now().UTC().Add(-24 * time.Hour)
+	repo.Users = append(repo.Users, &models.User{
+		ID:            "user123",
+		Email:         "test@example.com",
+		LastActivity:  time.Now().UTC().Add(-8 * 24 * time.Hour),
+		PingFrequency: 7,
+		PingDeadline:  14,
+	})
+	repo.PingHistories = append(repo.PingHistories, &models.PingHistory{
+		ID:     "ping1",
+		UserID: "user123",
+		SentAt: sentAt,
+		Method: "email",
+		Status: "sent",
+	})
+	repo.PingVerifications = append(repo.PingVerifications, &models.PingVerification{
+		ID:        "verification1",
+		UserID:    "user123",
+		Code:      "abc123",
+		ExpiresAt: expiresAt,
+		CreatedAt: sentAt,
+	})
+
+	return repo, NewVerifyHandler(repo, delivery.NewSealer(repo, nil, nil))
+}
+
+// newCodeRequest creates a request of a link ending in a code, e.g. /verify/{code}
+func newCodeRequest(path, code string) *http.Request {
+	req := httptest.NewRequest("GET", path+code, nil)
+	req.SetPathValue("code", code)
+	return req
+}
+
+func TestHandleVerify(t *testing.T) {
+	repo, handler := setupVerifyTest(t, time.Now().UTC().Add(24*time.Hour))
+
+	rr := httptest.NewRecorder()
+	handler.HandleVerify(rr, newCodeRequest("/verify/", "abc123"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if !repo.PingVerifications[0].Used {
+		t.Error("Expected verification code to be marked as used")
+	}
+
+	ping := repo.PingHistories[0]
+	if ping.Status != "responded" || ping.RespondedAt == nil {
+		t.Errorf("Expected ping to be responded, got status %q", ping.Status)
+	}
+
+	user := repo.Users[0]
+	if time.Since(user.LastActivity) > time.Minute {
+		t.Errorf("Expected last activity to be reset, got %v", user.LastActivity)
+	}
+	expectedNextPing := time.Now().UTC().AddDate(0, 0, user.PingFrequency)
+	if diff := user.NextScheduledPing.Sub(expectedNextPing); diff > time.Minute || diff < -time.Minute {
+		t.Errorf("Expected next ping around %v, got %v", expectedNextPing, user.NextScheduledPing)
+	}
+
+	// The link only works once
+	rr = httptest.NewRecorder()
+	handler.HandleVerify(rr, newCodeRequest("/verify/", "abc123"))
+	if rr.Code != http.StatusGone {
+		t.Errorf("Expected status 410 for a used code, got %d", rr.Code)
+	}
+}
+
+func TestHandleVerifyInvalid(t *testing.T) {
+	repo, handler := setupVerifyTest(t, time.Now().UTC().Add(-time.Hour))
+	lastActivity := repo.Users[0].LastActivity
+
+	tests := []struct {
+		name string
+		code string
+		want int
+	}{
+		{"expired code", "abc123", http.StatusGone},
+		{"unknown code", "nope", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.HandleVerify(rr, newCodeRequest("/verify/", tt.code))
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+
+	if !repo.Users[0].LastActivity.Equal(lastActivity) {
+		t.Error("Expected last activity to stay unchanged")
+	}
+	if repo.PingHistories[0].Status != "sent" {
+		t.Errorf("Expected ping to stay sent, got %q", repo.PingHistories[0].Status)
+	}
+}
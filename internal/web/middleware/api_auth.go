@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+// APITokenContextKey is the context key for storing the API token a request was authenticated with
+const APITokenContextKey contextKey = "apiToken"
+
+// APIAuth is a middleware that authenticates API requests with a personal API token sent as
+// "Authorization: Bearer <token>". The token must have been granted the given scope.
+// Requests without an Authorization header fall back to the session cookie, so the web
+// interface keeps working. Failures are answered with a JSON error instead of a redirect.
+func APIAuth(repo storage.Repository, sealer *delivery.Sealer, scope string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				ctx, ok := authenticateSession(r, repo, sealer)
+				if !ok {
+					writeAPIAuthError(w, http.StatusUnauthorized, "authentication required")
+					return
+				}
+				next(w, r.WithContext(ctx))
+				return
+			}
+
+			// Get the bearer token
+			scheme, tokenString, found := strings.Cut(header, " ")
+			tokenString = strings.TrimSpace(tokenString)
+			if !found || !strings.EqualFold(scheme, "Bearer") || !auth.IsAPIToken(tokenString) {
+				writeAPIAuthError(w, http.StatusUnauthorized, "invalid authorization header")
+				return
+			}
+
+			// Get the token from the database
+			ctx := r.Context()
+			token, err := repo.GetAPITokenByHash(ctx, auth.HashAPIToken(tokenString))
+			if err != nil {
+				if err != storage.ErrNotFound {
+					log.Printf("Error fetching API token: %v", err)
+				}
+				writeAPIAuthError(w, http.StatusUnauthorized, "invalid API token")
+				return
+			}
+
+			if token.IsRevoked() {
+				writeAPIAuthError(w, http.StatusUnauthorized, "API token has been revoked")
+				return
+			}
+
+			if !token.HasScope(scope) {
+				writeAPIAuthError(w, http.StatusForbidden, "API token is missing the "+scope+" scope")
+				return
+			}
+
+			// Get the user the token belongs to
+			user, err := repo.GetUserByID(ctx, token.UserID)
+			if err != nil {
+				log.Printf("User not found for API token %s: %v", token.ID, err)
+				writeAPIAuthError(w, http.StatusUnauthorized, "invalid API token")
+				return
+			}
+
+			// Track when the token was last used
+			if err := repo.UpdateAPITokenLastUsed(ctx, token.ID); err != nil {
+				log.Printf("Error updating API token last used: %v", err)
+				// Continue anyway, this is not critical
+			}
+
+			// Add the user and token to the request context
+			ctx = context.WithValue(ctx, UserContextKey, user)
+			ctx = context.WithValue(ctx, APITokenContextKey, token)
+
+			// Call the next handler with the updated context
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// GetAPITokenFromContext gets the API token from the request context.
+// It is only set for requests authenticated with an API token.
+func GetAPITokenFromContext(r *http.Request) (*models.APIToken, bool) {
+	token, ok := r.Context().Value(APITokenContextKey).(*models.APIToken)
+	return token, ok
+}
+
+// writeAPIAuthError writes a JSON error for a failed API authentication
+func writeAPIAuthError(w http.ResponseWriter, status int, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   message,
+	}); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
+	"github.com/korjavin/deadmanswitch/internal/models"
+	"github.com/korjavin/deadmanswitch/internal/storage"
+)
+
+func TestAPIAuth(t *testing.T) {
+	repo := storage.NewMockRepository()
+
+	user := &models.User{
+		ID:    "user123",
+		Email: "test@example.com",
+	}
+	repo.Users = append(repo.Users, user)
+
+	// Create a check-in token, a read-only token and a revoked token
+	addToken := func(id string, scopes []string, revoked bool) string {
+		tokenString, tokenHash, err := auth.GenerateAPIToken()
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+		token := &models.APIToken{ID: id, UserID: user.ID, Name: id, TokenHash: tokenHash, Scopes: scopes}
+		if revoked {
+			now := time.Now()
+			token.RevokedAt = &now
+		}
+		repo.APITokens = append(repo.APITokens, token)
+		return tokenString
+	}
+	checkInToken := addToken("checkin", []string{models.APITokenScopeCheckIn}, false)
+	readToken := addToken("read", []string{models.APITokenScopeRead}, false)
+	revokedToken := addToken("revoked", []string{models.APITokenScopeCheckIn}, true)
+
+	// Create a valid session for the cookie fallback
+	repo.Sessions = append(repo.Sessions, &models.Session{
+		ID:        "session123",
+		UserID:    user.ID,
+		Token:     "session-token",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	testHandler := func(w http.ResponseWriter, r *http.Request) {
+		if u, ok := GetUserFromContext(r); !ok || u.ID != user.ID {
+			t.Error("Expected user in context")
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	handler := APIAuth(repo, delivery.NewSealer(repo, nil, nil), models.APITokenScopeCheckIn)(testHandler)
+
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		want   int
+	}{
+		{"valid token", "Bearer " + checkInToken, "", http.StatusOK},
+		{"lowercase scheme", "bearer " + checkInToken, "", http.StatusOK},
+		{"missing scope", "Bearer " + readToken, "", http.StatusForbidden},
+		{"revoked token", "Bearer " + revokedToken, "", http.StatusUnauthorized},
+		{"unknown token", "Bearer dms_unknown", "", http.StatusUnauthorized},
+		{"wrong scheme", "Basic " + checkInToken, "", http.StatusUnauthorized},
+		{"session cookie", "", "session-token", http.StatusOK},
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"invalid session cookie", "", "invalid-token", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/check-in", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "session_token", Value: tt.cookie})
+			}
+
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+
+	// Successful token use is tracked
+	if repo.APITokens[0].LastUsedAt == nil {
+		t.Error("Expected last used time to be set for the check-in token")
+	}
+	if repo.APITokens[1].LastUsedAt != nil {
+		t.Error("Expected last used time not to be set for a token without the scope")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 )
@@ -22,69 +23,80 @@ const (
 	RecipientIDContextKey contextKey = "recipientID"
 )
 
-// Auth is a middleware that checks if the user is authenticated
-func Auth(repo storage.Repository) func(http.HandlerFunc) http.HandlerFunc {
+// Auth is a middleware that checks if the user is authenticated. Every
+// authenticated request counts as activity, the sealer moves the timelock of
+// the user's questions along.
+func Auth(repo storage.Repository, sealer *delivery.Sealer) func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			// Get the session cookie
-			cookie, err := r.Cookie("session_token")
-			if err != nil {
-				// No session cookie, redirect to login
+			ctx, ok := authenticateSession(r, repo, sealer)
+			if !ok {
+				// No valid session, redirect to login
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
 
-			// Get the session token
-			sessionToken := cookie.Value
+			// Call the next handler with the updated context
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
 
-			// Get the session from the database
-			ctx := r.Context()
-			session, err := repo.GetSessionByToken(ctx, sessionToken)
-			if err != nil {
-				// Invalid session, redirect to login
-				log.Printf("Invalid session: %v", err)
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
-				return
-			}
+// authenticateSession checks the session cookie of a request. On success it records the
+// activity and returns a context carrying the user and the session.
+func authenticateSession(r *http.Request, repo storage.Repository, sealer *delivery.Sealer) (context.Context, bool) {
+	// Get the session cookie
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return nil, false
+	}
 
-			// Check if the session has expired
-			if session.ExpiresAt.Before(time.Now()) {
-				// Session expired, redirect to login
-				log.Printf("Session expired")
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
-				return
-			}
+	// Get the session token
+	sessionToken := cookie.Value
 
-			// Get the user from the session
-			user, err := repo.GetUserByID(ctx, session.UserID)
-			if err != nil {
-				// User not found, redirect to login
-				log.Printf("User not found: %v", err)
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
-				return
-			}
+	// Get the session from the database
+	ctx := r.Context()
+	session, err := repo.GetSessionByToken(ctx, sessionToken)
+	if err != nil {
+		log.Printf("Invalid session: %v", err)
+		return nil, false
+	}
 
-			// Update the user's last activity time
-			user.LastActivity = time.Now()
-			if err := repo.UpdateUser(ctx, user); err != nil {
-				log.Printf("Error updating user last activity: %v", err)
-				// Continue anyway, this is not critical
-			}
+	// Check if the session has expired
+	if session.ExpiresAt.Before(time.Now()) {
+		log.Printf("Session expired")
+		return nil, false
+	}
 
-			// Update session activity
-			if err := repo.UpdateSessionActivity(ctx, session.ID); err != nil {
-				log.Printf("Error updating session activity: %v", err)
-				// Continue anyway, this is not critical
-			}
+	// Get the user from the session
+	user, err := repo.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		log.Printf("User not found: %v", err)
+		return nil, false
+	}
 
-			// Add the user and session to the request context
-			ctx = context.WithValue(ctx, UserContextKey, user)
-			ctx = context.WithValue(ctx, SessionContextKey, session)
+	// Update the user's last activity time
+	user.LastActivity = time.Now()
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		log.Printf("Error updating user last activity: %v", err)
+		// Continue anyway, this is not critical
+	}
+	if err := sealer.RenewQuestionTimelocks(ctx, user, nil); err != nil {
+		log.Printf("Error renewing question timelocks: %v", err)
+		// Continue anyway, the next request tries again
+	}
 
-			// Call the next handler with the updated context
-			next(w, r.WithContext(ctx))
-		}
+	// Update session activity
+	if err := repo.UpdateSessionActivity(ctx, session.ID); err != nil {
+		log.Printf("Error updating session activity: %v", err)
+		// Continue anyway, this is not critical
 	}
+
+	// Add the user and session to the request context
+	ctx = context.WithValue(ctx, UserContextKey, user)
+	ctx = context.WithValue(ctx, SessionContextKey, session)
+
+	return ctx, true
 }
 
 // GetUserFromContext gets the user from the request context
@@ -92,3 +104,9 @@ func GetUserFromContext(r *http.Request) (*models.User, bool) {
 	user, ok := r.Context().Value(UserContextKey).(*models.User)
 	return user, ok
 }
+
+// GetSessionFromContext gets the session from the request context
+func GetSessionFromContext(r *http.Request) (*models.Session, bool) {
+	session, ok := r.Context().Value(SessionContextKey).(*models.Session)
+	return session, ok
+}
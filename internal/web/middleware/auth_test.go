@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 )
@@ -74,7 +75,7 @@ func TestAuth(t *testing.T) {
 	})
 
 	// Create the middleware
-	authMiddleware := Auth(repo)
+	authMiddleware := Auth(repo, delivery.NewSealer(repo, nil, nil))
 
 	// Test with valid session
 	t.Run("Valid Session", func(t *testing.T) {
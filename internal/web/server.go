@@ -10,8 +10,12 @@ import (
 	"time"
 
 	"github.com/korjavin/deadmanswitch/internal/auth"
+	"github.com/korjavin/deadmanswitch/internal/backup"
 	"github.com/korjavin/deadmanswitch/internal/config"
+	"github.com/korjavin/deadmanswitch/internal/delivery"
 	"github.com/korjavin/deadmanswitch/internal/email"
+	"github.com/korjavin/deadmanswitch/internal/files"
+	"github.com/korjavin/deadmanswitch/internal/models"
 	"github.com/korjavin/deadmanswitch/internal/scheduler"
 	"github.com/korjavin/deadmanswitch/internal/storage"
 	"github.com/korjavin/deadmanswitch/internal/telegram"
@@ -27,6 +31,7 @@ type Server struct {
 	emailClient *email.Client
 	telegramBot *telegram.Bot
 	scheduler   *scheduler.Scheduler
+	sealer      *delivery.Sealer
 	router      *http.ServeMux
 	httpServer  *http.Server
 	handlers    struct {
@@ -41,6 +46,14 @@ type Server struct {
 		history    *handlers.HistoryHandler
 		twofa      *handlers.TwoFAHandler
 		passkey    *handlers.PasskeyHandler
+		access     *handlers.AccessHandler
+		verify     *handlers.VerifyHandler
+		abort      *handlers.AbortHandler
+		emergency  *handlers.EmergencyAccessHandler
+		recovery   *handlers.RecoveryHandler
+		apiTokens  *handlers.APITokenHandler
+		apiV1      *handlers.APIV1Handler
+		backup     *handlers.BackupHandler
 	}
 }
 
@@ -91,18 +104,54 @@ func NewServer(
 		webAuthnService = nil
 	}
 
+	// Initialize the vault service that holds unlocked vault keys per session
+	vaultService := auth.NewVaultService(repo)
+
+	// Initialize the sealer that protects delivery material for recipients
+	sealer := delivery.NewSealer(repo, cfg.MasterKey, cfg.Timelock)
+	sealer.SetPreviousMasterKeys(cfg.PreviousMasterKeys)
+	server.sealer = sealer
+
 	// Initialize handlers
 	server.handlers.index = handlers.NewIndexHandler()
-	server.handlers.auth = handlers.NewAuthHandler(repo, emailClient)
+	server.handlers.auth = handlers.NewAuthHandler(repo, emailClient, vaultService, sealer)
 	server.handlers.dashboard = handlers.NewDashboardHandler(repo)
-	server.handlers.secrets = handlers.NewSecretsHandler(repo)
-	server.handlers.recipients = handlers.NewRecipientsHandler(repo, emailClient)
-	server.handlers.api = handlers.NewAPIHandler(repo)
+	server.handlers.secrets = handlers.NewSecretsHandler(repo, vaultService, sealer)
+	server.handlers.recipients = handlers.NewRecipientsHandler(repo, emailClient, vaultService, sealer)
+	server.handlers.recipients.SetBaseDomain(cfg.BaseDomain)
+	server.handlers.api = handlers.NewAPIHandler(repo, sealer)
 	server.handlers.profile = handlers.NewProfileHandler(repo, cfg)
 	server.handlers.settings = handlers.NewSettingsHandler(repo)
 	server.handlers.history = handlers.NewHistoryHandler(repo)
 	server.handlers.twofa = handlers.NewTwoFAHandler(repo)
-	server.handlers.passkey = handlers.NewPasskeyHandler(repo, webAuthnService)
+	server.handlers.passkey = handlers.NewPasskeyHandler(repo, webAuthnService, sealer)
+	server.handlers.access = handlers.NewAccessHandler(repo, sealer)
+	server.handlers.verify = handlers.NewVerifyHandler(repo, sealer)
+	server.handlers.abort = handlers.NewAbortHandler(repo, sealer)
+	server.handlers.emergency = handlers.NewEmergencyAccessHandler(repo, emailClient, emergencyAccessNotifier(scheduler), sealer)
+	server.handlers.emergency.SetBaseDomain(cfg.BaseDomain)
+	server.handlers.recovery = handlers.NewRecoveryHandler(repo, emailClient, vaultService, sealer)
+	server.handlers.apiTokens = handlers.NewAPITokenHandler(repo)
+	server.handlers.apiV1 = handlers.NewAPIV1Handler(repo, emailClient, vaultService, sealer, cfg.AdminToken)
+
+	// Backups of an owner's data, file secrets are included with the file store
+	backups := backup.NewService(repo, sealer)
+	server.handlers.backup = handlers.NewBackupHandler(repo, emailClient, vaultService, backups)
+
+	// File secrets are stored encrypted next to the database
+	fileStore, err := files.NewStore(cfg.FilesDir)
+	if err != nil {
+		log.Printf("Warning: Failed to create file store, file secrets are disabled: %v", err)
+	} else {
+		server.handlers.secrets.SetFileStore(fileStore, cfg.MaxFileSize, cfg.FileQuota)
+		server.handlers.access.SetFileStore(fileStore)
+		server.handlers.apiV1.SetFileStore(fileStore, cfg.MaxFileSize, cfg.FileQuota)
+		backups.SetFileStore(fileStore, cfg.MaxFileSize, cfg.FileQuota)
+	}
+
+	// Earlier revisions kept when a secret changes
+	server.handlers.secrets.SetSecretVersions(cfg.SecretVersions)
+	server.handlers.apiV1.SetSecretVersions(cfg.SecretVersions)
 
 	// Set up routes
 	server.setupRoutes()
@@ -155,41 +204,88 @@ func (s *Server) setupRoutes() {
 	r.HandleFunc("/login/passkey/begin", s.handlers.passkey.HandleBeginLogin)
 	r.HandleFunc("/login/passkey/finish", s.handlers.passkey.HandleFinishLogin)
 	r.HandleFunc("/confirm/", s.handleConfirmation)
+	r.HandleFunc("/access/", s.handleAccess)
+	r.HandleFunc("/verify/", s.handleVerify)
+	r.HandleFunc("/abort/", s.handleAbort)
+	r.HandleFunc("/emergency-access", s.handleMethodRouter(
+		"GET", s.handlers.emergency.HandleRequestForm,
+		"POST", s.handlers.emergency.HandleRequest,
+	))
+	r.HandleFunc("/emergency-access/", s.handleEmergencyAccess)
 	r.Handle("/static/", http.StripPrefix("/static/", s.setupFileServer()))
 	r.HandleFunc("/logout", s.handlers.auth.HandleLogout)
 
 	// Protected routes
-	r.HandleFunc("/dashboard", authMiddleware.Auth(s.repo)(s.handlers.dashboard.HandleDashboard))
-	r.HandleFunc("/secrets", authMiddleware.Auth(s.repo)(s.handlers.secrets.HandleListSecrets))
-	r.HandleFunc("/secrets/new", authMiddleware.Auth(s.repo)(s.handleMethodRouter(
+	r.HandleFunc("/unlock", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
+		"GET", s.handlers.auth.HandleUnlockForm,
+		"POST", s.handlers.auth.HandleUnlock,
+	)))
+	r.HandleFunc("/dashboard", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.dashboard.HandleDashboard))
+	r.HandleFunc("/secrets", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.secrets.HandleListSecrets))
+	r.HandleFunc("/secrets/new", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
 		"GET", s.handlers.secrets.HandleNewSecretForm,
 		"POST", s.handlers.secrets.HandleCreateSecret,
 	)))
-	r.HandleFunc("/secrets/", authMiddleware.Auth(s.repo)(s.handleSecrets))
-	r.HandleFunc("/recipients", authMiddleware.Auth(s.repo)(s.handlers.recipients.HandleListRecipients))
-	r.HandleFunc("/recipients/new", authMiddleware.Auth(s.repo)(s.handleMethodRouter(
+	r.HandleFunc("/secrets/new-file", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
+		"GET", s.handlers.secrets.HandleNewFileSecretForm,
+		"POST", s.handlers.secrets.HandleCreateFileSecret,
+	)))
+	r.HandleFunc("/secrets/import", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
+		"GET", s.handlers.secrets.HandleImportForm,
+		"POST", s.handlers.secrets.HandleImportPreview,
+	)))
+	r.HandleFunc("/secrets/import/confirm", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
+		"POST", s.handlers.secrets.HandleImportSecrets,
+	)))
+	r.HandleFunc("/secrets/", authMiddleware.Auth(s.repo, s.sealer)(s.handleSecrets))
+	r.HandleFunc("/recipients", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.recipients.HandleListRecipients))
+	r.HandleFunc("/recipients/new", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
 		"GET", s.handlers.recipients.HandleNewRecipientForm,
 		"POST", s.handlers.recipients.HandleCreateRecipient,
 	)))
-	r.HandleFunc("/recipients/", authMiddleware.Auth(s.repo)(s.handleRecipients))
-	r.HandleFunc("/profile", authMiddleware.Auth(s.repo)(s.handleMethodRouter(
+	r.HandleFunc("/recipients/", authMiddleware.Auth(s.repo, s.sealer)(s.handleRecipients))
+	r.HandleFunc("/profile", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
 		"GET", s.handlers.profile.HandleProfile,
 		"POST", s.handlers.profile.HandleUpdateProfile,
 	)))
-	r.HandleFunc("/profile/github/disconnect", authMiddleware.Auth(s.repo)(s.handlers.profile.HandleDisconnectGitHub))
-	r.HandleFunc("/profile/passkeys", authMiddleware.Auth(s.repo)(s.handlers.passkey.HandlePasskeyManagement))
-	r.HandleFunc("/profile/passkeys/register/begin", authMiddleware.Auth(s.repo)(s.handlers.passkey.HandleBeginRegistration))
-	r.HandleFunc("/profile/passkeys/register/finish", authMiddleware.Auth(s.repo)(s.handlers.passkey.HandleFinishRegistration))
-	r.HandleFunc("/profile/passkeys/", authMiddleware.Auth(s.repo)(s.handlePasskeys))
-	r.HandleFunc("/settings", authMiddleware.Auth(s.repo)(s.handlers.settings.HandleSettings))
-	r.HandleFunc("/settings/deadmanswitch", authMiddleware.Auth(s.repo)(s.handlers.settings.HandleUpdateDeadManSwitchSettings))
-	r.HandleFunc("/settings/notifications", authMiddleware.Auth(s.repo)(s.handlers.settings.HandleUpdateNotificationSettings))
-	r.HandleFunc("/settings/security", authMiddleware.Auth(s.repo)(s.handlers.settings.HandleUpdateSecuritySettings))
-	r.HandleFunc("/2fa/setup", authMiddleware.Auth(s.repo)(s.handlers.twofa.HandleSetup))
-	r.HandleFunc("/2fa/verify", authMiddleware.Auth(s.repo)(s.handlers.twofa.HandleVerify))
-	r.HandleFunc("/2fa/disable", authMiddleware.Auth(s.repo)(s.handlers.twofa.HandleDisable))
-	r.HandleFunc("/history", authMiddleware.Auth(s.repo)(s.handlers.history.HandleHistory))
-	r.HandleFunc("/api/check-in", authMiddleware.Auth(s.repo)(s.handlers.api.HandleCheckIn))
+	r.HandleFunc("/profile/github/disconnect", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.profile.HandleDisconnectGitHub))
+	r.HandleFunc("/profile/passkeys", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.passkey.HandlePasskeyManagement))
+	r.HandleFunc("/profile/passkeys/register/begin", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.passkey.HandleBeginRegistration))
+	r.HandleFunc("/profile/passkeys/register/finish", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.passkey.HandleFinishRegistration))
+	r.HandleFunc("/profile/passkeys/", authMiddleware.Auth(s.repo, s.sealer)(s.handlePasskeys))
+	r.HandleFunc("/profile/tokens", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
+		"GET", s.handlers.apiTokens.HandleListTokens,
+		"POST", s.handlers.apiTokens.HandleCreateToken,
+	)))
+	r.HandleFunc("/profile/tokens/", authMiddleware.Auth(s.repo, s.sealer)(s.handleAPITokens))
+	r.HandleFunc("/settings", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.settings.HandleSettings))
+	r.HandleFunc("/settings/deadmanswitch", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.settings.HandleUpdateDeadManSwitchSettings))
+	r.HandleFunc("/settings/notifications", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.settings.HandleUpdateNotificationSettings))
+	r.HandleFunc("/settings/security", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.settings.HandleUpdateSecuritySettings))
+	r.HandleFunc("/2fa/setup", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.twofa.HandleSetup))
+	r.HandleFunc("/2fa/verify", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.twofa.HandleVerify))
+	r.HandleFunc("/2fa/disable", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.twofa.HandleDisable))
+	r.HandleFunc("/backup", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.backup.HandleBackup))
+	r.HandleFunc("/backup/export", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
+		"POST", s.handlers.backup.HandleExportBackup,
+	)))
+	r.HandleFunc("/backup/restore", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
+		"POST", s.handlers.backup.HandleRestoreBackup,
+	)))
+	r.HandleFunc("/history", authMiddleware.Auth(s.repo, s.sealer)(s.handlers.history.HandleHistory))
+	r.HandleFunc("/recovery", authMiddleware.Auth(s.repo, s.sealer)(s.handleMethodRouter(
+		"GET", s.handlers.recovery.HandleRecovery,
+		"POST", s.handlers.recovery.HandleRecover,
+	)))
+	r.HandleFunc("/api/check-in", authMiddleware.APIAuth(s.repo, s.sealer, models.APITokenScopeCheckIn)(s.handlers.api.HandleCheckIn))
+
+	// Versioned JSON API, every route checks the API token scope it needs
+	for _, route := range s.handlers.apiV1.Routes() {
+		r.HandleFunc(route.Method+" "+handlers.APIV1Prefix+route.Path, authMiddleware.APIAuth(s.repo, s.sealer, route.Scope)(route.HandlerFunc))
+	}
+	r.HandleFunc(handlers.APIV1Prefix+"/", s.handlers.apiV1.HandleNotFound)
+	r.HandleFunc("GET "+handlers.APIV1Prefix+"/openapi.json", s.handlers.apiV1.HandleOpenAPI)
+	r.HandleFunc("GET /.well-known/openapi.json", s.handlers.apiV1.HandleOpenAPI)
 }
 
 // Helper functions for routing
@@ -222,11 +318,41 @@ func (s *Server) handleMethodRouter(methods ...interface{}) http.HandlerFunc {
 }
 
 func (s *Server) handleSecrets(w http.ResponseWriter, r *http.Request) {
-	id := utils.GetLastURLSegment(r)
-	if id == "" {
+	// Extract the secret ID from the URL path
+	path := strings.TrimPrefix(r.URL.Path, "/secrets/")
+	parts := strings.Split(path, "/")
+	if len(parts) == 0 || parts[0] == "" {
 		http.NotFound(w, r)
 		return
 	}
+	r.SetPathValue("id", parts[0])
+
+	// The file of a file secret is streamed, its form must not be parsed before the handler reads it
+	if strings.HasSuffix(r.URL.Path, "/file") {
+		switch r.Method {
+		case http.MethodGet:
+			s.handlers.secrets.HandleDownloadSecretFile(w, r)
+		case http.MethodPost:
+			s.handlers.secrets.HandleReplaceSecretFile(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Earlier revisions: /secrets/{id}/versions/diff and /secrets/{id}/versions/{version}/restore
+	if len(parts) > 1 && parts[1] == "versions" {
+		switch {
+		case len(parts) == 3 && parts[2] == "diff" && r.Method == http.MethodGet:
+			s.handlers.secrets.HandleSecretVersionDiff(w, r)
+		case len(parts) == 4 && parts[3] == "restore" && r.Method == http.MethodPost:
+			r.SetPathValue("version", parts[2])
+			s.handlers.secrets.HandleRestoreSecretVersion(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
 
 	// Check if this is an "assign" request
 	if strings.HasSuffix(r.URL.Path, "/assign") {
@@ -266,6 +392,7 @@ func (s *Server) handleRecipients(w http.ResponseWriter, r *http.Request) {
 
 	// Set the ID in the request context so handlers can access it
 	r = r.WithContext(context.WithValue(r.Context(), authMiddleware.RecipientIDContextKey, id))
+	r.SetPathValue("id", id)
 
 	// Handle test contact request
 	if strings.HasSuffix(r.URL.Path, "/test") {
@@ -284,6 +411,28 @@ func (s *Server) handleRecipients(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Handle personal questions
+	if strings.HasSuffix(r.URL.Path, "/questions") {
+		switch r.Method {
+		case http.MethodGet:
+			s.handlers.recipients.HandleRecipientQuestionsForm(w, r)
+		case http.MethodPost:
+			s.handlers.recipients.HandleUpdateRecipientQuestions(w, r)
+		}
+		return
+	}
+
+	// Handle printable recovery kits
+	if strings.HasSuffix(r.URL.Path, "/recovery-kit") {
+		switch r.Method {
+		case http.MethodGet:
+			s.handlers.recipients.HandleRecoveryKitForm(w, r)
+		case http.MethodPost:
+			s.handlers.recipients.HandleCreateRecoveryKit(w, r)
+		}
+		return
+	}
+
 	// Handle regular recipient operations
 	switch r.Method {
 	case http.MethodGet:
@@ -306,13 +455,136 @@ func (s *Server) handlePasskeys(w http.ResponseWriter, r *http.Request) {
 	s.handlers.passkey.HandleDeletePasskey(w, r)
 }
 
+func (s *Server) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	// Only /profile/tokens/{id}/revoke exists below /profile/tokens/
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/profile/tokens/"), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "revoke" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Make the token ID available to the handler
+	r.SetPathValue("id", parts[0])
+	s.handlers.apiTokens.HandleRevokeToken(w, r)
+}
+
 func (s *Server) handleConfirmation(w http.ResponseWriter, r *http.Request) {
-	code := utils.GetLastURLSegment(r)
-	if code == "" {
+	code := strings.Trim(strings.TrimPrefix(r.URL.Path, "/confirm/"), "/")
+	if code == "" || strings.Contains(code, "/") {
 		http.NotFound(w, r)
 		return
 	}
-	s.handlers.recipients.HandleConfirmRecipient(w, r)
+
+	// Make the code available to the handler
+	r.SetPathValue("code", code)
+
+	switch r.Method {
+	case http.MethodGet:
+		s.handlers.recipients.HandleConfirmRecipient(w, r)
+	case http.MethodPost:
+		s.handlers.recipients.HandleRegisterRecipientKey(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleAccess(w http.ResponseWriter, r *http.Request) {
+	code := strings.Trim(strings.TrimPrefix(r.URL.Path, "/access/"), "/")
+	if code == "" || strings.Contains(code, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Make the code available to the handler
+	r.SetPathValue("code", code)
+
+	switch r.Method {
+	case http.MethodGet:
+		s.handlers.access.HandleAccessForm(w, r)
+	case http.MethodPost:
+		s.handlers.access.HandleAccess(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	code := strings.Trim(strings.TrimPrefix(r.URL.Path, "/verify/"), "/")
+	if code == "" || strings.Contains(code, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Make the code available to the handler
+	r.SetPathValue("code", code)
+	s.handlers.verify.HandleVerify(w, r)
+}
+
+func (s *Server) handleAbort(w http.ResponseWriter, r *http.Request) {
+	code := strings.Trim(strings.TrimPrefix(r.URL.Path, "/abort/"), "/")
+	if code == "" || strings.Contains(code, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Make the code available to the handler
+	r.SetPathValue("code", code)
+	s.handlers.abort.HandleAbort(w, r)
+}
+
+func (s *Server) handleEmergencyAccess(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/emergency-access/")
+
+	var handler http.HandlerFunc
+	switch {
+	case strings.HasPrefix(path, "confirm/"):
+		path = strings.TrimPrefix(path, "confirm/")
+		handler = s.handlers.emergency.HandleConfirm
+	case strings.HasPrefix(path, "deny/"):
+		path = strings.TrimPrefix(path, "deny/")
+		handler = s.handlers.emergency.HandleDeny
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	code := strings.Trim(path, "/")
+	if code == "" || strings.Contains(code, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Make the code available to the handler
+	r.SetPathValue("code", code)
+	handler(w, r)
+}
+
+// emergencyAccessNotifier avoids handing the handler a nil scheduler wrapped in a non-nil interface
+func emergencyAccessNotifier(s *scheduler.Scheduler) handlers.EmergencyAccessNotifier {
+	if s == nil {
+		return nil
+	}
+	return s
 }
 
 func (s *Server) setupFileServer() http.Handler {
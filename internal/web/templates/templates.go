@@ -35,6 +35,16 @@ func FuncMap() template.FuncMap {
 		"formatDateTime": func(t time.Time) string {
 			return t.Format("Jan 2, 2006 15:04")
 		},
+		"formatBytes": func(size int64) string {
+			switch {
+			case size >= 1<<20:
+				return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+			case size >= 1<<10:
+				return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+			default:
+				return fmt.Sprintf("%d bytes", size)
+			}
+		},
 		"add": func(a, b int) int {
 			return a + b
 		},
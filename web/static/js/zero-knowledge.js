@@ -0,0 +1,194 @@
+/**
+ * Dead Man's Switch - Zero-knowledge mode
+ *
+ * Secrets are encrypted in the browser with a key derived from a passphrase
+ * that never leaves this page. The server only stores the envelope:
+ * {"v":1,"kdf":"PBKDF2-SHA256","iter":600000,"salt":"...","iv":"...","ct":"..."}
+ * See internal/crypto/client.go for the server side of the format.
+ */
+
+const ZK_ITERATIONS = 600000;
+const ZK_MIN_PASSPHRASE_LENGTH = 12;
+
+function zkToBase64(bytes) {
+  let binary = '';
+  bytes.forEach(b => { binary += String.fromCharCode(b); });
+  return btoa(binary);
+}
+
+function zkFromBase64(text) {
+  return Uint8Array.from(atob(text), c => c.charCodeAt(0));
+}
+
+async function zkDeriveKey(passphrase, salt, iterations) {
+  const material = await crypto.subtle.importKey(
+    'raw', new TextEncoder().encode(passphrase), 'PBKDF2', false, ['deriveKey']);
+  return crypto.subtle.deriveKey(
+    { name: 'PBKDF2', hash: 'SHA-256', salt: salt, iterations: iterations },
+    material, { name: 'AES-GCM', length: 256 }, false, ['encrypt', 'decrypt']);
+}
+
+/**
+ * Encrypt text with a passphrase and return the envelope as JSON
+ */
+async function zkEncrypt(text, passphrase) {
+  const salt = crypto.getRandomValues(new Uint8Array(16));
+  const iv = crypto.getRandomValues(new Uint8Array(12));
+  const key = await zkDeriveKey(passphrase, salt, ZK_ITERATIONS);
+  const ciphertext = await crypto.subtle.encrypt(
+    { name: 'AES-GCM', iv: iv }, key, new TextEncoder().encode(text));
+
+  return JSON.stringify({
+    v: 1,
+    kdf: 'PBKDF2-SHA256',
+    iter: ZK_ITERATIONS,
+    salt: zkToBase64(salt),
+    iv: zkToBase64(iv),
+    ct: zkToBase64(new Uint8Array(ciphertext))
+  });
+}
+
+/**
+ * Decrypt an envelope, a wrong passphrase throws an error
+ */
+async function zkDecrypt(envelopeJSON, passphrase) {
+  const envelope = JSON.parse(envelopeJSON);
+  if (envelope.v !== 1 || envelope.kdf !== 'PBKDF2-SHA256') {
+    throw new Error('This secret was encrypted with an unsupported format.');
+  }
+
+  const key = await zkDeriveKey(passphrase, zkFromBase64(envelope.salt), envelope.iter);
+  try {
+    const plaintext = await crypto.subtle.decrypt(
+      { name: 'AES-GCM', iv: zkFromBase64(envelope.iv) }, key, zkFromBase64(envelope.ct));
+    return new TextDecoder().decode(plaintext);
+  } catch (e) {
+    throw new Error('Wrong passphrase.');
+  }
+}
+
+/**
+ * Forms with data-zk-form encrypt their content before they are submitted.
+ * New secrets only do so when the zero-knowledge checkbox is ticked, existing
+ * ones once their content was decrypted with data-zk-decrypt.
+ */
+function initZeroKnowledgeForms() {
+  document.querySelectorAll('form[data-zk-form]').forEach(form => {
+    const toggle = form.querySelector('[data-zk-toggle]');
+    const fields = form.querySelector('[data-zk-fields]');
+    const content = form.querySelector('[data-zk-content]');
+    const error = form.querySelector('[data-zk-error]');
+
+    if (toggle && fields) {
+      const update = () => { fields.hidden = !toggle.checked; };
+      toggle.addEventListener('change', update);
+      update();
+    }
+
+    form.addEventListener('submit', async (event) => {
+      if (toggle && !toggle.checked) {
+        return;
+      }
+      if (form.dataset.zkEncrypted === 'true') {
+        return;
+      }
+      event.preventDefault();
+
+      const showError = (message) => {
+        if (error) {
+          error.textContent = message;
+          error.hidden = false;
+        }
+      };
+
+      // Editing a secret that was never decrypted keeps its content
+      if (content.disabled) {
+        form.dataset.zkEncrypted = 'true';
+        form.submit();
+        return;
+      }
+
+      // A new passphrase is entered twice, an existing one already decrypted the content
+      const passphrase = form.querySelector('[data-zk-passphrase]').value;
+      const confirmation = form.querySelector('[data-zk-passphrase-confirm]');
+      if (confirmation) {
+        if (passphrase.length < ZK_MIN_PASSPHRASE_LENGTH) {
+          showError('The passphrase must be at least ' + ZK_MIN_PASSPHRASE_LENGTH + ' characters long.');
+          return;
+        }
+        if (confirmation.value !== passphrase) {
+          showError('The passphrases do not match.');
+          return;
+        }
+      }
+
+      // Submit the envelope in place of the plaintext
+      const envelope = document.createElement('input');
+      envelope.type = 'hidden';
+      envelope.name = 'content';
+      envelope.value = await zkEncrypt(content.value, passphrase);
+      content.removeAttribute('name');
+      form.appendChild(envelope);
+
+      form.dataset.zkEncrypted = 'true';
+      form.submit();
+    });
+  });
+}
+
+/**
+ * Blocks with data-zk-decrypt hold an envelope in data-envelope and decrypt it
+ * into their data-zk-output element once the passphrase is entered
+ */
+function initZeroKnowledgeDecrypt() {
+  document.querySelectorAll('[data-zk-decrypt]').forEach(block => {
+    const passphrase = block.querySelector('[data-zk-passphrase]');
+    const button = block.querySelector('[data-zk-decrypt-button]');
+    const output = block.querySelector('[data-zk-output]');
+    const error = block.querySelector('[data-zk-error]');
+
+    // Enter decrypts instead of submitting the surrounding form
+    passphrase.addEventListener('keydown', (event) => {
+      if (event.key === 'Enter') {
+        event.preventDefault();
+        button.click();
+      }
+    });
+
+    button.addEventListener('click', async () => {
+      button.disabled = true;
+      if (error) {
+        error.hidden = true;
+      }
+
+      try {
+        const plaintext = await zkDecrypt(block.dataset.envelope, passphrase.value);
+        if ('value' in output) {
+          output.value = plaintext;
+          output.disabled = false;
+        } else {
+          output.textContent = plaintext;
+        }
+        output.hidden = false;
+        block.querySelectorAll('[data-zk-unlock]').forEach(el => { el.hidden = true; });
+      } catch (e) {
+        if (error) {
+          error.textContent = e.message;
+          error.hidden = false;
+        }
+      } finally {
+        button.disabled = false;
+      }
+    });
+  });
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+  if (!window.crypto || !window.crypto.subtle) {
+    document.querySelectorAll('[data-zk-unsupported]').forEach(el => { el.hidden = false; });
+    return;
+  }
+
+  initZeroKnowledgeForms();
+  initZeroKnowledgeDecrypt();
+});